@@ -0,0 +1 @@
+ALTER TABLE auth_otp_codes DROP COLUMN attempts;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE auth_otp_codes ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
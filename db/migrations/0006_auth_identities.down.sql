@@ -0,0 +1,23 @@
+ALTER TABLE auth
+ADD COLUMN provider auth_provider,
+ADD COLUMN provider_id VARCHAR(255);
+
+UPDATE auth
+SET provider = first_identity.provider,
+    provider_id = CASE WHEN first_identity.provider = 'email_otp' THEN NULL ELSE first_identity.provider_id END
+FROM (
+    SELECT DISTINCT ON (auth_id) auth_id, provider, provider_id
+    FROM auth_identities
+    ORDER BY auth_id, created_at
+) AS first_identity
+WHERE auth.id = first_identity.auth_id;
+
+UPDATE auth SET provider = 'email_otp' WHERE provider IS NULL;
+
+ALTER TABLE auth
+ALTER COLUMN provider SET NOT NULL,
+ADD CONSTRAINT auth_provider_provider_id_key UNIQUE (provider, provider_id);
+
+CREATE INDEX idx_auth_provider ON auth(provider, provider_id);
+
+DROP TABLE IF EXISTS auth_identities;
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS auth_identities (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+    provider auth_provider NOT NULL,
+    provider_id VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(provider, provider_id)
+);
+
+CREATE INDEX idx_auth_identities_auth_id ON auth_identities(auth_id);
+
+INSERT INTO auth_identities (auth_id, provider, provider_id, email, created_at)
+SELECT id, provider, COALESCE(provider_id, email), email, created_at
+FROM auth;
+
+ALTER TABLE auth
+DROP COLUMN provider,
+DROP COLUMN provider_id;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE auth_otp_codes DROP COLUMN kind;
+DROP TYPE IF EXISTS otp_kind;
\ No newline at end of file
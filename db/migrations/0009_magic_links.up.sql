@@ -0,0 +1,3 @@
+CREATE TYPE otp_kind AS ENUM ('code', 'magic_link');
+
+ALTER TABLE auth_otp_codes ADD COLUMN kind otp_kind NOT NULL DEFAULT 'code';
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS auth_recovery_codes;
+
+DROP TABLE IF EXISTS auth_totp;
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS auth_totp (
+    auth_id UUID PRIMARY KEY REFERENCES auth(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMP,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS auth_recovery_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_auth_recovery_codes_auth_id ON auth_recovery_codes(auth_id);
\ No newline at end of file
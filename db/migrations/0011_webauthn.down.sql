@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS webauthn_challenges;
+
+DROP TABLE IF EXISTS webauthn_credentials;
+
+DROP TYPE IF EXISTS webauthn_ceremony;
\ No newline at end of file
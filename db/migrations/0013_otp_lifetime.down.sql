@@ -0,0 +1 @@
+ALTER TABLE auth_otp_codes ALTER COLUMN expires_at SET DEFAULT (CURRENT_TIMESTAMP + INTERVAL '3 minutes');
\ No newline at end of file
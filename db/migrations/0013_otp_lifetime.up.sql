@@ -0,0 +1 @@
+ALTER TABLE auth_otp_codes ALTER COLUMN expires_at DROP DEFAULT;
\ No newline at end of file
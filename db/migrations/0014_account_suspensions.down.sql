@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS auth_suspension_events;
+
+DROP TYPE IF EXISTS suspension_action;
+
+ALTER TABLE auth
+    DROP COLUMN IF EXISTS suspended_until,
+    DROP COLUMN IF EXISTS suspension_reason;
\ No newline at end of file
@@ -0,0 +1,17 @@
+ALTER TABLE auth
+    ADD COLUMN suspension_reason TEXT,
+    ADD COLUMN suspended_until TIMESTAMP;
+
+CREATE TYPE suspension_action AS ENUM ('suspended', 'lifted');
+
+CREATE TABLE IF NOT EXISTS auth_suspension_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+    action suspension_action NOT NULL,
+    reason TEXT NOT NULL,
+    suspended_until TIMESTAMP,
+    actor VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_auth_suspension_events_auth_id ON auth_suspension_events(auth_id, created_at);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS auth_email_changes;
\ No newline at end of file
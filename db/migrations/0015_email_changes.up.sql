@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS auth_email_changes (
+    auth_id UUID PRIMARY KEY REFERENCES auth(id) ON DELETE CASCADE,
+    new_email VARCHAR(255) NOT NULL,
+    current_code_hash TEXT NOT NULL,
+    new_code_hash TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
\ No newline at end of file
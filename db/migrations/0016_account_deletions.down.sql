@@ -0,0 +1 @@
+DROP TABLE IF EXISTS auth_deletions;
\ No newline at end of file
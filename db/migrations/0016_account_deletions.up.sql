@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS auth_deletions (
+    auth_id UUID PRIMARY KEY REFERENCES auth(id) ON DELETE CASCADE,
+    restore_token_hash TEXT NOT NULL UNIQUE,
+    requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    purge_after TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_auth_deletions_purge_after ON auth_deletions(purge_after);
\ No newline at end of file
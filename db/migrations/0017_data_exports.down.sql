@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS data_exports;
+
+DROP TYPE IF EXISTS data_export_status;
\ No newline at end of file
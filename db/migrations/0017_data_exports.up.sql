@@ -0,0 +1,19 @@
+CREATE TYPE data_export_status AS ENUM ('pending', 'running', 'completed', 'failed');
+
+CREATE TABLE IF NOT EXISTS data_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status data_export_status NOT NULL DEFAULT 'pending',
+    archive_key TEXT,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX idx_data_exports_user_id ON data_exports(user_id, created_at);
+
+CREATE INDEX idx_data_exports_status ON data_exports(status, created_at);
+
+-- One export in flight per user
+CREATE UNIQUE INDEX idx_data_exports_user_in_flight ON data_exports(user_id) WHERE status IN ('pending', 'running');
\ No newline at end of file
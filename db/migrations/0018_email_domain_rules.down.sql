@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS email_domain_rules;
+DROP TYPE IF EXISTS email_domain_action;
@@ -0,0 +1,8 @@
+CREATE TYPE email_domain_action AS ENUM ('allow', 'deny');
+
+CREATE TABLE IF NOT EXISTS email_domain_rules (
+    domain TEXT PRIMARY KEY CHECK (domain = lower(domain)),
+    action email_domain_action NOT NULL,
+    note TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
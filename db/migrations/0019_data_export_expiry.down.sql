@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_data_exports_expires_at;
+
+ALTER TABLE data_exports DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,7 @@
+ALTER TABLE data_exports ADD COLUMN expires_at TIMESTAMP;
+
+-- Archives of exports completed before expiry was tracked are removed by the
+-- next worker run.
+UPDATE data_exports SET expires_at = completed_at WHERE archive_key IS NOT NULL;
+
+CREATE INDEX idx_data_exports_expires_at ON data_exports(expires_at) WHERE archive_key IS NOT NULL;
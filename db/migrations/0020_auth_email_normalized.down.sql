@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_auth_email_normalized;
+
+ALTER TABLE auth DROP COLUMN IF EXISTS email_normalized;
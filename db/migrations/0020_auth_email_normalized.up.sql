@@ -0,0 +1,33 @@
+-- Accounts are looked up by their normalized address: lower case, plus tag and
+-- trailing dot of the domain removed. The address as entered stays in email
+-- and is where mail goes.
+ALTER TABLE auth ADD COLUMN IF NOT EXISTS email_normalized VARCHAR(255);
+
+UPDATE auth
+SET email_normalized = lower(regexp_replace(rtrim(email, '.'), '\+[^@]*@', '@'));
+
+-- Accounts whose addresses normalize to the same one cannot be told apart at
+-- login. Stop here and name them, so they can be merged or one of them given
+-- another email before migrating again.
+DO $$
+DECLARE
+    collisions TEXT;
+BEGIN
+    SELECT string_agg(email_normalized || ' (auth ' || ids || ')', '; ')
+    INTO collisions
+    FROM (
+        SELECT email_normalized, string_agg(id::text, ', ' ORDER BY created_at) AS ids
+        FROM auth
+        GROUP BY email_normalized
+        HAVING COUNT(*) > 1
+    ) duplicates;
+
+    IF collisions IS NOT NULL THEN
+        RAISE EXCEPTION 'accounts share a normalized email address: %', collisions
+            USING HINT = 'Merge these accounts or change their email, then run the migration again.';
+    END IF;
+END $$;
+
+ALTER TABLE auth ALTER COLUMN email_normalized SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_auth_email_normalized ON auth(email_normalized);
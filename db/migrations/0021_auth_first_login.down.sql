@@ -0,0 +1 @@
+ALTER TABLE auth DROP COLUMN IF EXISTS first_login_at;
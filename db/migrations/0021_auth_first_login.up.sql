@@ -0,0 +1,14 @@
+-- first_login_at is set by the first login that starts a session; the login
+-- that sets it is reported as the account's first.
+ALTER TABLE auth ADD COLUMN IF NOT EXISTS first_login_at TIMESTAMP;
+
+-- Accounts that already signed in count from their oldest session.
+UPDATE auth a
+SET first_login_at = first.first_session_at
+FROM (
+    SELECT u.auth_id, MIN(s.created_at) AS first_session_at
+    FROM sessions s
+    JOIN users u ON u.id = s.user_id
+    GROUP BY u.auth_id
+) first
+WHERE first.auth_id = a.id AND a.first_login_at IS NULL;
@@ -1,18 +1,57 @@
 -- name: InsertAuth :one
-INSERT INTO auth (email, provider, provider_id)
-VALUES (sqlc.arg(email), sqlc.arg(provider), sqlc.arg(provider_id))
+INSERT INTO auth (email, email_normalized)
+VALUES (sqlc.arg(email), sqlc.arg(email_normalized))
 RETURNING id;
 
+-- name: InsertAuthIdentity :one
+INSERT INTO auth_identities (auth_id, provider, provider_id, email)
+VALUES (sqlc.arg(auth_id), sqlc.arg(provider), sqlc.arg(provider_id), sqlc.arg(email))
+RETURNING *;
+
 -- name: InsertUser :one
 INSERT INTO users (auth_id, username, display_name, avatar_key)
 VALUES (sqlc.arg(auth_id), sqlc.arg(username), sqlc.narg(display_name), sqlc.narg(avatar_key))
 RETURNING *;
 
 -- name: InsertAuthOtpCode :one
-INSERT INTO auth_otp_codes (auth_id, code)
-VALUES (sqlc.arg(auth_id), sqlc.arg(code))
+INSERT INTO auth_otp_codes (auth_id, code, kind, expires_at, attempts)
+VALUES (
+    sqlc.arg(auth_id),
+    sqlc.arg(code),
+    sqlc.arg(kind),
+    CURRENT_TIMESTAMP + make_interval(secs => sqlc.arg(lifetime_seconds)::float8),
+    COALESCE((
+        SELECT MAX(previous.attempts)
+        FROM auth_otp_codes previous
+        WHERE previous.auth_id = sqlc.arg(auth_id)
+          AND previous.kind = sqlc.arg(kind)
+          AND previous.expires_at > CURRENT_TIMESTAMP
+    ), 0)
+)
 RETURNING id;
 
+-- name: GetLatestOtpCodeAgeByAuthID :one
+SELECT EXTRACT(EPOCH FROM LOCALTIMESTAMP - created_at)::float8 AS age_seconds
+FROM auth_otp_codes
+WHERE auth_id = sqlc.arg(auth_id)
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: DeleteSupersededOtpCodesByAuthID :exec
+DELETE FROM auth_otp_codes superseded
+WHERE superseded.auth_id = sqlc.arg(auth_id)
+  AND (
+    superseded.expires_at <= CURRENT_TIMESTAMP
+    OR superseded.id <> (
+        SELECT latest.id
+        FROM auth_otp_codes latest
+        WHERE latest.auth_id = sqlc.arg(auth_id)
+          AND latest.expires_at > CURRENT_TIMESTAMP
+        ORDER BY latest.created_at DESC
+        LIMIT 1
+    )
+  );
+
 -- name: UpdateUser :one
 UPDATE users
 SET username = COALESCE(sqlc.narg(username), username), 
@@ -22,17 +61,198 @@ SET username = COALESCE(sqlc.narg(username), username),
 WHERE id = sqlc.arg(id)
 RETURNING *;
 
--- name: GetUserIdAndEmailByOtpCode :one
-SELECT users.id, auth.email , auth.id as auth_id
-FROM users 
-JOIN auth ON users.auth_id = auth.id 
-JOIN auth_otp_codes ON auth.id = auth_otp_codes.auth_id 
-WHERE auth_otp_codes.code = sqlc.arg(code) AND auth_otp_codes.expires_at > CURRENT_TIMESTAMP 
+-- name: ClearUserAvatarKey :one
+UPDATE users
+SET avatar_key = NULL
+WHERE id = sqlc.arg(id)
+RETURNING *;
+
+-- name: GetActiveOtpCodesByEmail :many
+SELECT users.id, auth.email, auth.id AS auth_id, auth_otp_codes.code
+FROM users
+JOIN auth ON users.auth_id = auth.id
+JOIN auth_otp_codes ON auth.id = auth_otp_codes.auth_id
+WHERE auth.email_normalized = sqlc.arg(email_normalized)
+  AND auth_otp_codes.kind = 'code'
+  AND auth_otp_codes.expires_at > CURRENT_TIMESTAMP;
+
+-- name: IncrementOtpAttemptsByEmail :one
+UPDATE auth_otp_codes
+SET attempts = attempts + 1
+WHERE id = (
+    SELECT auth_otp_codes.id
+    FROM auth_otp_codes
+    JOIN auth ON auth_otp_codes.auth_id = auth.id
+    WHERE auth.email_normalized = sqlc.arg(email_normalized)
+      AND auth_otp_codes.kind = 'code'
+      AND auth_otp_codes.expires_at > CURRENT_TIMESTAMP
+    ORDER BY auth_otp_codes.created_at DESC
+    LIMIT 1
+)
+RETURNING attempts;
+
+
+-- name: GetAuthByID :one
+SELECT * FROM auth WHERE id = sqlc.arg(id) LIMIT 1;
+
+-- name: GetActiveAuthRestriction :one
+SELECT a.suspension_reason, a.suspended_until, d.purge_after
+FROM auth a
+LEFT JOIN auth_deletions d ON d.auth_id = a.id
+WHERE a.id = sqlc.arg(id)
+  AND (
+    (a.is_suspended AND (a.suspended_until IS NULL OR a.suspended_until > CURRENT_TIMESTAMP))
+    OR d.auth_id IS NOT NULL
+  );
+
+-- name: SuspendAuth :execrows
+UPDATE auth
+SET is_suspended = TRUE,
+    suspension_reason = sqlc.arg(reason),
+    suspended_until = sqlc.narg(suspended_until),
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id);
+
+-- name: LiftAuthSuspension :execrows
+UPDATE auth
+SET is_suspended = FALSE,
+    suspension_reason = NULL,
+    suspended_until = NULL,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id) AND is_suspended;
+
+-- name: InsertAuthSuspensionEvent :one
+INSERT INTO auth_suspension_events (auth_id, action, reason, suspended_until, actor)
+VALUES (sqlc.arg(auth_id), sqlc.arg(action), sqlc.arg(reason), sqlc.narg(suspended_until), sqlc.arg(actor))
+RETURNING *;
+
+-- name: ListAuthSuspensionEvents :many
+SELECT * FROM auth_suspension_events
+WHERE auth_id = sqlc.arg(auth_id)
+ORDER BY created_at DESC;
+
+-- name: AuthEmailExists :one
+SELECT EXISTS(SELECT 1 FROM auth WHERE email_normalized = sqlc.arg(email_normalized));
+
+-- name: UpdateAuthEmail :execrows
+UPDATE auth
+SET email = sqlc.arg(email), email_normalized = sqlc.arg(email_normalized), updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id);
+
+-- name: MarkAuthFirstLogin :execrows
+UPDATE auth
+SET first_login_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id) AND first_login_at IS NULL;
+
+-- name: UpdateEmailOtpIdentity :exec
+UPDATE auth_identities
+SET provider_id = sqlc.arg(email), email = sqlc.arg(email)
+WHERE auth_id = sqlc.arg(auth_id) AND provider = 'email_otp';
+
+-- name: UpsertAuthEmailChange :one
+INSERT INTO auth_email_changes (auth_id, new_email, current_code_hash, new_code_hash, expires_at)
+VALUES (
+    sqlc.arg(auth_id),
+    sqlc.arg(new_email),
+    sqlc.arg(current_code_hash),
+    sqlc.arg(new_code_hash),
+    CURRENT_TIMESTAMP + make_interval(secs => sqlc.arg(lifetime_seconds)::float8)
+)
+ON CONFLICT (auth_id) DO UPDATE
+SET new_email = EXCLUDED.new_email,
+    current_code_hash = EXCLUDED.current_code_hash,
+    new_code_hash = EXCLUDED.new_code_hash,
+    attempts = 0,
+    expires_at = EXCLUDED.expires_at,
+    created_at = CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: GetAuthEmailChangeAge :one
+SELECT EXTRACT(EPOCH FROM LOCALTIMESTAMP - created_at)::float8 AS age_seconds
+FROM auth_email_changes
+WHERE auth_id = sqlc.arg(auth_id);
+
+-- name: GetActiveAuthEmailChange :one
+SELECT * FROM auth_email_changes
+WHERE auth_id = sqlc.arg(auth_id) AND expires_at > CURRENT_TIMESTAMP;
+
+-- name: IncrementAuthEmailChangeAttempts :one
+UPDATE auth_email_changes
+SET attempts = attempts + 1
+WHERE auth_id = sqlc.arg(auth_id) AND expires_at > CURRENT_TIMESTAMP
+RETURNING attempts;
+
+-- name: DeleteAuthEmailChange :execrows
+DELETE FROM auth_email_changes
+WHERE auth_id = sqlc.arg(auth_id) AND new_email = sqlc.arg(new_email);
+
+-- name: DeleteAuthEmailChangesByAuthID :exec
+DELETE FROM auth_email_changes WHERE auth_id = sqlc.arg(auth_id);
+
+-- name: InsertAuthDeletion :one
+INSERT INTO auth_deletions (auth_id, restore_token_hash, purge_after)
+VALUES (
+    sqlc.arg(auth_id),
+    sqlc.arg(restore_token_hash),
+    CURRENT_TIMESTAMP + make_interval(secs => sqlc.arg(grace_seconds)::float8)
+)
+RETURNING *;
+
+-- name: DeleteAuthDeletionByTokenHash :one
+DELETE FROM auth_deletions
+WHERE restore_token_hash = sqlc.arg(restore_token_hash) AND purge_after > CURRENT_TIMESTAMP
+RETURNING auth_id;
+
+-- name: ListDueAuthDeletions :many
+SELECT d.auth_id, u.avatar_key,
+    ARRAY(
+        SELECT e.archive_key FROM data_exports e
+        WHERE e.user_id = u.id AND e.archive_key IS NOT NULL
+        ORDER BY e.created_at
+    )::text[] AS export_archive_keys
+FROM auth_deletions d
+LEFT JOIN users u ON u.auth_id = d.auth_id
+WHERE d.purge_after <= CURRENT_TIMESTAMP
+ORDER BY d.purge_after
+LIMIT sqlc.arg(max_rows);
+
+-- name: DeleteDueAuth :execrows
+DELETE FROM auth
+WHERE id = sqlc.arg(id)
+  AND EXISTS (
+    SELECT 1 FROM auth_deletions d WHERE d.auth_id = auth.id AND d.purge_after <= CURRENT_TIMESTAMP
+  );
+
+-- name: LockAuthByID :one
+SELECT id FROM auth WHERE id = sqlc.arg(id) FOR UPDATE;
+
+-- name: GetAuthByIdentity :one
+SELECT auth.* FROM auth
+JOIN auth_identities ON auth_identities.auth_id = auth.id
+WHERE auth_identities.provider = sqlc.arg(provider) AND auth_identities.provider_id = sqlc.arg(provider_id)
 LIMIT 1;
 
+-- name: ListAuthIdentitiesByAuthID :many
+SELECT * FROM auth_identities WHERE auth_id = sqlc.arg(auth_id) ORDER BY created_at, id;
+
+-- name: CountAuthIdentitiesByAuthID :one
+SELECT COUNT(*) FROM auth_identities WHERE auth_id = sqlc.arg(auth_id);
+
+-- name: AuthIdentityExists :one
+SELECT EXISTS(
+    SELECT 1 FROM auth_identities WHERE auth_id = sqlc.arg(auth_id) AND provider = sqlc.arg(provider)
+);
+
+-- name: DeleteAuthIdentity :execrows
+DELETE FROM auth_identities WHERE id = sqlc.arg(id) AND auth_id = sqlc.arg(auth_id);
+
+-- name: GetUserByAuthID :one
+SELECT * FROM users WHERE auth_id = sqlc.arg(auth_id) LIMIT 1;
 
 -- name: GetUserByEmail :one
-SELECT * FROM users WHERE auth_id = (SELECT id FROM auth WHERE email = sqlc.arg(email)) LIMIT 1;
+SELECT * FROM users
+WHERE auth_id = (SELECT id FROM auth WHERE email_normalized = sqlc.arg(email_normalized))
+LIMIT 1;
 
 -- name: GetUserByID :one
 SELECT * FROM users WHERE id = sqlc.arg(id) LIMIT 1;
@@ -44,7 +264,119 @@ DELETE FROM auth_otp_codes WHERE auth_id = sqlc.arg(auth_id);
 DELETE FROM auth_otp_codes WHERE auth_id = (SELECT u.auth_id FROM users u WHERE u.id = sqlc.arg(user_id));
 
 -- name: DeleteOtpCodesByEmail :exec
-DELETE FROM auth_otp_codes WHERE auth_id = (SELECT id FROM auth WHERE email = sqlc.arg(email));
+DELETE FROM auth_otp_codes
+WHERE auth_id = (SELECT id FROM auth WHERE email_normalized = sqlc.arg(email_normalized));
+
+-- name: ConsumeMagicLinkByUserID :execrows
+DELETE FROM auth_otp_codes
+WHERE kind = 'magic_link'
+  AND code = sqlc.arg(code)
+  AND expires_at > CURRENT_TIMESTAMP
+  AND auth_id = (SELECT u.auth_id FROM users u WHERE u.id = sqlc.arg(user_id));
 
 -- name: UserNameExists :one
-SELECT COUNT(*) FROM users WHERE username = sqlc.arg(username) LIMIT 1;
\ No newline at end of file
+SELECT COUNT(*) FROM users WHERE username = sqlc.arg(username) LIMIT 1;
+
+-- name: InsertRefreshToken :exec
+INSERT INTO refresh_tokens (id, user_id, family_id, parent_id, expires_at, session_id)
+VALUES (sqlc.arg(id), sqlc.arg(user_id), sqlc.arg(family_id), sqlc.narg(parent_id), sqlc.arg(expires_at), sqlc.narg(session_id));
+
+-- name: GetRefreshTokenByID :one
+SELECT * FROM refresh_tokens WHERE id = sqlc.arg(id) LIMIT 1;
+
+-- name: UseRefreshToken :one
+UPDATE refresh_tokens
+SET used_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id)
+    AND used_at IS NULL
+    AND revoked_at IS NULL
+    AND expires_at > CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE family_id = sqlc.arg(family_id) AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokensBySessionID :exec
+UPDATE refresh_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE session_id = sqlc.arg(session_id) AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokensByUserID :exec
+UPDATE refresh_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = sqlc.arg(user_id) AND revoked_at IS NULL;
+
+-- name: InsertSession :one
+INSERT INTO sessions (user_id, device_label, ip_address, user_agent)
+VALUES (sqlc.arg(user_id), sqlc.narg(device_label), sqlc.narg(ip_address), sqlc.narg(user_agent))
+RETURNING *;
+
+-- name: GetSessionByID :one
+SELECT * FROM sessions WHERE id = sqlc.arg(id) LIMIT 1;
+
+-- name: ListActiveSessionsByUserID :many
+SELECT * FROM sessions
+WHERE user_id = sqlc.arg(user_id) AND revoked_at IS NULL
+ORDER BY last_seen_at DESC, id;
+
+-- name: TouchSession :exec
+UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP WHERE id = sqlc.arg(id);
+
+-- name: RevokeSession :execrows
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id) AND revoked_at IS NULL;
+
+-- name: RevokeSessionsByUserID :exec
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = sqlc.arg(user_id) AND revoked_at IS NULL;
+
+-- name: UpsertPendingAuthTotp :one
+INSERT INTO auth_totp (auth_id, secret)
+VALUES (sqlc.arg(auth_id), sqlc.arg(secret))
+ON CONFLICT (auth_id) DO UPDATE
+SET secret = EXCLUDED.secret,
+    last_used_step = 0,
+    failed_attempts = 0,
+    created_at = CURRENT_TIMESTAMP
+WHERE auth_totp.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetAuthTotpByAuthID :one
+SELECT * FROM auth_totp WHERE auth_id = sqlc.arg(auth_id) LIMIT 1;
+
+-- name: ConfirmAuthTotp :execrows
+UPDATE auth_totp
+SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = sqlc.arg(last_used_step)
+WHERE auth_id = sqlc.arg(auth_id) AND confirmed_at IS NULL;
+
+-- name: UseAuthTotpStep :execrows
+UPDATE auth_totp
+SET last_used_step = sqlc.arg(last_used_step), failed_attempts = 0
+WHERE auth_id = sqlc.arg(auth_id)
+    AND confirmed_at IS NOT NULL
+    AND last_used_step < sqlc.arg(last_used_step);
+
+-- name: IncrementAuthTotpFailedAttempts :one
+UPDATE auth_totp
+SET failed_attempts = failed_attempts + 1
+WHERE auth_id = sqlc.arg(auth_id) AND confirmed_at IS NOT NULL
+RETURNING failed_attempts;
+
+-- name: ResetAuthTotpFailedAttempts :exec
+UPDATE auth_totp SET failed_attempts = 0 WHERE auth_id = sqlc.arg(auth_id);
+
+-- name: DeleteAuthRecoveryCodesByAuthID :exec
+DELETE FROM auth_recovery_codes WHERE auth_id = sqlc.arg(auth_id);
+
+-- name: InsertAuthRecoveryCode :exec
+INSERT INTO auth_recovery_codes (auth_id, code_hash)
+VALUES (sqlc.arg(auth_id), sqlc.arg(code_hash));
+
+-- name: UseAuthRecoveryCode :execrows
+UPDATE auth_recovery_codes
+SET used_at = CURRENT_TIMESTAMP
+WHERE auth_id = sqlc.arg(auth_id) AND code_hash = sqlc.arg(code_hash) AND used_at IS NULL;
\ No newline at end of file
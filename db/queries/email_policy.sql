@@ -0,0 +1,4 @@
+-- name: ListEmailDomainRules :many
+SELECT * FROM email_domain_rules
+WHERE domain = ANY(sqlc.arg(domains)::text[])
+ORDER BY length(domain) DESC;
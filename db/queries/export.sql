@@ -0,0 +1,51 @@
+-- name: InsertDataExport :one
+INSERT INTO data_exports (user_id)
+VALUES (sqlc.arg(user_id))
+RETURNING *;
+
+-- name: GetLatestDataExportByUserID :one
+SELECT * FROM data_exports
+WHERE user_id = sqlc.arg(user_id)
+ORDER BY created_at DESC, id
+LIMIT 1;
+
+-- name: ClaimNextDataExport :one
+UPDATE data_exports
+SET status = 'running', started_at = CURRENT_TIMESTAMP
+WHERE id = (
+    SELECT e.id FROM data_exports e
+    WHERE e.status = 'pending'
+        OR (e.status = 'running' AND e.started_at < CURRENT_TIMESTAMP - make_interval(secs => sqlc.arg(stale_seconds)::float8))
+    ORDER BY e.created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CompleteDataExport :execrows
+UPDATE data_exports
+SET status = 'completed', archive_key = sqlc.arg(archive_key), error = NULL, completed_at = CURRENT_TIMESTAMP,
+    expires_at = CURRENT_TIMESTAMP + make_interval(secs => sqlc.arg(ttl_seconds)::float8)
+WHERE id = sqlc.arg(id) AND status = 'running';
+
+-- name: FailDataExport :execrows
+UPDATE data_exports
+SET status = 'failed', error = sqlc.arg(error), completed_at = CURRENT_TIMESTAMP,
+    archive_key = sqlc.narg(archive_key), expires_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id) AND status = 'running';
+
+-- name: ListExpiredDataExports :many
+SELECT * FROM data_exports
+WHERE archive_key IS NOT NULL AND expires_at <= CURRENT_TIMESTAMP
+ORDER BY expires_at
+LIMIT sqlc.arg(max_rows);
+
+-- name: ClearDataExportArchive :execrows
+UPDATE data_exports
+SET archive_key = NULL
+WHERE id = sqlc.arg(id) AND archive_key = sqlc.arg(archive_key);
+
+-- name: ListSessionsByUserID :many
+SELECT * FROM sessions
+WHERE user_id = sqlc.arg(user_id)
+ORDER BY created_at, id;
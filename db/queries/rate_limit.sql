@@ -0,0 +1,15 @@
+-- name: InsertRateLimitBucket :exec
+INSERT INTO rate_limit_buckets (key, tokens, updated_at, expires_at)
+VALUES (sqlc.arg(key), sqlc.arg(tokens), sqlc.arg(updated_at), sqlc.arg(expires_at))
+ON CONFLICT (key) DO NOTHING;
+
+-- name: GetRateLimitBucketForUpdate :one
+SELECT * FROM rate_limit_buckets WHERE key = sqlc.arg(key) FOR UPDATE;
+
+-- name: UpdateRateLimitBucket :exec
+UPDATE rate_limit_buckets
+SET tokens = sqlc.arg(tokens), updated_at = sqlc.arg(updated_at), expires_at = sqlc.arg(expires_at)
+WHERE key = sqlc.arg(key);
+
+-- name: DeleteExpiredRateLimitBuckets :exec
+DELETE FROM rate_limit_buckets WHERE expires_at < sqlc.arg(now);
@@ -0,0 +1,44 @@
+-- name: InsertWebauthnChallenge :exec
+INSERT INTO webauthn_challenges (auth_id, ceremony, challenge_hash)
+VALUES (sqlc.narg(auth_id), sqlc.arg(ceremony), sqlc.arg(challenge_hash));
+
+-- name: ConsumeWebauthnChallenge :one
+DELETE FROM webauthn_challenges
+WHERE challenge_hash = sqlc.arg(challenge_hash)
+    AND ceremony = sqlc.arg(ceremony)
+    AND expires_at > CURRENT_TIMESTAMP
+RETURNING *;
+
+-- name: DeleteExpiredWebauthnChallenges :exec
+DELETE FROM webauthn_challenges WHERE expires_at <= CURRENT_TIMESTAMP;
+
+-- name: InsertWebauthnCredential :one
+INSERT INTO webauthn_credentials (
+    auth_id, credential_id, public_key, algorithm, sign_count, aaguid, backup_eligible, backed_up, label
+)
+VALUES (
+    sqlc.arg(auth_id),
+    sqlc.arg(credential_id),
+    sqlc.arg(public_key),
+    sqlc.arg(algorithm),
+    sqlc.arg(sign_count),
+    sqlc.arg(aaguid),
+    sqlc.arg(backup_eligible),
+    sqlc.arg(backed_up),
+    sqlc.narg(label)
+)
+RETURNING *;
+
+-- name: GetWebauthnCredentialByCredentialID :one
+SELECT * FROM webauthn_credentials WHERE credential_id = sqlc.arg(credential_id) LIMIT 1;
+
+-- name: ListWebauthnCredentialsByAuthID :many
+SELECT * FROM webauthn_credentials WHERE auth_id = sqlc.arg(auth_id) ORDER BY created_at;
+
+-- name: UpdateWebauthnCredentialSignCount :execrows
+UPDATE webauthn_credentials
+SET sign_count = sqlc.arg(sign_count), backed_up = sqlc.arg(backed_up), last_used_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id) AND sign_count = sqlc.arg(previous_sign_count);
+
+-- name: DeleteWebauthnCredential :execrows
+DELETE FROM webauthn_credentials WHERE id = sqlc.arg(id) AND auth_id = sqlc.arg(auth_id);
\ No newline at end of file
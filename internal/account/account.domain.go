@@ -0,0 +1,408 @@
+package account
+
+import (
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+var moduleErrors = []int{400, 401, 403, 404, 409, 429, 500}
+var moduleTags = []string{"Account"}
+var moduleSecurity = []map[string][]string{{"bearer": {}}}
+
+const (
+	ListIdentities     = "listIdentities"
+	LinkGoogleIdentity = "linkGoogleIdentity"
+	LinkEmailIdentity  = "linkEmailIdentity"
+	UnlinkIdentity     = "unlinkIdentity"
+	ListSessions       = "listSessions"
+	RevokeSession      = "revokeSession"
+	RevokeAllSessions  = "revokeAllSessions"
+	Logout             = "logout"
+	EnrollTOTP         = "enrollTotp"
+	ConfirmTOTP        = "confirmTotp"
+	PasskeyOptions     = "passkeyRegistrationOptions"
+	RegisterPasskey    = "registerPasskey"
+	ListPasskeys       = "listPasskeys"
+	DeletePasskey      = "deletePasskey"
+	ChangeEmail        = "changeEmail"
+	ConfirmEmailChange = "confirmEmailChange"
+	DeleteAccount      = "deleteAccount"
+	RestoreAccount     = "restoreAccount"
+)
+
+var operations = map[string]huma.Operation{
+	ListIdentities: {
+		Method:      "GET",
+		Path:        "/me/identities",
+		Summary:     "List login methods",
+		Description: "List the identities that can be used to sign in to the current account",
+		OperationID: ListIdentities,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	LinkGoogleIdentity: {
+		Method:      "POST",
+		Path:        "/me/identities/google",
+		Summary:     "Link a Google account",
+		Description: "Verify a Google ID token and attach its identity to the current account",
+		OperationID: LinkGoogleIdentity,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	LinkEmailIdentity: {
+		Method:      "POST",
+		Path:        "/me/identities/email",
+		Summary:     "Enable email code login",
+		Description: "Allow signing in to the current account with a one-time code sent to the account email",
+		OperationID: LinkEmailIdentity,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	UnlinkIdentity: {
+		Method:      "DELETE",
+		Path:        "/me/identities/{identityId}",
+		Summary:     "Unlink a login method",
+		Description: "Remove an identity from the current account; the last remaining identity cannot be removed",
+		OperationID: UnlinkIdentity,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	ListSessions: {
+		Method:      "GET",
+		Path:        "/me/sessions",
+		Summary:     "List sessions",
+		Description: "List the devices the current account is signed in on",
+		OperationID: ListSessions,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	RevokeSession: {
+		Method:      "DELETE",
+		Path:        "/me/sessions/{sessionId}",
+		Summary:     "Revoke a session",
+		Description: "Sign a device out; its access and refresh tokens stop working",
+		OperationID: RevokeSession,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	RevokeAllSessions: {
+		Method:      "DELETE",
+		Path:        "/me/sessions",
+		Summary:     "Log out everywhere",
+		Description: "Revoke every session of the current account, including this one",
+		OperationID: RevokeAllSessions,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	Logout: {
+		Method:      "POST",
+		Path:        "/me/logout",
+		Summary:     "Log out",
+		Description: "Revoke the session the request was made with",
+		OperationID: Logout,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	EnrollTOTP: {
+		Method:      "POST",
+		Path:        "/me/totp",
+		Summary:     "Start authenticator setup",
+		Description: "Generate an authenticator secret; two-factor login is enabled once the first code is confirmed",
+		OperationID: EnrollTOTP,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	ConfirmTOTP: {
+		Method:      "POST",
+		Path:        "/me/totp/confirm",
+		Summary:     "Confirm authenticator setup",
+		Description: "Enable two-factor login with a code from the authenticator and return single-use recovery codes",
+		OperationID: ConfirmTOTP,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	PasskeyOptions: {
+		Method:      "POST",
+		Path:        "/me/passkeys/options",
+		Summary:     "Start passkey registration",
+		Description: "Issue a challenge and the options to pass to navigator.credentials.create",
+		OperationID: PasskeyOptions,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	RegisterPasskey: {
+		Method:      "POST",
+		Path:        "/me/passkeys",
+		Summary:     "Register a passkey",
+		Description: "Verify the credential returned by navigator.credentials.create and add it to the current account",
+		OperationID: RegisterPasskey,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	ListPasskeys: {
+		Method:      "GET",
+		Path:        "/me/passkeys",
+		Summary:     "List passkeys",
+		Description: "List the passkeys that can sign in to the current account",
+		OperationID: ListPasskeys,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	DeletePasskey: {
+		Method:      "DELETE",
+		Path:        "/me/passkeys/{passkeyId}",
+		Summary:     "Remove a passkey",
+		Description: "Remove a passkey from the current account; it can no longer be used to sign in",
+		OperationID: DeletePasskey,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	ChangeEmail: {
+		Method:      "POST",
+		Path:        "/me/email",
+		Summary:     "Start an email change",
+		Description: "Send a confirmation code to both the current and the new email address",
+		OperationID: ChangeEmail,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	ConfirmEmailChange: {
+		Method:      "POST",
+		Path:        "/me/email/confirm",
+		Summary:     "Confirm an email change",
+		Description: "Change the account email with the codes sent to both addresses; every session is signed out",
+		OperationID: ConfirmEmailChange,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	DeleteAccount: {
+		Method:      "DELETE",
+		Path:        "/me",
+		Summary:     "Delete account",
+		Description: "Sign out everywhere and delete the account once the grace period ends unless it is restored",
+		OperationID: DeleteAccount,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	RestoreAccount: {
+		Method:      "POST",
+		Path:        "/auth/restore-account",
+		Summary:     "Restore a deleted account",
+		Description: "Cancel a scheduled account deletion with the token from the restore link",
+		OperationID: RestoreAccount,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+	},
+}
+
+type IdentityData struct {
+	ID        string    `json:"id"`
+	Provider  string    `json:"provider" enum:"email_otp,google_oauth"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ListIdentitiesInput struct{}
+
+type ListIdentitiesOutput struct {
+	Body struct {
+		Data []IdentityData
+	}
+}
+
+type LinkGoogleIdentityInput struct {
+	Body struct {
+		IDToken string `json:"idToken" doc:"ID token issued by Google Sign-In" required:"true" minLength:"1"`
+	}
+}
+
+type LinkIdentityOutput struct {
+	Body struct {
+		Data IdentityData
+	}
+}
+
+type LinkEmailIdentityInput struct{}
+
+type UnlinkIdentityInput struct {
+	IdentityID string `path:"identityId" doc:"ID of the identity to remove" format:"uuid"`
+}
+
+type UnlinkIdentityOutput struct{}
+
+type SessionData struct {
+	ID          string    `json:"id"`
+	DeviceLabel string    `json:"deviceLabel,omitempty"`
+	IPAddress   string    `json:"ipAddress,omitempty"`
+	UserAgent   string    `json:"userAgent,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+	Current     bool      `json:"current" doc:"Whether this is the session the request was made with"`
+}
+
+type ListSessionsInput struct{}
+
+type ListSessionsOutput struct {
+	Body struct {
+		Data []SessionData
+	}
+}
+
+type RevokeSessionInput struct {
+	SessionID string `path:"sessionId" doc:"ID of the session to revoke" format:"uuid"`
+}
+
+type RevokeSessionOutput struct{}
+
+type RevokeAllSessionsInput struct{}
+
+type RevokeAllSessionsOutput struct{}
+
+type LogoutInput struct{}
+
+type LogoutOutput struct{}
+
+type EnrollTOTPInput struct{}
+
+type TOTPEnrollmentData struct {
+	Secret          string `json:"secret" doc:"Base32 secret for manual entry in an authenticator app"`
+	ProvisioningURI string `json:"provisioningUri" doc:"otpauth:// URI to render as a QR code"`
+}
+
+type EnrollTOTPOutput struct {
+	Body struct {
+		Data TOTPEnrollmentData
+	}
+}
+
+type ConfirmTOTPInput struct {
+	Body struct {
+		Code string `json:"code" doc:"Current code shown by the authenticator app" required:"true" minLength:"6" maxLength:"6"`
+	}
+}
+
+type RecoveryCodesData struct {
+	RecoveryCodes []string `json:"recoveryCodes" doc:"Single-use codes that replace the authenticator; shown only once"`
+}
+
+type ConfirmTOTPOutput struct {
+	Body struct {
+		Data RecoveryCodesData
+	}
+}
+
+type PasskeyOptionsInput struct{}
+
+type PasskeyOptionsOutput struct {
+	Body struct {
+		Data webauthn.CreationOptions
+	}
+}
+
+type PasskeyData struct {
+	ID         string     `json:"id"`
+	Label      string     `json:"label,omitempty"`
+	BackedUp   bool       `json:"backedUp" doc:"Whether the passkey is synced to other devices"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+type RegisterPasskeyInput struct {
+	Body struct {
+		Credential webauthn.RegistrationCredentialJSON `json:"credential" doc:"Result of credential.toJSON()" required:"true"`
+		Label      string                              `json:"label,omitempty" doc:"Name shown in the passkey list" maxLength:"255"`
+	}
+}
+
+type RegisterPasskeyOutput struct {
+	Body struct {
+		Data PasskeyData
+	}
+}
+
+type ListPasskeysInput struct{}
+
+type ListPasskeysOutput struct {
+	Body struct {
+		Data []PasskeyData
+	}
+}
+
+type DeletePasskeyInput struct {
+	PasskeyID string `path:"passkeyId" doc:"ID of the passkey to remove" format:"uuid"`
+}
+
+type DeletePasskeyOutput struct{}
+
+type ChangeEmailInput struct {
+	Body struct {
+		Email string `json:"email" doc:"New email address" required:"true" format:"email" maxLength:"255"`
+	}
+}
+
+type EmailChangeData struct {
+	NewEmail  string    `json:"newEmail"`
+	ExpiresAt time.Time `json:"expiresAt" doc:"When the confirmation codes expire"`
+}
+
+type ChangeEmailOutput struct {
+	Body struct {
+		Data EmailChangeData
+	}
+}
+
+type ConfirmEmailChangeInput struct {
+	Body struct {
+		CurrentCode string `json:"currentCode" doc:"Code sent to the current address" required:"true" minLength:"1"`
+		NewCode     string `json:"newCode" doc:"Code sent to the new address" required:"true" minLength:"1"`
+	}
+}
+
+type EmailData struct {
+	Email string `json:"email"`
+}
+
+type ConfirmEmailChangeOutput struct {
+	Body struct {
+		Data EmailData
+	}
+}
+
+type DeleteAccountInput struct{}
+
+type AccountDeletionData struct {
+	PurgeAfter time.Time `json:"purgeAfter" doc:"When the account is permanently deleted unless restored"`
+}
+
+type DeleteAccountOutput struct {
+	Body struct {
+		Data AccountDeletionData
+	}
+}
+
+type RestoreAccountInput struct {
+	Body struct {
+		Token string `json:"token" doc:"Token from the restore link" required:"true" minLength:"1"`
+	}
+}
+
+type RestoreAccountOutput struct{}
@@ -0,0 +1,69 @@
+package account
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/auth"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	mail "github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	oauthport "github.com/abdurrahimagca/qq-back/internal/platform/oauth"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Module struct {
+	usecase Usecase
+	server  Server
+}
+
+func NewModule(
+	authService auth.Service,
+	pool *pgxpool.Pool,
+	googleVerifier oauthport.Verifier,
+	passkeyService webauthn.Service,
+	mailer mail.Service,
+	uploader fileupload.Uploader,
+	emailPolicy emailpolicy.Policy,
+	conf environment.AccountEnvironment,
+) *Module {
+	usecase := NewUsecase(authService, pool, googleVerifier, passkeyService, mailer, uploader, emailPolicy, conf)
+	server := NewServer(usecase)
+
+	return &Module{
+		usecase: usecase,
+		server:  server,
+	}
+}
+
+func (am *Module) RegisterEndpoints(api huma.API) {
+	am.server.RegisterAccountEndpoints(api)
+}
+
+// RunPurger purges the accounts whose deletion grace period has ended, once at
+// start and then every interval, until ctx is done.
+func (am *Module) RunPurger(ctx context.Context, interval time.Duration) {
+	logger := slog.Default()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := am.usecase.PurgeDueAccounts(ctx)
+		if err != nil {
+			logger.Error("Error purging deleted accounts", "error", err)
+		}
+		if purged > 0 {
+			logger.Info("Purged deleted accounts", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
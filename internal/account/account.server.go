@@ -0,0 +1,456 @@
+package account
+
+import (
+	"context"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/middleware"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type accountServer struct {
+	uc Usecase
+}
+type Server interface {
+	ListIdentitiesHandler(ctx context.Context, input *ListIdentitiesInput) (*ListIdentitiesOutput, error)
+	LinkGoogleIdentityHandler(ctx context.Context, input *LinkGoogleIdentityInput) (*LinkIdentityOutput, error)
+	LinkEmailIdentityHandler(ctx context.Context, input *LinkEmailIdentityInput) (*LinkIdentityOutput, error)
+	UnlinkIdentityHandler(ctx context.Context, input *UnlinkIdentityInput) (*UnlinkIdentityOutput, error)
+	ListSessionsHandler(ctx context.Context, input *ListSessionsInput) (*ListSessionsOutput, error)
+	RevokeSessionHandler(ctx context.Context, input *RevokeSessionInput) (*RevokeSessionOutput, error)
+	RevokeAllSessionsHandler(ctx context.Context, input *RevokeAllSessionsInput) (*RevokeAllSessionsOutput, error)
+	LogoutHandler(ctx context.Context, input *LogoutInput) (*LogoutOutput, error)
+	EnrollTOTPHandler(ctx context.Context, input *EnrollTOTPInput) (*EnrollTOTPOutput, error)
+	ConfirmTOTPHandler(ctx context.Context, input *ConfirmTOTPInput) (*ConfirmTOTPOutput, error)
+	PasskeyOptionsHandler(ctx context.Context, input *PasskeyOptionsInput) (*PasskeyOptionsOutput, error)
+	RegisterPasskeyHandler(ctx context.Context, input *RegisterPasskeyInput) (*RegisterPasskeyOutput, error)
+	ListPasskeysHandler(ctx context.Context, input *ListPasskeysInput) (*ListPasskeysOutput, error)
+	DeletePasskeyHandler(ctx context.Context, input *DeletePasskeyInput) (*DeletePasskeyOutput, error)
+	ChangeEmailHandler(ctx context.Context, input *ChangeEmailInput) (*ChangeEmailOutput, error)
+	ConfirmEmailChangeHandler(ctx context.Context, input *ConfirmEmailChangeInput) (*ConfirmEmailChangeOutput, error)
+	DeleteAccountHandler(ctx context.Context, input *DeleteAccountInput) (*DeleteAccountOutput, error)
+	RestoreAccountHandler(ctx context.Context, input *RestoreAccountInput) (*RestoreAccountOutput, error)
+	RegisterAccountEndpoints(api huma.API)
+}
+
+func NewServer(uc Usecase) Server {
+	return &accountServer{uc: uc}
+}
+
+func (s *accountServer) ListIdentitiesHandler(
+	ctx context.Context, _ *ListIdentitiesInput) (*ListIdentitiesOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	identities, err := s.uc.ListIdentities(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	data := make([]IdentityData, 0, len(identities))
+	for _, identity := range identities {
+		data = append(data, toIdentityData(identity))
+	}
+
+	return &ListIdentitiesOutput{
+		Body: struct {
+			Data []IdentityData
+		}{
+			Data: data,
+		},
+	}, nil
+}
+
+func (s *accountServer) LinkGoogleIdentityHandler(
+	ctx context.Context, input *LinkGoogleIdentityInput) (*LinkIdentityOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	identity, err := s.uc.LinkGoogleIdentity(ctx, user, input.Body.IDToken)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return newLinkIdentityOutput(identity), nil
+}
+
+func (s *accountServer) LinkEmailIdentityHandler(
+	ctx context.Context, _ *LinkEmailIdentityInput) (*LinkIdentityOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	identity, err := s.uc.LinkEmailIdentity(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return newLinkIdentityOutput(identity), nil
+}
+
+func (s *accountServer) UnlinkIdentityHandler(
+	ctx context.Context, input *UnlinkIdentityInput) (*UnlinkIdentityOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	var identityID pgtype.UUID
+	if err := identityID.Scan(input.IdentityID); err != nil {
+		return nil, huma.Error422UnprocessableEntity("Validation error", err)
+	}
+
+	if err := s.uc.UnlinkIdentity(ctx, user, identityID); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &UnlinkIdentityOutput{}, nil
+}
+
+func (s *accountServer) ListSessionsHandler(
+	ctx context.Context, _ *ListSessionsInput) (*ListSessionsOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+	currentID, _ := middleware.GetSessionIDFromContext(ctx)
+
+	sessions, err := s.uc.ListSessions(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	data := make([]SessionData, 0, len(sessions))
+	for _, session := range sessions {
+		data = append(data, toSessionData(session, currentID))
+	}
+
+	return &ListSessionsOutput{
+		Body: struct {
+			Data []SessionData
+		}{
+			Data: data,
+		},
+	}, nil
+}
+
+func (s *accountServer) RevokeSessionHandler(
+	ctx context.Context, input *RevokeSessionInput) (*RevokeSessionOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	var sessionID pgtype.UUID
+	if err := sessionID.Scan(input.SessionID); err != nil {
+		return nil, huma.Error422UnprocessableEntity("Validation error", err)
+	}
+
+	if err := s.uc.RevokeSession(ctx, user, sessionID); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &RevokeSessionOutput{}, nil
+}
+
+func (s *accountServer) RevokeAllSessionsHandler(
+	ctx context.Context, _ *RevokeAllSessionsInput) (*RevokeAllSessionsOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	if err := s.uc.RevokeAllSessions(ctx, user); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &RevokeAllSessionsOutput{}, nil
+}
+
+func (s *accountServer) LogoutHandler(ctx context.Context, _ *LogoutInput) (*LogoutOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+	sessionID, ok := middleware.GetSessionIDFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	if err := s.uc.RevokeSession(ctx, user, sessionID); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &LogoutOutput{}, nil
+}
+
+func (s *accountServer) EnrollTOTPHandler(ctx context.Context, _ *EnrollTOTPInput) (*EnrollTOTPOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	enrollment, err := s.uc.StartTOTPEnrollment(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &EnrollTOTPOutput{
+		Body: struct {
+			Data TOTPEnrollmentData
+		}{
+			Data: TOTPEnrollmentData{
+				Secret:          enrollment.Secret,
+				ProvisioningURI: enrollment.ProvisioningURI,
+			},
+		},
+	}, nil
+}
+
+func (s *accountServer) ConfirmTOTPHandler(ctx context.Context, input *ConfirmTOTPInput) (*ConfirmTOTPOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	recoveryCodes, err := s.uc.ConfirmTOTPEnrollment(ctx, user, input.Body.Code)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &ConfirmTOTPOutput{
+		Body: struct {
+			Data RecoveryCodesData
+		}{
+			Data: RecoveryCodesData{RecoveryCodes: recoveryCodes},
+		},
+	}, nil
+}
+
+func (s *accountServer) PasskeyOptionsHandler(
+	ctx context.Context, _ *PasskeyOptionsInput) (*PasskeyOptionsOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	options, err := s.uc.BeginPasskeyRegistration(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &PasskeyOptionsOutput{
+		Body: struct {
+			Data webauthn.CreationOptions
+		}{
+			Data: *options,
+		},
+	}, nil
+}
+
+func (s *accountServer) RegisterPasskeyHandler(
+	ctx context.Context, input *RegisterPasskeyInput) (*RegisterPasskeyOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	response, err := input.Body.Credential.Decode()
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	credential, err := s.uc.FinishPasskeyRegistration(ctx, user, response, input.Body.Label)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &RegisterPasskeyOutput{
+		Body: struct {
+			Data PasskeyData
+		}{
+			Data: toPasskeyData(*credential),
+		},
+	}, nil
+}
+
+func (s *accountServer) ListPasskeysHandler(
+	ctx context.Context, _ *ListPasskeysInput) (*ListPasskeysOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	credentials, err := s.uc.ListPasskeys(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	data := make([]PasskeyData, 0, len(credentials))
+	for _, credential := range credentials {
+		data = append(data, toPasskeyData(credential))
+	}
+
+	return &ListPasskeysOutput{
+		Body: struct {
+			Data []PasskeyData
+		}{
+			Data: data,
+		},
+	}, nil
+}
+
+func (s *accountServer) DeletePasskeyHandler(
+	ctx context.Context, input *DeletePasskeyInput) (*DeletePasskeyOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	var passkeyID pgtype.UUID
+	if err := passkeyID.Scan(input.PasskeyID); err != nil {
+		return nil, huma.Error422UnprocessableEntity("Validation error", err)
+	}
+
+	if err := s.uc.DeletePasskey(ctx, user, passkeyID); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &DeletePasskeyOutput{}, nil
+}
+
+func (s *accountServer) ChangeEmailHandler(ctx context.Context, input *ChangeEmailInput) (*ChangeEmailOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	codes, err := s.uc.StartEmailChange(ctx, user, input.Body.Email)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &ChangeEmailOutput{
+		Body: struct {
+			Data EmailChangeData
+		}{
+			Data: EmailChangeData{NewEmail: codes.NewEmail, ExpiresAt: codes.ExpiresAt},
+		},
+	}, nil
+}
+
+func (s *accountServer) ConfirmEmailChangeHandler(
+	ctx context.Context, input *ConfirmEmailChangeInput) (*ConfirmEmailChangeOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	email, err := s.uc.ConfirmEmailChange(ctx, user, input.Body.CurrentCode, input.Body.NewCode)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &ConfirmEmailChangeOutput{
+		Body: struct {
+			Data EmailData
+		}{
+			Data: EmailData{Email: email},
+		},
+	}, nil
+}
+
+func (s *accountServer) DeleteAccountHandler(
+	ctx context.Context, _ *DeleteAccountInput) (*DeleteAccountOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	deletion, err := s.uc.DeleteAccount(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &DeleteAccountOutput{
+		Body: struct {
+			Data AccountDeletionData
+		}{
+			Data: AccountDeletionData{PurgeAfter: deletion.PurgeAfter},
+		},
+	}, nil
+}
+
+func (s *accountServer) RestoreAccountHandler(
+	ctx context.Context, input *RestoreAccountInput) (*RestoreAccountOutput, error) {
+	if err := s.uc.RestoreAccount(ctx, input.Body.Token); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &RestoreAccountOutput{}, nil
+}
+
+func (s *accountServer) RegisterAccountEndpoints(api huma.API) {
+	huma.Register(api, operations[ListIdentities], s.ListIdentitiesHandler)
+	huma.Register(api, operations[LinkGoogleIdentity], s.LinkGoogleIdentityHandler)
+	huma.Register(api, operations[LinkEmailIdentity], s.LinkEmailIdentityHandler)
+	huma.Register(api, operations[UnlinkIdentity], s.UnlinkIdentityHandler)
+	huma.Register(api, operations[ListSessions], s.ListSessionsHandler)
+	huma.Register(api, operations[RevokeSession], s.RevokeSessionHandler)
+	huma.Register(api, operations[RevokeAllSessions], s.RevokeAllSessionsHandler)
+	huma.Register(api, operations[Logout], s.LogoutHandler)
+	huma.Register(api, operations[EnrollTOTP], s.EnrollTOTPHandler)
+	huma.Register(api, operations[ConfirmTOTP], s.ConfirmTOTPHandler)
+	huma.Register(api, operations[PasskeyOptions], s.PasskeyOptionsHandler)
+	huma.Register(api, operations[RegisterPasskey], s.RegisterPasskeyHandler)
+	huma.Register(api, operations[ListPasskeys], s.ListPasskeysHandler)
+	huma.Register(api, operations[DeletePasskey], s.DeletePasskeyHandler)
+	huma.Register(api, operations[ChangeEmail], s.ChangeEmailHandler)
+	huma.Register(api, operations[ConfirmEmailChange], s.ConfirmEmailChangeHandler)
+	huma.Register(api, operations[DeleteAccount], s.DeleteAccountHandler)
+	huma.Register(api, operations[RestoreAccount], s.RestoreAccountHandler)
+}
+
+func toIdentityData(identity db.AuthIdentity) IdentityData {
+	return IdentityData{
+		ID:        identity.ID.String(),
+		Provider:  string(identity.Provider),
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt.Time,
+	}
+}
+
+func newLinkIdentityOutput(identity *db.AuthIdentity) *LinkIdentityOutput {
+	return &LinkIdentityOutput{
+		Body: struct {
+			Data IdentityData
+		}{
+			Data: toIdentityData(*identity),
+		},
+	}
+}
+
+func toSessionData(session db.Session, currentID pgtype.UUID) SessionData {
+	return SessionData{
+		ID:          session.ID.String(),
+		DeviceLabel: session.DeviceLabel.String,
+		IPAddress:   session.IpAddress.String,
+		UserAgent:   session.UserAgent.String,
+		CreatedAt:   session.CreatedAt.Time,
+		LastSeenAt:  session.LastSeenAt.Time,
+		Current:     currentID.Valid && session.ID == currentID,
+	}
+}
+
+func toPasskeyData(credential db.WebauthnCredential) PasskeyData {
+	data := PasskeyData{
+		ID:        credential.ID.String(),
+		Label:     credential.Label.String,
+		BackedUp:  credential.BackedUp,
+		CreatedAt: credential.CreatedAt.Time,
+	}
+	if credential.LastUsedAt.Valid {
+		data.LastUsedAt = &credential.LastUsedAt.Time
+	}
+	return data
+}
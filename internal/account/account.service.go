@@ -0,0 +1,457 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	"github.com/abdurrahimagca/qq-back/internal/auth"
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	mail "github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	oauthport "github.com/abdurrahimagca/qq-back/internal/platform/oauth"
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Usecase interface {
+	ListIdentities(ctx context.Context, user *db.User) ([]db.AuthIdentity, error)
+	LinkGoogleIdentity(ctx context.Context, user *db.User, idToken string) (*db.AuthIdentity, error)
+	LinkEmailIdentity(ctx context.Context, user *db.User) (*db.AuthIdentity, error)
+	UnlinkIdentity(ctx context.Context, user *db.User, identityID pgtype.UUID) error
+	ListSessions(ctx context.Context, user *db.User) ([]db.Session, error)
+	RevokeSession(ctx context.Context, user *db.User, sessionID pgtype.UUID) error
+	RevokeAllSessions(ctx context.Context, user *db.User) error
+	StartTOTPEnrollment(ctx context.Context, user *db.User) (*auth.TOTPEnrollment, error)
+	ConfirmTOTPEnrollment(ctx context.Context, user *db.User, code string) ([]string, error)
+	BeginPasskeyRegistration(ctx context.Context, user *db.User) (*webauthn.CreationOptions, error)
+	FinishPasskeyRegistration(
+		ctx context.Context, user *db.User, response webauthnport.RegistrationResponse, label string,
+	) (*db.WebauthnCredential, error)
+	ListPasskeys(ctx context.Context, user *db.User) ([]db.WebauthnCredential, error)
+	DeletePasskey(ctx context.Context, user *db.User, passkeyID pgtype.UUID) error
+	StartEmailChange(ctx context.Context, user *db.User, newEmail string) (*auth.EmailChangeCodes, error)
+	ConfirmEmailChange(ctx context.Context, user *db.User, currentCode string, newCode string) (string, error)
+	DeleteAccount(ctx context.Context, user *db.User) (*auth.DeletionRequest, error)
+	RestoreAccount(ctx context.Context, restoreToken string) error
+	PurgeDueAccounts(ctx context.Context) (int, error)
+}
+
+// purgeBatchSize is how many due accounts are listed at a time by the purge job.
+const purgeBatchSize = 100
+
+type accountUsecase struct {
+	authService    auth.Service
+	dbpool         *pgxpool.Pool
+	googleVerifier oauthport.Verifier
+	passkeyService webauthn.Service
+	mailer         mail.Service
+	uploader       fileupload.Uploader
+	emailPolicy    emailpolicy.Policy
+	conf           environment.AccountEnvironment
+}
+
+func NewUsecase(
+	authService auth.Service,
+	pool *pgxpool.Pool,
+	googleVerifier oauthport.Verifier,
+	passkeyService webauthn.Service,
+	mailer mail.Service,
+	uploader fileupload.Uploader,
+	emailPolicy emailpolicy.Policy,
+	conf environment.AccountEnvironment,
+) Usecase {
+	return &accountUsecase{
+		authService:    authService,
+		dbpool:         pool,
+		googleVerifier: googleVerifier,
+		passkeyService: passkeyService,
+		mailer:         mailer,
+		uploader:       uploader,
+		emailPolicy:    emailPolicy,
+		conf:           conf,
+	}
+}
+
+func (uc *accountUsecase) ListIdentities(ctx context.Context, user *db.User) ([]db.AuthIdentity, error) {
+	return uc.authService.ListIdentities(ctx, user.AuthID)
+}
+
+func (uc *accountUsecase) LinkGoogleIdentity(
+	ctx context.Context, user *db.User, idToken string,
+) (*db.AuthIdentity, error) {
+	identity, err := uc.googleVerifier.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return uc.authService.LinkIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     user.AuthID,
+		Provider:   db.AuthProviderGoogleOauth,
+		ProviderID: identity.Subject,
+		Email:      identity.Email,
+	})
+}
+
+// LinkEmailIdentity enables code login for the account email. The address was
+// verified when the account was created, either by a code or by the identity
+// provider, so no further proof is asked for here.
+func (uc *accountUsecase) LinkEmailIdentity(ctx context.Context, user *db.User) (*db.AuthIdentity, error) {
+	authRow, err := uc.authService.GetAuthByID(ctx, user.AuthID)
+	if err != nil {
+		return nil, err
+	}
+
+	return uc.authService.LinkIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     user.AuthID,
+		Provider:   db.AuthProviderEmailOtp,
+		ProviderID: authRow.Email,
+		Email:      authRow.Email,
+	})
+}
+
+func (uc *accountUsecase) UnlinkIdentity(ctx context.Context, user *db.User, identityID pgtype.UUID) error {
+	tx, err := uc.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	txAuthService := uc.authService.WithTx(tx)
+
+	identities, err := txAuthService.ListIdentities(ctx, user.AuthID)
+	if err != nil {
+		return err
+	}
+
+	if err = txAuthService.UnlinkIdentity(ctx, user.AuthID, identityID); err != nil {
+		return err
+	}
+
+	// Codes already sent must not outlive the email login they were issued for.
+	for _, identity := range identities {
+		if identity.ID == identityID && identity.Provider == db.AuthProviderEmailOtp {
+			if err = txAuthService.KillOrphanedOTPsByUserID(ctx, user.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if commitErr := tx.Commit(ctx); commitErr != nil {
+		return commitErr
+	}
+	tx = nil
+
+	return nil
+}
+
+func (uc *accountUsecase) ListSessions(ctx context.Context, user *db.User) ([]db.Session, error) {
+	return uc.authService.ListSessions(ctx, user.ID)
+}
+
+func (uc *accountUsecase) RevokeSession(ctx context.Context, user *db.User, sessionID pgtype.UUID) error {
+	return uc.inTx(ctx, func(txAuthService auth.Service) error {
+		return txAuthService.RevokeSession(ctx, user.ID, sessionID)
+	})
+}
+
+func (uc *accountUsecase) RevokeAllSessions(ctx context.Context, user *db.User) error {
+	return uc.inTx(ctx, func(txAuthService auth.Service) error {
+		return txAuthService.RevokeAllSessions(ctx, user.ID)
+	})
+}
+
+func (uc *accountUsecase) StartTOTPEnrollment(ctx context.Context, user *db.User) (*auth.TOTPEnrollment, error) {
+	return uc.authService.StartTOTPEnrollment(ctx, user.AuthID)
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication and stores the
+// recovery codes in one transaction so a failure cannot leave the account
+// protected by an authenticator with no way to recover from losing it.
+func (uc *accountUsecase) ConfirmTOTPEnrollment(ctx context.Context, user *db.User, code string) ([]string, error) {
+	var recoveryCodes []string
+	err := uc.inTx(ctx, func(txAuthService auth.Service) error {
+		var confirmErr error
+		recoveryCodes, confirmErr = txAuthService.ConfirmTOTPEnrollment(ctx, user.AuthID, code)
+		return confirmErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// BeginPasskeyRegistration names the passkey after the account email, which is
+// what authenticators show in their account picker.
+func (uc *accountUsecase) BeginPasskeyRegistration(
+	ctx context.Context, user *db.User,
+) (*webauthn.CreationOptions, error) {
+	authRow, err := uc.authService.GetAuthByID(ctx, user.AuthID)
+	if err != nil {
+		return nil, err
+	}
+
+	displayName := user.Username
+	if user.DisplayName.Valid && user.DisplayName.String != "" {
+		displayName = user.DisplayName.String
+	}
+	return uc.passkeyService.BeginRegistration(ctx, user.AuthID, authRow.Email, displayName)
+}
+
+func (uc *accountUsecase) FinishPasskeyRegistration(
+	ctx context.Context, user *db.User, response webauthnport.RegistrationResponse, label string,
+) (*db.WebauthnCredential, error) {
+	return uc.passkeyService.FinishRegistration(ctx, user.AuthID, response, label)
+}
+
+func (uc *accountUsecase) ListPasskeys(ctx context.Context, user *db.User) ([]db.WebauthnCredential, error) {
+	return uc.passkeyService.ListCredentials(ctx, user.AuthID)
+}
+
+func (uc *accountUsecase) DeletePasskey(ctx context.Context, user *db.User, passkeyID pgtype.UUID) error {
+	return uc.passkeyService.DeleteCredential(ctx, user.AuthID, passkeyID)
+}
+
+// StartEmailChange stores the pending change and then sends one code to the
+// current address and one to the new address. The new address is held to the
+// same email policy as sign-up and kept as entered; only the lookup for
+// another account uses its normalized form. As with login codes, a failed
+// send is reported after the change was stored; starting again after the
+// cooldown issues fresh codes.
+func (uc *accountUsecase) StartEmailChange(
+	ctx context.Context, user *db.User, newEmail string,
+) (*auth.EmailChangeCodes, error) {
+	newEmail = strings.TrimSpace(newEmail)
+	if err := uc.emailPolicy.Allow(ctx, newEmail); err != nil {
+		return nil, err
+	}
+
+	var codes *auth.EmailChangeCodes
+	err := uc.inTx(ctx, func(txAuthService auth.Service) error {
+		var startErr error
+		codes, startErr = txAuthService.StartEmailChange(ctx, user.AuthID, newEmail)
+		return startErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := uc.mailer.GetTemplate(ctx, "email_change")
+	if err != nil {
+		return nil, err
+	}
+	template = strings.ReplaceAll(template, "{{.NewEmail}}", html.EscapeString(codes.NewEmail))
+	recipients := [][2]string{{codes.CurrentEmail, codes.CurrentCode}, {codes.NewEmail, codes.NewCode}}
+	for _, recipient := range recipients {
+		err = uc.mailer.SendEmail(ctx, mail.SendParams{
+			To:      recipient[0],
+			From:    mail.DefaultFrom,
+			Subject: "Confirm your email change",
+			Body:    strings.Replace(template, "{{.OTP}}", recipient[1], 1),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// ConfirmEmailChange checks both codes, then swaps the email and revokes every
+// session in one transaction so a stolen session cannot outlive the change.
+// Both addresses are told about the change once it is committed; the returned
+// string is the new email.
+func (uc *accountUsecase) ConfirmEmailChange(
+	ctx context.Context, user *db.User, currentCode string, newCode string,
+) (string, error) {
+	change, err := uc.authService.VerifyEmailChange(ctx, user.AuthID, currentCode, newCode)
+	if err != nil {
+		return "", err
+	}
+
+	var oldEmail string
+	err = uc.inTx(ctx, func(txAuthService auth.Service) error {
+		var applyErr error
+		oldEmail, applyErr = txAuthService.ApplyEmailChange(ctx, user.AuthID, *change)
+		if applyErr != nil {
+			return applyErr
+		}
+		return txAuthService.RevokeAllSessions(ctx, user.ID)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	template, err := uc.mailer.GetTemplate(ctx, "email_changed")
+	if err != nil {
+		return "", err
+	}
+	body := strings.NewReplacer(
+		"{{.OldEmail}}", html.EscapeString(oldEmail),
+		"{{.NewEmail}}", html.EscapeString(change.NewEmail),
+	).Replace(template)
+	for _, to := range []string{oldEmail, change.NewEmail} {
+		err = uc.mailer.SendEmail(ctx, mail.SendParams{
+			To:      to,
+			From:    mail.DefaultFrom,
+			Subject: "Your email address was changed",
+			Body:    body,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return change.NewEmail, nil
+}
+
+// DeleteAccount schedules the account to be purged once the grace period has
+// passed and revokes every session in the same transaction. The restore link is
+// mailed before commit, so the account is only blocked once the user holds a
+// way back in; if the mail cannot be sent nothing changes.
+func (uc *accountUsecase) DeleteAccount(ctx context.Context, user *db.User) (*auth.DeletionRequest, error) {
+	authRow, err := uc.authService.GetAuthByID(ctx, user.AuthID)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := url.Parse(uc.conf.RestoreURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account restore url: %w", err)
+	}
+	template, err := uc.mailer.GetTemplate(ctx, "account_deletion")
+	if err != nil {
+		return nil, err
+	}
+
+	var deletion *auth.DeletionRequest
+	err = uc.inTx(ctx, func(txAuthService auth.Service) error {
+		var deleteErr error
+		deletion, deleteErr = txAuthService.RequestDeletion(ctx, user.AuthID, uc.conf.DeletionGracePeriod)
+		if deleteErr != nil {
+			return deleteErr
+		}
+		if revokeErr := txAuthService.RevokeAllSessions(ctx, user.ID); revokeErr != nil {
+			return revokeErr
+		}
+
+		query := link.Query()
+		query.Set("token", deletion.RestoreToken)
+		link.RawQuery = query.Encode()
+		body := strings.NewReplacer(
+			"{{.Link}}", html.EscapeString(link.String()),
+			"{{.PurgeAfter}}", deletion.PurgeAfter.UTC().Format("2 January 2006 15:04 MST"),
+		).Replace(template)
+		return uc.mailer.SendEmail(ctx, mail.SendParams{
+			To:      authRow.Email,
+			From:    mail.DefaultFrom,
+			Subject: "Your account will be deleted",
+			Body:    body,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deletion, nil
+}
+
+// RestoreAccount cancels a scheduled deletion. Sessions revoked by the deletion
+// stay revoked; the user signs in again.
+func (uc *accountUsecase) RestoreAccount(ctx context.Context, restoreToken string) error {
+	_, err := uc.authService.RestoreDeletion(ctx, restoreToken)
+	return err
+}
+
+// PurgeDueAccounts deletes the accounts whose grace period has ended and
+// returns how many were deleted. Each account is purged in its own transaction
+// and its avatar and data export archives are removed before commit, so an
+// account whose files could not be deleted is kept and retried on the next
+// run. Its export rows go with the user row. Accounts restored after they were
+// listed are skipped.
+func (uc *accountUsecase) PurgeDueAccounts(ctx context.Context) (int, error) {
+	purged := 0
+	var errs []error
+	for {
+		due, err := uc.authService.ListDueDeletions(ctx, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		batchPurged := 0
+		for _, deletion := range due {
+			err = uc.inTx(ctx, func(txAuthService auth.Service) error {
+				if purgeErr := txAuthService.PurgeAccount(ctx, deletion.AuthID); purgeErr != nil {
+					return purgeErr
+				}
+				return uc.deleteAccountFiles(ctx, deletion)
+			})
+			switch {
+			case errors.Is(err, auth.ErrNotFound):
+			case err != nil:
+				errs = append(errs, fmt.Errorf("purging account %s: %w", deletion.AuthID.String(), err))
+			default:
+				batchPurged++
+			}
+		}
+
+		purged += batchPurged
+		// Failed accounts are listed again; stop rather than retry them in a loop.
+		if len(due) < purgeBatchSize || batchPurged == 0 {
+			return purged, errors.Join(errs...)
+		}
+	}
+}
+
+// deleteAccountFiles removes the avatar and the data export archives of an
+// account being purged.
+func (uc *accountUsecase) deleteAccountFiles(ctx context.Context, deletion db.ListDueAuthDeletionsRow) error {
+	var errs []error
+	if deletion.AvatarKey.Valid && deletion.AvatarKey.String != "" {
+		err := uc.uploader.DeleteRenditions(ctx, deletion.AvatarKey.String, imageprocess.AvatarRenditions)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, archiveKey := range deletion.ExportArchiveKeys {
+		if err := uc.uploader.DeleteFile(ctx, archiveKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (uc *accountUsecase) inTx(ctx context.Context, fn func(txAuthService auth.Service) error) error {
+	tx, err := uc.dbpool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	if err = fn(uc.authService.WithTx(tx)); err != nil {
+		return err
+	}
+
+	if commitErr := tx.Commit(ctx); commitErr != nil {
+		return commitErr
+	}
+	tx = nil
+
+	return nil
+}
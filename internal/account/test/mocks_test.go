@@ -0,0 +1,131 @@
+package account_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	"github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	"github.com/abdurrahimagca/qq-back/internal/platform/oauth"
+)
+
+type fakeOAuthVerifier struct {
+	mu        sync.Mutex
+	identity  *oauth.Identity
+	verifyErr error
+}
+
+func (f *fakeOAuthVerifier) VerifyIDToken(ctx context.Context, idToken string) (*oauth.Identity, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.verifyErr != nil {
+		return nil, f.verifyErr
+	}
+	if f.identity == nil {
+		return nil, oauth.ErrInvalidIDToken
+	}
+	identity := *f.identity
+	return &identity, nil
+}
+
+func (f *fakeOAuthVerifier) setIdentity(identity oauth.Identity) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.identity = &identity
+}
+
+// fakeMailer serves every template as just the code placeholder, so the body
+// of a code email is the code itself.
+type fakeMailer struct {
+	mu         sync.Mutex
+	sendErr    error
+	sentEmails []mailer.SendParams
+}
+
+func (f *fakeMailer) GetTemplate(ctx context.Context, templateName string) (string, error) {
+	return "{{.OTP}}", nil
+}
+
+func (f *fakeMailer) SendEmail(ctx context.Context, params mailer.SendParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sendErr != nil {
+		return f.sendErr
+	}
+	f.sentEmails = append(f.sentEmails, params)
+	return nil
+}
+
+func (f *fakeMailer) emails() []mailer.SendParams {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]mailer.SendParams(nil), f.sentEmails...)
+}
+
+// fakeUploader records deleted keys; deleteErr makes every delete fail.
+type fakeUploader struct {
+	mu          sync.Mutex
+	deleteErr   error
+	deletedKeys []string
+}
+
+func (f *fakeUploader) UploadFile(ctx context.Context, file io.Reader) (*string, error) {
+	key := "uploaded"
+	return &key, nil
+}
+
+func (f *fakeUploader) UploadRenditions(
+	ctx context.Context, file io.Reader, renditions []imageprocess.Rendition) (*string, error) {
+	prefix := "uploaded"
+	return &prefix, nil
+}
+
+func (f *fakeUploader) DeleteRenditions(
+	ctx context.Context, prefix string, renditions []imageprocess.Rendition) error {
+	if err := f.DeleteFile(ctx, prefix); err != nil {
+		return err
+	}
+	for _, rendition := range renditions {
+		if err := f.DeleteFile(ctx, fileupload.RenditionKey(prefix, rendition.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeUploader) PutFile(ctx context.Context, key string, file io.Reader, contentType string) error {
+	return nil
+}
+
+func (f *fakeUploader) GetFile(ctx context.Context, key string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeUploader) FileExists(ctx context.Context, key string) (bool, error) {
+	return true, nil
+}
+
+func (f *fakeUploader) GetSignedURL(ctx context.Context, key string, expires time.Duration) (*string, error) {
+	signed := "https://files.example/" + key
+	return &signed, nil
+}
+
+func (f *fakeUploader) DeleteFile(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	f.deletedKeys = append(f.deletedKeys, key)
+	return nil
+}
+
+func (f *fakeUploader) deleted() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.deletedKeys...)
+}
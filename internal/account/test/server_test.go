@@ -0,0 +1,662 @@
+package account_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/account"
+	"github.com/abdurrahimagca/qq-back/internal/auth"
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/middleware"
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAccountUsecase struct {
+	identities     []db.AuthIdentity
+	linked         *db.AuthIdentity
+	err            error
+	lastUser       *db.User
+	lastIDToken    string
+	lastIdentityID pgtype.UUID
+	sessions       []db.Session
+	lastSessionID  pgtype.UUID
+	revokedAll     bool
+	enrollment     *auth.TOTPEnrollment
+	recoveryCodes  []string
+	lastTOTPCode   string
+	creation       *webauthn.CreationOptions
+	passkey        *db.WebauthnCredential
+	passkeys       []db.WebauthnCredential
+	lastResponse   webauthnport.RegistrationResponse
+	lastLabel      string
+	lastPasskeyID  pgtype.UUID
+	emailChange    *auth.EmailChangeCodes
+	lastNewEmail   string
+	lastEmailCodes [2]string
+	deletion       *auth.DeletionRequest
+	lastToken      string
+	purged         int
+}
+
+func (f *fakeAccountUsecase) ListIdentities(ctx context.Context, user *db.User) ([]db.AuthIdentity, error) {
+	f.lastUser = user
+	return f.identities, f.err
+}
+
+func (f *fakeAccountUsecase) LinkGoogleIdentity(
+	ctx context.Context, user *db.User, idToken string,
+) (*db.AuthIdentity, error) {
+	f.lastUser = user
+	f.lastIDToken = idToken
+	return f.linked, f.err
+}
+
+func (f *fakeAccountUsecase) LinkEmailIdentity(ctx context.Context, user *db.User) (*db.AuthIdentity, error) {
+	f.lastUser = user
+	return f.linked, f.err
+}
+
+func (f *fakeAccountUsecase) UnlinkIdentity(ctx context.Context, user *db.User, identityID pgtype.UUID) error {
+	f.lastUser = user
+	f.lastIdentityID = identityID
+	return f.err
+}
+
+func (f *fakeAccountUsecase) ListSessions(ctx context.Context, user *db.User) ([]db.Session, error) {
+	f.lastUser = user
+	return f.sessions, f.err
+}
+
+func (f *fakeAccountUsecase) RevokeSession(ctx context.Context, user *db.User, sessionID pgtype.UUID) error {
+	f.lastUser = user
+	f.lastSessionID = sessionID
+	return f.err
+}
+
+func (f *fakeAccountUsecase) RevokeAllSessions(ctx context.Context, user *db.User) error {
+	f.lastUser = user
+	f.revokedAll = f.err == nil
+	return f.err
+}
+
+func (f *fakeAccountUsecase) StartTOTPEnrollment(ctx context.Context, user *db.User) (*auth.TOTPEnrollment, error) {
+	f.lastUser = user
+	return f.enrollment, f.err
+}
+
+func (f *fakeAccountUsecase) ConfirmTOTPEnrollment(ctx context.Context, user *db.User, code string) ([]string, error) {
+	f.lastUser = user
+	f.lastTOTPCode = code
+	return f.recoveryCodes, f.err
+}
+
+func (f *fakeAccountUsecase) BeginPasskeyRegistration(
+	ctx context.Context, user *db.User,
+) (*webauthn.CreationOptions, error) {
+	f.lastUser = user
+	return f.creation, f.err
+}
+
+func (f *fakeAccountUsecase) FinishPasskeyRegistration(
+	ctx context.Context, user *db.User, response webauthnport.RegistrationResponse, label string,
+) (*db.WebauthnCredential, error) {
+	f.lastUser = user
+	f.lastResponse = response
+	f.lastLabel = label
+	return f.passkey, f.err
+}
+
+func (f *fakeAccountUsecase) ListPasskeys(ctx context.Context, user *db.User) ([]db.WebauthnCredential, error) {
+	f.lastUser = user
+	return f.passkeys, f.err
+}
+
+func (f *fakeAccountUsecase) DeletePasskey(ctx context.Context, user *db.User, passkeyID pgtype.UUID) error {
+	f.lastUser = user
+	f.lastPasskeyID = passkeyID
+	return f.err
+}
+
+func (f *fakeAccountUsecase) StartEmailChange(
+	ctx context.Context, user *db.User, newEmail string,
+) (*auth.EmailChangeCodes, error) {
+	f.lastUser = user
+	f.lastNewEmail = newEmail
+	return f.emailChange, f.err
+}
+
+func (f *fakeAccountUsecase) ConfirmEmailChange(
+	ctx context.Context, user *db.User, currentCode string, newCode string,
+) (string, error) {
+	f.lastUser = user
+	f.lastEmailCodes = [2]string{currentCode, newCode}
+	return f.lastNewEmail, f.err
+}
+
+func (f *fakeAccountUsecase) DeleteAccount(ctx context.Context, user *db.User) (*auth.DeletionRequest, error) {
+	f.lastUser = user
+	return f.deletion, f.err
+}
+
+func (f *fakeAccountUsecase) RestoreAccount(ctx context.Context, restoreToken string) error {
+	f.lastToken = restoreToken
+	return f.err
+}
+
+func (f *fakeAccountUsecase) PurgeDueAccounts(ctx context.Context) (int, error) {
+	return f.purged, f.err
+}
+
+func newTestUUID(t *testing.T, value string) pgtype.UUID {
+	t.Helper()
+	var id pgtype.UUID
+	require.NoError(t, id.Scan(value))
+	return id
+}
+
+func authenticatedContext(t *testing.T) (context.Context, *db.User) {
+	t.Helper()
+	user := &db.User{
+		ID:     newTestUUID(t, "11111111-1111-1111-1111-111111111111"),
+		AuthID: newTestUUID(t, "22222222-2222-2222-2222-222222222222"),
+	}
+	return middleware.WithUser(context.Background(), user), user
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, status, statusErr.GetStatus())
+}
+
+func TestServer_ListIdentitiesHandler_Success(t *testing.T) {
+	ctx, user := authenticatedContext(t)
+	uc := &fakeAccountUsecase{identities: []db.AuthIdentity{
+		{ID: newTestUUID(t, "33333333-3333-3333-3333-333333333333"), Provider: db.AuthProviderEmailOtp, Email: "a@b.c"},
+	}}
+	server := account.NewServer(uc)
+
+	resp, err := server.ListIdentitiesHandler(ctx, &account.ListIdentitiesInput{})
+	require.NoError(t, err)
+	require.Len(t, resp.Body.Data, 1)
+	assert.Equal(t, "email_otp", resp.Body.Data[0].Provider)
+	assert.Equal(t, "33333333-3333-3333-3333-333333333333", resp.Body.Data[0].ID)
+	assert.Equal(t, user, uc.lastUser)
+}
+
+func TestServer_ListIdentitiesHandler_Unauthenticated(t *testing.T) {
+	server := account.NewServer(&fakeAccountUsecase{})
+
+	resp, err := server.ListIdentitiesHandler(context.Background(), &account.ListIdentitiesInput{})
+	require.Nil(t, resp)
+	requireStatus(t, err, http.StatusUnauthorized)
+}
+
+func TestServer_LinkGoogleIdentityHandler_Success(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+	uc := &fakeAccountUsecase{linked: &db.AuthIdentity{Provider: db.AuthProviderGoogleOauth, Email: "g@example.com"}}
+	server := account.NewServer(uc)
+
+	input := &account.LinkGoogleIdentityInput{}
+	input.Body.IDToken = "id-token"
+
+	resp, err := server.LinkGoogleIdentityHandler(ctx, input)
+	require.NoError(t, err)
+	assert.Equal(t, "google_oauth", resp.Body.Data.Provider)
+	assert.Equal(t, "id-token", uc.lastIDToken)
+}
+
+func TestServer_LinkGoogleIdentityHandler_AlreadyLinked(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+	server := account.NewServer(&fakeAccountUsecase{err: auth.ErrIdentityLinked})
+
+	input := &account.LinkGoogleIdentityInput{}
+	input.Body.IDToken = "id-token"
+
+	resp, err := server.LinkGoogleIdentityHandler(ctx, input)
+	require.Nil(t, resp)
+	requireStatus(t, err, http.StatusConflict)
+}
+
+func TestServer_LinkEmailIdentityHandler_Success(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+	uc := &fakeAccountUsecase{linked: &db.AuthIdentity{Provider: db.AuthProviderEmailOtp, Email: "a@b.c"}}
+	server := account.NewServer(uc)
+
+	resp, err := server.LinkEmailIdentityHandler(ctx, &account.LinkEmailIdentityInput{})
+	require.NoError(t, err)
+	assert.Equal(t, "email_otp", resp.Body.Data.Provider)
+}
+
+func TestServer_UnlinkIdentityHandler_Success(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+	uc := &fakeAccountUsecase{}
+	server := account.NewServer(uc)
+
+	resp, err := server.UnlinkIdentityHandler(ctx, &account.UnlinkIdentityInput{
+		IdentityID: "44444444-4444-4444-4444-444444444444",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, newTestUUID(t, "44444444-4444-4444-4444-444444444444"), uc.lastIdentityID)
+}
+
+func TestServer_UnlinkIdentityHandler_LastIdentity(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+	server := account.NewServer(&fakeAccountUsecase{err: auth.ErrLastIdentity})
+
+	resp, err := server.UnlinkIdentityHandler(ctx, &account.UnlinkIdentityInput{
+		IdentityID: "44444444-4444-4444-4444-444444444444",
+	})
+	require.Nil(t, resp)
+	requireStatus(t, err, http.StatusBadRequest)
+}
+
+func TestServer_UnlinkIdentityHandler_InvalidID(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+	server := account.NewServer(&fakeAccountUsecase{})
+
+	resp, err := server.UnlinkIdentityHandler(ctx, &account.UnlinkIdentityInput{IdentityID: "not-a-uuid"})
+	require.Nil(t, resp)
+	requireStatus(t, err, http.StatusUnprocessableEntity)
+}
+
+func TestServer_ListSessionsHandler_MarksCurrent(t *testing.T) {
+	ctx, user := authenticatedContext(t)
+	current := newTestUUID(t, "55555555-5555-5555-5555-555555555555")
+	other := newTestUUID(t, "66666666-6666-6666-6666-666666666666")
+	ctx = middleware.WithSessionID(ctx, current)
+	uc := &fakeAccountUsecase{sessions: []db.Session{
+		{ID: other, UserAgent: pgtype.Text{String: "curl/8", Valid: true}},
+		{ID: current, DeviceLabel: pgtype.Text{String: "Phone", Valid: true}},
+	}}
+	server := account.NewServer(uc)
+
+	resp, err := server.ListSessionsHandler(ctx, &account.ListSessionsInput{})
+	require.NoError(t, err)
+	require.Len(t, resp.Body.Data, 2)
+	assert.False(t, resp.Body.Data[0].Current)
+	assert.Equal(t, "curl/8", resp.Body.Data[0].UserAgent)
+	assert.True(t, resp.Body.Data[1].Current)
+	assert.Equal(t, "Phone", resp.Body.Data[1].DeviceLabel)
+	assert.Equal(t, user, uc.lastUser)
+}
+
+func TestServer_RevokeSessionHandler(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+
+	t.Run("Success", func(t *testing.T) {
+		uc := &fakeAccountUsecase{}
+		resp, err := account.NewServer(uc).RevokeSessionHandler(ctx, &account.RevokeSessionInput{
+			SessionID: "66666666-6666-6666-6666-666666666666",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, "66666666-6666-6666-6666-666666666666", uc.lastSessionID.String())
+	})
+
+	t.Run("Unknown session", func(t *testing.T) {
+		resp, err := account.NewServer(&fakeAccountUsecase{err: auth.ErrNotFound}).
+			RevokeSessionHandler(ctx, &account.RevokeSessionInput{SessionID: "66666666-6666-6666-6666-666666666666"})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusNotFound)
+	})
+
+	t.Run("Invalid id", func(t *testing.T) {
+		resp, err := account.NewServer(&fakeAccountUsecase{}).
+			RevokeSessionHandler(ctx, &account.RevokeSessionInput{SessionID: "nope"})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestServer_RevokeAllSessionsHandler(t *testing.T) {
+	ctx, user := authenticatedContext(t)
+	uc := &fakeAccountUsecase{}
+
+	resp, err := account.NewServer(uc).RevokeAllSessionsHandler(ctx, &account.RevokeAllSessionsInput{})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.True(t, uc.revokedAll)
+	assert.Equal(t, user, uc.lastUser)
+}
+
+func TestServer_LogoutHandler(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+
+	t.Run("Revokes current session", func(t *testing.T) {
+		current := newTestUUID(t, "55555555-5555-5555-5555-555555555555")
+		uc := &fakeAccountUsecase{}
+		resp, err := account.NewServer(uc).LogoutHandler(middleware.WithSessionID(ctx, current), &account.LogoutInput{})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, current, uc.lastSessionID)
+	})
+
+	t.Run("No session in context", func(t *testing.T) {
+		resp, err := account.NewServer(&fakeAccountUsecase{}).LogoutHandler(ctx, &account.LogoutInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnauthorized)
+	})
+}
+
+func TestServer_EnrollTOTPHandler(t *testing.T) {
+	ctx, user := authenticatedContext(t)
+
+	t.Run("Success", func(t *testing.T) {
+		uc := &fakeAccountUsecase{enrollment: &auth.TOTPEnrollment{
+			Secret:          "JBSWY3DPEHPK3PXP",
+			ProvisioningURI: "otpauth://totp/QQ:a@b.c?secret=JBSWY3DPEHPK3PXP",
+		}}
+		resp, err := account.NewServer(uc).EnrollTOTPHandler(ctx, &account.EnrollTOTPInput{})
+		require.NoError(t, err)
+		assert.Equal(t, "JBSWY3DPEHPK3PXP", resp.Body.Data.Secret)
+		assert.Equal(t, "otpauth://totp/QQ:a@b.c?secret=JBSWY3DPEHPK3PXP", resp.Body.Data.ProvisioningURI)
+		assert.Equal(t, user, uc.lastUser)
+	})
+
+	t.Run("Already enabled", func(t *testing.T) {
+		resp, err := account.NewServer(&fakeAccountUsecase{err: auth.ErrTOTPAlreadyEnabled}).
+			EnrollTOTPHandler(ctx, &account.EnrollTOTPInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusConflict)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		resp, err := account.NewServer(&fakeAccountUsecase{}).
+			EnrollTOTPHandler(context.Background(), &account.EnrollTOTPInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnauthorized)
+	})
+}
+
+func TestServer_ConfirmTOTPHandler(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+
+	t.Run("Success", func(t *testing.T) {
+		uc := &fakeAccountUsecase{recoveryCodes: []string{"aaaaa-bbbbb", "ccccc-ddddd"}}
+		input := &account.ConfirmTOTPInput{}
+		input.Body.Code = "123456"
+
+		resp, err := account.NewServer(uc).ConfirmTOTPHandler(ctx, input)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"aaaaa-bbbbb", "ccccc-ddddd"}, resp.Body.Data.RecoveryCodes)
+		assert.Equal(t, "123456", uc.lastTOTPCode)
+	})
+
+	t.Run("Wrong code", func(t *testing.T) {
+		input := &account.ConfirmTOTPInput{}
+		input.Body.Code = "000000"
+
+		resp, err := account.NewServer(&fakeAccountUsecase{err: auth.ErrTOTPCodeMismatch}).ConfirmTOTPHandler(ctx, input)
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("No pending setup", func(t *testing.T) {
+		input := &account.ConfirmTOTPInput{}
+		input.Body.Code = "000000"
+
+		resp, err := account.NewServer(&fakeAccountUsecase{err: auth.ErrTOTPNotEnabled}).ConfirmTOTPHandler(ctx, input)
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusNotFound)
+	})
+}
+
+func TestServer_PasskeyOptionsHandler(t *testing.T) {
+	ctx, user := authenticatedContext(t)
+
+	uc := &fakeAccountUsecase{creation: &webauthn.CreationOptions{
+		Challenge: "challenge",
+		RP:        webauthn.RelyingPartyEntity{ID: "qq.example", Name: "QQ"},
+	}}
+	resp, err := account.NewServer(uc).PasskeyOptionsHandler(ctx, &account.PasskeyOptionsInput{})
+	require.NoError(t, err)
+	assert.Equal(t, "challenge", resp.Body.Data.Challenge)
+	assert.Equal(t, "qq.example", resp.Body.Data.RP.ID)
+	assert.Equal(t, user, uc.lastUser)
+
+	resp, err = account.NewServer(&fakeAccountUsecase{}).
+		PasskeyOptionsHandler(context.Background(), &account.PasskeyOptionsInput{})
+	require.Nil(t, resp)
+	requireStatus(t, err, http.StatusUnauthorized)
+}
+
+func newRegisterPasskeyInput() *account.RegisterPasskeyInput {
+	input := &account.RegisterPasskeyInput{}
+	input.Body.Credential.ID = "Y3JlZA"
+	input.Body.Credential.Type = "public-key"
+	input.Body.Credential.Response.ClientDataJSON = "e30"
+	input.Body.Credential.Response.AttestationObject = "b2Jq"
+	input.Body.Label = "YubiKey"
+	return input
+}
+
+func TestServer_RegisterPasskeyHandler(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+	createdAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Success", func(t *testing.T) {
+		uc := &fakeAccountUsecase{passkey: &db.WebauthnCredential{
+			ID:        newTestUUID(t, "44444444-4444-4444-4444-444444444444"),
+			Label:     pgtype.Text{String: "YubiKey", Valid: true},
+			BackedUp:  true,
+			CreatedAt: pgtype.Timestamp{Time: createdAt, Valid: true},
+		}}
+
+		resp, err := account.NewServer(uc).RegisterPasskeyHandler(ctx, newRegisterPasskeyInput())
+		require.NoError(t, err)
+		assert.Equal(t, account.PasskeyData{
+			ID:        "44444444-4444-4444-4444-444444444444",
+			Label:     "YubiKey",
+			BackedUp:  true,
+			CreatedAt: createdAt,
+		}, resp.Body.Data)
+		assert.Equal(t, []byte("{}"), uc.lastResponse.ClientDataJSON)
+		assert.Equal(t, []byte("obj"), uc.lastResponse.AttestationObject)
+		assert.Equal(t, "YubiKey", uc.lastLabel)
+	})
+
+	t.Run("Malformed base64url", func(t *testing.T) {
+		input := newRegisterPasskeyInput()
+		input.Body.Credential.Response.AttestationObject = "not base64!"
+
+		resp, err := account.NewServer(&fakeAccountUsecase{}).RegisterPasskeyHandler(ctx, input)
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("Already registered", func(t *testing.T) {
+		resp, err := account.NewServer(&fakeAccountUsecase{err: webauthn.ErrCredentialExists}).
+			RegisterPasskeyHandler(ctx, newRegisterPasskeyInput())
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusConflict)
+	})
+
+	t.Run("Attestation rejected", func(t *testing.T) {
+		resp, err := account.NewServer(&fakeAccountUsecase{err: webauthnport.ErrVerificationFailed}).
+			RegisterPasskeyHandler(ctx, newRegisterPasskeyInput())
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnauthorized)
+	})
+}
+
+func TestServer_ListPasskeysHandler(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+	lastUsed := time.Date(2026, 10, 2, 8, 0, 0, 0, time.UTC)
+	uc := &fakeAccountUsecase{passkeys: []db.WebauthnCredential{
+		{
+			ID:         newTestUUID(t, "44444444-4444-4444-4444-444444444444"),
+			LastUsedAt: pgtype.Timestamp{Time: lastUsed, Valid: true},
+		},
+		{ID: newTestUUID(t, "55555555-5555-5555-5555-555555555555")},
+	}}
+
+	resp, err := account.NewServer(uc).ListPasskeysHandler(ctx, &account.ListPasskeysInput{})
+	require.NoError(t, err)
+	require.Len(t, resp.Body.Data, 2)
+	require.NotNil(t, resp.Body.Data[0].LastUsedAt)
+	assert.Equal(t, lastUsed, *resp.Body.Data[0].LastUsedAt)
+	assert.Nil(t, resp.Body.Data[1].LastUsedAt)
+}
+
+func TestServer_DeletePasskeyHandler(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+
+	t.Run("Success", func(t *testing.T) {
+		uc := &fakeAccountUsecase{}
+		input := &account.DeletePasskeyInput{PasskeyID: "44444444-4444-4444-4444-444444444444"}
+
+		_, err := account.NewServer(uc).DeletePasskeyHandler(ctx, input)
+		require.NoError(t, err)
+		assert.Equal(t, newTestUUID(t, "44444444-4444-4444-4444-444444444444"), uc.lastPasskeyID)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		input := &account.DeletePasskeyInput{PasskeyID: "44444444-4444-4444-4444-444444444444"}
+
+		_, err := account.NewServer(&fakeAccountUsecase{err: webauthn.ErrNotFound}).DeletePasskeyHandler(ctx, input)
+		requireStatus(t, err, http.StatusNotFound)
+	})
+
+	t.Run("Invalid ID", func(t *testing.T) {
+		input := &account.DeletePasskeyInput{PasskeyID: "not-a-uuid"}
+
+		_, err := account.NewServer(&fakeAccountUsecase{}).DeletePasskeyHandler(ctx, input)
+		requireStatus(t, err, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestServer_ChangeEmailHandler(t *testing.T) {
+	ctx, user := authenticatedContext(t)
+
+	t.Run("Success", func(t *testing.T) {
+		expiresAt := time.Now().Add(3 * time.Minute)
+		uc := &fakeAccountUsecase{emailChange: &auth.EmailChangeCodes{
+			CurrentEmail: "old@example.com",
+			CurrentCode:  "AAAAAA",
+			NewEmail:     "new@example.com",
+			NewCode:      "BBBBBB",
+			ExpiresAt:    expiresAt,
+		}}
+		input := &account.ChangeEmailInput{}
+		input.Body.Email = "new@example.com"
+
+		resp, err := account.NewServer(uc).ChangeEmailHandler(ctx, input)
+		require.NoError(t, err)
+		assert.Equal(t, "new@example.com", resp.Body.Data.NewEmail)
+		assert.Equal(t, expiresAt, resp.Body.Data.ExpiresAt)
+		assert.Equal(t, "new@example.com", uc.lastNewEmail)
+		assert.Equal(t, user, uc.lastUser)
+	})
+
+	t.Run("Email in use", func(t *testing.T) {
+		input := &account.ChangeEmailInput{}
+		input.Body.Email = "taken@example.com"
+
+		resp, err := account.NewServer(&fakeAccountUsecase{err: auth.ErrEmailInUse}).ChangeEmailHandler(ctx, input)
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusConflict)
+	})
+
+	t.Run("Too soon", func(t *testing.T) {
+		input := &account.ChangeEmailInput{}
+		input.Body.Email = "new@example.com"
+		uc := &fakeAccountUsecase{
+			err: &qqerrors.RetryAfterError{Err: auth.ErrEmailChangeTooSoon, RetryAfter: 10 * time.Second},
+		}
+
+		resp, err := account.NewServer(uc).ChangeEmailHandler(ctx, input)
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusTooManyRequests)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		resp, err := account.NewServer(&fakeAccountUsecase{}).ChangeEmailHandler(
+			context.Background(), &account.ChangeEmailInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnauthorized)
+	})
+}
+
+func TestServer_ConfirmEmailChangeHandler(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+
+	t.Run("Success", func(t *testing.T) {
+		uc := &fakeAccountUsecase{lastNewEmail: "new@example.com"}
+		input := &account.ConfirmEmailChangeInput{}
+		input.Body.CurrentCode = "AAAAAA"
+		input.Body.NewCode = "BBBBBB"
+
+		resp, err := account.NewServer(uc).ConfirmEmailChangeHandler(ctx, input)
+		require.NoError(t, err)
+		assert.Equal(t, "new@example.com", resp.Body.Data.Email)
+		assert.Equal(t, [2]string{"AAAAAA", "BBBBBB"}, uc.lastEmailCodes)
+	})
+
+	t.Run("Wrong codes", func(t *testing.T) {
+		uc := &fakeAccountUsecase{err: auth.ErrInvalidEmailChange}
+
+		resp, err := account.NewServer(uc).ConfirmEmailChangeHandler(ctx, &account.ConfirmEmailChangeInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("No pending change", func(t *testing.T) {
+		uc := &fakeAccountUsecase{err: auth.ErrEmailChangeNotFound}
+
+		resp, err := account.NewServer(uc).ConfirmEmailChangeHandler(ctx, &account.ConfirmEmailChangeInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusNotFound)
+	})
+}
+
+func TestServer_DeleteAccountHandler(t *testing.T) {
+	ctx, user := authenticatedContext(t)
+
+	t.Run("Success", func(t *testing.T) {
+		purgeAfter := time.Now().Add(720 * time.Hour)
+		uc := &fakeAccountUsecase{deletion: &auth.DeletionRequest{RestoreToken: "token", PurgeAfter: purgeAfter}}
+
+		resp, err := account.NewServer(uc).DeleteAccountHandler(ctx, &account.DeleteAccountInput{})
+		require.NoError(t, err)
+		assert.Equal(t, purgeAfter, resp.Body.Data.PurgeAfter)
+		assert.Equal(t, user, uc.lastUser)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		resp, err := account.NewServer(&fakeAccountUsecase{}).DeleteAccountHandler(
+			context.Background(), &account.DeleteAccountInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnauthorized)
+	})
+}
+
+func TestServer_RestoreAccountHandler(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		uc := &fakeAccountUsecase{}
+		input := &account.RestoreAccountInput{}
+		input.Body.Token = "restore-token"
+
+		_, err := account.NewServer(uc).RestoreAccountHandler(context.Background(), input)
+		require.NoError(t, err)
+		assert.Equal(t, "restore-token", uc.lastToken)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		uc := &fakeAccountUsecase{err: auth.ErrInvalidRestoreToken}
+
+		resp, err := account.NewServer(uc).RestoreAccountHandler(context.Background(), &account.RestoreAccountInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,158 @@
+package account_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/auth"
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/user"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+type accountTestHarness struct {
+	ctx       context.Context
+	pool      *pgxpool.Pool
+	container testcontainers.Container
+	authRepo  auth.Repository
+	userRepo  user.Repository
+}
+
+func newAccountTestHarness(t *testing.T) *accountTestHarness {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	req := testcontainers.ContainerRequest{
+		Image: "postgres:16-alpine",
+		Env: map[string]string{
+			"POSTGRES_USER":     "postgres",
+			"POSTGRES_PASSWORD": "postgres",
+			"POSTGRES_DB":       "qq_account_test",
+		},
+		ExposedPorts: []string{"5432/tcp"},
+		WaitingFor:   wait.ForListeningPort("5432/tcp").WithStartupTimeout(90 * time.Second),
+		AutoRemove:   true,
+	}
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "docker") {
+			t.Skipf("skipping account integration tests: %v", err)
+		}
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		_ = container.Terminate(ctx)
+		t.Fatalf("failed to resolve container host: %v", err)
+	}
+
+	port, err := container.MappedPort(ctx, "5432/tcp")
+	if err != nil {
+		_ = container.Terminate(ctx)
+		t.Fatalf("failed to resolve container port: %v", err)
+	}
+
+	dsn := fmt.Sprintf(
+		"postgres://postgres:postgres@%s/qq_account_test?sslmode=disable", net.JoinHostPort(host, port.Port()))
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		_ = container.Terminate(ctx)
+		t.Fatalf("failed to create pgx pool: %v", err)
+	}
+
+	if err := pool.Ping(context.Background()); err != nil {
+		pool.Close()
+		_ = container.Terminate(ctx)
+		t.Fatalf("database not reachable: %v", err)
+	}
+
+	applyAccountMigrations(t, context.Background(), pool)
+
+	harness := &accountTestHarness{
+		ctx:       context.Background(),
+		pool:      pool,
+		container: container,
+		authRepo:  auth.NewPgxRepository(pool),
+		userRepo:  user.NewPgxRepository(pool),
+	}
+
+	t.Cleanup(func() {
+		harness.Close()
+	})
+
+	return harness
+}
+
+func (h *accountTestHarness) Close() {
+	if h.pool != nil {
+		h.pool.Close()
+		h.pool = nil
+	}
+	if h.container != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = h.container.Terminate(ctx)
+		h.container = nil
+	}
+}
+
+func applyAccountMigrations(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+	t.Helper()
+	root := accountProjectRoot(t)
+	files, err := filepath.Glob(filepath.Join(root, "db", "migrations", "*.up.sql"))
+	require.NoError(t, err)
+	sort.Strings(files)
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		require.NoErrorf(t, err, "failed to read migration %s", file)
+		for _, part := range strings.Split(string(contents), ";") {
+			stmt := strings.TrimSpace(part)
+			if stmt == "" {
+				continue
+			}
+			_, err := pool.Exec(ctx, stmt)
+			require.NoErrorf(t, err, "failed executing migration %s", file)
+		}
+	}
+}
+
+func accountProjectRoot(t *testing.T) string {
+	t.Helper()
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatalf("cannot determine caller path")
+	}
+	return filepath.Clean(filepath.Join(filepath.Dir(file), "../../.."))
+}
+
+func createOTPUser(t *testing.T, h *accountTestHarness, email, username string) *db.User {
+	t.Helper()
+
+	authID, err := h.authRepo.CreateAuthForOTPLogin(h.ctx, email, email)
+	require.NoError(t, err)
+
+	userRecord, err := h.userRepo.CreateUserWithAuthID(h.ctx, *authID, username)
+	require.NoError(t, err)
+
+	return userRecord
+}
@@ -0,0 +1,401 @@
+package account_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/account"
+	"github.com/abdurrahimagca/qq-back/internal/auth"
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
+	"github.com/abdurrahimagca/qq-back/internal/platform/oauth"
+	"github.com/abdurrahimagca/qq-back/internal/platform/totp"
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAccountUsecaseForTest(h *accountTestHarness, verifier *fakeOAuthVerifier) account.Usecase {
+	return newAccountUsecaseWithMailer(h, verifier, &fakeMailer{})
+}
+
+func newAccountUsecaseWithMailer(
+	h *accountTestHarness, verifier *fakeOAuthVerifier, mailer *fakeMailer,
+) account.Usecase {
+	return newAccountUsecaseWithUploader(h, mailer, &fakeUploader{}, time.Hour)
+}
+
+func newAccountUsecaseWithPolicy(h *accountTestHarness, mailer *fakeMailer, policy emailpolicy.Policy) account.Usecase {
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+	passkeyService := webauthn.NewService(webauthn.NewPgxRepository(h.pool), webauthnport.NewRelyingParty(
+		environment.WebAuthnEnvironment{RPID: "qq.example", RPName: "QQ", Origins: []string{"https://qq.example"}}))
+	return account.NewUsecase(authService, h.pool, &fakeOAuthVerifier{}, passkeyService, mailer, &fakeUploader{},
+		policy, environment.AccountEnvironment{DeletionGracePeriod: time.Hour})
+}
+
+func newAccountUsecaseWithUploader(
+	h *accountTestHarness, mailer *fakeMailer, uploader *fakeUploader, gracePeriod time.Duration,
+) account.Usecase {
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+	passkeyService := webauthn.NewService(webauthn.NewPgxRepository(h.pool), webauthnport.NewRelyingParty(
+		environment.WebAuthnEnvironment{RPID: "qq.example", RPName: "QQ", Origins: []string{"https://qq.example"}}))
+	return account.NewUsecase(authService, h.pool, &fakeOAuthVerifier{}, passkeyService, mailer, uploader,
+		emailpolicy.NewPolicy(nil, nil),
+		environment.AccountEnvironment{DeletionGracePeriod: gracePeriod, RestoreURL: "https://qq.example/restore"})
+}
+
+func TestUsecase_LinkGoogleThenUnlinkEmail(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("link-%d@example.com", time.Now().UnixNano())
+	userRecord := createOTPUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+
+	verifier := &fakeOAuthVerifier{}
+	verifier.setIdentity(oauth.Identity{
+		Subject:       fmt.Sprintf("sub-%d", time.Now().UnixNano()),
+		Email:         "someone@gmail.com",
+		EmailVerified: true,
+	})
+	usecase := newAccountUsecaseForTest(h, verifier)
+
+	google, err := usecase.LinkGoogleIdentity(ctx, userRecord, "id-token")
+	require.NoError(t, err)
+	assert.Equal(t, db.AuthProviderGoogleOauth, google.Provider)
+
+	identities, err := usecase.ListIdentities(ctx, userRecord)
+	require.NoError(t, err)
+	require.Len(t, identities, 2)
+
+	var emailIdentity db.AuthIdentity
+	for _, identity := range identities {
+		if identity.Provider == db.AuthProviderEmailOtp {
+			emailIdentity = identity
+		}
+	}
+	require.True(t, emailIdentity.ID.Valid)
+
+	// Pending codes are dropped together with the email login.
+	require.NoError(t, h.authRepo.CreateOTP(ctx, userRecord.AuthID, "hash", auth.DefaultOTPLifetime))
+
+	err = usecase.UnlinkIdentity(ctx, userRecord, emailIdentity.ID)
+	require.NoError(t, err)
+
+	var otpCount int
+	err = h.pool.QueryRow(ctx, "SELECT COUNT(*) FROM auth_otp_codes WHERE auth_id = $1", userRecord.AuthID).
+		Scan(&otpCount)
+	require.NoError(t, err)
+	assert.Equal(t, 0, otpCount)
+
+	// Google is now the only login method and cannot be removed.
+	err = usecase.UnlinkIdentity(ctx, userRecord, google.ID)
+	require.ErrorIs(t, err, auth.ErrLastIdentity)
+
+	found, err := h.authRepo.GetAuthByProvider(ctx, db.AuthProviderGoogleOauth, google.ProviderID)
+	require.NoError(t, err)
+	assert.Equal(t, userRecord.AuthID, found.ID)
+}
+
+func TestUsecase_LinkGoogle_SubjectOwnedByAnotherAccount(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	subject := fmt.Sprintf("sub-%d", time.Now().UnixNano())
+	_, err := h.authRepo.CreateAuthForOAuthLogin(ctx, "owner@example.com", "owner@example.com",
+		db.AuthProviderGoogleOauth, subject)
+	require.NoError(t, err)
+
+	userRecord := createOTPUser(t, h, fmt.Sprintf("other-%d@example.com", time.Now().UnixNano()), "other_user")
+
+	verifier := &fakeOAuthVerifier{}
+	verifier.setIdentity(oauth.Identity{Subject: subject, Email: "owner@example.com", EmailVerified: true})
+	usecase := newAccountUsecaseForTest(h, verifier)
+
+	_, err = usecase.LinkGoogleIdentity(ctx, userRecord, "id-token")
+	require.ErrorIs(t, err, auth.ErrIdentityLinked)
+}
+
+func TestUsecase_LinkEmail_AfterUnlinking(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("relink-%d@example.com", time.Now().UnixNano())
+	userRecord := createOTPUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+
+	usecase := newAccountUsecaseForTest(h, &fakeOAuthVerifier{})
+
+	// Already enabled from sign-up.
+	_, err := usecase.LinkEmailIdentity(ctx, userRecord)
+	require.ErrorIs(t, err, auth.ErrIdentityLinked)
+
+	_, err = h.authRepo.CreateIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     userRecord.AuthID,
+		Provider:   db.AuthProviderGoogleOauth,
+		ProviderID: fmt.Sprintf("sub-%d", time.Now().UnixNano()),
+		Email:      email,
+	})
+	require.NoError(t, err)
+
+	identities, err := usecase.ListIdentities(ctx, userRecord)
+	require.NoError(t, err)
+	for _, identity := range identities {
+		if identity.Provider == db.AuthProviderEmailOtp {
+			require.NoError(t, usecase.UnlinkIdentity(ctx, userRecord, identity.ID))
+		}
+	}
+
+	linked, err := usecase.LinkEmailIdentity(ctx, userRecord)
+	require.NoError(t, err)
+	assert.Equal(t, email, linked.Email)
+	assert.Equal(t, email, linked.ProviderID)
+}
+
+func TestUsecase_TOTPEnrollment(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("totp-%d@example.com", time.Now().UnixNano())
+	userRecord := createOTPUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+	usecase := newAccountUsecaseForTest(h, &fakeOAuthVerifier{})
+
+	enrollment, err := usecase.StartTOTPEnrollment(ctx, userRecord)
+	require.NoError(t, err)
+	assert.Contains(t, enrollment.ProvisioningURI, enrollment.Secret)
+
+	code, err := totp.CodeAt(enrollment.Secret, totp.StepAt(time.Now()))
+	require.NoError(t, err)
+	_, err = usecase.ConfirmTOTPEnrollment(ctx, userRecord, "ABCDEF")
+	require.ErrorIs(t, err, auth.ErrTOTPCodeMismatch)
+
+	recoveryCodes, err := usecase.ConfirmTOTPEnrollment(ctx, userRecord, code)
+	require.NoError(t, err)
+	assert.Len(t, recoveryCodes, 10)
+
+	_, err = usecase.StartTOTPEnrollment(ctx, userRecord)
+	require.ErrorIs(t, err, auth.ErrTOTPAlreadyEnabled)
+}
+
+func TestUsecase_BeginPasskeyRegistration(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("passkey-%d@example.com", time.Now().UnixNano())
+	username := fmt.Sprintf("user_%d", time.Now().UnixNano())
+	userRecord := createOTPUser(t, h, email, username)
+	usecase := newAccountUsecaseForTest(h, &fakeOAuthVerifier{})
+
+	options, err := usecase.BeginPasskeyRegistration(ctx, userRecord)
+	require.NoError(t, err)
+	assert.Equal(t, "qq.example", options.RP.ID)
+	assert.Equal(t, email, options.User.Name)
+	assert.Equal(t, username, options.User.DisplayName, "Username stands in for a missing display name")
+	assert.NotEmpty(t, options.Challenge)
+	assert.Empty(t, options.ExcludeCredentials)
+
+	passkeys, err := usecase.ListPasskeys(ctx, userRecord)
+	require.NoError(t, err)
+	assert.Empty(t, passkeys)
+
+	err = usecase.DeletePasskey(ctx, userRecord, userRecord.ID)
+	require.ErrorIs(t, err, webauthn.ErrNotFound)
+}
+
+func TestUsecase_EmailChange(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	email := fmt.Sprintf("change-%d@example.com", suffix)
+	newEmail := fmt.Sprintf("changed-%d@example.com", suffix)
+	userRecord := createOTPUser(t, h, email, fmt.Sprintf("user_%d", suffix))
+	mailer := &fakeMailer{}
+	usecase := newAccountUsecaseWithMailer(h, &fakeOAuthVerifier{}, mailer)
+
+	session, err := h.authRepo.CreateSession(ctx, db.InsertSessionParams{UserID: userRecord.ID})
+	require.NoError(t, err)
+
+	codes, err := usecase.StartEmailChange(ctx, userRecord, newEmail)
+	require.NoError(t, err)
+	sent := mailer.emails()
+	require.Len(t, sent, 2)
+	assert.Equal(t, email, sent[0].To)
+	assert.Equal(t, codes.CurrentCode, sent[0].Body)
+	assert.Equal(t, newEmail, sent[1].To)
+	assert.Equal(t, codes.NewCode, sent[1].Body)
+
+	_, err = usecase.ConfirmEmailChange(ctx, userRecord, codes.NewCode, codes.CurrentCode)
+	require.ErrorIs(t, err, auth.ErrInvalidEmailChange)
+
+	changed, err := usecase.ConfirmEmailChange(ctx, userRecord, codes.CurrentCode, codes.NewCode)
+	require.NoError(t, err)
+	assert.Equal(t, newEmail, changed)
+
+	authRow, err := h.authRepo.GetAuthByID(ctx, userRecord.AuthID)
+	require.NoError(t, err)
+	assert.Equal(t, newEmail, authRow.Email)
+	found, err := h.authRepo.GetAuthByProvider(ctx, db.AuthProviderEmailOtp, newEmail)
+	require.NoError(t, err)
+	assert.Equal(t, userRecord.AuthID, found.ID)
+
+	stored, err := h.authRepo.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.RevokedAt.Valid, "Sessions should be revoked by the change")
+
+	sent = mailer.emails()
+	require.Len(t, sent, 4, "Both addresses should be notified")
+	assert.Equal(t, email, sent[2].To)
+	assert.Equal(t, newEmail, sent[3].To)
+
+	_, err = usecase.ConfirmEmailChange(ctx, userRecord, codes.CurrentCode, codes.NewCode)
+	require.ErrorIs(t, err, auth.ErrEmailChangeNotFound)
+}
+
+func TestUsecase_StartEmailChange_EmailInUse(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	taken := fmt.Sprintf("taken-%d@example.com", suffix)
+	createOTPUser(t, h, taken, fmt.Sprintf("taken_%d", suffix))
+	userRecord := createOTPUser(t, h, fmt.Sprintf("mover-%d@example.com", suffix), fmt.Sprintf("mover_%d", suffix))
+	mailer := &fakeMailer{}
+	usecase := newAccountUsecaseWithMailer(h, &fakeOAuthVerifier{}, mailer)
+
+	_, err := usecase.StartEmailChange(ctx, userRecord, taken)
+	require.ErrorIs(t, err, auth.ErrEmailInUse)
+	assert.Empty(t, mailer.emails(), "No code should be sent for a taken address")
+}
+
+func TestUsecase_StartEmailChange_EmailPolicy(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	userRecord := createOTPUser(t, h, fmt.Sprintf("policy-%d@example.com", suffix), fmt.Sprintf("policy_%d", suffix))
+	mailer := &fakeMailer{}
+	usecase := newAccountUsecaseWithPolicy(h, mailer,
+		emailpolicy.NewPolicy([]emailpolicy.RuleSource{emailpolicy.DisposableRules()}, nil))
+
+	_, err := usecase.StartEmailChange(ctx, userRecord, "someone@mailinator.com")
+	require.ErrorIs(t, err, emailpolicy.ErrDomainNotAllowed)
+	assert.Empty(t, mailer.emails(), "No code should be sent to a refused domain")
+
+	codes, err := usecase.StartEmailChange(ctx, userRecord, fmt.Sprintf(" Moved-%d+tag@Example.com", suffix))
+	require.NoError(t, err)
+	changed, err := usecase.ConfirmEmailChange(ctx, userRecord, codes.CurrentCode, codes.NewCode)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("Moved-%d+tag@Example.com", suffix), changed, "The new address is kept as entered")
+}
+
+func TestUsecase_DeleteAndRestoreAccount(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	email := fmt.Sprintf("delete-%d@example.com", suffix)
+	userRecord := createOTPUser(t, h, email, fmt.Sprintf("user_%d", suffix))
+	mailer := &fakeMailer{}
+	usecase := newAccountUsecaseWithUploader(h, mailer, &fakeUploader{}, time.Hour)
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+
+	session, err := h.authRepo.CreateSession(ctx, db.InsertSessionParams{UserID: userRecord.ID})
+	require.NoError(t, err)
+
+	deletion, err := usecase.DeleteAccount(ctx, userRecord)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deletion.PurgeAfter, time.Minute)
+
+	sent := mailer.emails()
+	require.Len(t, sent, 1)
+	assert.Equal(t, email, sent[0].To)
+	stored, err := h.authRepo.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.RevokedAt.Valid, "Sessions should be revoked by the deletion")
+	require.ErrorIs(t, authService.CheckSuspension(ctx, userRecord.AuthID), auth.ErrAccountDeleted)
+
+	purged, err := usecase.PurgeDueAccounts(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, purged, "Accounts in their grace period should be kept")
+
+	require.ErrorIs(t, usecase.RestoreAccount(ctx, "wrong-token"), auth.ErrInvalidRestoreToken)
+	require.NoError(t, usecase.RestoreAccount(ctx, deletion.RestoreToken))
+	assert.NoError(t, authService.CheckSuspension(ctx, userRecord.AuthID))
+}
+
+func TestUsecase_DeleteAccount_MailFailure(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	userRecord := createOTPUser(t, h, fmt.Sprintf("delete-%d@example.com", suffix), fmt.Sprintf("user_%d", suffix))
+	mailer := &fakeMailer{sendErr: errors.New("smtp unavailable")}
+	usecase := newAccountUsecaseWithUploader(h, mailer, &fakeUploader{}, time.Hour)
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+
+	session, err := h.authRepo.CreateSession(ctx, db.InsertSessionParams{UserID: userRecord.ID})
+	require.NoError(t, err)
+
+	_, err = usecase.DeleteAccount(ctx, userRecord)
+	require.Error(t, err)
+
+	require.NoError(t, authService.CheckSuspension(ctx, userRecord.AuthID),
+		"An account whose restore link was not sent should not be scheduled")
+	stored, err := h.authRepo.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.RevokedAt.Valid, "Sessions should survive a failed deletion")
+}
+
+func TestUsecase_PurgeDueAccounts(t *testing.T) {
+	h := newAccountTestHarness(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	userRecord := createOTPUser(t, h, fmt.Sprintf("purge-%d@example.com", suffix), fmt.Sprintf("user_%d", suffix))
+	avatarKey := fmt.Sprintf("avatar-%d", suffix)
+	_, err := h.pool.Exec(ctx, "UPDATE users SET avatar_key = $1 WHERE id = $2", avatarKey, userRecord.ID)
+	require.NoError(t, err)
+	archiveKey := fmt.Sprintf("exports/%d.zip", suffix)
+	_, err = h.pool.Exec(ctx, `INSERT INTO data_exports (user_id, status, archive_key)
+		VALUES ($1, 'completed', $2), ($1, 'failed', NULL)`, userRecord.ID, archiveKey)
+	require.NoError(t, err)
+
+	uploader := &fakeUploader{deleteErr: errors.New("storage unavailable")}
+	usecase := newAccountUsecaseWithUploader(h, &fakeMailer{}, uploader, 0)
+
+	_, err = usecase.DeleteAccount(ctx, userRecord)
+	require.NoError(t, err)
+
+	purged, err := usecase.PurgeDueAccounts(ctx)
+	require.Error(t, err)
+	assert.Zero(t, purged)
+	_, err = h.authRepo.GetAuthByID(ctx, userRecord.AuthID)
+	require.NoError(t, err, "The account should be kept while its files cannot be deleted")
+
+	uploader.mu.Lock()
+	uploader.deleteErr = nil
+	uploader.mu.Unlock()
+
+	purged, err = usecase.PurgeDueAccounts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t,
+		[]string{avatarKey, avatarKey + "/small", avatarKey + "/medium", avatarKey + "/large", archiveKey},
+		uploader.deleted(),
+		"Every rendition of the avatar, an avatar stored before renditions and the export archive should be deleted")
+
+	_, err = h.authRepo.GetAuthByID(ctx, userRecord.AuthID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	_, err = h.userRepo.GetUserByID(ctx, userRecord.ID)
+	require.Error(t, err, "The user row should go with the auth row")
+
+	var exportCount int
+	err = h.pool.QueryRow(ctx, "SELECT COUNT(*) FROM data_exports WHERE user_id = $1", userRecord.ID).
+		Scan(&exportCount)
+	require.NoError(t, err)
+	assert.Equal(t, 0, exportCount, "The export rows should go with the user row")
+}
@@ -2,10 +2,37 @@ package auth
 
 import (
 	"errors"
+	"fmt"
+
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 )
 
 var (
-	ErrInvalidOtpCode = errors.New("invalid otp code")
-	ErrInvalidEmail   = errors.New("invalid email")
-	ErrNotFound       = errors.New("not found")
+	ErrInvalidOtpCode       = fmt.Errorf("invalid otp code: %w", qqerrors.ErrUnauthorized)
+	ErrInvalidEmail         = errors.New("invalid email")
+	ErrNotFound             = fmt.Errorf("auth record %w", qqerrors.ErrNotFound)
+	ErrOtpAttemptsExceeded  = fmt.Errorf("otp attempts exceeded: %w", qqerrors.ErrTooManyRequests)
+	ErrOtpResendTooSoon     = fmt.Errorf("a login email was sent recently: %w", qqerrors.ErrTooManyRequests)
+	ErrIdentityLinked       = fmt.Errorf("identity is already linked to an account: %w", qqerrors.ErrUniqueViolation)
+	ErrLastIdentity         = fmt.Errorf("cannot remove the last login method: %w", qqerrors.ErrConstraintViolation)
+	ErrAccountExists        = fmt.Errorf("an account with this email already exists: %w", qqerrors.ErrUniqueViolation)
+	ErrIdentityNotLinked    = fmt.Errorf("login method is not linked to this account: %w", qqerrors.ErrForbidden)
+	ErrInvalidRefreshToken  = fmt.Errorf("refresh token is invalid or expired: %w", qqerrors.ErrUnauthorized)
+	ErrRefreshTokenReused   = fmt.Errorf("refresh token reuse detected: %w", qqerrors.ErrUnauthorized)
+	ErrSessionRevoked       = fmt.Errorf("session has been revoked: %w", qqerrors.ErrUnauthorized)
+	ErrInvalidMagicLink     = fmt.Errorf("magic link is invalid or expired: %w", qqerrors.ErrUnauthorized)
+	ErrTOTPAlreadyEnabled   = fmt.Errorf("two-factor authentication is already enabled: %w", qqerrors.ErrUniqueViolation)
+	ErrTOTPNotEnabled       = fmt.Errorf("two-factor authentication is not set up: %w", qqerrors.ErrNotFound)
+	ErrTOTPCodeMismatch     = fmt.Errorf("code does not match the authenticator secret: %w", qqerrors.ErrValidationError)
+	ErrInvalidTOTPCode      = fmt.Errorf("two-factor code is invalid: %w", qqerrors.ErrUnauthorized)
+	ErrTOTPAttemptsExceeded = fmt.Errorf("two-factor attempts exceeded: %w", qqerrors.ErrTooManyRequests)
+	ErrAccountSuspended     = fmt.Errorf("account is suspended: %w", qqerrors.ErrAccountSuspended)
+	ErrInvalidSuspension    = fmt.Errorf("invalid suspension request: %w", qqerrors.ErrValidationError)
+	ErrEmailInUse           = fmt.Errorf("email address is already in use: %w", qqerrors.ErrUniqueViolation)
+	ErrSameEmail            = fmt.Errorf("new email is the current email: %w", qqerrors.ErrValidationError)
+	ErrEmailChangeTooSoon   = fmt.Errorf("an email change was requested recently: %w", qqerrors.ErrTooManyRequests)
+	ErrEmailChangeNotFound  = fmt.Errorf("no pending email change: %w", qqerrors.ErrNotFound)
+	ErrInvalidEmailChange   = fmt.Errorf("email change codes are invalid: %w", qqerrors.ErrValidationError)
+	ErrAccountDeleted       = fmt.Errorf("account is scheduled for deletion: %w", qqerrors.ErrPendingDeletion)
+	ErrInvalidRestoreToken  = fmt.Errorf("restore token is invalid or expired: %w", qqerrors.ErrNotFound)
 )
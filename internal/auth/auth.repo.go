@@ -2,7 +2,9 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
+	"time"
 
 	"github.com/abdurrahimagca/qq-back/internal/db"
 	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
@@ -13,11 +15,67 @@ import (
 
 type Repository interface {
 	WithTx(tx pgx.Tx) Repository
-	CreateAuthForOTPLogin(ctx context.Context, email string) (*pgtype.UUID, error)
-	CreateOTP(ctx context.Context, userID pgtype.UUID, otpHash string) error
-	GetUserIDAndEmailByOTPCode(ctx context.Context, otpHash string) (db.GetUserIdAndEmailByOtpCodeRow, error)
-	KillOrphanedOTPs(ctx context.Context, email string) error
+	CreateAuthForOTPLogin(ctx context.Context, email string, normalizedEmail string) (*pgtype.UUID, error)
+	CreateAuthForOAuthLogin(
+		ctx context.Context, email string, normalizedEmail string, provider db.AuthProvider, providerID string,
+	) (*pgtype.UUID, error)
+	GetAuthByProvider(ctx context.Context, provider db.AuthProvider, providerID string) (*db.Auth, error)
+	GetAuthByID(ctx context.Context, authID pgtype.UUID) (*db.Auth, error)
+	LockAuth(ctx context.Context, authID pgtype.UUID) error
+	GetActiveRestriction(ctx context.Context, authID pgtype.UUID) (*db.GetActiveAuthRestrictionRow, error)
+	SuspendAuth(ctx context.Context, params db.SuspendAuthParams) error
+	LiftSuspension(ctx context.Context, authID pgtype.UUID) error
+	CreateSuspensionEvent(
+		ctx context.Context, params db.InsertAuthSuspensionEventParams) (*db.AuthSuspensionEvent, error)
+	ListSuspensionEvents(ctx context.Context, authID pgtype.UUID) ([]db.AuthSuspensionEvent, error)
+	EmailExists(ctx context.Context, normalizedEmail string) (bool, error)
+	UpdateEmail(ctx context.Context, authID pgtype.UUID, email string, normalizedEmail string) error
+	CreateEmailChange(ctx context.Context, params db.UpsertAuthEmailChangeParams) (*db.AuthEmailChange, error)
+	GetEmailChangeAge(ctx context.Context, authID pgtype.UUID) (time.Duration, error)
+	GetActiveEmailChange(ctx context.Context, authID pgtype.UUID) (*db.AuthEmailChange, error)
+	IncrementEmailChangeAttempts(ctx context.Context, authID pgtype.UUID) (int32, error)
+	DeleteEmailChange(ctx context.Context, authID pgtype.UUID, newEmail string) error
+	DeleteEmailChanges(ctx context.Context, authID pgtype.UUID) error
+	CreateDeletion(ctx context.Context, params db.InsertAuthDeletionParams) (*db.AuthDeletion, error)
+	RestoreDeletion(ctx context.Context, restoreTokenHash string) (pgtype.UUID, error)
+	ListDueDeletions(ctx context.Context, limit int32) ([]db.ListDueAuthDeletionsRow, error)
+	DeleteDueAuth(ctx context.Context, authID pgtype.UUID) error
+	CreateIdentity(ctx context.Context, params db.InsertAuthIdentityParams) (*db.AuthIdentity, error)
+	ListIdentities(ctx context.Context, authID pgtype.UUID) ([]db.AuthIdentity, error)
+	CountIdentities(ctx context.Context, authID pgtype.UUID) (int64, error)
+	HasIdentity(ctx context.Context, authID pgtype.UUID, provider db.AuthProvider) (bool, error)
+	DeleteIdentity(ctx context.Context, authID pgtype.UUID, identityID pgtype.UUID) error
+	CreateOTP(ctx context.Context, authID pgtype.UUID, otpHash string, lifetime time.Duration) error
+	GetActiveOTPByEmailAndHash(
+		ctx context.Context, normalizedEmail string, otpHash string) (db.GetActiveOtpCodesByEmailRow, error)
+	IncrementOTPAttempts(ctx context.Context, normalizedEmail string) (int32, error)
+	KillOrphanedOTPs(ctx context.Context, normalizedEmail string) error
 	KillOrphanedOTPsByUserID(ctx context.Context, userID pgtype.UUID) error
+	GetLatestOTPAge(ctx context.Context, authID pgtype.UUID) (time.Duration, error)
+	PruneSupersededOTPs(ctx context.Context, authID pgtype.UUID) error
+	CreateMagicLink(ctx context.Context, authID pgtype.UUID, tokenHash string, lifetime time.Duration) error
+	ConsumeMagicLink(ctx context.Context, userID pgtype.UUID, tokenHash string) error
+	CreatePendingTOTP(ctx context.Context, authID pgtype.UUID, secret string) (*db.AuthTotp, error)
+	GetTOTP(ctx context.Context, authID pgtype.UUID) (*db.AuthTotp, error)
+	ConfirmTOTP(ctx context.Context, authID pgtype.UUID, step int64) error
+	UseTOTPStep(ctx context.Context, authID pgtype.UUID, step int64) error
+	IncrementTOTPAttempts(ctx context.Context, authID pgtype.UUID) (int32, error)
+	ResetTOTPAttempts(ctx context.Context, authID pgtype.UUID) error
+	ReplaceRecoveryCodes(ctx context.Context, authID pgtype.UUID, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, authID pgtype.UUID, codeHash string) error
+	CreateRefreshToken(ctx context.Context, params db.InsertRefreshTokenParams) error
+	GetRefreshToken(ctx context.Context, tokenID pgtype.UUID) (*db.RefreshToken, error)
+	UseRefreshToken(ctx context.Context, tokenID pgtype.UUID) (*db.RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID pgtype.UUID) error
+	RevokeSessionRefreshTokens(ctx context.Context, sessionID pgtype.UUID) error
+	RevokeUserRefreshTokens(ctx context.Context, userID pgtype.UUID) error
+	CreateSession(ctx context.Context, params db.InsertSessionParams) (*db.Session, error)
+	GetSession(ctx context.Context, sessionID pgtype.UUID) (*db.Session, error)
+	ListActiveSessions(ctx context.Context, userID pgtype.UUID) ([]db.Session, error)
+	MarkFirstLogin(ctx context.Context, authID pgtype.UUID) (bool, error)
+	TouchSession(ctx context.Context, sessionID pgtype.UUID) error
+	RevokeSession(ctx context.Context, userID pgtype.UUID, sessionID pgtype.UUID) error
+	RevokeUserSessions(ctx context.Context, userID pgtype.UUID) error
 }
 
 type pgxRepository struct {
@@ -36,10 +94,31 @@ func (r *pgxRepository) WithTx(tx pgx.Tx) Repository {
 	}
 }
 
-func (r *pgxRepository) CreateAuthForOTPLogin(ctx context.Context, email string) (*pgtype.UUID, error) {
-	id, err := r.q.InsertAuth(ctx, db.InsertAuthParams{
-		Email:    email,
-		Provider: "email_otp",
+func (r *pgxRepository) CreateAuthForOTPLogin(
+	ctx context.Context, email string, normalizedEmail string) (*pgtype.UUID, error) {
+	return r.createAuthWithIdentity(ctx, email, normalizedEmail, db.AuthProviderEmailOtp, email)
+}
+
+func (r *pgxRepository) CreateAuthForOAuthLogin(
+	ctx context.Context, email string, normalizedEmail string, provider db.AuthProvider, providerID string,
+) (*pgtype.UUID, error) {
+	return r.createAuthWithIdentity(ctx, email, normalizedEmail, provider, providerID)
+}
+
+// createAuthWithIdentity inserts the auth row together with its first identity.
+// Callers are expected to run it inside a transaction.
+func (r *pgxRepository) createAuthWithIdentity(
+	ctx context.Context, email string, normalizedEmail string, provider db.AuthProvider, providerID string,
+) (*pgtype.UUID, error) {
+	id, err := r.q.InsertAuth(ctx, db.InsertAuthParams{Email: email, EmailNormalized: normalizedEmail})
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	_, err = r.q.InsertAuthIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     id,
+		Provider:   provider,
+		ProviderID: providerID,
+		Email:      email,
 	})
 	if err != nil {
 		return nil, qqerrors.GetDBErrAsQQError(err)
@@ -47,10 +126,303 @@ func (r *pgxRepository) CreateAuthForOTPLogin(ctx context.Context, email string)
 	return &id, nil
 }
 
-func (r *pgxRepository) CreateOTP(ctx context.Context, userID pgtype.UUID, otpHash string) error {
+func (r *pgxRepository) GetAuthByProvider(
+	ctx context.Context, provider db.AuthProvider, providerID string) (*db.Auth, error) {
+	row, err := r.q.GetAuthByIdentity(ctx, db.GetAuthByIdentityParams{
+		Provider:   provider,
+		ProviderID: providerID,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &row, nil
+}
+
+func (r *pgxRepository) GetAuthByID(ctx context.Context, authID pgtype.UUID) (*db.Auth, error) {
+	row, err := r.q.GetAuthByID(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &row, nil
+}
+
+func (r *pgxRepository) LockAuth(ctx context.Context, authID pgtype.UUID) error {
+	_, err := r.q.LockAuthByID(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+// GetActiveRestriction returns the suspension or pending deletion of the
+// account, or ErrNotFound when it is neither suspended nor waiting to be purged.
+// A temporary suspension that has run out does not count.
+func (r *pgxRepository) GetActiveRestriction(
+	ctx context.Context, authID pgtype.UUID) (*db.GetActiveAuthRestrictionRow, error) {
+	row, err := r.q.GetActiveAuthRestriction(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &row, nil
+}
+
+func (r *pgxRepository) SuspendAuth(ctx context.Context, params db.SuspendAuthParams) error {
+	rows, err := r.q.SuspendAuth(ctx, params)
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// LiftSuspension clears the suspension of the account; ErrNotFound when it was
+// not suspended.
+func (r *pgxRepository) LiftSuspension(ctx context.Context, authID pgtype.UUID) error {
+	rows, err := r.q.LiftAuthSuspension(ctx, authID)
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgxRepository) CreateSuspensionEvent(
+	ctx context.Context, params db.InsertAuthSuspensionEventParams) (*db.AuthSuspensionEvent, error) {
+	event, err := r.q.InsertAuthSuspensionEvent(ctx, params)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &event, nil
+}
+
+func (r *pgxRepository) ListSuspensionEvents(
+	ctx context.Context, authID pgtype.UUID) ([]db.AuthSuspensionEvent, error) {
+	events, err := r.q.ListAuthSuspensionEvents(ctx, authID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return events, nil
+}
+
+func (r *pgxRepository) EmailExists(ctx context.Context, normalizedEmail string) (bool, error) {
+	exists, err := r.q.AuthEmailExists(ctx, normalizedEmail)
+	if err != nil {
+		return false, qqerrors.GetDBErrAsQQError(err)
+	}
+	return exists, nil
+}
+
+// UpdateEmail changes the account email together with the provider id of its
+// email_otp identity, which mirrors it. Run it inside a transaction.
+func (r *pgxRepository) UpdateEmail(
+	ctx context.Context, authID pgtype.UUID, email string, normalizedEmail string) error {
+	rows, err := r.q.UpdateAuthEmail(ctx, db.UpdateAuthEmailParams{
+		Email:           email,
+		EmailNormalized: normalizedEmail,
+		ID:              authID,
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	err = r.q.UpdateEmailOtpIdentity(ctx, db.UpdateEmailOtpIdentityParams{AuthID: authID, Email: email})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+// CreateEmailChange stores the pending email change of the account, replacing
+// an earlier one along with its attempts.
+func (r *pgxRepository) CreateEmailChange(
+	ctx context.Context, params db.UpsertAuthEmailChangeParams) (*db.AuthEmailChange, error) {
+	change, err := r.q.UpsertAuthEmailChange(ctx, params)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &change, nil
+}
+
+func (r *pgxRepository) GetEmailChangeAge(ctx context.Context, authID pgtype.UUID) (time.Duration, error) {
+	seconds, err := r.q.GetAuthEmailChangeAge(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, qqerrors.GetDBErrAsQQError(err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (r *pgxRepository) GetActiveEmailChange(ctx context.Context, authID pgtype.UUID) (*db.AuthEmailChange, error) {
+	change, err := r.q.GetActiveAuthEmailChange(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &change, nil
+}
+
+func (r *pgxRepository) IncrementEmailChangeAttempts(ctx context.Context, authID pgtype.UUID) (int32, error) {
+	attempts, err := r.q.IncrementAuthEmailChangeAttempts(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, qqerrors.GetDBErrAsQQError(err)
+	}
+	return attempts, nil
+}
+
+// DeleteEmailChange removes the pending change to newEmail; ErrNotFound when it
+// was already applied or replaced by a change to another address.
+func (r *pgxRepository) DeleteEmailChange(ctx context.Context, authID pgtype.UUID, newEmail string) error {
+	rows, err := r.q.DeleteAuthEmailChange(ctx, db.DeleteAuthEmailChangeParams{AuthID: authID, NewEmail: newEmail})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgxRepository) DeleteEmailChanges(ctx context.Context, authID pgtype.UUID) error {
+	if err := r.q.DeleteAuthEmailChangesByAuthID(ctx, authID); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+// CreateDeletion schedules the account to be purged; a deletion that is already
+// pending is a unique violation.
+func (r *pgxRepository) CreateDeletion(
+	ctx context.Context, params db.InsertAuthDeletionParams) (*db.AuthDeletion, error) {
+	deletion, err := r.q.InsertAuthDeletion(ctx, params)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &deletion, nil
+}
+
+// RestoreDeletion cancels the pending deletion the restore token belongs to and
+// returns the account; ErrNotFound when there is none or its grace period ended.
+func (r *pgxRepository) RestoreDeletion(ctx context.Context, restoreTokenHash string) (pgtype.UUID, error) {
+	authID, err := r.q.DeleteAuthDeletionByTokenHash(ctx, restoreTokenHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pgtype.UUID{}, ErrNotFound
+		}
+		return pgtype.UUID{}, qqerrors.GetDBErrAsQQError(err)
+	}
+	return authID, nil
+}
+
+// ListDueDeletions returns up to limit accounts whose grace period has ended,
+// oldest first, with the avatar and data export archive objects to remove.
+func (r *pgxRepository) ListDueDeletions(ctx context.Context, limit int32) ([]db.ListDueAuthDeletionsRow, error) {
+	rows, err := r.q.ListDueAuthDeletions(ctx, limit)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return rows, nil
+}
+
+// DeleteDueAuth deletes the auth row, and through the cascades everything tied
+// to it, if its deletion is still pending and due; ErrNotFound otherwise.
+func (r *pgxRepository) DeleteDueAuth(ctx context.Context, authID pgtype.UUID) error {
+	rows, err := r.q.DeleteDueAuth(ctx, authID)
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgxRepository) CreateIdentity(
+	ctx context.Context, params db.InsertAuthIdentityParams) (*db.AuthIdentity, error) {
+	identity, err := r.q.InsertAuthIdentity(ctx, params)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &identity, nil
+}
+
+func (r *pgxRepository) ListIdentities(ctx context.Context, authID pgtype.UUID) ([]db.AuthIdentity, error) {
+	identities, err := r.q.ListAuthIdentitiesByAuthID(ctx, authID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return identities, nil
+}
+
+func (r *pgxRepository) CountIdentities(ctx context.Context, authID pgtype.UUID) (int64, error) {
+	count, err := r.q.CountAuthIdentitiesByAuthID(ctx, authID)
+	if err != nil {
+		return 0, qqerrors.GetDBErrAsQQError(err)
+	}
+	return count, nil
+}
+
+func (r *pgxRepository) HasIdentity(
+	ctx context.Context, authID pgtype.UUID, provider db.AuthProvider) (bool, error) {
+	exists, err := r.q.AuthIdentityExists(ctx, db.AuthIdentityExistsParams{
+		AuthID:   authID,
+		Provider: provider,
+	})
+	if err != nil {
+		return false, qqerrors.GetDBErrAsQQError(err)
+	}
+	return exists, nil
+}
+
+func (r *pgxRepository) DeleteIdentity(ctx context.Context, authID pgtype.UUID, identityID pgtype.UUID) error {
+	deleted, err := r.q.DeleteAuthIdentity(ctx, db.DeleteAuthIdentityParams{
+		ID:     identityID,
+		AuthID: authID,
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateOTP stores a code hash that expires after lifetime. The code starts with
+// the attempts already spent on the account's pending codes, so resending does
+// not hand out a fresh budget of guesses while the older code still works.
+func (r *pgxRepository) CreateOTP(
+	ctx context.Context, authID pgtype.UUID, otpHash string, lifetime time.Duration,
+) error {
 	_, err := r.q.InsertAuthOtpCode(ctx, db.InsertAuthOtpCodeParams{
-		AuthID: userID,
-		Code:   otpHash,
+		AuthID:          authID,
+		Code:            otpHash,
+		Kind:            db.OtpKindCode,
+		LifetimeSeconds: lifetime.Seconds(),
 	})
 	if err != nil {
 		return qqerrors.GetDBErrAsQQError(err)
@@ -58,21 +430,47 @@ func (r *pgxRepository) CreateOTP(ctx context.Context, userID pgtype.UUID, otpHa
 	return nil
 }
 
-func (r *pgxRepository) GetUserIDAndEmailByOTPCode(
+// GetActiveOTPByEmailAndHash returns the active code of the account with the
+// normalized email whose hash matches otpHash. Every candidate is compared in constant time so the response
+// time does not depend on how much of the hash matched.
+func (r *pgxRepository) GetActiveOTPByEmailAndHash(
 	ctx context.Context,
+	normalizedEmail string,
 	otpHash string,
-) (db.GetUserIdAndEmailByOtpCodeRow, error) {
-	row, err := r.q.GetUserIdAndEmailByOtpCode(ctx, otpHash)
+) (db.GetActiveOtpCodesByEmailRow, error) {
+	rows, err := r.q.GetActiveOtpCodesByEmail(ctx, normalizedEmail)
+	if err != nil {
+		return db.GetActiveOtpCodesByEmailRow{}, qqerrors.GetDBErrAsQQError(err)
+	}
+
+	var match db.GetActiveOtpCodesByEmailRow
+	found := 0
+	for _, row := range rows {
+		eq := subtle.ConstantTimeCompare([]byte(row.Code), []byte(otpHash))
+		if eq == 1 {
+			match = row
+		}
+		found |= eq
+	}
+	if found == 0 {
+		return db.GetActiveOtpCodesByEmailRow{}, ErrNotFound
+	}
+	return match, nil
+}
+
+func (r *pgxRepository) IncrementOTPAttempts(ctx context.Context, normalizedEmail string) (int32, error) {
+	attempts, err := r.q.IncrementOtpAttemptsByEmail(ctx, normalizedEmail)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return db.GetUserIdAndEmailByOtpCodeRow{}, ErrNotFound
+			return 0, ErrNotFound
 		}
-		return db.GetUserIdAndEmailByOtpCodeRow{}, qqerrors.GetDBErrAsQQError(err)
+		return 0, qqerrors.GetDBErrAsQQError(err)
 	}
-	return row, nil
+	return attempts, nil
 }
-func (r *pgxRepository) KillOrphanedOTPs(ctx context.Context, email string) error {
-	err := r.q.DeleteOtpCodesByEmail(ctx, email)
+
+func (r *pgxRepository) KillOrphanedOTPs(ctx context.Context, normalizedEmail string) error {
+	err := r.q.DeleteOtpCodesByEmail(ctx, normalizedEmail)
 	if err != nil {
 		return qqerrors.GetDBErrAsQQError(err)
 	}
@@ -86,3 +484,284 @@ func (r *pgxRepository) KillOrphanedOTPsByUserID(ctx context.Context, userID pgt
 	}
 	return nil
 }
+
+// GetLatestOTPAge returns how long ago the newest code or link of the account was
+// created, measured by the database clock.
+func (r *pgxRepository) GetLatestOTPAge(ctx context.Context, authID pgtype.UUID) (time.Duration, error) {
+	seconds, err := r.q.GetLatestOtpCodeAgeByAuthID(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, qqerrors.GetDBErrAsQQError(err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// PruneSupersededOTPs deletes the account's expired codes and links and every
+// pending one except the newest, which stays valid while its replacement is
+// on the way.
+func (r *pgxRepository) PruneSupersededOTPs(ctx context.Context, authID pgtype.UUID) error {
+	err := r.q.DeleteSupersededOtpCodesByAuthID(ctx, authID)
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+// CreateMagicLink stores a magic-link token hash next to the OTP codes, so it
+// shares their expiry and is removed by the same cleanup.
+func (r *pgxRepository) CreateMagicLink(
+	ctx context.Context, authID pgtype.UUID, tokenHash string, lifetime time.Duration,
+) error {
+	_, err := r.q.InsertAuthOtpCode(ctx, db.InsertAuthOtpCodeParams{
+		AuthID:          authID,
+		Code:            tokenHash,
+		Kind:            db.OtpKindMagicLink,
+		LifetimeSeconds: lifetime.Seconds(),
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+// ConsumeMagicLink deletes the user's active magic link with the given hash.
+// Deleting is what makes the link single-use: a second attempt finds no row.
+func (r *pgxRepository) ConsumeMagicLink(ctx context.Context, userID pgtype.UUID, tokenHash string) error {
+	rows, err := r.q.ConsumeMagicLinkByUserID(ctx, db.ConsumeMagicLinkByUserIDParams{
+		Code:   tokenHash,
+		UserID: userID,
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreatePendingTOTP stores a new secret for an enrollment that has not been
+// confirmed yet, replacing an earlier unconfirmed one. Once TOTP is confirmed the
+// row is left alone and ErrNotFound is returned.
+func (r *pgxRepository) CreatePendingTOTP(
+	ctx context.Context, authID pgtype.UUID, secret string) (*db.AuthTotp, error) {
+	record, err := r.q.UpsertPendingAuthTotp(ctx, db.UpsertPendingAuthTotpParams{
+		AuthID: authID,
+		Secret: secret,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &record, nil
+}
+
+func (r *pgxRepository) GetTOTP(ctx context.Context, authID pgtype.UUID) (*db.AuthTotp, error) {
+	record, err := r.q.GetAuthTotpByAuthID(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &record, nil
+}
+
+func (r *pgxRepository) ConfirmTOTP(ctx context.Context, authID pgtype.UUID, step int64) error {
+	rows, err := r.q.ConfirmAuthTotp(ctx, db.ConfirmAuthTotpParams{
+		LastUsedStep: step,
+		AuthID:       authID,
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UseTOTPStep records the step of an accepted code and clears failed attempts.
+// Steps at or before the last recorded one return ErrNotFound, so each code is
+// accepted once even by concurrent requests.
+func (r *pgxRepository) UseTOTPStep(ctx context.Context, authID pgtype.UUID, step int64) error {
+	rows, err := r.q.UseAuthTotpStep(ctx, db.UseAuthTotpStepParams{
+		LastUsedStep: step,
+		AuthID:       authID,
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgxRepository) IncrementTOTPAttempts(ctx context.Context, authID pgtype.UUID) (int32, error) {
+	attempts, err := r.q.IncrementAuthTotpFailedAttempts(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, qqerrors.GetDBErrAsQQError(err)
+	}
+	return attempts, nil
+}
+
+func (r *pgxRepository) ResetTOTPAttempts(ctx context.Context, authID pgtype.UUID) error {
+	if err := r.q.ResetAuthTotpFailedAttempts(ctx, authID); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes drops every recovery code of the account and stores the
+// given hashes. Callers are expected to run it inside a transaction.
+func (r *pgxRepository) ReplaceRecoveryCodes(ctx context.Context, authID pgtype.UUID, codeHashes []string) error {
+	if err := r.q.DeleteAuthRecoveryCodesByAuthID(ctx, authID); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	for _, codeHash := range codeHashes {
+		err := r.q.InsertAuthRecoveryCode(ctx, db.InsertAuthRecoveryCodeParams{
+			AuthID:   authID,
+			CodeHash: codeHash,
+		})
+		if err != nil {
+			return qqerrors.GetDBErrAsQQError(err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used; unknown and already
+// used codes return ErrNotFound.
+func (r *pgxRepository) UseRecoveryCode(ctx context.Context, authID pgtype.UUID, codeHash string) error {
+	rows, err := r.q.UseAuthRecoveryCode(ctx, db.UseAuthRecoveryCodeParams{
+		AuthID:   authID,
+		CodeHash: codeHash,
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgxRepository) CreateRefreshToken(ctx context.Context, params db.InsertRefreshTokenParams) error {
+	if err := r.q.InsertRefreshToken(ctx, params); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+func (r *pgxRepository) GetRefreshToken(ctx context.Context, tokenID pgtype.UUID) (*db.RefreshToken, error) {
+	token, err := r.q.GetRefreshTokenByID(ctx, tokenID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks an active token as used in a single statement, so of two
+// concurrent requests presenting the same token only one gets it back.
+func (r *pgxRepository) UseRefreshToken(ctx context.Context, tokenID pgtype.UUID) (*db.RefreshToken, error) {
+	token, err := r.q.UseRefreshToken(ctx, tokenID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &token, nil
+}
+
+func (r *pgxRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID pgtype.UUID) error {
+	if err := r.q.RevokeRefreshTokenFamily(ctx, familyID); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+func (r *pgxRepository) RevokeSessionRefreshTokens(ctx context.Context, sessionID pgtype.UUID) error {
+	if err := r.q.RevokeRefreshTokensBySessionID(ctx, sessionID); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+func (r *pgxRepository) RevokeUserRefreshTokens(ctx context.Context, userID pgtype.UUID) error {
+	if err := r.q.RevokeRefreshTokensByUserID(ctx, userID); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+func (r *pgxRepository) CreateSession(ctx context.Context, params db.InsertSessionParams) (*db.Session, error) {
+	session, err := r.q.InsertSession(ctx, params)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &session, nil
+}
+
+func (r *pgxRepository) GetSession(ctx context.Context, sessionID pgtype.UUID) (*db.Session, error) {
+	session, err := r.q.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &session, nil
+}
+
+func (r *pgxRepository) ListActiveSessions(ctx context.Context, userID pgtype.UUID) ([]db.Session, error) {
+	sessions, err := r.q.ListActiveSessionsByUserID(ctx, userID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return sessions, nil
+}
+
+func (r *pgxRepository) MarkFirstLogin(ctx context.Context, authID pgtype.UUID) (bool, error) {
+	rows, err := r.q.MarkAuthFirstLogin(ctx, authID)
+	if err != nil {
+		return false, qqerrors.GetDBErrAsQQError(err)
+	}
+	return rows == 1, nil
+}
+
+func (r *pgxRepository) TouchSession(ctx context.Context, sessionID pgtype.UUID) error {
+	if err := r.q.TouchSession(ctx, sessionID); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+func (r *pgxRepository) RevokeSession(ctx context.Context, userID pgtype.UUID, sessionID pgtype.UUID) error {
+	rows, err := r.q.RevokeSession(ctx, db.RevokeSessionParams{ID: sessionID, UserID: userID})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgxRepository) RevokeUserSessions(ctx context.Context, userID pgtype.UUID) error {
+	if err := r.q.RevokeSessionsByUserID(ctx, userID); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
@@ -4,9 +4,18 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
+	"github.com/abdurrahimagca/qq-back/internal/platform/totp"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
@@ -14,64 +23,867 @@ import (
 type Service interface {
 	WithTx(tx pgx.Tx) Service
 	GenerateAndSaveOTPForAuth(ctx context.Context, authID pgtype.UUID) (string, error)
-	VerifyOTP(ctx context.Context, email string, otpCode string) error
+	VerifyOTP(ctx context.Context, normalizedEmail string, otpCode string) (pgtype.UUID, error)
 	KillOrphanedOTPsByUserID(ctx context.Context, userID pgtype.UUID) error
-	KillOrphanedOTPs(ctx context.Context, email string) error
+	KillOrphanedOTPs(ctx context.Context, normalizedEmail string) error
+	CheckOTPResendCooldown(ctx context.Context, authID pgtype.UUID) error
+	PruneSupersededOTPs(ctx context.Context, authID pgtype.UUID) error
+	GenerateAndSaveMagicLinkForAuth(ctx context.Context, authID pgtype.UUID) (string, error)
+	ConsumeMagicLink(ctx context.Context, userID pgtype.UUID, nonce string) error
+	StartTOTPEnrollment(ctx context.Context, authID pgtype.UUID) (*TOTPEnrollment, error)
+	ConfirmTOTPEnrollment(ctx context.Context, authID pgtype.UUID, code string) ([]string, error)
+	IsTOTPEnabled(ctx context.Context, authID pgtype.UUID) (bool, error)
+	ResetTOTPAttempts(ctx context.Context, authID pgtype.UUID) error
+	VerifySecondFactor(ctx context.Context, authID pgtype.UUID, code string) error
 	CreateNewAuthForOTPLogin(ctx context.Context, email string) (*pgtype.UUID, error)
+	CreateNewAuthForOAuthLogin(
+		ctx context.Context, email string, provider db.AuthProvider, providerID string) (*pgtype.UUID, error)
+	GetAuthByProvider(ctx context.Context, provider db.AuthProvider, providerID string) (*db.Auth, error)
+	GetAuthByID(ctx context.Context, authID pgtype.UUID) (*db.Auth, error)
+	CheckSuspension(ctx context.Context, authID pgtype.UUID) error
+	SuspendAccount(ctx context.Context, params SuspendParams) error
+	LiftSuspension(ctx context.Context, authID pgtype.UUID, actor string, reason string) error
+	ListSuspensionEvents(ctx context.Context, authID pgtype.UUID) ([]db.AuthSuspensionEvent, error)
+	StartEmailChange(ctx context.Context, authID pgtype.UUID, newEmail string) (*EmailChangeCodes, error)
+	VerifyEmailChange(
+		ctx context.Context, authID pgtype.UUID, currentCode string, newCode string) (*db.AuthEmailChange, error)
+	ApplyEmailChange(ctx context.Context, authID pgtype.UUID, change db.AuthEmailChange) (string, error)
+	RequestDeletion(ctx context.Context, authID pgtype.UUID, gracePeriod time.Duration) (*DeletionRequest, error)
+	RestoreDeletion(ctx context.Context, restoreToken string) (pgtype.UUID, error)
+	ListDueDeletions(ctx context.Context, limit int32) ([]db.ListDueAuthDeletionsRow, error)
+	PurgeAccount(ctx context.Context, authID pgtype.UUID) error
+	HasIdentity(ctx context.Context, authID pgtype.UUID, provider db.AuthProvider) (bool, error)
+	ListIdentities(ctx context.Context, authID pgtype.UUID) ([]db.AuthIdentity, error)
+	LinkIdentity(ctx context.Context, params db.InsertAuthIdentityParams) (*db.AuthIdentity, error)
+	UnlinkIdentity(ctx context.Context, authID pgtype.UUID, identityID pgtype.UUID) error
+	SaveRefreshToken(ctx context.Context, params db.InsertRefreshTokenParams) error
+	RotateRefreshToken(ctx context.Context, tokenID pgtype.UUID, userID pgtype.UUID) (*db.RefreshToken, error)
+	CreateSession(ctx context.Context, params db.InsertSessionParams) (*db.Session, error)
+	ListSessions(ctx context.Context, userID pgtype.UUID) ([]db.Session, error)
+	MarkFirstLogin(ctx context.Context, authID pgtype.UUID) (bool, error)
+	IsSessionActive(ctx context.Context, sessionID pgtype.UUID, userID pgtype.UUID) (bool, error)
+	TouchSession(ctx context.Context, sessionID pgtype.UUID) error
+	RevokeSession(ctx context.Context, userID pgtype.UUID, sessionID pgtype.UUID) error
+	RevokeAllSessions(ctx context.Context, userID pgtype.UUID) error
+}
+
+// DefaultOTPLifetime is used when OTP_LIFETIME is not configured.
+const DefaultOTPLifetime = 3 * time.Minute
+
+const (
+	defaultMaxOTPAttempts     = 5
+	magicLinkNonceBytesLength = 32
+	restoreTokenBytesLength   = 32
+	defaultTOTPIssuer         = "QQ"
+	recoveryCodeCount         = 10
+	recoveryCodeBytesLength   = 5
+)
+
+// TOTPEnrollment is an authenticator setup waiting for its first code: the
+// secret for manual entry and the otpauth:// URI to show as a QR code.
+type TOTPEnrollment struct {
+	Secret          string
+	ProvisioningURI string
+}
+
+// SuspendParams describes a suspension. Actor records who made the decision,
+// e.g. the moderator's email. A zero Until suspends the account until the
+// suspension is lifted.
+type SuspendParams struct {
+	AuthID pgtype.UUID
+	Reason string
+	Until  time.Time
+	Actor  string
+}
+
+// EmailChangeCodes are the codes of a pending email change: CurrentCode goes
+// to the current address and NewCode to the requested one.
+type EmailChangeCodes struct {
+	CurrentEmail string
+	CurrentCode  string
+	NewEmail     string
+	NewCode      string
+	ExpiresAt    time.Time
+}
+
+// DeletionRequest is a scheduled account deletion. RestoreToken cancels it
+// until PurgeAfter; only its hash is stored.
+type DeletionRequest struct {
+	RestoreToken string
+	PurgeAfter   time.Time
+}
+
+// SessionRevocationListener is told about sessions the service revokes, so a
+// cache of active sessions can drop them before its entries run out.
+type SessionRevocationListener interface {
+	SessionRevoked(sessionID pgtype.UUID)
+	UserSessionsRevoked(userID pgtype.UUID)
 }
 
 type service struct {
-	repo Repository
+	repo           Repository
+	maxOTPAttempts int32
+	otpLifetime    time.Duration
+	resendCooldown time.Duration
+	totpIssuer     string
+	revocations    []SessionRevocationListener
+}
+
+// ServiceOption changes how NewService is set up.
+type ServiceOption func(*service)
+
+// WithSessionRevocationListener tells listener about every session revoked by
+// the service.
+func WithSessionRevocationListener(listener SessionRevocationListener) ServiceOption {
+	return func(s *service) {
+		s.revocations = append(s.revocations, listener)
+	}
 }
 
-func NewService(repo Repository) Service {
-	return &service{repo: repo}
+func NewService(repo Repository, conf environment.OTPEnvironment, options ...ServiceOption) Service {
+	maxOTPAttempts := int32(conf.MaxAttempts)
+	if maxOTPAttempts <= 0 {
+		maxOTPAttempts = defaultMaxOTPAttempts
+	}
+	otpLifetime := conf.Lifetime
+	if otpLifetime <= 0 {
+		otpLifetime = DefaultOTPLifetime
+	}
+	totpIssuer := conf.TOTPIssuer
+	if totpIssuer == "" {
+		totpIssuer = defaultTOTPIssuer
+	}
+	s := &service{
+		repo:           repo,
+		maxOTPAttempts: maxOTPAttempts,
+		otpLifetime:    otpLifetime,
+		resendCooldown: conf.ResendCooldown,
+		totpIssuer:     totpIssuer,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 func (s *service) WithTx(tx pgx.Tx) Service {
-	return &service{repo: s.repo.WithTx(tx)}
+	txService := *s
+	txService.repo = s.repo.WithTx(tx)
+	return &txService
 }
 
+// CreateNewAuthForOTPLogin creates an account for email as it was entered. Its
+// normalized form is stored beside it as the lookup key, so another spelling of
+// the same address fails with qqerrors.ErrUniqueViolation.
 func (s *service) CreateNewAuthForOTPLogin(ctx context.Context, email string) (*pgtype.UUID, error) {
-	id, err := s.repo.CreateAuthForOTPLogin(ctx, email)
+	email = strings.TrimSpace(email)
+	normalized, err := emailpolicy.Normalize(email)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.repo.CreateAuthForOTPLogin(ctx, email, normalized)
 	if err != nil {
 		return nil, err
 	}
 	return id, nil
 }
 
+// CreateNewAuthForOAuthLogin is CreateNewAuthForOTPLogin for an address
+// asserted by an identity provider.
+func (s *service) CreateNewAuthForOAuthLogin(
+	ctx context.Context, email string, provider db.AuthProvider, providerID string) (*pgtype.UUID, error) {
+	email = strings.TrimSpace(email)
+	normalized, err := emailpolicy.Normalize(email)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.CreateAuthForOAuthLogin(ctx, email, normalized, provider, providerID)
+}
+
+func (s *service) GetAuthByProvider(
+	ctx context.Context, provider db.AuthProvider, providerID string) (*db.Auth, error) {
+	return s.repo.GetAuthByProvider(ctx, provider, providerID)
+}
+
+func (s *service) GetAuthByID(ctx context.Context, authID pgtype.UUID) (*db.Auth, error) {
+	return s.repo.GetAuthByID(ctx, authID)
+}
+
+// CheckSuspension returns ErrAccountSuspended while the account is suspended
+// and ErrAccountDeleted while it waits to be purged. Temporary suspensions stop
+// applying on their own once they run out.
+func (s *service) CheckSuspension(ctx context.Context, authID pgtype.UUID) error {
+	suspension, err := s.repo.GetActiveRestriction(ctx, authID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if suspension.PurgeAfter.Valid {
+		return ErrAccountDeleted
+	}
+	if suspension.SuspendedUntil.Valid {
+		return fmt.Errorf("%w until %s", ErrAccountSuspended, suspension.SuspendedUntil.Time.Format(time.RFC3339))
+	}
+	return ErrAccountSuspended
+}
+
+// SuspendAccount suspends the account, replacing any earlier suspension, and
+// records who did it. A missing reason or actor, or an Until in the past, is
+// ErrInvalidSuspension. Run it inside a transaction so the audit entry is
+// written together with the change.
+func (s *service) SuspendAccount(ctx context.Context, params SuspendParams) error {
+	reason := strings.TrimSpace(params.Reason)
+	actor := strings.TrimSpace(params.Actor)
+	if reason == "" || actor == "" {
+		return ErrInvalidSuspension
+	}
+	var until pgtype.Timestamp
+	if !params.Until.IsZero() {
+		if !params.Until.After(time.Now()) {
+			return ErrInvalidSuspension
+		}
+		until = pgtype.Timestamp{Time: params.Until.UTC(), Valid: true}
+	}
+
+	err := s.repo.SuspendAuth(ctx, db.SuspendAuthParams{
+		ID:             params.AuthID,
+		Reason:         pgtype.Text{String: reason, Valid: true},
+		SuspendedUntil: until,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.repo.CreateSuspensionEvent(ctx, db.InsertAuthSuspensionEventParams{
+		AuthID:         params.AuthID,
+		Action:         db.SuspensionActionSuspended,
+		Reason:         reason,
+		SuspendedUntil: until,
+		Actor:          actor,
+	})
+	return err
+}
+
+// LiftSuspension ends the suspension of the account before it runs out and
+// records who lifted it and why. Like SuspendAccount, run it inside a transaction.
+func (s *service) LiftSuspension(ctx context.Context, authID pgtype.UUID, actor string, reason string) error {
+	reason = strings.TrimSpace(reason)
+	actor = strings.TrimSpace(actor)
+	if reason == "" || actor == "" {
+		return ErrInvalidSuspension
+	}
+	if err := s.repo.LiftSuspension(ctx, authID); err != nil {
+		return err
+	}
+	_, err := s.repo.CreateSuspensionEvent(ctx, db.InsertAuthSuspensionEventParams{
+		AuthID: authID,
+		Action: db.SuspensionActionLifted,
+		Reason: reason,
+		Actor:  actor,
+	})
+	return err
+}
+
+// ListSuspensionEvents returns the suspension history of the account, newest first.
+func (s *service) ListSuspensionEvents(ctx context.Context, authID pgtype.UUID) ([]db.AuthSuspensionEvent, error) {
+	return s.repo.ListSuspensionEvents(ctx, authID)
+}
+
+// StartEmailChange creates the pending change of the account email to newEmail
+// and returns one code for each address; both are needed to apply it. Addresses
+// are compared by their normalized form, while newEmail is kept as entered.
+// Starting again replaces the pending change and is held to the resend
+// cooldown. The auth row is locked first; run it inside a transaction.
+func (s *service) StartEmailChange(
+	ctx context.Context, authID pgtype.UUID, newEmail string) (*EmailChangeCodes, error) {
+	newEmail = strings.TrimSpace(newEmail)
+	normalized, err := emailpolicy.Normalize(newEmail)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.repo.LockAuth(ctx, authID); err != nil {
+		return nil, err
+	}
+	authRow, err := s.repo.GetAuthByID(ctx, authID)
+	if err != nil {
+		return nil, err
+	}
+	if normalized == authRow.EmailNormalized {
+		return nil, ErrSameEmail
+	}
+	inUse, err := s.repo.EmailExists(ctx, normalized)
+	if err != nil {
+		return nil, err
+	}
+	if inUse {
+		return nil, ErrEmailInUse
+	}
+
+	if s.resendCooldown > 0 {
+		age, ageErr := s.repo.GetEmailChangeAge(ctx, authID)
+		if ageErr != nil && !errors.Is(ageErr, ErrNotFound) {
+			return nil, ageErr
+		}
+		if wait := s.resendCooldown - age; ageErr == nil && wait > 0 {
+			return nil, &qqerrors.RetryAfterError{Err: ErrEmailChangeTooSoon, RetryAfter: wait}
+		}
+	}
+
+	currentCode, currentHash, err := newOTPCode()
+	if err != nil {
+		return nil, err
+	}
+	newCode, newHash, err := newOTPCode()
+	if err != nil {
+		return nil, err
+	}
+	change, err := s.repo.CreateEmailChange(ctx, db.UpsertAuthEmailChangeParams{
+		AuthID:          authID,
+		NewEmail:        newEmail,
+		CurrentCodeHash: currentHash,
+		NewCodeHash:     newHash,
+		LifetimeSeconds: s.otpLifetime.Seconds(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailChangeCodes{
+		CurrentEmail: authRow.Email,
+		CurrentCode:  currentCode,
+		NewEmail:     newEmail,
+		NewCode:      newCode,
+		ExpiresAt:    change.ExpiresAt.Time,
+	}, nil
+}
+
+// VerifyEmailChange checks both codes of the pending email change and returns
+// it. Like VerifyOTP it consumes an attempt before comparing, must run outside a
+// transaction so failed attempts persist, and drops the change once the limit
+// is hit.
+func (s *service) VerifyEmailChange(
+	ctx context.Context, authID pgtype.UUID, currentCode string, newCode string) (*db.AuthEmailChange, error) {
+	attempts, err := s.repo.IncrementEmailChangeAttempts(ctx, authID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrEmailChangeNotFound
+		}
+		return nil, err
+	}
+	if attempts > s.maxOTPAttempts {
+		return nil, ErrOtpAttemptsExceeded
+	}
+
+	change, err := s.repo.GetActiveEmailChange(ctx, authID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrEmailChangeNotFound
+		}
+		return nil, err
+	}
+
+	currentHash := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(currentCode))))
+	newHash := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(newCode))))
+	currentOK := subtle.ConstantTimeCompare([]byte(hex.EncodeToString(currentHash[:])), []byte(change.CurrentCodeHash))
+	newOK := subtle.ConstantTimeCompare([]byte(hex.EncodeToString(newHash[:])), []byte(change.NewCodeHash))
+	if currentOK&newOK == 1 {
+		return change, nil
+	}
+
+	if attempts >= s.maxOTPAttempts {
+		if deleteErr := s.repo.DeleteEmailChanges(ctx, authID); deleteErr != nil {
+			return nil, deleteErr
+		}
+		return nil, ErrOtpAttemptsExceeded
+	}
+	return nil, ErrInvalidEmailChange
+}
+
+// ApplyEmailChange swaps the account email for the verified change and returns
+// the previous address. Codes and links sent to the previous address are
+// deleted. It fails with ErrEmailChangeNotFound when the change was applied or
+// replaced meanwhile and with ErrEmailInUse when the address was taken since it
+// was requested. Run it inside a transaction.
+func (s *service) ApplyEmailChange(
+	ctx context.Context, authID pgtype.UUID, change db.AuthEmailChange) (string, error) {
+	if err := s.repo.LockAuth(ctx, authID); err != nil {
+		return "", err
+	}
+	authRow, err := s.repo.GetAuthByID(ctx, authID)
+	if err != nil {
+		return "", err
+	}
+	if err = s.repo.DeleteEmailChange(ctx, authID, change.NewEmail); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", ErrEmailChangeNotFound
+		}
+		return "", err
+	}
+	normalized, err := emailpolicy.Normalize(change.NewEmail)
+	if err != nil {
+		return "", err
+	}
+	if err = s.repo.KillOrphanedOTPs(ctx, authRow.EmailNormalized); err != nil {
+		return "", err
+	}
+	if err = s.repo.UpdateEmail(ctx, authID, change.NewEmail, normalized); err != nil {
+		if errors.Is(err, qqerrors.ErrUniqueViolation) {
+			return "", ErrEmailInUse
+		}
+		return "", err
+	}
+	return authRow.Email, nil
+}
+
+// RequestDeletion schedules the account to be purged once gracePeriod has
+// passed. Until then CheckSuspension blocks it and the returned restore token
+// cancels the deletion.
+func (s *service) RequestDeletion(
+	ctx context.Context, authID pgtype.UUID, gracePeriod time.Duration) (*DeletionRequest, error) {
+	randomBytes := make([]byte, restoreTokenBytesLength)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, err
+	}
+	restoreToken := hex.EncodeToString(randomBytes)
+	tokenHash := sha256.Sum256([]byte(restoreToken))
+
+	deletion, err := s.repo.CreateDeletion(ctx, db.InsertAuthDeletionParams{
+		AuthID:           authID,
+		RestoreTokenHash: hex.EncodeToString(tokenHash[:]),
+		GraceSeconds:     gracePeriod.Seconds(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &DeletionRequest{RestoreToken: restoreToken, PurgeAfter: deletion.PurgeAfter.Time}, nil
+}
+
+// RestoreDeletion cancels the deletion the restore token was issued for and
+// returns the account. After the grace period it is ErrInvalidRestoreToken.
+func (s *service) RestoreDeletion(ctx context.Context, restoreToken string) (pgtype.UUID, error) {
+	tokenHash := sha256.Sum256([]byte(strings.TrimSpace(restoreToken)))
+	authID, err := s.repo.RestoreDeletion(ctx, hex.EncodeToString(tokenHash[:]))
+	if errors.Is(err, ErrNotFound) {
+		return pgtype.UUID{}, ErrInvalidRestoreToken
+	}
+	return authID, err
+}
+
+// ListDueDeletions returns up to limit accounts whose grace period has ended,
+// together with their avatar key and stored data export archives.
+func (s *service) ListDueDeletions(ctx context.Context, limit int32) ([]db.ListDueAuthDeletionsRow, error) {
+	return s.repo.ListDueDeletions(ctx, limit)
+}
+
+// PurgeAccount deletes the auth row of a due deletion; the user, sessions,
+// codes and every other row tied to it go with it through the cascades. It is
+// ErrNotFound when the deletion was restored or is not due yet.
+func (s *service) PurgeAccount(ctx context.Context, authID pgtype.UUID) error {
+	return s.repo.DeleteDueAuth(ctx, authID)
+}
+
+func (s *service) HasIdentity(ctx context.Context, authID pgtype.UUID, provider db.AuthProvider) (bool, error) {
+	return s.repo.HasIdentity(ctx, authID, provider)
+}
+
+func (s *service) ListIdentities(ctx context.Context, authID pgtype.UUID) ([]db.AuthIdentity, error) {
+	return s.repo.ListIdentities(ctx, authID)
+}
+
+func (s *service) LinkIdentity(ctx context.Context, params db.InsertAuthIdentityParams) (*db.AuthIdentity, error) {
+	identity, err := s.repo.CreateIdentity(ctx, params)
+	if err != nil {
+		if errors.Is(err, qqerrors.ErrUniqueViolation) {
+			return nil, ErrIdentityLinked
+		}
+		return nil, err
+	}
+	return identity, nil
+}
+
+// UnlinkIdentity removes an identity from the account unless it is the last one.
+// The auth row is locked first so two concurrent unlinks cannot both pass the
+// count check; run it inside a transaction for the lock to hold.
+func (s *service) UnlinkIdentity(ctx context.Context, authID pgtype.UUID, identityID pgtype.UUID) error {
+	if err := s.repo.LockAuth(ctx, authID); err != nil {
+		return err
+	}
+	count, err := s.repo.CountIdentities(ctx, authID)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return ErrLastIdentity
+	}
+	return s.repo.DeleteIdentity(ctx, authID, identityID)
+}
+
 func (s *service) GenerateAndSaveOTPForAuth(ctx context.Context, authID pgtype.UUID) (string, error) {
-	otpCodeBytesLength := 3
-	randomBytes := make([]byte, otpCodeBytesLength)
-	_, err := rand.Read(randomBytes)
+	otpCode, otpHash, err := newOTPCode()
 	if err != nil {
 		return "", err
 	}
+
+	if err = s.repo.CreateOTP(ctx, authID, otpHash, s.otpLifetime); err != nil {
+		return "", err
+	}
+
+	return otpCode, nil
+}
+
+// newOTPCode returns a random six character code and its SHA-256 hash.
+func newOTPCode() (string, string, error) {
+	otpCodeBytesLength := 3
+	randomBytes := make([]byte, otpCodeBytesLength)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", "", err
+	}
 	otpCode := strings.ToUpper(hex.EncodeToString(randomBytes))
 	otpHash := sha256.Sum256([]byte(otpCode))
+	return otpCode, hex.EncodeToString(otpHash[:]), nil
+}
 
-	if err = s.repo.CreateOTP(ctx, authID, hex.EncodeToString(otpHash[:])); err != nil {
+// GenerateAndSaveMagicLinkForAuth creates the random nonce carried by a magic
+// link. Only its hash is stored; the caller signs the nonce into the link.
+func (s *service) GenerateAndSaveMagicLinkForAuth(ctx context.Context, authID pgtype.UUID) (string, error) {
+	randomBytes := make([]byte, magicLinkNonceBytesLength)
+	if _, err := rand.Read(randomBytes); err != nil {
 		return "", err
 	}
+	nonce := hex.EncodeToString(randomBytes)
+	nonceHash := sha256.Sum256([]byte(nonce))
 
-	return otpCode, nil
+	if err := s.repo.CreateMagicLink(ctx, authID, hex.EncodeToString(nonceHash[:]), s.otpLifetime); err != nil {
+		return "", err
+	}
+	return nonce, nil
+}
+
+// ConsumeMagicLink accepts the nonce of an active magic link of the user exactly once.
+func (s *service) ConsumeMagicLink(ctx context.Context, userID pgtype.UUID, nonce string) error {
+	nonceHash := sha256.Sum256([]byte(nonce))
+	err := s.repo.ConsumeMagicLink(ctx, userID, hex.EncodeToString(nonceHash[:]))
+	if errors.Is(err, ErrNotFound) {
+		return ErrInvalidMagicLink
+	}
+	return err
+}
+
+// StartTOTPEnrollment creates a new authenticator secret for the account. It
+// stays pending, and login is unaffected, until ConfirmTOTPEnrollment accepts a
+// code generated from it. Starting again replaces a pending secret.
+func (s *service) StartTOTPEnrollment(ctx context.Context, authID pgtype.UUID) (*TOTPEnrollment, error) {
+	authRow, err := s.repo.GetAuthByID(ctx, authID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.repo.CreatePendingTOTP(ctx, authID, secret); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrTOTPAlreadyEnabled
+		}
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(s.totpIssuer, authRow.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment turns on two-factor authentication once the code proves
+// the authenticator holds the pending secret, and returns a fresh set of
+// recovery codes. Only their hashes are stored, so they are shown this once.
+// Run it inside a transaction so the codes are replaced together.
+func (s *service) ConfirmTOTPEnrollment(ctx context.Context, authID pgtype.UUID, code string) ([]string, error) {
+	record, err := s.repo.GetTOTP(ctx, authID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrTOTPNotEnabled
+		}
+		return nil, err
+	}
+	if record.ConfirmedAt.Valid {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	step, ok := totp.Validate(record.Secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return nil, ErrTOTPCodeMismatch
+	}
+	if err = s.repo.ConfirmTOTP(ctx, authID, step); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrTOTPAlreadyEnabled
+		}
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		randomBytes := make([]byte, recoveryCodeBytesLength)
+		if _, err = rand.Read(randomBytes); err != nil {
+			return nil, err
+		}
+		raw := hex.EncodeToString(randomBytes)
+		codes = append(codes, raw[:len(raw)/2]+"-"+raw[len(raw)/2:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	if err = s.repo.ReplaceRecoveryCodes(ctx, authID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *service) IsTOTPEnabled(ctx context.Context, authID pgtype.UUID) (bool, error) {
+	record, err := s.repo.GetTOTP(ctx, authID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return record.ConfirmedAt.Valid, nil
+}
+
+// ResetTOTPAttempts gives a new login challenge its own budget of second-factor
+// attempts. Each challenge requires passing the first factor again.
+func (s *service) ResetTOTPAttempts(ctx context.Context, authID pgtype.UUID) error {
+	return s.repo.ResetTOTPAttempts(ctx, authID)
+}
+
+// VerifySecondFactor accepts a current authenticator code or an unused recovery
+// code. Like VerifyOTP it consumes an attempt before comparing and must run
+// outside a transaction so failed attempts persist. Authenticator codes are
+// accepted once; recovery codes are used up.
+func (s *service) VerifySecondFactor(ctx context.Context, authID pgtype.UUID, code string) error {
+	record, err := s.repo.GetTOTP(ctx, authID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrTOTPNotEnabled
+		}
+		return err
+	}
+	if !record.ConfirmedAt.Valid {
+		return ErrTOTPNotEnabled
+	}
+
+	attempts, err := s.repo.IncrementTOTPAttempts(ctx, authID)
+	if err != nil {
+		return err
+	}
+	if attempts > s.maxOTPAttempts {
+		return ErrTOTPAttemptsExceeded
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(record.Secret, code, time.Now()); ok {
+		err = s.repo.UseTOTPStep(ctx, authID, step)
+		if errors.Is(err, ErrNotFound) {
+			return ErrInvalidTOTPCode
+		}
+		return err
+	}
+
+	if len(code) != totp.Digits {
+		err = s.repo.UseRecoveryCode(ctx, authID, hashRecoveryCode(code))
+		if err == nil {
+			return s.repo.ResetTOTPAttempts(ctx, authID)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return ErrInvalidTOTPCode
+}
+
+// hashRecoveryCode ignores case, spaces and the separator dash so a code can be
+// typed back the way it reads.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
 }
 
 func (s *service) KillOrphanedOTPsByUserID(ctx context.Context, userID pgtype.UUID) error {
 	return s.repo.KillOrphanedOTPsByUserID(ctx, userID)
 }
 
-func (s *service) KillOrphanedOTPs(ctx context.Context, email string) error {
-	return s.repo.KillOrphanedOTPs(ctx, email)
+// KillOrphanedOTPs deletes the codes and links of the account with the
+// normalized email.
+func (s *service) KillOrphanedOTPs(ctx context.Context, normalizedEmail string) error {
+	return s.repo.KillOrphanedOTPs(ctx, normalizedEmail)
 }
 
-func (s *service) VerifyOTP(ctx context.Context, email string, otpCode string) error {
-	otpHash := sha256.Sum256([]byte(otpCode))
-	usr, err := s.repo.GetUserIDAndEmailByOTPCode(ctx, hex.EncodeToString(otpHash[:]))
+// CheckOTPResendCooldown refuses another code or link while the newest one of
+// the account is younger than the resend cooldown; the error carries the time
+// left. The auth row is locked first so concurrent requests cannot both pass
+// the check; run it inside a transaction for the lock to hold.
+func (s *service) CheckOTPResendCooldown(ctx context.Context, authID pgtype.UUID) error {
+	if s.resendCooldown <= 0 {
+		return nil
+	}
+	if err := s.repo.LockAuth(ctx, authID); err != nil {
+		return err
+	}
+	age, err := s.repo.GetLatestOTPAge(ctx, authID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	if usr.Email != email {
-		return ErrInvalidOtpCode
+	if wait := s.resendCooldown - age; wait > 0 {
+		return &qqerrors.RetryAfterError{Err: ErrOtpResendTooSoon, RetryAfter: wait}
 	}
 	return nil
 }
+
+// PruneSupersededOTPs clears the account's codes and links before a new one is
+// sent, keeping only the newest pending one so an email still in flight works.
+func (s *service) PruneSupersededOTPs(ctx context.Context, authID pgtype.UUID) error {
+	return s.repo.PruneSupersededOTPs(ctx, authID)
+}
+
+// VerifyOTP checks the code against the active OTPs of the account with the
+// normalized email and returns the ID of the user it belongs to. Every call
+// consumes one attempt before the code is compared, so concurrent guesses are
+// serialized by the database and the code is invalidated once the limit is hit. An email without an active code,
+// because it expired or was never sent, is ErrInvalidOtpCode like a wrong code.
+func (s *service) VerifyOTP(ctx context.Context, normalizedEmail string, otpCode string) (pgtype.UUID, error) {
+	attempts, err := s.repo.IncrementOTPAttempts(ctx, normalizedEmail)
+	if errors.Is(err, ErrNotFound) {
+		return pgtype.UUID{}, ErrInvalidOtpCode
+	}
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	if attempts > s.maxOTPAttempts {
+		return pgtype.UUID{}, ErrOtpAttemptsExceeded
+	}
+
+	otpHash := sha256.Sum256([]byte(otpCode))
+	row, err := s.repo.GetActiveOTPByEmailAndHash(ctx, normalizedEmail, hex.EncodeToString(otpHash[:]))
+	if err == nil {
+		return row.ID, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return pgtype.UUID{}, err
+	}
+
+	if attempts >= s.maxOTPAttempts {
+		if killErr := s.repo.KillOrphanedOTPs(ctx, normalizedEmail); killErr != nil {
+			return pgtype.UUID{}, killErr
+		}
+		return pgtype.UUID{}, ErrOtpAttemptsExceeded
+	}
+	return pgtype.UUID{}, ErrInvalidOtpCode
+}
+
+func (s *service) SaveRefreshToken(ctx context.Context, params db.InsertRefreshTokenParams) error {
+	return s.repo.CreateRefreshToken(ctx, params)
+}
+
+// RotateRefreshToken consumes the refresh token so it can be exchanged exactly
+// once and returns it; the caller issues the successor in the same family.
+// Presenting a token that was already used or revoked is treated as theft and
+// revokes every token of its family along with its session. Run it outside a
+// transaction so the revocation persists even though the request fails.
+func (s *service) RotateRefreshToken(
+	ctx context.Context, tokenID pgtype.UUID, userID pgtype.UUID) (*db.RefreshToken, error) {
+	token, err := s.repo.UseRefreshToken(ctx, tokenID)
+	if err == nil {
+		if token.UserID != userID {
+			return nil, ErrInvalidRefreshToken
+		}
+		return token, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	stored, err := s.repo.GetRefreshToken(ctx, tokenID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if stored.UsedAt.Valid || stored.RevokedAt.Valid {
+		if revokeErr := s.repo.RevokeRefreshTokenFamily(ctx, stored.FamilyID); revokeErr != nil {
+			return nil, revokeErr
+		}
+		if stored.SessionID.Valid {
+			revokeErr := s.repo.RevokeSession(ctx, stored.UserID, stored.SessionID)
+			if revokeErr != nil && !errors.Is(revokeErr, ErrNotFound) {
+				return nil, revokeErr
+			}
+			s.sessionRevoked(stored.SessionID)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	return nil, ErrInvalidRefreshToken
+}
+
+func (s *service) CreateSession(ctx context.Context, params db.InsertSessionParams) (*db.Session, error) {
+	return s.repo.CreateSession(ctx, params)
+}
+
+func (s *service) ListSessions(ctx context.Context, userID pgtype.UUID) ([]db.Session, error) {
+	return s.repo.ListActiveSessions(ctx, userID)
+}
+
+// MarkFirstLogin records the first completed login of the account and reports
+// whether this call was the one that recorded it. Concurrent logins cannot
+// both be reported as first.
+func (s *service) MarkFirstLogin(ctx context.Context, authID pgtype.UUID) (bool, error) {
+	return s.repo.MarkFirstLogin(ctx, authID)
+}
+
+// IsSessionActive reports whether the session exists, belongs to the user and
+// has not been revoked.
+func (s *service) IsSessionActive(ctx context.Context, sessionID pgtype.UUID, userID pgtype.UUID) (bool, error) {
+	session, err := s.repo.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return session.UserID == userID && !session.RevokedAt.Valid, nil
+}
+
+func (s *service) TouchSession(ctx context.Context, sessionID pgtype.UUID) error {
+	return s.repo.TouchSession(ctx, sessionID)
+}
+
+// RevokeSession ends one of the user's sessions and revokes its refresh tokens.
+// Sessions of other users and already revoked ones return ErrNotFound.
+func (s *service) RevokeSession(ctx context.Context, userID pgtype.UUID, sessionID pgtype.UUID) error {
+	if err := s.repo.RevokeSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	s.sessionRevoked(sessionID)
+	return s.repo.RevokeSessionRefreshTokens(ctx, sessionID)
+}
+
+// RevokeAllSessions ends every session of the user and revokes all refresh tokens.
+func (s *service) RevokeAllSessions(ctx context.Context, userID pgtype.UUID) error {
+	if err := s.repo.RevokeUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	for _, listener := range s.revocations {
+		listener.UserSessionsRevoked(userID)
+	}
+	return s.repo.RevokeUserRefreshTokens(ctx, userID)
+}
+
+func (s *service) sessionRevoked(sessionID pgtype.UUID) {
+	for _, listener := range s.revocations {
+		listener.SessionRevoked(sessionID)
+	}
+}
@@ -2,25 +2,53 @@ package auth_test
 
 import (
 	"context"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/abdurrahimagca/qq-back/internal/auth"
 	"github.com/abdurrahimagca/qq-back/internal/db"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type fakeOTP struct {
+	hash     string
+	kind     db.OtpKind
+	lifetime time.Duration
+	row      db.GetActiveOtpCodesByEmailRow
+}
+
 type fakeRepositoryState struct {
 	mu                      sync.Mutex
 	emailsByAuthID          map[string]string
-	otpsByHash              map[string]db.GetUserIdAndEmailByOtpCodeRow
+	normalizedByAuthID      map[string]string
+	firstLogins             map[string]bool
+	identities              []db.AuthIdentity
+	lockedAuthIDs           []pgtype.UUID
+	refreshTokens           map[string]db.RefreshToken
+	revokedFamilies         []pgtype.UUID
+	sessions                map[string]db.Session
+	totps                   map[string]db.AuthTotp
+	recoveryCodes           map[string][]string
+	otps                    []fakeOTP
 	userIDByAuthID          map[string]pgtype.UUID
+	attemptsByEmail         map[string]int32
 	killOrphanedEmails      []string
 	killOrphanedUserIDs     []pgtype.UUID
+	latestOTPAges           map[string]time.Duration
+	suspensions             map[string]db.SuspendAuthParams
+	suspensionEvents        []db.AuthSuspensionEvent
+	emailChanges            map[string]db.AuthEmailChange
+	emailChangeAges         map[string]time.Duration
+	deletions               map[string]db.AuthDeletion
 	createAuthErr           error
 	nextAuthID              *pgtype.UUID
 	createOTPErr            error
 	getOtpErr               error
+	incrementAttemptsErr    error
 	killOrphanedErr         error
 	killOrphanedByUserIDErr error
 	withTxCount             int
@@ -35,10 +63,24 @@ func newFakeRepository() *fakeRepository {
 	return &fakeRepository{
 		state: &fakeRepositoryState{
 			emailsByAuthID:      make(map[string]string),
-			otpsByHash:          make(map[string]db.GetUserIdAndEmailByOtpCodeRow),
+			normalizedByAuthID:  make(map[string]string),
+			firstLogins:         make(map[string]bool),
+			identities:          make([]db.AuthIdentity, 0),
+			refreshTokens:       make(map[string]db.RefreshToken),
+			sessions:            make(map[string]db.Session),
+			totps:               make(map[string]db.AuthTotp),
+			recoveryCodes:       make(map[string][]string),
+			otps:                make([]fakeOTP, 0),
 			userIDByAuthID:      make(map[string]pgtype.UUID),
+			attemptsByEmail:     make(map[string]int32),
 			killOrphanedEmails:  make([]string, 0),
 			killOrphanedUserIDs: make([]pgtype.UUID, 0),
+			latestOTPAges:       make(map[string]time.Duration),
+			suspensions:         make(map[string]db.SuspendAuthParams),
+			suspensionEvents:    make([]db.AuthSuspensionEvent, 0),
+			emailChanges:        make(map[string]db.AuthEmailChange),
+			emailChangeAges:     make(map[string]time.Duration),
+			deletions:           make(map[string]db.AuthDeletion),
 		},
 	}
 }
@@ -51,13 +93,30 @@ func (f *fakeRepository) WithTx(tx pgx.Tx) auth.Repository {
 	return &fakeRepository{state: f.state}
 }
 
-func (f *fakeRepository) CreateAuthForOTPLogin(ctx context.Context, email string) (*pgtype.UUID, error) {
+func (f *fakeRepository) CreateAuthForOTPLogin(
+	ctx context.Context, email string, normalizedEmail string) (*pgtype.UUID, error) {
+	return f.createAuthWithIdentity(email, normalizedEmail, db.AuthProviderEmailOtp, email)
+}
+
+func (f *fakeRepository) CreateAuthForOAuthLogin(
+	ctx context.Context, email string, normalizedEmail string, provider db.AuthProvider, providerID string,
+) (*pgtype.UUID, error) {
+	return f.createAuthWithIdentity(email, normalizedEmail, provider, providerID)
+}
+
+func (f *fakeRepository) createAuthWithIdentity(
+	email string, normalizedEmail string, provider db.AuthProvider, providerID string) (*pgtype.UUID, error) {
 	f.state.mu.Lock()
 	defer f.state.mu.Unlock()
 
 	if f.state.createAuthErr != nil {
 		return nil, f.state.createAuthErr
 	}
+	for _, existing := range f.state.normalizedByAuthID {
+		if existing == normalizedEmail {
+			return nil, qqerrors.ErrUniqueViolation
+		}
+	}
 
 	var id pgtype.UUID
 	if f.state.nextAuthID != nil {
@@ -68,11 +127,387 @@ func (f *fakeRepository) CreateAuthForOTPLogin(ctx context.Context, email string
 	}
 
 	f.state.emailsByAuthID[uuidToString(id)] = email
+	f.state.normalizedByAuthID[uuidToString(id)] = normalizedEmail
+	f.state.identities = append(f.state.identities, db.AuthIdentity{
+		ID:         newPGUUID(),
+		AuthID:     id,
+		Provider:   provider,
+		ProviderID: providerID,
+		Email:      email,
+	})
 	idCopy := id
 	return &idCopy, nil
 }
 
-func (f *fakeRepository) CreateOTP(ctx context.Context, authID pgtype.UUID, otpHash string) error {
+func (f *fakeRepository) GetAuthByProvider(
+	ctx context.Context, provider db.AuthProvider, providerID string) (*db.Auth, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	for _, identity := range f.state.identities {
+		if identity.Provider == provider && identity.ProviderID == providerID {
+			return &db.Auth{
+				ID:              identity.AuthID,
+				Email:           f.state.emailsByAuthID[uuidToString(identity.AuthID)],
+				EmailNormalized: f.state.normalizedByAuthID[uuidToString(identity.AuthID)],
+			}, nil
+		}
+	}
+	return nil, auth.ErrNotFound
+}
+
+func (f *fakeRepository) GetAuthByID(ctx context.Context, authID pgtype.UUID) (*db.Auth, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	email, ok := f.state.emailsByAuthID[uuidToString(authID)]
+	if !ok {
+		return nil, auth.ErrNotFound
+	}
+	return &db.Auth{ID: authID, Email: email, EmailNormalized: f.state.normalizedByAuthID[uuidToString(authID)]}, nil
+}
+
+func (f *fakeRepository) LockAuth(ctx context.Context, authID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	if _, ok := f.state.emailsByAuthID[uuidToString(authID)]; !ok {
+		return auth.ErrNotFound
+	}
+	f.state.lockedAuthIDs = append(f.state.lockedAuthIDs, authID)
+	return nil
+}
+
+// GetActiveRestriction mirrors the query: a suspension whose end has passed is
+// not active, a pending deletion always is.
+func (f *fakeRepository) GetActiveRestriction(
+	ctx context.Context, authID pgtype.UUID) (*db.GetActiveAuthRestrictionRow, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	var row db.GetActiveAuthRestrictionRow
+	restricted := false
+	suspension, ok := f.state.suspensions[uuidToString(authID)]
+	if ok && (!suspension.SuspendedUntil.Valid || suspension.SuspendedUntil.Time.After(time.Now().UTC())) {
+		row.SuspensionReason = suspension.Reason
+		row.SuspendedUntil = suspension.SuspendedUntil
+		restricted = true
+	}
+	if deletion, pending := f.state.deletions[uuidToString(authID)]; pending {
+		row.PurgeAfter = deletion.PurgeAfter
+		restricted = true
+	}
+	if !restricted {
+		return nil, auth.ErrNotFound
+	}
+	return &row, nil
+}
+
+func (f *fakeRepository) SuspendAuth(ctx context.Context, params db.SuspendAuthParams) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	if _, ok := f.state.emailsByAuthID[uuidToString(params.ID)]; !ok {
+		return auth.ErrNotFound
+	}
+	f.state.suspensions[uuidToString(params.ID)] = params
+	return nil
+}
+
+func (f *fakeRepository) LiftSuspension(ctx context.Context, authID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	if _, ok := f.state.suspensions[uuidToString(authID)]; !ok {
+		return auth.ErrNotFound
+	}
+	delete(f.state.suspensions, uuidToString(authID))
+	return nil
+}
+
+func (f *fakeRepository) CreateSuspensionEvent(
+	ctx context.Context, params db.InsertAuthSuspensionEventParams) (*db.AuthSuspensionEvent, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	event := db.AuthSuspensionEvent{
+		ID:             newPGUUID(),
+		AuthID:         params.AuthID,
+		Action:         params.Action,
+		Reason:         params.Reason,
+		SuspendedUntil: params.SuspendedUntil,
+		Actor:          params.Actor,
+	}
+	f.state.suspensionEvents = append(f.state.suspensionEvents, event)
+	return &event, nil
+}
+
+func (f *fakeRepository) ListSuspensionEvents(
+	ctx context.Context, authID pgtype.UUID) ([]db.AuthSuspensionEvent, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	var events []db.AuthSuspensionEvent
+	for i := len(f.state.suspensionEvents) - 1; i >= 0; i-- {
+		if f.state.suspensionEvents[i].AuthID == authID {
+			events = append(events, f.state.suspensionEvents[i])
+		}
+	}
+	return events, nil
+}
+
+func (f *fakeRepository) EmailExists(ctx context.Context, normalizedEmail string) (bool, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	for _, existing := range f.state.normalizedByAuthID {
+		if existing == normalizedEmail {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeRepository) UpdateEmail(
+	ctx context.Context, authID pgtype.UUID, email string, normalizedEmail string) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	key := uuidToString(authID)
+	oldEmail, ok := f.state.emailsByAuthID[key]
+	if !ok {
+		return auth.ErrNotFound
+	}
+	for otherKey, existing := range f.state.normalizedByAuthID {
+		if otherKey != key && existing == normalizedEmail {
+			return qqerrors.ErrUniqueViolation
+		}
+	}
+	f.state.emailsByAuthID[key] = email
+	f.state.normalizedByAuthID[key] = normalizedEmail
+	for i, identity := range f.state.identities {
+		if identity.AuthID == authID && identity.Provider == db.AuthProviderEmailOtp &&
+			identity.ProviderID == oldEmail {
+			f.state.identities[i].ProviderID = email
+			f.state.identities[i].Email = email
+		}
+	}
+	return nil
+}
+
+// CreateEmailChange replaces any pending change of the account; the fake has no
+// clock, so the change expires lifetime after the call.
+func (f *fakeRepository) CreateEmailChange(
+	ctx context.Context, params db.UpsertAuthEmailChangeParams) (*db.AuthEmailChange, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	lifetime := time.Duration(params.LifetimeSeconds * float64(time.Second))
+	change := db.AuthEmailChange{
+		AuthID:          params.AuthID,
+		NewEmail:        params.NewEmail,
+		CurrentCodeHash: params.CurrentCodeHash,
+		NewCodeHash:     params.NewCodeHash,
+		ExpiresAt:       pgtype.Timestamp{Time: time.Now().UTC().Add(lifetime), Valid: true},
+		CreatedAt:       pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+	}
+	f.state.emailChanges[uuidToString(params.AuthID)] = change
+	f.state.emailChangeAges[uuidToString(params.AuthID)] = 0
+	return &change, nil
+}
+
+func (f *fakeRepository) GetEmailChangeAge(ctx context.Context, authID pgtype.UUID) (time.Duration, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	age, ok := f.state.emailChangeAges[uuidToString(authID)]
+	if !ok {
+		return 0, auth.ErrNotFound
+	}
+	return age, nil
+}
+
+func (f *fakeRepository) GetActiveEmailChange(ctx context.Context, authID pgtype.UUID) (*db.AuthEmailChange, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	change, ok := f.state.emailChanges[uuidToString(authID)]
+	if !ok {
+		return nil, auth.ErrNotFound
+	}
+	return &change, nil
+}
+
+func (f *fakeRepository) IncrementEmailChangeAttempts(ctx context.Context, authID pgtype.UUID) (int32, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	change, ok := f.state.emailChanges[uuidToString(authID)]
+	if !ok {
+		return 0, auth.ErrNotFound
+	}
+	change.Attempts++
+	f.state.emailChanges[uuidToString(authID)] = change
+	return change.Attempts, nil
+}
+
+func (f *fakeRepository) DeleteEmailChange(ctx context.Context, authID pgtype.UUID, newEmail string) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	change, ok := f.state.emailChanges[uuidToString(authID)]
+	if !ok || change.NewEmail != newEmail {
+		return auth.ErrNotFound
+	}
+	delete(f.state.emailChanges, uuidToString(authID))
+	delete(f.state.emailChangeAges, uuidToString(authID))
+	return nil
+}
+
+func (f *fakeRepository) DeleteEmailChanges(ctx context.Context, authID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	delete(f.state.emailChanges, uuidToString(authID))
+	delete(f.state.emailChangeAges, uuidToString(authID))
+	return nil
+}
+
+// CreateDeletion fails with a unique violation while a deletion is pending, like
+// the primary key on auth_deletions.
+func (f *fakeRepository) CreateDeletion(
+	ctx context.Context, params db.InsertAuthDeletionParams) (*db.AuthDeletion, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	if _, ok := f.state.deletions[uuidToString(params.AuthID)]; ok {
+		return nil, qqerrors.GetDBErrAsQQError(&pgconn.PgError{Code: qqerrors.SQLUniqueViolation})
+	}
+	grace := time.Duration(params.GraceSeconds * float64(time.Second))
+	now := time.Now().UTC()
+	deletion := db.AuthDeletion{
+		AuthID:           params.AuthID,
+		RestoreTokenHash: params.RestoreTokenHash,
+		RequestedAt:      pgtype.Timestamp{Time: now, Valid: true},
+		PurgeAfter:       pgtype.Timestamp{Time: now.Add(grace), Valid: true},
+	}
+	f.state.deletions[uuidToString(params.AuthID)] = deletion
+	return &deletion, nil
+}
+
+func (f *fakeRepository) RestoreDeletion(ctx context.Context, restoreTokenHash string) (pgtype.UUID, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	for key, deletion := range f.state.deletions {
+		if deletion.RestoreTokenHash == restoreTokenHash && deletion.PurgeAfter.Time.After(time.Now().UTC()) {
+			delete(f.state.deletions, key)
+			return deletion.AuthID, nil
+		}
+	}
+	return pgtype.UUID{}, auth.ErrNotFound
+}
+
+// ListDueDeletions has no users or exports to join, so the avatar key and the
+// export archive keys are never set.
+func (f *fakeRepository) ListDueDeletions(ctx context.Context, limit int32) ([]db.ListDueAuthDeletionsRow, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	rows := make([]db.ListDueAuthDeletionsRow, 0)
+	for _, deletion := range f.state.deletions {
+		if int32(len(rows)) >= limit {
+			break
+		}
+		if !deletion.PurgeAfter.Time.After(time.Now().UTC()) {
+			rows = append(rows, db.ListDueAuthDeletionsRow{AuthID: deletion.AuthID})
+		}
+	}
+	return rows, nil
+}
+
+func (f *fakeRepository) DeleteDueAuth(ctx context.Context, authID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	deletion, ok := f.state.deletions[uuidToString(authID)]
+	if !ok || deletion.PurgeAfter.Time.After(time.Now().UTC()) {
+		return auth.ErrNotFound
+	}
+	delete(f.state.deletions, uuidToString(authID))
+	delete(f.state.emailsByAuthID, uuidToString(authID))
+	delete(f.state.normalizedByAuthID, uuidToString(authID))
+	return nil
+}
+
+func (f *fakeRepository) CreateIdentity(
+	ctx context.Context, params db.InsertAuthIdentityParams) (*db.AuthIdentity, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	for _, identity := range f.state.identities {
+		if identity.Provider == params.Provider && identity.ProviderID == params.ProviderID {
+			return nil, qqerrors.GetDBErrAsQQError(&pgconn.PgError{Code: qqerrors.SQLUniqueViolation})
+		}
+	}
+
+	identity := db.AuthIdentity{
+		ID:         newPGUUID(),
+		AuthID:     params.AuthID,
+		Provider:   params.Provider,
+		ProviderID: params.ProviderID,
+		Email:      params.Email,
+	}
+	f.state.identities = append(f.state.identities, identity)
+	return &identity, nil
+}
+
+func (f *fakeRepository) ListIdentities(ctx context.Context, authID pgtype.UUID) ([]db.AuthIdentity, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	identities := make([]db.AuthIdentity, 0)
+	for _, identity := range f.state.identities {
+		if identity.AuthID == authID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (f *fakeRepository) CountIdentities(ctx context.Context, authID pgtype.UUID) (int64, error) {
+	identities, err := f.ListIdentities(ctx, authID)
+	return int64(len(identities)), err
+}
+
+func (f *fakeRepository) HasIdentity(
+	ctx context.Context, authID pgtype.UUID, provider db.AuthProvider) (bool, error) {
+	identities, err := f.ListIdentities(ctx, authID)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(identities, func(identity db.AuthIdentity) bool {
+		return identity.Provider == provider
+	}), nil
+}
+
+func (f *fakeRepository) DeleteIdentity(ctx context.Context, authID pgtype.UUID, identityID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	before := len(f.state.identities)
+	f.state.identities = slices.DeleteFunc(f.state.identities, func(identity db.AuthIdentity) bool {
+		return identity.ID == identityID && identity.AuthID == authID
+	})
+	if len(f.state.identities) == before {
+		return auth.ErrNotFound
+	}
+	return nil
+}
+
+func (f *fakeRepository) CreateOTP(
+	ctx context.Context, authID pgtype.UUID, otpHash string, lifetime time.Duration,
+) error {
 	f.state.mu.Lock()
 	defer f.state.mu.Unlock()
 
@@ -80,7 +515,7 @@ func (f *fakeRepository) CreateOTP(ctx context.Context, authID pgtype.UUID, otpH
 		return f.state.createOTPErr
 	}
 
-	entry := db.GetUserIdAndEmailByOtpCodeRow{AuthID: authID}
+	entry := db.GetActiveOtpCodesByEmailRow{AuthID: authID, Code: otpHash}
 	if email, ok := f.state.emailsByAuthID[uuidToString(authID)]; ok {
 		entry.Email = email
 	}
@@ -88,24 +523,38 @@ func (f *fakeRepository) CreateOTP(ctx context.Context, authID pgtype.UUID, otpH
 		entry.ID = userID
 	}
 
-	f.state.otpsByHash[otpHash] = entry
+	f.state.otps = append(f.state.otps, fakeOTP{hash: otpHash, kind: db.OtpKindCode, lifetime: lifetime, row: entry})
 	return nil
 }
 
-func (f *fakeRepository) GetUserIDAndEmailByOTPCode(
-	ctx context.Context, otpHash string) (db.GetUserIdAndEmailByOtpCodeRow, error) {
+func (f *fakeRepository) GetActiveOTPByEmailAndHash(
+	ctx context.Context, email string, otpHash string) (db.GetActiveOtpCodesByEmailRow, error) {
 	f.state.mu.Lock()
 	defer f.state.mu.Unlock()
 
 	if f.state.getOtpErr != nil {
-		return db.GetUserIdAndEmailByOtpCodeRow{}, f.state.getOtpErr
+		return db.GetActiveOtpCodesByEmailRow{}, f.state.getOtpErr
 	}
 
-	if entry, ok := f.state.otpsByHash[otpHash]; ok {
-		return entry, nil
+	for _, otp := range f.state.otps {
+		if otp.kind != db.OtpKindMagicLink && otp.row.Email == email && otp.hash == otpHash {
+			return otp.row, nil
+		}
 	}
 
-	return db.GetUserIdAndEmailByOtpCodeRow{}, auth.ErrNotFound
+	return db.GetActiveOtpCodesByEmailRow{}, auth.ErrNotFound
+}
+
+func (f *fakeRepository) IncrementOTPAttempts(ctx context.Context, email string) (int32, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	if f.state.incrementAttemptsErr != nil {
+		return 0, f.state.incrementAttemptsErr
+	}
+
+	f.state.attemptsByEmail[email]++
+	return f.state.attemptsByEmail[email], nil
 }
 
 func (f *fakeRepository) KillOrphanedOTPs(ctx context.Context, email string) error {
@@ -118,11 +567,10 @@ func (f *fakeRepository) KillOrphanedOTPs(ctx context.Context, email string) err
 		return f.state.killOrphanedErr
 	}
 
-	for hash, entry := range f.state.otpsByHash {
-		if entry.Email == email {
-			delete(f.state.otpsByHash, hash)
-		}
-	}
+	f.state.otps = slices.DeleteFunc(f.state.otps, func(otp fakeOTP) bool {
+		return otp.row.Email == email
+	})
+	delete(f.state.attemptsByEmail, email)
 
 	return nil
 }
@@ -137,12 +585,351 @@ func (f *fakeRepository) KillOrphanedOTPsByUserID(ctx context.Context, userID pg
 		return f.state.killOrphanedByUserIDErr
 	}
 
-	for hash, entry := range f.state.otpsByHash {
-		if entry.ID == userID {
-			delete(f.state.otpsByHash, hash)
+	f.state.otps = slices.DeleteFunc(f.state.otps, func(otp fakeOTP) bool {
+		return otp.row.ID == userID
+	})
+
+	return nil
+}
+
+func (f *fakeRepository) GetLatestOTPAge(ctx context.Context, authID pgtype.UUID) (time.Duration, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	age, ok := f.state.latestOTPAges[uuidToString(authID)]
+	if !ok {
+		return 0, auth.ErrNotFound
+	}
+	return age, nil
+}
+
+// PruneSupersededOTPs keeps the most recently created entry of the account; the
+// fake has no clock, so nothing counts as expired.
+func (f *fakeRepository) PruneSupersededOTPs(ctx context.Context, authID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	newest := -1
+	for i, otp := range f.state.otps {
+		if otp.row.AuthID == authID {
+			newest = i
+		}
+	}
+	kept := f.state.otps[:0]
+	for i, otp := range f.state.otps {
+		if otp.row.AuthID != authID || i == newest {
+			kept = append(kept, otp)
+		}
+	}
+	f.state.otps = kept
+	return nil
+}
+
+func (f *fakeRepository) CreateMagicLink(
+	ctx context.Context, authID pgtype.UUID, tokenHash string, lifetime time.Duration,
+) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	if f.state.createOTPErr != nil {
+		return f.state.createOTPErr
+	}
+
+	entry := db.GetActiveOtpCodesByEmailRow{AuthID: authID, Code: tokenHash}
+	if email, ok := f.state.emailsByAuthID[uuidToString(authID)]; ok {
+		entry.Email = email
+	}
+	if userID, ok := f.state.userIDByAuthID[uuidToString(authID)]; ok {
+		entry.ID = userID
+	}
+
+	f.state.otps = append(f.state.otps, fakeOTP{hash: tokenHash, kind: db.OtpKindMagicLink, lifetime: lifetime, row: entry})
+	return nil
+}
+
+func (f *fakeRepository) ConsumeMagicLink(ctx context.Context, userID pgtype.UUID, tokenHash string) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	before := len(f.state.otps)
+	f.state.otps = slices.DeleteFunc(f.state.otps, func(otp fakeOTP) bool {
+		return otp.kind == db.OtpKindMagicLink && otp.row.ID == userID && otp.hash == tokenHash
+	})
+	if len(f.state.otps) == before {
+		return auth.ErrNotFound
+	}
+	return nil
+}
+
+func (f *fakeRepository) CreatePendingTOTP(
+	ctx context.Context, authID pgtype.UUID, secret string) (*db.AuthTotp, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	key := uuidToString(authID)
+	if f.state.totps[key].ConfirmedAt.Valid {
+		return nil, auth.ErrNotFound
+	}
+	record := db.AuthTotp{AuthID: authID, Secret: secret}
+	f.state.totps[key] = record
+	return &record, nil
+}
+
+func (f *fakeRepository) GetTOTP(ctx context.Context, authID pgtype.UUID) (*db.AuthTotp, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	record, ok := f.state.totps[uuidToString(authID)]
+	if !ok {
+		return nil, auth.ErrNotFound
+	}
+	return &record, nil
+}
+
+func (f *fakeRepository) ConfirmTOTP(ctx context.Context, authID pgtype.UUID, step int64) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	key := uuidToString(authID)
+	record, ok := f.state.totps[key]
+	if !ok || record.ConfirmedAt.Valid {
+		return auth.ErrNotFound
+	}
+	record.ConfirmedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	record.LastUsedStep = step
+	f.state.totps[key] = record
+	return nil
+}
+
+func (f *fakeRepository) UseTOTPStep(ctx context.Context, authID pgtype.UUID, step int64) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	key := uuidToString(authID)
+	record, ok := f.state.totps[key]
+	if !ok || !record.ConfirmedAt.Valid || record.LastUsedStep >= step {
+		return auth.ErrNotFound
+	}
+	record.LastUsedStep = step
+	record.FailedAttempts = 0
+	f.state.totps[key] = record
+	return nil
+}
+
+func (f *fakeRepository) IncrementTOTPAttempts(ctx context.Context, authID pgtype.UUID) (int32, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	key := uuidToString(authID)
+	record, ok := f.state.totps[key]
+	if !ok || !record.ConfirmedAt.Valid {
+		return 0, auth.ErrNotFound
+	}
+	record.FailedAttempts++
+	f.state.totps[key] = record
+	return record.FailedAttempts, nil
+}
+
+func (f *fakeRepository) ResetTOTPAttempts(ctx context.Context, authID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	key := uuidToString(authID)
+	if record, ok := f.state.totps[key]; ok {
+		record.FailedAttempts = 0
+		f.state.totps[key] = record
+	}
+	return nil
+}
+
+func (f *fakeRepository) ReplaceRecoveryCodes(ctx context.Context, authID pgtype.UUID, codeHashes []string) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	f.state.recoveryCodes[uuidToString(authID)] = slices.Clone(codeHashes)
+	return nil
+}
+
+func (f *fakeRepository) UseRecoveryCode(ctx context.Context, authID pgtype.UUID, codeHash string) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	key := uuidToString(authID)
+	index := slices.Index(f.state.recoveryCodes[key], codeHash)
+	if index < 0 {
+		return auth.ErrNotFound
+	}
+	f.state.recoveryCodes[key] = slices.Delete(f.state.recoveryCodes[key], index, index+1)
+	return nil
+}
+
+func (f *fakeRepository) CreateRefreshToken(ctx context.Context, params db.InsertRefreshTokenParams) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	f.state.refreshTokens[uuidToString(params.ID)] = db.RefreshToken{
+		ID:        params.ID,
+		UserID:    params.UserID,
+		FamilyID:  params.FamilyID,
+		ParentID:  params.ParentID,
+		ExpiresAt: params.ExpiresAt,
+		SessionID: params.SessionID,
+	}
+	return nil
+}
+
+func (f *fakeRepository) GetRefreshToken(ctx context.Context, tokenID pgtype.UUID) (*db.RefreshToken, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	token, ok := f.state.refreshTokens[uuidToString(tokenID)]
+	if !ok {
+		return nil, auth.ErrNotFound
+	}
+	return &token, nil
+}
+
+func (f *fakeRepository) UseRefreshToken(ctx context.Context, tokenID pgtype.UUID) (*db.RefreshToken, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	token, ok := f.state.refreshTokens[uuidToString(tokenID)]
+	if !ok || token.UsedAt.Valid || token.RevokedAt.Valid || !token.ExpiresAt.Time.After(time.Now()) {
+		return nil, auth.ErrNotFound
+	}
+	token.UsedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	f.state.refreshTokens[uuidToString(tokenID)] = token
+	return &token, nil
+}
+
+func (f *fakeRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	f.state.revokedFamilies = append(f.state.revokedFamilies, familyID)
+	for id, token := range f.state.refreshTokens {
+		if token.FamilyID == familyID && !token.RevokedAt.Valid {
+			token.RevokedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+			f.state.refreshTokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepository) RevokeSessionRefreshTokens(ctx context.Context, sessionID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	for id, token := range f.state.refreshTokens {
+		if token.SessionID == sessionID && !token.RevokedAt.Valid {
+			token.RevokedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+			f.state.refreshTokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepository) RevokeUserRefreshTokens(ctx context.Context, userID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	for id, token := range f.state.refreshTokens {
+		if token.UserID == userID && !token.RevokedAt.Valid {
+			token.RevokedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+			f.state.refreshTokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepository) CreateSession(ctx context.Context, params db.InsertSessionParams) (*db.Session, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	now := pgtype.Timestamp{Time: time.Now(), Valid: true}
+	session := db.Session{
+		ID:          newPGUUID(),
+		UserID:      params.UserID,
+		DeviceLabel: params.DeviceLabel,
+		IpAddress:   params.IpAddress,
+		UserAgent:   params.UserAgent,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+	}
+	f.state.sessions[uuidToString(session.ID)] = session
+	return &session, nil
+}
+
+func (f *fakeRepository) GetSession(ctx context.Context, sessionID pgtype.UUID) (*db.Session, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	session, ok := f.state.sessions[uuidToString(sessionID)]
+	if !ok {
+		return nil, auth.ErrNotFound
+	}
+	return &session, nil
+}
+
+func (f *fakeRepository) ListActiveSessions(ctx context.Context, userID pgtype.UUID) ([]db.Session, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	sessions := make([]db.Session, 0)
+	for _, session := range f.state.sessions {
+		if session.UserID == userID && !session.RevokedAt.Valid {
+			sessions = append(sessions, session)
 		}
 	}
+	return sessions, nil
+}
+
+func (f *fakeRepository) MarkFirstLogin(ctx context.Context, authID pgtype.UUID) (bool, error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	key := uuidToString(authID)
+	if f.state.firstLogins[key] {
+		return false, nil
+	}
+	f.state.firstLogins[key] = true
+	return true, nil
+}
+
+func (f *fakeRepository) TouchSession(ctx context.Context, sessionID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
 
+	if session, ok := f.state.sessions[uuidToString(sessionID)]; ok {
+		session.LastSeenAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+		f.state.sessions[uuidToString(sessionID)] = session
+	}
+	return nil
+}
+
+func (f *fakeRepository) RevokeSession(ctx context.Context, userID pgtype.UUID, sessionID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	session, ok := f.state.sessions[uuidToString(sessionID)]
+	if !ok || session.UserID != userID || session.RevokedAt.Valid {
+		return auth.ErrNotFound
+	}
+	session.RevokedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	f.state.sessions[uuidToString(sessionID)] = session
+	return nil
+}
+
+func (f *fakeRepository) RevokeUserSessions(ctx context.Context, userID pgtype.UUID) error {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+
+	for id, session := range f.state.sessions {
+		if session.UserID == userID && !session.RevokedAt.Valid {
+			session.RevokedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+			f.state.sessions[id] = session
+		}
+	}
 	return nil
 }
 
@@ -172,6 +959,18 @@ func (f *fakeRepository) setGetOtpErr(err error) {
 	f.state.getOtpErr = err
 }
 
+func (f *fakeRepository) setIncrementAttemptsErr(err error) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	f.state.incrementAttemptsErr = err
+}
+
+func (f *fakeRepository) attempts(email string) int32 {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	return f.state.attemptsByEmail[email]
+}
+
 func (f *fakeRepository) setKillOrphanedErr(err error) {
 	f.state.mu.Lock()
 	defer f.state.mu.Unlock()
@@ -196,17 +995,61 @@ func (f *fakeRepository) setUserForAuth(authID, userID pgtype.UUID) {
 	f.state.userIDByAuthID[uuidToString(authID)] = userID
 }
 
-func (f *fakeRepository) setOTP(hash string, row db.GetUserIdAndEmailByOtpCodeRow) {
+func (f *fakeRepository) setOTP(hash string, row db.GetActiveOtpCodesByEmailRow) {
 	f.state.mu.Lock()
 	defer f.state.mu.Unlock()
-	f.state.otpsByHash[hash] = row
+	row.Code = hash
+	f.state.otps = append(f.state.otps, fakeOTP{hash: hash, row: row})
 }
 
-func (f *fakeRepository) getOTP(hash string) (db.GetUserIdAndEmailByOtpCodeRow, bool) {
+func (f *fakeRepository) getOTP(hash string) (db.GetActiveOtpCodesByEmailRow, bool) {
 	f.state.mu.Lock()
 	defer f.state.mu.Unlock()
-	row, ok := f.state.otpsByHash[hash]
-	return row, ok
+	for _, otp := range f.state.otps {
+		if otp.hash == hash {
+			return otp.row, true
+		}
+	}
+	return db.GetActiveOtpCodesByEmailRow{}, false
+}
+
+func (f *fakeRepository) setLatestOTPAge(authID pgtype.UUID, age time.Duration) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	f.state.latestOTPAges[uuidToString(authID)] = age
+}
+
+func (f *fakeRepository) otpLifetime(hash string) (time.Duration, bool) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	for _, otp := range f.state.otps {
+		if otp.hash == hash {
+			return otp.lifetime, true
+		}
+	}
+	return 0, false
+}
+
+func (f *fakeRepository) otpCount() int {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	return len(f.state.otps)
+}
+
+func (f *fakeRepository) lockedAuthIDs() []pgtype.UUID {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	ids := make([]pgtype.UUID, len(f.state.lockedAuthIDs))
+	copy(ids, f.state.lockedAuthIDs)
+	return ids
+}
+
+func (f *fakeRepository) revokedFamilies() []pgtype.UUID {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	ids := make([]pgtype.UUID, len(f.state.revokedFamilies))
+	copy(ids, f.state.revokedFamilies)
+	return ids
 }
 
 func (f *fakeRepository) withTxCount() int {
@@ -230,3 +1073,68 @@ func (f *fakeRepository) killOrphanedUserIDs() []pgtype.UUID {
 	copy(ids, f.state.killOrphanedUserIDs)
 	return ids
 }
+
+func (f *fakeRepository) refreshToken(id pgtype.UUID) db.RefreshToken {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	return f.state.refreshTokens[uuidToString(id)]
+}
+
+func (f *fakeRepository) totp(authID pgtype.UUID) (db.AuthTotp, bool) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	record, ok := f.state.totps[uuidToString(authID)]
+	return record, ok
+}
+
+func (f *fakeRepository) recoveryCodeCount(authID pgtype.UUID) int {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	return len(f.state.recoveryCodes[uuidToString(authID)])
+}
+
+// setSuspension stores a suspension directly, bypassing the service checks, so
+// tests can set up suspensions that have already run out.
+func (f *fakeRepository) setSuspension(authID pgtype.UUID, reason string, until time.Time) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	params := db.SuspendAuthParams{ID: authID, Reason: pgtype.Text{String: reason, Valid: true}}
+	if !until.IsZero() {
+		params.SuspendedUntil = pgtype.Timestamp{Time: until.UTC(), Valid: true}
+	}
+	f.state.suspensions[uuidToString(authID)] = params
+}
+
+func (f *fakeRepository) suspensionEvents() []db.AuthSuspensionEvent {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	return append([]db.AuthSuspensionEvent(nil), f.state.suspensionEvents...)
+}
+
+func (f *fakeRepository) emailChange(authID pgtype.UUID) (db.AuthEmailChange, bool) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	change, ok := f.state.emailChanges[uuidToString(authID)]
+	return change, ok
+}
+
+func (f *fakeRepository) setEmailChangeAge(authID pgtype.UUID, age time.Duration) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	f.state.emailChangeAges[uuidToString(authID)] = age
+}
+
+// backdateDeletion moves the purge time of a pending deletion into the past.
+func (f *fakeRepository) backdateDeletion(authID pgtype.UUID) {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	deletion := f.state.deletions[uuidToString(authID)]
+	deletion.PurgeAfter = pgtype.Timestamp{Time: time.Now().UTC().Add(-time.Minute), Valid: true}
+	f.state.deletions[uuidToString(authID)] = deletion
+}
+
+func (f *fakeRepository) authEmail(authID pgtype.UUID) string {
+	f.state.mu.Lock()
+	defer f.state.mu.Unlock()
+	return f.state.emailsByAuthID[uuidToString(authID)]
+}
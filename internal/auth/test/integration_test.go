@@ -2,6 +2,7 @@ package auth_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -9,11 +10,13 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/abdurrahimagca/qq-back/internal/auth"
 	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
 	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -184,32 +187,56 @@ func TestPgxRepository_CreateAuthForOTPLogin(t *testing.T) {
 	ctx := context.Background()
 	email := fmt.Sprintf("auth-%d@example.com", time.Now().UnixNano())
 
-	id, err := h.repo.CreateAuthForOTPLogin(ctx, email)
+	id, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
 	require.NoError(t, err)
 	require.NotNil(t, id)
 
-	var provider string
-	err = h.pool.QueryRow(ctx, "SELECT provider FROM auth WHERE id = $1", *id).Scan(&provider)
+	var provider, providerID string
+	err = h.pool.QueryRow(ctx,
+		"SELECT provider, provider_id FROM auth_identities WHERE auth_id = $1", *id).Scan(&provider, &providerID)
 	require.NoError(t, err)
 	require.Equal(t, "email_otp", provider)
+	require.Equal(t, email, providerID)
 
-	_, err = h.repo.CreateAuthForOTPLogin(ctx, email)
+	// Another spelling of the same address is the same account.
+	_, err = h.repo.CreateAuthForOTPLogin(ctx, strings.Replace(email, "auth-", "Auth-", 1), email)
 	require.Error(t, err)
 	var qqErr *qqerrors.QQError
 	require.ErrorAs(t, err, &qqErr)
 	require.Equal(t, qqerrors.ErrUniqueViolation, qqErr.Original)
 }
 
+func TestPgxRepository_CreateAuthForOTPLogin_KeepsEnteredEmail(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	normalized := fmt.Sprintf("entered-%d@example.com", time.Now().UnixNano())
+	entered := strings.Replace(normalized, "entered-", "Entered-", 1)
+	entered = strings.Replace(entered, "@", "+news@", 1)
+
+	id, err := h.repo.CreateAuthForOTPLogin(ctx, entered, normalized)
+	require.NoError(t, err)
+
+	stored, err := h.repo.GetAuthByID(ctx, *id)
+	require.NoError(t, err)
+	require.Equal(t, entered, stored.Email, "The address is kept as entered for delivery")
+	require.Equal(t, normalized, stored.EmailNormalized)
+
+	exists, err := h.repo.EmailExists(ctx, normalized)
+	require.NoError(t, err)
+	require.True(t, exists, "Lookups use the normalized address")
+}
+
 func TestPgxRepository_CreateOTP(t *testing.T) {
 	h := setupIntegrationHarness(t)
 
 	ctx := context.Background()
 	email := fmt.Sprintf("otp-%d@example.com", time.Now().UnixNano())
-	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email)
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
 	require.NoError(t, err)
 
 	hash := hashOTP("ABC123")
-	err = h.repo.CreateOTP(ctx, *authID, hash)
+	err = h.repo.CreateOTP(ctx, *authID, hash, auth.DefaultOTPLifetime)
 	require.NoError(t, err)
 
 	var stored string
@@ -218,12 +245,12 @@ func TestPgxRepository_CreateOTP(t *testing.T) {
 	require.Equal(t, hash, stored)
 }
 
-func TestPgxRepository_GetUserIDAndEmailByOTPCode(t *testing.T) {
+func TestPgxRepository_GetActiveOTPByEmailAndHash(t *testing.T) {
 	h := setupIntegrationHarness(t)
 
 	ctx := context.Background()
 	email := fmt.Sprintf("user-%d@example.com", time.Now().UnixNano())
-	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email)
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
 	require.NoError(t, err)
 
 	userID, err := h.createUserForAuth(ctx, *authID)
@@ -231,27 +258,58 @@ func TestPgxRepository_GetUserIDAndEmailByOTPCode(t *testing.T) {
 
 	otpCode := "ABC123"
 	hash := hashOTP(otpCode)
-	err = h.repo.CreateOTP(ctx, *authID, hash)
+	err = h.repo.CreateOTP(ctx, *authID, hash, auth.DefaultOTPLifetime)
 	require.NoError(t, err)
 
-	row, err := h.repo.GetUserIDAndEmailByOTPCode(ctx, hash)
+	row, err := h.repo.GetActiveOTPByEmailAndHash(ctx, email, hash)
 	require.NoError(t, err)
 	require.Equal(t, email, row.Email)
 	require.Equal(t, uuidToString(*authID), uuidToString(row.AuthID))
 	require.Equal(t, uuidToString(userID), uuidToString(row.ID))
 
-	_, err = h.repo.GetUserIDAndEmailByOTPCode(ctx, "missing")
+	_, err = h.repo.GetActiveOTPByEmailAndHash(ctx, email, "missing")
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	_, err = h.repo.GetActiveOTPByEmailAndHash(ctx, "other@example.com", hash)
 	require.ErrorIs(t, err, auth.ErrNotFound)
 }
 
+func TestPgxRepository_GetActiveOTPByEmailAndHash_IdenticalCodes(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	hash := hashOTP("ABC123")
+	userIDs := make(map[string]pgtype.UUID, 2)
+	for _, prefix := range []string{"first", "second"} {
+		email := fmt.Sprintf("%s-%d@example.com", prefix, time.Now().UnixNano())
+		authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+		require.NoError(t, err)
+		userID, err := h.createUserForAuth(ctx, *authID)
+		require.NoError(t, err)
+		require.NoError(t, h.repo.CreateOTP(ctx, *authID, hash, auth.DefaultOTPLifetime))
+		userIDs[email] = userID
+	}
+
+	svc := auth.NewService(h.repo, environment.OTPEnvironment{})
+	for email, userID := range userIDs {
+		row, err := h.repo.GetActiveOTPByEmailAndHash(ctx, email, hash)
+		require.NoError(t, err)
+		require.Equal(t, uuidToString(userID), uuidToString(row.ID))
+
+		gotUserID, err := svc.VerifyOTP(ctx, email, "ABC123")
+		require.NoError(t, err)
+		require.Equal(t, uuidToString(userID), uuidToString(gotUserID))
+	}
+}
+
 func TestPgxRepository_KillOrphanedOTPs(t *testing.T) {
 	h := setupIntegrationHarness(t)
 
 	ctx := context.Background()
 	email := fmt.Sprintf("cleanup-%d@example.com", time.Now().UnixNano())
-	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email)
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
 	require.NoError(t, err)
-	err = h.repo.CreateOTP(ctx, *authID, hashOTP("ABC123"))
+	err = h.repo.CreateOTP(ctx, *authID, hashOTP("ABC123"), auth.DefaultOTPLifetime)
 	require.NoError(t, err)
 
 	err = h.repo.KillOrphanedOTPs(ctx, email)
@@ -267,12 +325,12 @@ func TestPgxRepository_KillOrphanedOTPsByUserID(t *testing.T) {
 
 	ctx := context.Background()
 	email := fmt.Sprintf("cleanup-user-%d@example.com", time.Now().UnixNano())
-	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email)
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
 	require.NoError(t, err)
 	userID, err := h.createUserForAuth(ctx, *authID)
 	require.NoError(t, err)
 
-	err = h.repo.CreateOTP(ctx, *authID, hashOTP("ABC123"))
+	err = h.repo.CreateOTP(ctx, *authID, hashOTP("ABC123"), auth.DefaultOTPLifetime)
 	require.NoError(t, err)
 
 	err = h.repo.KillOrphanedOTPsByUserID(ctx, userID)
@@ -283,6 +341,226 @@ func TestPgxRepository_KillOrphanedOTPsByUserID(t *testing.T) {
 	require.Zero(t, count)
 }
 
+func TestPgxRepository_CreateOTP_Lifetime(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("lifetime-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	require.NoError(t, h.repo.CreateOTP(ctx, *authID, hashOTP("ABC123"), 10*time.Minute))
+
+	var seconds float64
+	err = h.pool.QueryRow(ctx,
+		"SELECT EXTRACT(EPOCH FROM expires_at - created_at)::float8 FROM auth_otp_codes WHERE auth_id = $1",
+		*authID).Scan(&seconds)
+	require.NoError(t, err)
+	require.InDelta(t, 600, seconds, 1)
+}
+
+func TestPgxRepository_CreateOTP_CarriesOverAttempts(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("carry-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	require.NoError(t, h.repo.CreateOTP(ctx, *authID, hashOTP("ABC123"), auth.DefaultOTPLifetime))
+	for range 2 {
+		_, err = h.repo.IncrementOTPAttempts(ctx, email)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, h.repo.CreateOTP(ctx, *authID, hashOTP("DEF456"), auth.DefaultOTPLifetime))
+	attempts, err := h.repo.IncrementOTPAttempts(ctx, email)
+	require.NoError(t, err)
+	require.Equal(t, int32(3), attempts)
+}
+
+func TestPgxRepository_GetLatestOTPAge(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("age-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	_, err = h.repo.GetLatestOTPAge(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	require.NoError(t, h.repo.CreateOTP(ctx, *authID, hashOTP("ABC123"), auth.DefaultOTPLifetime))
+	_, err = h.pool.Exec(ctx,
+		"UPDATE auth_otp_codes SET created_at = created_at - INTERVAL '1 minute' WHERE auth_id = $1", *authID)
+	require.NoError(t, err)
+
+	age, err := h.repo.GetLatestOTPAge(ctx, *authID)
+	require.NoError(t, err)
+	require.InDelta(t, time.Minute.Seconds(), age.Seconds(), 5)
+}
+
+func TestPgxRepository_PruneSupersededOTPs(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("prune-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	for i, code := range []string{"AAAAAA", "BBBBBB", "CCCCCC"} {
+		require.NoError(t, h.repo.CreateOTP(ctx, *authID, hashOTP(code), auth.DefaultOTPLifetime))
+		_, err = h.pool.Exec(ctx,
+			"UPDATE auth_otp_codes SET created_at = created_at - make_interval(secs => $2) WHERE code = $1",
+			hashOTP(code), float64(30-10*i))
+		require.NoError(t, err)
+	}
+	_, err = h.pool.Exec(ctx,
+		"UPDATE auth_otp_codes SET expires_at = CURRENT_TIMESTAMP - INTERVAL '1 second' WHERE code = $1",
+		hashOTP("CCCCCC"))
+	require.NoError(t, err)
+
+	require.NoError(t, h.repo.PruneSupersededOTPs(ctx, *authID))
+
+	var remaining []string
+	rows, err := h.pool.Query(ctx, "SELECT code FROM auth_otp_codes WHERE auth_id = $1", *authID)
+	require.NoError(t, err)
+	for rows.Next() {
+		var code string
+		require.NoError(t, rows.Scan(&code))
+		remaining = append(remaining, code)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{hashOTP("BBBBBB")}, remaining)
+}
+
+func TestPgxRepository_Suspensions(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("suspend-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	_, err = h.repo.GetActiveRestriction(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	require.ErrorIs(t, h.repo.LiftSuspension(ctx, *authID), auth.ErrNotFound)
+
+	until := time.Now().Add(time.Hour).UTC()
+	require.NoError(t, h.repo.SuspendAuth(ctx, db.SuspendAuthParams{
+		ID:             *authID,
+		Reason:         pgtype.Text{String: "spam", Valid: true},
+		SuspendedUntil: pgtype.Timestamp{Time: until, Valid: true},
+	}))
+	suspension, err := h.repo.GetActiveRestriction(ctx, *authID)
+	require.NoError(t, err)
+	require.Equal(t, "spam", suspension.SuspensionReason.String)
+	require.WithinDuration(t, until, suspension.SuspendedUntil.Time, time.Millisecond)
+
+	// A temporary suspension stops applying once it runs out
+	require.NoError(t, h.repo.SuspendAuth(ctx, db.SuspendAuthParams{
+		ID:             *authID,
+		Reason:         pgtype.Text{String: "spam", Valid: true},
+		SuspendedUntil: pgtype.Timestamp{Time: time.Now().Add(-time.Minute).UTC(), Valid: true},
+	}))
+	_, err = h.repo.GetActiveRestriction(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	require.NoError(t, h.repo.LiftSuspension(ctx, *authID))
+	record, err := h.repo.GetAuthByID(ctx, *authID)
+	require.NoError(t, err)
+	require.False(t, record.IsSuspended)
+	require.False(t, record.SuspensionReason.Valid)
+	require.False(t, record.SuspendedUntil.Valid)
+
+	err = h.repo.SuspendAuth(ctx, db.SuspendAuthParams{ID: newPGUUID(), Reason: pgtype.Text{String: "x", Valid: true}})
+	require.ErrorIs(t, err, auth.ErrNotFound)
+}
+
+func TestPgxRepository_SuspensionEvents(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("suspend-events-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	for _, action := range []db.SuspensionAction{db.SuspensionActionSuspended, db.SuspensionActionLifted} {
+		_, err = h.repo.CreateSuspensionEvent(ctx, db.InsertAuthSuspensionEventParams{
+			AuthID: *authID,
+			Action: action,
+			Reason: "reason",
+			Actor:  "admin",
+		})
+		require.NoError(t, err)
+	}
+
+	events, err := h.repo.ListSuspensionEvents(ctx, *authID)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, db.SuspensionActionLifted, events[0].Action, "Newest event first")
+	require.Equal(t, db.SuspensionActionSuspended, events[1].Action)
+	require.Equal(t, "admin", events[0].Actor)
+}
+
+func TestPgxRepository_Deletions(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("delete-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+	_, err = h.createUserForAuth(ctx, *authID)
+	require.NoError(t, err)
+
+	deletion, err := h.repo.CreateDeletion(ctx, db.InsertAuthDeletionParams{
+		AuthID:           *authID,
+		RestoreTokenHash: "restore-hash",
+		GraceSeconds:     3600,
+	})
+	require.NoError(t, err)
+	require.WithinDuration(t, deletion.RequestedAt.Time.Add(time.Hour), deletion.PurgeAfter.Time, time.Second)
+
+	restriction, err := h.repo.GetActiveRestriction(ctx, *authID)
+	require.NoError(t, err)
+	require.True(t, restriction.PurgeAfter.Valid)
+	require.False(t, restriction.SuspendedUntil.Valid)
+
+	// Nothing is due during the grace period
+	due, err := h.repo.ListDueDeletions(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, due)
+	require.ErrorIs(t, h.repo.DeleteDueAuth(ctx, *authID), auth.ErrNotFound)
+
+	restoredID, err := h.repo.RestoreDeletion(ctx, "restore-hash")
+	require.NoError(t, err)
+	require.Equal(t, *authID, restoredID)
+	_, err = h.repo.GetActiveRestriction(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	_, err = h.repo.CreateDeletion(ctx, db.InsertAuthDeletionParams{
+		AuthID:           *authID,
+		RestoreTokenHash: "restore-hash-2",
+		GraceSeconds:     0,
+	})
+	require.NoError(t, err)
+	_, err = h.repo.RestoreDeletion(ctx, "restore-hash-2")
+	require.ErrorIs(t, err, auth.ErrNotFound, "Restoring after the grace period is refused")
+
+	due, err = h.repo.ListDueDeletions(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	require.Equal(t, *authID, due[0].AuthID)
+
+	require.NoError(t, h.repo.DeleteDueAuth(ctx, *authID))
+	count, err := countRows(ctx, h.pool, "SELECT COUNT(*) FROM users WHERE auth_id = $1", *authID)
+	require.NoError(t, err)
+	require.Zero(t, count, "The user row goes with the auth row")
+	count, err = countRows(ctx, h.pool, "SELECT COUNT(*) FROM auth_deletions WHERE auth_id = $1", *authID)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
 func TestPgxRepository_WithTx_Rollback(t *testing.T) {
 	h := setupIntegrationHarness(t)
 
@@ -292,7 +570,7 @@ func TestPgxRepository_WithTx_Rollback(t *testing.T) {
 
 	repoTx := h.repo.WithTx(tx)
 	email := fmt.Sprintf("tx-%d@example.com", time.Now().UnixNano())
-	_, err = repoTx.CreateAuthForOTPLogin(ctx, email)
+	_, err = repoTx.CreateAuthForOTPLogin(ctx, email, email)
 	require.NoError(t, err)
 
 	err = tx.Rollback(ctx)
@@ -303,13 +581,13 @@ func TestPgxRepository_WithTx_Rollback(t *testing.T) {
 	require.Zero(t, count)
 }
 
-func TestPgxRepository_GetUserIDAndEmailByOTPCode_DBError(t *testing.T) {
+func TestPgxRepository_GetActiveOTPByEmailAndHash_DBError(t *testing.T) {
 	h := setupIntegrationHarness(t)
 
 	h.pool.Close()
 	h.pool = nil
 
-	_, err := h.repo.GetUserIDAndEmailByOTPCode(context.Background(), "deadbeef")
+	_, err := h.repo.GetActiveOTPByEmailAndHash(context.Background(), "test@example.com", "deadbeef")
 	require.Error(t, err)
 	var qqErr *qqerrors.QQError
 	require.ErrorAs(t, err, &qqErr)
@@ -326,3 +604,440 @@ func TestPgxRepository_KillOrphanedOTPs_DBError(t *testing.T) {
 	var qqErr *qqerrors.QQError
 	require.ErrorAs(t, err, &qqErr)
 }
+
+func TestPgxRepository_IncrementOTPAttempts(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("attempts-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	_, err = h.repo.IncrementOTPAttempts(ctx, email)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	err = h.repo.CreateOTP(ctx, *authID, hashOTP("ABC123"), auth.DefaultOTPLifetime)
+	require.NoError(t, err)
+
+	for want := int32(1); want <= 3; want++ {
+		attempts, incErr := h.repo.IncrementOTPAttempts(ctx, email)
+		require.NoError(t, incErr)
+		require.Equal(t, want, attempts)
+	}
+}
+
+func TestPgxRepository_VerifyOTPConcurrentGuessesAreLimited(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("concurrent-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+	_, err = h.createUserForAuth(ctx, *authID)
+	require.NoError(t, err)
+	err = h.repo.CreateOTP(ctx, *authID, hashOTP("ABC123"), auth.DefaultOTPLifetime)
+	require.NoError(t, err)
+
+	const maxAttempts = 5
+	svc := auth.NewService(h.repo, environment.OTPEnvironment{MaxAttempts: maxAttempts})
+
+	guess := func(n int) []error {
+		results := make(chan error, n)
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := svc.VerifyOTP(ctx, email, fmt.Sprintf("%06X", i+1))
+				results <- err
+			}(i)
+		}
+		wg.Wait()
+		close(results)
+
+		errs := make([]error, 0, n)
+		for err := range results {
+			errs = append(errs, err)
+		}
+		return errs
+	}
+
+	for _, err := range guess(maxAttempts - 1) {
+		require.ErrorIs(t, err, auth.ErrInvalidOtpCode)
+	}
+
+	var attempts int
+	err = h.pool.QueryRow(ctx, "SELECT attempts FROM auth_otp_codes WHERE auth_id = $1", *authID).Scan(&attempts)
+	require.NoError(t, err)
+	require.Equal(t, maxAttempts-1, attempts, "concurrent guesses must not lose attempt increments")
+
+	locked := 0
+	for _, err := range guess(10) {
+		require.Error(t, err)
+		if errors.Is(err, auth.ErrOtpAttemptsExceeded) {
+			locked++
+		}
+	}
+	require.Positive(t, locked)
+
+	_, err = svc.VerifyOTP(ctx, email, "ABC123")
+	require.Error(t, err)
+
+	count, err := countRows(ctx, h.pool, "SELECT COUNT(*) FROM auth_otp_codes WHERE auth_id = $1", *authID)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestPgxRepository_CreateAuthForOAuthLogin_ResolvesByIdentity(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("oauth-%d@example.com", time.Now().UnixNano())
+	subject := fmt.Sprintf("sub-%d", time.Now().UnixNano())
+
+	id, err := h.repo.CreateAuthForOAuthLogin(ctx, email, email, db.AuthProviderGoogleOauth, subject)
+	require.NoError(t, err)
+
+	found, err := h.repo.GetAuthByProvider(ctx, db.AuthProviderGoogleOauth, subject)
+	require.NoError(t, err)
+	require.Equal(t, *id, found.ID)
+	require.Equal(t, email, found.Email)
+
+	_, err = h.repo.GetAuthByProvider(ctx, db.AuthProviderGoogleOauth, "unknown-subject")
+	require.ErrorIs(t, err, auth.ErrNotFound)
+}
+
+func TestPgxRepository_MarkFirstLogin(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("first-login-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	first, err := h.repo.MarkFirstLogin(ctx, *authID)
+	require.NoError(t, err)
+	require.True(t, first, "The first call records the first login")
+
+	stored, err := h.repo.GetAuthByID(ctx, *authID)
+	require.NoError(t, err)
+	require.True(t, stored.FirstLoginAt.Valid)
+
+	first, err = h.repo.MarkFirstLogin(ctx, *authID)
+	require.NoError(t, err)
+	require.False(t, first, "Later logins are not the first")
+}
+
+func TestPgxRepository_Identities_LinkListDelete(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("link-%d@example.com", time.Now().UnixNano())
+	subject := fmt.Sprintf("sub-%d", time.Now().UnixNano())
+
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	linked, err := h.repo.CreateIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     *authID,
+		Provider:   db.AuthProviderGoogleOauth,
+		ProviderID: subject,
+		Email:      "other@example.com",
+	})
+	require.NoError(t, err)
+
+	identities, err := h.repo.ListIdentities(ctx, *authID)
+	require.NoError(t, err)
+	require.Len(t, identities, 2)
+
+	hasGoogle, err := h.repo.HasIdentity(ctx, *authID, db.AuthProviderGoogleOauth)
+	require.NoError(t, err)
+	require.True(t, hasGoogle)
+
+	// The same Google subject cannot be attached to a second account.
+	otherAuthID, err := h.repo.CreateAuthForOTPLogin(ctx, "second-"+email, "second-"+email)
+	require.NoError(t, err)
+	_, err = h.repo.CreateIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     *otherAuthID,
+		Provider:   db.AuthProviderGoogleOauth,
+		ProviderID: subject,
+		Email:      "other@example.com",
+	})
+	require.ErrorIs(t, err, qqerrors.ErrUniqueViolation)
+
+	// Deleting through another account must not touch the identity.
+	err = h.repo.DeleteIdentity(ctx, *otherAuthID, linked.ID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	err = h.repo.DeleteIdentity(ctx, *authID, linked.ID)
+	require.NoError(t, err)
+
+	count, err := h.repo.CountIdentities(ctx, *authID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+}
+
+func TestPgxRepository_ConcurrentUnlinksKeepOneIdentity(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("unlink-%d@example.com", time.Now().UnixNano())
+
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+	_, err = h.repo.CreateIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     *authID,
+		Provider:   db.AuthProviderGoogleOauth,
+		ProviderID: fmt.Sprintf("sub-%d", time.Now().UnixNano()),
+		Email:      email,
+	})
+	require.NoError(t, err)
+
+	identities, err := h.repo.ListIdentities(ctx, *authID)
+	require.NoError(t, err)
+	require.Len(t, identities, 2)
+
+	svc := auth.NewService(h.repo, environment.OTPEnvironment{})
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(identities))
+	for i, identity := range identities {
+		wg.Add(1)
+		go func(i int, identityID pgtype.UUID) {
+			defer wg.Done()
+			tx, beginErr := h.pool.Begin(ctx)
+			if beginErr != nil {
+				errs[i] = beginErr
+				return
+			}
+			if errs[i] = svc.WithTx(tx).UnlinkIdentity(ctx, *authID, identityID); errs[i] != nil {
+				_ = tx.Rollback(ctx)
+				return
+			}
+			errs[i] = tx.Commit(ctx)
+		}(i, identity.ID)
+	}
+	wg.Wait()
+
+	failures := 0
+	for _, err := range errs {
+		if err != nil {
+			require.ErrorIs(t, err, auth.ErrLastIdentity)
+			failures++
+		}
+	}
+	require.Equal(t, 1, failures)
+
+	count, err := h.repo.CountIdentities(ctx, *authID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+}
+
+func TestPgxRepository_Sessions_ListAndRevoke(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("session-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+	userID, err := h.createUserForAuth(ctx, *authID)
+	require.NoError(t, err)
+
+	first, err := h.repo.CreateSession(ctx, db.InsertSessionParams{
+		UserID:      userID,
+		DeviceLabel: pgtype.Text{String: "Laptop", Valid: true},
+	})
+	require.NoError(t, err)
+	second, err := h.repo.CreateSession(ctx, db.InsertSessionParams{UserID: userID})
+	require.NoError(t, err)
+
+	tokenParams := db.InsertRefreshTokenParams{
+		UserID:    userID,
+		SessionID: first.ID,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().UTC().Add(time.Hour), Valid: true},
+	}
+	require.NoError(t, tokenParams.ID.Scan("11111111-1111-4111-8111-111111111111"))
+	require.NoError(t, tokenParams.FamilyID.Scan("22222222-2222-4222-8222-222222222222"))
+	require.NoError(t, h.repo.CreateRefreshToken(ctx, tokenParams))
+
+	sessions, err := h.repo.ListActiveSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	// Another user's ID must not revoke the session.
+	err = h.repo.RevokeSession(ctx, pgtype.UUID{Bytes: [16]byte{1}, Valid: true}, first.ID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	require.NoError(t, h.repo.RevokeSession(ctx, userID, first.ID))
+	require.NoError(t, h.repo.RevokeSessionRefreshTokens(ctx, first.ID))
+
+	stored, err := h.repo.GetRefreshToken(ctx, tokenParams.ID)
+	require.NoError(t, err)
+	require.True(t, stored.RevokedAt.Valid)
+
+	sessions, err = h.repo.ListActiveSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, second.ID, sessions[0].ID)
+
+	require.NoError(t, h.repo.RevokeUserSessions(ctx, userID))
+	sessions, err = h.repo.ListActiveSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+}
+
+func TestPgxRepository_MagicLink_ConsumeOnce(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("magic-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+	userID, err := h.createUserForAuth(ctx, *authID)
+	require.NoError(t, err)
+
+	hash := hashOTP("magic-nonce")
+	require.NoError(t, h.repo.CreateMagicLink(ctx, *authID, hash, auth.DefaultOTPLifetime))
+
+	var kind string
+	err = h.pool.QueryRow(ctx, "SELECT kind FROM auth_otp_codes WHERE auth_id = $1", *authID).Scan(&kind)
+	require.NoError(t, err)
+	require.Equal(t, "magic_link", kind)
+
+	// Magic links are not typed codes.
+	_, err = h.repo.GetActiveOTPByEmailAndHash(ctx, email, hash)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	_, err = h.repo.IncrementOTPAttempts(ctx, email)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	err = h.repo.ConsumeMagicLink(ctx, pgtype.UUID{Bytes: [16]byte{1}, Valid: true}, hash)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	err = h.repo.ConsumeMagicLink(ctx, userID, "missing")
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	require.NoError(t, h.repo.ConsumeMagicLink(ctx, userID, hash))
+	err = h.repo.ConsumeMagicLink(ctx, userID, hash)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+}
+
+func TestPgxRepository_TOTP_Lifecycle(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("totp-%d@example.com", time.Now().UnixNano())
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+
+	_, err = h.repo.GetTOTP(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	_, err = h.repo.CreatePendingTOTP(ctx, *authID, "FIRSTSECRET")
+	require.NoError(t, err)
+	pending, err := h.repo.CreatePendingTOTP(ctx, *authID, "SECONDSECRET")
+	require.NoError(t, err)
+	require.Equal(t, "SECONDSECRET", pending.Secret)
+	require.False(t, pending.ConfirmedAt.Valid)
+
+	// Pending secrets do not count failed attempts.
+	_, err = h.repo.IncrementTOTPAttempts(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	err = h.repo.UseTOTPStep(ctx, *authID, 100)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	require.NoError(t, h.repo.ConfirmTOTP(ctx, *authID, 100))
+	err = h.repo.ConfirmTOTP(ctx, *authID, 101)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	_, err = h.repo.CreatePendingTOTP(ctx, *authID, "THIRDSECRET")
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	attempts, err := h.repo.IncrementTOTPAttempts(ctx, *authID)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), attempts)
+
+	err = h.repo.UseTOTPStep(ctx, *authID, 100)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	require.NoError(t, h.repo.UseTOTPStep(ctx, *authID, 101))
+
+	stored, err := h.repo.GetTOTP(ctx, *authID)
+	require.NoError(t, err)
+	require.Equal(t, "SECONDSECRET", stored.Secret)
+	require.Equal(t, int64(101), stored.LastUsedStep)
+	require.Equal(t, int32(0), stored.FailedAttempts)
+
+	require.NoError(t, h.repo.ReplaceRecoveryCodes(ctx, *authID, []string{"hash-a", "hash-b"}))
+	require.NoError(t, h.repo.UseRecoveryCode(ctx, *authID, "hash-a"))
+	err = h.repo.UseRecoveryCode(ctx, *authID, "hash-a")
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	require.NoError(t, h.repo.ReplaceRecoveryCodes(ctx, *authID, []string{"hash-c"}))
+	err = h.repo.UseRecoveryCode(ctx, *authID, "hash-b")
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	require.NoError(t, h.repo.UseRecoveryCode(ctx, *authID, "hash-c"))
+}
+
+func TestPgxRepository_EmailChange_Lifecycle(t *testing.T) {
+	h := setupIntegrationHarness(t)
+
+	ctx := context.Background()
+	suffix := time.Now().UnixNano()
+	email := fmt.Sprintf("change-old-%d@example.com", suffix)
+	newEmail := fmt.Sprintf("change-new-%d@example.com", suffix)
+	takenEmail := fmt.Sprintf("change-taken-%d@example.com", suffix)
+	authID, err := h.repo.CreateAuthForOTPLogin(ctx, email, email)
+	require.NoError(t, err)
+	_, err = h.repo.CreateAuthForOTPLogin(ctx, takenEmail, takenEmail)
+	require.NoError(t, err)
+
+	_, err = h.repo.GetEmailChangeAge(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	_, err = h.repo.IncrementEmailChangeAttempts(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+
+	params := db.UpsertAuthEmailChangeParams{
+		AuthID:          *authID,
+		NewEmail:        takenEmail,
+		CurrentCodeHash: "current-hash",
+		NewCodeHash:     "new-hash",
+		LifetimeSeconds: 600,
+	}
+	_, err = h.repo.CreateEmailChange(ctx, params)
+	require.NoError(t, err)
+	_, err = h.repo.IncrementEmailChangeAttempts(ctx, *authID)
+	require.NoError(t, err)
+
+	// Starting again replaces the pending change and its attempts.
+	params.NewEmail = newEmail
+	change, err := h.repo.CreateEmailChange(ctx, params)
+	require.NoError(t, err)
+	require.Equal(t, newEmail, change.NewEmail)
+	require.Equal(t, int32(0), change.Attempts)
+
+	age, err := h.repo.GetEmailChangeAge(ctx, *authID)
+	require.NoError(t, err)
+	require.Less(t, age, time.Minute)
+	attempts, err := h.repo.IncrementEmailChangeAttempts(ctx, *authID)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), attempts)
+
+	exists, err := h.repo.EmailExists(ctx, takenEmail)
+	require.NoError(t, err)
+	require.True(t, exists)
+	err = h.repo.UpdateEmail(ctx, *authID, strings.ToUpper(takenEmail), takenEmail)
+	require.ErrorIs(t, err, qqerrors.ErrUniqueViolation)
+
+	err = h.repo.DeleteEmailChange(ctx, *authID, takenEmail)
+	require.ErrorIs(t, err, auth.ErrNotFound, "Only the pending address should be deleted")
+	require.NoError(t, h.repo.DeleteEmailChange(ctx, *authID, newEmail))
+	enteredEmail := strings.Replace(newEmail, "change-new", "Change-New", 1)
+	require.NoError(t, h.repo.UpdateEmail(ctx, *authID, enteredEmail, newEmail))
+
+	stored, err := h.repo.GetAuthByID(ctx, *authID)
+	require.NoError(t, err)
+	require.Equal(t, enteredEmail, stored.Email)
+	require.Equal(t, newEmail, stored.EmailNormalized)
+	byProvider, err := h.repo.GetAuthByProvider(ctx, db.AuthProviderEmailOtp, enteredEmail)
+	require.NoError(t, err)
+	require.Equal(t, *authID, byProvider.ID)
+	_, err = h.repo.GetActiveEmailChange(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrNotFound)
+}
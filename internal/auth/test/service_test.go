@@ -3,11 +3,16 @@ package auth_test
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/abdurrahimagca/qq-back/internal/auth"
 	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/totp"
 	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -15,7 +20,7 @@ import (
 func TestService_GenerateAndSaveOTPForAuth_Success(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
 	authID := newPGUUID()
 	email := "user@example.com"
@@ -38,7 +43,7 @@ func TestService_GenerateAndSaveOTPForAuth_CreateOTPError(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
 	fakeRepo.setCreateOTPErr(errors.New("db unavailable"))
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
 	useRandReader(t, &deterministicReader{data: []byte{0xaa, 0xbb, 0xcc}})
 
@@ -46,10 +51,120 @@ func TestService_GenerateAndSaveOTPForAuth_CreateOTPError(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestService_GenerateAndSaveOTPForAuth_Lifetime(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("defaults to three minutes", func(t *testing.T) {
+		fakeRepo := newFakeRepository()
+		svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+		code, err := svc.GenerateAndSaveOTPForAuth(ctx, newPGUUID())
+		require.NoError(t, err)
+		lifetime, ok := fakeRepo.otpLifetime(hashOTP(code))
+		require.True(t, ok)
+		assert.Equal(t, auth.DefaultOTPLifetime, lifetime)
+	})
+
+	t.Run("uses the configured lifetime for codes and links", func(t *testing.T) {
+		fakeRepo := newFakeRepository()
+		svc := auth.NewService(fakeRepo, environment.OTPEnvironment{Lifetime: 10 * time.Minute})
+
+		code, err := svc.GenerateAndSaveOTPForAuth(ctx, newPGUUID())
+		require.NoError(t, err)
+		lifetime, ok := fakeRepo.otpLifetime(hashOTP(code))
+		require.True(t, ok)
+		assert.Equal(t, 10*time.Minute, lifetime)
+
+		nonce, err := svc.GenerateAndSaveMagicLinkForAuth(ctx, newPGUUID())
+		require.NoError(t, err)
+		lifetime, ok = fakeRepo.otpLifetime(hashOTP(nonce))
+		require.True(t, ok)
+		assert.Equal(t, 10*time.Minute, lifetime)
+	})
+}
+
+func TestService_CheckOTPResendCooldown(t *testing.T) {
+	ctx := context.Background()
+	conf := environment.OTPEnvironment{ResendCooldown: 30 * time.Second}
+
+	t.Run("refuses while the newest code is younger than the cooldown", func(t *testing.T) {
+		fakeRepo := newFakeRepository()
+		svc := auth.NewService(fakeRepo, conf)
+		authID := newPGUUID()
+		fakeRepo.setAuthEmail(authID, "user@example.com")
+		fakeRepo.setLatestOTPAge(authID, 10*time.Second)
+
+		err := svc.CheckOTPResendCooldown(ctx, authID)
+		require.ErrorIs(t, err, auth.ErrOtpResendTooSoon)
+		assert.ErrorIs(t, err, qqerrors.ErrTooManyRequests)
+
+		var retryErr *qqerrors.RetryAfterError
+		require.ErrorAs(t, err, &retryErr)
+		assert.Equal(t, 20*time.Second, retryErr.RetryAfter)
+		assert.Len(t, fakeRepo.lockedAuthIDs(), 1)
+	})
+
+	t.Run("allows a send once the cooldown has passed", func(t *testing.T) {
+		fakeRepo := newFakeRepository()
+		svc := auth.NewService(fakeRepo, conf)
+		authID := newPGUUID()
+		fakeRepo.setAuthEmail(authID, "user@example.com")
+		fakeRepo.setLatestOTPAge(authID, 30*time.Second)
+
+		require.NoError(t, svc.CheckOTPResendCooldown(ctx, authID))
+	})
+
+	t.Run("allows the first send", func(t *testing.T) {
+		fakeRepo := newFakeRepository()
+		svc := auth.NewService(fakeRepo, conf)
+		authID := newPGUUID()
+		fakeRepo.setAuthEmail(authID, "user@example.com")
+
+		require.NoError(t, svc.CheckOTPResendCooldown(ctx, authID))
+	})
+
+	t.Run("a zero cooldown is disabled", func(t *testing.T) {
+		fakeRepo := newFakeRepository()
+		svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+		authID := newPGUUID()
+		fakeRepo.setLatestOTPAge(authID, 0)
+
+		require.NoError(t, svc.CheckOTPResendCooldown(ctx, authID))
+		assert.Empty(t, fakeRepo.lockedAuthIDs())
+	})
+}
+
+func TestService_PruneSupersededOTPs_KeepsNewest(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+	authID := newPGUUID()
+	otherAuthID := newPGUUID()
+
+	first, err := svc.GenerateAndSaveOTPForAuth(ctx, authID)
+	require.NoError(t, err)
+	_, err = svc.GenerateAndSaveOTPForAuth(ctx, otherAuthID)
+	require.NoError(t, err)
+	second, err := svc.GenerateAndSaveOTPForAuth(ctx, authID)
+	require.NoError(t, err)
+	third, err := svc.GenerateAndSaveOTPForAuth(ctx, authID)
+	require.NoError(t, err)
+
+	require.NoError(t, svc.PruneSupersededOTPs(ctx, authID))
+
+	for _, code := range []string{first, second} {
+		_, ok := fakeRepo.getOTP(hashOTP(code))
+		assert.False(t, ok)
+	}
+	_, ok := fakeRepo.getOTP(hashOTP(third))
+	assert.True(t, ok)
+	assert.Equal(t, 2, fakeRepo.otpCount())
+}
+
 func TestService_VerifyOTP_Success(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
 	otpCode := "ABC123"
 	authID := newPGUUID()
@@ -58,35 +173,56 @@ func TestService_VerifyOTP_Success(t *testing.T) {
 
 	fakeRepo.setAuthEmail(authID, email)
 	fakeRepo.setUserForAuth(authID, userID)
-	fakeRepo.setOTP(hashOTP(otpCode), db.GetUserIdAndEmailByOtpCodeRow{
+	fakeRepo.setOTP(hashOTP(otpCode), db.GetActiveOtpCodesByEmailRow{
 		AuthID: authID,
 		Email:  email,
 		ID:     userID,
 	})
 
-	err := svc.VerifyOTP(ctx, email, otpCode)
+	gotUserID, err := svc.VerifyOTP(ctx, email, otpCode)
 	require.NoError(t, err)
+	assert.Equal(t, uuidToString(userID), uuidToString(gotUserID))
 }
 
 func TestService_VerifyOTP_EmailMismatch(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
-	fakeRepo.setOTP(hashOTP("ABC123"), db.GetUserIdAndEmailByOtpCodeRow{Email: "other@example.com"})
+	fakeRepo.setOTP(hashOTP("ABC123"), db.GetActiveOtpCodesByEmailRow{Email: "other@example.com"})
 
-	err := svc.VerifyOTP(ctx, "user@example.com", "ABC123")
+	_, err := svc.VerifyOTP(ctx, "user@example.com", "ABC123")
 	require.ErrorIs(t, err, auth.ErrInvalidOtpCode)
 }
 
-func TestService_VerifyOTP_NotFound(t *testing.T) {
+func TestService_VerifyOTP_SameCodeForTwoAccounts(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
-	fakeRepo.setGetOtpErr(auth.ErrNotFound)
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
-	err := svc.VerifyOTP(ctx, "user@example.com", "ABC123")
-	require.ErrorIs(t, err, auth.ErrNotFound)
+	firstUserID := newPGUUID()
+	secondUserID := newPGUUID()
+	fakeRepo.setOTP(hashOTP("ABC123"), db.GetActiveOtpCodesByEmailRow{Email: "first@example.com", ID: firstUserID})
+	fakeRepo.setOTP(hashOTP("ABC123"), db.GetActiveOtpCodesByEmailRow{Email: "second@example.com", ID: secondUserID})
+
+	gotUserID, err := svc.VerifyOTP(ctx, "second@example.com", "ABC123")
+	require.NoError(t, err)
+	assert.Equal(t, uuidToString(secondUserID), uuidToString(gotUserID))
+
+	gotUserID, err = svc.VerifyOTP(ctx, "first@example.com", "ABC123")
+	require.NoError(t, err)
+	assert.Equal(t, uuidToString(firstUserID), uuidToString(gotUserID))
+}
+
+func TestService_VerifyOTP_NoActiveCode(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	fakeRepo.setIncrementAttemptsErr(auth.ErrNotFound)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	_, err := svc.VerifyOTP(ctx, "user@example.com", "ABC123")
+	require.ErrorIs(t, err, auth.ErrInvalidOtpCode, "An expired or unsent code is an invalid code")
+	assert.NotErrorIs(t, err, qqerrors.ErrNotFound)
 }
 
 func TestService_VerifyOTP_RepoFailure(t *testing.T) {
@@ -94,18 +230,112 @@ func TestService_VerifyOTP_RepoFailure(t *testing.T) {
 	fakeRepo := newFakeRepository()
 	repoErr := errors.New("query failed")
 	fakeRepo.setGetOtpErr(repoErr)
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
-	err := svc.VerifyOTP(ctx, "user@example.com", "ABC123")
+	_, err := svc.VerifyOTP(ctx, "user@example.com", "ABC123")
 	require.ErrorIs(t, err, repoErr)
 }
 
+func TestService_VerifyOTP_LocksAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{MaxAttempts: 3})
+
+	email := "user@example.com"
+	fakeRepo.setOTP(hashOTP("ABC123"), db.GetActiveOtpCodesByEmailRow{Email: email})
+
+	for range 2 {
+		_, err := svc.VerifyOTP(ctx, email, "000000")
+		require.ErrorIs(t, err, auth.ErrInvalidOtpCode)
+	}
+
+	_, err := svc.VerifyOTP(ctx, email, "000000")
+	require.ErrorIs(t, err, auth.ErrOtpAttemptsExceeded)
+	require.ErrorIs(t, err, qqerrors.ErrTooManyRequests)
+
+	_, ok := fakeRepo.getOTP(hashOTP("ABC123"))
+	assert.False(t, ok, "expected OTP to be invalidated after lockout")
+
+	_, err = svc.VerifyOTP(ctx, email, "ABC123")
+	require.Error(t, err)
+}
+
+func TestService_VerifyOTP_RejectsAttemptsOverLimit(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{MaxAttempts: 1})
+
+	email := "user@example.com"
+	fakeRepo.setOTP(hashOTP("ABC123"), db.GetActiveOtpCodesByEmailRow{Email: email})
+	_, err := fakeRepo.IncrementOTPAttempts(ctx, email)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyOTP(ctx, email, "ABC123")
+	require.ErrorIs(t, err, auth.ErrOtpAttemptsExceeded)
+}
+
+func TestService_VerifyOTP_CountsAttempt(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	email := "user@example.com"
+	fakeRepo.setOTP(hashOTP("ABC123"), db.GetActiveOtpCodesByEmailRow{Email: email})
+
+	_, err := svc.VerifyOTP(ctx, email, "ABC123")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), fakeRepo.attempts(email))
+}
+
+func TestService_MagicLink_GenerateAndConsume(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID := newPGUUID()
+	userID := newPGUUID()
+	email := "user@example.com"
+	fakeRepo.setAuthEmail(authID, email)
+	fakeRepo.setUserForAuth(authID, userID)
+
+	nonce, err := svc.GenerateAndSaveMagicLinkForAuth(ctx, authID)
+	require.NoError(t, err)
+	assert.Len(t, nonce, 64)
+
+	// Only the hash is stored, and it is never accepted as a typed code.
+	stored, ok := fakeRepo.getOTP(hashOTP(nonce))
+	require.True(t, ok, "expected magic link hash to be stored")
+	assert.Equal(t, uuidToString(authID), uuidToString(stored.AuthID))
+	_, err = svc.VerifyOTP(ctx, email, nonce)
+	require.ErrorIs(t, err, auth.ErrInvalidOtpCode)
+
+	// Another user cannot consume the link.
+	err = svc.ConsumeMagicLink(ctx, newPGUUID(), nonce)
+	require.ErrorIs(t, err, auth.ErrInvalidMagicLink)
+
+	require.NoError(t, svc.ConsumeMagicLink(ctx, userID, nonce))
+
+	err = svc.ConsumeMagicLink(ctx, userID, nonce)
+	require.ErrorIs(t, err, auth.ErrInvalidMagicLink)
+	assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+}
+
+func TestService_GenerateAndSaveMagicLinkForAuth_CreateError(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	fakeRepo.setCreateOTPErr(errors.New("db unavailable"))
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	_, err := svc.GenerateAndSaveMagicLinkForAuth(ctx, newPGUUID())
+	require.Error(t, err)
+}
+
 func TestService_CreateNewAuthForOTPLogin_Success(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
 	expectedID := newPGUUID()
 	fakeRepo.setNextAuthID(expectedID)
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
 	id, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
 	require.NoError(t, err)
@@ -117,7 +347,7 @@ func TestService_CreateNewAuthForOTPLogin_Error(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
 	fakeRepo.setCreateAuthErr(qqerrors.ErrUniqueViolation)
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
 	_, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
 	require.ErrorIs(t, err, qqerrors.ErrUniqueViolation)
@@ -126,7 +356,7 @@ func TestService_CreateNewAuthForOTPLogin_Error(t *testing.T) {
 func TestService_KillOrphanedOTPs_Success(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
 	err := svc.KillOrphanedOTPs(ctx, "user@example.com")
 	require.NoError(t, err)
@@ -140,7 +370,7 @@ func TestService_KillOrphanedOTPs_Error(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
 	fakeRepo.setKillOrphanedErr(errors.New("db error"))
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
 	err := svc.KillOrphanedOTPs(ctx, "user@example.com")
 	require.Error(t, err)
@@ -149,7 +379,7 @@ func TestService_KillOrphanedOTPs_Error(t *testing.T) {
 func TestService_KillOrphanedOTPsByUserID_Success(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 	userID := newPGUUID()
 
 	err := svc.KillOrphanedOTPsByUserID(ctx, userID)
@@ -164,7 +394,7 @@ func TestService_KillOrphanedOTPsByUserID_Error(t *testing.T) {
 	ctx := context.Background()
 	fakeRepo := newFakeRepository()
 	fakeRepo.setKillOrphanedByUserIDErr(errors.New("db error"))
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
 	err := svc.KillOrphanedOTPsByUserID(ctx, newPGUUID())
 	require.Error(t, err)
@@ -172,7 +402,7 @@ func TestService_KillOrphanedOTPsByUserID_Error(t *testing.T) {
 
 func TestService_WithTx_DelegatesToRepository(t *testing.T) {
 	fakeRepo := newFakeRepository()
-	svc := auth.NewService(fakeRepo)
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
 
 	require.Equal(t, 0, fakeRepo.withTxCount())
 
@@ -180,3 +410,918 @@ func TestService_WithTx_DelegatesToRepository(t *testing.T) {
 
 	require.Equal(t, 1, fakeRepo.withTxCount())
 }
+
+func TestService_LinkIdentity_Success(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+
+	identity, err := svc.LinkIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     *authID,
+		Provider:   db.AuthProviderGoogleOauth,
+		ProviderID: "google-sub",
+		Email:      "user@gmail.com",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, db.AuthProviderGoogleOauth, identity.Provider)
+
+	identities, err := svc.ListIdentities(ctx, *authID)
+	require.NoError(t, err)
+	assert.Len(t, identities, 2)
+}
+
+func TestService_LinkIdentity_AlreadyLinkedElsewhere(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	_, err := svc.CreateNewAuthForOAuthLogin(ctx, "first@example.com", db.AuthProviderGoogleOauth, "google-sub")
+	require.NoError(t, err)
+	secondAuthID, err := svc.CreateNewAuthForOTPLogin(ctx, "second@example.com")
+	require.NoError(t, err)
+
+	_, err = svc.LinkIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     *secondAuthID,
+		Provider:   db.AuthProviderGoogleOauth,
+		ProviderID: "google-sub",
+		Email:      "first@example.com",
+	})
+	require.ErrorIs(t, err, auth.ErrIdentityLinked)
+	assert.ErrorIs(t, err, qqerrors.ErrUniqueViolation)
+}
+
+func TestService_UnlinkIdentity_Success(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+	google, err := svc.LinkIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     *authID,
+		Provider:   db.AuthProviderGoogleOauth,
+		ProviderID: "google-sub",
+		Email:      "user@example.com",
+	})
+	require.NoError(t, err)
+
+	err = svc.UnlinkIdentity(ctx, *authID, google.ID)
+	require.NoError(t, err)
+
+	hasGoogle, err := svc.HasIdentity(ctx, *authID, db.AuthProviderGoogleOauth)
+	require.NoError(t, err)
+	assert.False(t, hasGoogle)
+	assert.Equal(t, []pgtype.UUID{*authID}, fakeRepo.lockedAuthIDs())
+}
+
+func TestService_UnlinkIdentity_RefusesLastIdentity(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+	identities, err := svc.ListIdentities(ctx, *authID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+
+	err = svc.UnlinkIdentity(ctx, *authID, identities[0].ID)
+	require.ErrorIs(t, err, auth.ErrLastIdentity)
+
+	hasEmail, err := svc.HasIdentity(ctx, *authID, db.AuthProviderEmailOtp)
+	require.NoError(t, err)
+	assert.True(t, hasEmail)
+}
+
+func TestService_UnlinkIdentity_UnknownIdentity(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+	_, err = svc.LinkIdentity(ctx, db.InsertAuthIdentityParams{
+		AuthID:     *authID,
+		Provider:   db.AuthProviderGoogleOauth,
+		ProviderID: "google-sub",
+		Email:      "user@example.com",
+	})
+	require.NoError(t, err)
+
+	err = svc.UnlinkIdentity(ctx, *authID, newPGUUID())
+	require.ErrorIs(t, err, auth.ErrNotFound)
+}
+
+func TestService_CheckSuspension(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+
+	t.Run("Not suspended", func(t *testing.T) {
+		assert.NoError(t, svc.CheckSuspension(ctx, *authID))
+	})
+
+	t.Run("Permanent suspension", func(t *testing.T) {
+		fakeRepo.setSuspension(*authID, "spam", time.Time{})
+
+		err := svc.CheckSuspension(ctx, *authID)
+		require.ErrorIs(t, err, auth.ErrAccountSuspended)
+		assert.ErrorIs(t, err, qqerrors.ErrAccountSuspended)
+		assert.NotContains(t, err.Error(), "until")
+	})
+
+	t.Run("Temporary suspension reports its end", func(t *testing.T) {
+		until := time.Date(2099, 1, 2, 3, 4, 5, 0, time.UTC)
+		fakeRepo.setSuspension(*authID, "spam", until)
+
+		err := svc.CheckSuspension(ctx, *authID)
+		require.ErrorIs(t, err, auth.ErrAccountSuspended)
+		assert.Contains(t, err.Error(), "until 2099-01-02T03:04:05Z")
+	})
+
+	t.Run("Expired suspension", func(t *testing.T) {
+		fakeRepo.setSuspension(*authID, "spam", time.Now().Add(-time.Minute))
+
+		assert.NoError(t, svc.CheckSuspension(ctx, *authID))
+	})
+}
+
+func TestService_SuspendAccount_RecordsEvent(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+	until := time.Now().Add(24 * time.Hour)
+
+	err = svc.SuspendAccount(ctx, auth.SuspendParams{
+		AuthID: *authID,
+		Reason: "  abusive messages ",
+		Until:  until,
+		Actor:  "moderator@example.com",
+	})
+	require.NoError(t, err)
+
+	require.ErrorIs(t, svc.CheckSuspension(ctx, *authID), auth.ErrAccountSuspended)
+	events := fakeRepo.suspensionEvents()
+	require.Len(t, events, 1)
+	assert.Equal(t, db.SuspensionActionSuspended, events[0].Action)
+	assert.Equal(t, "abusive messages", events[0].Reason, "Reason should be trimmed")
+	assert.Equal(t, "moderator@example.com", events[0].Actor)
+	assert.True(t, events[0].SuspendedUntil.Valid)
+	assert.WithinDuration(t, until, events[0].SuspendedUntil.Time, time.Second)
+}
+
+func TestService_SuspendAccount_Validation(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		params auth.SuspendParams
+	}{
+		{name: "Missing reason", params: auth.SuspendParams{AuthID: *authID, Reason: " ", Actor: "admin"}},
+		{name: "Missing actor", params: auth.SuspendParams{AuthID: *authID, Reason: "spam"}},
+		{
+			name: "Until in the past",
+			params: auth.SuspendParams{
+				AuthID: *authID, Reason: "spam", Actor: "admin", Until: time.Now().Add(-time.Hour),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.SuspendAccount(ctx, tt.params)
+
+			require.ErrorIs(t, err, auth.ErrInvalidSuspension)
+			assert.ErrorIs(t, err, qqerrors.ErrValidationError)
+		})
+	}
+	assert.NoError(t, svc.CheckSuspension(ctx, *authID))
+	assert.Empty(t, fakeRepo.suspensionEvents())
+}
+
+func TestService_SuspendAccount_UnknownAuth(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	err := svc.SuspendAccount(ctx, auth.SuspendParams{AuthID: newPGUUID(), Reason: "spam", Actor: "admin"})
+
+	require.ErrorIs(t, err, auth.ErrNotFound)
+	assert.Empty(t, fakeRepo.suspensionEvents())
+}
+
+func TestService_LiftSuspension(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+
+	err = svc.LiftSuspension(ctx, *authID, "admin", "appeal accepted")
+	require.ErrorIs(t, err, auth.ErrNotFound, "Lifting requires an active suspension")
+
+	require.NoError(t, svc.SuspendAccount(ctx, auth.SuspendParams{AuthID: *authID, Reason: "spam", Actor: "admin"}))
+	require.NoError(t, svc.LiftSuspension(ctx, *authID, "admin", "appeal accepted"))
+
+	assert.NoError(t, svc.CheckSuspension(ctx, *authID))
+	events, err := svc.ListSuspensionEvents(ctx, *authID)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, db.SuspensionActionLifted, events[0].Action, "Newest event first")
+	assert.Equal(t, "appeal accepted", events[0].Reason)
+	assert.Equal(t, db.SuspensionActionSuspended, events[1].Action)
+}
+
+func TestService_StartEmailChange_Success(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{Lifetime: 10 * time.Minute})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "old@example.com")
+	require.NoError(t, err)
+
+	codes, err := svc.StartEmailChange(ctx, *authID, "new@example.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, "old@example.com", codes.CurrentEmail)
+	assert.Equal(t, "new@example.com", codes.NewEmail)
+	assert.Len(t, codes.CurrentCode, 6)
+	assert.Len(t, codes.NewCode, 6)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), codes.ExpiresAt, 5*time.Second)
+	assert.Contains(t, fakeRepo.lockedAuthIDs(), *authID)
+
+	change, ok := fakeRepo.emailChange(*authID)
+	require.True(t, ok)
+	assert.Equal(t, "new@example.com", change.NewEmail)
+	assert.Equal(t, hashOTP(codes.CurrentCode), change.CurrentCodeHash, "Only the code hashes should be stored")
+	assert.Equal(t, hashOTP(codes.NewCode), change.NewCodeHash)
+	assert.Equal(t, "old@example.com", fakeRepo.authEmail(*authID), "Email should not change before confirmation")
+}
+
+func TestService_StartEmailChange_Rejected(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{ResendCooldown: 30 * time.Second})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "old@example.com")
+	require.NoError(t, err)
+	_, err = svc.CreateNewAuthForOTPLogin(ctx, "taken@example.com")
+	require.NoError(t, err)
+
+	t.Run("Same email", func(t *testing.T) {
+		_, err := svc.StartEmailChange(ctx, *authID, "OLD@example.com")
+
+		require.ErrorIs(t, err, auth.ErrSameEmail)
+		assert.ErrorIs(t, err, qqerrors.ErrValidationError)
+	})
+
+	t.Run("Email in use", func(t *testing.T) {
+		_, err := svc.StartEmailChange(ctx, *authID, "taken@example.com")
+
+		require.ErrorIs(t, err, auth.ErrEmailInUse)
+		assert.ErrorIs(t, err, qqerrors.ErrUniqueViolation)
+	})
+
+	t.Run("Restart within the cooldown", func(t *testing.T) {
+		_, err := svc.StartEmailChange(ctx, *authID, "new@example.com")
+		require.NoError(t, err)
+		fakeRepo.setEmailChangeAge(*authID, 10*time.Second)
+
+		_, err = svc.StartEmailChange(ctx, *authID, "other@example.com")
+
+		require.ErrorIs(t, err, auth.ErrEmailChangeTooSoon)
+		var retryErr *qqerrors.RetryAfterError
+		require.ErrorAs(t, err, &retryErr)
+		assert.Equal(t, 20*time.Second, retryErr.RetryAfter)
+		change, _ := fakeRepo.emailChange(*authID)
+		assert.Equal(t, "new@example.com", change.NewEmail, "Pending change should be kept")
+	})
+
+	t.Run("Restart after the cooldown replaces the change", func(t *testing.T) {
+		fakeRepo.setEmailChangeAge(*authID, time.Minute)
+
+		codes, err := svc.StartEmailChange(ctx, *authID, "other@example.com")
+
+		require.NoError(t, err)
+		change, _ := fakeRepo.emailChange(*authID)
+		assert.Equal(t, "other@example.com", change.NewEmail)
+		assert.Equal(t, hashOTP(codes.NewCode), change.NewCodeHash)
+	})
+}
+
+func TestService_VerifyEmailChange(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{MaxAttempts: 3})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "old@example.com")
+	require.NoError(t, err)
+
+	t.Run("No pending change", func(t *testing.T) {
+		_, err := svc.VerifyEmailChange(ctx, *authID, "AAAAAA", "BBBBBB")
+
+		require.ErrorIs(t, err, auth.ErrEmailChangeNotFound)
+		assert.ErrorIs(t, err, qqerrors.ErrNotFound)
+	})
+
+	codes, err := svc.StartEmailChange(ctx, *authID, "new@example.com")
+	require.NoError(t, err)
+
+	t.Run("Codes swapped", func(t *testing.T) {
+		_, err := svc.VerifyEmailChange(ctx, *authID, codes.NewCode, codes.CurrentCode)
+
+		require.ErrorIs(t, err, auth.ErrInvalidEmailChange)
+		assert.ErrorIs(t, err, qqerrors.ErrValidationError)
+	})
+
+	t.Run("Both codes match", func(t *testing.T) {
+		change, err := svc.VerifyEmailChange(ctx, *authID, strings.ToLower(codes.CurrentCode), codes.NewCode)
+
+		require.NoError(t, err)
+		assert.Equal(t, "new@example.com", change.NewEmail)
+		assert.Equal(t, int32(2), change.Attempts, "Every check should consume an attempt")
+	})
+}
+
+func TestService_VerifyEmailChange_AttemptsExceeded(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{MaxAttempts: 2})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "old@example.com")
+	require.NoError(t, err)
+	codes, err := svc.StartEmailChange(ctx, *authID, "new@example.com")
+	require.NoError(t, err)
+
+	_, err = svc.VerifyEmailChange(ctx, *authID, codes.CurrentCode, "WRONG1")
+	require.ErrorIs(t, err, auth.ErrInvalidEmailChange)
+
+	_, err = svc.VerifyEmailChange(ctx, *authID, codes.CurrentCode, "WRONG2")
+	require.ErrorIs(t, err, auth.ErrOtpAttemptsExceeded)
+	_, ok := fakeRepo.emailChange(*authID)
+	assert.False(t, ok, "Change should be dropped once the attempts are used up")
+
+	_, err = svc.VerifyEmailChange(ctx, *authID, codes.CurrentCode, codes.NewCode)
+	assert.ErrorIs(t, err, auth.ErrEmailChangeNotFound, "Correct codes should not work after the limit")
+}
+
+func TestService_ApplyEmailChange(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "old@example.com")
+	require.NoError(t, err)
+	codes, err := svc.StartEmailChange(ctx, *authID, "new@example.com")
+	require.NoError(t, err)
+	change, err := svc.VerifyEmailChange(ctx, *authID, codes.CurrentCode, codes.NewCode)
+	require.NoError(t, err)
+
+	oldEmail, err := svc.ApplyEmailChange(ctx, *authID, *change)
+
+	require.NoError(t, err)
+	assert.Equal(t, "old@example.com", oldEmail)
+	assert.Equal(t, "new@example.com", fakeRepo.authEmail(*authID))
+	assert.Contains(t, fakeRepo.killOrphanedEmails(), "old@example.com", "Codes sent to the old address should die")
+	identities, err := svc.ListIdentities(ctx, *authID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "new@example.com", identities[0].ProviderID, "Email login should follow the new address")
+
+	_, err = svc.ApplyEmailChange(ctx, *authID, *change)
+	assert.ErrorIs(t, err, auth.ErrEmailChangeNotFound, "A change should only apply once")
+}
+
+func TestService_ApplyEmailChange_EmailTakenMeanwhile(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "old@example.com")
+	require.NoError(t, err)
+	codes, err := svc.StartEmailChange(ctx, *authID, "new@example.com")
+	require.NoError(t, err)
+	change, err := svc.VerifyEmailChange(ctx, *authID, codes.CurrentCode, codes.NewCode)
+	require.NoError(t, err)
+	_, err = svc.CreateNewAuthForOTPLogin(ctx, "new@example.com")
+	require.NoError(t, err)
+
+	_, err = svc.ApplyEmailChange(ctx, *authID, *change)
+
+	require.ErrorIs(t, err, auth.ErrEmailInUse)
+	assert.Equal(t, "old@example.com", fakeRepo.authEmail(*authID))
+}
+
+func TestService_RequestDeletion(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+
+	deletion, err := svc.RequestDeletion(ctx, *authID, 24*time.Hour)
+
+	require.NoError(t, err)
+	assert.Len(t, deletion.RestoreToken, 64)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), deletion.PurgeAfter, time.Minute)
+
+	err = svc.CheckSuspension(ctx, *authID)
+	require.ErrorIs(t, err, auth.ErrAccountDeleted)
+	assert.ErrorIs(t, err, qqerrors.ErrPendingDeletion)
+
+	_, err = svc.RequestDeletion(ctx, *authID, 24*time.Hour)
+	assert.ErrorIs(t, err, qqerrors.ErrUniqueViolation, "A pending deletion cannot be requested twice")
+}
+
+func TestService_RestoreDeletion(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+	deletion, err := svc.RequestDeletion(ctx, *authID, time.Hour)
+	require.NoError(t, err)
+
+	_, err = svc.RestoreDeletion(ctx, "not-the-token")
+	require.ErrorIs(t, err, auth.ErrInvalidRestoreToken)
+
+	restored, err := svc.RestoreDeletion(ctx, " "+deletion.RestoreToken+" ")
+	require.NoError(t, err)
+	assert.Equal(t, *authID, restored)
+	assert.NoError(t, svc.CheckSuspension(ctx, *authID))
+
+	_, err = svc.RestoreDeletion(ctx, deletion.RestoreToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidRestoreToken, "A restore token should only work once")
+}
+
+func TestService_PurgeAccount(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID, err := svc.CreateNewAuthForOTPLogin(ctx, "user@example.com")
+	require.NoError(t, err)
+	deletion, err := svc.RequestDeletion(ctx, *authID, time.Hour)
+	require.NoError(t, err)
+
+	due, err := svc.ListDueDeletions(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+	require.ErrorIs(t, svc.PurgeAccount(ctx, *authID), auth.ErrNotFound, "Accounts in their grace period stay")
+
+	fakeRepo.backdateDeletion(*authID)
+	_, err = svc.RestoreDeletion(ctx, deletion.RestoreToken)
+	require.ErrorIs(t, err, auth.ErrInvalidRestoreToken, "The grace period is over")
+
+	due, err = svc.ListDueDeletions(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, *authID, due[0].AuthID)
+
+	require.NoError(t, svc.PurgeAccount(ctx, *authID))
+	_, err = svc.GetAuthByID(ctx, *authID)
+	assert.ErrorIs(t, err, auth.ErrNotFound)
+}
+
+func newRefreshTokenParams(userID pgtype.UUID, expiresAt time.Time) db.InsertRefreshTokenParams {
+	return db.InsertRefreshTokenParams{
+		ID:        newPGUUID(),
+		UserID:    userID,
+		FamilyID:  newPGUUID(),
+		ExpiresAt: pgtype.Timestamp{Time: expiresAt, Valid: true},
+	}
+}
+
+func TestService_RotateRefreshToken_Success(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	userID := newPGUUID()
+	params := newRefreshTokenParams(userID, time.Now().Add(time.Hour))
+	require.NoError(t, svc.SaveRefreshToken(ctx, params))
+
+	consumed, err := svc.RotateRefreshToken(ctx, params.ID, userID)
+	require.NoError(t, err)
+	assert.Equal(t, params.FamilyID, consumed.FamilyID)
+	assert.True(t, consumed.UsedAt.Valid)
+	assert.Empty(t, fakeRepo.revokedFamilies())
+}
+
+func TestService_RotateRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	userID := newPGUUID()
+	params := newRefreshTokenParams(userID, time.Now().Add(time.Hour))
+	require.NoError(t, svc.SaveRefreshToken(ctx, params))
+
+	_, err := svc.RotateRefreshToken(ctx, params.ID, userID)
+	require.NoError(t, err)
+
+	_, err = svc.RotateRefreshToken(ctx, params.ID, userID)
+	require.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+	assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+	assert.Equal(t, []pgtype.UUID{params.FamilyID}, fakeRepo.revokedFamilies())
+}
+
+func TestService_RotateRefreshToken_Expired(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	userID := newPGUUID()
+	params := newRefreshTokenParams(userID, time.Now().Add(-time.Minute))
+	require.NoError(t, svc.SaveRefreshToken(ctx, params))
+
+	_, err := svc.RotateRefreshToken(ctx, params.ID, userID)
+	require.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+	assert.Empty(t, fakeRepo.revokedFamilies())
+}
+
+func TestService_RotateRefreshToken_Unknown(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	_, err := svc.RotateRefreshToken(ctx, newPGUUID(), newPGUUID())
+	require.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+}
+
+func TestService_RotateRefreshToken_OtherUser(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	params := newRefreshTokenParams(newPGUUID(), time.Now().Add(time.Hour))
+	require.NoError(t, svc.SaveRefreshToken(ctx, params))
+
+	_, err := svc.RotateRefreshToken(ctx, params.ID, newPGUUID())
+	require.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+}
+
+func TestService_RotateRefreshToken_ReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	userID := newPGUUID()
+	session, err := svc.CreateSession(ctx, db.InsertSessionParams{UserID: userID})
+	require.NoError(t, err)
+	params := newRefreshTokenParams(userID, time.Now().Add(time.Hour))
+	params.SessionID = session.ID
+	require.NoError(t, svc.SaveRefreshToken(ctx, params))
+
+	_, err = svc.RotateRefreshToken(ctx, params.ID, userID)
+	require.NoError(t, err)
+	_, err = svc.RotateRefreshToken(ctx, params.ID, userID)
+	require.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+
+	active, err := svc.IsSessionActive(ctx, session.ID, userID)
+	require.NoError(t, err)
+	assert.False(t, active, "Reuse should end the session")
+}
+
+func TestService_IsSessionActive(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	userID := newPGUUID()
+	session, err := svc.CreateSession(ctx, db.InsertSessionParams{
+		UserID:    userID,
+		UserAgent: pgtype.Text{String: "curl/8", Valid: true},
+	})
+	require.NoError(t, err)
+
+	active, err := svc.IsSessionActive(ctx, session.ID, userID)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	active, err = svc.IsSessionActive(ctx, session.ID, newPGUUID())
+	require.NoError(t, err)
+	assert.False(t, active, "Session of another user")
+
+	active, err = svc.IsSessionActive(ctx, newPGUUID(), userID)
+	require.NoError(t, err)
+	assert.False(t, active, "Unknown session")
+
+	require.NoError(t, svc.RevokeSession(ctx, userID, session.ID))
+	active, err = svc.IsSessionActive(ctx, session.ID, userID)
+	require.NoError(t, err)
+	assert.False(t, active, "Revoked session")
+}
+
+func TestService_RevokeSession_RevokesRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	userID := newPGUUID()
+	session, err := svc.CreateSession(ctx, db.InsertSessionParams{UserID: userID})
+	require.NoError(t, err)
+	other, err := svc.CreateSession(ctx, db.InsertSessionParams{UserID: userID})
+	require.NoError(t, err)
+
+	params := newRefreshTokenParams(userID, time.Now().Add(time.Hour))
+	params.SessionID = session.ID
+	require.NoError(t, svc.SaveRefreshToken(ctx, params))
+	otherParams := newRefreshTokenParams(userID, time.Now().Add(time.Hour))
+	otherParams.SessionID = other.ID
+	require.NoError(t, svc.SaveRefreshToken(ctx, otherParams))
+
+	require.NoError(t, svc.RevokeSession(ctx, userID, session.ID))
+
+	assert.True(t, fakeRepo.refreshToken(params.ID).RevokedAt.Valid)
+	assert.False(t, fakeRepo.refreshToken(otherParams.ID).RevokedAt.Valid, "Other sessions keep their tokens")
+
+	sessions, err := svc.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, other.ID, sessions[0].ID)
+
+	err = svc.RevokeSession(ctx, userID, session.ID)
+	require.ErrorIs(t, err, auth.ErrNotFound, "Already revoked")
+	assert.ErrorIs(t, err, qqerrors.ErrNotFound)
+	assert.ErrorIs(t, svc.RevokeSession(ctx, newPGUUID(), other.ID), auth.ErrNotFound, "Other user's session")
+}
+
+func TestService_RevokeAllSessions(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	userID := newPGUUID()
+	strangerID := newPGUUID()
+	for range 2 {
+		session, err := svc.CreateSession(ctx, db.InsertSessionParams{UserID: userID})
+		require.NoError(t, err)
+		params := newRefreshTokenParams(userID, time.Now().Add(time.Hour))
+		params.SessionID = session.ID
+		require.NoError(t, svc.SaveRefreshToken(ctx, params))
+	}
+	stranger, err := svc.CreateSession(ctx, db.InsertSessionParams{UserID: strangerID})
+	require.NoError(t, err)
+
+	require.NoError(t, svc.RevokeAllSessions(ctx, userID))
+
+	sessions, err := svc.ListSessions(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	active, err := svc.IsSessionActive(ctx, stranger.ID, strangerID)
+	require.NoError(t, err)
+	assert.True(t, active, "Other users are unaffected")
+	for _, token := range fakeRepo.state.refreshTokens {
+		assert.True(t, token.RevokedAt.Valid)
+	}
+}
+
+// enrollTOTP starts and confirms enrollment with the code of the current step
+// and returns the secret and recovery codes.
+func enrollTOTP(t *testing.T, svc auth.Service, authID pgtype.UUID) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := svc.StartTOTPEnrollment(ctx, authID)
+	require.NoError(t, err)
+
+	code, err := totp.CodeAt(enrollment.Secret, totp.StepAt(time.Now()))
+	require.NoError(t, err)
+	recoveryCodes, err := svc.ConfirmTOTPEnrollment(ctx, authID, code)
+	require.NoError(t, err)
+	return enrollment.Secret, recoveryCodes
+}
+
+func TestService_StartTOTPEnrollment(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{TOTPIssuer: "QQ Test"})
+
+	authID := newPGUUID()
+	fakeRepo.setAuthEmail(authID, "user@example.com")
+
+	enrollment, err := svc.StartTOTPEnrollment(ctx, authID)
+	require.NoError(t, err)
+	assert.Len(t, enrollment.Secret, 32)
+	assert.Contains(t, enrollment.ProvisioningURI, "otpauth://totp/QQ%20Test:user@example.com?")
+	assert.Contains(t, enrollment.ProvisioningURI, "secret="+enrollment.Secret)
+
+	// Enrollment stays pending until confirmed.
+	enabled, err := svc.IsTOTPEnabled(ctx, authID)
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	// Starting again replaces the pending secret.
+	again, err := svc.StartTOTPEnrollment(ctx, authID)
+	require.NoError(t, err)
+	assert.NotEqual(t, enrollment.Secret, again.Secret)
+	stored, ok := fakeRepo.totp(authID)
+	require.True(t, ok)
+	assert.Equal(t, again.Secret, stored.Secret)
+
+	_, err = svc.StartTOTPEnrollment(ctx, newPGUUID())
+	require.ErrorIs(t, err, auth.ErrNotFound)
+}
+
+func TestService_ConfirmTOTPEnrollment(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID := newPGUUID()
+	fakeRepo.setAuthEmail(authID, "user@example.com")
+
+	_, err := svc.ConfirmTOTPEnrollment(ctx, authID, "123456")
+	require.ErrorIs(t, err, auth.ErrTOTPNotEnabled)
+
+	enrollment, err := svc.StartTOTPEnrollment(ctx, authID)
+	require.NoError(t, err)
+
+	wrong, err := totp.CodeAt(enrollment.Secret, totp.StepAt(time.Now())+5)
+	require.NoError(t, err)
+	_, err = svc.ConfirmTOTPEnrollment(ctx, authID, wrong)
+	require.ErrorIs(t, err, auth.ErrTOTPCodeMismatch)
+	assert.ErrorIs(t, err, qqerrors.ErrValidationError)
+
+	code, err := totp.CodeAt(enrollment.Secret, totp.StepAt(time.Now()))
+	require.NoError(t, err)
+	recoveryCodes, err := svc.ConfirmTOTPEnrollment(ctx, authID, code)
+	require.NoError(t, err)
+	require.Len(t, recoveryCodes, 10)
+	assert.Regexp(t, `^[0-9a-f]{5}-[0-9a-f]{5}$`, recoveryCodes[0])
+	assert.Equal(t, 10, fakeRepo.recoveryCodeCount(authID))
+
+	enabled, err := svc.IsTOTPEnabled(ctx, authID)
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	_, err = svc.ConfirmTOTPEnrollment(ctx, authID, code)
+	require.ErrorIs(t, err, auth.ErrTOTPAlreadyEnabled)
+	_, err = svc.StartTOTPEnrollment(ctx, authID)
+	require.ErrorIs(t, err, auth.ErrTOTPAlreadyEnabled)
+	assert.ErrorIs(t, err, qqerrors.ErrUniqueViolation)
+}
+
+func TestService_VerifySecondFactor_TOTPCode(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID := newPGUUID()
+	fakeRepo.setAuthEmail(authID, "user@example.com")
+	secret, _ := enrollTOTP(t, svc, authID)
+
+	// The code used to confirm enrollment cannot be replayed.
+	confirmed, ok := fakeRepo.totp(authID)
+	require.True(t, ok)
+	confirmCode, err := totp.CodeAt(secret, confirmed.LastUsedStep)
+	require.NoError(t, err)
+	err = svc.VerifySecondFactor(ctx, authID, confirmCode)
+	require.ErrorIs(t, err, auth.ErrInvalidTOTPCode)
+
+	nextStep := confirmed.LastUsedStep + 1
+	next, err := totp.CodeAt(secret, nextStep)
+	require.NoError(t, err)
+	require.NoError(t, svc.VerifySecondFactor(ctx, authID, " "+next+" "))
+
+	stored, ok := fakeRepo.totp(authID)
+	require.True(t, ok)
+	assert.Equal(t, nextStep, stored.LastUsedStep)
+	assert.Zero(t, stored.FailedAttempts)
+
+	err = svc.VerifySecondFactor(ctx, authID, next)
+	require.ErrorIs(t, err, auth.ErrInvalidTOTPCode)
+	assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+}
+
+func TestService_VerifySecondFactor_RecoveryCode(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID := newPGUUID()
+	fakeRepo.setAuthEmail(authID, "user@example.com")
+	_, recoveryCodes := enrollTOTP(t, svc, authID)
+
+	// Case, spaces and the dash are ignored.
+	typed := strings.ToUpper(strings.ReplaceAll(recoveryCodes[0], "-", " "))
+	require.NoError(t, svc.VerifySecondFactor(ctx, authID, typed))
+	assert.Equal(t, 9, fakeRepo.recoveryCodeCount(authID))
+
+	err := svc.VerifySecondFactor(ctx, authID, recoveryCodes[0])
+	require.ErrorIs(t, err, auth.ErrInvalidTOTPCode)
+
+	// Recovery codes belong to one account.
+	otherAuthID := newPGUUID()
+	fakeRepo.setAuthEmail(otherAuthID, "other@example.com")
+	enrollTOTP(t, svc, otherAuthID)
+	err = svc.VerifySecondFactor(ctx, otherAuthID, recoveryCodes[1])
+	require.ErrorIs(t, err, auth.ErrInvalidTOTPCode)
+}
+
+func TestService_VerifySecondFactor_AttemptsExceeded(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	maxAttempts := 3
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{MaxAttempts: maxAttempts})
+
+	authID := newPGUUID()
+	fakeRepo.setAuthEmail(authID, "user@example.com")
+	secret, _ := enrollTOTP(t, svc, authID)
+
+	for range maxAttempts {
+		err := svc.VerifySecondFactor(ctx, authID, "wrong-code")
+		require.ErrorIs(t, err, auth.ErrInvalidTOTPCode)
+	}
+
+	next, err := totp.CodeAt(secret, totp.StepAt(time.Now())+1)
+	require.NoError(t, err)
+	err = svc.VerifySecondFactor(ctx, authID, next)
+	require.ErrorIs(t, err, auth.ErrTOTPAttemptsExceeded)
+	assert.ErrorIs(t, err, qqerrors.ErrTooManyRequests)
+
+	// A new login challenge gets a fresh budget.
+	require.NoError(t, svc.ResetTOTPAttempts(ctx, authID))
+	require.NoError(t, svc.VerifySecondFactor(ctx, authID, next))
+}
+
+func TestService_VerifySecondFactor_NotEnabled(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{})
+
+	authID := newPGUUID()
+	fakeRepo.setAuthEmail(authID, "user@example.com")
+
+	err := svc.VerifySecondFactor(ctx, authID, "123456")
+	require.ErrorIs(t, err, auth.ErrTOTPNotEnabled)
+
+	// A pending enrollment does not count.
+	_, err = svc.StartTOTPEnrollment(ctx, authID)
+	require.NoError(t, err)
+	err = svc.VerifySecondFactor(ctx, authID, "123456")
+	require.ErrorIs(t, err, auth.ErrTOTPNotEnabled)
+}
+
+// revocationRecorder is a SessionRevocationListener that remembers what it was
+// told.
+type revocationRecorder struct {
+	sessions []pgtype.UUID
+	users    []pgtype.UUID
+}
+
+func (r *revocationRecorder) SessionRevoked(sessionID pgtype.UUID) {
+	r.sessions = append(r.sessions, sessionID)
+}
+
+func (r *revocationRecorder) UserSessionsRevoked(userID pgtype.UUID) {
+	r.users = append(r.users, userID)
+}
+
+func TestService_RevocationListener(t *testing.T) {
+	ctx := context.Background()
+	fakeRepo := newFakeRepository()
+	recorder := &revocationRecorder{}
+	svc := auth.NewService(fakeRepo, environment.OTPEnvironment{}, auth.WithSessionRevocationListener(recorder))
+
+	userID := newPGUUID()
+	session, err := svc.CreateSession(ctx, db.InsertSessionParams{UserID: userID})
+	require.NoError(t, err)
+	require.NoError(t, svc.RevokeSession(ctx, userID, session.ID))
+	assert.Equal(t, []pgtype.UUID{session.ID}, recorder.sessions)
+
+	require.ErrorIs(t, svc.RevokeSession(ctx, userID, session.ID), auth.ErrNotFound)
+	assert.Len(t, recorder.sessions, 1, "Failed revocations are not announced")
+
+	require.NoError(t, svc.RevokeAllSessions(ctx, userID))
+	assert.Equal(t, []pgtype.UUID{userID}, recorder.users)
+
+	reused, err := svc.CreateSession(ctx, db.InsertSessionParams{UserID: userID})
+	require.NoError(t, err)
+	params := newRefreshTokenParams(userID, time.Now().Add(time.Hour))
+	params.SessionID = reused.ID
+	require.NoError(t, svc.SaveRefreshToken(ctx, params))
+	_, err = svc.RotateRefreshToken(ctx, params.ID, userID)
+	require.NoError(t, err)
+	_, err = svc.RotateRefreshToken(ctx, params.ID, userID)
+	require.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+	assert.Equal(t, []pgtype.UUID{session.ID, reused.ID}, recorder.sessions, "Refresh token reuse ends the session")
+}
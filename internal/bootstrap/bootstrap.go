@@ -2,33 +2,66 @@ package bootstrap
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/abdurrahimagca/qq-back/internal/account"
 	"github.com/abdurrahimagca/qq-back/internal/auth"
 	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/export"
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	"github.com/abdurrahimagca/qq-back/internal/middleware"
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
 	"github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	oauthport "github.com/abdurrahimagca/qq-back/internal/platform/oauth"
+	"github.com/abdurrahimagca/qq-back/internal/platform/ratelimit"
 	tokenport "github.com/abdurrahimagca/qq-back/internal/platform/token"
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
 	"github.com/abdurrahimagca/qq-back/internal/registration"
 	"github.com/abdurrahimagca/qq-back/internal/user"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humago"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// publicPaths are served without a bearer token; everything else goes through
+// the auth middleware.
+var publicPaths = []string{
+	"/auth/",
+	"/docs",
+	"/openapi.json",
+	"/openapi.yaml",
+	"/openapi-3.0.json",
+	"/openapi-3.0.yaml",
+	"/schemas/",
+	"/.well-known/",
+}
+
 type Bootstrap struct {
 	pool *pgxpool.Pool
 	env  *environment.Environment
 	api  huma.API
 	mux  *http.ServeMux
 
-	mailer       mailer.Service
-	authService  auth.Service
-	userService  user.Service
-	tokenService tokenport.Service
-	logger       *slog.Logger
+	mailer         mailer.Service
+	authService    auth.Service
+	userService    user.Service
+	tokenService   tokenport.Service
+	tokenKeys      *tokenport.KeyRing
+	sessionCache   *middleware.SessionCache
+	googleVerifier oauthport.Verifier
+	passkeyService webauthn.Service
+	uploader       fileupload.Uploader
+	rateLimiter    ratelimit.Limiter
+	emailPolicy    emailpolicy.Policy
+	logger         *slog.Logger
 }
 
 func New(env *environment.Environment) *Bootstrap {
@@ -54,6 +87,13 @@ func (b *Bootstrap) initInfrastructure() {
 	b.mux = http.NewServeMux()
 	humaConfig := huma.DefaultConfig(b.env.API.Title, b.env.API.Version)
 	humaConfig.DocsPath = ""
+	humaConfig.Components.SecuritySchemes = map[string]*huma.SecurityScheme{
+		"bearer": {
+			Type:         "http",
+			Scheme:       "bearer",
+			BearerFormat: "JWT",
+		},
+	}
 	b.api = humago.New(b.mux, humaConfig)
 
 	b.setupDocsEndpoint()
@@ -84,10 +124,86 @@ func (b *Bootstrap) setupDocsEndpoint() {
 func (b *Bootstrap) initDependencies() {
 	authRepo := auth.NewPgxRepository(b.pool)
 	userRepo := user.NewPgxRepository(b.pool)
-	b.authService = auth.NewService(authRepo)
+	b.sessionCache = middleware.NewSessionCache()
+	b.authService = auth.NewService(authRepo, b.env.OTP, auth.WithSessionRevocationListener(b.sessionCache))
 	b.userService = user.NewService(userRepo)
 	b.mailer = mailer.NewResendMailer(b.env)
-	b.tokenService = tokenport.NewJWTTokenService(b.env)
+	b.initTokenService()
+	b.googleVerifier = oauthport.NewGoogleVerifier(b.env.Google, nil)
+	b.passkeyService = webauthn.NewService(
+		webauthn.NewPgxRepository(b.pool), webauthnport.NewRelyingParty(b.env.WebAuthn))
+	b.initUploader()
+	b.initRateLimiter()
+	b.initEmailPolicy()
+}
+
+// initUploader stores files in R2 after converting images as the image
+// environment asks: to WebP, or to JPEG and PNG in builds without cgo.
+func (b *Bootstrap) initUploader() {
+	conf := b.env.Image
+	processor := imageprocess.NewProcessor(
+		imageprocess.WithMaxDimensions(conf.MaxWidth, conf.MaxHeight),
+		imageprocess.WithMaxPixels(conf.MaxPixels),
+		imageprocess.WithQuality(conf.Quality),
+		imageprocess.WithLossless(conf.Lossless),
+		imageprocess.WithMethod(conf.Method),
+		imageprocess.WithResampling(imageprocess.Resampling(conf.Resampling)),
+	)
+	b.uploader = fileupload.NewR2Service(b.env.R2, processor)
+}
+
+// initRateLimiter picks the backend for the request budgets. Buckets in memory
+// are per instance, so deployments with several instances use Postgres.
+func (b *Bootstrap) initRateLimiter() {
+	if b.env.RateLimit.Backend == "postgres" {
+		b.rateLimiter = ratelimit.NewPostgresLimiter(b.pool, nil)
+		return
+	}
+	b.rateLimiter = ratelimit.NewMemoryLimiter(nil)
+}
+
+// initEmailPolicy combines the rule files, the email_domain_rules table and,
+// when enabled, the bundled disposable list and the MX lookup.
+func (b *Bootstrap) initEmailPolicy() {
+	conf := b.env.EmailPolicy
+	fileRules, err := emailpolicy.LoadRuleFiles(conf.AllowlistFile, conf.DenylistFile)
+	if err != nil {
+		panic(fmt.Sprintf("error loading email domain rules: %v", err))
+	}
+	sources := []emailpolicy.RuleSource{fileRules, emailpolicy.NewPostgresRules(b.pool)}
+	if conf.BlockDisposable {
+		sources = append(sources, emailpolicy.DisposableRules())
+	}
+	var resolver emailpolicy.MXResolver
+	if conf.CheckMX {
+		resolver = net.DefaultResolver
+	}
+	b.emailPolicy = emailpolicy.NewPolicy(sources, resolver)
+}
+
+func (b *Bootstrap) initTokenService() {
+	if b.env.Token.SigningKeys == "" {
+		b.tokenService = tokenport.NewJWTTokenService(b.env)
+		return
+	}
+	specs, err := tokenport.ParseKeySpecs(b.env.Token.SigningKeys)
+	if err == nil {
+		b.tokenKeys, err = tokenport.LoadKeyRing(specs)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("error loading token signing keys: %v", err))
+	}
+	b.tokenService = tokenport.NewKeyRingTokenService(b.env, b.tokenKeys)
+}
+
+// setupJWKSEndpoint publishes the public signing keys so other services can
+// verify our tokens without holding a secret.
+func (b *Bootstrap) setupJWKSEndpoint() {
+	b.mux.HandleFunc("GET /.well-known/jwks.json", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_ = json.NewEncoder(w).Encode(b.tokenKeys.JWKS())
+	})
 }
 
 func (b *Bootstrap) registrationModule() {
@@ -97,13 +213,57 @@ func (b *Bootstrap) registrationModule() {
 		b.userService,
 		b.pool,
 		b.tokenService,
+		b.googleVerifier,
+		b.passkeyService,
+		b.emailPolicy,
+		b.rateLimiter,
+		b.env.OTP,
+		b.env.RateLimit,
+		b.env.API.TrustedProxies,
 	)
 	rm.RegisterEndpoints(b.api)
 }
 
+func (b *Bootstrap) userModule() {
+	um := user.NewModule(b.userService, b.uploader, b.env.Avatar)
+	um.RegisterEndpoints(b.api)
+}
+
+func (b *Bootstrap) accountModule() {
+	am := account.NewModule(
+		b.authService,
+		b.pool,
+		b.googleVerifier,
+		b.passkeyService,
+		b.mailer,
+		b.uploader,
+		b.emailPolicy,
+		b.env.Account,
+	)
+	am.RegisterEndpoints(b.api)
+	go am.RunPurger(context.Background(), b.env.Account.PurgeInterval)
+}
+
+func (b *Bootstrap) exportModule() {
+	em := export.NewModule(b.pool, b.uploader, b.mailer, b.env.Export)
+	em.RegisterEndpoints(b.api)
+	go em.RunWorker(context.Background(), b.env.Export.PollInterval)
+}
+
 func (b *Bootstrap) Bootstrap() {
+	b.setupJWKSEndpoint()
 	b.registrationModule()
+	b.userModule()
+	b.accountModule()
+	b.exportModule()
+}
+
+func (b *Bootstrap) handler() http.Handler {
+	authMiddleware := middleware.NewAuthMiddleware(b.tokenService, b.userService, b.authService, b.authService,
+		middleware.WithSessionCache(b.sessionCache))
+	return middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths).Handler(b.mux)
 }
+
 func (b *Bootstrap) StartServer() {
 	readTimeout := 15
 	readHeaderTimeout := 5
@@ -112,7 +272,7 @@ func (b *Bootstrap) StartServer() {
 
 	srv := &http.Server{
 		Addr:              ":" + b.env.API.Port,
-		Handler:           b.mux,
+		Handler:           b.handler(),
 		ReadTimeout:       time.Duration(readTimeout) * time.Second,
 		ReadHeaderTimeout: time.Duration(readHeaderTimeout) * time.Second,
 		WriteTimeout:      time.Duration(writeTimeout) * time.Second,
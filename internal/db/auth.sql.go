@@ -11,6 +11,191 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const authEmailExists = `-- name: AuthEmailExists :one
+SELECT EXISTS(SELECT 1 FROM auth WHERE email_normalized = $1)
+`
+
+func (q *Queries) AuthEmailExists(ctx context.Context, emailNormalized string) (bool, error) {
+	row := q.db.QueryRow(ctx, authEmailExists, emailNormalized)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const authIdentityExists = `-- name: AuthIdentityExists :one
+SELECT EXISTS(
+    SELECT 1 FROM auth_identities WHERE auth_id = $1 AND provider = $2
+)
+`
+
+type AuthIdentityExistsParams struct {
+	AuthID   pgtype.UUID  `json:"authId"`
+	Provider AuthProvider `json:"provider"`
+}
+
+func (q *Queries) AuthIdentityExists(ctx context.Context, arg AuthIdentityExistsParams) (bool, error) {
+	row := q.db.QueryRow(ctx, authIdentityExists, arg.AuthID, arg.Provider)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const clearUserAvatarKey = `-- name: ClearUserAvatarKey :one
+UPDATE users
+SET avatar_key = NULL
+WHERE id = $1
+RETURNING id, privacy_level, auth_id, username, display_name, created_at, updated_at, avatar_key
+`
+
+func (q *Queries) ClearUserAvatarKey(ctx context.Context, id pgtype.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, clearUserAvatarKey, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.PrivacyLevel,
+		&i.AuthID,
+		&i.Username,
+		&i.DisplayName,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.AvatarKey,
+	)
+	return i, err
+}
+
+const confirmAuthTotp = `-- name: ConfirmAuthTotp :execrows
+UPDATE auth_totp
+SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $1
+WHERE auth_id = $2 AND confirmed_at IS NULL
+`
+
+type ConfirmAuthTotpParams struct {
+	LastUsedStep int64       `json:"lastUsedStep"`
+	AuthID       pgtype.UUID `json:"authId"`
+}
+
+func (q *Queries) ConfirmAuthTotp(ctx context.Context, arg ConfirmAuthTotpParams) (int64, error) {
+	result, err := q.db.Exec(ctx, confirmAuthTotp, arg.LastUsedStep, arg.AuthID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const consumeMagicLinkByUserID = `-- name: ConsumeMagicLinkByUserID :execrows
+DELETE FROM auth_otp_codes
+WHERE kind = 'magic_link'
+  AND code = $1
+  AND expires_at > CURRENT_TIMESTAMP
+  AND auth_id = (SELECT u.auth_id FROM users u WHERE u.id = $2)
+`
+
+type ConsumeMagicLinkByUserIDParams struct {
+	Code   string      `json:"code"`
+	UserID pgtype.UUID `json:"userId"`
+}
+
+func (q *Queries) ConsumeMagicLinkByUserID(ctx context.Context, arg ConsumeMagicLinkByUserIDParams) (int64, error) {
+	result, err := q.db.Exec(ctx, consumeMagicLinkByUserID, arg.Code, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const countAuthIdentitiesByAuthID = `-- name: CountAuthIdentitiesByAuthID :one
+SELECT COUNT(*) FROM auth_identities WHERE auth_id = $1
+`
+
+func (q *Queries) CountAuthIdentitiesByAuthID(ctx context.Context, authID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countAuthIdentitiesByAuthID, authID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteAuthDeletionByTokenHash = `-- name: DeleteAuthDeletionByTokenHash :one
+DELETE FROM auth_deletions
+WHERE restore_token_hash = $1 AND purge_after > CURRENT_TIMESTAMP
+RETURNING auth_id
+`
+
+func (q *Queries) DeleteAuthDeletionByTokenHash(ctx context.Context, restoreTokenHash string) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, deleteAuthDeletionByTokenHash, restoreTokenHash)
+	var auth_id pgtype.UUID
+	err := row.Scan(&auth_id)
+	return auth_id, err
+}
+
+const deleteAuthEmailChange = `-- name: DeleteAuthEmailChange :execrows
+DELETE FROM auth_email_changes
+WHERE auth_id = $1 AND new_email = $2
+`
+
+type DeleteAuthEmailChangeParams struct {
+	AuthID   pgtype.UUID `json:"authId"`
+	NewEmail string      `json:"newEmail"`
+}
+
+func (q *Queries) DeleteAuthEmailChange(ctx context.Context, arg DeleteAuthEmailChangeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteAuthEmailChange, arg.AuthID, arg.NewEmail)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteAuthEmailChangesByAuthID = `-- name: DeleteAuthEmailChangesByAuthID :exec
+DELETE FROM auth_email_changes WHERE auth_id = $1
+`
+
+func (q *Queries) DeleteAuthEmailChangesByAuthID(ctx context.Context, authID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteAuthEmailChangesByAuthID, authID)
+	return err
+}
+
+const deleteAuthIdentity = `-- name: DeleteAuthIdentity :execrows
+DELETE FROM auth_identities WHERE id = $1 AND auth_id = $2
+`
+
+type DeleteAuthIdentityParams struct {
+	ID     pgtype.UUID `json:"id"`
+	AuthID pgtype.UUID `json:"authId"`
+}
+
+func (q *Queries) DeleteAuthIdentity(ctx context.Context, arg DeleteAuthIdentityParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteAuthIdentity, arg.ID, arg.AuthID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteAuthRecoveryCodesByAuthID = `-- name: DeleteAuthRecoveryCodesByAuthID :exec
+DELETE FROM auth_recovery_codes WHERE auth_id = $1
+`
+
+func (q *Queries) DeleteAuthRecoveryCodesByAuthID(ctx context.Context, authID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteAuthRecoveryCodesByAuthID, authID)
+	return err
+}
+
+const deleteDueAuth = `-- name: DeleteDueAuth :execrows
+DELETE FROM auth
+WHERE id = $1
+  AND EXISTS (
+    SELECT 1 FROM auth_deletions d WHERE d.auth_id = auth.id AND d.purge_after <= CURRENT_TIMESTAMP
+  )
+`
+
+func (q *Queries) DeleteDueAuth(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteDueAuth, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteOtpCodeEntryByAuthID = `-- name: DeleteOtpCodeEntryByAuthID :exec
 DELETE FROM auth_otp_codes WHERE auth_id = $1
 `
@@ -21,11 +206,12 @@ func (q *Queries) DeleteOtpCodeEntryByAuthID(ctx context.Context, authID pgtype.
 }
 
 const deleteOtpCodesByEmail = `-- name: DeleteOtpCodesByEmail :exec
-DELETE FROM auth_otp_codes WHERE auth_id = (SELECT id FROM auth WHERE email = $1)
+DELETE FROM auth_otp_codes
+WHERE auth_id = (SELECT id FROM auth WHERE email_normalized = $1)
 `
 
-func (q *Queries) DeleteOtpCodesByEmail(ctx context.Context, email string) error {
-	_, err := q.db.Exec(ctx, deleteOtpCodesByEmail, email)
+func (q *Queries) DeleteOtpCodesByEmail(ctx context.Context, emailNormalized string) error {
+	_, err := q.db.Exec(ctx, deleteOtpCodesByEmail, emailNormalized)
 	return err
 }
 
@@ -38,12 +224,278 @@ func (q *Queries) DeleteOtpCodesByUserID(ctx context.Context, userID pgtype.UUID
 	return err
 }
 
+const deleteSupersededOtpCodesByAuthID = `-- name: DeleteSupersededOtpCodesByAuthID :exec
+DELETE FROM auth_otp_codes superseded
+WHERE superseded.auth_id = $1
+  AND (
+    superseded.expires_at <= CURRENT_TIMESTAMP
+    OR superseded.id <> (
+        SELECT latest.id
+        FROM auth_otp_codes latest
+        WHERE latest.auth_id = $1
+          AND latest.expires_at > CURRENT_TIMESTAMP
+        ORDER BY latest.created_at DESC
+        LIMIT 1
+    )
+  )
+`
+
+func (q *Queries) DeleteSupersededOtpCodesByAuthID(ctx context.Context, authID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteSupersededOtpCodesByAuthID, authID)
+	return err
+}
+
+const getActiveAuthEmailChange = `-- name: GetActiveAuthEmailChange :one
+SELECT auth_id, new_email, current_code_hash, new_code_hash, attempts, expires_at, created_at FROM auth_email_changes
+WHERE auth_id = $1 AND expires_at > CURRENT_TIMESTAMP
+`
+
+func (q *Queries) GetActiveAuthEmailChange(ctx context.Context, authID pgtype.UUID) (AuthEmailChange, error) {
+	row := q.db.QueryRow(ctx, getActiveAuthEmailChange, authID)
+	var i AuthEmailChange
+	err := row.Scan(
+		&i.AuthID,
+		&i.NewEmail,
+		&i.CurrentCodeHash,
+		&i.NewCodeHash,
+		&i.Attempts,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getActiveAuthRestriction = `-- name: GetActiveAuthRestriction :one
+SELECT a.suspension_reason, a.suspended_until, d.purge_after
+FROM auth a
+LEFT JOIN auth_deletions d ON d.auth_id = a.id
+WHERE a.id = $1
+  AND (
+    (a.is_suspended AND (a.suspended_until IS NULL OR a.suspended_until > CURRENT_TIMESTAMP))
+    OR d.auth_id IS NOT NULL
+  )
+`
+
+type GetActiveAuthRestrictionRow struct {
+	SuspensionReason pgtype.Text      `json:"suspensionReason"`
+	SuspendedUntil   pgtype.Timestamp `json:"suspendedUntil"`
+	PurgeAfter       pgtype.Timestamp `json:"purgeAfter"`
+}
+
+func (q *Queries) GetActiveAuthRestriction(ctx context.Context, id pgtype.UUID) (GetActiveAuthRestrictionRow, error) {
+	row := q.db.QueryRow(ctx, getActiveAuthRestriction, id)
+	var i GetActiveAuthRestrictionRow
+	err := row.Scan(&i.SuspensionReason, &i.SuspendedUntil, &i.PurgeAfter)
+	return i, err
+}
+
+const getActiveOtpCodesByEmail = `-- name: GetActiveOtpCodesByEmail :many
+SELECT users.id, auth.email, auth.id AS auth_id, auth_otp_codes.code
+FROM users
+JOIN auth ON users.auth_id = auth.id
+JOIN auth_otp_codes ON auth.id = auth_otp_codes.auth_id
+WHERE auth.email_normalized = $1
+  AND auth_otp_codes.kind = 'code'
+  AND auth_otp_codes.expires_at > CURRENT_TIMESTAMP
+`
+
+type GetActiveOtpCodesByEmailRow struct {
+	ID     pgtype.UUID `json:"id"`
+	Email  string      `json:"email"`
+	AuthID pgtype.UUID `json:"authId"`
+	Code   string      `json:"code"`
+}
+
+func (q *Queries) GetActiveOtpCodesByEmail(ctx context.Context, emailNormalized string) ([]GetActiveOtpCodesByEmailRow, error) {
+	rows, err := q.db.Query(ctx, getActiveOtpCodesByEmail, emailNormalized)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetActiveOtpCodesByEmailRow{}
+	for rows.Next() {
+		var i GetActiveOtpCodesByEmailRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.AuthID,
+			&i.Code,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAuthByID = `-- name: GetAuthByID :one
+SELECT id, email, is_suspended, created_at, updated_at, suspension_reason, suspended_until, email_normalized, first_login_at FROM auth WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetAuthByID(ctx context.Context, id pgtype.UUID) (Auth, error) {
+	row := q.db.QueryRow(ctx, getAuthByID, id)
+	var i Auth
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.IsSuspended,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.SuspensionReason,
+		&i.SuspendedUntil,
+		&i.EmailNormalized,
+		&i.FirstLoginAt,
+	)
+	return i, err
+}
+
+const getAuthByIdentity = `-- name: GetAuthByIdentity :one
+SELECT auth.id, auth.email, auth.is_suspended, auth.created_at, auth.updated_at, auth.suspension_reason, auth.suspended_until, auth.email_normalized, auth.first_login_at FROM auth
+JOIN auth_identities ON auth_identities.auth_id = auth.id
+WHERE auth_identities.provider = $1 AND auth_identities.provider_id = $2
+LIMIT 1
+`
+
+type GetAuthByIdentityParams struct {
+	Provider   AuthProvider `json:"provider"`
+	ProviderID string       `json:"providerId"`
+}
+
+func (q *Queries) GetAuthByIdentity(ctx context.Context, arg GetAuthByIdentityParams) (Auth, error) {
+	row := q.db.QueryRow(ctx, getAuthByIdentity, arg.Provider, arg.ProviderID)
+	var i Auth
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.IsSuspended,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.SuspensionReason,
+		&i.SuspendedUntil,
+		&i.EmailNormalized,
+		&i.FirstLoginAt,
+	)
+	return i, err
+}
+
+const getAuthEmailChangeAge = `-- name: GetAuthEmailChangeAge :one
+SELECT EXTRACT(EPOCH FROM LOCALTIMESTAMP - created_at)::float8 AS age_seconds
+FROM auth_email_changes
+WHERE auth_id = $1
+`
+
+func (q *Queries) GetAuthEmailChangeAge(ctx context.Context, authID pgtype.UUID) (float64, error) {
+	row := q.db.QueryRow(ctx, getAuthEmailChangeAge, authID)
+	var age_seconds float64
+	err := row.Scan(&age_seconds)
+	return age_seconds, err
+}
+
+const getAuthTotpByAuthID = `-- name: GetAuthTotpByAuthID :one
+SELECT auth_id, secret, confirmed_at, last_used_step, failed_attempts, created_at FROM auth_totp WHERE auth_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetAuthTotpByAuthID(ctx context.Context, authID pgtype.UUID) (AuthTotp, error) {
+	row := q.db.QueryRow(ctx, getAuthTotpByAuthID, authID)
+	var i AuthTotp
+	err := row.Scan(
+		&i.AuthID,
+		&i.Secret,
+		&i.ConfirmedAt,
+		&i.LastUsedStep,
+		&i.FailedAttempts,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getLatestOtpCodeAgeByAuthID = `-- name: GetLatestOtpCodeAgeByAuthID :one
+SELECT EXTRACT(EPOCH FROM LOCALTIMESTAMP - created_at)::float8 AS age_seconds
+FROM auth_otp_codes
+WHERE auth_id = $1
+ORDER BY created_at DESC
+LIMIT 1
+`
+
+func (q *Queries) GetLatestOtpCodeAgeByAuthID(ctx context.Context, authID pgtype.UUID) (float64, error) {
+	row := q.db.QueryRow(ctx, getLatestOtpCodeAgeByAuthID, authID)
+	var age_seconds float64
+	err := row.Scan(&age_seconds)
+	return age_seconds, err
+}
+
+const getRefreshTokenByID = `-- name: GetRefreshTokenByID :one
+SELECT id, user_id, family_id, parent_id, expires_at, used_at, revoked_at, created_at, session_id FROM refresh_tokens WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetRefreshTokenByID(ctx context.Context, id pgtype.UUID) (RefreshToken, error) {
+	row := q.db.QueryRow(ctx, getRefreshTokenByID, id)
+	var i RefreshToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.FamilyID,
+		&i.ParentID,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+		&i.SessionID,
+	)
+	return i, err
+}
+
+const getSessionByID = `-- name: GetSessionByID :one
+SELECT id, user_id, device_label, ip_address, user_agent, created_at, last_seen_at, revoked_at FROM sessions WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetSessionByID(ctx context.Context, id pgtype.UUID) (Session, error) {
+	row := q.db.QueryRow(ctx, getSessionByID, id)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.DeviceLabel,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getUserByAuthID = `-- name: GetUserByAuthID :one
+SELECT id, privacy_level, auth_id, username, display_name, created_at, updated_at, avatar_key FROM users WHERE auth_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetUserByAuthID(ctx context.Context, authID pgtype.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByAuthID, authID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.PrivacyLevel,
+		&i.AuthID,
+		&i.Username,
+		&i.DisplayName,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.AvatarKey,
+	)
+	return i, err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, privacy_level, auth_id, username, display_name, created_at, updated_at, avatar_key FROM users WHERE auth_id = (SELECT id FROM auth WHERE email = $1) LIMIT 1
+SELECT id, privacy_level, auth_id, username, display_name, created_at, updated_at, avatar_key FROM users
+WHERE auth_id = (SELECT id FROM auth WHERE email_normalized = $1)
+LIMIT 1
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRow(ctx, getUserByEmail, email)
+func (q *Queries) GetUserByEmail(ctx context.Context, emailNormalized string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByEmail, emailNormalized)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -78,65 +530,282 @@ func (q *Queries) GetUserByID(ctx context.Context, id pgtype.UUID) (User, error)
 	return i, err
 }
 
-const getUserIdAndEmailByOtpCode = `-- name: GetUserIdAndEmailByOtpCode :one
-SELECT users.id, auth.email , auth.id as auth_id
-FROM users 
-JOIN auth ON users.auth_id = auth.id 
-JOIN auth_otp_codes ON auth.id = auth_otp_codes.auth_id 
-WHERE auth_otp_codes.code = $1 AND auth_otp_codes.expires_at > CURRENT_TIMESTAMP 
-LIMIT 1
+const incrementAuthEmailChangeAttempts = `-- name: IncrementAuthEmailChangeAttempts :one
+UPDATE auth_email_changes
+SET attempts = attempts + 1
+WHERE auth_id = $1 AND expires_at > CURRENT_TIMESTAMP
+RETURNING attempts
 `
 
-type GetUserIdAndEmailByOtpCodeRow struct {
-	ID     pgtype.UUID `json:"id"`
-	Email  string      `json:"email"`
-	AuthID pgtype.UUID `json:"authId"`
+func (q *Queries) IncrementAuthEmailChangeAttempts(ctx context.Context, authID pgtype.UUID) (int32, error) {
+	row := q.db.QueryRow(ctx, incrementAuthEmailChangeAttempts, authID)
+	var attempts int32
+	err := row.Scan(&attempts)
+	return attempts, err
 }
 
-func (q *Queries) GetUserIdAndEmailByOtpCode(ctx context.Context, code string) (GetUserIdAndEmailByOtpCodeRow, error) {
-	row := q.db.QueryRow(ctx, getUserIdAndEmailByOtpCode, code)
-	var i GetUserIdAndEmailByOtpCodeRow
-	err := row.Scan(&i.ID, &i.Email, &i.AuthID)
-	return i, err
+const incrementAuthTotpFailedAttempts = `-- name: IncrementAuthTotpFailedAttempts :one
+UPDATE auth_totp
+SET failed_attempts = failed_attempts + 1
+WHERE auth_id = $1 AND confirmed_at IS NOT NULL
+RETURNING failed_attempts
+`
+
+func (q *Queries) IncrementAuthTotpFailedAttempts(ctx context.Context, authID pgtype.UUID) (int32, error) {
+	row := q.db.QueryRow(ctx, incrementAuthTotpFailedAttempts, authID)
+	var failed_attempts int32
+	err := row.Scan(&failed_attempts)
+	return failed_attempts, err
+}
+
+const incrementOtpAttemptsByEmail = `-- name: IncrementOtpAttemptsByEmail :one
+UPDATE auth_otp_codes
+SET attempts = attempts + 1
+WHERE id = (
+    SELECT auth_otp_codes.id
+    FROM auth_otp_codes
+    JOIN auth ON auth_otp_codes.auth_id = auth.id
+    WHERE auth.email_normalized = $1
+      AND auth_otp_codes.kind = 'code'
+      AND auth_otp_codes.expires_at > CURRENT_TIMESTAMP
+    ORDER BY auth_otp_codes.created_at DESC
+    LIMIT 1
+)
+RETURNING attempts
+`
+
+func (q *Queries) IncrementOtpAttemptsByEmail(ctx context.Context, emailNormalized string) (int32, error) {
+	row := q.db.QueryRow(ctx, incrementOtpAttemptsByEmail, emailNormalized)
+	var attempts int32
+	err := row.Scan(&attempts)
+	return attempts, err
 }
 
 const insertAuth = `-- name: InsertAuth :one
-INSERT INTO auth (email, provider, provider_id)
-VALUES ($1, $2, $3)
+INSERT INTO auth (email, email_normalized)
+VALUES ($1, $2)
 RETURNING id
 `
 
 type InsertAuthParams struct {
-	Email      string       `json:"email"`
-	Provider   AuthProvider `json:"provider"`
-	ProviderID pgtype.Text  `json:"providerId"`
+	Email           string `json:"email"`
+	EmailNormalized string `json:"emailNormalized"`
 }
 
 func (q *Queries) InsertAuth(ctx context.Context, arg InsertAuthParams) (pgtype.UUID, error) {
-	row := q.db.QueryRow(ctx, insertAuth, arg.Email, arg.Provider, arg.ProviderID)
+	row := q.db.QueryRow(ctx, insertAuth, arg.Email, arg.EmailNormalized)
 	var id pgtype.UUID
 	err := row.Scan(&id)
 	return id, err
 }
 
+const insertAuthDeletion = `-- name: InsertAuthDeletion :one
+INSERT INTO auth_deletions (auth_id, restore_token_hash, purge_after)
+VALUES (
+    $1,
+    $2,
+    CURRENT_TIMESTAMP + make_interval(secs => $3::float8)
+)
+RETURNING auth_id, restore_token_hash, requested_at, purge_after
+`
+
+type InsertAuthDeletionParams struct {
+	AuthID           pgtype.UUID `json:"authId"`
+	RestoreTokenHash string      `json:"restoreTokenHash"`
+	GraceSeconds     float64     `json:"graceSeconds"`
+}
+
+func (q *Queries) InsertAuthDeletion(ctx context.Context, arg InsertAuthDeletionParams) (AuthDeletion, error) {
+	row := q.db.QueryRow(ctx, insertAuthDeletion, arg.AuthID, arg.RestoreTokenHash, arg.GraceSeconds)
+	var i AuthDeletion
+	err := row.Scan(
+		&i.AuthID,
+		&i.RestoreTokenHash,
+		&i.RequestedAt,
+		&i.PurgeAfter,
+	)
+	return i, err
+}
+
+const insertAuthIdentity = `-- name: InsertAuthIdentity :one
+INSERT INTO auth_identities (auth_id, provider, provider_id, email)
+VALUES ($1, $2, $3, $4)
+RETURNING id, auth_id, provider, provider_id, email, created_at
+`
+
+type InsertAuthIdentityParams struct {
+	AuthID     pgtype.UUID  `json:"authId"`
+	Provider   AuthProvider `json:"provider"`
+	ProviderID string       `json:"providerId"`
+	Email      string       `json:"email"`
+}
+
+func (q *Queries) InsertAuthIdentity(ctx context.Context, arg InsertAuthIdentityParams) (AuthIdentity, error) {
+	row := q.db.QueryRow(ctx, insertAuthIdentity,
+		arg.AuthID,
+		arg.Provider,
+		arg.ProviderID,
+		arg.Email,
+	)
+	var i AuthIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.AuthID,
+		&i.Provider,
+		&i.ProviderID,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const insertAuthOtpCode = `-- name: InsertAuthOtpCode :one
-INSERT INTO auth_otp_codes (auth_id, code)
-VALUES ($1, $2)
+INSERT INTO auth_otp_codes (auth_id, code, kind, expires_at, attempts)
+VALUES (
+    $1,
+    $2,
+    $3,
+    CURRENT_TIMESTAMP + make_interval(secs => $4::float8),
+    COALESCE((
+        SELECT MAX(previous.attempts)
+        FROM auth_otp_codes previous
+        WHERE previous.auth_id = $1
+          AND previous.kind = $3
+          AND previous.expires_at > CURRENT_TIMESTAMP
+    ), 0)
+)
 RETURNING id
 `
 
 type InsertAuthOtpCodeParams struct {
-	AuthID pgtype.UUID `json:"authId"`
-	Code   string      `json:"code"`
+	AuthID          pgtype.UUID `json:"authId"`
+	Code            string      `json:"code"`
+	Kind            OtpKind     `json:"kind"`
+	LifetimeSeconds float64     `json:"lifetimeSeconds"`
 }
 
 func (q *Queries) InsertAuthOtpCode(ctx context.Context, arg InsertAuthOtpCodeParams) (pgtype.UUID, error) {
-	row := q.db.QueryRow(ctx, insertAuthOtpCode, arg.AuthID, arg.Code)
+	row := q.db.QueryRow(ctx, insertAuthOtpCode,
+		arg.AuthID,
+		arg.Code,
+		arg.Kind,
+		arg.LifetimeSeconds,
+	)
 	var id pgtype.UUID
 	err := row.Scan(&id)
 	return id, err
 }
 
+const insertAuthRecoveryCode = `-- name: InsertAuthRecoveryCode :exec
+INSERT INTO auth_recovery_codes (auth_id, code_hash)
+VALUES ($1, $2)
+`
+
+type InsertAuthRecoveryCodeParams struct {
+	AuthID   pgtype.UUID `json:"authId"`
+	CodeHash string      `json:"codeHash"`
+}
+
+func (q *Queries) InsertAuthRecoveryCode(ctx context.Context, arg InsertAuthRecoveryCodeParams) error {
+	_, err := q.db.Exec(ctx, insertAuthRecoveryCode, arg.AuthID, arg.CodeHash)
+	return err
+}
+
+const insertAuthSuspensionEvent = `-- name: InsertAuthSuspensionEvent :one
+INSERT INTO auth_suspension_events (auth_id, action, reason, suspended_until, actor)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, auth_id, action, reason, suspended_until, actor, created_at
+`
+
+type InsertAuthSuspensionEventParams struct {
+	AuthID         pgtype.UUID      `json:"authId"`
+	Action         SuspensionAction `json:"action"`
+	Reason         string           `json:"reason"`
+	SuspendedUntil pgtype.Timestamp `json:"suspendedUntil"`
+	Actor          string           `json:"actor"`
+}
+
+func (q *Queries) InsertAuthSuspensionEvent(ctx context.Context, arg InsertAuthSuspensionEventParams) (AuthSuspensionEvent, error) {
+	row := q.db.QueryRow(ctx, insertAuthSuspensionEvent,
+		arg.AuthID,
+		arg.Action,
+		arg.Reason,
+		arg.SuspendedUntil,
+		arg.Actor,
+	)
+	var i AuthSuspensionEvent
+	err := row.Scan(
+		&i.ID,
+		&i.AuthID,
+		&i.Action,
+		&i.Reason,
+		&i.SuspendedUntil,
+		&i.Actor,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const insertRefreshToken = `-- name: InsertRefreshToken :exec
+INSERT INTO refresh_tokens (id, user_id, family_id, parent_id, expires_at, session_id)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type InsertRefreshTokenParams struct {
+	ID        pgtype.UUID      `json:"id"`
+	UserID    pgtype.UUID      `json:"userId"`
+	FamilyID  pgtype.UUID      `json:"familyId"`
+	ParentID  pgtype.UUID      `json:"parentId"`
+	ExpiresAt pgtype.Timestamp `json:"expiresAt"`
+	SessionID pgtype.UUID      `json:"sessionId"`
+}
+
+func (q *Queries) InsertRefreshToken(ctx context.Context, arg InsertRefreshTokenParams) error {
+	_, err := q.db.Exec(ctx, insertRefreshToken,
+		arg.ID,
+		arg.UserID,
+		arg.FamilyID,
+		arg.ParentID,
+		arg.ExpiresAt,
+		arg.SessionID,
+	)
+	return err
+}
+
+const insertSession = `-- name: InsertSession :one
+INSERT INTO sessions (user_id, device_label, ip_address, user_agent)
+VALUES ($1, $2, $3, $4)
+RETURNING id, user_id, device_label, ip_address, user_agent, created_at, last_seen_at, revoked_at
+`
+
+type InsertSessionParams struct {
+	UserID      pgtype.UUID `json:"userId"`
+	DeviceLabel pgtype.Text `json:"deviceLabel"`
+	IpAddress   pgtype.Text `json:"ipAddress"`
+	UserAgent   pgtype.Text `json:"userAgent"`
+}
+
+func (q *Queries) InsertSession(ctx context.Context, arg InsertSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, insertSession,
+		arg.UserID,
+		arg.DeviceLabel,
+		arg.IpAddress,
+		arg.UserAgent,
+	)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.DeviceLabel,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
 const insertUser = `-- name: InsertUser :one
 INSERT INTO users (auth_id, username, display_name, avatar_key)
 VALUES ($1, $2, $3, $4)
@@ -171,6 +840,327 @@ func (q *Queries) InsertUser(ctx context.Context, arg InsertUserParams) (User, e
 	return i, err
 }
 
+const liftAuthSuspension = `-- name: LiftAuthSuspension :execrows
+UPDATE auth
+SET is_suspended = FALSE,
+    suspension_reason = NULL,
+    suspended_until = NULL,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND is_suspended
+`
+
+func (q *Queries) LiftAuthSuspension(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, liftAuthSuspension, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const listActiveSessionsByUserID = `-- name: ListActiveSessionsByUserID :many
+SELECT id, user_id, device_label, ip_address, user_agent, created_at, last_seen_at, revoked_at FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY last_seen_at DESC, id
+`
+
+func (q *Queries) ListActiveSessionsByUserID(ctx context.Context, userID pgtype.UUID) ([]Session, error) {
+	rows, err := q.db.Query(ctx, listActiveSessionsByUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Session{}
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.DeviceLabel,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.CreatedAt,
+			&i.LastSeenAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuthIdentitiesByAuthID = `-- name: ListAuthIdentitiesByAuthID :many
+SELECT id, auth_id, provider, provider_id, email, created_at FROM auth_identities WHERE auth_id = $1 ORDER BY created_at, id
+`
+
+func (q *Queries) ListAuthIdentitiesByAuthID(ctx context.Context, authID pgtype.UUID) ([]AuthIdentity, error) {
+	rows, err := q.db.Query(ctx, listAuthIdentitiesByAuthID, authID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AuthIdentity{}
+	for rows.Next() {
+		var i AuthIdentity
+		if err := rows.Scan(
+			&i.ID,
+			&i.AuthID,
+			&i.Provider,
+			&i.ProviderID,
+			&i.Email,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuthSuspensionEvents = `-- name: ListAuthSuspensionEvents :many
+SELECT id, auth_id, action, reason, suspended_until, actor, created_at FROM auth_suspension_events
+WHERE auth_id = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListAuthSuspensionEvents(ctx context.Context, authID pgtype.UUID) ([]AuthSuspensionEvent, error) {
+	rows, err := q.db.Query(ctx, listAuthSuspensionEvents, authID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AuthSuspensionEvent{}
+	for rows.Next() {
+		var i AuthSuspensionEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.AuthID,
+			&i.Action,
+			&i.Reason,
+			&i.SuspendedUntil,
+			&i.Actor,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDueAuthDeletions = `-- name: ListDueAuthDeletions :many
+SELECT d.auth_id, u.avatar_key,
+    ARRAY(
+        SELECT e.archive_key FROM data_exports e
+        WHERE e.user_id = u.id AND e.archive_key IS NOT NULL
+        ORDER BY e.created_at
+    )::text[] AS export_archive_keys
+FROM auth_deletions d
+LEFT JOIN users u ON u.auth_id = d.auth_id
+WHERE d.purge_after <= CURRENT_TIMESTAMP
+ORDER BY d.purge_after
+LIMIT $1
+`
+
+type ListDueAuthDeletionsRow struct {
+	AuthID            pgtype.UUID `json:"authId"`
+	AvatarKey         pgtype.Text `json:"avatarKey"`
+	ExportArchiveKeys []string    `json:"exportArchiveKeys"`
+}
+
+func (q *Queries) ListDueAuthDeletions(ctx context.Context, maxRows int32) ([]ListDueAuthDeletionsRow, error) {
+	rows, err := q.db.Query(ctx, listDueAuthDeletions, maxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListDueAuthDeletionsRow{}
+	for rows.Next() {
+		var i ListDueAuthDeletionsRow
+		if err := rows.Scan(&i.AuthID, &i.AvatarKey, &i.ExportArchiveKeys); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockAuthByID = `-- name: LockAuthByID :one
+SELECT id FROM auth WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) LockAuthByID(ctx context.Context, id pgtype.UUID) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, lockAuthByID, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const markAuthFirstLogin = `-- name: MarkAuthFirstLogin :execrows
+UPDATE auth
+SET first_login_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND first_login_at IS NULL
+`
+
+func (q *Queries) MarkAuthFirstLogin(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, markAuthFirstLogin, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const resetAuthTotpFailedAttempts = `-- name: ResetAuthTotpFailedAttempts :exec
+UPDATE auth_totp SET failed_attempts = 0 WHERE auth_id = $1
+`
+
+func (q *Queries) ResetAuthTotpFailedAttempts(ctx context.Context, authID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, resetAuthTotpFailedAttempts, authID)
+	return err
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE family_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeRefreshTokensBySessionID = `-- name: RevokeRefreshTokensBySessionID :exec
+UPDATE refresh_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE session_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokensBySessionID(ctx context.Context, sessionID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, revokeRefreshTokensBySessionID, sessionID)
+	return err
+}
+
+const revokeRefreshTokensByUserID = `-- name: RevokeRefreshTokensByUserID :exec
+UPDATE refresh_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokensByUserID(ctx context.Context, userID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, revokeRefreshTokensByUserID, userID)
+	return err
+}
+
+const revokeSession = `-- name: RevokeSession :execrows
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeSessionParams struct {
+	ID     pgtype.UUID `json:"id"`
+	UserID pgtype.UUID `json:"userId"`
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeSession, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeSessionsByUserID = `-- name: RevokeSessionsByUserID :exec
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeSessionsByUserID(ctx context.Context, userID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, revokeSessionsByUserID, userID)
+	return err
+}
+
+const suspendAuth = `-- name: SuspendAuth :execrows
+UPDATE auth
+SET is_suspended = TRUE,
+    suspension_reason = $1,
+    suspended_until = $2,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $3
+`
+
+type SuspendAuthParams struct {
+	Reason         pgtype.Text      `json:"reason"`
+	SuspendedUntil pgtype.Timestamp `json:"suspendedUntil"`
+	ID             pgtype.UUID      `json:"id"`
+}
+
+func (q *Queries) SuspendAuth(ctx context.Context, arg SuspendAuthParams) (int64, error) {
+	result, err := q.db.Exec(ctx, suspendAuth, arg.Reason, arg.SuspendedUntil, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP WHERE id = $1
+`
+
+func (q *Queries) TouchSession(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, touchSession, id)
+	return err
+}
+
+const updateAuthEmail = `-- name: UpdateAuthEmail :execrows
+UPDATE auth
+SET email = $1, email_normalized = $2, updated_at = CURRENT_TIMESTAMP
+WHERE id = $3
+`
+
+type UpdateAuthEmailParams struct {
+	Email           string      `json:"email"`
+	EmailNormalized string      `json:"emailNormalized"`
+	ID              pgtype.UUID `json:"id"`
+}
+
+func (q *Queries) UpdateAuthEmail(ctx context.Context, arg UpdateAuthEmailParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateAuthEmail, arg.Email, arg.EmailNormalized, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateEmailOtpIdentity = `-- name: UpdateEmailOtpIdentity :exec
+UPDATE auth_identities
+SET provider_id = $1, email = $1
+WHERE auth_id = $2 AND provider = 'email_otp'
+`
+
+type UpdateEmailOtpIdentityParams struct {
+	Email  string      `json:"email"`
+	AuthID pgtype.UUID `json:"authId"`
+}
+
+func (q *Queries) UpdateEmailOtpIdentity(ctx context.Context, arg UpdateEmailOtpIdentityParams) error {
+	_, err := q.db.Exec(ctx, updateEmailOtpIdentity, arg.Email, arg.AuthID)
+	return err
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET username = COALESCE($1, username), 
@@ -211,6 +1201,152 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 	return i, err
 }
 
+const upsertAuthEmailChange = `-- name: UpsertAuthEmailChange :one
+INSERT INTO auth_email_changes (auth_id, new_email, current_code_hash, new_code_hash, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    CURRENT_TIMESTAMP + make_interval(secs => $5::float8)
+)
+ON CONFLICT (auth_id) DO UPDATE
+SET new_email = EXCLUDED.new_email,
+    current_code_hash = EXCLUDED.current_code_hash,
+    new_code_hash = EXCLUDED.new_code_hash,
+    attempts = 0,
+    expires_at = EXCLUDED.expires_at,
+    created_at = CURRENT_TIMESTAMP
+RETURNING auth_id, new_email, current_code_hash, new_code_hash, attempts, expires_at, created_at
+`
+
+type UpsertAuthEmailChangeParams struct {
+	AuthID          pgtype.UUID `json:"authId"`
+	NewEmail        string      `json:"newEmail"`
+	CurrentCodeHash string      `json:"currentCodeHash"`
+	NewCodeHash     string      `json:"newCodeHash"`
+	LifetimeSeconds float64     `json:"lifetimeSeconds"`
+}
+
+func (q *Queries) UpsertAuthEmailChange(ctx context.Context, arg UpsertAuthEmailChangeParams) (AuthEmailChange, error) {
+	row := q.db.QueryRow(ctx, upsertAuthEmailChange,
+		arg.AuthID,
+		arg.NewEmail,
+		arg.CurrentCodeHash,
+		arg.NewCodeHash,
+		arg.LifetimeSeconds,
+	)
+	var i AuthEmailChange
+	err := row.Scan(
+		&i.AuthID,
+		&i.NewEmail,
+		&i.CurrentCodeHash,
+		&i.NewCodeHash,
+		&i.Attempts,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const upsertPendingAuthTotp = `-- name: UpsertPendingAuthTotp :one
+INSERT INTO auth_totp (auth_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (auth_id) DO UPDATE
+SET secret = EXCLUDED.secret,
+    last_used_step = 0,
+    failed_attempts = 0,
+    created_at = CURRENT_TIMESTAMP
+WHERE auth_totp.confirmed_at IS NULL
+RETURNING auth_id, secret, confirmed_at, last_used_step, failed_attempts, created_at
+`
+
+type UpsertPendingAuthTotpParams struct {
+	AuthID pgtype.UUID `json:"authId"`
+	Secret string      `json:"secret"`
+}
+
+func (q *Queries) UpsertPendingAuthTotp(ctx context.Context, arg UpsertPendingAuthTotpParams) (AuthTotp, error) {
+	row := q.db.QueryRow(ctx, upsertPendingAuthTotp, arg.AuthID, arg.Secret)
+	var i AuthTotp
+	err := row.Scan(
+		&i.AuthID,
+		&i.Secret,
+		&i.ConfirmedAt,
+		&i.LastUsedStep,
+		&i.FailedAttempts,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const useAuthRecoveryCode = `-- name: UseAuthRecoveryCode :execrows
+UPDATE auth_recovery_codes
+SET used_at = CURRENT_TIMESTAMP
+WHERE auth_id = $1 AND code_hash = $2 AND used_at IS NULL
+`
+
+type UseAuthRecoveryCodeParams struct {
+	AuthID   pgtype.UUID `json:"authId"`
+	CodeHash string      `json:"codeHash"`
+}
+
+func (q *Queries) UseAuthRecoveryCode(ctx context.Context, arg UseAuthRecoveryCodeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, useAuthRecoveryCode, arg.AuthID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const useAuthTotpStep = `-- name: UseAuthTotpStep :execrows
+UPDATE auth_totp
+SET last_used_step = $1, failed_attempts = 0
+WHERE auth_id = $2
+    AND confirmed_at IS NOT NULL
+    AND last_used_step < $1
+`
+
+type UseAuthTotpStepParams struct {
+	LastUsedStep int64       `json:"lastUsedStep"`
+	AuthID       pgtype.UUID `json:"authId"`
+}
+
+func (q *Queries) UseAuthTotpStep(ctx context.Context, arg UseAuthTotpStepParams) (int64, error) {
+	result, err := q.db.Exec(ctx, useAuthTotpStep, arg.LastUsedStep, arg.AuthID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const useRefreshToken = `-- name: UseRefreshToken :one
+UPDATE refresh_tokens
+SET used_at = CURRENT_TIMESTAMP
+WHERE id = $1
+    AND used_at IS NULL
+    AND revoked_at IS NULL
+    AND expires_at > CURRENT_TIMESTAMP
+RETURNING id, user_id, family_id, parent_id, expires_at, used_at, revoked_at, created_at, session_id
+`
+
+func (q *Queries) UseRefreshToken(ctx context.Context, id pgtype.UUID) (RefreshToken, error) {
+	row := q.db.QueryRow(ctx, useRefreshToken, id)
+	var i RefreshToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.FamilyID,
+		&i.ParentID,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+		&i.SessionID,
+	)
+	return i, err
+}
+
 const userNameExists = `-- name: UserNameExists :one
 SELECT COUNT(*) FROM users WHERE username = $1 LIMIT 1
 `
@@ -53,6 +53,134 @@ func (ns NullAuthProvider) Value() (driver.Value, error) {
 	return string(ns.AuthProvider), nil
 }
 
+type DataExportStatus string
+
+const (
+	DataExportStatusPending   DataExportStatus = "pending"
+	DataExportStatusRunning   DataExportStatus = "running"
+	DataExportStatusCompleted DataExportStatus = "completed"
+	DataExportStatusFailed    DataExportStatus = "failed"
+)
+
+func (e *DataExportStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = DataExportStatus(s)
+	case string:
+		*e = DataExportStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for DataExportStatus: %T", src)
+	}
+	return nil
+}
+
+type NullDataExportStatus struct {
+	DataExportStatus DataExportStatus `json:"dataExportStatus"`
+	Valid            bool             `json:"valid"` // Valid is true if DataExportStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullDataExportStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.DataExportStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.DataExportStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullDataExportStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.DataExportStatus), nil
+}
+
+type EmailDomainAction string
+
+const (
+	EmailDomainActionAllow EmailDomainAction = "allow"
+	EmailDomainActionDeny  EmailDomainAction = "deny"
+)
+
+func (e *EmailDomainAction) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = EmailDomainAction(s)
+	case string:
+		*e = EmailDomainAction(s)
+	default:
+		return fmt.Errorf("unsupported scan type for EmailDomainAction: %T", src)
+	}
+	return nil
+}
+
+type NullEmailDomainAction struct {
+	EmailDomainAction EmailDomainAction `json:"emailDomainAction"`
+	Valid             bool              `json:"valid"` // Valid is true if EmailDomainAction is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullEmailDomainAction) Scan(value interface{}) error {
+	if value == nil {
+		ns.EmailDomainAction, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.EmailDomainAction.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullEmailDomainAction) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.EmailDomainAction), nil
+}
+
+type OtpKind string
+
+const (
+	OtpKindCode      OtpKind = "code"
+	OtpKindMagicLink OtpKind = "magic_link"
+)
+
+func (e *OtpKind) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = OtpKind(s)
+	case string:
+		*e = OtpKind(s)
+	default:
+		return fmt.Errorf("unsupported scan type for OtpKind: %T", src)
+	}
+	return nil
+}
+
+type NullOtpKind struct {
+	OtpKind OtpKind `json:"otpKind"`
+	Valid   bool    `json:"valid"` // Valid is true if OtpKind is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullOtpKind) Scan(value interface{}) error {
+	if value == nil {
+		ns.OtpKind, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.OtpKind.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullOtpKind) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.OtpKind), nil
+}
+
 type PrivacyLevel string
 
 const (
@@ -96,14 +224,126 @@ func (ns NullPrivacyLevel) Value() (driver.Value, error) {
 	return string(ns.PrivacyLevel), nil
 }
 
+type SuspensionAction string
+
+const (
+	SuspensionActionSuspended SuspensionAction = "suspended"
+	SuspensionActionLifted    SuspensionAction = "lifted"
+)
+
+func (e *SuspensionAction) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = SuspensionAction(s)
+	case string:
+		*e = SuspensionAction(s)
+	default:
+		return fmt.Errorf("unsupported scan type for SuspensionAction: %T", src)
+	}
+	return nil
+}
+
+type NullSuspensionAction struct {
+	SuspensionAction SuspensionAction `json:"suspensionAction"`
+	Valid            bool             `json:"valid"` // Valid is true if SuspensionAction is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullSuspensionAction) Scan(value interface{}) error {
+	if value == nil {
+		ns.SuspensionAction, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.SuspensionAction.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullSuspensionAction) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.SuspensionAction), nil
+}
+
+type WebauthnCeremony string
+
+const (
+	WebauthnCeremonyRegistration WebauthnCeremony = "registration"
+	WebauthnCeremonyAssertion    WebauthnCeremony = "assertion"
+)
+
+func (e *WebauthnCeremony) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = WebauthnCeremony(s)
+	case string:
+		*e = WebauthnCeremony(s)
+	default:
+		return fmt.Errorf("unsupported scan type for WebauthnCeremony: %T", src)
+	}
+	return nil
+}
+
+type NullWebauthnCeremony struct {
+	WebauthnCeremony WebauthnCeremony `json:"webauthnCeremony"`
+	Valid            bool             `json:"valid"` // Valid is true if WebauthnCeremony is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullWebauthnCeremony) Scan(value interface{}) error {
+	if value == nil {
+		ns.WebauthnCeremony, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.WebauthnCeremony.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullWebauthnCeremony) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.WebauthnCeremony), nil
+}
+
 type Auth struct {
-	ID          pgtype.UUID      `json:"id"`
-	Email       string           `json:"email"`
-	Provider    AuthProvider     `json:"provider"`
-	ProviderID  pgtype.Text      `json:"providerId"`
-	IsSuspended bool             `json:"isSuspended"`
-	CreatedAt   pgtype.Timestamp `json:"createdAt"`
-	UpdatedAt   pgtype.Timestamp `json:"updatedAt"`
+	ID               pgtype.UUID      `json:"id"`
+	Email            string           `json:"email"`
+	IsSuspended      bool             `json:"isSuspended"`
+	CreatedAt        pgtype.Timestamp `json:"createdAt"`
+	UpdatedAt        pgtype.Timestamp `json:"updatedAt"`
+	SuspensionReason pgtype.Text      `json:"suspensionReason"`
+	SuspendedUntil   pgtype.Timestamp `json:"suspendedUntil"`
+	EmailNormalized  string           `json:"emailNormalized"`
+	FirstLoginAt     pgtype.Timestamp `json:"firstLoginAt"`
+}
+
+type AuthDeletion struct {
+	AuthID           pgtype.UUID      `json:"authId"`
+	RestoreTokenHash string           `json:"restoreTokenHash"`
+	RequestedAt      pgtype.Timestamp `json:"requestedAt"`
+	PurgeAfter       pgtype.Timestamp `json:"purgeAfter"`
+}
+
+type AuthEmailChange struct {
+	AuthID          pgtype.UUID      `json:"authId"`
+	NewEmail        string           `json:"newEmail"`
+	CurrentCodeHash string           `json:"currentCodeHash"`
+	NewCodeHash     string           `json:"newCodeHash"`
+	Attempts        int32            `json:"attempts"`
+	ExpiresAt       pgtype.Timestamp `json:"expiresAt"`
+	CreatedAt       pgtype.Timestamp `json:"createdAt"`
+}
+
+type AuthIdentity struct {
+	ID         pgtype.UUID      `json:"id"`
+	AuthID     pgtype.UUID      `json:"authId"`
+	Provider   AuthProvider     `json:"provider"`
+	ProviderID string           `json:"providerId"`
+	Email      string           `json:"email"`
+	CreatedAt  pgtype.Timestamp `json:"createdAt"`
 }
 
 type AuthOtpCode struct {
@@ -112,6 +352,84 @@ type AuthOtpCode struct {
 	Code      string           `json:"code"`
 	ExpiresAt pgtype.Timestamp `json:"expiresAt"`
 	CreatedAt pgtype.Timestamp `json:"createdAt"`
+	Attempts  int32            `json:"attempts"`
+	Kind      OtpKind          `json:"kind"`
+}
+
+type AuthRecoveryCode struct {
+	ID        pgtype.UUID      `json:"id"`
+	AuthID    pgtype.UUID      `json:"authId"`
+	CodeHash  string           `json:"codeHash"`
+	UsedAt    pgtype.Timestamp `json:"usedAt"`
+	CreatedAt pgtype.Timestamp `json:"createdAt"`
+}
+
+type AuthSuspensionEvent struct {
+	ID             pgtype.UUID      `json:"id"`
+	AuthID         pgtype.UUID      `json:"authId"`
+	Action         SuspensionAction `json:"action"`
+	Reason         string           `json:"reason"`
+	SuspendedUntil pgtype.Timestamp `json:"suspendedUntil"`
+	Actor          string           `json:"actor"`
+	CreatedAt      pgtype.Timestamp `json:"createdAt"`
+}
+
+type AuthTotp struct {
+	AuthID         pgtype.UUID      `json:"authId"`
+	Secret         string           `json:"secret"`
+	ConfirmedAt    pgtype.Timestamp `json:"confirmedAt"`
+	LastUsedStep   int64            `json:"lastUsedStep"`
+	FailedAttempts int32            `json:"failedAttempts"`
+	CreatedAt      pgtype.Timestamp `json:"createdAt"`
+}
+
+type DataExport struct {
+	ID          pgtype.UUID      `json:"id"`
+	UserID      pgtype.UUID      `json:"userId"`
+	Status      DataExportStatus `json:"status"`
+	ArchiveKey  pgtype.Text      `json:"archiveKey"`
+	Error       pgtype.Text      `json:"error"`
+	CreatedAt   pgtype.Timestamp `json:"createdAt"`
+	StartedAt   pgtype.Timestamp `json:"startedAt"`
+	CompletedAt pgtype.Timestamp `json:"completedAt"`
+	ExpiresAt   pgtype.Timestamp `json:"expiresAt"`
+}
+
+type EmailDomainRule struct {
+	Domain    string            `json:"domain"`
+	Action    EmailDomainAction `json:"action"`
+	Note      pgtype.Text       `json:"note"`
+	CreatedAt pgtype.Timestamp  `json:"createdAt"`
+}
+
+type RateLimitBucket struct {
+	Key       string           `json:"key"`
+	Tokens    float64          `json:"tokens"`
+	UpdatedAt pgtype.Timestamp `json:"updatedAt"`
+	ExpiresAt pgtype.Timestamp `json:"expiresAt"`
+}
+
+type RefreshToken struct {
+	ID        pgtype.UUID      `json:"id"`
+	UserID    pgtype.UUID      `json:"userId"`
+	FamilyID  pgtype.UUID      `json:"familyId"`
+	ParentID  pgtype.UUID      `json:"parentId"`
+	ExpiresAt pgtype.Timestamp `json:"expiresAt"`
+	UsedAt    pgtype.Timestamp `json:"usedAt"`
+	RevokedAt pgtype.Timestamp `json:"revokedAt"`
+	CreatedAt pgtype.Timestamp `json:"createdAt"`
+	SessionID pgtype.UUID      `json:"sessionId"`
+}
+
+type Session struct {
+	ID          pgtype.UUID      `json:"id"`
+	UserID      pgtype.UUID      `json:"userId"`
+	DeviceLabel pgtype.Text      `json:"deviceLabel"`
+	IpAddress   pgtype.Text      `json:"ipAddress"`
+	UserAgent   pgtype.Text      `json:"userAgent"`
+	CreatedAt   pgtype.Timestamp `json:"createdAt"`
+	LastSeenAt  pgtype.Timestamp `json:"lastSeenAt"`
+	RevokedAt   pgtype.Timestamp `json:"revokedAt"`
 }
 
 type User struct {
@@ -124,3 +442,27 @@ type User struct {
 	UpdatedAt    pgtype.Timestamp `json:"updatedAt"`
 	AvatarKey    pgtype.Text      `json:"avatarKey"`
 }
+
+type WebauthnChallenge struct {
+	ID            pgtype.UUID      `json:"id"`
+	AuthID        pgtype.UUID      `json:"authId"`
+	Ceremony      WebauthnCeremony `json:"ceremony"`
+	ChallengeHash string           `json:"challengeHash"`
+	ExpiresAt     pgtype.Timestamp `json:"expiresAt"`
+	CreatedAt     pgtype.Timestamp `json:"createdAt"`
+}
+
+type WebauthnCredential struct {
+	ID             pgtype.UUID      `json:"id"`
+	AuthID         pgtype.UUID      `json:"authId"`
+	CredentialID   []byte           `json:"credentialId"`
+	PublicKey      []byte           `json:"publicKey"`
+	Algorithm      int64            `json:"algorithm"`
+	SignCount      int64            `json:"signCount"`
+	Aaguid         []byte           `json:"aaguid"`
+	BackupEligible bool             `json:"backupEligible"`
+	BackedUp       bool             `json:"backedUp"`
+	Label          pgtype.Text      `json:"label"`
+	CreatedAt      pgtype.Timestamp `json:"createdAt"`
+	LastUsedAt     pgtype.Timestamp `json:"lastUsedAt"`
+}
@@ -11,16 +11,91 @@ import (
 )
 
 type Querier interface {
+	AuthEmailExists(ctx context.Context, emailNormalized string) (bool, error)
+	AuthIdentityExists(ctx context.Context, arg AuthIdentityExistsParams) (bool, error)
+	ClaimNextDataExport(ctx context.Context, staleSeconds float64) (DataExport, error)
+	ClearDataExportArchive(ctx context.Context, arg ClearDataExportArchiveParams) (int64, error)
+	ClearUserAvatarKey(ctx context.Context, id pgtype.UUID) (User, error)
+	CompleteDataExport(ctx context.Context, arg CompleteDataExportParams) (int64, error)
+	ConfirmAuthTotp(ctx context.Context, arg ConfirmAuthTotpParams) (int64, error)
+	ConsumeMagicLinkByUserID(ctx context.Context, arg ConsumeMagicLinkByUserIDParams) (int64, error)
+	ConsumeWebauthnChallenge(ctx context.Context, arg ConsumeWebauthnChallengeParams) (WebauthnChallenge, error)
+	CountAuthIdentitiesByAuthID(ctx context.Context, authID pgtype.UUID) (int64, error)
+	DeleteAuthDeletionByTokenHash(ctx context.Context, restoreTokenHash string) (pgtype.UUID, error)
+	DeleteAuthEmailChange(ctx context.Context, arg DeleteAuthEmailChangeParams) (int64, error)
+	DeleteAuthEmailChangesByAuthID(ctx context.Context, authID pgtype.UUID) error
+	DeleteAuthIdentity(ctx context.Context, arg DeleteAuthIdentityParams) (int64, error)
+	DeleteAuthRecoveryCodesByAuthID(ctx context.Context, authID pgtype.UUID) error
+	DeleteDueAuth(ctx context.Context, id pgtype.UUID) (int64, error)
+	DeleteExpiredRateLimitBuckets(ctx context.Context, now pgtype.Timestamp) error
+	DeleteExpiredWebauthnChallenges(ctx context.Context) error
 	DeleteOtpCodeEntryByAuthID(ctx context.Context, authID pgtype.UUID) error
-	DeleteOtpCodesByEmail(ctx context.Context, email string) error
+	DeleteOtpCodesByEmail(ctx context.Context, emailNormalized string) error
 	DeleteOtpCodesByUserID(ctx context.Context, userID pgtype.UUID) error
-	GetUserByEmail(ctx context.Context, email string) (User, error)
+	DeleteSupersededOtpCodesByAuthID(ctx context.Context, authID pgtype.UUID) error
+	DeleteWebauthnCredential(ctx context.Context, arg DeleteWebauthnCredentialParams) (int64, error)
+	FailDataExport(ctx context.Context, arg FailDataExportParams) (int64, error)
+	GetActiveAuthEmailChange(ctx context.Context, authID pgtype.UUID) (AuthEmailChange, error)
+	GetActiveAuthRestriction(ctx context.Context, id pgtype.UUID) (GetActiveAuthRestrictionRow, error)
+	GetActiveOtpCodesByEmail(ctx context.Context, emailNormalized string) ([]GetActiveOtpCodesByEmailRow, error)
+	GetAuthByID(ctx context.Context, id pgtype.UUID) (Auth, error)
+	GetAuthByIdentity(ctx context.Context, arg GetAuthByIdentityParams) (Auth, error)
+	GetAuthEmailChangeAge(ctx context.Context, authID pgtype.UUID) (float64, error)
+	GetAuthTotpByAuthID(ctx context.Context, authID pgtype.UUID) (AuthTotp, error)
+	GetLatestDataExportByUserID(ctx context.Context, userID pgtype.UUID) (DataExport, error)
+	GetLatestOtpCodeAgeByAuthID(ctx context.Context, authID pgtype.UUID) (float64, error)
+	GetRateLimitBucketForUpdate(ctx context.Context, key string) (RateLimitBucket, error)
+	GetRefreshTokenByID(ctx context.Context, id pgtype.UUID) (RefreshToken, error)
+	GetSessionByID(ctx context.Context, id pgtype.UUID) (Session, error)
+	GetUserByAuthID(ctx context.Context, authID pgtype.UUID) (User, error)
+	GetUserByEmail(ctx context.Context, emailNormalized string) (User, error)
 	GetUserByID(ctx context.Context, id pgtype.UUID) (User, error)
-	GetUserIdAndEmailByOtpCode(ctx context.Context, code string) (GetUserIdAndEmailByOtpCodeRow, error)
+	GetWebauthnCredentialByCredentialID(ctx context.Context, credentialID []byte) (WebauthnCredential, error)
+	IncrementAuthEmailChangeAttempts(ctx context.Context, authID pgtype.UUID) (int32, error)
+	IncrementAuthTotpFailedAttempts(ctx context.Context, authID pgtype.UUID) (int32, error)
+	IncrementOtpAttemptsByEmail(ctx context.Context, emailNormalized string) (int32, error)
 	InsertAuth(ctx context.Context, arg InsertAuthParams) (pgtype.UUID, error)
+	InsertAuthDeletion(ctx context.Context, arg InsertAuthDeletionParams) (AuthDeletion, error)
+	InsertAuthIdentity(ctx context.Context, arg InsertAuthIdentityParams) (AuthIdentity, error)
 	InsertAuthOtpCode(ctx context.Context, arg InsertAuthOtpCodeParams) (pgtype.UUID, error)
+	InsertAuthRecoveryCode(ctx context.Context, arg InsertAuthRecoveryCodeParams) error
+	InsertAuthSuspensionEvent(ctx context.Context, arg InsertAuthSuspensionEventParams) (AuthSuspensionEvent, error)
+	InsertDataExport(ctx context.Context, userID pgtype.UUID) (DataExport, error)
+	InsertRateLimitBucket(ctx context.Context, arg InsertRateLimitBucketParams) error
+	InsertRefreshToken(ctx context.Context, arg InsertRefreshTokenParams) error
+	InsertSession(ctx context.Context, arg InsertSessionParams) (Session, error)
 	InsertUser(ctx context.Context, arg InsertUserParams) (User, error)
+	InsertWebauthnChallenge(ctx context.Context, arg InsertWebauthnChallengeParams) error
+	InsertWebauthnCredential(ctx context.Context, arg InsertWebauthnCredentialParams) (WebauthnCredential, error)
+	LiftAuthSuspension(ctx context.Context, id pgtype.UUID) (int64, error)
+	ListActiveSessionsByUserID(ctx context.Context, userID pgtype.UUID) ([]Session, error)
+	ListAuthIdentitiesByAuthID(ctx context.Context, authID pgtype.UUID) ([]AuthIdentity, error)
+	ListAuthSuspensionEvents(ctx context.Context, authID pgtype.UUID) ([]AuthSuspensionEvent, error)
+	ListDueAuthDeletions(ctx context.Context, maxRows int32) ([]ListDueAuthDeletionsRow, error)
+	ListEmailDomainRules(ctx context.Context, domains []string) ([]EmailDomainRule, error)
+	ListExpiredDataExports(ctx context.Context, maxRows int32) ([]DataExport, error)
+	ListSessionsByUserID(ctx context.Context, userID pgtype.UUID) ([]Session, error)
+	ListWebauthnCredentialsByAuthID(ctx context.Context, authID pgtype.UUID) ([]WebauthnCredential, error)
+	LockAuthByID(ctx context.Context, id pgtype.UUID) (pgtype.UUID, error)
+	MarkAuthFirstLogin(ctx context.Context, id pgtype.UUID) (int64, error)
+	ResetAuthTotpFailedAttempts(ctx context.Context, authID pgtype.UUID) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID pgtype.UUID) error
+	RevokeRefreshTokensBySessionID(ctx context.Context, sessionID pgtype.UUID) error
+	RevokeRefreshTokensByUserID(ctx context.Context, userID pgtype.UUID) error
+	RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error)
+	RevokeSessionsByUserID(ctx context.Context, userID pgtype.UUID) error
+	SuspendAuth(ctx context.Context, arg SuspendAuthParams) (int64, error)
+	TouchSession(ctx context.Context, id pgtype.UUID) error
+	UpdateAuthEmail(ctx context.Context, arg UpdateAuthEmailParams) (int64, error)
+	UpdateEmailOtpIdentity(ctx context.Context, arg UpdateEmailOtpIdentityParams) error
+	UpdateRateLimitBucket(ctx context.Context, arg UpdateRateLimitBucketParams) error
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	UpdateWebauthnCredentialSignCount(ctx context.Context, arg UpdateWebauthnCredentialSignCountParams) (int64, error)
+	UpsertAuthEmailChange(ctx context.Context, arg UpsertAuthEmailChangeParams) (AuthEmailChange, error)
+	UpsertPendingAuthTotp(ctx context.Context, arg UpsertPendingAuthTotpParams) (AuthTotp, error)
+	UseAuthRecoveryCode(ctx context.Context, arg UseAuthRecoveryCodeParams) (int64, error)
+	UseAuthTotpStep(ctx context.Context, arg UseAuthTotpStepParams) (int64, error)
+	UseRefreshToken(ctx context.Context, id pgtype.UUID) (RefreshToken, error)
 	UserNameExists(ctx context.Context, username string) (int64, error)
 }
 
@@ -1,9 +1,13 @@
 package environment
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 )
@@ -12,12 +16,115 @@ type ResendEnvironment struct {
 	Key string
 }
 type TokenEnvironment struct {
-	Secret                 string
+	Secret string
+	// SigningKeys is a JSON array of asymmetric key specs; when empty tokens are
+	// signed with HS256 and Secret.
+	SigningKeys            string
 	AccessTokenExpireTime  int
 	RefreshTokenExpireTime int
 	Issuer                 string
 	Audience               string
 }
+type OTPEnvironment struct {
+	MaxAttempts int
+	// MagicLinkURL is where login links point; the signed token is appended as
+	// the token query parameter.
+	MagicLinkURL string
+	// TOTPIssuer names the service in authenticator apps.
+	TOTPIssuer string
+	// Lifetime is how long an emailed code or link stays valid.
+	Lifetime time.Duration
+	// ResendCooldown is the minimum time between two login emails to the same
+	// account. Zero disables it.
+	ResendCooldown time.Duration
+	// HideAccountExistence makes /auth/send-otp answer alike whether or not the
+	// email has an account. Whether the account is new is then only told after
+	// a successful verification.
+	HideAccountExistence bool
+	// SendMinDuration is the least time a send takes when accounts are hidden,
+	// so the response time does not tell the cases apart either.
+	SendMinDuration time.Duration
+}
+type WebAuthnEnvironment struct {
+	// RPID is the domain passkeys are scoped to.
+	RPID   string
+	RPName string
+	// Origins lists the web and app origins allowed to run ceremonies.
+	Origins []string
+}
+type RateLimitEnvironment struct {
+	// Backend is "memory" for a single instance or "postgres" to share the
+	// budgets between instances.
+	Backend         string
+	SendOTPPerEmail RateLimit
+	SendOTPPerIP    RateLimit
+	SendOTPGlobal   RateLimit
+	// LoginPerIP is shared by every operation that completes a login, so one
+	// address cannot keep guessing codes, links, tokens or assertions.
+	LoginPerIP RateLimit
+}
+
+// RateLimit allows Burst requests at once, refilled evenly over Period. It is
+// configured as "<burst>/<period>", e.g. "5/1h".
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+type AccountEnvironment struct {
+	// DeletionGracePeriod is how long a deleted account can still be restored
+	// before it is purged.
+	DeletionGracePeriod time.Duration
+	// PurgeInterval is how often accounts past their grace period are purged.
+	PurgeInterval time.Duration
+	// RestoreURL is where restore links point; the token is appended as the
+	// token query parameter.
+	RestoreURL string
+}
+type EmailPolicyEnvironment struct {
+	// AllowlistFile and DenylistFile name files of one domain per line that
+	// are allowed or refused for new accounts. Empty skips the file.
+	AllowlistFile string
+	DenylistFile  string
+	// BlockDisposable refuses the bundled list of disposable mailbox domains.
+	BlockDisposable bool
+	// CheckMX refuses domains that publish no mail exchanger.
+	CheckMX bool
+}
+type ExportEnvironment struct {
+	// PollInterval is how often the export worker looks for pending exports.
+	PollInterval time.Duration
+	// LinkTTL is how long the emailed download link stays valid; the archive is
+	// deleted by the worker once it has passed.
+	LinkTTL time.Duration
+}
+type AvatarEnvironment struct {
+	// UploadsEnabled turns avatar uploads off when false; reading and deleting
+	// the current avatar keep working.
+	UploadsEnabled bool
+	// MaxBytes is the largest image accepted for an upload.
+	MaxBytes int64
+	// URLTTL is how long a signed avatar URL stays valid.
+	URLTTL time.Duration
+}
+type ImageEnvironment struct {
+	// MaxWidth and MaxHeight bound uploaded images that are not avatars.
+	MaxWidth  uint
+	MaxHeight uint
+	// MaxPixels caps the area of an image that has to be scaled down; 0 turns
+	// the cap off.
+	MaxPixels uint
+	// Quality is the WebP quality from 0 to 100, or the effort when Lossless.
+	Quality  float32
+	Lossless bool
+	// Method trades encoding speed for size, from 0 (fastest) to 6.
+	Method int
+	// Resampling is one of nearest, bilinear, catmullrom, mitchell or lanczos.
+	Resampling string
+}
+type GoogleEnvironment struct {
+	ClientID string
+	JWKSURL  string
+}
 type R2Environment struct {
 	BucketName      string
 	URL             string
@@ -25,10 +132,17 @@ type R2Environment struct {
 	AccessKeyID     string
 	SecretAccessKey string
 	AccountID       string
+	// Endpoint overrides the Cloudflare endpoint derived from AccountID, e.g.
+	// to point at a local S3-compatible server. Objects are then addressed by
+	// path rather than by bucket subdomain.
+	Endpoint string
 }
 type APIEnvironment struct {
 	Port    string
 	Version string
+	// TrustedProxies are the proxies whose X-Forwarded-For is believed. The
+	// client address of any other connection is its remote address.
+	TrustedProxies []*net.IPNet
 
 	Title       string
 	Description string
@@ -38,11 +152,25 @@ type Environment struct {
 	DatabaseURL string
 	Ctx         context.Context
 	Token       TokenEnvironment
+	OTP         OTPEnvironment
+	Google      GoogleEnvironment
+	WebAuthn    WebAuthnEnvironment
+	RateLimit   RateLimitEnvironment
+	Account     AccountEnvironment
+	EmailPolicy EmailPolicyEnvironment
+	Export      ExportEnvironment
+	Avatar      AvatarEnvironment
+	Image       ImageEnvironment
 	R2          R2Environment
 	API         APIEnvironment
 }
 
 func Load() (*Environment, error) {
+	trustedProxies, err := parseTrustedProxies(getOrReturnPlaceholder("TRUSTED_PROXIES", ""))
+	if err != nil {
+		return nil, err
+	}
+
 	accessTokenExpireTime, err := strconv.Atoi(getOrThrow("ACCESS_TOKEN_EXPIRE_TIME"))
 	if err != nil {
 		return nil, fmt.Errorf("error converting ACCESS_TOKEN_EXPIRE_TIME to int: %w", err)
@@ -51,6 +179,71 @@ func Load() (*Environment, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error converting REFRESH_TOKEN_EXPIRE_TIME to int: %w", err)
 	}
+	otpMaxAttempts, err := strconv.Atoi(getOrReturnPlaceholder("OTP_MAX_ATTEMPTS", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("error converting OTP_MAX_ATTEMPTS to int: %w", err)
+	}
+	otpLifetime, err := time.ParseDuration(getOrReturnPlaceholder("OTP_LIFETIME", "3m"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing OTP_LIFETIME: %w", err)
+	}
+	if otpLifetime <= 0 {
+		return nil, errors.New("OTP_LIFETIME must be positive")
+	}
+	otpResendCooldown, err := time.ParseDuration(getOrReturnPlaceholder("OTP_RESEND_COOLDOWN", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing OTP_RESEND_COOLDOWN: %w", err)
+	}
+	if otpResendCooldown < 0 {
+		return nil, errors.New("OTP_RESEND_COOLDOWN cannot be negative")
+	}
+	otpHideAccountExistence, err := strconv.ParseBool(getOrReturnPlaceholder("OTP_HIDE_ACCOUNT_EXISTENCE", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing OTP_HIDE_ACCOUNT_EXISTENCE: %w", err)
+	}
+	otpSendMinDuration, err := time.ParseDuration(getOrReturnPlaceholder("OTP_SEND_MIN_DURATION", "1s"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing OTP_SEND_MIN_DURATION: %w", err)
+	}
+	if otpSendMinDuration < 0 {
+		return nil, errors.New("OTP_SEND_MIN_DURATION cannot be negative")
+	}
+
+	rateLimit, err := loadRateLimitEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := loadAccountEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	emailPolicy, err := loadEmailPolicyEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	export, err := loadExportEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	avatar, err := loadAvatarEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	imageConf, err := loadImageEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	tokenSecret := getOrReturnPlaceholder("TOKEN_SECRET", "")
+	tokenSigningKeys := getOrReturnPlaceholder("TOKEN_SIGNING_KEYS", "")
+	if tokenSecret == "" && tokenSigningKeys == "" {
+		return nil, fmt.Errorf("one of TOKEN_SECRET or TOKEN_SIGNING_KEYS must be set")
+	}
 
 	return &Environment{
 		Resend: ResendEnvironment{
@@ -60,12 +253,37 @@ func Load() (*Environment, error) {
 		DatabaseURL: getOrThrow("DATABASE_URL"),
 		Ctx:         context.Background(),
 		Token: TokenEnvironment{
-			Secret:                 getOrThrow("TOKEN_SECRET"),
+			Secret:                 tokenSecret,
+			SigningKeys:            tokenSigningKeys,
 			AccessTokenExpireTime:  accessTokenExpireTime,
 			RefreshTokenExpireTime: refreshTokenExpireTime,
 			Issuer:                 getOrThrow("ISSUER"),
 			Audience:               getOrThrow("AUDIENCE"),
 		},
+		OTP: OTPEnvironment{
+			MaxAttempts:          otpMaxAttempts,
+			MagicLinkURL:         getOrReturnPlaceholder("MAGIC_LINK_URL", "qq://auth/magic-link"),
+			TOTPIssuer:           getOrReturnPlaceholder("TOTP_ISSUER", "QQ"),
+			Lifetime:             otpLifetime,
+			ResendCooldown:       otpResendCooldown,
+			HideAccountExistence: otpHideAccountExistence,
+			SendMinDuration:      otpSendMinDuration,
+		},
+		Google: GoogleEnvironment{
+			ClientID: getOrReturnPlaceholder("GOOGLE_CLIENT_ID", ""),
+			JWKSURL:  getOrReturnPlaceholder("GOOGLE_JWKS_URL", "https://www.googleapis.com/oauth2/v3/certs"),
+		},
+		WebAuthn: WebAuthnEnvironment{
+			RPID:    getOrReturnPlaceholder("WEBAUTHN_RP_ID", "localhost"),
+			RPName:  getOrReturnPlaceholder("WEBAUTHN_RP_NAME", "QQ"),
+			Origins: splitList(getOrReturnPlaceholder("WEBAUTHN_ORIGINS", "http://localhost:3003")),
+		},
+		RateLimit:   *rateLimit,
+		Account:     *account,
+		EmailPolicy: *emailPolicy,
+		Export:      *export,
+		Avatar:      *avatar,
+		Image:       *imageConf,
 		R2: R2Environment{
 			BucketName:      getOrThrow("R2_BUCKET_NAME"),
 			URL:             getOrThrow("R2_URL"),
@@ -73,13 +291,15 @@ func Load() (*Environment, error) {
 			AccessKeyID:     getOrThrow("R2_ACCESS_KEY_ID"),
 			SecretAccessKey: getOrThrow("R2_SECRET_ACCESS_KEY"),
 			AccountID:       getOrThrow("R2_ACCOUNT_ID"),
+			Endpoint:        getOrReturnPlaceholder("R2_ENDPOINT", ""),
 		},
 		API: APIEnvironment{
 
-			Port:        getOrThrow("API_PORT"),
-			Version:     getOrReturnPlaceholder("API_VERSION", "0.0.1"),
-			Title:       getOrReturnPlaceholder("API_TITLE", "QQ API"),
-			Description: getOrReturnPlaceholder("API_DESCRIPTION", "QQ API"),
+			Port:           getOrThrow("API_PORT"),
+			Version:        getOrReturnPlaceholder("API_VERSION", "0.0.1"),
+			TrustedProxies: trustedProxies,
+			Title:          getOrReturnPlaceholder("API_TITLE", "QQ API"),
+			Description:    getOrReturnPlaceholder("API_DESCRIPTION", "QQ API"),
 		},
 	}, nil
 }
@@ -97,3 +317,223 @@ func getOrReturnPlaceholder(env string, placeholder string) string {
 	}
 	return os.Getenv(env)
 }
+
+func loadRateLimitEnvironment() (*RateLimitEnvironment, error) {
+	conf := &RateLimitEnvironment{Backend: getOrReturnPlaceholder("RATE_LIMIT_BACKEND", "memory")}
+	if conf.Backend != "memory" && conf.Backend != "postgres" {
+		return nil, fmt.Errorf("RATE_LIMIT_BACKEND must be memory or postgres, got %q", conf.Backend)
+	}
+
+	limits := []struct {
+		env      string
+		fallback string
+		target   *RateLimit
+	}{
+		{"RATE_LIMIT_SEND_OTP_EMAIL", "5/1h", &conf.SendOTPPerEmail},
+		{"RATE_LIMIT_SEND_OTP_IP", "20/1h", &conf.SendOTPPerIP},
+		{"RATE_LIMIT_SEND_OTP_GLOBAL", "1000/1h", &conf.SendOTPGlobal},
+		{"RATE_LIMIT_LOGIN_IP", "60/1h", &conf.LoginPerIP},
+	}
+	for _, limit := range limits {
+		parsed, err := ParseRateLimit(getOrReturnPlaceholder(limit.env, limit.fallback))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", limit.env, err)
+		}
+		*limit.target = parsed
+	}
+	return conf, nil
+}
+
+func loadAccountEnvironment() (*AccountEnvironment, error) {
+	gracePeriod, err := time.ParseDuration(getOrReturnPlaceholder("ACCOUNT_DELETION_GRACE_PERIOD", "720h"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ACCOUNT_DELETION_GRACE_PERIOD: %w", err)
+	}
+	if gracePeriod < 0 {
+		return nil, errors.New("ACCOUNT_DELETION_GRACE_PERIOD cannot be negative")
+	}
+	purgeInterval, err := time.ParseDuration(getOrReturnPlaceholder("ACCOUNT_PURGE_INTERVAL", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ACCOUNT_PURGE_INTERVAL: %w", err)
+	}
+	if purgeInterval <= 0 {
+		return nil, errors.New("ACCOUNT_PURGE_INTERVAL must be positive")
+	}
+	return &AccountEnvironment{
+		DeletionGracePeriod: gracePeriod,
+		PurgeInterval:       purgeInterval,
+		RestoreURL:          getOrReturnPlaceholder("ACCOUNT_RESTORE_URL", "qq://account/restore"),
+	}, nil
+}
+
+func loadEmailPolicyEnvironment() (*EmailPolicyEnvironment, error) {
+	blockDisposable, err := strconv.ParseBool(getOrReturnPlaceholder("EMAIL_BLOCK_DISPOSABLE", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing EMAIL_BLOCK_DISPOSABLE: %w", err)
+	}
+	checkMX, err := strconv.ParseBool(getOrReturnPlaceholder("EMAIL_CHECK_MX", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing EMAIL_CHECK_MX: %w", err)
+	}
+	return &EmailPolicyEnvironment{
+		AllowlistFile:   getOrReturnPlaceholder("EMAIL_ALLOWLIST_FILE", ""),
+		DenylistFile:    getOrReturnPlaceholder("EMAIL_DENYLIST_FILE", ""),
+		BlockDisposable: blockDisposable,
+		CheckMX:         checkMX,
+	}, nil
+}
+
+// maxPresignTTL is the longest validity S3 accepts for a presigned URL.
+const maxPresignTTL = 7 * 24 * time.Hour
+
+func loadExportEnvironment() (*ExportEnvironment, error) {
+	pollInterval, err := time.ParseDuration(getOrReturnPlaceholder("EXPORT_POLL_INTERVAL", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing EXPORT_POLL_INTERVAL: %w", err)
+	}
+	if pollInterval <= 0 {
+		return nil, errors.New("EXPORT_POLL_INTERVAL must be positive")
+	}
+	linkTTL, err := time.ParseDuration(getOrReturnPlaceholder("EXPORT_LINK_TTL", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing EXPORT_LINK_TTL: %w", err)
+	}
+	if linkTTL <= 0 || linkTTL > maxPresignTTL {
+		return nil, errors.New("EXPORT_LINK_TTL must be positive and at most 168h")
+	}
+	return &ExportEnvironment{
+		PollInterval: pollInterval,
+		LinkTTL:      linkTTL,
+	}, nil
+}
+
+func loadAvatarEnvironment() (*AvatarEnvironment, error) {
+	uploadsEnabled, err := strconv.ParseBool(getOrReturnPlaceholder("AVATAR_UPLOADS_ENABLED", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing AVATAR_UPLOADS_ENABLED: %w", err)
+	}
+	maxBytes, err := strconv.ParseInt(getOrReturnPlaceholder("AVATAR_MAX_BYTES", "10485760"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error converting AVATAR_MAX_BYTES to int: %w", err)
+	}
+	if maxBytes <= 0 {
+		return nil, errors.New("AVATAR_MAX_BYTES must be positive")
+	}
+	urlTTL, err := time.ParseDuration(getOrReturnPlaceholder("AVATAR_URL_TTL", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing AVATAR_URL_TTL: %w", err)
+	}
+	if urlTTL <= 0 || urlTTL > maxPresignTTL {
+		return nil, errors.New("AVATAR_URL_TTL must be positive and at most 168h")
+	}
+	return &AvatarEnvironment{
+		UploadsEnabled: uploadsEnabled,
+		MaxBytes:       maxBytes,
+		URLTTL:         urlTTL,
+	}, nil
+}
+
+func loadImageEnvironment() (*ImageEnvironment, error) {
+	maxWidth, err := strconv.ParseUint(getOrReturnPlaceholder("IMAGE_MAX_WIDTH", "2048"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("error converting IMAGE_MAX_WIDTH to int: %w", err)
+	}
+	maxHeight, err := strconv.ParseUint(getOrReturnPlaceholder("IMAGE_MAX_HEIGHT", "1080"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("error converting IMAGE_MAX_HEIGHT to int: %w", err)
+	}
+	if maxWidth == 0 || maxHeight == 0 {
+		return nil, errors.New("IMAGE_MAX_WIDTH and IMAGE_MAX_HEIGHT must be positive")
+	}
+	maxPixels, err := strconv.ParseUint(getOrReturnPlaceholder("IMAGE_MAX_PIXELS", "400000"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("error converting IMAGE_MAX_PIXELS to int: %w", err)
+	}
+	quality, err := strconv.ParseFloat(getOrReturnPlaceholder("IMAGE_QUALITY", "45"), 32)
+	if err != nil {
+		return nil, fmt.Errorf("error converting IMAGE_QUALITY to float: %w", err)
+	}
+	if quality < 0 || quality > 100 {
+		return nil, errors.New("IMAGE_QUALITY must be between 0 and 100")
+	}
+	lossless, err := strconv.ParseBool(getOrReturnPlaceholder("IMAGE_LOSSLESS", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing IMAGE_LOSSLESS: %w", err)
+	}
+	method, err := strconv.Atoi(getOrReturnPlaceholder("IMAGE_METHOD", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("error converting IMAGE_METHOD to int: %w", err)
+	}
+	if method < 0 || method > 6 {
+		return nil, errors.New("IMAGE_METHOD must be between 0 and 6")
+	}
+	resampling := getOrReturnPlaceholder("IMAGE_RESAMPLING", "catmullrom")
+	switch resampling {
+	case "nearest", "bilinear", "catmullrom", "mitchell", "lanczos":
+	default:
+		return nil, fmt.Errorf(
+			"IMAGE_RESAMPLING must be nearest, bilinear, catmullrom, mitchell or lanczos, got %q", resampling)
+	}
+	return &ImageEnvironment{
+		MaxWidth:   uint(maxWidth),
+		MaxHeight:  uint(maxHeight),
+		MaxPixels:  uint(maxPixels),
+		Quality:    float32(quality),
+		Lossless:   lossless,
+		Method:     method,
+		Resampling: resampling,
+	}, nil
+}
+
+// ParseRateLimit reads a "<burst>/<period>" rate such as "5/1h".
+func ParseRateLimit(value string) (RateLimit, error) {
+	burstPart, periodPart, ok := strings.Cut(value, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate %q is not of the form <burst>/<period>", value)
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(burstPart))
+	if err != nil || burst < 1 {
+		return RateLimit{}, fmt.Errorf("rate %q needs a positive burst", value)
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(periodPart))
+	if err != nil || period <= 0 {
+		return RateLimit{}, fmt.Errorf("rate %q needs a positive period", value)
+	}
+	return RateLimit{Burst: burst, Period: period}, nil
+}
+
+// parseTrustedProxies reads a comma-separated list of CIDRs or single IPs.
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, item := range splitList(value) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES has an invalid address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, proxy, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES has an invalid range %q: %w", item, err)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// splitList parses a comma-separated variable, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
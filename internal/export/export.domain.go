@@ -0,0 +1,79 @@
+package export
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+var (
+	ErrNotFound         = fmt.Errorf("data export %w", qqerrors.ErrNotFound)
+	ErrExportInProgress = fmt.Errorf("a data export is already in progress: %w", qqerrors.ErrUniqueViolation)
+)
+
+var moduleErrors = []int{400, 401, 403, 404, 409, 500}
+var moduleTags = []string{"Export"}
+var moduleSecurity = []map[string][]string{{"bearer": {}}}
+
+const (
+	RequestExport = "requestDataExport"
+	GetExport     = "getDataExport"
+)
+
+var operations = map[string]huma.Operation{
+	RequestExport: {
+		Method:        "POST",
+		Path:          "/me/export",
+		Summary:       "Request a data export",
+		Description:   "Queue an archive of the data of the current user; a download link is emailed when it is ready",
+		OperationID:   RequestExport,
+		DefaultStatus: http.StatusAccepted,
+		Errors:        moduleErrors,
+		Tags:          moduleTags,
+		Security:      moduleSecurity,
+	},
+	GetExport: {
+		Method:      "GET",
+		Path:        "/me/export",
+		Summary:     "Get data export status",
+		Description: "Get the status of the most recent data export of the current user",
+		OperationID: GetExport,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+}
+
+type ExportData struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status" enum:"pending,running,completed,failed"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// totpExport is the TOTP enrollment written to the archive. EnrolledAt is set
+// once enrollment was started, ConfirmedAt once it was finished.
+type totpExport struct {
+	Enabled     bool       `json:"enabled"`
+	EnrolledAt  *time.Time `json:"enrolledAt,omitempty"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+}
+
+type RequestExportInput struct{}
+
+type RequestExportOutput struct {
+	Body struct {
+		Data ExportData
+	}
+}
+
+type GetExportInput struct{}
+
+type GetExportOutput struct {
+	Body struct {
+		Data ExportData
+	}
+}
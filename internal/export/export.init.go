@@ -0,0 +1,71 @@
+package export
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	mail "github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Module struct {
+	usecase Usecase
+	server  Server
+}
+
+func NewModule(
+	pool *pgxpool.Pool,
+	uploader fileupload.Uploader,
+	mailer mail.Service,
+	conf environment.ExportEnvironment,
+) *Module {
+	usecase := NewUsecase(NewPgxRepository(pool), uploader, mailer, conf)
+	server := NewServer(usecase)
+
+	return &Module{
+		usecase: usecase,
+		server:  server,
+	}
+}
+
+func (em *Module) RegisterEndpoints(api huma.API) {
+	em.server.RegisterExportEndpoints(api)
+}
+
+// RunWorker deletes expired archives and processes queued exports until none
+// are left, then waits for the next interval, until ctx is done.
+func (em *Module) RunWorker(ctx context.Context, interval time.Duration) {
+	logger := slog.Default()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := em.usecase.DeleteExpiredArchives(ctx)
+		if err != nil {
+			logger.Error("Error deleting expired data exports", "error", err)
+		}
+		if deleted > 0 {
+			logger.Info("Deleted expired data exports", "count", deleted)
+		}
+
+		for ctx.Err() == nil {
+			processed, err := em.usecase.ProcessNext(ctx)
+			if err != nil {
+				logger.Error("Error processing data export", "error", err)
+			}
+			if !processed {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Repository interface {
+	WithTx(tx pgx.Tx) Repository
+	CreateExport(ctx context.Context, userID pgtype.UUID) (*db.DataExport, error)
+	GetLatestExport(ctx context.Context, userID pgtype.UUID) (*db.DataExport, error)
+	ClaimNextExport(ctx context.Context, staleAfter time.Duration) (*db.DataExport, error)
+	CompleteExport(ctx context.Context, exportID pgtype.UUID, archiveKey string, ttl time.Duration) error
+	FailExport(ctx context.Context, exportID pgtype.UUID, archiveKey string, reason string) error
+	ListExpiredExports(ctx context.Context, maxRows int32) ([]db.DataExport, error)
+	ClearExportArchive(ctx context.Context, exportID pgtype.UUID, archiveKey string) error
+	GetUser(ctx context.Context, userID pgtype.UUID) (*db.User, error)
+	GetAuth(ctx context.Context, authID pgtype.UUID) (*db.Auth, error)
+	ListIdentities(ctx context.Context, authID pgtype.UUID) ([]db.AuthIdentity, error)
+	ListSessions(ctx context.Context, userID pgtype.UUID) ([]db.Session, error)
+	ListPasskeys(ctx context.Context, authID pgtype.UUID) ([]db.WebauthnCredential, error)
+	GetTOTP(ctx context.Context, authID pgtype.UUID) (*db.AuthTotp, error)
+}
+
+type pgxRepository struct {
+	q *db.Queries
+}
+
+func NewPgxRepository(pool *pgxpool.Pool) Repository {
+	return &pgxRepository{
+		q: db.New(pool),
+	}
+}
+
+func (r *pgxRepository) WithTx(tx pgx.Tx) Repository {
+	return &pgxRepository{
+		q: r.q.WithTx(tx),
+	}
+}
+
+// CreateExport queues an export; ErrExportInProgress when the user already has
+// one pending or running.
+func (r *pgxRepository) CreateExport(ctx context.Context, userID pgtype.UUID) (*db.DataExport, error) {
+	export, err := r.q.InsertDataExport(ctx, userID)
+	if err != nil {
+		err = qqerrors.GetDBErrAsQQError(err)
+		if errors.Is(err, qqerrors.ErrUniqueViolation) {
+			return nil, ErrExportInProgress
+		}
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (r *pgxRepository) GetLatestExport(ctx context.Context, userID pgtype.UUID) (*db.DataExport, error) {
+	export, err := r.q.GetLatestDataExportByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &export, nil
+}
+
+// ClaimNextExport marks the oldest pending export as running and returns it. A
+// running export older than staleAfter is claimed again, as its worker is
+// assumed to have died. ErrNotFound when there is nothing to do.
+func (r *pgxRepository) ClaimNextExport(ctx context.Context, staleAfter time.Duration) (*db.DataExport, error) {
+	export, err := r.q.ClaimNextDataExport(ctx, staleAfter.Seconds())
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &export, nil
+}
+
+// CompleteExport records the stored archive, which expires ttl from now.
+func (r *pgxRepository) CompleteExport(
+	ctx context.Context, exportID pgtype.UUID, archiveKey string, ttl time.Duration) error {
+	rows, err := r.q.CompleteDataExport(ctx, db.CompleteDataExportParams{
+		ArchiveKey: pgtype.Text{String: archiveKey, Valid: true},
+		TtlSeconds: ttl.Seconds(),
+		ID:         exportID,
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FailExport records why the export failed. An archiveKey that was already
+// stored is kept on the export and expires at once, so the next sweep of
+// expired archives deletes it; pass "" when nothing was stored.
+func (r *pgxRepository) FailExport(ctx context.Context, exportID pgtype.UUID, archiveKey string, reason string) error {
+	rows, err := r.q.FailDataExport(ctx, db.FailDataExportParams{
+		Error:      pgtype.Text{String: reason, Valid: true},
+		ArchiveKey: pgtype.Text{String: archiveKey, Valid: archiveKey != ""},
+		ID:         exportID,
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListExpiredExports returns up to maxRows exports whose archive is still
+// stored past its expiry, oldest first.
+func (r *pgxRepository) ListExpiredExports(ctx context.Context, maxRows int32) ([]db.DataExport, error) {
+	exports, err := r.q.ListExpiredDataExports(ctx, maxRows)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return exports, nil
+}
+
+// ClearExportArchive records that the archive was deleted. ErrNotFound when
+// the export no longer points at archiveKey.
+func (r *pgxRepository) ClearExportArchive(ctx context.Context, exportID pgtype.UUID, archiveKey string) error {
+	rows, err := r.q.ClearDataExportArchive(ctx, db.ClearDataExportArchiveParams{
+		ID:         exportID,
+		ArchiveKey: pgtype.Text{String: archiveKey, Valid: true},
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgxRepository) GetUser(ctx context.Context, userID pgtype.UUID) (*db.User, error) {
+	user, err := r.q.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &user, nil
+}
+
+func (r *pgxRepository) GetAuth(ctx context.Context, authID pgtype.UUID) (*db.Auth, error) {
+	authRow, err := r.q.GetAuthByID(ctx, authID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &authRow, nil
+}
+
+func (r *pgxRepository) ListIdentities(ctx context.Context, authID pgtype.UUID) ([]db.AuthIdentity, error) {
+	identities, err := r.q.ListAuthIdentitiesByAuthID(ctx, authID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return identities, nil
+}
+
+func (r *pgxRepository) ListSessions(ctx context.Context, userID pgtype.UUID) ([]db.Session, error) {
+	sessions, err := r.q.ListSessionsByUserID(ctx, userID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return sessions, nil
+}
+
+func (r *pgxRepository) ListPasskeys(ctx context.Context, authID pgtype.UUID) ([]db.WebauthnCredential, error) {
+	credentials, err := r.q.ListWebauthnCredentialsByAuthID(ctx, authID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return credentials, nil
+}
+
+// GetTOTP returns the TOTP enrollment of the account; ErrNotFound when it never
+// started one.
+func (r *pgxRepository) GetTOTP(ctx context.Context, authID pgtype.UUID) (*db.AuthTotp, error) {
+	record, err := r.q.GetAuthTotpByAuthID(ctx, authID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &record, nil
+}
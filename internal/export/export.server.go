@@ -0,0 +1,82 @@
+package export
+
+import (
+	"context"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/middleware"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+type exportServer struct {
+	uc Usecase
+}
+type Server interface {
+	RequestExportHandler(ctx context.Context, input *RequestExportInput) (*RequestExportOutput, error)
+	GetExportHandler(ctx context.Context, input *GetExportInput) (*GetExportOutput, error)
+	RegisterExportEndpoints(api huma.API)
+}
+
+func NewServer(uc Usecase) Server {
+	return &exportServer{uc: uc}
+}
+
+func (s *exportServer) RequestExportHandler(
+	ctx context.Context, _ *RequestExportInput) (*RequestExportOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	export, err := s.uc.RequestExport(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &RequestExportOutput{
+		Body: struct {
+			Data ExportData
+		}{
+			Data: toExportData(export),
+		},
+	}, nil
+}
+
+func (s *exportServer) GetExportHandler(ctx context.Context, _ *GetExportInput) (*GetExportOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	export, err := s.uc.GetLatestExport(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &GetExportOutput{
+		Body: struct {
+			Data ExportData
+		}{
+			Data: toExportData(export),
+		},
+	}, nil
+}
+
+func (s *exportServer) RegisterExportEndpoints(api huma.API) {
+	huma.Register(api, operations[RequestExport], s.RequestExportHandler)
+	huma.Register(api, operations[GetExport], s.GetExportHandler)
+}
+
+func toExportData(export *db.DataExport) ExportData {
+	data := ExportData{
+		ID:        export.ID.String(),
+		Status:    string(export.Status),
+		CreatedAt: export.CreatedAt.Time,
+	}
+	if export.CompletedAt.Valid {
+		completedAt := export.CompletedAt.Time
+		data.CompletedAt = &completedAt
+	}
+	return data
+}
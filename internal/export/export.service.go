@@ -0,0 +1,296 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	mail "github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type Usecase interface {
+	RequestExport(ctx context.Context, user *db.User) (*db.DataExport, error)
+	GetLatestExport(ctx context.Context, user *db.User) (*db.DataExport, error)
+	ProcessNext(ctx context.Context) (bool, error)
+	DeleteExpiredArchives(ctx context.Context) (int, error)
+}
+
+// staleExportAfter is how long an export may stay running before another
+// worker takes it over.
+const staleExportAfter = 30 * time.Minute
+
+// expiredBatchSize is how many expired archives are listed at a time.
+const expiredBatchSize = 100
+
+type exportUsecase struct {
+	repo     Repository
+	uploader fileupload.Uploader
+	mailer   mail.Service
+	conf     environment.ExportEnvironment
+}
+
+func NewUsecase(
+	repo Repository,
+	uploader fileupload.Uploader,
+	mailer mail.Service,
+	conf environment.ExportEnvironment,
+) Usecase {
+	return &exportUsecase{
+		repo:     repo,
+		uploader: uploader,
+		mailer:   mailer,
+		conf:     conf,
+	}
+}
+
+// RequestExport queues an export for the worker. Only one export per user can
+// be pending or running at a time.
+func (uc *exportUsecase) RequestExport(ctx context.Context, user *db.User) (*db.DataExport, error) {
+	return uc.repo.CreateExport(ctx, user.ID)
+}
+
+func (uc *exportUsecase) GetLatestExport(ctx context.Context, user *db.User) (*db.DataExport, error) {
+	return uc.repo.GetLatestExport(ctx, user.ID)
+}
+
+// ProcessNext builds the archive of the oldest queued export, stores it in the
+// bucket and emails the user a signed download link. It reports whether an
+// export was claimed, so the worker can drain the queue before sleeping. An
+// export that cannot be built or mailed is marked failed and the user can ask
+// for a new one; an archive stored before the failure is left to the sweep of
+// expired archives.
+func (uc *exportUsecase) ProcessNext(ctx context.Context) (bool, error) {
+	export, err := uc.repo.ClaimNextExport(ctx, staleExportAfter)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	archiveKey := fmt.Sprintf("exports/%s.zip", export.ID.String())
+	stored, err := uc.deliver(ctx, export, archiveKey)
+	if err != nil {
+		storedKey := ""
+		if stored {
+			storedKey = archiveKey
+		}
+		if failErr := uc.repo.FailExport(ctx, export.ID, storedKey, err.Error()); failErr != nil {
+			err = errors.Join(err, failErr)
+		}
+		return true, fmt.Errorf("processing data export %s: %w", export.ID.String(), err)
+	}
+
+	return true, uc.repo.CompleteExport(ctx, export.ID, archiveKey, uc.conf.LinkTTL)
+}
+
+// DeleteExpiredArchives deletes the stored archives whose download link has
+// expired, or whose export failed, and returns how many were deleted. An archive that could not be
+// deleted stays recorded on its export and is retried on the next run.
+func (uc *exportUsecase) DeleteExpiredArchives(ctx context.Context) (int, error) {
+	deleted := 0
+	var errs []error
+	for {
+		expired, err := uc.repo.ListExpiredExports(ctx, expiredBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		batchDeleted := 0
+		for _, export := range expired {
+			archiveKey := export.ArchiveKey.String
+			err = uc.uploader.DeleteFile(ctx, archiveKey)
+			if err == nil {
+				err = uc.repo.ClearExportArchive(ctx, export.ID, archiveKey)
+			}
+			switch {
+			case errors.Is(err, ErrNotFound):
+			case err != nil:
+				errs = append(errs, fmt.Errorf("deleting data export archive %s: %w", archiveKey, err))
+			default:
+				batchDeleted++
+			}
+		}
+
+		deleted += batchDeleted
+		// Failed archives are listed again; stop rather than retry them in a loop.
+		if len(expired) < expiredBatchSize || batchDeleted == 0 {
+			return deleted, errors.Join(errs...)
+		}
+	}
+}
+
+// deliver stores the archive and mails its link. It reports whether the
+// archive was stored, which is also the case when mailing it failed.
+func (uc *exportUsecase) deliver(ctx context.Context, export *db.DataExport, archiveKey string) (bool, error) {
+	user, err := uc.repo.GetUser(ctx, export.UserID)
+	if err != nil {
+		return false, err
+	}
+	authRow, err := uc.repo.GetAuth(ctx, user.AuthID)
+	if err != nil {
+		return false, err
+	}
+
+	archive, err := uc.buildArchive(ctx, authRow, user)
+	if err != nil {
+		return false, err
+	}
+	if err = uc.uploader.PutFile(ctx, archiveKey, bytes.NewReader(archive), "application/zip"); err != nil {
+		return false, err
+	}
+
+	link, err := uc.uploader.GetSignedURL(ctx, archiveKey, uc.conf.LinkTTL)
+	if err != nil {
+		return true, err
+	}
+
+	template, err := uc.mailer.GetTemplate(ctx, "data_export")
+	if err != nil {
+		return true, err
+	}
+	body := strings.NewReplacer(
+		"{{.Link}}", html.EscapeString(*link),
+		"{{.ExpiresAt}}", time.Now().Add(uc.conf.LinkTTL).UTC().Format("2 January 2006 15:04 MST"),
+	).Replace(template)
+	return true, uc.mailer.SendEmail(ctx, mail.SendParams{
+		To:      authRow.Email,
+		From:    mail.DefaultFrom,
+		Subject: "Your data export is ready",
+		Body:    body,
+	})
+}
+
+// buildArchive writes the account, profile, login methods, sessions, passkeys
+// and TOTP enrollment of the user as JSON files, plus each avatar rendition. An
+// avatar uploaded before renditions is the single image at the key. Avatar
+// objects that are gone are left out rather than failing the export.
+func (uc *exportUsecase) buildArchive(ctx context.Context, authRow *db.Auth, user *db.User) ([]byte, error) {
+	identities, err := uc.repo.ListIdentities(ctx, authRow.ID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := uc.repo.ListSessions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := uc.repo.ListPasskeys(ctx, authRow.ID)
+	if err != nil {
+		return nil, err
+	}
+	totp, err := uc.totpState(ctx, authRow.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"account.json", authRow},
+		{"profile.json", user},
+		{"identities.json", identities},
+		{"sessions.json", sessions},
+		{"passkeys.json", passkeys},
+		{"totp.json", totp},
+	}
+	for _, file := range files {
+		if err = writeJSON(zw, file.name, file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.AvatarKey.Valid && user.AvatarKey.String != "" {
+		copied := 0
+		for _, rendition := range imageprocess.AvatarRenditions {
+			key := fileupload.RenditionKey(user.AvatarKey.String, rendition.Name)
+			ok, err := uc.copyAvatar(ctx, zw, "avatar/"+rendition.Name, key)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				copied++
+			}
+		}
+		if copied == 0 {
+			if _, err = uc.copyAvatar(ctx, zw, "avatar/image", user.AvatarKey.String); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// totpState reads the TOTP enrollment of the account without its secret, which
+// would let anyone holding the archive pass the second factor.
+func (uc *exportUsecase) totpState(ctx context.Context, authID pgtype.UUID) (totpExport, error) {
+	record, err := uc.repo.GetTOTP(ctx, authID)
+	if errors.Is(err, ErrNotFound) {
+		return totpExport{}, nil
+	}
+	if err != nil {
+		return totpExport{}, err
+	}
+	enrolledAt := record.CreatedAt.Time
+	state := totpExport{Enabled: record.ConfirmedAt.Valid, EnrolledAt: &enrolledAt}
+	if record.ConfirmedAt.Valid {
+		confirmedAt := record.ConfirmedAt.Time
+		state.ConfirmedAt = &confirmedAt
+	}
+	return state, nil
+}
+
+// copyAvatar copies an avatar object into the archive and reports whether it
+// was there.
+func (uc *exportUsecase) copyAvatar(ctx context.Context, zw *zip.Writer, name string, key string) (bool, error) {
+	err := uc.copyObject(ctx, zw, name, key)
+	if errors.Is(err, fileupload.ErrFileNotFound) {
+		slog.Default().Warn("Avatar object missing from data export", "key", key)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (uc *exportUsecase) copyObject(ctx context.Context, zw *zip.Writer, name string, key string) error {
+	object, err := uc.uploader.GetFile(ctx, key)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", key, err)
+	}
+	defer object.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, object)
+	return err
+}
+
+func writeJSON(zw *zip.Writer, name string, data any) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
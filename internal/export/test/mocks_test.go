@@ -0,0 +1,307 @@
+package export_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/export"
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	"github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// fakeRepository keeps exports in memory and serves one user with its auth row,
+// identities, sessions, passkeys and TOTP enrollment.
+type fakeRepository struct {
+	mu         sync.Mutex
+	exports    []*db.DataExport
+	user       db.User
+	auth       db.Auth
+	identities []db.AuthIdentity
+	sessions   []db.Session
+	passkeys   []db.WebauthnCredential
+	totp       *db.AuthTotp
+	nextID     byte
+}
+
+func (f *fakeRepository) WithTx(tx pgx.Tx) export.Repository {
+	return f
+}
+
+func (f *fakeRepository) CreateExport(ctx context.Context, userID pgtype.UUID) (*db.DataExport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, existing := range f.exports {
+		if existing.UserID == userID &&
+			(existing.Status == db.DataExportStatusPending || existing.Status == db.DataExportStatusRunning) {
+			return nil, export.ErrExportInProgress
+		}
+	}
+	f.nextID++
+	created := &db.DataExport{
+		ID:        pgtype.UUID{Bytes: [16]byte{f.nextID}, Valid: true},
+		UserID:    userID,
+		Status:    db.DataExportStatusPending,
+		CreatedAt: pgtype.Timestamp{Time: time.Now(), Valid: true},
+	}
+	f.exports = append(f.exports, created)
+	copied := *created
+	return &copied, nil
+}
+
+func (f *fakeRepository) GetLatestExport(ctx context.Context, userID pgtype.UUID) (*db.DataExport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.exports) - 1; i >= 0; i-- {
+		if f.exports[i].UserID == userID {
+			copied := *f.exports[i]
+			return &copied, nil
+		}
+	}
+	return nil, export.ErrNotFound
+}
+
+func (f *fakeRepository) ClaimNextExport(ctx context.Context, staleAfter time.Duration) (*db.DataExport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, candidate := range f.exports {
+		stale := candidate.Status == db.DataExportStatusRunning && time.Since(candidate.StartedAt.Time) > staleAfter
+		if candidate.Status == db.DataExportStatusPending || stale {
+			candidate.Status = db.DataExportStatusRunning
+			candidate.StartedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+			copied := *candidate
+			return &copied, nil
+		}
+	}
+	return nil, export.ErrNotFound
+}
+
+func (f *fakeRepository) CompleteExport(
+	ctx context.Context, exportID pgtype.UUID, archiveKey string, ttl time.Duration) error {
+	return f.finish(exportID, db.DataExportStatusCompleted, func(e *db.DataExport) {
+		e.ArchiveKey = pgtype.Text{String: archiveKey, Valid: true}
+		e.ExpiresAt = pgtype.Timestamp{Time: time.Now().Add(ttl), Valid: true}
+	})
+}
+
+func (f *fakeRepository) FailExport(
+	ctx context.Context, exportID pgtype.UUID, archiveKey string, reason string) error {
+	return f.finish(exportID, db.DataExportStatusFailed, func(e *db.DataExport) {
+		e.Error = pgtype.Text{String: reason, Valid: true}
+		e.ArchiveKey = pgtype.Text{String: archiveKey, Valid: archiveKey != ""}
+		e.ExpiresAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	})
+}
+
+func (f *fakeRepository) ListExpiredExports(ctx context.Context, maxRows int32) ([]db.DataExport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var expired []db.DataExport
+	for _, candidate := range f.exports {
+		if candidate.ArchiveKey.Valid && !candidate.ExpiresAt.Time.After(time.Now()) && len(expired) < int(maxRows) {
+			expired = append(expired, *candidate)
+		}
+	}
+	return expired, nil
+}
+
+func (f *fakeRepository) ClearExportArchive(ctx context.Context, exportID pgtype.UUID, archiveKey string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, candidate := range f.exports {
+		if candidate.ID == exportID && candidate.ArchiveKey.String == archiveKey {
+			candidate.ArchiveKey = pgtype.Text{}
+			return nil
+		}
+	}
+	return export.ErrNotFound
+}
+
+// expire moves the expiry of every stored archive into the past.
+func (f *fakeRepository) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, candidate := range f.exports {
+		candidate.ExpiresAt = pgtype.Timestamp{Time: time.Now().Add(-time.Second), Valid: true}
+	}
+}
+
+func (f *fakeRepository) finish(exportID pgtype.UUID, status db.DataExportStatus, apply func(*db.DataExport)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, candidate := range f.exports {
+		if candidate.ID == exportID && candidate.Status == db.DataExportStatusRunning {
+			candidate.Status = status
+			candidate.CompletedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+			apply(candidate)
+			return nil
+		}
+	}
+	return export.ErrNotFound
+}
+
+func (f *fakeRepository) GetUser(ctx context.Context, userID pgtype.UUID) (*db.User, error) {
+	if f.user.ID != userID {
+		return nil, errors.New("user not found")
+	}
+	user := f.user
+	return &user, nil
+}
+
+func (f *fakeRepository) GetAuth(ctx context.Context, authID pgtype.UUID) (*db.Auth, error) {
+	if f.auth.ID != authID {
+		return nil, errors.New("auth not found")
+	}
+	authRow := f.auth
+	return &authRow, nil
+}
+
+func (f *fakeRepository) ListIdentities(ctx context.Context, authID pgtype.UUID) ([]db.AuthIdentity, error) {
+	return f.identities, nil
+}
+
+func (f *fakeRepository) ListSessions(ctx context.Context, userID pgtype.UUID) ([]db.Session, error) {
+	return f.sessions, nil
+}
+
+func (f *fakeRepository) ListPasskeys(ctx context.Context, authID pgtype.UUID) ([]db.WebauthnCredential, error) {
+	return f.passkeys, nil
+}
+
+func (f *fakeRepository) GetTOTP(ctx context.Context, authID pgtype.UUID) (*db.AuthTotp, error) {
+	if f.totp == nil {
+		return nil, export.ErrNotFound
+	}
+	record := *f.totp
+	return &record, nil
+}
+
+func (f *fakeRepository) get(exportID pgtype.UUID) db.DataExport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, candidate := range f.exports {
+		if candidate.ID == exportID {
+			return *candidate
+		}
+	}
+	return db.DataExport{}
+}
+
+// fakeUploader is an in-memory bucket; getErr makes every read fail and
+// deleteErr every delete.
+type fakeUploader struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	types     map[string]string
+	getErr    error
+	deleteErr error
+}
+
+func newFakeUploader() *fakeUploader {
+	return &fakeUploader{objects: map[string][]byte{}, types: map[string]string{}}
+}
+
+func (f *fakeUploader) UploadFile(ctx context.Context, file io.Reader) (*string, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeUploader) UploadRenditions(
+	ctx context.Context, file io.Reader, renditions []imageprocess.Rendition) (*string, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeUploader) DeleteRenditions(
+	ctx context.Context, prefix string, renditions []imageprocess.Rendition) error {
+	if err := f.DeleteFile(ctx, prefix); err != nil {
+		return err
+	}
+	for _, rendition := range renditions {
+		if err := f.DeleteFile(ctx, fileupload.RenditionKey(prefix, rendition.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeUploader) PutFile(ctx context.Context, key string, file io.Reader, contentType string) error {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = data
+	f.types[key] = contentType
+	return nil
+}
+
+func (f *fakeUploader) GetFile(ctx context.Context, key string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	data, ok := f.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", fileupload.ErrFileNotFound, key)
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (f *fakeUploader) FileExists(ctx context.Context, key string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.objects[key]
+	return ok, nil
+}
+
+func (f *fakeUploader) GetSignedURL(ctx context.Context, key string, expires time.Duration) (*string, error) {
+	signed := "https://files.example/" + key + "?expires=" + expires.String()
+	return &signed, nil
+}
+
+func (f *fakeUploader) DeleteFile(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	delete(f.objects, key)
+	return nil
+}
+
+// fakeMailer serves every template as just the link placeholder, so the body
+// of a sent email is the download link.
+type fakeMailer struct {
+	mu         sync.Mutex
+	sendErr    error
+	sentEmails []mailer.SendParams
+}
+
+func (f *fakeMailer) GetTemplate(ctx context.Context, templateName string) (string, error) {
+	return "{{.Link}}", nil
+}
+
+func (f *fakeMailer) SendEmail(ctx context.Context, params mailer.SendParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sendErr != nil {
+		return f.sendErr
+	}
+	f.sentEmails = append(f.sentEmails, params)
+	return nil
+}
+
+func (f *fakeMailer) emails() []mailer.SendParams {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]mailer.SendParams(nil), f.sentEmails...)
+}
@@ -0,0 +1,124 @@
+package export_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/export"
+	"github.com/abdurrahimagca/qq-back/internal/middleware"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeExportUsecase struct {
+	export   *db.DataExport
+	err      error
+	lastUser *db.User
+}
+
+func (f *fakeExportUsecase) RequestExport(ctx context.Context, user *db.User) (*db.DataExport, error) {
+	f.lastUser = user
+	return f.export, f.err
+}
+
+func (f *fakeExportUsecase) GetLatestExport(ctx context.Context, user *db.User) (*db.DataExport, error) {
+	f.lastUser = user
+	return f.export, f.err
+}
+
+func (f *fakeExportUsecase) ProcessNext(ctx context.Context) (bool, error) {
+	return false, f.err
+}
+
+func (f *fakeExportUsecase) DeleteExpiredArchives(ctx context.Context) (int, error) {
+	return 0, f.err
+}
+
+func authenticatedContext(t *testing.T) (context.Context, *db.User) {
+	t.Helper()
+	user := &db.User{
+		ID:     newTestUUID(t, "11111111-1111-1111-1111-111111111111"),
+		AuthID: newTestUUID(t, "22222222-2222-2222-2222-222222222222"),
+	}
+	return middleware.WithUser(context.Background(), user), user
+}
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, status, statusErr.GetStatus())
+}
+
+func TestServer_RequestExportHandler(t *testing.T) {
+	ctx, user := authenticatedContext(t)
+
+	t.Run("Success", func(t *testing.T) {
+		createdAt := time.Now()
+		uc := &fakeExportUsecase{export: &db.DataExport{
+			ID:        newTestUUID(t, "33333333-3333-3333-3333-333333333333"),
+			Status:    db.DataExportStatusPending,
+			CreatedAt: pgtype.Timestamp{Time: createdAt, Valid: true},
+		}}
+
+		resp, err := export.NewServer(uc).RequestExportHandler(ctx, &export.RequestExportInput{})
+		require.NoError(t, err)
+		assert.Equal(t, "33333333-3333-3333-3333-333333333333", resp.Body.Data.ID)
+		assert.Equal(t, "pending", resp.Body.Data.Status)
+		assert.Equal(t, createdAt, resp.Body.Data.CreatedAt)
+		assert.Nil(t, resp.Body.Data.CompletedAt)
+		assert.Equal(t, user, uc.lastUser)
+	})
+
+	t.Run("InProgress", func(t *testing.T) {
+		uc := &fakeExportUsecase{err: export.ErrExportInProgress}
+		resp, err := export.NewServer(uc).RequestExportHandler(ctx, &export.RequestExportInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusConflict)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		resp, err := export.NewServer(&fakeExportUsecase{}).RequestExportHandler(
+			context.Background(), &export.RequestExportInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnauthorized)
+	})
+}
+
+func TestServer_GetExportHandler(t *testing.T) {
+	ctx, _ := authenticatedContext(t)
+
+	t.Run("Completed", func(t *testing.T) {
+		completedAt := time.Now()
+		uc := &fakeExportUsecase{export: &db.DataExport{
+			ID:          newTestUUID(t, "33333333-3333-3333-3333-333333333333"),
+			Status:      db.DataExportStatusCompleted,
+			CompletedAt: pgtype.Timestamp{Time: completedAt, Valid: true},
+		}}
+
+		resp, err := export.NewServer(uc).GetExportHandler(ctx, &export.GetExportInput{})
+		require.NoError(t, err)
+		assert.Equal(t, "completed", resp.Body.Data.Status)
+		require.NotNil(t, resp.Body.Data.CompletedAt)
+		assert.Equal(t, completedAt, *resp.Body.Data.CompletedAt)
+	})
+
+	t.Run("NoExport", func(t *testing.T) {
+		uc := &fakeExportUsecase{err: export.ErrNotFound}
+		resp, err := export.NewServer(uc).GetExportHandler(ctx, &export.GetExportInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusNotFound)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		resp, err := export.NewServer(&fakeExportUsecase{}).GetExportHandler(
+			context.Background(), &export.GetExportInput{})
+		require.Nil(t, resp)
+		requireStatus(t, err, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,317 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/export"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUUID(t *testing.T, value string) pgtype.UUID {
+	t.Helper()
+	var id pgtype.UUID
+	require.NoError(t, id.Scan(value))
+	return id
+}
+
+func newFakeRepository(t *testing.T) *fakeRepository {
+	t.Helper()
+	authID := newTestUUID(t, "22222222-2222-2222-2222-222222222222")
+	userID := newTestUUID(t, "11111111-1111-1111-1111-111111111111")
+	return &fakeRepository{
+		auth: db.Auth{ID: authID, Email: "user@example.com"},
+		user: db.User{
+			ID:        userID,
+			AuthID:    authID,
+			Username:  "user_1",
+			AvatarKey: pgtype.Text{String: "avatar-key", Valid: true},
+		},
+		identities: []db.AuthIdentity{{AuthID: authID, Provider: db.AuthProviderEmailOtp, Email: "user@example.com"}},
+		sessions: []db.Session{
+			{ID: newTestUUID(t, "33333333-3333-3333-3333-333333333333"), UserID: userID},
+			{ID: newTestUUID(t, "44444444-4444-4444-4444-444444444444"), UserID: userID},
+		},
+		passkeys: []db.WebauthnCredential{{AuthID: authID, Label: pgtype.Text{String: "Laptop", Valid: true}}},
+		totp: &db.AuthTotp{
+			AuthID:      authID,
+			Secret:      "TOTPSECRET",
+			CreatedAt:   pgtype.Timestamp{Time: time.Now().Add(-time.Hour), Valid: true},
+			ConfirmedAt: pgtype.Timestamp{Time: time.Now(), Valid: true},
+		},
+	}
+}
+
+func newExportUsecase(repo export.Repository, uploader *fakeUploader, mailer *fakeMailer) export.Usecase {
+	return export.NewUsecase(repo, uploader, mailer, environment.ExportEnvironment{
+		PollInterval: time.Second,
+		LinkTTL:      time.Hour,
+	})
+}
+
+func readArchive(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		files[file.Name] = content
+	}
+	return files
+}
+
+func TestUsecase_RequestExport(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepository(t)
+	uc := newExportUsecase(repo, newFakeUploader(), &fakeMailer{})
+
+	_, err := uc.GetLatestExport(ctx, &repo.user)
+	require.ErrorIs(t, err, export.ErrNotFound)
+
+	requested, err := uc.RequestExport(ctx, &repo.user)
+	require.NoError(t, err)
+	assert.Equal(t, db.DataExportStatusPending, requested.Status)
+
+	_, err = uc.RequestExport(ctx, &repo.user)
+	require.ErrorIs(t, err, export.ErrExportInProgress)
+
+	latest, err := uc.GetLatestExport(ctx, &repo.user)
+	require.NoError(t, err)
+	assert.Equal(t, requested.ID, latest.ID)
+}
+
+func TestUsecase_ProcessNext(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("NothingQueued", func(t *testing.T) {
+		uc := newExportUsecase(newFakeRepository(t), newFakeUploader(), &fakeMailer{})
+		processed, err := uc.ProcessNext(ctx)
+		require.NoError(t, err)
+		assert.False(t, processed)
+	})
+
+	t.Run("BuildsArchiveAndMailsLink", func(t *testing.T) {
+		repo := newFakeRepository(t)
+		uploader := newFakeUploader()
+		for _, name := range []string{"small", "medium", "large"} {
+			uploader.objects["avatar-key/"+name] = []byte(name + "-bytes")
+		}
+		mailer := &fakeMailer{}
+		uc := newExportUsecase(repo, uploader, mailer)
+
+		requested, err := uc.RequestExport(ctx, &repo.user)
+		require.NoError(t, err)
+
+		processed, err := uc.ProcessNext(ctx)
+		require.NoError(t, err)
+		assert.True(t, processed)
+
+		stored := repo.get(requested.ID)
+		assert.Equal(t, db.DataExportStatusCompleted, stored.Status)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), stored.ExpiresAt.Time, time.Minute,
+			"the archive expires with the link")
+		archiveKey := "exports/" + requested.ID.String() + ".zip"
+		assert.Equal(t, archiveKey, stored.ArchiveKey.String)
+		assert.Equal(t, "application/zip", uploader.types[archiveKey])
+
+		files := readArchive(t, uploader.objects[archiveKey])
+		assert.Len(t, files, 9)
+		for _, name := range []string{"small", "medium", "large"} {
+			assert.Equal(t, []byte(name+"-bytes"), files["avatar/"+name])
+		}
+
+		var account db.Auth
+		require.NoError(t, json.Unmarshal(files["account.json"], &account))
+		assert.Equal(t, "user@example.com", account.Email)
+		var profile db.User
+		require.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+		assert.Equal(t, "user_1", profile.Username)
+		var sessions []db.Session
+		require.NoError(t, json.Unmarshal(files["sessions.json"], &sessions))
+		assert.Len(t, sessions, 2)
+		assert.Contains(t, files, "identities.json")
+		var passkeys []db.WebauthnCredential
+		require.NoError(t, json.Unmarshal(files["passkeys.json"], &passkeys))
+		require.Len(t, passkeys, 1)
+		assert.Equal(t, "Laptop", passkeys[0].Label.String)
+		var totp map[string]any
+		require.NoError(t, json.Unmarshal(files["totp.json"], &totp))
+		assert.Equal(t, true, totp["enabled"])
+		assert.Contains(t, totp, "enrolledAt")
+		assert.Contains(t, totp, "confirmedAt")
+		assert.NotContains(t, string(files["totp.json"]), "TOTPSECRET", "the TOTP secret is never exported")
+
+		emails := mailer.emails()
+		require.Len(t, emails, 1)
+		assert.Equal(t, "user@example.com", emails[0].To)
+		assert.Equal(t, "https://files.example/"+archiveKey+"?expires=1h0m0s", emails[0].Body)
+
+		processed, err = uc.ProcessNext(ctx)
+		require.NoError(t, err)
+		assert.False(t, processed)
+
+		_, err = uc.RequestExport(ctx, &repo.user)
+		require.NoError(t, err, "a new export can be requested once the previous one completed")
+	})
+
+	t.Run("WithoutAvatar", func(t *testing.T) {
+		repo := newFakeRepository(t)
+		repo.user.AvatarKey = pgtype.Text{}
+		uploader := newFakeUploader()
+		uc := newExportUsecase(repo, uploader, &fakeMailer{})
+
+		requested, err := uc.RequestExport(ctx, &repo.user)
+		require.NoError(t, err)
+		_, err = uc.ProcessNext(ctx)
+		require.NoError(t, err)
+
+		files := readArchive(t, uploader.objects["exports/"+requested.ID.String()+".zip"])
+		assert.Len(t, files, 6)
+	})
+
+	t.Run("WithoutTOTP", func(t *testing.T) {
+		repo := newFakeRepository(t)
+		repo.totp = nil
+		uploader := newFakeUploader()
+		uc := newExportUsecase(repo, uploader, &fakeMailer{})
+
+		requested, err := uc.RequestExport(ctx, &repo.user)
+		require.NoError(t, err)
+		_, err = uc.ProcessNext(ctx)
+		require.NoError(t, err)
+
+		files := readArchive(t, uploader.objects["exports/"+requested.ID.String()+".zip"])
+		assert.JSONEq(t, `{"enabled": false}`, string(files["totp.json"]))
+	})
+
+	t.Run("MissingAvatarObjectsAreLeftOut", func(t *testing.T) {
+		repo := newFakeRepository(t)
+		uploader := newFakeUploader()
+		uploader.objects["avatar-key/small"] = []byte("small-bytes")
+		uc := newExportUsecase(repo, uploader, &fakeMailer{})
+
+		requested, err := uc.RequestExport(ctx, &repo.user)
+		require.NoError(t, err)
+		_, err = uc.ProcessNext(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, db.DataExportStatusCompleted, repo.get(requested.ID).Status)
+
+		files := readArchive(t, uploader.objects["exports/"+requested.ID.String()+".zip"])
+		assert.Len(t, files, 7)
+		assert.Equal(t, []byte("small-bytes"), files["avatar/small"])
+	})
+
+	t.Run("SingleObjectAvatar", func(t *testing.T) {
+		repo := newFakeRepository(t)
+		uploader := newFakeUploader()
+		uploader.objects["avatar-key"] = []byte("image-bytes")
+		uc := newExportUsecase(repo, uploader, &fakeMailer{})
+
+		requested, err := uc.RequestExport(ctx, &repo.user)
+		require.NoError(t, err)
+		_, err = uc.ProcessNext(ctx)
+		require.NoError(t, err)
+
+		files := readArchive(t, uploader.objects["exports/"+requested.ID.String()+".zip"])
+		assert.Len(t, files, 7)
+		assert.Equal(t, []byte("image-bytes"), files["avatar/image"], "avatars stored before renditions are exported")
+	})
+
+	t.Run("FailureMarksExportFailed", func(t *testing.T) {
+		repo := newFakeRepository(t)
+		uploader := newFakeUploader()
+		uploader.getErr = errors.New("bucket unavailable")
+		mailer := &fakeMailer{}
+		uc := newExportUsecase(repo, uploader, mailer)
+
+		requested, err := uc.RequestExport(ctx, &repo.user)
+		require.NoError(t, err)
+
+		processed, err := uc.ProcessNext(ctx)
+		require.Error(t, err)
+		assert.True(t, processed)
+		assert.Equal(t, db.DataExportStatusFailed, repo.get(requested.ID).Status)
+		assert.False(t, repo.get(requested.ID).ArchiveKey.Valid, "no archive was stored")
+		assert.Empty(t, mailer.emails())
+
+		_, err = uc.RequestExport(ctx, &repo.user)
+		require.NoError(t, err, "a new export can be requested once the previous one failed")
+	})
+
+	t.Run("MailFailureMarksExportFailed", func(t *testing.T) {
+		repo := newFakeRepository(t)
+		repo.user.AvatarKey = pgtype.Text{}
+		uploader := newFakeUploader()
+		uc := newExportUsecase(repo, uploader, &fakeMailer{sendErr: errors.New("smtp down")})
+
+		requested, err := uc.RequestExport(ctx, &repo.user)
+		require.NoError(t, err)
+
+		_, err = uc.ProcessNext(ctx)
+		require.Error(t, err)
+		stored := repo.get(requested.ID)
+		assert.Equal(t, db.DataExportStatusFailed, stored.Status)
+		archiveKey := "exports/" + requested.ID.String() + ".zip"
+		assert.Equal(t, archiveKey, stored.ArchiveKey.String, "the stored archive is recorded for the sweep")
+
+		deleted, err := uc.DeleteExpiredArchives(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		assert.NotContains(t, uploader.objects, archiveKey, "the archive of a failed export is deleted")
+		assert.Equal(t, db.DataExportStatusFailed, repo.get(requested.ID).Status)
+	})
+}
+
+func TestUsecase_DeleteExpiredArchives(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepository(t)
+	repo.user.AvatarKey = pgtype.Text{}
+	uploader := newFakeUploader()
+	uc := newExportUsecase(repo, uploader, &fakeMailer{})
+
+	requested, err := uc.RequestExport(ctx, &repo.user)
+	require.NoError(t, err)
+	_, err = uc.ProcessNext(ctx)
+	require.NoError(t, err)
+	archiveKey := "exports/" + requested.ID.String() + ".zip"
+
+	deleted, err := uc.DeleteExpiredArchives(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+	assert.Contains(t, uploader.objects, archiveKey, "the archive is kept while the link is valid")
+
+	repo.expire()
+	uploader.deleteErr = errors.New("bucket unavailable")
+	deleted, err = uc.DeleteExpiredArchives(ctx)
+	require.Error(t, err)
+	assert.Zero(t, deleted)
+	assert.True(t, repo.get(requested.ID).ArchiveKey.Valid, "a failed delete is retried on the next run")
+
+	uploader.deleteErr = nil
+	deleted, err = uc.DeleteExpiredArchives(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.NotContains(t, uploader.objects, archiveKey, "the expired archive is deleted")
+	stored := repo.get(requested.ID)
+	assert.False(t, stored.ArchiveKey.Valid)
+	assert.Equal(t, db.DataExportStatusCompleted, stored.Status)
+
+	deleted, err = uc.DeleteExpiredArchives(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
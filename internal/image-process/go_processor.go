@@ -0,0 +1,60 @@
+package imageprocess
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+// GoProcessor needs nothing but the standard library, for builds without cgo
+// where WebP cannot be encoded. It applies the same limits as WebpProcessor but
+// writes JPEG, or PNG when the image has transparency or output is lossless.
+type GoProcessor struct {
+	config
+}
+
+// NewGoProcessor returns a processor with the defaults changed by options.
+// The quality is used for JPEG; the WebP method is ignored.
+func NewGoProcessor(options ...Option) *GoProcessor {
+	return &GoProcessor{config: newConfig(options)}
+}
+
+func (p *GoProcessor) ImageProcessor(ctx context.Context, file io.Reader) (*ProcessedImage, error) {
+	_ = ctx
+	return p.process(file, p.encode)
+}
+
+func (p *GoProcessor) RenditionProcessor(
+	ctx context.Context, file io.Reader, renditions []Rendition) (map[string]*ProcessedImage, error) {
+	_ = ctx
+	return p.processRenditions(file, renditions, p.encode)
+}
+
+func (p *GoProcessor) encode(img image.Image) (*ProcessedImage, error) {
+	var buf bytes.Buffer
+	if p.lossless || !opaque(img) {
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		return &ProcessedImage{Data: buf.Bytes(), MimeType: "image/png"}, nil
+	}
+
+	quality := min(max(int(math.Round(float64(p.quality))), 1), 100)
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return &ProcessedImage{Data: buf.Bytes(), MimeType: "image/jpeg"}, nil
+}
+
+// opaque reports whether img has no transparent pixels. Images that cannot
+// tell are treated as transparent so PNG keeps whatever alpha they have.
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package imageprocess
+
+import "github.com/nfnt/resize"
+
+// Resampling names the filter images are scaled down with.
+type Resampling string
+
+const (
+	// ResamplingNearest is the fastest and the blockiest.
+	ResamplingNearest    Resampling = "nearest"
+	ResamplingBilinear   Resampling = "bilinear"
+	ResamplingCatmullRom Resampling = "catmullrom"
+	ResamplingMitchell   Resampling = "mitchell"
+	// ResamplingLanczos is the sharpest and the slowest.
+	ResamplingLanczos Resampling = "lanczos"
+)
+
+func (r Resampling) interpolation() resize.InterpolationFunction {
+	switch r {
+	case ResamplingNearest:
+		return resize.NearestNeighbor
+	case ResamplingBilinear:
+		return resize.Bilinear
+	case ResamplingMitchell:
+		return resize.MitchellNetravali
+	case ResamplingLanczos:
+		return resize.Lanczos3
+	default:
+		// resize's bicubic kernel is Catmull-Rom.
+		return resize.Bicubic
+	}
+}
+
+const (
+	defaultMaxWidth   = 2048
+	defaultMaxHeight  = 1080
+	defaultMaxPixels  = 400000
+	defaultQuality    = 45
+	defaultMethod     = 0
+	defaultResampling = ResamplingCatmullRom
+)
+
+type config struct {
+	maxWidth   uint
+	maxHeight  uint
+	maxPixels  uint
+	quality    float32
+	lossless   bool
+	method     int
+	resampling Resampling
+}
+
+// Option changes one setting of a WebpProcessor or GoProcessor.
+type Option func(*config)
+
+// WithMaxDimensions bounds the images ImageProcessor returns; larger ones are
+// scaled down to fit, keeping their aspect ratio.
+func WithMaxDimensions(width, height uint) Option {
+	return func(c *config) {
+		c.maxWidth = width
+		c.maxHeight = height
+	}
+}
+
+// WithMaxPixels caps the area of an image ImageProcessor has to scale down.
+// Zero turns the cap off.
+func WithMaxPixels(pixels uint) Option {
+	return func(c *config) {
+		c.maxPixels = pixels
+	}
+}
+
+// WithQuality sets the lossy quality, from 0 to 100. In lossless WebP it sets
+// how hard the encoder works to make the file smaller instead.
+func WithQuality(quality float32) Option {
+	return func(c *config) {
+		c.quality = quality
+	}
+}
+
+// WithLossless encodes images losslessly; GoProcessor then always writes PNG.
+func WithLossless(lossless bool) Option {
+	return func(c *config) {
+		c.lossless = lossless
+	}
+}
+
+// WithMethod trades WebP encoding speed for size, from 0 (fastest) to 6.
+func WithMethod(method int) Option {
+	return func(c *config) {
+		c.method = method
+	}
+}
+
+// WithResampling sets the filter images are scaled down with. Unknown names
+// fall back to ResamplingCatmullRom.
+func WithResampling(resampling Resampling) Option {
+	return func(c *config) {
+		c.resampling = resampling
+	}
+}
+
+func newConfig(options []Option) config {
+	c := config{
+		maxWidth:   defaultMaxWidth,
+		maxHeight:  defaultMaxHeight,
+		maxPixels:  defaultMaxPixels,
+		quality:    defaultQuality,
+		method:     defaultMethod,
+		resampling: defaultResampling,
+	}
+	for _, option := range options {
+		option(&c)
+	}
+	return c
+}
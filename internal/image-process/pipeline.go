@@ -0,0 +1,76 @@
+package imageprocess
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// encodeFunc writes an image in a processor's output format.
+type encodeFunc func(img image.Image) (*ProcessedImage, error)
+
+// process decodes file, scales it down to the configured bounds and encodes
+// it. Every processor shares it, so they all apply the same limits.
+func (c config) process(file io.Reader, encode encodeFunc) (*ProcessedImage, error) {
+	img, err := decodeImage(file)
+	if err != nil {
+		return nil, err
+	}
+
+	processed := img
+	imgBounds := img.Bounds()
+	width := uint(imgBounds.Dx())
+	height := uint(imgBounds.Dy())
+
+	if width > 0 && height > 0 && (width > c.maxWidth || height > c.maxHeight) {
+		scale := math.Min(float64(c.maxWidth)/float64(width), float64(c.maxHeight)/float64(height))
+		if scale > 1 {
+			scale = 1
+		}
+		newWidth := uint(math.Max(1, math.Round(float64(width)*scale)))
+		newHeight := uint(math.Max(1, math.Round(float64(height)*scale)))
+
+		if c.maxPixels > 0 {
+			targetPixels := newWidth * newHeight
+			if targetPixels > c.maxPixels {
+				pixelScale := math.Sqrt(float64(c.maxPixels) / float64(targetPixels))
+				newWidth = uint(math.Max(1, math.Round(float64(newWidth)*pixelScale)))
+				newHeight = uint(math.Max(1, math.Round(float64(newHeight)*pixelScale)))
+			}
+		}
+		processed = resize.Resize(newWidth, newHeight, img, c.resampling.interpolation())
+	}
+
+	return encode(processed)
+}
+
+// processRenditions decodes file once, crops the largest centred square and
+// encodes it at each rendition's size. The pixel budget of process does not
+// apply; renditions are as large as they are configured.
+func (c config) processRenditions(
+	file io.Reader, renditions []Rendition, encode encodeFunc) (map[string]*ProcessedImage, error) {
+	if err := validateRenditions(renditions); err != nil {
+		return nil, err
+	}
+	img, err := decodeImage(file)
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("%w: image has no pixels", ErrInvalidImage)
+	}
+
+	square := squareCrop(img)
+	images := make(map[string]*ProcessedImage, len(renditions))
+	for _, rendition := range renditions {
+		encoded, err := encode(fitSquare(square, rendition.Size, c.resampling.interpolation()))
+		if err != nil {
+			return nil, err
+		}
+		images[rendition.Name] = encoded
+	}
+	return images, nil
+}
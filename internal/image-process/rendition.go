@@ -0,0 +1,60 @@
+package imageprocess
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+
+	"github.com/nfnt/resize"
+)
+
+// Rendition is a square crop of an image, Size pixels a side, named so it can
+// be stored and asked for by Name.
+type Rendition struct {
+	Name string
+	Size uint
+}
+
+// AvatarRenditions are the sizes every avatar is stored in, smallest first.
+var AvatarRenditions = []Rendition{
+	{Name: "small", Size: 64},
+	{Name: "medium", Size: 256},
+	{Name: "large", Size: 1024},
+}
+
+var ErrInvalidRenditions = errors.New("renditions need distinct, non-empty names and positive sizes")
+
+func validateRenditions(renditions []Rendition) error {
+	if len(renditions) == 0 {
+		return ErrInvalidRenditions
+	}
+	seen := make(map[string]bool, len(renditions))
+	for _, rendition := range renditions {
+		if rendition.Name == "" || rendition.Size == 0 || seen[rendition.Name] {
+			return ErrInvalidRenditions
+		}
+		seen[rendition.Name] = true
+	}
+	return nil
+}
+
+// squareCrop copies the largest centred square of img into a new image, so
+// every rendition is cut from the same area.
+func squareCrop(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	origin := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, origin, draw.Src)
+	return square
+}
+
+// fitSquare scales a square image down to size pixels a side with filter.
+// Smaller images are returned as they are rather than scaled up.
+func fitSquare(square image.Image, size uint, filter resize.InterpolationFunction) image.Image {
+	if uint(square.Bounds().Dx()) <= size {
+		return square
+	}
+	return resize.Resize(size, size, square, filter)
+}
@@ -2,9 +2,14 @@ package imageprocess
 
 import (
 	"context"
+	"errors"
 	"io"
 )
 
+// ErrInvalidImage is wrapped by processors when the input cannot be decoded as
+// a supported image, as opposed to failing to encode it.
+var ErrInvalidImage = errors.New("not a supported image")
+
 type ProcessedImage struct {
 	Data     []byte
 	MimeType string
@@ -12,4 +17,7 @@ type ProcessedImage struct {
 
 type Processor interface {
 	ImageProcessor(ctx context.Context, file io.Reader) (*ProcessedImage, error)
+	// RenditionProcessor decodes file once and returns one image per
+	// rendition, keyed by rendition name.
+	RenditionProcessor(ctx context.Context, file io.Reader, renditions []Rendition) (map[string]*ProcessedImage, error)
 }
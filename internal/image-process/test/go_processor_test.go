@@ -0,0 +1,134 @@
+package imageprocess_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoProcessor_ImageProcessor_Formats(t *testing.T) {
+	ctx := context.Background()
+
+	result, err := imageprocess.NewGoProcessor().ImageProcessor(ctx, encodePNG(t, createTestImage(100, 100)))
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", result.MimeType, "opaque images are JPEG")
+	_, format, err := image.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+
+	transparent := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	transparent.SetNRGBA(2, 3, color.NRGBA{R: 255, A: 128})
+	result, err = imageprocess.NewGoProcessor().ImageProcessor(ctx, encodePNG(t, transparent))
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", result.MimeType, "transparency needs PNG")
+	decoded, err := png.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	_, _, _, alpha := decoded.At(2, 3).RGBA()
+	assert.Equal(t, uint32(128), alpha>>8)
+
+	source := createTestImage(64, 48)
+	result, err = imageprocess.NewGoProcessor(imageprocess.WithLossless(true)).ImageProcessor(ctx, encodePNG(t, source))
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", result.MimeType, "lossless is PNG")
+	decoded, err = png.Decode(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Equal(t, source.At(40, 30), color.RGBAModel.Convert(decoded.At(40, 30)))
+
+	_, err = imageprocess.NewGoProcessor().ImageProcessor(ctx, bytes.NewBufferString("not an image"))
+	assert.ErrorIs(t, err, imageprocess.ErrInvalidImage)
+}
+
+func TestGoProcessor_ImageProcessor_Limits(t *testing.T) {
+	// The same sizes WebpProcessor is pinned to.
+	tests := []struct {
+		name       string
+		options    []imageprocess.Option
+		inputW     int
+		inputH     int
+		wantWidth  int
+		wantHeight int
+	}{
+		{
+			name:   "defaults fit 2048x1080 then the 400k pixel budget",
+			inputW: 4000, inputH: 3000,
+			wantWidth: 730, wantHeight: 548,
+		},
+		{
+			name:    "max dimensions without a pixel budget",
+			options: []imageprocess.Option{imageprocess.WithMaxDimensions(800, 800), imageprocess.WithMaxPixels(0)},
+			inputW:  4000, inputH: 3000,
+			wantWidth: 800, wantHeight: 600,
+		},
+		{
+			name:    "images within the bounds keep their size",
+			options: []imageprocess.Option{imageprocess.WithMaxDimensions(800, 800)},
+			inputW:  640, inputH: 480,
+			wantWidth: 640, wantHeight: 480,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := imageprocess.NewGoProcessor(tt.options...)
+			result, err := processor.ImageProcessor(context.Background(), encodePNG(t, createTestImage(tt.inputW, tt.inputH)))
+			require.NoError(t, err)
+
+			config, _, err := image.DecodeConfig(bytes.NewReader(result.Data))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantWidth, config.Width)
+			assert.Equal(t, tt.wantHeight, config.Height)
+		})
+	}
+}
+
+func TestGoProcessor_RenditionProcessor(t *testing.T) {
+	processor := imageprocess.NewGoProcessor()
+	images, err := processor.RenditionProcessor(context.Background(), encodePNG(t, createTestImage(300, 200)),
+		[]imageprocess.Rendition{{Name: "small", Size: 64}, {Name: "large", Size: 1024}})
+	require.NoError(t, err)
+
+	for name, side := range map[string]int{"small": 64, "large": 200} {
+		require.Contains(t, images, name)
+		assert.Equal(t, "image/jpeg", images[name].MimeType)
+		config, _, err := image.DecodeConfig(bytes.NewReader(images[name].Data))
+		require.NoError(t, err)
+		assert.Equal(t, side, config.Width, "%s is a square crop", name)
+		assert.Equal(t, side, config.Height, "%s is a square crop", name)
+	}
+
+	_, err = processor.RenditionProcessor(context.Background(), encodePNG(t, createTestImage(10, 10)), nil)
+	assert.ErrorIs(t, err, imageprocess.ErrInvalidRenditions)
+}
+
+func TestGoProcessor_Orientation(t *testing.T) {
+	processor := imageprocess.NewGoProcessor(imageprocess.WithQuality(95))
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		t.Run(fmt.Sprintf("orientation %d", orientation), func(t *testing.T) {
+			result, err := processor.ImageProcessor(context.Background(), readOrientationFixture(t, orientation))
+			require.NoError(t, err)
+			assert.NotContains(t, string(result.Data), "Exif", "metadata is not kept")
+			assert.NotContains(t, string(result.Data), "QQTest", "metadata is not kept")
+
+			img, _, err := image.Decode(bytes.NewReader(result.Data))
+			require.NoError(t, err)
+			require.Equal(t, image.Pt(64, 32), img.Bounds().Size())
+			assertQuadrants(t, img)
+
+			images, err := processor.RenditionProcessor(context.Background(), readOrientationFixture(t, orientation),
+				[]imageprocess.Rendition{{Name: "small", Size: 32}})
+			require.NoError(t, err)
+			img, _, err = image.Decode(bytes.NewReader(images["small"].Data))
+			require.NoError(t, err)
+			assertQuadrants(t, img)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package imageprocess_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/orientation_<n>.jpg hold the same 64x32 picture stored for EXIF
+// orientation n: red, green, blue and yellow quadrants from the top left when
+// displayed upright. Each also has a camera make ("QQTest") and a GPS position,
+// with odd orientations in little-endian EXIF and even ones in big-endian.
+
+func encodePNG(t *testing.T, img image.Image) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, img))
+	return buf
+}
+
+func readOrientationFixture(t *testing.T, orientation int) *bytes.Reader {
+	t.Helper()
+	data, err := os.ReadFile(fmt.Sprintf("testdata/orientation_%d.jpg", orientation))
+	require.NoError(t, err)
+	return bytes.NewReader(data)
+}
+
+// assertQuadrants checks the centre of each quadrant of img against the
+// upright fixture picture.
+func assertQuadrants(t *testing.T, img image.Image) {
+	t.Helper()
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	quadrants := []struct {
+		name string
+		at   image.Point
+		want color.RGBA
+	}{
+		{"top left", image.Pt(w/4, h/4), color.RGBA{R: 255, A: 255}},
+		{"top right", image.Pt(3*w/4, h/4), color.RGBA{G: 255, A: 255}},
+		{"bottom left", image.Pt(w/4, 3*h/4), color.RGBA{B: 255, A: 255}},
+		{"bottom right", image.Pt(3*w/4, 3*h/4), color.RGBA{R: 255, G: 255, A: 255}},
+	}
+	for _, quadrant := range quadrants {
+		r, g, b, _ := img.At(bounds.Min.X+quadrant.at.X, bounds.Min.Y+quadrant.at.Y).RGBA()
+		got := []int{int(r >> 8), int(g >> 8), int(b >> 8)}
+		want := []int{int(quadrant.want.R), int(quadrant.want.G), int(quadrant.want.B)}
+		for i := range want {
+			assert.InDelta(t, want[i], got[i], 48, "%s is %v, want %v", quadrant.name, got, want)
+		}
+	}
+}
+
+// Helper function to create test images.
+func createTestImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// Fill with a gradient pattern to make it more realistic.
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{
+				R: uint8((x * 255) / width),
+				G: uint8((y * 255) / height),
+				B: uint8(((x + y) * 255) / (width + height)),
+				A: 255,
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	return img
+}
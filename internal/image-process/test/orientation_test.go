@@ -0,0 +1,81 @@
+//go:build cgo
+
+package imageprocess_test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"testing"
+
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebpProcessor_ImageProcessor_Orientation(t *testing.T) {
+	processor := imageprocess.NewWebpProcessor(imageprocess.WithLossless(true))
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		t.Run(fmt.Sprintf("orientation %d", orientation), func(t *testing.T) {
+			result, err := processor.ImageProcessor(context.Background(), readOrientationFixture(t, orientation))
+			require.NoError(t, err)
+
+			img := decodeWebp(t, result.Data)
+			require.Equal(t, image.Pt(64, 32), img.Bounds().Size(), "turned upright before any resizing")
+			assertQuadrants(t, img)
+		})
+	}
+}
+
+func TestWebpProcessor_RenditionProcessor_Orientation(t *testing.T) {
+	processor := imageprocess.NewWebpProcessor(imageprocess.WithLossless(true))
+	renditions := []imageprocess.Rendition{{Name: "small", Size: 32}}
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		t.Run(fmt.Sprintf("orientation %d", orientation), func(t *testing.T) {
+			images, err := processor.RenditionProcessor(
+				context.Background(), readOrientationFixture(t, orientation), renditions)
+			require.NoError(t, err)
+
+			// The centred square of the upright picture keeps all four quadrants.
+			img := decodeWebp(t, images["small"].Data)
+			require.Equal(t, image.Pt(32, 32), img.Bounds().Size())
+			assertQuadrants(t, img)
+		})
+	}
+}
+
+func TestWebpProcessor_StripsMetadata(t *testing.T) {
+	processor := imageprocess.NewWebpProcessor()
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		result, err := processor.ImageProcessor(context.Background(), readOrientationFixture(t, orientation))
+		require.NoError(t, err)
+		assertNoMetadata(t, result.Data)
+
+		images, err := processor.RenditionProcessor(context.Background(), readOrientationFixture(t, orientation),
+			imageprocess.AvatarRenditions)
+		require.NoError(t, err)
+		for _, rendition := range images {
+			assertNoMetadata(t, rendition.Data)
+		}
+	}
+}
+
+// assertNoMetadata checks that a WebP file has only image chunks and none of
+// the fixture's EXIF values.
+func assertNoMetadata(t *testing.T, data []byte) {
+	t.Helper()
+	assert.NotContains(t, string(data), "Exif")
+	assert.NotContains(t, string(data), "QQTest")
+
+	require.True(t, len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP", "not a WebP file")
+	for offset := 12; offset+8 <= len(data); {
+		chunk := string(data[offset : offset+4])
+		assert.Contains(t, []string{"VP8 ", "VP8L", "VP8X", "ALPH"}, chunk, "unexpected %q chunk", chunk)
+		size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		offset += 8 + size + size%2
+	}
+}
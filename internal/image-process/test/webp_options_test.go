@@ -0,0 +1,196 @@
+//go:build cgo
+
+package imageprocess_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	"github.com/kolesa-team/go-webp/decoder"
+	"github.com/kolesa-team/go-webp/webp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The golden images below are computed rather than stored: each test knows
+// what an ideal result looks like and pins how close the WebP output gets.
+
+func TestWebpProcessor_Options_OutputSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    []imageprocess.Option
+		inputW     int
+		inputH     int
+		wantWidth  int
+		wantHeight int
+	}{
+		{
+			name:   "defaults fit 2048x1080 then the 400k pixel budget",
+			inputW: 4000, inputH: 3000,
+			wantWidth: 730, wantHeight: 548,
+		},
+		{
+			name:    "max dimensions without a pixel budget",
+			options: []imageprocess.Option{imageprocess.WithMaxDimensions(800, 800), imageprocess.WithMaxPixels(0)},
+			inputW:  4000, inputH: 3000,
+			wantWidth: 800, wantHeight: 600,
+		},
+		{
+			name: "pixel budget after max dimensions",
+			options: []imageprocess.Option{
+				imageprocess.WithMaxDimensions(1000, 1000), imageprocess.WithMaxPixels(10000)},
+			inputW: 2000, inputH: 1000,
+			wantWidth: 141, wantHeight: 71,
+		},
+		{
+			name:    "images within the bounds keep their size",
+			options: []imageprocess.Option{imageprocess.WithMaxDimensions(800, 800)},
+			inputW:  640, inputH: 480,
+			wantWidth: 640, wantHeight: 480,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := imageprocess.NewWebpProcessor(tt.options...)
+			result, err := processor.ImageProcessor(context.Background(), encodePNG(t, createTestImage(tt.inputW, tt.inputH)))
+			require.NoError(t, err)
+
+			config, err := webp.DecodeConfig(bytes.NewReader(result.Data), &decoder.Options{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantWidth, config.Width)
+			assert.Equal(t, tt.wantHeight, config.Height)
+		})
+	}
+}
+
+func TestWebpProcessor_Options_Quality(t *testing.T) {
+	golden := createDetailedImage(256, 256)
+
+	encode := func(options ...imageprocess.Option) ([]byte, float64) {
+		result, err := imageprocess.NewWebpProcessor(options...).ImageProcessor(context.Background(), encodePNG(t, golden))
+		require.NoError(t, err)
+		return result.Data, psnr(t, golden, decodeWebp(t, result.Data))
+	}
+
+	low, lowPSNR := encode(imageprocess.WithQuality(20))
+	high, highPSNR := encode(imageprocess.WithQuality(90))
+	_, losslessPSNR := encode(imageprocess.WithLossless(true))
+
+	assert.Greater(t, len(high), len(low), "higher quality spends more bytes")
+	assert.Greater(t, highPSNR, lowPSNR, "higher quality is closer to the source")
+	assert.GreaterOrEqual(t, highPSNR, 30.0, "quality 90 PSNR")
+	assert.True(t, math.IsInf(losslessPSNR, 1), "lossless output matches the source exactly, got %.1f dB", losslessPSNR)
+}
+
+func TestWebpProcessor_Options_Resampling(t *testing.T) {
+	// Stripes three pixels apart are too fine to survive scaling down by four,
+	// so the ideal result is flat mid grey. Encoding losslessly leaves only the
+	// filter to tell the outputs apart.
+	stripes := createStripedImage(512, 512, 3)
+	golden := image.NewUniform(color.Gray{Y: 128})
+
+	scaled := func(resampling imageprocess.Resampling) float64 {
+		processor := imageprocess.NewWebpProcessor(
+			imageprocess.WithMaxDimensions(128, 128),
+			imageprocess.WithLossless(true),
+			imageprocess.WithResampling(resampling),
+		)
+		result, err := processor.ImageProcessor(context.Background(), encodePNG(t, stripes))
+		require.NoError(t, err)
+		return psnr(t, golden, decodeWebp(t, result.Data))
+	}
+
+	assert.Less(t, scaled(imageprocess.ResamplingNearest), 25.0, "nearest aliases the stripes")
+	for _, resampling := range []imageprocess.Resampling{
+		imageprocess.ResamplingCatmullRom,
+		imageprocess.ResamplingMitchell,
+		imageprocess.ResamplingLanczos,
+	} {
+		assert.GreaterOrEqual(t, scaled(resampling), 35.0, "%s filters the stripes out", resampling)
+	}
+
+	// Renditions are scaled with the same filter.
+	rendition := func(resampling imageprocess.Resampling) float64 {
+		processor := imageprocess.NewWebpProcessor(
+			imageprocess.WithLossless(true), imageprocess.WithResampling(resampling))
+		images, err := processor.RenditionProcessor(context.Background(), encodePNG(t, stripes),
+			[]imageprocess.Rendition{{Name: "small", Size: 64}})
+		require.NoError(t, err)
+		return psnr(t, golden, decodeWebp(t, images["small"].Data))
+	}
+	assert.Less(t, rendition(imageprocess.ResamplingNearest), 30.0)
+	assert.GreaterOrEqual(t, rendition(imageprocess.ResamplingLanczos), 35.0)
+}
+
+func decodeWebp(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := webp.Decode(bytes.NewReader(data), &decoder.Options{})
+	require.NoError(t, err)
+	return img
+}
+
+// psnr compares the RGB channels of got with want over got's bounds. Identical
+// images are +Inf.
+func psnr(t *testing.T, want, got image.Image) float64 {
+	t.Helper()
+	bounds := got.Bounds()
+	if _, uniform := want.(*image.Uniform); !uniform {
+		require.Equal(t, want.Bounds().Size(), bounds.Size())
+	}
+
+	var sum float64
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			wr, wg, wb, _ := want.At(want.Bounds().Min.X+x, want.Bounds().Min.Y+y).RGBA()
+			gr, gg, gb, _ := got.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			for _, d := range []float64{
+				float64(wr>>8) - float64(gr>>8),
+				float64(wg>>8) - float64(gg>>8),
+				float64(wb>>8) - float64(gb>>8),
+			} {
+				sum += d * d
+			}
+		}
+	}
+	mse := sum / float64(3*bounds.Dx()*bounds.Dy())
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+// createDetailedImage is a gradient with fine texture on top, so lossy
+// encoding at a low quality visibly loses detail.
+func createDetailedImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			texture := (x*7 + y*13) % 32
+			img.Set(x, y, color.RGBA{
+				R: uint8((x*191)/width + texture),
+				G: uint8((y*191)/height + texture),
+				B: uint8(((x+y)*191)/(width+height) + 31 - texture),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// createStripedImage draws vertical cosine stripes period pixels apart.
+func createStripedImage(width, height int, period float64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := 127.5 + 127.5*math.Cos(2*math.Pi*float64(x)/period)
+			img.SetGray(x, y, color.Gray{Y: uint8(math.Round(value))})
+		}
+	}
+	return img
+}
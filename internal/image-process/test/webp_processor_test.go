@@ -5,8 +5,6 @@ package imageprocess_test
 import (
 	"bytes"
 	"context"
-	"image"
-	"image/color"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
@@ -14,6 +12,8 @@ import (
 	"time"
 
 	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	"github.com/kolesa-team/go-webp/decoder"
+	"github.com/kolesa-team/go-webp/webp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -226,7 +226,7 @@ func TestWebpProcessor_ImageProcessor_ErrorHandling(t *testing.T) {
 
 			result, err := processor.ImageProcessor(ctx, input)
 
-			assert.Error(t, err, "Should return error for invalid input")
+			assert.ErrorIs(t, err, imageprocess.ErrInvalidImage, "Should return error for invalid input")
 			assert.Nil(t, result, "Should not return result on error")
 		})
 	}
@@ -250,6 +250,43 @@ func TestWebpProcessor_Constructor(t *testing.T) {
 	assert.NotEmpty(t, result.Data)
 }
 
+func TestWebpProcessor_RenditionProcessor(t *testing.T) {
+	processor := imageprocess.NewWebpProcessor()
+	ctx := context.Background()
+	renditions := []imageprocess.Rendition{{Name: "small", Size: 64}, {Name: "large", Size: 1024}}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, createTestImage(300, 200)))
+	images, err := processor.RenditionProcessor(ctx, buf, renditions)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+
+	wantSides := map[string]int{"small": 64, "large": 200}
+	for name, side := range wantSides {
+		require.Contains(t, images, name)
+		assert.Equal(t, "image/webp", images[name].MimeType)
+		config, err := webp.DecodeConfig(bytes.NewReader(images[name].Data), &decoder.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, side, config.Width, "%s is a square crop", name)
+		assert.Equal(t, side, config.Height, "%s is a square crop", name)
+	}
+
+	_, err = processor.RenditionProcessor(ctx, bytes.NewBufferString("not an image"), renditions)
+	assert.ErrorIs(t, err, imageprocess.ErrInvalidImage)
+
+	for _, invalid := range [][]imageprocess.Rendition{
+		nil,
+		{{Name: "", Size: 64}},
+		{{Name: "small", Size: 0}},
+		{{Name: "small", Size: 64}, {Name: "small", Size: 128}},
+	} {
+		buf.Reset()
+		require.NoError(t, png.Encode(buf, createTestImage(10, 10)))
+		_, err = processor.RenditionProcessor(ctx, buf, invalid)
+		assert.ErrorIs(t, err, imageprocess.ErrInvalidRenditions, invalid)
+	}
+}
+
 // Benchmark tests for performance validation.
 func BenchmarkImageProcessor_SmallImage(b *testing.B) {
 	processor := imageprocess.NewWebpProcessor()
@@ -304,23 +341,3 @@ func BenchmarkImageProcessor_ExtremeSize(b *testing.B) {
 		}
 	}
 }
-
-// Helper function to create test images.
-func createTestImage(width, height int) image.Image {
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	// Fill with a gradient pattern to make it more realistic.
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			c := color.RGBA{
-				R: uint8((x * 255) / width),
-				G: uint8((y * 255) / height),
-				B: uint8(((x + y) * 255) / (width + height)),
-				A: 255,
-			}
-			img.Set(x, y, c)
-		}
-	}
-
-	return img
-}
@@ -7,86 +7,55 @@ import (
 	"context"
 	"errors"
 	"image"
-	_ "image/gif"  // register GIF decoder
-	_ "image/jpeg" // register JPEG decoder
-	_ "image/png"  // register PNG decoder
 	"io"
-	"math"
 
 	"github.com/kolesa-team/go-webp/encoder"
 	"github.com/kolesa-team/go-webp/webp"
-	"github.com/nfnt/resize"
-)
-
-const (
-	defaultMaxWidth  = 2048
-	defaultMaxHeight = 1080
-	defaultMaxPixels = 400000
-	defaultQuality   = 45
-	defaultMethod    = 0
 )
 
 type WebpProcessor struct {
-	maxWidth  uint
-	maxHeight uint
-	maxPixels uint
-	quality   int
-	method    int
+	config
 }
 
 var ErrWebpProcessorUnavailable = errors.New("webp processing requires cgo support")
 
-func NewWebpProcessor() *WebpProcessor {
-	return &WebpProcessor{
-		maxWidth:  defaultMaxWidth,
-		maxHeight: defaultMaxHeight,
-		maxPixels: defaultMaxPixels,
-		quality:   defaultQuality,
-		method:    defaultMethod,
-	}
+// NewProcessor returns the best Processor this build has: WebP with cgo.
+func NewProcessor(options ...Option) Processor {
+	return NewWebpProcessor(options...)
 }
 
+// NewWebpProcessor returns a processor with the defaults changed by options.
+func NewWebpProcessor(options ...Option) *WebpProcessor {
+	return &WebpProcessor{config: newConfig(options)}
+}
+
+// ImageProcessor turns file upright, scales it down to the configured bounds
+// and encodes it as WebP. Metadata such as EXIF and GPS is not kept.
 func (p *WebpProcessor) ImageProcessor(ctx context.Context, file io.Reader) (*ProcessedImage, error) {
 	_ = ctx
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return nil, err
-	}
-
-	processed := img
-	imgBounds := img.Bounds()
-	width := uint(imgBounds.Dx())
-	height := uint(imgBounds.Dy())
-
-	if width > 0 && height > 0 && (width > p.maxWidth || height > p.maxHeight) {
-		scale := math.Min(float64(p.maxWidth)/float64(width), float64(p.maxHeight)/float64(height))
-		if scale > 1 {
-			scale = 1
-		}
-		newWidth := uint(math.Max(1, math.Round(float64(width)*scale)))
-		newHeight := uint(math.Max(1, math.Round(float64(height)*scale)))
+	return p.process(file, p.encode)
+}
 
-		if p.maxPixels > 0 {
-			targetPixels := newWidth * newHeight
-			if targetPixels > p.maxPixels {
-				pixelScale := math.Sqrt(float64(p.maxPixels) / float64(targetPixels))
-				newWidth = uint(math.Max(1, math.Round(float64(newWidth)*pixelScale)))
-				newHeight = uint(math.Max(1, math.Round(float64(newHeight)*pixelScale)))
-			}
-		}
-		processed = resize.Resize(newWidth, newHeight, img, resize.NearestNeighbor)
-	}
+// RenditionProcessor encodes a WebP square of file for each rendition.
+func (p *WebpProcessor) RenditionProcessor(
+	ctx context.Context, file io.Reader, renditions []Rendition) (map[string]*ProcessedImage, error) {
+	_ = ctx
+	return p.processRenditions(file, renditions, p.encode)
+}
 
+func (p *WebpProcessor) encode(img image.Image) (*ProcessedImage, error) {
 	var buf bytes.Buffer
-	options, err := encoder.NewLossyEncoderOptions(encoder.PresetDefault, float32(p.quality))
+	options, err := encoder.NewLossyEncoderOptions(encoder.PresetDefault, p.quality)
 	if err != nil {
 		return nil, err
 	}
 
+	// libwebp reads the quality of a lossless encoding as effort.
+	options.Lossless = p.lossless
 	options.Method = p.method
 	options.ThreadLevel = true
 
-	if err = webp.Encode(&buf, processed, options); err != nil {
+	if err = webp.Encode(&buf, img, options); err != nil {
 		return nil, err
 	}
 
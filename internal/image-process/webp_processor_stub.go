@@ -12,7 +12,14 @@ var ErrWebpProcessorUnavailable = errors.New("webp processing requires cgo suppo
 
 type WebpProcessor struct{}
 
-func NewWebpProcessor() *WebpProcessor {
+// NewProcessor returns the best Processor this build has: without cgo that is
+// the pure Go one.
+func NewProcessor(options ...Option) Processor {
+	return NewGoProcessor(options...)
+}
+
+func NewWebpProcessor(options ...Option) *WebpProcessor {
+	_ = options
 	return &WebpProcessor{}
 }
 
@@ -21,3 +28,11 @@ func (p *WebpProcessor) ImageProcessor(ctx context.Context, file io.Reader) (*Pr
 	_ = file
 	return nil, ErrWebpProcessorUnavailable
 }
+
+func (p *WebpProcessor) RenditionProcessor(
+	ctx context.Context, file io.Reader, renditions []Rendition) (map[string]*ProcessedImage, error) {
+	_ = ctx
+	_ = file
+	_ = renditions
+	return nil, ErrWebpProcessorUnavailable
+}
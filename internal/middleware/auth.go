@@ -1,24 +1,77 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 
+	"github.com/abdurrahimagca/qq-back/internal/db"
 	tokenport "github.com/abdurrahimagca/qq-back/internal/platform/token"
-	"github.com/abdurrahimagca/qq-back/internal/user"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+var (
+	errMissingSession = errors.New("missing session")
+	errSessionRevoked = errors.New("session has been revoked")
+)
+
+// UserGetter loads the user a token was issued to.
+type UserGetter interface {
+	GetUserByID(ctx context.Context, userID pgtype.UUID) (*db.User, error)
+}
+
+// SuspensionChecker returns an error wrapping qqerrors.ErrAccountSuspended while
+// the account is suspended, or qqerrors.ErrPendingDeletion while it waits to be
+// purged.
+type SuspensionChecker interface {
+	CheckSuspension(ctx context.Context, authID pgtype.UUID) error
+}
+
 type AuthMiddleware struct {
 	tokenService tokenport.Service
-	userService  user.Service
+	users        UserGetter
+	sessions     SessionChecker
+	sessionCache *SessionCache
+	suspensions  SuspensionChecker
+}
+
+// AuthOption changes how NewAuthMiddleware is set up.
+type AuthOption func(*AuthMiddleware)
+
+// WithSessionCache shares cache with the auth service, which drops sessions
+// from it as they are revoked. Without it the middleware keeps its own cache.
+func WithSessionCache(cache *SessionCache) AuthOption {
+	return func(m *AuthMiddleware) {
+		m.sessionCache = cache
+	}
 }
 
-func NewAuthMiddleware(tokenService tokenport.Service, userService user.Service) *AuthMiddleware {
-	return &AuthMiddleware{
+// NewAuthMiddleware authenticates bearer access tokens. Tokens whose session was
+// revoked are rejected; session lookups are cached for a short time. Users of
+// suspended accounts are refused on every request, without caching, so a
+// suspension takes effect immediately.
+func NewAuthMiddleware(
+	tokenService tokenport.Service,
+	users UserGetter,
+	sessions SessionChecker,
+	suspensions SuspensionChecker,
+	options ...AuthOption,
+) *AuthMiddleware {
+	m := &AuthMiddleware{
 		tokenService: tokenService,
-		userService:  userService,
+		users:        users,
+		sessions:     sessions,
+		suspensions:  suspensions,
 	}
+	for _, option := range options {
+		option(m)
+	}
+	if m.sessionCache == nil {
+		m.sessionCache = NewSessionCache()
+	}
+	return m
 }
 
 func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
@@ -43,7 +96,7 @@ func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 		}
 
 		// Validate token
-		tokenResult, err := m.tokenService.ValidateToken(r.Context(), tokenport.ValidateTokenParams{
+		tokenResult, err := m.tokenService.ValidateAccessToken(r.Context(), tokenport.ValidateTokenParams{
 			Token: token,
 		})
 		if err != nil {
@@ -64,14 +117,34 @@ func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 			http.Error(w, "Invalid user ID: "+err.Error(), http.StatusUnauthorized)
 			return
 		}
-		retrievedUser, userErr := m.userService.GetUserByID(r.Context(), userUUID)
+
+		sessionID, err := m.activeSession(r, tokenResult.Claims, userUUID)
+		if err != nil {
+			http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		retrievedUser, userErr := m.users.GetUserByID(r.Context(), userUUID)
 		if userErr != nil {
 			http.Error(w, "User not found: "+userErr.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		// Add user to context
-		ctx := WithUser(r.Context(), retrievedUser)
+		if suspendedErr := m.suspensions.CheckSuspension(r.Context(), retrievedUser.AuthID); suspendedErr != nil {
+			if errors.Is(suspendedErr, qqerrors.ErrAccountSuspended) {
+				http.Error(w, "Account suspended", http.StatusForbidden)
+				return
+			}
+			if errors.Is(suspendedErr, qqerrors.ErrPendingDeletion) {
+				http.Error(w, "Account scheduled for deletion", http.StatusForbidden)
+				return
+			}
+			http.Error(w, "Invalid token: "+suspendedErr.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		// Add user and session to context
+		ctx := WithSessionID(WithUser(r.Context(), retrievedUser), sessionID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -101,7 +174,7 @@ func (m *AuthMiddleware) OptionalAuth(next http.Handler) http.Handler {
 		}
 
 		// Try to validate token
-		tokenResult, err := m.tokenService.ValidateToken(r.Context(), tokenport.ValidateTokenParams{
+		tokenResult, err := m.tokenService.ValidateAccessToken(r.Context(), tokenport.ValidateTokenParams{
 			Token: token,
 		})
 		if err != nil {
@@ -119,14 +192,28 @@ func (m *AuthMiddleware) OptionalAuth(next http.Handler) http.Handler {
 				http.Error(w, "Invalid user ID: "+err.Error(), http.StatusUnauthorized)
 				return
 			}
-			retrievedUser, userErr := m.userService.GetUserByID(r.Context(), userUUID)
+
+			sessionID, sessionErr := m.activeSession(r, tokenResult.Claims, userUUID)
+			if sessionErr != nil {
+				// Revoked session but optional, continue without user
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			retrievedUser, userErr := m.users.GetUserByID(r.Context(), userUUID)
 			if userErr != nil {
 				http.Error(w, "User not found: "+userErr.Error(), http.StatusUnauthorized)
 				return
 			}
 
-			// Add user to context
-			ctx := WithUser(r.Context(), retrievedUser)
+			if suspendedErr := m.suspensions.CheckSuspension(r.Context(), retrievedUser.AuthID); suspendedErr != nil {
+				// Suspended account but optional, continue without user
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Add user and session to context
+			ctx := WithSessionID(WithUser(r.Context(), retrievedUser), sessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
@@ -135,3 +222,24 @@ func (m *AuthMiddleware) OptionalAuth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// activeSession returns the token's session ID once the session is confirmed
+// to be active for the user.
+func (m *AuthMiddleware) activeSession(
+	r *http.Request, claims *tokenport.Claims, userID pgtype.UUID) (pgtype.UUID, error) {
+	var sessionID pgtype.UUID
+	if claims.SessionID == "" {
+		return sessionID, errMissingSession
+	}
+	if err := sessionID.Scan(claims.SessionID); err != nil {
+		return sessionID, errMissingSession
+	}
+	active, err := m.sessionCache.isSessionActive(r.Context(), m.sessions, sessionID, userID)
+	if err != nil {
+		return sessionID, err
+	}
+	if !active {
+		return sessionID, errSessionRevoked
+	}
+	return sessionID, nil
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 
 	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type contextKey string
 
 const (
-	UserContextKey contextKey = "user"
+	UserContextKey    contextKey = "user"
+	SessionContextKey contextKey = "session"
 )
 
 func WithUser(ctx context.Context, user *db.User) context.Context {
@@ -28,3 +30,12 @@ func MustGetUserFromContext(ctx context.Context) *db.User {
 	}
 	return user
 }
+
+func WithSessionID(ctx context.Context, sessionID pgtype.UUID) context.Context {
+	return context.WithValue(ctx, SessionContextKey, sessionID)
+}
+
+func GetSessionIDFromContext(ctx context.Context) (pgtype.UUID, bool) {
+	sessionID, ok := ctx.Value(SessionContextKey).(pgtype.UUID)
+	return sessionID, ok
+}
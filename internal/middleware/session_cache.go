@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// sessionCacheTTL bounds how long a revoked session can keep authenticating
+// requests on an instance that cached it as active and was not told about the
+// revocation.
+const sessionCacheTTL = 30 * time.Second
+
+const sessionCacheMaxEntries = 10000
+
+// SessionChecker reports whether a token's session is still active.
+type SessionChecker interface {
+	IsSessionActive(ctx context.Context, sessionID pgtype.UUID, userID pgtype.UUID) (bool, error)
+}
+
+type sessionCacheEntry struct {
+	active    bool
+	expiresAt time.Time
+}
+
+// SessionCache memoizes session lookups for a short time so authenticated
+// requests don't each cost a database round trip. It implements
+// auth.SessionRevocationListener: sessions revoked through the auth service of
+// this instance are dropped at once.
+type SessionCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[[2]pgtype.UUID]sessionCacheEntry
+	// revoked holds the session and user IDs revoked within the last ttl.
+	// Their active results are not cached: a revocation is announced before
+	// its transaction commits, and a lookup in between still reads active.
+	revoked map[pgtype.UUID]time.Time
+}
+
+func NewSessionCache() *SessionCache {
+	return newSessionCache(sessionCacheTTL)
+}
+
+func newSessionCache(ttl time.Duration) *SessionCache {
+	return &SessionCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[[2]pgtype.UUID]sessionCacheEntry),
+		revoked: make(map[pgtype.UUID]time.Time),
+	}
+}
+
+// SessionRevoked drops the cached state of a session.
+func (c *SessionCache) SessionRevoked(sessionID pgtype.UUID) {
+	c.forget(sessionID, func(key [2]pgtype.UUID) bool { return key[0] == sessionID })
+}
+
+// UserSessionsRevoked drops the cached state of every session of a user.
+func (c *SessionCache) UserSessionsRevoked(userID pgtype.UUID) {
+	c.forget(userID, func(key [2]pgtype.UUID) bool { return key[1] == userID })
+}
+
+func (c *SessionCache) forget(id pgtype.UUID, match func(key [2]pgtype.UUID) bool) {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if match(key) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.revoked) >= sessionCacheMaxEntries {
+		for k, until := range c.revoked {
+			if !now.Before(until) {
+				delete(c.revoked, k)
+			}
+		}
+	}
+	c.revoked[id] = now.Add(c.ttl)
+}
+
+func (c *SessionCache) isSessionActive(
+	ctx context.Context, checker SessionChecker, sessionID pgtype.UUID, userID pgtype.UUID) (bool, error) {
+	key := [2]pgtype.UUID{sessionID, userID}
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.active, nil
+	}
+
+	active, err := checker.IsSessionActive(ctx, sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if active && (now.Before(c.revoked[sessionID]) || now.Before(c.revoked[userID])) {
+		return active, nil
+	}
+	if len(c.entries) >= sessionCacheMaxEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= sessionCacheMaxEntries {
+			c.entries = make(map[[2]pgtype.UUID]sessionCacheEntry)
+		}
+	}
+	c.entries[key] = sessionCacheEntry{active: active, expiresAt: now.Add(c.ttl)}
+	return active, nil
+}
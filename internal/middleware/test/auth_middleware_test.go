@@ -1,18 +1,28 @@
 package middleware_test
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/abdurrahimagca/qq-back/internal/environment"
 	"github.com/abdurrahimagca/qq-back/internal/middleware"
+	"github.com/abdurrahimagca/qq-back/internal/platform/token"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewAuthMiddleware(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
 
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	assert.NotNil(t, authMiddleware, "AuthMiddleware should not be nil")
 }
@@ -20,7 +30,9 @@ func TestNewAuthMiddleware(t *testing.T) {
 func TestAuthMiddleware_RequireAuth_ValidToken(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup successful token validation
 	user := createTestUser(TestUserID1)
@@ -50,7 +62,9 @@ func TestAuthMiddleware_RequireAuth_ValidToken(t *testing.T) {
 func TestAuthMiddleware_RequireAuth_NoAuthHeader(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	handler := NewTestHandler()
 	protectedHandler := authMiddleware.RequireAuth(handler)
@@ -74,7 +88,9 @@ func TestAuthMiddleware_RequireAuth_NoAuthHeader(t *testing.T) {
 func TestAuthMiddleware_RequireAuth_EmptyAuthHeader(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	handler := NewTestHandler()
 	protectedHandler := authMiddleware.RequireAuth(handler)
@@ -95,7 +111,9 @@ func TestAuthMiddleware_RequireAuth_EmptyAuthHeader(t *testing.T) {
 func TestAuthMiddleware_RequireAuth_InvalidAuthHeaderFormat(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	handler := NewTestHandler()
 	protectedHandler := authMiddleware.RequireAuth(handler)
@@ -144,7 +162,9 @@ func TestAuthMiddleware_RequireAuth_InvalidAuthHeaderFormat(t *testing.T) {
 func TestAuthMiddleware_RequireAuth_TokenValidationError(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup token validation error
 	tokenService.SetValidateTokenError(ErrInvalidToken)
@@ -168,10 +188,56 @@ func TestAuthMiddleware_RequireAuth_TokenValidationError(t *testing.T) {
 	assert.Equal(t, 0, userService.GetGetUserByIDCallCount(), "User service should not be called")
 }
 
+func TestAuthMiddleware_RequireAuth_RefreshTokenRejected(t *testing.T) {
+	tokenService := token.NewJWTTokenService(&environment.Environment{
+		Token: environment.TokenEnvironment{
+			Secret:                 "test-secret",
+			AccessTokenExpireTime:  15,
+			RefreshTokenExpireTime: 24,
+			Issuer:                 "test-issuer",
+			Audience:               "test-audience",
+		},
+	})
+	userService := NewMockUserService()
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
+
+	user := createTestUser(TestUserID1)
+	userService.SetGetUserByIDResult(user, nil)
+	tokens, err := tokenService.GenerateTokens(context.Background(), token.GenerateTokenParams{
+		UserID:    TestUserID1,
+		SessionID: TestSessionID,
+	})
+	require.NoError(t, err)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	// Refresh token presented as a bearer credential
+	req := createTestRequest("/protected", "Bearer "+tokens.RefreshToken)
+	w := httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, req)
+
+	assertUnauthorized(t, w)
+	assert.False(t, handler.WasCalled(), "Next handler should not be called")
+	assert.Equal(t, 0, userService.GetGetUserByIDCallCount(), "User service should not be called")
+
+	// The access token from the same pair is accepted
+	req = createTestRequest("/protected", "Bearer "+tokens.AccessToken)
+	w = httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, req)
+
+	assertOK(t, w)
+	assertUserInContext(t, handler.GetRequest(), user)
+}
+
 func TestAuthMiddleware_RequireAuth_EmptyUserID(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup token with empty UserID
 	tokenService.SetValidateTokenResult("", nil)
@@ -197,7 +263,9 @@ func TestAuthMiddleware_RequireAuth_EmptyUserID(t *testing.T) {
 func TestAuthMiddleware_RequireAuth_InvalidUserIDFormat(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup token with invalid UUID format
 	tokenService.SetValidateTokenResult("not-a-uuid", nil)
@@ -223,7 +291,9 @@ func TestAuthMiddleware_RequireAuth_InvalidUserIDFormat(t *testing.T) {
 func TestAuthMiddleware_RequireAuth_UserNotFound(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup successful token validation but user not found
 	tokenService.SetValidateTokenResult(TestUserID1, nil)
@@ -251,7 +321,9 @@ func TestAuthMiddleware_RequireAuth_UserNotFound(t *testing.T) {
 func TestAuthMiddleware_OptionalAuth_ValidToken(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup successful authentication
 	user := createTestUser(TestUserID1)
@@ -279,7 +351,9 @@ func TestAuthMiddleware_OptionalAuth_ValidToken(t *testing.T) {
 func TestAuthMiddleware_OptionalAuth_NoAuthHeader(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	handler := NewTestHandler()
 	optionalHandler := authMiddleware.OptionalAuth(handler)
@@ -302,7 +376,9 @@ func TestAuthMiddleware_OptionalAuth_NoAuthHeader(t *testing.T) {
 func TestAuthMiddleware_OptionalAuth_InvalidAuthHeaderFormat(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	handler := NewTestHandler()
 	optionalHandler := authMiddleware.OptionalAuth(handler)
@@ -325,7 +401,9 @@ func TestAuthMiddleware_OptionalAuth_InvalidAuthHeaderFormat(t *testing.T) {
 func TestAuthMiddleware_OptionalAuth_TokenValidationError(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup token validation error
 	tokenService.SetValidateTokenError(ErrInvalidToken)
@@ -351,7 +429,9 @@ func TestAuthMiddleware_OptionalAuth_TokenValidationError(t *testing.T) {
 func TestAuthMiddleware_OptionalAuth_EmptyUserID(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup token with empty UserID
 	tokenService.SetValidateTokenResult("", nil)
@@ -381,7 +461,9 @@ func TestAuthMiddleware_OptionalAuth_EmptyUserID(t *testing.T) {
 func TestAuthMiddleware_OptionalAuth_InvalidUserIDFormat_CurrentBehavior(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup token with invalid UUID format
 	tokenService.SetValidateTokenResult("not-a-uuid", nil)
@@ -403,7 +485,9 @@ func TestAuthMiddleware_OptionalAuth_InvalidUserIDFormat_CurrentBehavior(t *test
 func TestAuthMiddleware_OptionalAuth_UserServiceError_CurrentBehavior(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 
 	// Setup token validation success but user service error
 	tokenService.SetValidateTokenResult(TestUserID1, nil)
@@ -422,3 +506,308 @@ func TestAuthMiddleware_OptionalAuth_UserServiceError_CurrentBehavior(t *testing
 	assert.False(t, handler.WasCalled(), "Next handler should not be called")
 	assert.Contains(t, w.Body.String(), "User not found:")
 }
+
+func TestAuthMiddleware_RequireAuth_RevokedSession(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	sessions := NewMockSessionChecker()
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, sessions, NewMockSuspensionChecker())
+
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(createTestUser(TestUserID1), nil)
+	sessions.Revoke(TestSessionID)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	req := createTestRequest("/protected", createValidToken(TestUserID1))
+	w := httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, req)
+
+	assertUnauthorized(t, w)
+	assert.False(t, handler.WasCalled(), "Next handler should not be called")
+	assert.Contains(t, w.Body.String(), "session has been revoked")
+	assert.Equal(t, 0, userService.GetGetUserByIDCallCount(), "User service should not be called")
+}
+
+func TestAuthMiddleware_RequireAuth_MissingSessionID(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	sessions := NewMockSessionChecker()
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, sessions, NewMockSuspensionChecker())
+
+	// Token issued before sessions existed carries no sid
+	tokenService.ValidateTokenFunc = func(
+		ctx context.Context, params token.ValidateTokenParams,
+	) (token.ValidateTokenResult, error) {
+		return token.ValidateTokenResult{
+			Claims: &token.Claims{UserID: TestUserID1, TokenUse: token.TokenUseAccess},
+		}, nil
+	}
+	userService.SetGetUserByIDResult(createTestUser(TestUserID1), nil)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	req := createTestRequest("/protected", createValidToken(TestUserID1))
+	w := httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, req)
+
+	assertUnauthorized(t, w)
+	assert.False(t, handler.WasCalled(), "Next handler should not be called")
+	assert.Contains(t, w.Body.String(), "missing session")
+	assert.Equal(t, 0, sessions.GetCallCount(), "Session checker should not be called")
+}
+
+func TestAuthMiddleware_RequireAuth_SessionCheckCached(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	sessions := NewMockSessionChecker()
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, sessions, NewMockSuspensionChecker())
+
+	user := createTestUser(TestUserID1)
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(user, nil)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	for i := 0; i < 3; i++ {
+		handler.Reset()
+		req := createTestRequest("/protected", createValidToken(TestUserID1))
+		w := httptest.NewRecorder()
+		protectedHandler.ServeHTTP(w, req)
+
+		assertOK(t, w)
+		assert.True(t, handler.WasCalled(), "Next handler should be called")
+	}
+
+	assert.Equal(t, 1, sessions.GetCallCount(), "Session lookups should be cached")
+}
+
+func TestAuthMiddleware_RequireAuth_SessionCheckerError(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	sessions := NewMockSessionChecker()
+	sessions.IsSessionActiveFunc = func(ctx context.Context, sessionID, userID pgtype.UUID) (bool, error) {
+		return false, ErrDatabaseError
+	}
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, sessions, NewMockSuspensionChecker())
+
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	req := createTestRequest("/protected", createValidToken(TestUserID1))
+	w := httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, req)
+
+	assertUnauthorized(t, w)
+	assert.False(t, handler.WasCalled(), "Next handler should not be called")
+}
+
+func TestAuthMiddleware_RequireAuth_SessionIDInContext(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
+
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(createTestUser(TestUserID1), nil)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	req := createTestRequest("/protected", createValidToken(TestUserID1))
+	w := httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, req)
+
+	assertOK(t, w)
+	sessionID, ok := middleware.GetSessionIDFromContext(handler.GetRequest().Context())
+	require.True(t, ok, "Session ID should be present in context")
+	assert.Equal(t, TestSessionID, sessionID.String())
+}
+
+func TestAuthMiddleware_OptionalAuth_RevokedSession(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	sessions := NewMockSessionChecker()
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, sessions, NewMockSuspensionChecker())
+
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(createTestUser(TestUserID1), nil)
+	sessions.Revoke(TestSessionID)
+
+	handler := NewTestHandler()
+	optionalHandler := authMiddleware.OptionalAuth(handler)
+
+	req := createTestRequest("/optional", createValidToken(TestUserID1))
+	w := httptest.NewRecorder()
+	optionalHandler.ServeHTTP(w, req)
+
+	assertOK(t, w)
+	assert.True(t, handler.WasCalled(), "Next handler should be called")
+	assertNoUserInContext(t, handler.GetRequest())
+}
+
+func TestAuthMiddleware_RequireAuth_SuspendedAccount(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	suspensions := NewMockSuspensionChecker()
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, NewMockSessionChecker(), suspensions)
+
+	user := createTestUser(TestUserID1)
+	require.NoError(t, user.AuthID.Scan(TestAuthID))
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(user, nil)
+	suspensions.Suspend(TestAuthID)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	req := createTestRequest("/protected", createValidToken(TestUserID1))
+	w := httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, handler.WasCalled(), "Next handler should not be called")
+	assert.Contains(t, w.Body.String(), "Account suspended")
+	require.Equal(t, 1, suspensions.GetCallCount())
+	assert.Equal(t, TestAuthID, suspensions.Calls[0].String(), "Suspension should be checked by auth ID")
+}
+
+func TestAuthMiddleware_RequireAuth_SuspensionCheckNotCached(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	suspensions := NewMockSuspensionChecker()
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, NewMockSessionChecker(), suspensions)
+
+	user := createTestUser(TestUserID1)
+	require.NoError(t, user.AuthID.Scan(TestAuthID))
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(user, nil)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	w := httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, createTestRequest("/protected", createValidToken(TestUserID1)))
+	assertOK(t, w)
+
+	// A suspension takes effect on the next request, even though the session is cached
+	suspensions.Suspend(TestAuthID)
+	handler.Reset()
+	w = httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, createTestRequest("/protected", createValidToken(TestUserID1)))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, handler.WasCalled(), "Next handler should not be called")
+}
+
+func TestAuthMiddleware_RequireAuth_PendingDeletion(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	suspensions := NewMockSuspensionChecker()
+	suspensions.CheckSuspensionFunc = func(ctx context.Context, authID pgtype.UUID) error {
+		return fmt.Errorf("account is scheduled for deletion: %w", qqerrors.ErrPendingDeletion)
+	}
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, NewMockSessionChecker(), suspensions)
+
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(createTestUser(TestUserID1), nil)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	req := createTestRequest("/protected", createValidToken(TestUserID1))
+	w := httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, handler.WasCalled(), "Next handler should not be called")
+	assert.Contains(t, w.Body.String(), "Account scheduled for deletion")
+}
+
+func TestAuthMiddleware_RequireAuth_SuspensionCheckerError(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	suspensions := NewMockSuspensionChecker()
+	suspensions.CheckSuspensionFunc = func(ctx context.Context, authID pgtype.UUID) error {
+		return ErrDatabaseError
+	}
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, NewMockSessionChecker(), suspensions)
+
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(createTestUser(TestUserID1), nil)
+
+	handler := NewTestHandler()
+	protectedHandler := authMiddleware.RequireAuth(handler)
+
+	req := createTestRequest("/protected", createValidToken(TestUserID1))
+	w := httptest.NewRecorder()
+	protectedHandler.ServeHTTP(w, req)
+
+	assertUnauthorized(t, w)
+	assert.False(t, handler.WasCalled(), "Next handler should not be called")
+}
+
+func TestAuthMiddleware_OptionalAuth_SuspendedAccount(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	suspensions := NewMockSuspensionChecker()
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, NewMockSessionChecker(), suspensions)
+
+	user := createTestUser(TestUserID1)
+	require.NoError(t, user.AuthID.Scan(TestAuthID))
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(user, nil)
+	suspensions.Suspend(TestAuthID)
+
+	handler := NewTestHandler()
+	optionalHandler := authMiddleware.OptionalAuth(handler)
+
+	req := createTestRequest("/optional", createValidToken(TestUserID1))
+	w := httptest.NewRecorder()
+	optionalHandler.ServeHTTP(w, req)
+
+	assertOK(t, w)
+	assert.True(t, handler.WasCalled(), "Next handler should be called")
+	assertNoUserInContext(t, handler.GetRequest())
+}
+
+func TestAuthMiddleware_RequireAuth_RevocationDropsCachedSession(t *testing.T) {
+	tokenService := NewMockTokenService()
+	userService := NewMockUserService()
+	sessions := NewMockSessionChecker()
+	cache := middleware.NewSessionCache()
+	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService, sessions, NewMockSuspensionChecker(),
+		middleware.WithSessionCache(cache))
+
+	tokenService.SetValidateTokenResult(TestUserID1, nil)
+	userService.SetGetUserByIDResult(createTestUser(TestUserID1), nil)
+	protectedHandler := authMiddleware.RequireAuth(NewTestHandler())
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		protectedHandler.ServeHTTP(w, createTestRequest("/protected", createValidToken(TestUserID1)))
+		return w
+	}
+
+	assertOK(t, serve())
+	var sessionID pgtype.UUID
+	require.NoError(t, sessionID.Scan(TestSessionID))
+	sessions.Revoke(TestSessionID)
+	cache.SessionRevoked(sessionID)
+	assertUnauthorized(t, serve())
+
+	// Revoke-all is announced before its transaction commits; the session still
+	// reads as active until then, but that answer is not cached.
+	var userID pgtype.UUID
+	require.NoError(t, userID.Scan(TestUserID1))
+	delete(sessions.Revoked, TestSessionID)
+	cache.UserSessionsRevoked(userID)
+	assertOK(t, serve())
+	sessions.Revoke(TestSessionID)
+	assertUnauthorized(t, serve())
+}
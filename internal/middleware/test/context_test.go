@@ -5,7 +5,9 @@ import (
 	"testing"
 
 	"github.com/abdurrahimagca/qq-back/internal/middleware"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWithUser(t *testing.T) {
@@ -148,3 +150,20 @@ func TestContextChaining(t *testing.T) {
 	assert.True(t, ok, "Second context should have user")
 	assert.Equal(t, user2, retrievedUser2, "Second context should have user2")
 }
+
+func TestWithSessionID(t *testing.T) {
+	var sessionID pgtype.UUID
+	require.NoError(t, sessionID.Scan(TestSessionID))
+
+	ctx := middleware.WithSessionID(context.Background(), sessionID)
+
+	retrieved, ok := middleware.GetSessionIDFromContext(ctx)
+	assert.True(t, ok, "Should be able to retrieve session ID from context")
+	assert.Equal(t, sessionID, retrieved)
+}
+
+func TestGetSessionIDFromContext_WithoutSession(t *testing.T) {
+	_, ok := middleware.GetSessionIDFromContext(context.Background())
+
+	assert.False(t, ok, "Should not find session ID in empty context")
+}
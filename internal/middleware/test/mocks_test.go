@@ -3,10 +3,12 @@ package middleware_test
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/abdurrahimagca/qq-back/internal/db"
 	"github.com/abdurrahimagca/qq-back/internal/platform/token"
 	"github.com/abdurrahimagca/qq-back/internal/user"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
@@ -32,6 +34,13 @@ func (m *MockTokenService) GenerateTokens(
 
 func (m *MockTokenService) ValidateToken(
 	ctx context.Context, params token.ValidateTokenParams,
+) (token.ValidateTokenResult, error) {
+	// Not used in middleware tests.
+	return token.ValidateTokenResult{}, errors.New("not implemented in mock")
+}
+
+func (m *MockTokenService) ValidateAccessToken(
+	ctx context.Context, params token.ValidateTokenParams,
 ) (token.ValidateTokenResult, error) {
 	m.ValidateCalls = append(m.ValidateCalls, params)
 
@@ -42,11 +51,34 @@ func (m *MockTokenService) ValidateToken(
 	// Default success behavior.
 	return token.ValidateTokenResult{
 		Claims: &token.Claims{
-			UserID: "550e8400-e29b-41d4-a716-446655440000",
+			UserID:    "550e8400-e29b-41d4-a716-446655440000",
+			TokenUse:  token.TokenUseAccess,
+			SessionID: TestSessionID,
 		},
 	}, nil
 }
 
+func (m *MockTokenService) ValidateRefreshToken(
+	ctx context.Context, params token.ValidateTokenParams,
+) (token.ValidateTokenResult, error) {
+	// Not used in middleware tests.
+	return token.ValidateTokenResult{}, errors.New("not implemented in mock")
+}
+
+func (m *MockTokenService) GeneratePurposeToken(
+	ctx context.Context, params token.PurposeTokenParams,
+) (string, error) {
+	// Not used in middleware tests.
+	return "", errors.New("not implemented in mock")
+}
+
+func (m *MockTokenService) ValidatePurposeToken(
+	ctx context.Context, params token.ValidateTokenParams, use token.TokenUse,
+) (token.ValidateTokenResult, error) {
+	// Not used in middleware tests.
+	return token.ValidateTokenResult{}, errors.New("not implemented in mock")
+}
+
 // SetValidateTokenResult configures the mock to return specific result.
 func (m *MockTokenService) SetValidateTokenResult(userID string, err error) {
 	m.ValidateTokenFunc = func(
@@ -57,7 +89,9 @@ func (m *MockTokenService) SetValidateTokenResult(userID string, err error) {
 		}
 		return token.ValidateTokenResult{
 			Claims: &token.Claims{
-				UserID: userID,
+				UserID:    userID,
+				TokenUse:  token.TokenUseAccess,
+				SessionID: TestSessionID,
 			},
 		}, nil
 	}
@@ -72,12 +106,12 @@ func (m *MockTokenService) SetValidateTokenError(err error) {
 	}
 }
 
-// GetValidateTokenCallCount returns the number of times ValidateToken was called.
+// GetValidateTokenCallCount returns the number of times ValidateAccessToken was called.
 func (m *MockTokenService) GetValidateTokenCallCount() int {
 	return len(m.ValidateCalls)
 }
 
-// GetLastValidateTokenCall returns the last call to ValidateToken.
+// GetLastValidateTokenCall returns the last call to ValidateAccessToken.
 func (m *MockTokenService) GetLastValidateTokenCall() *token.ValidateTokenParams {
 	if len(m.ValidateCalls) == 0 {
 		return nil
@@ -124,6 +158,10 @@ func (m *MockUserService) GetUserByID(ctx context.Context, userID pgtype.UUID) (
 	return nil, errors.New("user not found")
 }
 
+func (m *MockUserService) GetUserByAuthID(ctx context.Context, authID pgtype.UUID) (*db.User, error) {
+	return nil, errors.New("not implemented in mock")
+}
+
 func (m *MockUserService) GetUserByEmail(ctx context.Context, email string) (*db.User, error) {
 	return nil, errors.New("not implemented in mock")
 }
@@ -132,6 +170,10 @@ func (m *MockUserService) UpdateUser(ctx context.Context, user db.UpdateUserPara
 	return nil, errors.New("not implemented in mock")
 }
 
+func (m *MockUserService) ClearAvatarKey(ctx context.Context, userID pgtype.UUID) (*db.User, error) {
+	return nil, errors.New("not implemented in mock")
+}
+
 func (m *MockUserService) UserNameAvailable(ctx context.Context, username string) (bool, error) {
 	return false, errors.New("not implemented in mock")
 }
@@ -184,6 +226,79 @@ func (m *MockUserService) Reset() {
 	m.Users = make(map[string]*db.User)
 }
 
+// MockSessionChecker implements middleware.SessionChecker for testing. Sessions
+// are active unless listed in Revoked.
+type MockSessionChecker struct {
+	IsSessionActiveFunc func(ctx context.Context, sessionID, userID pgtype.UUID) (bool, error)
+	Revoked             map[string]bool // keyed by session UUID string
+	Calls               []pgtype.UUID
+}
+
+func NewMockSessionChecker() *MockSessionChecker {
+	return &MockSessionChecker{
+		Revoked: make(map[string]bool),
+		Calls:   make([]pgtype.UUID, 0),
+	}
+}
+
+func (m *MockSessionChecker) IsSessionActive(
+	ctx context.Context, sessionID pgtype.UUID, userID pgtype.UUID,
+) (bool, error) {
+	m.Calls = append(m.Calls, sessionID)
+
+	if m.IsSessionActiveFunc != nil {
+		return m.IsSessionActiveFunc(ctx, sessionID, userID)
+	}
+	return !m.Revoked[sessionID.String()], nil
+}
+
+// Revoke marks the session as revoked.
+func (m *MockSessionChecker) Revoke(sessionID string) {
+	m.Revoked[sessionID] = true
+}
+
+// GetCallCount returns the number of times IsSessionActive was called.
+func (m *MockSessionChecker) GetCallCount() int {
+	return len(m.Calls)
+}
+
+// MockSuspensionChecker implements middleware.SuspensionChecker for testing.
+// Accounts are not suspended unless listed in Suspended.
+type MockSuspensionChecker struct {
+	CheckSuspensionFunc func(ctx context.Context, authID pgtype.UUID) error
+	Suspended           map[string]bool // keyed by auth UUID string
+	Calls               []pgtype.UUID
+}
+
+func NewMockSuspensionChecker() *MockSuspensionChecker {
+	return &MockSuspensionChecker{
+		Suspended: make(map[string]bool),
+		Calls:     make([]pgtype.UUID, 0),
+	}
+}
+
+func (m *MockSuspensionChecker) CheckSuspension(ctx context.Context, authID pgtype.UUID) error {
+	m.Calls = append(m.Calls, authID)
+
+	if m.CheckSuspensionFunc != nil {
+		return m.CheckSuspensionFunc(ctx, authID)
+	}
+	if m.Suspended[authID.String()] {
+		return fmt.Errorf("account is suspended: %w", qqerrors.ErrAccountSuspended)
+	}
+	return nil
+}
+
+// Suspend marks the account as suspended.
+func (m *MockSuspensionChecker) Suspend(authID string) {
+	m.Suspended[authID] = true
+}
+
+// GetCallCount returns the number of times CheckSuspension was called.
+func (m *MockSuspensionChecker) GetCallCount() int {
+	return len(m.Calls)
+}
+
 // Common errors for testing.
 var (
 	ErrInvalidToken  = errors.New("invalid token")
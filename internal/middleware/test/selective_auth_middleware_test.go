@@ -11,7 +11,9 @@ import (
 func TestNewSelectiveAuthMiddleware(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{"/health", "/public"}
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -22,7 +24,9 @@ func TestNewSelectiveAuthMiddleware(t *testing.T) {
 func TestSelectiveAuthMiddleware_PublicPath_ExactMatch(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{"/health", "/public"}
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -66,7 +70,9 @@ func TestSelectiveAuthMiddleware_PublicPath_ExactMatch(t *testing.T) {
 func TestSelectiveAuthMiddleware_PublicPath_PrefixMatch(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{"/api/public", "/docs"}
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -125,7 +131,9 @@ func TestSelectiveAuthMiddleware_PublicPath_PrefixMatch(t *testing.T) {
 func TestSelectiveAuthMiddleware_ProtectedPath_RequiresAuth(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{"/health", "/public"}
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -188,7 +196,9 @@ func TestSelectiveAuthMiddleware_ProtectedPath_RequiresAuth(t *testing.T) {
 func TestSelectiveAuthMiddleware_ProtectedPath_WithValidAuth(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{"/health", "/public"}
 
 	// Setup successful authentication
@@ -218,7 +228,9 @@ func TestSelectiveAuthMiddleware_ProtectedPath_WithValidAuth(t *testing.T) {
 func TestSelectiveAuthMiddleware_EmptyPublicPaths(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{} // No public paths
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -249,7 +261,9 @@ func TestSelectiveAuthMiddleware_EmptyPublicPaths(t *testing.T) {
 func TestSelectiveAuthMiddleware_NilPublicPaths(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	var publicPaths []string = nil // Nil public paths
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -270,7 +284,9 @@ func TestSelectiveAuthMiddleware_NilPublicPaths(t *testing.T) {
 func TestSelectiveAuthMiddleware_RootPathPublic(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{"/"} // Root path is public
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -305,7 +321,9 @@ func TestSelectiveAuthMiddleware_RootPathPublic(t *testing.T) {
 func TestSelectiveAuthMiddleware_OverlappingPaths(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{"/api", "/api/public", "/api/public/health"}
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -360,7 +378,9 @@ func TestSelectiveAuthMiddleware_OverlappingPaths(t *testing.T) {
 func TestSelectiveAuthMiddleware_CaseSensitivity(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{"/public"}
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -414,7 +434,9 @@ func TestSelectiveAuthMiddleware_CaseSensitivity(t *testing.T) {
 func TestSelectiveAuthMiddleware_QueryParameters(t *testing.T) {
 	tokenService := NewMockTokenService()
 	userService := NewMockUserService()
-	authMiddleware := middleware.NewAuthMiddleware(tokenService, userService)
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenService, userService, NewMockSessionChecker(), NewMockSuspensionChecker(),
+	)
 	publicPaths := []string{"/health"}
 
 	selectiveAuth := middleware.NewSelectiveAuthMiddleware(authMiddleware, publicPaths)
@@ -107,4 +107,7 @@ const (
 	TestUserID1 = "550e8400-e29b-41d4-a716-446655440000"
 	TestUserID2 = "550e8400-e29b-41d4-a716-446655440001"
 	TestUserID3 = "550e8400-e29b-41d4-a716-446655440002"
+
+	TestSessionID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	TestAuthID    = "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
 )
@@ -0,0 +1,53 @@
+package emailpolicy
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// maxEmailLength is the longest address that fits the auth.email column.
+const maxEmailLength = 255
+
+// Normalize lower-cases an address and drops the plus tag from its local part,
+// so "Jane+news@Example.com" and "jane@example.com" resolve to one account. It
+// returns ErrInvalidEmail for anything but a bare address with a dotted domain.
+func Normalize(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	parsed, err := mail.ParseAddress(email)
+	if err != nil || parsed.Name != "" || parsed.Address != email || len(email) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+
+	at := strings.LastIndexByte(email, '@')
+	local, domain := strings.ToLower(email[:at]), strings.ToLower(email[at+1:])
+	if strings.ContainsAny(local, `"\`) {
+		return "", ErrInvalidEmail
+	}
+	if tag := strings.IndexByte(local, '+'); tag >= 0 {
+		local = local[:tag]
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	if local == "" || !strings.Contains(domain, ".") {
+		return "", ErrInvalidEmail
+	}
+	return local + "@" + domain, nil
+}
+
+// Domain returns the domain of an address returned by Normalize.
+func Domain(normalized string) string {
+	return normalized[strings.LastIndexByte(normalized, '@')+1:]
+}
+
+// parentDomains lists a domain and each parent above it, most specific first,
+// stopping before the top-level domain.
+func parentDomains(domain string) []string {
+	domains := []string{domain}
+	for {
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 || !strings.Contains(domain[dot+1:], ".") {
+			return domains
+		}
+		domain = domain[dot+1:]
+		domains = append(domains, domain)
+	}
+}
@@ -0,0 +1,79 @@
+package emailpolicy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// mxLookupTimeout bounds the DNS lookup so a slow resolver cannot stall sign-up.
+const mxLookupTimeout = 3 * time.Second
+
+type policy struct {
+	sources  []RuleSource
+	resolver MXResolver
+}
+
+// NewPolicy checks addresses against sources and, when resolver is not nil,
+// rejects domains that publish no mail exchanger. An allow rule in any source
+// wins over deny rules and skips the MX lookup.
+func NewPolicy(sources []RuleSource, resolver MXResolver) Policy {
+	return &policy{sources: sources, resolver: resolver}
+}
+
+func (p *policy) Allow(ctx context.Context, email string) error {
+	normalized, err := Normalize(email)
+	if err != nil {
+		return err
+	}
+	domain := Domain(normalized)
+	domains := parentDomains(domain)
+
+	denied := false
+	for _, source := range p.sources {
+		action, ok, matchErr := source.Match(ctx, domains)
+		if matchErr != nil {
+			return matchErr
+		}
+		if !ok {
+			continue
+		}
+		if action == ActionAllow {
+			return nil
+		}
+		denied = true
+	}
+	if denied {
+		return ErrDomainNotAllowed
+	}
+
+	if p.resolver != nil {
+		return p.checkMX(ctx, domain)
+	}
+	return nil
+}
+
+// checkMX rejects domains that do not exist, have no MX records or publish a
+// null MX (RFC 7505). Other lookup failures let the address through so a DNS
+// outage does not stop sign-ups.
+func (p *policy) checkMX(ctx context.Context, domain string) error {
+	ctx, cancel := context.WithTimeout(ctx, mxLookupTimeout)
+	defer cancel()
+
+	records, err := p.resolver.LookupMX(ctx, domain)
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return ErrDomainNotAllowed
+	case err != nil:
+		slog.Default().Warn("MX lookup failed, allowing email domain", "domain", domain, "error", err)
+		return nil
+	}
+
+	if len(records) == 0 || (len(records) == 1 && (records[0].Host == "." || records[0].Host == "")) {
+		return ErrDomainNotAllowed
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package emailpolicy
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+)
+
+var (
+	ErrInvalidEmail     = fmt.Errorf("invalid email address: %w", qqerrors.ErrValidationError)
+	ErrDomainNotAllowed = fmt.Errorf("email domain is not allowed: %w", qqerrors.ErrValidationError)
+)
+
+// Action is what a rule says about a domain.
+type Action string
+
+const (
+	ActionAllow Action = "allow"
+	ActionDeny  Action = "deny"
+)
+
+// Policy decides whether a new account may use an email address.
+type Policy interface {
+	Allow(ctx context.Context, email string) error
+}
+
+// RuleSource holds domain rules. Match is given the domain of an address
+// followed by its parent domains and returns the rule of the first one listed.
+type RuleSource interface {
+	Match(ctx context.Context, domains []string) (Action, bool, error)
+}
+
+// MXResolver looks up the mail exchangers of a domain; *net.Resolver satisfies
+// it.
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
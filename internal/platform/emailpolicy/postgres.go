@@ -0,0 +1,30 @@
+package emailpolicy
+
+import (
+	"context"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresRules struct {
+	q *db.Queries
+}
+
+// NewPostgresRules reads rules from the email_domain_rules table on every
+// match, so rules added there apply without a restart.
+func NewPostgresRules(pool *pgxpool.Pool) RuleSource {
+	return &postgresRules{q: db.New(pool)}
+}
+
+func (r *postgresRules) Match(ctx context.Context, domains []string) (Action, bool, error) {
+	rules, err := r.q.ListEmailDomainRules(ctx, domains)
+	if err != nil {
+		return "", false, err
+	}
+	if len(rules) == 0 {
+		return "", false, nil
+	}
+	// Rules are ordered longest domain first, which is the most specific one.
+	return Action(rules[0].Action), true, nil
+}
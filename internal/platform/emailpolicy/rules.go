@@ -0,0 +1,94 @@
+package emailpolicy
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed disposable_domains.txt
+var disposableDomains string
+
+// StaticRules is a fixed set of rules keyed by lower-case domain.
+type StaticRules map[string]Action
+
+func (r StaticRules) Match(_ context.Context, domains []string) (Action, bool, error) {
+	for _, domain := range domains {
+		if action, ok := r[domain]; ok {
+			return action, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// Add lists every domain under action, replacing earlier rules for it.
+func (r StaticRules) Add(action Action, domains ...string) {
+	for _, domain := range domains {
+		r[domain] = action
+	}
+}
+
+// DisposableRules denies the bundled list of disposable mailbox providers.
+func DisposableRules() StaticRules {
+	rules := StaticRules{}
+	domains, _ := ReadDomains(strings.NewReader(disposableDomains))
+	rules.Add(ActionDeny, domains...)
+	return rules
+}
+
+// LoadRuleFiles reads an allowlist and a denylist file; an empty path is
+// skipped. A domain in both files is allowed.
+func LoadRuleFiles(allowlistPath string, denylistPath string) (StaticRules, error) {
+	rules := StaticRules{}
+	for _, list := range []struct {
+		path   string
+		action Action
+	}{{denylistPath, ActionDeny}, {allowlistPath, ActionAllow}} {
+		if list.path == "" {
+			continue
+		}
+		domains, err := readDomainsFile(list.path)
+		if err != nil {
+			return nil, err
+		}
+		rules.Add(list.action, domains...)
+	}
+	return rules, nil
+}
+
+func readDomainsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	domains, err := ReadDomains(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return domains, nil
+}
+
+// ReadDomains reads one domain per line. Blank lines and everything after a #
+// are ignored, and domains are lower-cased.
+func ReadDomains(r io.Reader) ([]string, error) {
+	var domains []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(line)), ".")
+		if line == "" {
+			continue
+		}
+		if strings.ContainsAny(line, " \t@") {
+			return nil, fmt.Errorf("invalid domain %q", line)
+		}
+		domains = append(domains, line)
+	}
+	return domains, scanner.Err()
+}
@@ -0,0 +1,156 @@
+package emailpolicy_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeResolver struct {
+	records []*net.MX
+	err     error
+	lookups []string
+}
+
+func (f *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	f.lookups = append(f.lookups, name)
+	return f.records, f.err
+}
+
+type failingSource struct{}
+
+func (failingSource) Match(ctx context.Context, domains []string) (emailpolicy.Action, bool, error) {
+	return "", false, errors.New("rules unavailable")
+}
+
+func TestNormalize(t *testing.T) {
+	valid := map[string]string{
+		"jane@example.com":            "jane@example.com",
+		"  Jane.Doe@Example.COM ":     "jane.doe@example.com",
+		"jane+news@example.com":       "jane@example.com",
+		"jane+news+more@Mail.Example": "jane@mail.example",
+	}
+	for input, want := range valid {
+		got, err := emailpolicy.Normalize(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	invalid := []string{
+		"",
+		"jane",
+		"jane@localhost",
+		"+news@example.com",
+		"Jane <jane@example.com>",
+		`"jane doe"@example.com`,
+		"jane@@example.com",
+		strings.Repeat("a", 250) + "@example.com",
+	}
+	for _, input := range invalid {
+		_, err := emailpolicy.Normalize(input)
+		require.ErrorIs(t, err, emailpolicy.ErrInvalidEmail, input)
+		require.ErrorIs(t, err, qqerrors.ErrValidationError, input)
+	}
+}
+
+func TestPolicy_Rules(t *testing.T) {
+	ctx := context.Background()
+	rules := emailpolicy.StaticRules{}
+	rules.Add(emailpolicy.ActionDeny, "blocked.example")
+	rules.Add(emailpolicy.ActionAllow, "ok.blocked.example")
+	allowDisposable := emailpolicy.StaticRules{}
+	allowDisposable.Add(emailpolicy.ActionAllow, "yopmail.com")
+
+	policy := emailpolicy.NewPolicy([]emailpolicy.RuleSource{rules, emailpolicy.DisposableRules()}, nil)
+	require.NoError(t, policy.Allow(ctx, "jane@example.com"))
+	require.ErrorIs(t, policy.Allow(ctx, "jane@blocked.example"), emailpolicy.ErrDomainNotAllowed)
+	require.ErrorIs(t, policy.Allow(ctx, "jane@mx.blocked.example"), emailpolicy.ErrDomainNotAllowed,
+		"subdomains inherit the rule")
+	require.NoError(t, policy.Allow(ctx, "jane@ok.blocked.example"), "the most specific rule wins")
+	require.ErrorIs(t, policy.Allow(ctx, "Jane+x@MAILINATOR.com"), emailpolicy.ErrDomainNotAllowed)
+	require.ErrorIs(t, policy.Allow(ctx, "jane"), emailpolicy.ErrInvalidEmail)
+
+	policy = emailpolicy.NewPolicy([]emailpolicy.RuleSource{emailpolicy.DisposableRules(), allowDisposable}, nil)
+	require.NoError(t, policy.Allow(ctx, "jane@yopmail.com"), "an allow rule in any source wins")
+
+	policy = emailpolicy.NewPolicy([]emailpolicy.RuleSource{failingSource{}}, nil)
+	require.Error(t, policy.Allow(ctx, "jane@example.com"))
+}
+
+func TestPolicy_MX(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		resolver *fakeResolver
+		allowed  bool
+	}{
+		{"Mail exchanger", &fakeResolver{records: []*net.MX{{Host: "mx.example.com.", Pref: 10}}}, true},
+		{"No records", &fakeResolver{}, false},
+		{"Null MX", &fakeResolver{records: []*net.MX{{Host: ".", Pref: 0}}}, false},
+		{"Unknown domain", &fakeResolver{err: &net.DNSError{Err: "no such host", IsNotFound: true}}, false},
+		{"Resolver outage", &fakeResolver{err: &net.DNSError{Err: "timeout", IsTimeout: true}}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := emailpolicy.NewPolicy(nil, tc.resolver).Allow(ctx, "jane+x@Example.com")
+			if tc.allowed {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, emailpolicy.ErrDomainNotAllowed)
+			}
+			assert.Equal(t, []string{"example.com"}, tc.resolver.lookups)
+		})
+	}
+
+	t.Run("Allowlisted domains skip the lookup", func(t *testing.T) {
+		rules := emailpolicy.StaticRules{}
+		rules.Add(emailpolicy.ActionAllow, "example.com")
+		resolver := &fakeResolver{}
+		require.NoError(t, emailpolicy.NewPolicy([]emailpolicy.RuleSource{rules}, resolver).Allow(ctx, "a@example.com"))
+		assert.Empty(t, resolver.lookups)
+	})
+
+	t.Run("Denied domains skip the lookup", func(t *testing.T) {
+		resolver := &fakeResolver{records: []*net.MX{{Host: "mx.yopmail.com."}}}
+		policy := emailpolicy.NewPolicy([]emailpolicy.RuleSource{emailpolicy.DisposableRules()}, resolver)
+		require.ErrorIs(t, policy.Allow(ctx, "a@yopmail.com"), emailpolicy.ErrDomainNotAllowed)
+		assert.Empty(t, resolver.lookups)
+	})
+}
+
+func TestLoadRuleFiles(t *testing.T) {
+	dir := t.TempDir()
+	allowPath := filepath.Join(dir, "allow.txt")
+	denyPath := filepath.Join(dir, "deny.txt")
+	require.NoError(t, os.WriteFile(allowPath, []byte("# partners\nPartner.Example\nboth.example\n"), 0o600))
+	require.NoError(t, os.WriteFile(denyPath, []byte("spam.example # reported\n\nboth.example\n"), 0o600))
+
+	rules, err := emailpolicy.LoadRuleFiles(allowPath, denyPath)
+	require.NoError(t, err)
+	assert.Equal(t, emailpolicy.StaticRules{
+		"partner.example": emailpolicy.ActionAllow,
+		"spam.example":    emailpolicy.ActionDeny,
+		"both.example":    emailpolicy.ActionAllow,
+	}, rules)
+
+	rules, err = emailpolicy.LoadRuleFiles("", "")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	_, err = emailpolicy.LoadRuleFiles(filepath.Join(dir, "missing.txt"), "")
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(denyPath, []byte("user@spam.example\n"), 0o600))
+	_, err = emailpolicy.LoadRuleFiles("", denyPath)
+	require.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package emailpolicy_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func newPostgresPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image: "postgres:16-alpine",
+			Env: map[string]string{
+				"POSTGRES_USER":     "postgres",
+				"POSTGRES_PASSWORD": "postgres",
+				"POSTGRES_DB":       "qq_db_test",
+			},
+			ExposedPorts: []string{"5432/tcp"},
+			WaitingFor:   wait.ForListeningPort("5432/tcp").WithStartupTimeout(90 * time.Second),
+			AutoRemove:   true,
+		},
+		Started: true,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "docker") {
+			t.Skipf("skipping integration tests: %v", err)
+		}
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = container.Terminate(context.Background())
+	})
+
+	host, err := container.Host(ctx)
+	require.NoError(t, err)
+	port, err := container.MappedPort(ctx, "5432/tcp")
+	require.NoError(t, err)
+
+	dsn := fmt.Sprintf("postgres://postgres:postgres@%s/qq_db_test?sslmode=disable", net.JoinHostPort(host, port.Port()))
+	pool, err := pgxpool.New(context.Background(), dsn)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	require.NoError(t, pool.Ping(ctx))
+
+	applyMigrations(t, pool)
+	return pool
+}
+
+func applyMigrations(t *testing.T, pool *pgxpool.Pool) {
+	t.Helper()
+	_, file, _, ok := runtime.Caller(0)
+	require.True(t, ok)
+	root := filepath.Clean(filepath.Join(filepath.Dir(file), "../../../.."))
+
+	files, err := filepath.Glob(filepath.Join(root, "db", "migrations", "*.up.sql"))
+	require.NoError(t, err)
+	sort.Strings(files)
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		require.NoErrorf(t, err, "failed to read migration %s", file)
+		for _, stmt := range strings.Split(string(contents), ";") {
+			if stmt = strings.TrimSpace(stmt); stmt == "" {
+				continue
+			}
+			_, err := pool.Exec(context.Background(), stmt)
+			require.NoErrorf(t, err, "failed executing migration %s", file)
+		}
+	}
+}
+
+func TestPostgresRules(t *testing.T) {
+	pool := newPostgresPool(t)
+	ctx := context.Background()
+
+	_, err := pool.Exec(ctx, `INSERT INTO email_domain_rules (domain, action, note) VALUES
+		('blocked.example', 'deny', 'abuse'),
+		('ok.blocked.example', 'allow', NULL)`)
+	require.NoError(t, err)
+
+	rules := emailpolicy.NewPostgresRules(pool)
+	action, ok, err := rules.Match(ctx, []string{"mx.blocked.example", "blocked.example"})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, emailpolicy.ActionDeny, action)
+
+	action, ok, err = rules.Match(ctx, []string{"ok.blocked.example", "blocked.example"})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, emailpolicy.ActionAllow, action, "the most specific rule wins")
+
+	_, ok, err = rules.Match(ctx, []string{"example.com"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	policy := emailpolicy.NewPolicy([]emailpolicy.RuleSource{rules}, nil)
+	require.ErrorIs(t, policy.Allow(ctx, "jane@blocked.example"), emailpolicy.ErrDomainNotAllowed)
+	require.NoError(t, policy.Allow(ctx, "jane@ok.blocked.example"))
+}
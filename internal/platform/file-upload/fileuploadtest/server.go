@@ -0,0 +1,117 @@
+// Package fileuploadtest provides an in-memory S3-compatible server for tests
+// of code that stores files through fileupload.R2Service.
+package fileuploadtest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+)
+
+// Object is a stored file.
+type Object struct {
+	Data        []byte
+	ContentType string
+}
+
+// Server answers the path-style PUT, GET, HEAD and DELETE object requests the
+// uploader makes. Signatures are not checked, so presigned URLs can be fetched
+// from it as they are.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]Object
+}
+
+// NewServer starts a server; callers should Close it when done.
+func NewServer() *Server {
+	s := &Server{objects: make(map[string]Object)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Environment returns an R2 configuration that points at the server.
+func (s *Server) Environment(bucket string) environment.R2Environment {
+	return environment.R2Environment{
+		BucketName:      bucket,
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		AccountID:       "test",
+		Endpoint:        s.URL,
+	}
+}
+
+// Object returns the object stored under key in bucket.
+func (s *Server) Object(bucket, key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[bucket+"/"+key]
+	return object, ok
+}
+
+// Keys lists the keys stored in bucket, sorted.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for path := range s.objects {
+		if key, ok := strings.CutPrefix(path, bucket+"/"); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.Contains(path, "/") {
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.mu.Lock()
+		s.objects[path] = Object{Data: data, ContentType: r.Header.Get("Content-Type")}
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		s.mu.Lock()
+		object, ok := s.objects[path]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", object.ContentType)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object.Data)
+		}
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, path)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, "<Error><Code>"+code+"</Code></Error>")
+}
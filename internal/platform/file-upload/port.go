@@ -2,12 +2,35 @@ package fileupload
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"time"
+
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 )
 
-// Uploader provides the contract for persisting files and retrieving signed URLs.
+// ErrFileNotFound is returned by GetFile for a key with no object.
+var ErrFileNotFound = fmt.Errorf("file %w", qqerrors.ErrNotFound)
+
+// Uploader provides the contract for persisting, deleting and retrieving signed URLs of files.
+// UploadFile processes an image and stores it under a generated key; PutFile and
+// GetFile store and read any object as is. UploadRenditions stores one processed
+// image per rendition under a generated prefix, each at RenditionKey(prefix, name).
+// Before renditions, images were stored as one object; DeleteRenditions removes
+// one at prefix too, and FileExists tells the two layouts apart.
 type Uploader interface {
 	UploadFile(ctx context.Context, file io.Reader) (*string, error)
+	UploadRenditions(ctx context.Context, file io.Reader, renditions []imageprocess.Rendition) (*string, error)
+	DeleteRenditions(ctx context.Context, prefix string, renditions []imageprocess.Rendition) error
+	PutFile(ctx context.Context, key string, file io.Reader, contentType string) error
+	GetFile(ctx context.Context, key string) (io.ReadCloser, error)
+	FileExists(ctx context.Context, key string) (bool, error)
 	GetSignedURL(ctx context.Context, key string, expires time.Duration) (*string, error)
+	DeleteFile(ctx context.Context, key string) error
+}
+
+// RenditionKey is the object key of the named rendition stored under prefix.
+func RenditionKey(prefix, name string) string {
+	return prefix + "/" + name
 }
@@ -3,6 +3,7 @@ package fileupload
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -14,6 +15,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 )
 
@@ -38,11 +40,16 @@ func NewR2Service(environment environment.R2Environment, processor imageprocess.
 	}
 
 	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if environment.Endpoint != "" {
+			o.BaseEndpoint = aws.String(environment.Endpoint)
+			o.UsePathStyle = true
+			return
+		}
 		o.BaseEndpoint = aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID))
 	})
 
 	if processor == nil {
-		processor = imageprocess.NewWebpProcessor()
+		processor = imageprocess.NewProcessor()
 	}
 
 	return &R2Service{
@@ -57,7 +64,7 @@ func (s *R2Service) UploadFile(ctx context.Context, file io.Reader) (*string, er
 	if err != nil {
 		return nil, err
 	}
-	key := fmt.Sprintf("%s-%s", uuid.New().String(), time.Now().Format("2006-01-02"))
+	key := newKey()
 	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(s.environment.BucketName),
 		Key:         aws.String(key),
@@ -71,13 +78,92 @@ func (s *R2Service) UploadFile(ctx context.Context, file io.Reader) (*string, er
 	return &key, nil
 }
 
+// UploadRenditions processes file into the given renditions and stores them
+// under a new prefix, which it returns. When one cannot be stored, the ones
+// already stored are deleted again.
+func (s *R2Service) UploadRenditions(
+	ctx context.Context, file io.Reader, renditions []imageprocess.Rendition) (*string, error) {
+	images, err := s.processor.RenditionProcessor(ctx, file, renditions)
+	if err != nil {
+		return nil, err
+	}
+	prefix := newKey()
+	for _, rendition := range renditions {
+		image, ok := images[rendition.Name]
+		if !ok {
+			err = fmt.Errorf("processor returned no %q rendition", rendition.Name)
+		} else {
+			err = s.PutFile(ctx, RenditionKey(prefix, rendition.Name), bytes.NewReader(image.Data), image.MimeType)
+		}
+		if err != nil {
+			return nil, errors.Join(err, s.DeleteRenditions(ctx, prefix, renditions))
+		}
+	}
+	return &prefix, nil
+}
+
+// DeleteRenditions deletes every rendition stored under prefix, and the single
+// image stored at prefix itself before there were renditions. Objects that do
+// not exist are not an error.
+func (s *R2Service) DeleteRenditions(ctx context.Context, prefix string, renditions []imageprocess.Rendition) error {
+	var errs []error
+	if err := s.DeleteFile(ctx, prefix); err != nil {
+		errs = append(errs, err)
+	}
+	for _, rendition := range renditions {
+		if err := s.DeleteFile(ctx, RenditionKey(prefix, rendition.Name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *R2Service) PutFile(ctx context.Context, key string, file io.Reader, contentType string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.environment.BucketName),
+		Key:         aws.String(key),
+		Body:        file,
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (s *R2Service) GetFile(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.environment.BucketName),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, fmt.Errorf("%w: %s: %w", ErrFileNotFound, key, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+func (s *R2Service) FileExists(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.environment.BucketName),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (s *R2Service) GetSignedURL(ctx context.Context, key string, expires time.Duration) (*string, error) {
 	presignClient := s3.NewPresignClient(s.client)
 	presignResult, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
-		ResponseExpires: aws.Time(time.Now().Add(expires)),
-		Bucket:          aws.String(s.environment.BucketName),
-		Key:             aws.String(key),
-	})
+		Bucket: aws.String(s.environment.BucketName),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
 	if err != nil {
 		return nil, err
 	}
@@ -91,3 +177,7 @@ func (s *R2Service) DeleteFile(ctx context.Context, key string) error {
 	})
 	return err
 }
+
+func newKey() string {
+	return fmt.Sprintf("%s-%s", uuid.New().String(), time.Now().Format("2006-01-02"))
+}
@@ -0,0 +1,37 @@
+//go:build !cgo
+
+package fileupload_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	"github.com/abdurrahimagca/qq-back/internal/platform/file-upload/fileuploadtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestR2Service_DefaultProcessorWithoutCgo(t *testing.T) {
+	server := fileuploadtest.NewServer()
+	defer server.Close()
+	svc := fileupload.NewR2Service(server.Environment("test-bucket"), nil)
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, img))
+
+	key, err := svc.UploadFile(context.Background(), buf)
+	require.NoError(t, err, "the pure Go processor stands in for WebP")
+	object, ok := server.Object("test-bucket", *key)
+	require.True(t, ok)
+	assert.Equal(t, "image/jpeg", object.ContentType)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
@@ -11,6 +12,9 @@ import (
 	"github.com/abdurrahimagca/qq-back/internal/environment"
 	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
 	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	"github.com/abdurrahimagca/qq-back/internal/platform/file-upload/fileuploadtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type failingProcessor struct{}
@@ -19,6 +23,12 @@ func (f *failingProcessor) ImageProcessor(ctx context.Context, r io.Reader) (*im
 	return nil, errors.New("processor failed")
 }
 
+func (f *failingProcessor) RenditionProcessor(
+	ctx context.Context, r io.Reader, renditions []imageprocess.Rendition,
+) (map[string]*imageprocess.ProcessedImage, error) {
+	return nil, errors.New("processor failed")
+}
+
 func TestGetSignedURL_ReturnsURL(t *testing.T) {
 	env := environment.R2Environment{
 		BucketName:      "test-bucket",
@@ -41,6 +51,9 @@ func TestGetSignedURL_ReturnsURL(t *testing.T) {
 	if !strings.Contains(*url, "test-key") {
 		t.Fatalf("signed URL does not contain key: %s", *url)
 	}
+	if !strings.Contains(*url, "X-Amz-Expires=60") {
+		t.Fatalf("signed URL is not valid for the requested minute: %s", *url)
+	}
 }
 
 func TestUploadFile_ProcessorError(t *testing.T) {
@@ -63,3 +76,106 @@ func TestUploadFile_ProcessorError(t *testing.T) {
 		t.Fatalf("expected nil key when processor fails, got %v", *key)
 	}
 }
+
+type passthroughProcessor struct{}
+
+func (p *passthroughProcessor) ImageProcessor(ctx context.Context, r io.Reader) (*imageprocess.ProcessedImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &imageprocess.ProcessedImage{Data: data, MimeType: "image/webp"}, nil
+}
+
+// RenditionProcessor tags the input with each rendition's name.
+func (p *passthroughProcessor) RenditionProcessor(
+	ctx context.Context, r io.Reader, renditions []imageprocess.Rendition,
+) (map[string]*imageprocess.ProcessedImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string]*imageprocess.ProcessedImage, len(renditions))
+	for _, rendition := range renditions {
+		images[rendition.Name] = &imageprocess.ProcessedImage{
+			Data:     append([]byte(rendition.Name+":"), data...),
+			MimeType: "image/webp",
+		}
+	}
+	return images, nil
+}
+
+func TestR2Service_LocalEndpoint(t *testing.T) {
+	server := fileuploadtest.NewServer()
+	defer server.Close()
+	svc := fileupload.NewR2Service(server.Environment("test-bucket"), &passthroughProcessor{})
+	ctx := context.Background()
+
+	key, err := svc.UploadFile(ctx, strings.NewReader("image"))
+	require.NoError(t, err)
+	object, ok := server.Object("test-bucket", *key)
+	require.True(t, ok, "UploadFile stores the processed image")
+	assert.Equal(t, "image", string(object.Data))
+	assert.Equal(t, "image/webp", object.ContentType)
+
+	require.NoError(t, svc.PutFile(ctx, "exports/archive.zip", strings.NewReader("zip"), "application/zip"))
+	file, err := svc.GetFile(ctx, "exports/archive.zip")
+	require.NoError(t, err)
+	data, err := io.ReadAll(file)
+	require.NoError(t, file.Close())
+	require.NoError(t, err)
+	assert.Equal(t, "zip", string(data))
+
+	signed, err := svc.GetSignedURL(ctx, *key, time.Minute)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(*signed, server.URL+"/test-bucket/"), "Objects are addressed by path: %s", *signed)
+	resp, err := http.Get(*signed)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, svc.DeleteFile(ctx, *key))
+	assert.Equal(t, []string{"exports/archive.zip"}, server.Keys("test-bucket"))
+	_, err = svc.GetFile(ctx, *key)
+	assert.ErrorIs(t, err, fileupload.ErrFileNotFound, "Deleted objects cannot be read")
+}
+
+func TestR2Service_Renditions(t *testing.T) {
+	server := fileuploadtest.NewServer()
+	defer server.Close()
+	svc := fileupload.NewR2Service(server.Environment("test-bucket"), &passthroughProcessor{})
+	ctx := context.Background()
+
+	prefix, err := svc.UploadRenditions(ctx, strings.NewReader("image"), imageprocess.AvatarRenditions)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		fileupload.RenditionKey(*prefix, "large"),
+		fileupload.RenditionKey(*prefix, "medium"),
+		fileupload.RenditionKey(*prefix, "small"),
+	}, server.Keys("test-bucket"))
+	object, _ := server.Object("test-bucket", *prefix+"/small")
+	assert.Equal(t, "small:image", string(object.Data))
+	assert.Equal(t, "image/webp", object.ContentType)
+	exists, err := svc.FileExists(ctx, fileupload.RenditionKey(*prefix, "large"))
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = svc.FileExists(ctx, *prefix)
+	require.NoError(t, err)
+	assert.False(t, exists, "The prefix itself is not an object")
+
+	require.NoError(t, svc.DeleteRenditions(ctx, *prefix, imageprocess.AvatarRenditions))
+	assert.Empty(t, server.Keys("test-bucket"))
+	require.NoError(t, svc.DeleteRenditions(ctx, *prefix, imageprocess.AvatarRenditions),
+		"Deleting renditions that are gone is not an error")
+
+	single, err := svc.UploadFile(ctx, strings.NewReader("image"))
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteRenditions(ctx, *single, imageprocess.AvatarRenditions))
+	assert.Empty(t, server.Keys("test-bucket"), "An image stored before renditions is deleted too")
+
+	failing := fileupload.NewR2Service(server.Environment("test-bucket"), &failingProcessor{})
+	prefix, err = failing.UploadRenditions(ctx, strings.NewReader("image"), imageprocess.AvatarRenditions)
+	require.Error(t, err)
+	assert.Nil(t, prefix)
+	assert.Empty(t, server.Keys("test-bucket"))
+}
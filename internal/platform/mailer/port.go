@@ -2,6 +2,9 @@ package mailer
 
 import "context"
 
+// DefaultFrom is the address application emails are sent from.
+const DefaultFrom = "qq@homelab-kaleici.space"
+
 // SendParams captures the information needed to send an email.
 type SendParams struct {
 	To      string
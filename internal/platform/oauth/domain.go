@@ -0,0 +1,14 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+)
+
+var (
+	ErrInvalidIDToken   = fmt.Errorf("invalid id token: %w", qqerrors.ErrUnauthorized)
+	ErrEmailNotVerified = fmt.Errorf("identity provider email is not verified: %w", qqerrors.ErrUnauthorized)
+	ErrUnknownKeyID     = errors.New("unknown key id")
+)
@@ -0,0 +1,79 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+type googleClaims struct {
+	jwt.RegisteredClaims
+
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+type googleVerifier struct {
+	clientID string
+	keySet   KeySet
+}
+
+// NewGoogleVerifier returns a Verifier for Google ID tokens. When keySet is nil
+// the keys are fetched from the JWKS URL configured in the environment.
+func NewGoogleVerifier(conf environment.GoogleEnvironment, keySet KeySet) Verifier {
+	if keySet == nil {
+		keySet = NewRemoteKeySet(conf.JWKSURL, nil)
+	}
+	return &googleVerifier{
+		clientID: conf.ClientID,
+		keySet:   keySet,
+	}
+}
+
+func (v *googleVerifier) VerifyIDToken(ctx context.Context, idToken string) (*Identity, error) {
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if v.clientID == "" {
+		return nil, errors.New("google client id is not configured")
+	}
+
+	claims := &googleClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.keySet.PublicKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithAudience(v.clientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	if !slices.Contains(googleIssuers, claims.Issuer) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	if claims.Subject == "" || claims.Email == "" {
+		return nil, fmt.Errorf("%w: missing subject or email", ErrInvalidIDToken)
+	}
+	if !claims.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
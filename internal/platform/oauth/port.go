@@ -0,0 +1,24 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+)
+
+// Identity is the verified subset of an ID token issued by an external identity provider.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Verifier validates ID tokens issued by an external identity provider.
+type Verifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*Identity, error)
+}
+
+// KeySet resolves the public keys used to check ID token signatures.
+type KeySet interface {
+	PublicKey(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
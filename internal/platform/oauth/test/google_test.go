@@ -0,0 +1,121 @@
+package oauth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/oauth"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestVerifier(s *jwksServer) oauth.Verifier {
+	return oauth.NewGoogleVerifier(environment.GoogleEnvironment{
+		ClientID: testClientID,
+		JWKSURL:  s.URL,
+	}, nil)
+}
+
+func TestGoogleVerifier_ValidToken(t *testing.T) {
+	s := newJWKSServer(t)
+	verifier := newTestVerifier(s)
+
+	identity, err := verifier.VerifyIDToken(context.Background(), signRS256(t, s.key, testKeyID, googleClaims()))
+	require.NoError(t, err)
+	assert.Equal(t, "google-subject-1", identity.Subject)
+	assert.Equal(t, "user@example.com", identity.Email)
+	assert.True(t, identity.EmailVerified)
+	assert.Equal(t, "Test User", identity.Name)
+}
+
+func TestGoogleVerifier_BareIssuerAccepted(t *testing.T) {
+	s := newJWKSServer(t)
+	verifier := newTestVerifier(s)
+
+	claims := googleClaims()
+	claims["iss"] = "accounts.google.com"
+
+	_, err := verifier.VerifyIDToken(context.Background(), signRS256(t, s.key, testKeyID, claims))
+	require.NoError(t, err)
+}
+
+func TestGoogleVerifier_KeysAreCached(t *testing.T) {
+	s := newJWKSServer(t)
+	verifier := newTestVerifier(s)
+
+	for range 3 {
+		_, err := verifier.VerifyIDToken(context.Background(), signRS256(t, s.key, testKeyID, googleClaims()))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), s.fetches.Load())
+}
+
+func TestGoogleVerifier_Rejections(t *testing.T) {
+	s := newJWKSServer(t)
+	verifier := newTestVerifier(s)
+
+	tests := []struct {
+		name   string
+		mutate func(jwt.MapClaims)
+		kid    string
+	}{
+		{name: "wrong audience", mutate: func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{name: "wrong issuer", mutate: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", mutate: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "missing expiry", mutate: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "missing subject", mutate: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "unknown kid", mutate: func(jwt.MapClaims) {}, kid: "rotated-away"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := googleClaims()
+			tt.mutate(claims)
+			kid := tt.kid
+			if kid == "" {
+				kid = testKeyID
+			}
+
+			identity, err := verifier.VerifyIDToken(context.Background(), signRS256(t, s.key, kid, claims))
+			require.Nil(t, identity)
+			require.ErrorIs(t, err, oauth.ErrInvalidIDToken)
+			assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+		})
+	}
+}
+
+func TestGoogleVerifier_UnverifiedEmail(t *testing.T) {
+	s := newJWKSServer(t)
+	verifier := newTestVerifier(s)
+
+	claims := googleClaims()
+	claims["email_verified"] = false
+
+	_, err := verifier.VerifyIDToken(context.Background(), signRS256(t, s.key, testKeyID, claims))
+	require.ErrorIs(t, err, oauth.ErrEmailNotVerified)
+}
+
+func TestGoogleVerifier_RejectsHS256(t *testing.T) {
+	s := newJWKSServer(t)
+	verifier := newTestVerifier(s)
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, googleClaims())
+	tok.Header["kid"] = testKeyID
+	signed, err := tok.SignedString([]byte("shared-secret"))
+	require.NoError(t, err)
+
+	_, err = verifier.VerifyIDToken(context.Background(), signed)
+	require.ErrorIs(t, err, oauth.ErrInvalidIDToken)
+}
+
+func TestGoogleVerifier_MissingClientID(t *testing.T) {
+	s := newJWKSServer(t)
+	verifier := oauth.NewGoogleVerifier(environment.GoogleEnvironment{JWKSURL: s.URL}, nil)
+
+	_, err := verifier.VerifyIDToken(context.Background(), signRS256(t, s.key, testKeyID, googleClaims()))
+	require.Error(t, err)
+}
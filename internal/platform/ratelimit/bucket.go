@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// bucket is the state both backends keep per key.
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newBucket(limit Limit, now time.Time) bucket {
+	return bucket{tokens: float64(limit.Burst), updatedAt: now}
+}
+
+// take refills the bucket for the time passed since its last update and then
+// removes one token if there is one.
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	rate := float64(limit.Burst) / limit.Period.Seconds()
+	if elapsed := now.Sub(b.updatedAt).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*rate)
+		b.updatedAt = now
+	}
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / rate
+		return Result{RetryAfter: time.Duration(math.Ceil(wait * float64(time.Second)))}
+	}
+	b.tokens--
+	return Result{Allowed: true, Remaining: int(b.tokens)}
+}
+
+// fullAt is when the bucket has refilled completely and can be forgotten, as a
+// new bucket would be in the same state.
+func (b *bucket) fullAt(limit Limit) time.Time {
+	missing := float64(limit.Burst) - b.tokens
+	return b.updatedAt.Add(time.Duration(missing / float64(limit.Burst) * float64(limit.Period)))
+}
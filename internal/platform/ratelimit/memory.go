@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	memoryMaxBuckets = 100000
+	// memoryEvictFraction is the share of buckets, as 1/n, dropped when the map
+	// is full of buckets still in use.
+	memoryEvictFraction = 10
+)
+
+type memoryLimiter struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	bucket
+	expiresAt time.Time
+}
+
+// NewMemoryLimiter keeps buckets in process memory, which suits a single
+// instance. A nil clock uses time.Now.
+func NewMemoryLimiter(clock func() time.Time) Limiter {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &memoryLimiter{
+		now:     clock,
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+func (l *memoryLimiter) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		l.evict(now)
+		b = &memoryBucket{bucket: newBucket(limit, now)}
+		l.buckets[key] = b
+	}
+	result := b.take(limit, now)
+	b.expiresAt = b.fullAt(limit)
+	return result, nil
+}
+
+// evict drops full buckets once the map reaches its cap. If that is not
+// enough, the least recently used tenth goes, so a flood of new keys cannot
+// reset the budgets that are in use.
+func (l *memoryLimiter) evict(now time.Time) {
+	if len(l.buckets) < memoryMaxBuckets {
+		return
+	}
+	for key, b := range l.buckets {
+		if !now.Before(b.expiresAt) {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) < memoryMaxBuckets {
+		return
+	}
+
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return l.buckets[a].updatedAt.Compare(l.buckets[b].updatedAt)
+	})
+	for _, key := range keys[:len(keys)/memoryEvictFraction] {
+		delete(l.buckets, key)
+	}
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+)
+
+var ErrRateLimited = fmt.Errorf("rate limit exceeded: %w", qqerrors.ErrTooManyRequests)
+
+// Limit is a token bucket holding Burst tokens that refill evenly over Period.
+type Limit = environment.RateLimit
+
+// Result reports the outcome of taking a token. RetryAfter is set when the
+// request was refused and says when the next token becomes available.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter keeps one token bucket per key.
+type Limiter interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Rule names a budget and the subject it is counted for, e.g. an email
+// address or an IP. Rules with the same name and subject share a bucket.
+type Rule struct {
+	Name    string
+	Subject string
+	Limit   Limit
+}
+
+// maxKeyLength is the size of rate_limit_buckets.key.
+const maxKeyLength = 255
+
+// key is Name and Subject, with Subject hashed when the two would not fit the
+// Postgres column, so a long subject still gets its own bucket.
+func (r Rule) key() string {
+	key := r.Name + ":" + r.Subject
+	if len(key) <= maxKeyLength {
+		return key
+	}
+	sum := sha256.Sum256([]byte(r.Subject))
+	return r.Name + ":sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Enforce takes a token from every rule in order and stops at the first
+// refusal, so a request rejected by a narrow budget does not use up the wider
+// ones listed after it. The refusal is a qqerrors.RetryAfterError wrapping
+// ErrRateLimited.
+func Enforce(ctx context.Context, limiter Limiter, rules ...Rule) error {
+	for _, rule := range rules {
+		result, err := limiter.Take(ctx, rule.key(), rule.Limit)
+		if err != nil {
+			return err
+		}
+		if !result.Allowed {
+			return &qqerrors.RetryAfterError{
+				Err:        fmt.Errorf("%w: %s", ErrRateLimited, rule.Name),
+				RetryAfter: result.RetryAfter,
+			}
+		}
+	}
+	return nil
+}
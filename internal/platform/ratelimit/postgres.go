@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresSweepInterval spaces out the deletion of buckets that have refilled.
+const postgresSweepInterval = time.Minute
+
+type postgresLimiter struct {
+	pool *pgxpool.Pool
+	now  func() time.Time
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewPostgresLimiter keeps buckets in the rate_limit_buckets table so every
+// instance draws from the same budgets. The row is locked while a token is
+// taken. A nil clock uses time.Now.
+func NewPostgresLimiter(pool *pgxpool.Pool, clock func() time.Time) Limiter {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &postgresLimiter{pool: pool, now: clock}
+}
+
+func (l *postgresLimiter) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := l.now().UTC()
+	if err := l.sweep(ctx, now); err != nil {
+		return Result{}, err
+	}
+
+	tx, err := l.pool.Begin(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	q := db.New(tx)
+
+	fresh := newBucket(limit, now)
+	err = q.InsertRateLimitBucket(ctx, db.InsertRateLimitBucketParams{
+		Key:       key,
+		Tokens:    fresh.tokens,
+		UpdatedAt: timestamp(fresh.updatedAt),
+		ExpiresAt: timestamp(fresh.fullAt(limit)),
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	row, err := q.GetRateLimitBucketForUpdate(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+
+	b := bucket{tokens: row.Tokens, updatedAt: row.UpdatedAt.Time}
+	result := b.take(limit, now)
+	err = q.UpdateRateLimitBucket(ctx, db.UpdateRateLimitBucketParams{
+		Key:       key,
+		Tokens:    b.tokens,
+		UpdatedAt: timestamp(b.updatedAt),
+		ExpiresAt: timestamp(b.fullAt(limit)),
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
+
+// sweep deletes refilled buckets at most once per interval per instance.
+func (l *postgresLimiter) sweep(ctx context.Context, now time.Time) error {
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) < postgresSweepInterval {
+		l.mu.Unlock()
+		return nil
+	}
+	l.lastSweep = now
+	l.mu.Unlock()
+
+	return db.New(l.pool).DeleteExpiredRateLimitBuckets(ctx, timestamp(now))
+}
+
+func timestamp(t time.Time) pgtype.Timestamp {
+	return pgtype.Timestamp{Time: t, Valid: true}
+}
@@ -0,0 +1,130 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/platform/ratelimit"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func newPostgresPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image: "postgres:16-alpine",
+			Env: map[string]string{
+				"POSTGRES_USER":     "postgres",
+				"POSTGRES_PASSWORD": "postgres",
+				"POSTGRES_DB":       "qq_db_test",
+			},
+			ExposedPorts: []string{"5432/tcp"},
+			WaitingFor:   wait.ForListeningPort("5432/tcp").WithStartupTimeout(90 * time.Second),
+			AutoRemove:   true,
+		},
+		Started: true,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "docker") {
+			t.Skipf("skipping integration tests: %v", err)
+		}
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = container.Terminate(context.Background())
+	})
+
+	host, err := container.Host(ctx)
+	require.NoError(t, err)
+	port, err := container.MappedPort(ctx, "5432/tcp")
+	require.NoError(t, err)
+
+	dsn := fmt.Sprintf("postgres://postgres:postgres@%s/qq_db_test?sslmode=disable", net.JoinHostPort(host, port.Port()))
+	pool, err := pgxpool.New(context.Background(), dsn)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	require.NoError(t, pool.Ping(ctx))
+
+	applyMigrations(t, pool)
+	return pool
+}
+
+func applyMigrations(t *testing.T, pool *pgxpool.Pool) {
+	t.Helper()
+	_, file, _, ok := runtime.Caller(0)
+	require.True(t, ok)
+	root := filepath.Clean(filepath.Join(filepath.Dir(file), "../../../.."))
+
+	files, err := filepath.Glob(filepath.Join(root, "db", "migrations", "*.up.sql"))
+	require.NoError(t, err)
+	sort.Strings(files)
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		require.NoErrorf(t, err, "failed to read migration %s", file)
+		for _, stmt := range strings.Split(string(contents), ";") {
+			if stmt = strings.TrimSpace(stmt); stmt == "" {
+				continue
+			}
+			_, err := pool.Exec(context.Background(), stmt)
+			require.NoErrorf(t, err, "failed executing migration %s", file)
+		}
+	}
+}
+
+func TestPostgresLimiter(t *testing.T) {
+	pool := newPostgresPool(t)
+
+	testLimiterBehaviour(t, func(clock func() time.Time) ratelimit.Limiter {
+		return ratelimit.NewPostgresLimiter(pool, clock)
+	})
+
+	t.Run("Instances share buckets", func(t *testing.T) {
+		clock := newFakeClock()
+		limit := ratelimit.Limit{Burst: 2, Period: time.Hour}
+		first := ratelimit.NewPostgresLimiter(pool, clock.Now)
+		second := ratelimit.NewPostgresLimiter(pool, clock.Now)
+
+		for _, limiter := range []ratelimit.Limiter{first, second} {
+			result, err := limiter.Take(context.Background(), "shared", limit)
+			require.NoError(t, err)
+			require.True(t, result.Allowed)
+		}
+		result, err := first.Take(context.Background(), "shared", limit)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+	})
+
+	t.Run("Refilled buckets are swept", func(t *testing.T) {
+		clock := newFakeClock()
+		limiter := ratelimit.NewPostgresLimiter(pool, clock.Now)
+		_, err := limiter.Take(context.Background(), "swept", ratelimit.Limit{Burst: 1, Period: time.Minute})
+		require.NoError(t, err)
+
+		clock.Advance(2 * time.Minute)
+		_, err = limiter.Take(context.Background(), "other", ratelimit.Limit{Burst: 1, Period: time.Minute})
+		require.NoError(t, err)
+
+		var count int
+		err = pool.QueryRow(context.Background(),
+			"SELECT count(*) FROM rate_limit_buckets WHERE key = 'swept'").Scan(&count)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+}
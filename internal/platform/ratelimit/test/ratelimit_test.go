@@ -0,0 +1,230 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/platform/ratelimit"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testLimiterBehaviour runs the same bucket checks against every backend.
+func testLimiterBehaviour(t *testing.T, newLimiter func(clock func() time.Time) ratelimit.Limiter) {
+	ctx := context.Background()
+	limit := ratelimit.Limit{Burst: 3, Period: time.Minute}
+
+	t.Run("Burst then refill", func(t *testing.T) {
+		clock := newFakeClock()
+		limiter := newLimiter(clock.Now)
+
+		for remaining := 2; remaining >= 0; remaining-- {
+			result, err := limiter.Take(ctx, "burst", limit)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, remaining, result.Remaining)
+		}
+
+		result, err := limiter.Take(ctx, "burst", limit)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 20*time.Second, result.RetryAfter, "One token refills every period/burst")
+
+		clock.Advance(10 * time.Second)
+		result, err = limiter.Take(ctx, "burst", limit)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 10*time.Second, result.RetryAfter)
+
+		clock.Advance(10 * time.Second)
+		result, err = limiter.Take(ctx, "burst", limit)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+
+	t.Run("Refill is capped at burst", func(t *testing.T) {
+		clock := newFakeClock()
+		limiter := newLimiter(clock.Now)
+
+		_, err := limiter.Take(ctx, "capped", limit)
+		require.NoError(t, err)
+		clock.Advance(time.Hour)
+
+		allowed := 0
+		for range 5 {
+			result, err := limiter.Take(ctx, "capped", limit)
+			require.NoError(t, err)
+			if result.Allowed {
+				allowed++
+			}
+		}
+		assert.Equal(t, 3, allowed)
+	})
+
+	t.Run("Keys are independent", func(t *testing.T) {
+		limiter := newLimiter(newFakeClock().Now)
+		one := ratelimit.Limit{Burst: 1, Period: time.Hour}
+
+		result, err := limiter.Take(ctx, "a", one)
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+		result, err = limiter.Take(ctx, "a", one)
+		require.NoError(t, err)
+		require.False(t, result.Allowed)
+
+		result, err = limiter.Take(ctx, "b", one)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	testLimiterBehaviour(t, ratelimit.NewMemoryLimiter)
+}
+
+func TestMemoryLimiter_Concurrent(t *testing.T) {
+	limiter := ratelimit.NewMemoryLimiter(newFakeClock().Now)
+	limit := ratelimit.Limit{Burst: 50, Period: time.Hour}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for range 200 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := limiter.Take(context.Background(), "shared", limit)
+			if err == nil && result.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 50, allowed)
+}
+
+func TestMemoryLimiter_EvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	limiter := ratelimit.NewMemoryLimiter(clock.Now)
+	limit := ratelimit.Limit{Burst: 1, Period: time.Hour}
+
+	result, err := limiter.Take(ctx, "victim", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	// Enough fresh keys to fill the map twice over, with the victim in use
+	// between them, must not hand it a new budget.
+	for i := range 200000 {
+		if i%10000 == 0 {
+			clock.Advance(time.Second)
+			result, err = limiter.Take(ctx, "victim", limit)
+			require.NoError(t, err)
+			require.False(t, result.Allowed, "victim refused after %d other keys", i)
+		}
+		_, err = limiter.Take(ctx, fmt.Sprintf("flood-%d", i), limit)
+		require.NoError(t, err)
+	}
+	clock.Advance(time.Second)
+	result, err = limiter.Take(ctx, "victim", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+}
+
+type stubLimiter struct {
+	results map[string]ratelimit.Result
+	err     error
+	taken   []string
+}
+
+func (s *stubLimiter) Take(_ context.Context, key string, _ ratelimit.Limit) (ratelimit.Result, error) {
+	s.taken = append(s.taken, key)
+	if s.err != nil {
+		return ratelimit.Result{}, s.err
+	}
+	if result, ok := s.results[key]; ok {
+		return result, nil
+	}
+	return ratelimit.Result{Allowed: true}, nil
+}
+
+func TestEnforce(t *testing.T) {
+	ctx := context.Background()
+	rules := []ratelimit.Rule{
+		{Name: "email", Subject: "hash"},
+		{Name: "ip", Subject: "203.0.113.7"},
+		{Name: "global", Subject: "all"},
+	}
+
+	t.Run("All allowed", func(t *testing.T) {
+		limiter := &stubLimiter{}
+		require.NoError(t, ratelimit.Enforce(ctx, limiter, rules...))
+		assert.Equal(t, []string{"email:hash", "ip:203.0.113.7", "global:all"}, limiter.taken)
+	})
+
+	t.Run("Stops at first refusal", func(t *testing.T) {
+		limiter := &stubLimiter{results: map[string]ratelimit.Result{
+			"ip:203.0.113.7": {RetryAfter: 90 * time.Second},
+		}}
+
+		err := ratelimit.Enforce(ctx, limiter, rules...)
+		require.ErrorIs(t, err, ratelimit.ErrRateLimited)
+		assert.ErrorIs(t, err, qqerrors.ErrTooManyRequests)
+		var retryErr *qqerrors.RetryAfterError
+		require.ErrorAs(t, err, &retryErr)
+		assert.Equal(t, 90*time.Second, retryErr.RetryAfter)
+		assert.Contains(t, err.Error(), "ip")
+		assert.Equal(t, []string{"email:hash", "ip:203.0.113.7"}, limiter.taken)
+	})
+
+	t.Run("Long subjects fit the key column", func(t *testing.T) {
+		limiter := &stubLimiter{}
+		long := strings.Repeat("x", 1000)
+		require.NoError(t, ratelimit.Enforce(ctx, limiter,
+			ratelimit.Rule{Name: "ip", Subject: long},
+			ratelimit.Rule{Name: "ip", Subject: long + "y"},
+		))
+		require.Len(t, limiter.taken, 2)
+		for _, key := range limiter.taken {
+			assert.LessOrEqual(t, len(key), 255)
+			assert.True(t, strings.HasPrefix(key, "ip:"), key)
+		}
+		assert.NotEqual(t, limiter.taken[0], limiter.taken[1], "Distinct subjects keep distinct buckets")
+	})
+
+	t.Run("Backend failure", func(t *testing.T) {
+		backendErr := errors.New("connection refused")
+		err := ratelimit.Enforce(ctx, &stubLimiter{err: backendErr}, rules...)
+		require.ErrorIs(t, err, backendErr)
+		assert.NotErrorIs(t, err, ratelimit.ErrRateLimited)
+	})
+}
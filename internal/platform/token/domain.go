@@ -1,22 +1,74 @@
 package token
 
 import (
+	"fmt"
+	"time"
+
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	ErrInvalidToken    = fmt.Errorf("invalid token: %w", qqerrors.ErrUnauthorized)
+	ErrNotAccessToken  = fmt.Errorf("token is not an access token: %w", qqerrors.ErrUnauthorized)
+	ErrNotRefreshToken = fmt.Errorf("token is not a refresh token: %w", qqerrors.ErrUnauthorized)
+	ErrWrongTokenUse   = fmt.Errorf("token was issued for another use: %w", qqerrors.ErrUnauthorized)
+)
+
+// TokenUse tells apart tokens signed with the same key so one kind can never be
+// presented where another is expected.
+type TokenUse string
+
+const (
+	TokenUseAccess      TokenUse = "access"
+	TokenUseRefresh     TokenUse = "refresh"
+	TokenUseEmailChange TokenUse = "email_change"
+	TokenUseStepUp      TokenUse = "step_up"
+	TokenUseMagicLink   TokenUse = "magic_link"
+	// TokenUseSecondFactor marks the token a first-factor login returns when the
+	// account has two-factor authentication; it only finishes that login.
+	TokenUseSecondFactor TokenUse = "second_factor"
+)
+
+// RefreshAudienceSuffix is appended to the configured audience for refresh
+// tokens, so they are only accepted by the refresh endpoint.
+const RefreshAudienceSuffix = "/refresh"
+
+// Claims are shared by access and refresh tokens. Both carry the session ID
+// (sid) so revoking the session invalidates them. Only refresh tokens carry a
+// token ID (jti) and a family ID; both are recorded server-side for rotation.
 type Claims struct {
 	jwt.RegisteredClaims
 
-	UserID string `json:"user_id"`
+	UserID    string   `json:"user_id"`
+	TokenUse  TokenUse `json:"token_use"`
+	SessionID string   `json:"sid,omitempty"`
+	FamilyID  string   `json:"fid,omitempty"`
 }
 
 type GenerateTokenParams struct {
+	UserID    string
+	SessionID string
+	// FamilyID continues an existing refresh token family; a new one is started when empty.
+	FamilyID string
+}
+
+// PurposeTokenParams describes a short-lived token that is only good for one
+// flow, such as a magic link. ID becomes the jti so callers can record the token
+// server-side and accept it once.
+type PurposeTokenParams struct {
 	UserID string
+	Use    TokenUse
+	ID     string
+	TTL    time.Duration
 }
 
 type GenerateTokenResult struct {
-	AccessToken  string
-	RefreshToken string
+	AccessToken           string
+	RefreshToken          string
+	RefreshTokenID        string
+	FamilyID              string
+	RefreshTokenExpiresAt time.Time
 }
 
 type ValidateTokenParams struct {
@@ -2,24 +2,36 @@ package token
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"time"
 
 	"github.com/abdurrahimagca/qq-back/internal/environment"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type jwtTokenService struct {
 	environment *environment.Environment
+	keys        *KeyRing
 }
 
+// NewJWTTokenService signs and verifies tokens with HS256 and TOKEN_SECRET.
 func NewJWTTokenService(conf *environment.Environment) Service {
 	return &jwtTokenService{
 		environment: conf,
 	}
 }
 
+// NewKeyRingTokenService signs with the currently active key of the ring and
+// verifies by kid. While TOKEN_SECRET is still set, HS256 tokens issued before
+// the switch keep verifying so sessions survive the migration.
+func NewKeyRingTokenService(conf *environment.Environment, keys *KeyRing) Service {
+	return &jwtTokenService{
+		environment: conf,
+		keys:        keys,
+	}
+}
+
 func (j *jwtTokenService) GenerateTokens(ctx context.Context, params GenerateTokenParams) (GenerateTokenResult, error) {
 	if ctx != nil {
 		if err := ctx.Err(); err != nil {
@@ -29,7 +41,9 @@ func (j *jwtTokenService) GenerateTokens(ctx context.Context, params GenerateTok
 
 	now := time.Now()
 	accessTokenClaims := &Claims{
-		UserID: params.UserID,
+		UserID:    params.UserID,
+		TokenUse:  TokenUseAccess,
+		SessionID: params.SessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject: params.UserID,
 			ExpiresAt: jwt.NewNumericDate(
@@ -40,59 +54,186 @@ func (j *jwtTokenService) GenerateTokens(ctx context.Context, params GenerateTok
 			Audience: jwt.ClaimStrings{j.environment.Token.Audience},
 		},
 	}
-	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims).
-		SignedString([]byte(j.environment.Token.Secret))
+	accessToken, err := j.sign(accessTokenClaims, now)
 	if err != nil {
 		return GenerateTokenResult{}, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
+	familyID := params.FamilyID
+	if familyID == "" {
+		familyID = uuid.NewString()
+	}
+	refreshTokenID := uuid.NewString()
+	refreshTokenExpiresAt := now.Add(time.Duration(j.environment.Token.RefreshTokenExpireTime) * time.Hour)
 	refreshTokenClaims := &Claims{
-		UserID: params.UserID,
+		UserID:    params.UserID,
+		TokenUse:  TokenUseRefresh,
+		SessionID: params.SessionID,
+		FamilyID:  familyID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Subject: params.UserID,
-			ExpiresAt: jwt.NewNumericDate(
-				now.Add(time.Duration(j.environment.Token.RefreshTokenExpireTime) * time.Hour),
-			),
-			IssuedAt: jwt.NewNumericDate(now),
-			Issuer:   j.environment.Token.Issuer,
-			Audience: jwt.ClaimStrings{j.environment.Token.Audience},
+			ID:        refreshTokenID,
+			Subject:   params.UserID,
+			ExpiresAt: jwt.NewNumericDate(refreshTokenExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    j.environment.Token.Issuer,
+			Audience:  jwt.ClaimStrings{j.refreshAudience()},
 		},
 	}
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims).
-		SignedString([]byte(j.environment.Token.Secret))
+	refreshToken, err := j.sign(refreshTokenClaims, now)
 	if err != nil {
 		return GenerateTokenResult{}, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
 	return GenerateTokenResult{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:           accessToken,
+		RefreshToken:          refreshToken,
+		RefreshTokenID:        refreshTokenID,
+		FamilyID:              familyID,
+		RefreshTokenExpiresAt: refreshTokenExpiresAt,
 	}, nil
 }
 
 func (j *jwtTokenService) ValidateToken(ctx context.Context, params ValidateTokenParams) (ValidateTokenResult, error) {
+	return j.parse(ctx, params.Token)
+}
+
+// ValidateAccessToken accepts only access tokens issued for the configured audience.
+func (j *jwtTokenService) ValidateAccessToken(
+	ctx context.Context, params ValidateTokenParams) (ValidateTokenResult, error) {
+	result, err := j.parse(ctx, params.Token,
+		jwt.WithIssuer(j.environment.Token.Issuer),
+		jwt.WithAudience(j.environment.Token.Audience),
+	)
+	if err != nil {
+		return ValidateTokenResult{}, err
+	}
+	if result.Claims.TokenUse != TokenUseAccess {
+		return ValidateTokenResult{}, ErrNotAccessToken
+	}
+	return result, nil
+}
+
+// ValidateRefreshToken accepts only refresh tokens issued for the refresh
+// audience and requires the token ID and family claims used for rotation.
+func (j *jwtTokenService) ValidateRefreshToken(
+	ctx context.Context, params ValidateTokenParams) (ValidateTokenResult, error) {
+	result, err := j.parse(ctx, params.Token,
+		jwt.WithIssuer(j.environment.Token.Issuer),
+		jwt.WithAudience(j.refreshAudience()),
+	)
+	if err != nil {
+		return ValidateTokenResult{}, err
+	}
+	if result.Claims.TokenUse != TokenUseRefresh || result.Claims.ID == "" || result.Claims.FamilyID == "" {
+		return ValidateTokenResult{}, ErrNotRefreshToken
+	}
+	return result, nil
+}
+
+// GeneratePurposeToken signs a token for a single flow. Its audience is scoped
+// to the use, so it is rejected as an access or refresh token and by every other
+// flow.
+func (j *jwtTokenService) GeneratePurposeToken(ctx context.Context, params PurposeTokenParams) (string, error) {
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+	}
+	if params.Use == "" || params.Use == TokenUseAccess || params.Use == TokenUseRefresh {
+		return "", fmt.Errorf("invalid purpose token use %q", params.Use)
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		UserID:   params.UserID,
+		TokenUse: params.Use,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        params.ID,
+			Subject:   params.UserID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(params.TTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    j.environment.Token.Issuer,
+			Audience:  jwt.ClaimStrings{j.purposeAudience(params.Use)},
+		},
+	}
+	token, err := j.sign(claims, now)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate %s token: %w", params.Use, err)
+	}
+	return token, nil
+}
+
+// ValidatePurposeToken accepts only tokens generated for use.
+func (j *jwtTokenService) ValidatePurposeToken(
+	ctx context.Context, params ValidateTokenParams, use TokenUse) (ValidateTokenResult, error) {
+	result, err := j.parse(ctx, params.Token,
+		jwt.WithIssuer(j.environment.Token.Issuer),
+		jwt.WithAudience(j.purposeAudience(use)),
+	)
+	if err != nil {
+		return ValidateTokenResult{}, err
+	}
+	if result.Claims.TokenUse != use {
+		return ValidateTokenResult{}, ErrWrongTokenUse
+	}
+	return result, nil
+}
+
+func (j *jwtTokenService) parse(
+	ctx context.Context, tokenString string, opts ...jwt.ParserOption) (ValidateTokenResult, error) {
 	if ctx != nil {
 		if err := ctx.Err(); err != nil {
 			return ValidateTokenResult{}, err
 		}
 	}
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(params.Token, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(j.environment.Token.Secret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, j.verificationKey, opts...)
 
 	if err != nil {
-		return ValidateTokenResult{}, fmt.Errorf("failed to parse token: %w", err)
+		return ValidateTokenResult{}, fmt.Errorf("failed to parse token: %w: %w", err, ErrInvalidToken)
 	}
 
 	if !token.Valid {
-		return ValidateTokenResult{}, errors.New("invalid token")
+		return ValidateTokenResult{}, ErrInvalidToken
 	}
 
 	return ValidateTokenResult{
 		Claims: claims,
 	}, nil
 }
+
+func (j *jwtTokenService) sign(claims *Claims, now time.Time) (string, error) {
+	if j.keys == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(j.environment.Token.Secret))
+	}
+	key := j.keys.Current(now)
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+func (j *jwtTokenService) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if j.keys == nil || kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || j.environment.Token.Secret == "" {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(j.environment.Token.Secret), nil
+	}
+	key, ok := j.keys.Lookup(kid)
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Private.Public(), nil
+}
+
+func (j *jwtTokenService) refreshAudience() string {
+	return j.environment.Token.Audience + RefreshAudienceSuffix
+}
+
+func (j *jwtTokenService) purposeAudience(use TokenUse) string {
+	return j.environment.Token.Audience + "/" + string(use)
+}
@@ -0,0 +1,199 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSAKeyBits = 2048
+
+var ErrUnknownKeyID = fmt.Errorf("unknown signing key id: %w", ErrInvalidToken)
+
+// KeySpec describes one signing key as configured in TOKEN_SIGNING_KEYS. The key
+// material comes either from a PEM file or inline PEM. A key starts signing at
+// ActiveFrom; until then it is only published so verifiers can cache it ahead of
+// the rotation.
+type KeySpec struct {
+	ID         string    `json:"kid"`
+	File       string    `json:"file,omitempty"`
+	PEM        string    `json:"pem,omitempty"`
+	ActiveFrom time.Time `json:"activeFrom"`
+}
+
+// SigningKey is a private key together with its kid and the JWT method derived
+// from its type: Ed25519 keys sign with EdDSA, RSA keys with RS256.
+type SigningKey struct {
+	ID         string
+	ActiveFrom time.Time
+	Method     jwt.SigningMethod
+	Private    crypto.Signer
+}
+
+// KeyRing holds every configured signing key ordered by activation time.
+type KeyRing struct {
+	keys []SigningKey
+	byID map[string]SigningKey
+}
+
+// JSONWebKey is the public half of a SigningKey as served from the JWKS endpoint.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// ParseKeySpecs decodes the JSON array held in TOKEN_SIGNING_KEYS.
+func ParseKeySpecs(raw string) ([]KeySpec, error) {
+	var specs []KeySpec
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		return nil, fmt.Errorf("failed to decode signing key specs: %w", err)
+	}
+	return specs, nil
+}
+
+// LoadKeyRing reads the key material for every spec and builds a KeyRing.
+func LoadKeyRing(specs []KeySpec) (*KeyRing, error) {
+	keys := make([]SigningKey, 0, len(specs))
+	for _, spec := range specs {
+		key, err := loadSigningKey(spec)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyRing(keys)
+}
+
+// NewKeyRing validates the keys and orders them by activation time.
+func NewKeyRing(keys []SigningKey) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("key ring needs at least one signing key")
+	}
+	ring := &KeyRing{
+		keys: append([]SigningKey(nil), keys...),
+		byID: make(map[string]SigningKey, len(keys)),
+	}
+	for _, key := range ring.keys {
+		if key.ID == "" {
+			return nil, errors.New("signing key is missing a kid")
+		}
+		if _, dup := ring.byID[key.ID]; dup {
+			return nil, fmt.Errorf("duplicate signing key id %q", key.ID)
+		}
+		ring.byID[key.ID] = key
+	}
+	sort.SliceStable(ring.keys, func(i, j int) bool {
+		return ring.keys[i].ActiveFrom.Before(ring.keys[j].ActiveFrom)
+	})
+	return ring, nil
+}
+
+// Current returns the key that signs at now: the most recently activated one.
+// Before any key is active the earliest key is used.
+func (r *KeyRing) Current(now time.Time) SigningKey {
+	current := r.keys[0]
+	for _, key := range r.keys[1:] {
+		if key.ActiveFrom.After(now) {
+			break
+		}
+		current = key
+	}
+	return current
+}
+
+// Lookup returns the key with the given kid.
+func (r *KeyRing) Lookup(kid string) (SigningKey, bool) {
+	key, ok := r.byID[kid]
+	return key, ok
+}
+
+// JWKS returns the public keys of the ring, including keys that are not active
+// yet. A nil ring (HS256 deployments) publishes an empty set.
+func (r *KeyRing) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	if r == nil {
+		return set
+	}
+	for _, key := range r.keys {
+		jwk := JSONWebKey{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.Private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func loadSigningKey(spec KeySpec) (SigningKey, error) {
+	data := []byte(spec.PEM)
+	if spec.File != "" {
+		var err error
+		data, err = os.ReadFile(spec.File)
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("failed to read signing key %q: %w", spec.ID, err)
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("signing key %q is not PEM encoded", spec.ID)
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("failed to parse signing key %q: %w", spec.ID, err)
+	}
+
+	key := SigningKey{ID: spec.ID, ActiveFrom: spec.ActiveFrom}
+	switch private := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.Private = private
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSAKeyBits {
+			return SigningKey{}, fmt.Errorf("signing key %q: RSA keys need at least %d bits", spec.ID, minRSAKeyBits)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.Private = private
+	default:
+		return SigningKey{}, fmt.Errorf("signing key %q: unsupported key type %T", spec.ID, parsed)
+	}
+	return key, nil
+}
@@ -7,5 +7,10 @@ import (
 // Service captures token generation and validation behaviour required by the app layer.
 type Service interface {
 	GenerateTokens(ctx context.Context, params GenerateTokenParams) (GenerateTokenResult, error)
+	// ValidateToken checks signature and expiry only; it accepts any token use.
 	ValidateToken(ctx context.Context, params ValidateTokenParams) (ValidateTokenResult, error)
+	ValidateAccessToken(ctx context.Context, params ValidateTokenParams) (ValidateTokenResult, error)
+	ValidateRefreshToken(ctx context.Context, params ValidateTokenParams) (ValidateTokenResult, error)
+	GeneratePurposeToken(ctx context.Context, params PurposeTokenParams) (string, error)
+	ValidatePurposeToken(ctx context.Context, params ValidateTokenParams, use TokenUse) (ValidateTokenResult, error)
 }
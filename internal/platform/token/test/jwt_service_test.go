@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/abdurrahimagca/qq-back/internal/platform/token"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -57,7 +58,10 @@ func TestJWTTokenService_GenerateTokens(t *testing.T) {
 	assert.Equal(t, userID, refreshClaims.UserID, "Refresh token UserID should match")
 	assert.Equal(t, userID, refreshClaims.Subject, "Refresh token Subject should match UserID")
 	assert.Equal(t, "test-issuer", refreshClaims.Issuer, "Refresh token Issuer should match")
-	assert.Contains(t, refreshClaims.Audience, "test-audience", "Refresh token Audience should match")
+	assert.Equal(t, jwt.ClaimStrings{"test-audience" + token.RefreshAudienceSuffix}, refreshClaims.Audience,
+		"Refresh token Audience should be the refresh audience")
+	assert.Equal(t, token.TokenUseAccess, accessClaims.TokenUse, "Access token use should be access")
+	assert.Equal(t, token.TokenUseRefresh, refreshClaims.TokenUse, "Refresh token use should be refresh")
 	assert.Equal(t, "HS256", refreshHeader["alg"], "Refresh token algorithm should be HS256")
 
 	// Validate refresh token expiration (24 hours)
@@ -297,3 +301,221 @@ func TestJWTTokenService_ValidateToken_MultipleAudiences(t *testing.T) {
 	// which creates a single-element slice, not a comma-separated parse
 	assert.Contains(t, valResult.Claims.Audience, "aud1,aud2,aud3")
 }
+
+func TestJWTTokenService_GenerateTokens_RefreshTokenIdentity(t *testing.T) {
+	env := BuildEnv("test-secret", 15, 24, "test-issuer", "test-audience")
+	service := token.NewJWTTokenService(env)
+	ctx := context.Background()
+
+	first, err := service.GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.RefreshTokenID)
+	assert.NotEmpty(t, first.FamilyID)
+
+	claims, _, err := ParseClaims(first.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, first.RefreshTokenID, claims.ID, "Refresh token jti should match result")
+	assert.Equal(t, first.FamilyID, claims.FamilyID, "Refresh token fid should match result")
+	assert.True(t, IsWithinTolerance(first.RefreshTokenExpiresAt, claims.ExpiresAt.Time, time.Second))
+
+	second, err := service.GenerateTokens(ctx, token.GenerateTokenParams{
+		UserID:   "user-123",
+		FamilyID: first.FamilyID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, first.FamilyID, second.FamilyID, "Rotated token should stay in the family")
+	assert.NotEqual(t, first.RefreshTokenID, second.RefreshTokenID, "Rotated token should get a new jti")
+}
+
+func TestJWTTokenService_GenerateTokens_SessionID(t *testing.T) {
+	env := BuildEnv("test-secret", 15, 24, "test-issuer", "test-audience")
+	service := token.NewJWTTokenService(env)
+
+	result, err := service.GenerateTokens(context.Background(), token.GenerateTokenParams{
+		UserID:    "user-123",
+		SessionID: "session-abc",
+	})
+	require.NoError(t, err)
+
+	accessClaims, _, err := ParseClaims(result.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "session-abc", accessClaims.SessionID, "Access token should carry the sid")
+
+	refreshClaims, _, err := ParseClaims(result.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, "session-abc", refreshClaims.SessionID, "Refresh token should carry the sid")
+}
+
+func TestJWTTokenService_ValidateRefreshToken(t *testing.T) {
+	env := BuildEnv("test-secret", 15, 24, "test-issuer", "test-audience")
+	service := token.NewJWTTokenService(env)
+	ctx := context.Background()
+
+	genResult, err := service.GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+	require.NoError(t, err)
+
+	t.Run("Refresh token accepted", func(t *testing.T) {
+		result, err := service.ValidateRefreshToken(ctx, token.ValidateTokenParams{Token: genResult.RefreshToken})
+		require.NoError(t, err)
+		assert.Equal(t, genResult.RefreshTokenID, result.Claims.ID)
+		assert.Equal(t, genResult.FamilyID, result.Claims.FamilyID)
+	})
+
+	t.Run("Access token rejected", func(t *testing.T) {
+		_, err := service.ValidateRefreshToken(ctx, token.ValidateTokenParams{Token: genResult.AccessToken})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, token.ErrInvalidToken, "Access audience should not satisfy the refresh audience")
+	})
+
+	t.Run("Wrong token use rejected", func(t *testing.T) {
+		tok := signClaims(t, env, token.Claims{
+			UserID:   "user-123",
+			TokenUse: token.TokenUseAccess,
+			FamilyID: "family",
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        "jti",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				Issuer:    "test-issuer",
+				Audience:  jwt.ClaimStrings{"test-audience" + token.RefreshAudienceSuffix},
+			},
+		})
+		_, err := service.ValidateRefreshToken(ctx, token.ValidateTokenParams{Token: tok})
+		assert.ErrorIs(t, err, token.ErrNotRefreshToken)
+	})
+
+	t.Run("Malformed token rejected", func(t *testing.T) {
+		_, err := service.ValidateRefreshToken(ctx, token.ValidateTokenParams{Token: "invalid.token"})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, token.ErrInvalidToken)
+	})
+}
+
+func TestJWTTokenService_ValidateAccessToken(t *testing.T) {
+	env := BuildEnv("test-secret", 15, 24, "test-issuer", "test-audience")
+	service := token.NewJWTTokenService(env)
+	ctx := context.Background()
+
+	genResult, err := service.GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+	require.NoError(t, err)
+
+	t.Run("Access token accepted", func(t *testing.T) {
+		result, err := service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: genResult.AccessToken})
+		require.NoError(t, err)
+		assert.Equal(t, "user-123", result.Claims.UserID)
+		assert.Equal(t, token.TokenUseAccess, result.Claims.TokenUse)
+	})
+
+	t.Run("Refresh token rejected", func(t *testing.T) {
+		_, err := service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: genResult.RefreshToken})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+	})
+
+	t.Run("Token without use rejected", func(t *testing.T) {
+		tok := signClaims(t, env, token.Claims{
+			UserID: "user-123",
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				Issuer:    "test-issuer",
+				Audience:  jwt.ClaimStrings{"test-audience"},
+			},
+		})
+		_, err := service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: tok})
+		assert.ErrorIs(t, err, token.ErrNotAccessToken)
+	})
+
+	t.Run("Other token use rejected", func(t *testing.T) {
+		tok := signClaims(t, env, token.Claims{
+			UserID:   "user-123",
+			TokenUse: token.TokenUseStepUp,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				Issuer:    "test-issuer",
+				Audience:  jwt.ClaimStrings{"test-audience"},
+			},
+		})
+		_, err := service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: tok})
+		assert.ErrorIs(t, err, token.ErrNotAccessToken)
+	})
+
+	t.Run("Wrong issuer rejected", func(t *testing.T) {
+		tok := signClaims(t, env, token.Claims{
+			UserID:   "user-123",
+			TokenUse: token.TokenUseAccess,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				Issuer:    "other-issuer",
+				Audience:  jwt.ClaimStrings{"test-audience"},
+			},
+		})
+		_, err := service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: tok})
+		assert.ErrorIs(t, err, token.ErrInvalidToken)
+	})
+}
+
+func TestJWTTokenService_PurposeToken(t *testing.T) {
+	env := BuildEnv("test-secret", 15, 24, "test-issuer", "test-audience")
+	service := token.NewJWTTokenService(env)
+	ctx := context.Background()
+
+	tok, err := service.GeneratePurposeToken(ctx, token.PurposeTokenParams{
+		UserID: "user-123",
+		Use:    token.TokenUseMagicLink,
+		ID:     "nonce-1",
+		TTL:    3 * time.Minute,
+	})
+	require.NoError(t, err)
+
+	t.Run("Same use accepted", func(t *testing.T) {
+		result, err := service.ValidatePurposeToken(ctx, token.ValidateTokenParams{Token: tok}, token.TokenUseMagicLink)
+		require.NoError(t, err)
+		assert.Equal(t, "user-123", result.Claims.UserID)
+		assert.Equal(t, "nonce-1", result.Claims.ID)
+		assert.True(t, IsWithinTolerance(time.Now().Add(3*time.Minute), result.Claims.ExpiresAt.Time, 2*time.Second))
+
+		claims, _, err := ParseClaims(tok)
+		require.NoError(t, err)
+		assert.Equal(t, jwt.ClaimStrings{"test-audience/magic_link"}, claims.Audience)
+	})
+
+	t.Run("Other use rejected", func(t *testing.T) {
+		_, err := service.ValidatePurposeToken(ctx, token.ValidateTokenParams{Token: tok}, token.TokenUseStepUp)
+		assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+	})
+
+	t.Run("Rejected as access and refresh token", func(t *testing.T) {
+		_, err := service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: tok})
+		assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+		_, err = service.ValidateRefreshToken(ctx, token.ValidateTokenParams{Token: tok})
+		assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+	})
+
+	t.Run("Access token rejected", func(t *testing.T) {
+		pair, err := service.GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+		require.NoError(t, err)
+		_, err = service.ValidatePurposeToken(
+			ctx, token.ValidateTokenParams{Token: pair.AccessToken}, token.TokenUseMagicLink)
+		assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+	})
+
+	t.Run("Audience matches but use differs", func(t *testing.T) {
+		forged := signClaims(t, env, token.Claims{
+			UserID:   "user-123",
+			TokenUse: token.TokenUseStepUp,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				Issuer:    "test-issuer",
+				Audience:  jwt.ClaimStrings{"test-audience/magic_link"},
+			},
+		})
+		_, err := service.ValidatePurposeToken(ctx, token.ValidateTokenParams{Token: forged}, token.TokenUseMagicLink)
+		assert.ErrorIs(t, err, token.ErrWrongTokenUse)
+	})
+
+	t.Run("Access and refresh uses refused", func(t *testing.T) {
+		for _, use := range []token.TokenUse{"", token.TokenUseAccess, token.TokenUseRefresh} {
+			_, err := service.GeneratePurposeToken(ctx, token.PurposeTokenParams{UserID: "user-123", Use: use})
+			assert.Error(t, err, "use %q", use)
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package token_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/platform/token"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeySpecs(t *testing.T) {
+	activeFrom := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	raw := `[{"kid":"k1","file":"/keys/k1.pem","activeFrom":"2026-10-01T00:00:00Z"},{"kid":"k2","pem":"inline"}]`
+
+	specs, err := token.ParseKeySpecs(raw)
+
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	assert.Equal(t, token.KeySpec{ID: "k1", File: "/keys/k1.pem", ActiveFrom: activeFrom}, specs[0])
+	assert.Equal(t, token.KeySpec{ID: "k2", PEM: "inline"}, specs[1])
+
+	_, err = token.ParseKeySpecs("not json")
+	assert.Error(t, err)
+}
+
+func TestLoadKeyRing_FileAndInlinePEM(t *testing.T) {
+	edPEM, _ := NewEd25519PEM(t)
+	rsaPEM, _ := NewRSAPEM(t, 2048)
+	path := filepath.Join(t.TempDir(), "ed.pem")
+	require.NoError(t, os.WriteFile(path, edPEM, 0o600))
+
+	ring, err := token.LoadKeyRing([]token.KeySpec{
+		{ID: "ed", File: path},
+		{ID: "rsa", PEM: string(rsaPEM), ActiveFrom: time.Now().Add(-time.Hour)},
+	})
+
+	require.NoError(t, err)
+	ed, ok := ring.Lookup("ed")
+	require.True(t, ok)
+	assert.Equal(t, "EdDSA", ed.Method.Alg())
+	rsaKey, ok := ring.Lookup("rsa")
+	require.True(t, ok)
+	assert.Equal(t, "RS256", rsaKey.Method.Alg())
+}
+
+func TestLoadKeyRing_Errors(t *testing.T) {
+	edPEM, _ := NewEd25519PEM(t)
+	weakRSA, _ := NewRSAPEM(t, 1024)
+
+	tests := []struct {
+		name  string
+		specs []token.KeySpec
+	}{
+		{name: "No keys", specs: nil},
+		{name: "Missing kid", specs: []token.KeySpec{{PEM: string(edPEM)}}},
+		{name: "Duplicate kid", specs: []token.KeySpec{{ID: "k", PEM: string(edPEM)}, {ID: "k", PEM: string(edPEM)}}},
+		{name: "Not PEM", specs: []token.KeySpec{{ID: "k", PEM: "garbage"}}},
+		{name: "Missing file", specs: []token.KeySpec{{ID: "k", File: filepath.Join(t.TempDir(), "missing.pem")}}},
+		{name: "Weak RSA key", specs: []token.KeySpec{{ID: "k", PEM: string(weakRSA)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := token.LoadKeyRing(tt.specs)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKeyRing_Current_FollowsSchedule(t *testing.T) {
+	now := time.Now()
+	ring := NewTestKeyRing(t,
+		token.KeySpec{ID: "next", ActiveFrom: now.Add(time.Hour)},
+		token.KeySpec{ID: "old", ActiveFrom: now.Add(-48 * time.Hour)},
+		token.KeySpec{ID: "current", ActiveFrom: now.Add(-time.Hour)},
+	)
+
+	assert.Equal(t, "current", ring.Current(now).ID)
+	assert.Equal(t, "old", ring.Current(now.Add(-24*time.Hour)).ID)
+	assert.Equal(t, "next", ring.Current(now.Add(2*time.Hour)).ID)
+	assert.Equal(t, "old", ring.Current(now.Add(-72*time.Hour)).ID, "Earliest key signs before any is active")
+}
+
+func TestKeyRingTokenService_SignsWithKid(t *testing.T) {
+	ring := NewTestKeyRing(t, token.KeySpec{ID: "k1"})
+	env := BuildEnv("", 15, 24, "test-issuer", "test-audience")
+	service := token.NewKeyRingTokenService(env, ring)
+	ctx := context.Background()
+
+	result, err := service.GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+	require.NoError(t, err)
+
+	_, header, err := ParseClaims(result.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "EdDSA", header["alg"])
+	assert.Equal(t, "k1", header["kid"])
+
+	access, err := service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: result.AccessToken})
+	require.NoError(t, err)
+	assert.Equal(t, "user-123", access.Claims.UserID)
+
+	_, err = service.ValidateRefreshToken(ctx, token.ValidateTokenParams{Token: result.RefreshToken})
+	require.NoError(t, err)
+}
+
+func TestKeyRingTokenService_RotatedKeyStillVerifies(t *testing.T) {
+	now := time.Now()
+	env := BuildEnv("", 15, 24, "test-issuer", "test-audience")
+	ctx := context.Background()
+
+	oldKey := NewTestSigningKey(t, token.KeySpec{ID: "old", ActiveFrom: now.Add(-48 * time.Hour)})
+	before, err := token.NewKeyRing([]token.SigningKey{oldKey})
+	require.NoError(t, err)
+	issued, err := token.NewKeyRingTokenService(env, before).
+		GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+	require.NoError(t, err)
+
+	newKey := NewTestSigningKey(t, token.KeySpec{ID: "new", ActiveFrom: now.Add(-time.Minute)})
+	after, err := token.NewKeyRing([]token.SigningKey{oldKey, newKey})
+	require.NoError(t, err)
+	service := token.NewKeyRingTokenService(env, after)
+
+	_, err = service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: issued.AccessToken})
+	require.NoError(t, err, "Tokens signed by the previous key should still verify")
+
+	fresh, err := service.GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+	require.NoError(t, err)
+	_, header, err := ParseClaims(fresh.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "new", header["kid"])
+}
+
+func TestKeyRingTokenService_Rejections(t *testing.T) {
+	env := BuildEnv("legacy-secret", 15, 24, "test-issuer", "test-audience")
+	ctx := context.Background()
+	ring := NewTestKeyRing(t, token.KeySpec{ID: "k1"})
+	service := token.NewKeyRingTokenService(env, ring)
+
+	t.Run("Unknown kid", func(t *testing.T) {
+		other := NewTestKeyRing(t, token.KeySpec{ID: "k2"})
+		foreign, err := token.NewKeyRingTokenService(env, other).
+			GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+		require.NoError(t, err)
+
+		_, err = service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: foreign.AccessToken})
+		assert.ErrorIs(t, err, token.ErrUnknownKeyID)
+	})
+
+	t.Run("Same kid signed by another key", func(t *testing.T) {
+		impostor := NewTestKeyRing(t, token.KeySpec{ID: "k1"})
+		forged, err := token.NewKeyRingTokenService(env, impostor).
+			GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+		require.NoError(t, err)
+
+		_, err = service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: forged.AccessToken})
+		assert.ErrorIs(t, err, token.ErrInvalidToken)
+	})
+
+	t.Run("HS256 token with kid", func(t *testing.T) {
+		claims := jwt.MapClaims{"user_id": "user-123", "token_use": "access", "iss": "test-issuer", "aud": "test-audience"}
+		tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tok.Header["kid"] = "k1"
+		signed, err := tok.SignedString([]byte("legacy-secret"))
+		require.NoError(t, err)
+
+		_, err = service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: signed})
+		assert.ErrorIs(t, err, token.ErrInvalidToken)
+	})
+}
+
+func TestKeyRingTokenService_LegacyHS256(t *testing.T) {
+	ctx := context.Background()
+	ring := NewTestKeyRing(t, token.KeySpec{ID: "k1"})
+
+	legacyEnv := BuildEnv("legacy-secret", 15, 24, "test-issuer", "test-audience")
+	legacy, err := token.NewJWTTokenService(legacyEnv).
+		GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+	require.NoError(t, err)
+
+	t.Run("Accepted while secret is configured", func(t *testing.T) {
+		service := token.NewKeyRingTokenService(legacyEnv, ring)
+		_, err := service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: legacy.AccessToken})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Rejected once secret is removed", func(t *testing.T) {
+		env := BuildEnv("", 15, 24, "test-issuer", "test-audience")
+		service := token.NewKeyRingTokenService(env, ring)
+		_, err := service.ValidateAccessToken(ctx, token.ValidateTokenParams{Token: legacy.AccessToken})
+		assert.ErrorIs(t, err, token.ErrInvalidToken)
+	})
+}
+
+func TestKeyRing_JWKS(t *testing.T) {
+	ctx := context.Background()
+	env := BuildEnv("", 15, 24, "test-issuer", "test-audience")
+	edKey := NewTestSigningKey(t, token.KeySpec{ID: "ed", ActiveFrom: time.Now().Add(-time.Hour)})
+	rsaPEM, _ := NewRSAPEM(t, 2048)
+	rsaRing, err := token.LoadKeyRing([]token.KeySpec{{ID: "rsa", PEM: string(rsaPEM)}})
+	require.NoError(t, err)
+	rsaKey, _ := rsaRing.Lookup("rsa")
+	ring, err := token.NewKeyRing([]token.SigningKey{edKey, rsaKey})
+	require.NoError(t, err)
+
+	// Round-trip through JSON the way a remote verifier would see it.
+	raw, err := json.Marshal(ring.JWKS())
+	require.NoError(t, err)
+	var set token.JSONWebKeySet
+	require.NoError(t, json.Unmarshal(raw, &set))
+	require.Len(t, set.Keys, 2)
+
+	published := map[string]token.JSONWebKey{}
+	for _, k := range set.Keys {
+		published[k.Kid] = k
+	}
+	assert.Equal(t, "OKP", published["ed"].Kty)
+	assert.Equal(t, "Ed25519", published["ed"].Crv)
+	assert.Equal(t, "EdDSA", published["ed"].Alg)
+	assert.Equal(t, "RSA", published["rsa"].Kty)
+	assert.Equal(t, "RS256", published["rsa"].Alg)
+	assert.NotContains(t, string(raw), `"d"`, "Private key material must not be published")
+
+	// A token issued by the service verifies with the published key alone.
+	issued, err := token.NewKeyRingTokenService(env, ring).
+		GenerateTokens(ctx, token.GenerateTokenParams{UserID: "user-123"})
+	require.NoError(t, err)
+	x, err := base64.RawURLEncoding.DecodeString(published["ed"].X)
+	require.NoError(t, err)
+	_, err = jwt.Parse(issued.AccessToken, func(*jwt.Token) (interface{}, error) {
+		return ed25519.PublicKey(x), nil
+	}, jwt.WithValidMethods([]string{"EdDSA"}))
+	assert.NoError(t, err)
+
+	n, err := base64.RawURLEncoding.DecodeString(published["rsa"].N)
+	require.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(published["rsa"].E)
+	require.NoError(t, err)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	assert.True(t, pub.Equal(rsaKey.Private.Public()))
+}
+
+func TestKeyRing_JWKS_NilRing(t *testing.T) {
+	var ring *token.KeyRing
+
+	raw, err := json.Marshal(ring.JWKS())
+
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"keys":[]}`, string(raw))
+}
@@ -2,6 +2,12 @@ package token_test
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
 	"time"
 
 	"github.com/abdurrahimagca/qq-back/internal/environment"
@@ -56,3 +62,65 @@ func IsWithinTolerance(actual, expected time.Time, tolerance time.Duration) bool
 	}
 	return diff <= tolerance
 }
+
+// signClaims signs arbitrary claims with the environment secret
+func signClaims(t *testing.T, env *environment.Environment, claims token.Claims) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims).SignedString([]byte(env.Token.Secret))
+	if err != nil {
+		t.Fatalf("failed to sign claims: %v", err)
+	}
+	return signed
+}
+
+// NewEd25519PEM generates an Ed25519 key and returns it PKCS#8 PEM encoded
+func NewEd25519PEM(t *testing.T) ([]byte, ed25519.PrivateKey) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("failed to marshal ed25519 key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), private
+}
+
+// NewRSAPEM generates an RSA key of the given size and returns it PKCS#1 PEM encoded
+func NewRSAPEM(t *testing.T, bits int) ([]byte, *rsa.PrivateKey) {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}
+	return pem.EncodeToMemory(block), private
+}
+
+// NewTestSigningKey loads a fresh Ed25519 key for the spec's kid and activation time
+func NewTestSigningKey(t *testing.T, spec token.KeySpec) token.SigningKey {
+	t.Helper()
+	keyPEM, _ := NewEd25519PEM(t)
+	spec.PEM = string(keyPEM)
+	ring, err := token.LoadKeyRing([]token.KeySpec{spec})
+	if err != nil {
+		t.Fatalf("failed to load signing key: %v", err)
+	}
+	key, _ := ring.Lookup(spec.ID)
+	return key
+}
+
+// NewTestKeyRing builds a key ring with a fresh Ed25519 key per spec
+func NewTestKeyRing(t *testing.T, specs ...token.KeySpec) *token.KeyRing {
+	t.Helper()
+	keys := make([]token.SigningKey, 0, len(specs))
+	for _, spec := range specs {
+		keys = append(keys, NewTestSigningKey(t, spec))
+	}
+	ring, err := token.NewKeyRing(keys)
+	if err != nil {
+		t.Fatalf("failed to build key ring: %v", err)
+	}
+	return ring
+}
@@ -0,0 +1,118 @@
+package totp_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/platform/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B ("12345678901234567890").
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAt_RFC6238Vectors(t *testing.T) {
+	// The RFC lists eight digit codes; six digit codes are their last six digits.
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range vectors {
+		code, err := totp.CodeAt(rfcSecret, totp.StepAt(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "time %d", unix)
+	}
+}
+
+func TestCodeAt_InvalidSecret(t *testing.T) {
+	_, err := totp.CodeAt("not base32!", 1)
+	require.ErrorIs(t, err, totp.ErrInvalidSecret)
+
+	_, err = totp.CodeAt("", 1)
+	require.ErrorIs(t, err, totp.ErrInvalidSecret)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totp.StepAt(now)
+
+	t.Run("Current step", func(t *testing.T) {
+		step, ok := totp.Validate(rfcSecret, "050471", now)
+		require.True(t, ok)
+		assert.Equal(t, current, step)
+	})
+
+	t.Run("Adjacent steps within skew", func(t *testing.T) {
+		for _, offset := range []int64{-1, 1} {
+			code, err := totp.CodeAt(rfcSecret, current+offset)
+			require.NoError(t, err)
+			step, ok := totp.Validate(rfcSecret, code, now)
+			require.True(t, ok)
+			assert.Equal(t, current+offset, step)
+		}
+	})
+
+	t.Run("Steps outside skew", func(t *testing.T) {
+		for _, offset := range []int64{-2, 2} {
+			code, err := totp.CodeAt(rfcSecret, current+offset)
+			require.NoError(t, err)
+			_, ok := totp.Validate(rfcSecret, code, now)
+			assert.False(t, ok)
+		}
+	})
+
+	t.Run("Lowercase secret with spaces", func(t *testing.T) {
+		_, ok := totp.Validate("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", "050471", now)
+		assert.True(t, ok)
+	})
+
+	t.Run("Malformed codes", func(t *testing.T) {
+		for _, code := range []string{"", "05047", "0504710", "abcdef"} {
+			_, ok := totp.Validate(rfcSecret, code, now)
+			assert.False(t, ok, "code %q", code)
+		}
+	})
+
+	t.Run("Invalid secret", func(t *testing.T) {
+		_, ok := totp.Validate("!!!", "050471", now)
+		assert.False(t, ok)
+	})
+}
+
+func TestGenerateSecret(t *testing.T) {
+	first, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	second, err := totp.GenerateSecret()
+	require.NoError(t, err)
+
+	assert.Len(t, first, 32)
+	assert.NotEqual(t, first, second)
+
+	code, err := totp.CodeAt(first, totp.StepAt(time.Now()))
+	require.NoError(t, err)
+	_, ok := totp.Validate(first, code, time.Now())
+	assert.True(t, ok)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := totp.ProvisioningURI("QQ", "user@example.com", rfcSecret)
+
+	parsed, err := url.Parse(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", parsed.Scheme)
+	assert.Equal(t, "totp", parsed.Host)
+	assert.Equal(t, "/QQ:user@example.com", parsed.Path)
+
+	query := parsed.Query()
+	assert.Equal(t, rfcSecret, query.Get("secret"))
+	assert.Equal(t, "QQ", query.Get("issuer"))
+	assert.Equal(t, "SHA1", query.Get("algorithm"))
+	assert.Equal(t, "6", query.Get("digits"))
+	assert.Equal(t, "30", query.Get("period"))
+}
@@ -0,0 +1,136 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// The CBOR subset used by WebAuthn: authenticators encode attestation objects
+// and COSE keys with definite lengths only, so indefinite-length items, tags and
+// floats are rejected.
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborSimple   = 7
+
+	cborMaxDepth = 16
+)
+
+// decodeCBOR decodes the first CBOR item of data and returns the bytes after it.
+// Integers decode to int64, byte strings to []byte, text to string, arrays to
+// []any and maps to map[any]any.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, fmt.Errorf("%w: cbor nested too deeply", ErrMalformedResponse)
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of cbor", ErrMalformedResponse)
+	}
+
+	major := data[0] >> 5
+	argument, rest, err := readCBORArgument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		if argument > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: cbor integer overflows int64", ErrMalformedResponse)
+		}
+		return int64(argument), rest, nil
+	case cborNegative:
+		if argument > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: cbor integer overflows int64", ErrMalformedResponse)
+		}
+		return -1 - int64(argument), rest, nil
+	case cborBytes, cborText:
+		if argument > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("%w: cbor string exceeds input", ErrMalformedResponse)
+		}
+		value := rest[:argument]
+		if major == cborText {
+			return string(value), rest[argument:], nil
+		}
+		return append([]byte(nil), value...), rest[argument:], nil
+	case cborArray:
+		if argument > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("%w: cbor array exceeds input", ErrMalformedResponse)
+		}
+		items := make([]any, 0, argument)
+		for range argument {
+			var item any
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case cborMap:
+		if argument > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("%w: cbor map exceeds input", ErrMalformedResponse)
+		}
+		entries := make(map[any]any, argument)
+		for range argument {
+			var key, value any
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported cbor map key %T", ErrMalformedResponse, key)
+			}
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, dup := entries[key]; dup {
+				return nil, nil, fmt.Errorf("%w: duplicate cbor map key %v", ErrMalformedResponse, key)
+			}
+			entries[key] = value
+		}
+		return entries, rest, nil
+	case cborSimple:
+		switch argument {
+		case 20:
+			return false, rest, nil
+		case 21:
+			return true, rest, nil
+		case 22:
+			return nil, rest, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: unsupported cbor item 0x%02x", ErrMalformedResponse, data[0])
+}
+
+func readCBORArgument(data []byte) (uint64, []byte, error) {
+	info := data[0] & 0x1f
+	rest := data[1:]
+	switch {
+	case info < 24:
+		return uint64(info), rest, nil
+	case info == 24 && len(rest) >= 1:
+		return uint64(rest[0]), rest[1:], nil
+	case info == 25 && len(rest) >= 2:
+		return uint64(binary.BigEndian.Uint16(rest)), rest[2:], nil
+	case info == 26 && len(rest) >= 4:
+		return uint64(binary.BigEndian.Uint32(rest)), rest[4:], nil
+	case info == 27 && len(rest) >= 8:
+		return binary.BigEndian.Uint64(rest), rest[8:], nil
+	case info >= 28:
+		return 0, nil, fmt.Errorf("%w: indefinite or reserved cbor length", ErrMalformedResponse)
+	}
+	return 0, nil, fmt.Errorf("%w: unexpected end of cbor", ErrMalformedResponse)
+}
@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers offered in the creation options, in order of
+// preference.
+const (
+	AlgorithmES256 int64 = -7
+	AlgorithmEdDSA int64 = -8
+	AlgorithmRS256 int64 = -257
+)
+
+// SupportedAlgorithms is advertised as pubKeyCredParams.
+var SupportedAlgorithms = []int64{AlgorithmES256, AlgorithmEdDSA, AlgorithmRS256}
+
+const (
+	coseKeyType   int64 = 1
+	coseAlgorithm int64 = 3
+	coseCurve     int64 = -1
+	coseX         int64 = -2
+	coseY         int64 = -3
+	coseRSAN      int64 = -1
+	coseRSAE      int64 = -2
+
+	coseKeyTypeOKP int64 = 1
+	coseKeyTypeEC2 int64 = 2
+	coseKeyTypeRSA int64 = 3
+
+	coseCurveP256    int64 = 1
+	coseCurveEd25519 int64 = 6
+
+	minRSAKeyBits = 2048
+)
+
+// publicKey is a credential public key decoded from its COSE_Key encoding.
+type publicKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key and returns the bytes following it.
+func parsePublicKey(data []byte) (*publicKey, []byte, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields, ok := item.(map[any]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: credential public key is not a map", ErrMalformedResponse)
+	}
+
+	keyType, _ := fields[coseKeyType].(int64)
+	algorithm, _ := fields[coseAlgorithm].(int64)
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgorithmES256:
+		key, keyErr := parseP256Key(fields)
+		return &publicKey{algorithm: algorithm, key: key}, rest, keyErr
+	case keyType == coseKeyTypeOKP && algorithm == AlgorithmEdDSA:
+		x, _ := fields[coseX].([]byte)
+		if curve, _ := fields[coseCurve].(int64); curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, nil, fmt.Errorf("%w: invalid Ed25519 key", ErrMalformedResponse)
+		}
+		return &publicKey{algorithm: algorithm, key: ed25519.PublicKey(x)}, rest, nil
+	case keyType == coseKeyTypeRSA && algorithm == AlgorithmRS256:
+		key, keyErr := parseRSAKey(fields)
+		return &publicKey{algorithm: algorithm, key: key}, rest, keyErr
+	}
+	return nil, nil, fmt.Errorf("%w: key type %d with algorithm %d", ErrUnsupportedAlgorithm, keyType, algorithm)
+}
+
+func parseP256Key(fields map[any]any) (*ecdsa.PublicKey, error) {
+	x, _ := fields[coseX].([]byte)
+	y, _ := fields[coseY].([]byte)
+	if curve, _ := fields[coseCurve].(int64); curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+		return nil, fmt.Errorf("%w: invalid P-256 key", ErrMalformedResponse)
+	}
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, fmt.Errorf("%w: P-256 point is not on the curve", ErrMalformedResponse)
+	}
+	return key, nil
+}
+
+func parseRSAKey(fields map[any]any) (*rsa.PublicKey, error) {
+	n, _ := fields[coseRSAN].([]byte)
+	e, _ := fields[coseRSAE].([]byte)
+	if len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("%w: invalid RSA exponent", ErrMalformedResponse)
+	}
+	modulus := new(big.Int).SetBytes(n)
+	if modulus.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("%w: RSA keys need at least %d bits", ErrUnsupportedAlgorithm, minRSAKeyBits)
+	}
+	exponent := int(new(big.Int).SetBytes(e).Int64())
+	return &rsa.PublicKey{N: modulus, E: exponent}, nil
+}
+
+// verify checks signature over message with the algorithm the key was
+// registered for.
+func (k *publicKey) verify(message []byte, signature []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package webauthn
+
+import (
+	"fmt"
+
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+)
+
+var (
+	ErrMalformedResponse      = fmt.Errorf("malformed webauthn response: %w", qqerrors.ErrValidationError)
+	ErrUnsupportedAlgorithm   = fmt.Errorf("unsupported credential algorithm: %w", qqerrors.ErrValidationError)
+	ErrUnsupportedAttestation = fmt.Errorf("unsupported attestation: %w", qqerrors.ErrValidationError)
+	ErrVerificationFailed     = fmt.Errorf("webauthn verification failed: %w", qqerrors.ErrUnauthorized)
+	// ErrSignCountRegression suggests the credential has been cloned.
+	ErrSignCountRegression = fmt.Errorf("authenticator sign count did not increase: %w", qqerrors.ErrUnauthorized)
+)
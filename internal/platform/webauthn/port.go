@@ -0,0 +1,42 @@
+package webauthn
+
+// RelyingParty verifies the responses of navigator.credentials.create and
+// navigator.credentials.get against a challenge the server issued.
+type RelyingParty interface {
+	ID() string
+	Name() string
+	VerifyRegistration(challenge []byte, response RegistrationResponse) (*Credential, error)
+	VerifyAssertion(challenge []byte, credentialPublicKey []byte, response AssertionResponse) (*Assertion, error)
+}
+
+// RegistrationResponse is the AuthenticatorAttestationResponse of a new
+// credential.
+type RegistrationResponse struct {
+	ClientDataJSON    []byte
+	AttestationObject []byte
+}
+
+// AssertionResponse is the AuthenticatorAssertionResponse of a login.
+type AssertionResponse struct {
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+}
+
+// Credential is a verified new credential. PublicKey keeps the COSE_Key
+// encoding so it can be stored as is and handed back to VerifyAssertion.
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte
+	Algorithm      int64
+	SignCount      uint32
+	AAGUID         []byte
+	BackupEligible bool
+	BackedUp       bool
+}
+
+// Assertion is the verified result of a login ceremony.
+type Assertion struct {
+	SignCount uint32
+	BackedUp  bool
+}
@@ -0,0 +1,311 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+)
+
+const (
+	challengeBytesLength = 32
+
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+
+	flagUserPresent    byte = 0x01
+	flagUserVerified   byte = 0x04
+	flagBackupEligible byte = 0x08
+	flagBackedUp       byte = 0x10
+	flagAttestedData   byte = 0x40
+	flagExtensionData  byte = 0x80
+
+	authenticatorDataMinLength = 37
+	aaguidLength               = 16
+	maxCredentialIDLength      = 1023
+)
+
+type relyingParty struct {
+	id       string
+	name     string
+	origins  []string
+	rpIDHash [sha256.Size]byte
+}
+
+// NewRelyingParty returns a RelyingParty for the configured RP ID. Both
+// ceremonies require user verification, so a passkey login stands on its own
+// without a second factor.
+func NewRelyingParty(conf environment.WebAuthnEnvironment) RelyingParty {
+	return &relyingParty{
+		id:       conf.RPID,
+		name:     conf.RPName,
+		origins:  conf.Origins,
+		rpIDHash: sha256.Sum256([]byte(conf.RPID)),
+	}
+}
+
+// NewChallenge returns 32 random bytes for a new ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeBytesLength)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// CheckSignCount rejects an assertion whose signature counter did not move past
+// the stored one. Authenticators without a counter always report zero.
+func CheckSignCount(stored uint32, received uint32) error {
+	if stored == 0 && received == 0 {
+		return nil
+	}
+	if received <= stored {
+		return ErrSignCountRegression
+	}
+	return nil
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ChallengeFromClientData returns the challenge the client signed so the
+// ceremony it belongs to can be looked up before the response is verified.
+func ChallengeFromClientData(clientDataJSON []byte) ([]byte, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return nil, fmt.Errorf("%w: client data is not JSON", ErrMalformedResponse)
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil || len(challenge) == 0 {
+		return nil, fmt.Errorf("%w: client data challenge is not base64url", ErrMalformedResponse)
+	}
+	return challenge, nil
+}
+
+func (rp *relyingParty) ID() string {
+	return rp.id
+}
+
+func (rp *relyingParty) Name() string {
+	return rp.name
+}
+
+func (rp *relyingParty) VerifyRegistration(challenge []byte, response RegistrationResponse) (*Credential, error) {
+	if err := rp.verifyClientData(response.ClientDataJSON, ceremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	item, rest, err := decodeCBOR(response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok || len(rest) != 0 {
+		return nil, fmt.Errorf("%w: attestation object is not a map", ErrMalformedResponse)
+	}
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[any]any)
+	rawAuthData, _ := attestation["authData"].([]byte)
+	if statement == nil || rawAuthData == nil {
+		return nil, fmt.Errorf("%w: attestation object is incomplete", ErrMalformedResponse)
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err = rp.checkAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.credential == nil {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrMalformedResponse)
+	}
+
+	if err = verifyAttestationStatement(format, statement, authData, rawAuthData, response.ClientDataJSON); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:             authData.credential.id,
+		PublicKey:      authData.credential.rawPublicKey,
+		Algorithm:      authData.credential.publicKey.algorithm,
+		SignCount:      authData.signCount,
+		AAGUID:         authData.credential.aaguid,
+		BackupEligible: authData.flags&flagBackupEligible != 0,
+		BackedUp:       authData.flags&flagBackedUp != 0,
+	}, nil
+}
+
+func (rp *relyingParty) VerifyAssertion(
+	challenge []byte, credentialPublicKey []byte, response AssertionResponse,
+) (*Assertion, error) {
+	if err := rp.verifyClientData(response.ClientDataJSON, ceremonyGet, challenge); err != nil {
+		return nil, err
+	}
+
+	authData, err := parseAuthenticatorData(response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err = rp.checkAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+
+	key, rest, err := parsePublicKey(credentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing bytes after stored public key", ErrMalformedResponse)
+	}
+	if !key.verify(signedData(response.AuthenticatorData, response.ClientDataJSON), response.Signature) {
+		return nil, fmt.Errorf("%w: signature does not match", ErrVerificationFailed)
+	}
+
+	return &Assertion{
+		SignCount: authData.signCount,
+		BackedUp:  authData.flags&flagBackedUp != 0,
+	}, nil
+}
+
+func (rp *relyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("%w: client data is not JSON", ErrMalformedResponse)
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("%w: client data type %q", ErrVerificationFailed, data.Type)
+	}
+	signed, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(signed, challenge) != 1 {
+		return fmt.Errorf("%w: challenge does not match", ErrVerificationFailed)
+	}
+	if !slices.Contains(rp.origins, data.Origin) {
+		return fmt.Errorf("%w: origin %q is not allowed", ErrVerificationFailed, data.Origin)
+	}
+	if data.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin ceremonies are not allowed", ErrVerificationFailed)
+	}
+	return nil
+}
+
+func (rp *relyingParty) checkAuthenticatorData(authData *authenticatorData) error {
+	if subtle.ConstantTimeCompare(authData.rpIDHash, rp.rpIDHash[:]) != 1 {
+		return fmt.Errorf("%w: credential is scoped to another RP ID", ErrVerificationFailed)
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user was not present", ErrVerificationFailed)
+	}
+	if authData.flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user was not verified", ErrVerificationFailed)
+	}
+	return nil
+}
+
+type attestedCredential struct {
+	aaguid       []byte
+	id           []byte
+	rawPublicKey []byte
+	publicKey    *publicKey
+}
+
+type authenticatorData struct {
+	rpIDHash   []byte
+	flags      byte
+	signCount  uint32
+	credential *attestedCredential
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < authenticatorDataMinLength {
+		return nil, fmt.Errorf("%w: authenticator data is too short", ErrMalformedResponse)
+	}
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[authenticatorDataMinLength:]
+
+	if authData.flags&flagAttestedData != 0 {
+		if len(rest) < aaguidLength+2 {
+			return nil, fmt.Errorf("%w: attested credential data is too short", ErrMalformedResponse)
+		}
+		credential := &attestedCredential{aaguid: rest[:aaguidLength]}
+		idLength := int(binary.BigEndian.Uint16(rest[aaguidLength:]))
+		rest = rest[aaguidLength+2:]
+		if idLength == 0 || idLength > maxCredentialIDLength || idLength > len(rest) {
+			return nil, fmt.Errorf("%w: invalid credential id length", ErrMalformedResponse)
+		}
+		credential.id = rest[:idLength]
+		rest = rest[idLength:]
+
+		key, afterKey, err := parsePublicKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		credential.rawPublicKey = bytes.Clone(rest[:len(rest)-len(afterKey)])
+		credential.publicKey = key
+		authData.credential = credential
+		rest = afterKey
+	}
+
+	if authData.flags&flagExtensionData != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, err
+		}
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing bytes in authenticator data", ErrMalformedResponse)
+	}
+	return authData, nil
+}
+
+// verifyAttestationStatement accepts "none" and self attestation in the
+// "packed" format. The creation options ask for no attestation, so statements
+// that chain to a vendor certificate are not expected and are refused.
+func verifyAttestationStatement(
+	format string, statement map[any]any, authData *authenticatorData, rawAuthData []byte, clientDataJSON []byte,
+) error {
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return fmt.Errorf("%w: none attestation carries a statement", ErrMalformedResponse)
+		}
+		return nil
+	case "packed":
+		if _, hasCertificates := statement["x5c"]; hasCertificates {
+			return fmt.Errorf("%w: packed attestation with certificates", ErrUnsupportedAttestation)
+		}
+		algorithm, _ := statement["alg"].(int64)
+		signature, _ := statement["sig"].([]byte)
+		credentialKey := authData.credential.publicKey
+		if algorithm != credentialKey.algorithm {
+			return fmt.Errorf("%w: attestation algorithm differs from the credential", ErrVerificationFailed)
+		}
+		if !credentialKey.verify(signedData(rawAuthData, clientDataJSON), signature) {
+			return fmt.Errorf("%w: attestation signature does not match", ErrVerificationFailed)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnsupportedAttestation, format)
+}
+
+// signedData is what authenticators sign: the authenticator data followed by
+// the SHA-256 of the client data.
+func signedData(authData []byte, clientDataJSON []byte) []byte {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	return append(bytes.Clone(authData), clientDataHash[:]...)
+}
@@ -0,0 +1,188 @@
+package webauthn_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRPID   = "qq.example"
+	testOrigin = "https://qq.example"
+)
+
+// cborPair keeps map entries in the order they are written.
+type cborPair struct {
+	key   any
+	value any
+}
+
+// encodeCBOR writes the subset of CBOR the relying party decodes.
+func encodeCBOR(value any) []byte {
+	switch v := value.(type) {
+	case int:
+		return encodeCBOR(int64(v))
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []cborPair:
+		out := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, encodeCBOR(pair.key)...)
+			out = append(out, encodeCBOR(pair.value)...)
+		}
+		return out
+	}
+	panic("unsupported cbor value")
+}
+
+func cborHead(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	}
+	return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+}
+
+// softAuthenticator plays the part of a platform authenticator holding one
+// credential.
+type softAuthenticator struct {
+	credentialID []byte
+	signer       crypto.Signer
+	coseKey      []byte
+	algorithm    int64
+	signCount    uint32
+	flags        byte
+	rpID         string
+}
+
+func newES256Authenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	x := key.X.FillBytes(make([]byte, 32))
+	y := key.Y.FillBytes(make([]byte, 32))
+	return newSoftAuthenticator(t, key, webauthnport.AlgorithmES256, encodeCBOR([]cborPair{
+		{1, 2}, {3, -7}, {-1, 1}, {-2, x}, {-3, y},
+	}))
+}
+
+func newEd25519Authenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return newSoftAuthenticator(t, private, webauthnport.AlgorithmEdDSA, encodeCBOR([]cborPair{
+		{1, 1}, {3, -8}, {-1, 6}, {-2, []byte(public)},
+	}))
+}
+
+func newSoftAuthenticator(t *testing.T, signer crypto.Signer, algorithm int64, coseKey []byte) *softAuthenticator {
+	t.Helper()
+	credentialID := make([]byte, 16)
+	_, err := rand.Read(credentialID)
+	require.NoError(t, err)
+	return &softAuthenticator{
+		credentialID: credentialID,
+		signer:       signer,
+		coseKey:      coseKey,
+		algorithm:    algorithm,
+		flags:        0x01 | 0x04 | 0x08,
+		rpID:         testRPID,
+	}
+}
+
+func clientDataJSON(t *testing.T, ceremony string, challenge []byte, origin string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+	require.NoError(t, err)
+	return data
+}
+
+func (a *softAuthenticator) authenticatorData(withCredential bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := a.flags
+	if withCredential {
+		flags |= 0x40
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if withCredential {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) sign(t *testing.T, authData []byte, clientData []byte) []byte {
+	t.Helper()
+	clientDataHash := sha256.Sum256(clientData)
+	message := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	var opts crypto.SignerOpts = crypto.Hash(0)
+	if a.algorithm == webauthnport.AlgorithmES256 {
+		digest := sha256.Sum256(message)
+		message = digest[:]
+		opts = crypto.SHA256
+	}
+	signature, err := a.signer.Sign(rand.Reader, message, opts)
+	require.NoError(t, err)
+	return signature
+}
+
+// create answers navigator.credentials.create with the given attestation format.
+func (a *softAuthenticator) create(
+	t *testing.T, challenge []byte, origin string, format string,
+) webauthnport.RegistrationResponse {
+	t.Helper()
+	clientData := clientDataJSON(t, "webauthn.create", challenge, origin)
+	authData := a.authenticatorData(true)
+
+	statement := []cborPair{}
+	if format == "packed" {
+		statement = []cborPair{{"alg", a.algorithm}, {"sig", a.sign(t, authData, clientData)}}
+	}
+	return webauthnport.RegistrationResponse{
+		ClientDataJSON: clientData,
+		AttestationObject: encodeCBOR([]cborPair{
+			{"fmt", format}, {"attStmt", statement}, {"authData", authData},
+		}),
+	}
+}
+
+// get answers navigator.credentials.get, bumping the counter first.
+func (a *softAuthenticator) get(t *testing.T, challenge []byte, origin string) webauthnport.AssertionResponse {
+	t.Helper()
+	a.signCount++
+	clientData := clientDataJSON(t, "webauthn.get", challenge, origin)
+	authData := a.authenticatorData(false)
+	return webauthnport.AssertionResponse{
+		ClientDataJSON:    clientData,
+		AuthenticatorData: authData,
+		Signature:         a.sign(t, authData, clientData),
+	}
+}
@@ -0,0 +1,180 @@
+package webauthn_test
+
+import (
+	"testing"
+
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRelyingParty() webauthnport.RelyingParty {
+	return webauthnport.NewRelyingParty(environment.WebAuthnEnvironment{
+		RPID:    testRPID,
+		RPName:  "QQ",
+		Origins: []string{testOrigin},
+	})
+}
+
+func newTestChallenge(t *testing.T) []byte {
+	t.Helper()
+	challenge, err := webauthnport.NewChallenge()
+	require.NoError(t, err)
+	require.Len(t, challenge, 32)
+	return challenge
+}
+
+func TestRelyingParty_RegistrationAndAssertion(t *testing.T) {
+	authenticators := map[string]func(*testing.T) *softAuthenticator{
+		"ES256": newES256Authenticator,
+		"EdDSA": newEd25519Authenticator,
+	}
+	for name, newAuthenticator := range authenticators {
+		t.Run(name, func(t *testing.T) {
+			rp := newTestRelyingParty()
+			authenticator := newAuthenticator(t)
+
+			challenge := newTestChallenge(t)
+			credential, err := rp.VerifyRegistration(challenge, authenticator.create(t, challenge, testOrigin, "none"))
+			require.NoError(t, err)
+			assert.Equal(t, authenticator.credentialID, credential.ID)
+			assert.Equal(t, authenticator.coseKey, credential.PublicKey)
+			assert.Equal(t, authenticator.algorithm, credential.Algorithm)
+			assert.True(t, credential.BackupEligible)
+			assert.False(t, credential.BackedUp)
+
+			challenge = newTestChallenge(t)
+			assertion, err := rp.VerifyAssertion(challenge, credential.PublicKey, authenticator.get(t, challenge, testOrigin))
+			require.NoError(t, err)
+			assert.Equal(t, uint32(1), assertion.SignCount)
+		})
+	}
+}
+
+func TestRelyingParty_PackedSelfAttestation(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := newES256Authenticator(t)
+	challenge := newTestChallenge(t)
+
+	_, err := rp.VerifyRegistration(challenge, authenticator.create(t, challenge, testOrigin, "packed"))
+	require.NoError(t, err)
+
+	response := authenticator.create(t, challenge, testOrigin, "fido-u2f")
+	_, err = rp.VerifyRegistration(challenge, response)
+	require.ErrorIs(t, err, webauthnport.ErrUnsupportedAttestation)
+}
+
+func TestRelyingParty_VerifyRegistration_Rejects(t *testing.T) {
+	rp := newTestRelyingParty()
+	challenge := newTestChallenge(t)
+
+	t.Run("Other challenge", func(t *testing.T) {
+		response := newES256Authenticator(t).create(t, newTestChallenge(t), testOrigin, "none")
+		_, err := rp.VerifyRegistration(challenge, response)
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+		assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+	})
+
+	t.Run("Other origin", func(t *testing.T) {
+		response := newES256Authenticator(t).create(t, challenge, "https://evil.example", "none")
+		_, err := rp.VerifyRegistration(challenge, response)
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+	})
+
+	t.Run("Other RP ID", func(t *testing.T) {
+		authenticator := newES256Authenticator(t)
+		authenticator.rpID = "evil.example"
+		_, err := rp.VerifyRegistration(challenge, authenticator.create(t, challenge, testOrigin, "none"))
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+	})
+
+	t.Run("User not verified", func(t *testing.T) {
+		authenticator := newES256Authenticator(t)
+		authenticator.flags = 0x01
+		_, err := rp.VerifyRegistration(challenge, authenticator.create(t, challenge, testOrigin, "none"))
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+	})
+
+	t.Run("Assertion client data", func(t *testing.T) {
+		authenticator := newES256Authenticator(t)
+		response := authenticator.create(t, challenge, testOrigin, "none")
+		response.ClientDataJSON = clientDataJSON(t, "webauthn.get", challenge, testOrigin)
+		_, err := rp.VerifyRegistration(challenge, response)
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+	})
+
+	t.Run("Unsupported algorithm", func(t *testing.T) {
+		authenticator := newES256Authenticator(t)
+		authenticator.coseKey = encodeCBOR([]cborPair{{1, 2}, {3, -36}})
+		_, err := rp.VerifyRegistration(challenge, authenticator.create(t, challenge, testOrigin, "none"))
+		require.ErrorIs(t, err, webauthnport.ErrUnsupportedAlgorithm)
+	})
+
+	t.Run("Garbage", func(t *testing.T) {
+		response := webauthnport.RegistrationResponse{
+			ClientDataJSON:    clientDataJSON(t, "webauthn.create", challenge, testOrigin),
+			AttestationObject: []byte{0xbf, 0x00},
+		}
+		_, err := rp.VerifyRegistration(challenge, response)
+		require.ErrorIs(t, err, webauthnport.ErrMalformedResponse)
+		assert.ErrorIs(t, err, qqerrors.ErrValidationError)
+	})
+}
+
+func TestRelyingParty_VerifyAssertion_Rejects(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := newES256Authenticator(t)
+	challenge := newTestChallenge(t)
+	credential, err := rp.VerifyRegistration(challenge, authenticator.create(t, challenge, testOrigin, "none"))
+	require.NoError(t, err)
+
+	t.Run("Tampered signature", func(t *testing.T) {
+		response := authenticator.get(t, challenge, testOrigin)
+		response.AuthenticatorData[len(response.AuthenticatorData)-1]++
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, response)
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+	})
+
+	t.Run("Key of another credential", func(t *testing.T) {
+		other := newES256Authenticator(t)
+		_, err := rp.VerifyAssertion(challenge, other.coseKey, authenticator.get(t, challenge, testOrigin))
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+	})
+
+	t.Run("Registration client data", func(t *testing.T) {
+		response := authenticator.get(t, challenge, testOrigin)
+		response.ClientDataJSON = clientDataJSON(t, "webauthn.create", challenge, testOrigin)
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, response)
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+	})
+
+	t.Run("Other challenge", func(t *testing.T) {
+		response := authenticator.get(t, newTestChallenge(t), testOrigin)
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, response)
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+	})
+}
+
+func TestChallengeFromClientData(t *testing.T) {
+	challenge := newTestChallenge(t)
+
+	decoded, err := webauthnport.ChallengeFromClientData(clientDataJSON(t, "webauthn.get", challenge, testOrigin))
+	require.NoError(t, err)
+	assert.Equal(t, challenge, decoded)
+
+	_, err = webauthnport.ChallengeFromClientData([]byte("not json"))
+	require.ErrorIs(t, err, webauthnport.ErrMalformedResponse)
+	_, err = webauthnport.ChallengeFromClientData([]byte(`{"challenge":"***"}`))
+	require.ErrorIs(t, err, webauthnport.ErrMalformedResponse)
+}
+
+func TestCheckSignCount(t *testing.T) {
+	require.NoError(t, webauthnport.CheckSignCount(0, 0))
+	require.NoError(t, webauthnport.CheckSignCount(0, 1))
+	require.NoError(t, webauthnport.CheckSignCount(7, 8))
+	require.ErrorIs(t, webauthnport.CheckSignCount(8, 8), webauthnport.ErrSignCountRegression)
+	require.ErrorIs(t, webauthnport.CheckSignCount(8, 3), webauthnport.ErrSignCountRegression)
+	require.ErrorIs(t, webauthnport.CheckSignCount(8, 0), webauthnport.ErrSignCountRegression)
+}
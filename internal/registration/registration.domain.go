@@ -1,16 +1,27 @@
 package registration
 
 import (
+	"net"
+	"strings"
+	"time"
+
+	tokenport "github.com/abdurrahimagca/qq-back/internal/platform/token"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
 	"github.com/danielgtaylor/huma/v2"
 )
 
-var moduleErrors = []int{400, 401, 403, 404, 500}
+var moduleErrors = []int{400, 401, 403, 404, 429, 500}
 var moduleTags = []string{"Registration"}
 
 const (
-	SendOtp       = "sendOtp"
-	VerifyOtp     = "verifyOtp"
-	RefreshTokens = "refreshTokens"
+	SendOtp             = "sendOtp"
+	VerifyOtp           = "verifyOtp"
+	RefreshTokens       = "refreshTokens"
+	GoogleLogin         = "googleLogin"
+	VerifyMagicLink     = "verifyMagicLink"
+	VerifySecondFactor  = "verifySecondFactor"
+	PasskeyLoginOptions = "passkeyLoginOptions"
+	VerifyPasskeyLogin  = "verifyPasskeyLogin"
 )
 
 var operations = map[string]huma.Operation{
@@ -18,7 +29,7 @@ var operations = map[string]huma.Operation{
 		Method:      "POST",
 		Path:        "/auth/send-otp",
 		Summary:     "Send OTP code to email for existing users or create new user account",
-		Description: "Send OTP code to email for existing users or create new user account",
+		Description: "Send an OTP code or a magic link to the email, creating the account on first use. Rate limited.",
 		OperationID: SendOtp,
 		Errors:      moduleErrors,
 		Tags:        moduleTags,
@@ -27,11 +38,29 @@ var operations = map[string]huma.Operation{
 		Method:      "POST",
 		Path:        "/auth/verify-otp",
 		Summary:     "Verify OTP code",
-		Description: "Verify OTP code",
+		Description: "Verify OTP code. Accounts with two-factor authentication get a second-factor token instead of tokens.",
 		OperationID: VerifyOtp,
 		Errors:      moduleErrors,
 		Tags:        moduleTags,
 	},
+	VerifyMagicLink: {
+		Method:      "POST",
+		Path:        "/auth/verify-magic-link",
+		Summary:     "Verify magic link",
+		Description: "Exchange the token of an emailed magic link for a token pair. Each link works once.",
+		OperationID: VerifyMagicLink,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+	},
+	VerifySecondFactor: {
+		Method:      "POST",
+		Path:        "/auth/verify-second-factor",
+		Summary:     "Finish a two-factor login",
+		Description: "Exchange a second-factor token and an authenticator or recovery code for a token pair",
+		OperationID: VerifySecondFactor,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+	},
 	RefreshTokens: {
 		Method:      "POST",
 		Path:        "/auth/refresh-tokens",
@@ -41,16 +70,54 @@ var operations = map[string]huma.Operation{
 		Errors:      moduleErrors,
 		Tags:        moduleTags,
 	},
+	GoogleLogin: {
+		Method:      "POST",
+		Path:        "/auth/google",
+		Summary:     "Login or register with a Google ID token",
+		Description: "Verify a Google ID token and login, creating the user account on first use",
+		OperationID: GoogleLogin,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+	},
+	PasskeyLoginOptions: {
+		Method:      "POST",
+		Path:        "/auth/passkey/options",
+		Summary:     "Start a passkey login",
+		Description: "Issue a challenge and the options to pass to navigator.credentials.get",
+		OperationID: PasskeyLoginOptions,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+	},
+	VerifyPasskeyLogin: {
+		Method:      "POST",
+		Path:        "/auth/passkey/verify",
+		Summary:     "Finish a passkey login",
+		Description: "Verify the assertion returned by navigator.credentials.get and exchange it for a token pair",
+		OperationID: VerifyPasskeyLogin,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+	},
 }
 
+// LoginMode selects what /auth/send-otp emails: a code to type back or a link to tap.
+type LoginMode string
+
+const (
+	LoginModeCode      LoginMode = "code"
+	LoginModeMagicLink LoginMode = "magic_link"
+)
+
 type SendOtpInput struct {
+	ClientParams
 	Body struct {
-		Email string `json:"email" doc:"Email address of the user" required:"true" format:"email"`
+		Email string    `json:"email" doc:"Email address of the user" required:"true" format:"email"`
+		Mode  LoginMode `json:"mode,omitempty" doc:"Send a code or a magic link" enum:"code,magic_link" default:"code"`
 	}
 }
 
 type SendOtpData struct {
-	IsNewUser bool `json:"isNewUser"`
+	IsNewUser   *bool `json:"isNewUser,omitempty" doc:"Left out when the service hides which emails have an account"`
+	ResendAfter int   `json:"resendAfter" doc:"Seconds until another code or link can be requested"`
 }
 
 type SendOtpOutput struct {
@@ -59,10 +126,90 @@ type SendOtpOutput struct {
 	}
 }
 
+// ClientInfo describes the device a login comes from. It is stored on the
+// session so users can recognise where they are signed in.
+type ClientInfo struct {
+	DeviceLabel string
+	IPAddress   string
+	UserAgent   string
+}
+
+// ClientParams is embedded in login inputs to capture the caller's device.
+type ClientParams struct {
+	UserAgent    string `header:"User-Agent" hidden:"true"`
+	ForwardedFor string `header:"X-Forwarded-For" hidden:"true"`
+	remoteAddr   string
+}
+
+func (p *ClientParams) Resolve(ctx huma.Context) []error {
+	p.remoteAddr = ctx.RemoteAddr()
+	return nil
+}
+
+// ClientInfo describes the caller with the address clientIP finds for it.
+func (p *ClientParams) ClientInfo(deviceLabel string, trustedProxies []*net.IPNet) ClientInfo {
+	return ClientInfo{
+		DeviceLabel: deviceLabel,
+		IPAddress:   p.clientIP(trustedProxies),
+		UserAgent:   p.UserAgent,
+	}
+}
+
+// clientIP is the connection address unless the connection comes from a
+// trusted proxy. X-Forwarded-For is then read from the right, skipping the
+// trusted proxies, up to the first address they did not add. Anyone can send
+// the header, so a hop that is not an IP ends the walk at the last address
+// known to be real.
+func (p *ClientParams) clientIP(trustedProxies []*net.IPNet) string {
+	addr := p.remoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+
+	hops := strings.Split(p.ForwardedFor, ",")
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(ip, trustedProxies); i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+	}
+	return ip.String()
+}
+
+func isTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 type VerifyOtpInput struct {
+	ClientParams
 	Body struct {
-		Email   string `json:"email" doc:"Email address of the user" required:"true" format:"email"`
-		OtpCode string `json:"otpCode" doc:"OTP code received via email" format:"number" required:"true" minLength:"6" maxLength:"6"`
+		Email       string `json:"email" doc:"Email address of the user" required:"true" format:"email"`
+		OtpCode     string `json:"otpCode" doc:"OTP code received via email" format:"number" required:"true" minLength:"6" maxLength:"6"`
+		DeviceLabel string `json:"deviceLabel,omitempty" doc:"Name of the device shown in the session list" maxLength:"255"`
+	}
+}
+
+type VerifyMagicLinkInput struct {
+	ClientParams
+	Body struct {
+		Token       string `json:"token" doc:"Token carried by the magic link" required:"true" minLength:"1"`
+		DeviceLabel string `json:"deviceLabel,omitempty" doc:"Name of the device shown in the session list" maxLength:"255"`
+	}
+}
+
+type VerifyMagicLinkOutput struct {
+	Body struct {
+		Data LoginData
 	}
 }
 
@@ -71,7 +218,52 @@ type TokenData struct {
 	RefreshToken string `json:"refreshToken"`
 }
 
+// SendResult reports whether /auth/send-otp created the account and how long
+// the caller has to wait before asking for another email. AccountHidden is set
+// when the send answers alike for every email; IsNewUser is then always false
+// and must not be shown.
+type SendResult struct {
+	IsNewUser     bool
+	ResendAfter   time.Duration
+	AccountHidden bool
+}
+
+// LoginResult is the outcome of a first-factor login: a token pair, or for
+// accounts with two-factor authentication only a SecondFactorToken. IsNewUser
+// is set on the first login of an account.
+type LoginResult struct {
+	Tokens            tokenport.GenerateTokenResult
+	SecondFactorToken string
+	IsNewUser         bool
+}
+
+// LoginData is returned by the first-factor login endpoints. When
+// secondFactorRequired is set there are no tokens yet; the secondFactorToken is
+// passed to /auth/verify-second-factor together with a code.
+type LoginData struct {
+	AccessToken          string `json:"accessToken,omitempty"`
+	RefreshToken         string `json:"refreshToken,omitempty"`
+	SecondFactorRequired bool   `json:"secondFactorRequired"`
+	SecondFactorToken    string `json:"secondFactorToken,omitempty"`
+	IsNewUser            bool   `json:"isNewUser" doc:"Set on the first login of the account"`
+}
+
 type VerifyOtpOutput struct {
+	Body struct {
+		Data LoginData
+	}
+}
+
+type VerifySecondFactorInput struct {
+	ClientParams
+	Body struct {
+		SecondFactorToken string `json:"secondFactorToken" doc:"Token returned by the first-factor login" required:"true" minLength:"1"`
+		Code              string `json:"code" doc:"Authenticator code or recovery code" required:"true" minLength:"6" maxLength:"32"`
+		DeviceLabel       string `json:"deviceLabel,omitempty" doc:"Name of the device shown in the session list" maxLength:"255"`
+	}
+}
+
+type VerifySecondFactorOutput struct {
 	Body struct {
 		Data TokenData
 	}
@@ -88,3 +280,39 @@ type RefreshTokensOutput struct {
 		Data TokenData
 	}
 }
+
+type GoogleLoginInput struct {
+	ClientParams
+	Body struct {
+		IDToken     string `json:"idToken" doc:"ID token issued by Google Sign-In" required:"true" minLength:"1"`
+		DeviceLabel string `json:"deviceLabel,omitempty" doc:"Name of the device shown in the session list" maxLength:"255"`
+	}
+}
+
+type GoogleLoginOutput struct {
+	Body struct {
+		Data LoginData
+	}
+}
+
+type PasskeyLoginOptionsInput struct{}
+
+type PasskeyLoginOptionsOutput struct {
+	Body struct {
+		Data webauthn.RequestOptions
+	}
+}
+
+type VerifyPasskeyLoginInput struct {
+	ClientParams
+	Body struct {
+		Credential  webauthn.AssertionCredentialJSON `json:"credential" doc:"Result of credential.toJSON()" required:"true"`
+		DeviceLabel string                           `json:"deviceLabel,omitempty" doc:"Name of the device shown in the session list" maxLength:"255"`
+	}
+}
+
+type VerifyPasskeyLoginOutput struct {
+	Body struct {
+		Data TokenData
+	}
+}
@@ -1,10 +1,17 @@
 package registration
 
 import (
+	"net"
+
 	"github.com/abdurrahimagca/qq-back/internal/auth"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
 	mailport "github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	oauthport "github.com/abdurrahimagca/qq-back/internal/platform/oauth"
+	"github.com/abdurrahimagca/qq-back/internal/platform/ratelimit"
 	tokenport "github.com/abdurrahimagca/qq-back/internal/platform/token"
 	"github.com/abdurrahimagca/qq-back/internal/user"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -20,9 +27,17 @@ func NewModule(
 	userService user.Service,
 	pool *pgxpool.Pool,
 	tokenService tokenport.Service,
+	googleVerifier oauthport.Verifier,
+	passkeyService webauthn.Service,
+	emailPolicy emailpolicy.Policy,
+	limiter ratelimit.Limiter,
+	conf environment.OTPEnvironment,
+	limits environment.RateLimitEnvironment,
+	trustedProxies []*net.IPNet,
 ) *Module {
-	usecase := NewUsecase(mailer, authService, userService, pool, tokenService)
-	server := NewServer(usecase)
+	usecase := NewUsecase(
+		mailer, authService, userService, pool, tokenService, googleVerifier, passkeyService, emailPolicy, conf)
+	server := NewServer(usecase, limiter, limits, trustedProxies)
 
 	return &Module{
 		usecase: usecase,
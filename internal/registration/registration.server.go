@@ -2,48 +2,125 @@ package registration
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"strings"
 
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
+	"github.com/abdurrahimagca/qq-back/internal/platform/ratelimit"
 	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
 	"github.com/danielgtaylor/huma/v2"
 )
 
 type registrationServer struct {
-	uc Usecase
+	uc             Usecase
+	limiter        ratelimit.Limiter
+	limits         environment.RateLimitEnvironment
+	trustedProxies []*net.IPNet
 }
 type Server interface {
 	SendOtpHandler(ctx context.Context, input *SendOtpInput) (*SendOtpOutput, error)
 	VerifyOtpHandler(ctx context.Context, input *VerifyOtpInput) (*VerifyOtpOutput, error)
+	VerifyMagicLinkHandler(ctx context.Context, input *VerifyMagicLinkInput) (*VerifyMagicLinkOutput, error)
+	VerifySecondFactorHandler(ctx context.Context, input *VerifySecondFactorInput) (*VerifySecondFactorOutput, error)
 	RefreshTokensHandler(ctx context.Context, input *RefreshTokensInput) (*RefreshTokensOutput, error)
+	GoogleLoginHandler(ctx context.Context, input *GoogleLoginInput) (*GoogleLoginOutput, error)
+	PasskeyLoginOptionsHandler(
+		ctx context.Context, input *PasskeyLoginOptionsInput) (*PasskeyLoginOptionsOutput, error)
+	VerifyPasskeyLoginHandler(ctx context.Context, input *VerifyPasskeyLoginInput) (*VerifyPasskeyLoginOutput, error)
 	RegisterRegistrationEndpoints(api huma.API)
 }
 
-func NewServer(uc Usecase) Server {
-	return &registrationServer{uc: uc}
+// NewServer reads client addresses from X-Forwarded-For only on connections
+// from trustedProxies.
+func NewServer(
+	uc Usecase, limiter ratelimit.Limiter, limits environment.RateLimitEnvironment, trustedProxies []*net.IPNet,
+) Server {
+	return &registrationServer{uc: uc, limiter: limiter, limits: limits, trustedProxies: trustedProxies}
 }
 
 func (s *registrationServer) SendOtpHandler(ctx context.Context, input *SendOtpInput) (*SendOtpOutput, error) {
-	isNewUser, err := s.uc.RegisterOrLoginOTP(ctx, input.Body.Email)
+	if err := s.limitSend(ctx, input); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	send := s.uc.RegisterOrLoginOTP
+	if input.Body.Mode == LoginModeMagicLink {
+		send = s.uc.RegisterOrLoginMagicLink
+	}
+	result, err := send(ctx, input.Body.Email)
 	if err != nil {
 		return nil, qqerrors.GetHumaErrorFromError(err)
 	}
 
+	data := SendOtpData{ResendAfter: int(math.Ceil(result.ResendAfter.Seconds()))}
+	if !result.AccountHidden {
+		data.IsNewUser = &result.IsNewUser
+	}
 	return &SendOtpOutput{
 		Body: struct {
 			Data SendOtpData
 		}{
-			Data: SendOtpData{
-				IsNewUser: *isNewUser,
-			},
+			Data: data,
 		},
 	}, nil
 }
 
 func (s *registrationServer) VerifyOtpHandler(ctx context.Context, input *VerifyOtpInput) (*VerifyOtpOutput, error) {
-	tokenPair, err := s.uc.VerifyOTPAndLogin(ctx, input.Body.Email, input.Body.OtpCode)
+	client := input.ClientInfo(input.Body.DeviceLabel, s.trustedProxies)
+	if err := s.limitLogin(ctx, client); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	result, err := s.uc.VerifyOTPAndLogin(ctx, input.Body.Email, input.Body.OtpCode, client)
 	if err != nil {
 		return nil, qqerrors.GetHumaErrorFromError(err)
 	}
 	return &VerifyOtpOutput{
+		Body: struct {
+			Data LoginData
+		}{
+			Data: toLoginData(result),
+		},
+	}, nil
+}
+
+func (s *registrationServer) VerifyMagicLinkHandler(
+	ctx context.Context, input *VerifyMagicLinkInput) (*VerifyMagicLinkOutput, error) {
+	client := input.ClientInfo(input.Body.DeviceLabel, s.trustedProxies)
+	if err := s.limitLogin(ctx, client); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	result, err := s.uc.VerifyMagicLink(ctx, input.Body.Token, client)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &VerifyMagicLinkOutput{
+		Body: struct {
+			Data LoginData
+		}{
+			Data: toLoginData(result),
+		},
+	}, nil
+}
+
+func (s *registrationServer) VerifySecondFactorHandler(
+	ctx context.Context, input *VerifySecondFactorInput) (*VerifySecondFactorOutput, error) {
+	client := input.ClientInfo(input.Body.DeviceLabel, s.trustedProxies)
+	if err := s.limitLogin(ctx, client); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	tokenPair, err := s.uc.VerifySecondFactor(ctx, input.Body.SecondFactorToken, input.Body.Code, client)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &VerifySecondFactorOutput{
 		Body: struct {
 			Data TokenData
 		}{
@@ -73,8 +150,126 @@ func (s *registrationServer) RefreshTokensHandler(
 	}, nil
 }
 
+func (s *registrationServer) GoogleLoginHandler(
+	ctx context.Context, input *GoogleLoginInput) (*GoogleLoginOutput, error) {
+	client := input.ClientInfo(input.Body.DeviceLabel, s.trustedProxies)
+	if err := s.limitLogin(ctx, client); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	result, err := s.uc.LoginWithGoogle(ctx, input.Body.IDToken, client)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &GoogleLoginOutput{
+		Body: struct {
+			Data LoginData
+		}{
+			Data: toLoginData(result),
+		},
+	}, nil
+}
+
+func (s *registrationServer) PasskeyLoginOptionsHandler(
+	ctx context.Context, _ *PasskeyLoginOptionsInput) (*PasskeyLoginOptionsOutput, error) {
+	options, err := s.uc.BeginPasskeyLogin(ctx)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &PasskeyLoginOptionsOutput{
+		Body: struct {
+			Data webauthn.RequestOptions
+		}{
+			Data: *options,
+		},
+	}, nil
+}
+
+func (s *registrationServer) VerifyPasskeyLoginHandler(
+	ctx context.Context, input *VerifyPasskeyLoginInput) (*VerifyPasskeyLoginOutput, error) {
+	client := input.ClientInfo(input.Body.DeviceLabel, s.trustedProxies)
+	if err := s.limitLogin(ctx, client); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	assertion, err := input.Body.Credential.Decode()
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	tokenPair, err := s.uc.VerifyPasskeyLogin(ctx, assertion, client)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &VerifyPasskeyLoginOutput{
+		Body: struct {
+			Data TokenData
+		}{
+			Data: TokenData{
+				AccessToken:  tokenPair.AccessToken,
+				RefreshToken: tokenPair.RefreshToken,
+			},
+		},
+	}, nil
+}
+
+// limitSend charges a send against the budget of the email, the caller's IP and
+// the whole service, in that order, so a flood aimed at one inbox is stopped
+// before it eats into the budgets everyone shares.
+func (s *registrationServer) limitSend(ctx context.Context, input *SendOtpInput) error {
+	// Emails are hashed so addresses are not kept in the rate limit store, and
+	// normalized so plus tags do not open a fresh budget for the same inbox.
+	emailKey, err := emailpolicy.Normalize(input.Body.Email)
+	if err != nil {
+		emailKey = strings.ToLower(strings.TrimSpace(input.Body.Email))
+	}
+	emailHash := sha256.Sum256([]byte(emailKey))
+
+	return ratelimit.Enforce(ctx, s.limiter,
+		ratelimit.Rule{
+			Name:    "send_otp_email",
+			Subject: hex.EncodeToString(emailHash[:]),
+			Limit:   s.limits.SendOTPPerEmail,
+		},
+		ratelimit.Rule{
+			Name:    "send_otp_ip",
+			Subject: input.ClientInfo("", s.trustedProxies).IPAddress,
+			Limit:   s.limits.SendOTPPerIP,
+		},
+		ratelimit.Rule{Name: "send_otp_global", Subject: "all", Limit: s.limits.SendOTPGlobal},
+	)
+}
+
+// limitLogin charges an attempt to complete a login against the caller's IP.
+// Refreshing is left out: it needs a valid refresh token, which is not
+// guessable and is revoked with its whole family on reuse.
+func (s *registrationServer) limitLogin(ctx context.Context, client ClientInfo) error {
+	return ratelimit.Enforce(ctx, s.limiter,
+		ratelimit.Rule{Name: "login_ip", Subject: client.IPAddress, Limit: s.limits.LoginPerIP},
+	)
+}
+
 func (s *registrationServer) RegisterRegistrationEndpoints(api huma.API) {
 	huma.Register(api, operations[SendOtp], s.SendOtpHandler)
 	huma.Register(api, operations[VerifyOtp], s.VerifyOtpHandler)
+	huma.Register(api, operations[VerifyMagicLink], s.VerifyMagicLinkHandler)
+	huma.Register(api, operations[VerifySecondFactor], s.VerifySecondFactorHandler)
 	huma.Register(api, operations[RefreshTokens], s.RefreshTokensHandler)
+	huma.Register(api, operations[GoogleLogin], s.GoogleLoginHandler)
+	huma.Register(api, operations[PasskeyLoginOptions], s.PasskeyLoginOptionsHandler)
+	huma.Register(api, operations[VerifyPasskeyLogin], s.VerifyPasskeyLoginHandler)
+}
+
+func toLoginData(result LoginResult) LoginData {
+	if result.SecondFactorToken != "" {
+		return LoginData{
+			SecondFactorRequired: true,
+			SecondFactorToken:    result.SecondFactorToken,
+		}
+	}
+	return LoginData{
+		AccessToken:  result.Tokens.AccessToken,
+		RefreshToken: result.Tokens.RefreshToken,
+		IsNewUser:    result.IsNewUser,
+	}
 }
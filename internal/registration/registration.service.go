@@ -2,32 +2,72 @@ package registration
 
 import (
 	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"time"
 
 	"strings"
 
 	"errors"
 
 	"github.com/abdurrahimagca/qq-back/internal/auth"
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
 	mail "github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	oauthport "github.com/abdurrahimagca/qq-back/internal/platform/oauth"
 	tokenport "github.com/abdurrahimagca/qq-back/internal/platform/token"
 	"github.com/abdurrahimagca/qq-back/internal/user"
 	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// secondFactorTTL bounds how long a user has to enter their authenticator code
+// after passing the first factor.
+const secondFactorTTL = 5 * time.Minute
+
+const (
+	maxDeviceLabelLength = 255
+	maxIPAddressLength   = 64
+	maxUserAgentLength   = 512
+)
+
 type Usecase interface {
-	RegisterOrLoginOTP(ctx context.Context, email string) (*bool, error)
-	VerifyOTPAndLogin(ctx context.Context, email string, otp string) (tokenport.GenerateTokenResult, error)
+	RegisterOrLoginOTP(ctx context.Context, email string) (SendResult, error)
+	RegisterOrLoginMagicLink(ctx context.Context, email string) (SendResult, error)
+	VerifyOTPAndLogin(ctx context.Context, email string, otp string, client ClientInfo) (LoginResult, error)
+	VerifyMagicLink(ctx context.Context, linkToken string, client ClientInfo) (LoginResult, error)
+	VerifySecondFactor(
+		ctx context.Context, secondFactorToken string, code string, client ClientInfo,
+	) (tokenport.GenerateTokenResult, error)
 	RefreshTokens(ctx context.Context, refreshToken string) (tokenport.GenerateTokenResult, error)
+	LoginWithGoogle(ctx context.Context, idToken string, client ClientInfo) (LoginResult, error)
+	BeginPasskeyLogin(ctx context.Context) (*webauthn.RequestOptions, error)
+	VerifyPasskeyLogin(
+		ctx context.Context, assertion webauthn.Assertion, client ClientInfo,
+	) (tokenport.GenerateTokenResult, error)
 }
 
 type registrationUsecase struct {
-	mailer       mail.Service
-	authService  auth.Service
-	userService  user.Service
-	dbpool       *pgxpool.Pool
-	tokenService tokenport.Service
+	mailer         mail.Service
+	authService    auth.Service
+	userService    user.Service
+	dbpool         *pgxpool.Pool
+	tokenService   tokenport.Service
+	googleVerifier oauthport.Verifier
+	passkeyService webauthn.Service
+	emailPolicy    emailpolicy.Policy
+	magicLinkURL   string
+	// magicLinkTTL matches the expiry of the auth_otp_codes row holding the link.
+	magicLinkTTL   time.Duration
+	resendCooldown time.Duration
+	// hideAccountExistence and sendMinDuration implement the private send
+	// mode; see sendLoginEmail.
+	hideAccountExistence bool
+	sendMinDuration      time.Duration
 }
 
 func NewUsecase(
@@ -36,20 +76,112 @@ func NewUsecase(
 	userService user.Service,
 	pool *pgxpool.Pool,
 	tokenService tokenport.Service,
+	googleVerifier oauthport.Verifier,
+	passkeyService webauthn.Service,
+	emailPolicy emailpolicy.Policy,
+	conf environment.OTPEnvironment,
 ) Usecase {
+	magicLinkTTL := conf.Lifetime
+	if magicLinkTTL <= 0 {
+		magicLinkTTL = auth.DefaultOTPLifetime
+	}
 	return &registrationUsecase{
-		mailer:       mailer,
-		authService:  authService,
-		userService:  userService,
-		dbpool:       pool,
-		tokenService: tokenService,
+		mailer:               mailer,
+		authService:          authService,
+		userService:          userService,
+		dbpool:               pool,
+		tokenService:         tokenService,
+		googleVerifier:       googleVerifier,
+		passkeyService:       passkeyService,
+		emailPolicy:          emailPolicy,
+		magicLinkURL:         conf.MagicLinkURL,
+		magicLinkTTL:         magicLinkTTL,
+		resendCooldown:       conf.ResendCooldown,
+		hideAccountExistence: conf.HideAccountExistence,
+		sendMinDuration:      conf.SendMinDuration,
+	}
+}
+
+func (uc *registrationUsecase) RegisterOrLoginOTP(ctx context.Context, emailAddr string) (SendResult, error) {
+	return uc.sendLoginEmail(ctx, emailAddr, LoginModeCode)
+}
+
+// RegisterOrLoginMagicLink works like RegisterOrLoginOTP but emails a
+// single-use login link instead of a code.
+func (uc *registrationUsecase) RegisterOrLoginMagicLink(ctx context.Context, emailAddr string) (SendResult, error) {
+	return uc.sendLoginEmail(ctx, emailAddr, LoginModeMagicLink)
+}
+
+// sendLoginEmail emails a login code or link, see deliverLoginEmail. When
+// account existence is hidden, refusals that only an existing account can get
+// are answered like a successful send without sending anything, IsNewUser is
+// not reported, and every send takes at least sendMinDuration. Refusals of the
+// address itself, such as the email policy, are still returned; they apply to
+// existing accounts alike in this mode.
+func (uc *registrationUsecase) sendLoginEmail(
+	ctx context.Context, emailAddr string, mode LoginMode,
+) (SendResult, error) {
+	if !uc.hideAccountExistence {
+		return uc.deliverLoginEmail(ctx, emailAddr, mode)
+	}
+
+	started := time.Now()
+	defer uc.waitForSendMinDuration(ctx, started)
+
+	_, err := uc.deliverLoginEmail(ctx, emailAddr, mode)
+	if err != nil && !revealsAccount(err) {
+		return SendResult{}, err
+	}
+	return SendResult{ResendAfter: uc.resendCooldown, AccountHidden: true}, nil
+}
+
+// revealsAccount reports whether a send was refused for a reason only an
+// existing account can have.
+func revealsAccount(err error) bool {
+	return errors.Is(err, auth.ErrIdentityNotLinked) ||
+		errors.Is(err, auth.ErrAccountSuspended) ||
+		errors.Is(err, auth.ErrAccountDeleted) ||
+		errors.Is(err, auth.ErrOtpResendTooSoon)
+}
+
+func (uc *registrationUsecase) waitForSendMinDuration(ctx context.Context, started time.Time) {
+	wait := uc.sendMinDuration - time.Since(started)
+	if wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
 	}
 }
 
-func (uc *registrationUsecase) RegisterOrLoginOTP(ctx context.Context, emailAddr string) (*bool, error) {
+// deliverLoginEmail resolves the account by the normalized email, creating it
+// with the address as entered on first use if the email policy allows it, and
+// emails a new code or link in the given mode to the address stored on the
+// account. Existing accounts are held to the resend cooldown, and the code or
+// link sent before stays valid until it expires so a resend does not break an
+// email that is still on its way. When account existence is hidden the policy
+// is checked for every address before the lookup, so a refusal says nothing
+// about whether an account exists.
+func (uc *registrationUsecase) deliverLoginEmail(
+	ctx context.Context, emailAddr string, mode LoginMode,
+) (SendResult, error) {
+	emailAddr = strings.TrimSpace(emailAddr)
+	normalized, err := emailpolicy.Normalize(emailAddr)
+	if err != nil {
+		return SendResult{}, err
+	}
+	if uc.hideAccountExistence {
+		if policyErr := uc.emailPolicy.Allow(ctx, emailAddr); policyErr != nil {
+			return SendResult{}, policyErr
+		}
+	}
+
 	tx, err := uc.dbpool.Begin(ctx)
 	if err != nil {
-		return nil, err
+		return SendResult{}, err
 	}
 	defer func() {
 		if tx != nil {
@@ -61,111 +193,246 @@ func (uc *registrationUsecase) RegisterOrLoginOTP(ctx context.Context, emailAddr
 	txUserService := uc.userService.WithTx(tx)
 	var isNewUser bool
 
-	foundUser, err := txUserService.GetUserByEmail(ctx, emailAddr)
+	foundUser, err := txUserService.GetUserByEmail(ctx, normalized)
 	if err != nil && !errors.Is(err, qqerrors.ErrNotFound) {
-		return nil, err
+		return SendResult{}, err
 	}
 
 	var authID pgtype.UUID
+	recipient := emailAddr
 	if foundUser != nil && foundUser.ID.Valid {
 		isNewUser = false
 		authID = foundUser.AuthID
+		authRow, authErr := txAuthService.GetAuthByID(ctx, authID)
+		if authErr != nil {
+			return SendResult{}, authErr
+		}
+		recipient = authRow.Email
+
+		// Accounts that unlinked email login (or never had it) cannot sign in with a code or link.
+		hasEmailLogin, identityErr := txAuthService.HasIdentity(ctx, authID, db.AuthProviderEmailOtp)
+		if identityErr != nil {
+			return SendResult{}, identityErr
+		}
+		if !hasEmailLogin {
+			return SendResult{}, auth.ErrIdentityNotLinked
+		}
+
+		if suspendedErr := txAuthService.CheckSuspension(ctx, authID); suspendedErr != nil {
+			return SendResult{}, suspendedErr
+		}
+
+		if cooldownErr := txAuthService.CheckOTPResendCooldown(ctx, authID); cooldownErr != nil {
+			return SendResult{}, cooldownErr
+		}
 	} else {
+		if !uc.hideAccountExistence {
+			if policyErr := uc.emailPolicy.Allow(ctx, emailAddr); policyErr != nil {
+				return SendResult{}, policyErr
+			}
+		}
 		isNewUser = true
 		authIDPtr, createAuthErr := txAuthService.CreateNewAuthForOTPLogin(ctx, emailAddr)
 		if createAuthErr != nil {
-			return nil, createAuthErr
+			return SendResult{}, createAuthErr
 		}
 		authID = *authIDPtr
 
 		foundUser, err = txUserService.CreateDefaultUserWithAuthID(ctx, authID)
 		if err != nil {
-			return nil, err
+			return SendResult{}, err
 		}
 	}
 
-	err = txAuthService.KillOrphanedOTPsByUserID(ctx, foundUser.ID)
+	err = txAuthService.PruneSupersededOTPs(ctx, authID)
 	if err != nil {
-		return nil, err
+		return SendResult{}, err
 	}
 
-	otp, err := txAuthService.GenerateAndSaveOTPForAuth(ctx, authID)
-	if err != nil {
-		return nil, err
+	var email mail.SendParams
+	if mode == LoginModeMagicLink {
+		email, err = uc.magicLinkEmail(ctx, txAuthService, authID, foundUser.ID)
+	} else {
+		email, err = uc.otpEmail(ctx, txAuthService, authID)
 	}
-
-	template, err := uc.mailer.GetTemplate(ctx, "otp")
 	if err != nil {
-		return nil, err
+		return SendResult{}, err
 	}
 
 	if commitErr := tx.Commit(ctx); commitErr != nil {
-		return nil, commitErr
+		return SendResult{}, commitErr
 	}
 	tx = nil
 
-	body := strings.Replace(template, "{{.OTP}}", otp, 1)
-	err = uc.mailer.SendEmail(ctx, mail.SendParams{
-		From:    "qq@homelab-kaleici.space",
-		To:      emailAddr,
+	email.To = recipient
+	err = uc.mailer.SendEmail(ctx, email)
+	if err != nil {
+		return SendResult{}, err
+	}
+
+	return SendResult{IsNewUser: isNewUser, ResendAfter: uc.resendCooldown}, nil
+}
+
+func (uc *registrationUsecase) otpEmail(
+	ctx context.Context, authService auth.Service, authID pgtype.UUID,
+) (mail.SendParams, error) {
+	otp, err := authService.GenerateAndSaveOTPForAuth(ctx, authID)
+	if err != nil {
+		return mail.SendParams{}, err
+	}
+
+	template, err := uc.mailer.GetTemplate(ctx, "otp")
+	if err != nil {
+		return mail.SendParams{}, err
+	}
+
+	return mail.SendParams{
+		From:    mail.DefaultFrom,
 		Subject: "OTP Verification",
-		Body:    body,
+		Body:    strings.Replace(template, "{{.OTP}}", otp, 1),
+	}, nil
+}
+
+// magicLinkEmail stores a new magic-link nonce and signs it into the link. The
+// signature binds the nonce to the user; the stored hash makes it single-use.
+func (uc *registrationUsecase) magicLinkEmail(
+	ctx context.Context, authService auth.Service, authID pgtype.UUID, userID pgtype.UUID,
+) (mail.SendParams, error) {
+	nonce, err := authService.GenerateAndSaveMagicLinkForAuth(ctx, authID)
+	if err != nil {
+		return mail.SendParams{}, err
+	}
+
+	linkToken, err := uc.tokenService.GeneratePurposeToken(ctx, tokenport.PurposeTokenParams{
+		UserID: userID.String(),
+		Use:    tokenport.TokenUseMagicLink,
+		ID:     nonce,
+		TTL:    uc.magicLinkTTL,
 	})
 	if err != nil {
-		return nil, err
+		return mail.SendParams{}, err
+	}
+
+	link, err := url.Parse(uc.magicLinkURL)
+	if err != nil {
+		return mail.SendParams{}, fmt.Errorf("invalid magic link url: %w", err)
 	}
+	query := link.Query()
+	query.Set("token", linkToken)
+	link.RawQuery = query.Encode()
 
-	return &isNewUser, nil
+	template, err := uc.mailer.GetTemplate(ctx, "magic_link")
+	if err != nil {
+		return mail.SendParams{}, err
+	}
+
+	return mail.SendParams{
+		From:    mail.DefaultFrom,
+		Subject: "Your login link",
+		Body:    strings.ReplaceAll(template, "{{.Link}}", html.EscapeString(link.String())),
+	}, nil
 }
+
 func (uc *registrationUsecase) VerifyOTPAndLogin(
-	ctx context.Context, emailAddr string, otp string,
-) (tokenport.GenerateTokenResult, error) {
-	tx, err := uc.dbpool.Begin(ctx)
+	ctx context.Context, emailAddr string, otp string, client ClientInfo,
+) (LoginResult, error) {
+	normalized, err := emailpolicy.Normalize(emailAddr)
 	if err != nil {
-		return tokenport.GenerateTokenResult{}, err
+		return LoginResult{}, err
 	}
-	defer func() {
-		if tx != nil {
-			_ = tx.Rollback(ctx)
-		}
-	}()
 
-	txAuthService := uc.authService.WithTx(tx)
-	txUserService := uc.userService.WithTx(tx)
+	// Verify against the pool rather than a transaction so failed attempts are
+	// persisted even when the login itself fails.
+	userID, err := uc.authService.VerifyOTP(ctx, normalized, otp)
+	if err != nil {
+		return LoginResult{}, err
+	}
 
-	err = txAuthService.VerifyOTP(ctx, emailAddr, otp)
+	err = uc.authService.KillOrphanedOTPsByUserID(ctx, userID)
 	if err != nil {
-		return tokenport.GenerateTokenResult{}, err
+		return LoginResult{}, err
 	}
 
-	user, err := txUserService.GetUserByEmail(ctx, emailAddr)
+	user, err := uc.userService.GetUserByID(ctx, userID)
 	if err != nil {
-		return tokenport.GenerateTokenResult{}, err
+		return LoginResult{}, err
 	}
+	return uc.completeLogin(ctx, user, client)
+}
 
-	err = txAuthService.KillOrphanedOTPsByUserID(ctx, user.ID)
+// VerifyMagicLink exchanges the token of a magic link for a token pair. The link
+// is consumed, so it cannot be used twice, and pending codes are dropped with it.
+func (uc *registrationUsecase) VerifyMagicLink(
+	ctx context.Context, linkToken string, client ClientInfo,
+) (LoginResult, error) {
+	tokenResult, err := uc.tokenService.ValidatePurposeToken(ctx, tokenport.ValidateTokenParams{
+		Token: linkToken,
+	}, tokenport.TokenUseMagicLink)
+	if err != nil {
+		return LoginResult{}, auth.ErrInvalidMagicLink
+	}
+
+	var userID pgtype.UUID
+	if scanErr := userID.Scan(tokenResult.Claims.UserID); scanErr != nil || tokenResult.Claims.ID == "" {
+		return LoginResult{}, auth.ErrInvalidMagicLink
+	}
+
+	if err = uc.authService.ConsumeMagicLink(ctx, userID, tokenResult.Claims.ID); err != nil {
+		return LoginResult{}, err
+	}
+
+	err = uc.authService.KillOrphanedOTPsByUserID(ctx, userID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	user, err := uc.userService.GetUserByID(ctx, userID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return uc.completeLogin(ctx, user, client)
+}
+
+// VerifySecondFactor finishes a login that stopped at the second factor. The
+// code may come from the authenticator app or be one of the recovery codes.
+func (uc *registrationUsecase) VerifySecondFactor(
+	ctx context.Context, secondFactorToken string, code string, client ClientInfo,
+) (tokenport.GenerateTokenResult, error) {
+	tokenResult, err := uc.tokenService.ValidatePurposeToken(ctx, tokenport.ValidateTokenParams{
+		Token: secondFactorToken,
+	}, tokenport.TokenUseSecondFactor)
 	if err != nil {
 		return tokenport.GenerateTokenResult{}, err
 	}
 
-	if commitErr := tx.Commit(ctx); commitErr != nil {
-		return tokenport.GenerateTokenResult{}, commitErr
+	var userID pgtype.UUID
+	if scanErr := userID.Scan(tokenResult.Claims.UserID); scanErr != nil {
+		return tokenport.GenerateTokenResult{}, tokenport.ErrInvalidToken
 	}
-	tx = nil
 
-	tokenPair, err := uc.tokenService.GenerateTokens(ctx, tokenport.GenerateTokenParams{
-		UserID: user.ID.String(),
-	})
+	user, err := uc.userService.GetUserByID(ctx, userID)
 	if err != nil {
 		return tokenport.GenerateTokenResult{}, err
 	}
+	if err = uc.authService.CheckSuspension(ctx, user.AuthID); err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
 
-	return tokenPair, nil
+	// Outside a transaction, like VerifyOTP, so failed attempts are persisted.
+	if err = uc.authService.VerifySecondFactor(ctx, user.AuthID, code); err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+
+	return uc.startSession(ctx, user.ID, client)
 }
+
+// RefreshTokens exchanges a refresh token for a new pair. The presented token is
+// consumed and its successor joins the same family; replaying a consumed token
+// revokes the whole family.
 func (uc *registrationUsecase) RefreshTokens(
 	ctx context.Context, refreshToken string,
 ) (tokenport.GenerateTokenResult, error) {
-	tokenResult, err := uc.tokenService.ValidateToken(ctx, tokenport.ValidateTokenParams{
+	tokenResult, err := uc.tokenService.ValidateRefreshToken(ctx, tokenport.ValidateTokenParams{
 		Token: refreshToken,
 	})
 	if err != nil {
@@ -181,17 +448,239 @@ func (uc *registrationUsecase) RefreshTokens(
 	if scanErr := userUUID.Scan(userID); scanErr != nil {
 		return tokenport.GenerateTokenResult{}, scanErr
 	}
+	tokenID := pgtype.UUID{}
+	if scanErr := tokenID.Scan(tokenResult.Claims.ID); scanErr != nil {
+		return tokenport.GenerateTokenResult{}, auth.ErrInvalidRefreshToken
+	}
+
 	user, err := uc.userService.GetUserByID(ctx, userUUID)
 	if err != nil {
 		return tokenport.GenerateTokenResult{}, err
 	}
+	if err = uc.authService.CheckSuspension(ctx, user.AuthID); err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+
+	consumed, err := uc.authService.RotateRefreshToken(ctx, tokenID, userUUID)
+	if err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+
+	sessionID := consumed.SessionID
+	if !sessionID.Valid {
+		// Tokens issued before sessions existed get one on their next refresh.
+		session, createErr := uc.authService.CreateSession(ctx, db.InsertSessionParams{UserID: user.ID})
+		if createErr != nil {
+			return tokenport.GenerateTokenResult{}, createErr
+		}
+		sessionID = session.ID
+	} else {
+		active, activeErr := uc.authService.IsSessionActive(ctx, sessionID, user.ID)
+		if activeErr != nil {
+			return tokenport.GenerateTokenResult{}, activeErr
+		}
+		if !active {
+			return tokenport.GenerateTokenResult{}, auth.ErrSessionRevoked
+		}
+		if touchErr := uc.authService.TouchSession(ctx, sessionID); touchErr != nil {
+			return tokenport.GenerateTokenResult{}, touchErr
+		}
+	}
+
+	return uc.issueTokens(ctx, user.ID, sessionID, consumed)
+}
+
+// completeLogin is called once the first factor is verified. Suspended accounts
+// are turned away; accounts without two-factor authentication get a session
+// straight away, and are reported as new when the login is the one that records
+// the account's first login; the others get a second-factor token and a fresh
+// budget of attempts to redeem it with. Two-factor and passkey accounts were
+// signed in to set those up, so their first login is already recorded.
+func (uc *registrationUsecase) completeLogin(
+	ctx context.Context, user *db.User, client ClientInfo,
+) (LoginResult, error) {
+	if err := uc.authService.CheckSuspension(ctx, user.AuthID); err != nil {
+		return LoginResult{}, err
+	}
+
+	enabled, err := uc.authService.IsTOTPEnabled(ctx, user.AuthID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if !enabled {
+		tokens, sessionErr := uc.startSession(ctx, user.ID, client)
+		if sessionErr != nil {
+			return LoginResult{}, sessionErr
+		}
+		firstLogin, markErr := uc.authService.MarkFirstLogin(ctx, user.AuthID)
+		if markErr != nil {
+			return LoginResult{}, markErr
+		}
+		return LoginResult{Tokens: tokens, IsNewUser: firstLogin}, nil
+	}
 
-	newTokens, err := uc.tokenService.GenerateTokens(ctx, tokenport.GenerateTokenParams{
+	if err = uc.authService.ResetTOTPAttempts(ctx, user.AuthID); err != nil {
+		return LoginResult{}, err
+	}
+	secondFactorToken, err := uc.tokenService.GeneratePurposeToken(ctx, tokenport.PurposeTokenParams{
 		UserID: user.ID.String(),
+		Use:    tokenport.TokenUseSecondFactor,
+		TTL:    secondFactorTTL,
 	})
 	if err != nil {
+		return LoginResult{}, err
+	}
+	return LoginResult{SecondFactorToken: secondFactorToken}, nil
+}
+
+func (uc *registrationUsecase) BeginPasskeyLogin(ctx context.Context) (*webauthn.RequestOptions, error) {
+	return uc.passkeyService.BeginLogin(ctx)
+}
+
+// VerifyPasskeyLogin logs in the owner of the passkey that signed the
+// assertion. Passkeys are only accepted with user verification, which already
+// makes them two factors, so TOTP is not asked for on top.
+func (uc *registrationUsecase) VerifyPasskeyLogin(
+	ctx context.Context, assertion webauthn.Assertion, client ClientInfo,
+) (tokenport.GenerateTokenResult, error) {
+	credential, err := uc.passkeyService.FinishLogin(ctx, assertion)
+	if err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+
+	user, err := uc.userService.GetUserByAuthID(ctx, credential.AuthID)
+	if err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+	if err = uc.authService.CheckSuspension(ctx, user.AuthID); err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+
+	return uc.startSession(ctx, user.ID, client)
+}
+
+// startSession records a new session for the login and issues its first token pair.
+func (uc *registrationUsecase) startSession(
+	ctx context.Context, userID pgtype.UUID, client ClientInfo,
+) (tokenport.GenerateTokenResult, error) {
+	session, err := uc.authService.CreateSession(ctx, db.InsertSessionParams{
+		UserID:      userID,
+		DeviceLabel: optionalText(client.DeviceLabel, maxDeviceLabelLength),
+		IpAddress:   optionalText(client.IPAddress, maxIPAddressLength),
+		UserAgent:   optionalText(client.UserAgent, maxUserAgentLength),
+	})
+	if err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+	return uc.issueTokens(ctx, userID, session.ID, nil)
+}
+
+// issueTokens signs a new token pair for the session and records its refresh
+// token. When parent is set the new refresh token continues the parent's family.
+func (uc *registrationUsecase) issueTokens(
+	ctx context.Context, userID pgtype.UUID, sessionID pgtype.UUID, parent *db.RefreshToken,
+) (tokenport.GenerateTokenResult, error) {
+	params := tokenport.GenerateTokenParams{UserID: userID.String(), SessionID: sessionID.String()}
+	if parent != nil {
+		params.FamilyID = parent.FamilyID.String()
+	}
+
+	tokenPair, err := uc.tokenService.GenerateTokens(ctx, params)
+	if err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+
+	record := db.InsertRefreshTokenParams{
+		UserID:    userID,
+		SessionID: sessionID,
+		ExpiresAt: pgtype.Timestamp{Time: tokenPair.RefreshTokenExpiresAt.UTC(), Valid: true},
+	}
+	if err = record.ID.Scan(tokenPair.RefreshTokenID); err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+	if err = record.FamilyID.Scan(tokenPair.FamilyID); err != nil {
+		return tokenport.GenerateTokenResult{}, err
+	}
+	if parent != nil {
+		record.ParentID = parent.ID
+	}
+
+	if err = uc.authService.SaveRefreshToken(ctx, record); err != nil {
 		return tokenport.GenerateTokenResult{}, err
 	}
 
-	return newTokens, nil
+	return tokenPair, nil
+}
+
+// LoginWithGoogle verifies a Google ID token and logs the matching google_oauth
+// account in, creating the auth and user rows the first time the subject is seen.
+func (uc *registrationUsecase) LoginWithGoogle(
+	ctx context.Context, idToken string, client ClientInfo,
+) (LoginResult, error) {
+	identity, err := uc.googleVerifier.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	tx, err := uc.dbpool.Begin(ctx)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	txAuthService := uc.authService.WithTx(tx)
+	txUserService := uc.userService.WithTx(tx)
+
+	var foundUser *db.User
+	authRow, err := txAuthService.GetAuthByProvider(ctx, db.AuthProviderGoogleOauth, identity.Subject)
+	switch {
+	case err == nil:
+		foundUser, err = txUserService.GetUserByAuthID(ctx, authRow.ID)
+		if err != nil {
+			return LoginResult{}, err
+		}
+	case errors.Is(err, auth.ErrNotFound):
+		if policyErr := uc.emailPolicy.Allow(ctx, identity.Email); policyErr != nil {
+			return LoginResult{}, policyErr
+		}
+		authID, createAuthErr := txAuthService.CreateNewAuthForOAuthLogin(
+			ctx, identity.Email, db.AuthProviderGoogleOauth, identity.Subject)
+		if errors.Is(createAuthErr, qqerrors.ErrUniqueViolation) {
+			// The email belongs to an existing account; linking has to be done by
+			// its owner while signed in.
+			return LoginResult{}, auth.ErrAccountExists
+		}
+		if createAuthErr != nil {
+			return LoginResult{}, createAuthErr
+		}
+		foundUser, err = txUserService.CreateDefaultUserWithAuthID(ctx, *authID)
+		if err != nil {
+			return LoginResult{}, err
+		}
+	default:
+		return LoginResult{}, err
+	}
+
+	if commitErr := tx.Commit(ctx); commitErr != nil {
+		return LoginResult{}, commitErr
+	}
+	tx = nil
+
+	return uc.completeLogin(ctx, foundUser, client)
+}
+
+// optionalText stores empty values as NULL and caps client-supplied strings.
+func optionalText(value string, maxLength int) pgtype.Text {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return pgtype.Text{}
+	}
+	if len(value) > maxLength {
+		value = strings.ToValidUTF8(value[:maxLength], "")
+	}
+	return pgtype.Text{String: value, Valid: true}
 }
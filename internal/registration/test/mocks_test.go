@@ -3,10 +3,20 @@ package registration_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/abdurrahimagca/qq-back/internal/db"
 	"github.com/abdurrahimagca/qq-back/internal/platform/mailer"
+	"github.com/abdurrahimagca/qq-back/internal/platform/oauth"
 	token "github.com/abdurrahimagca/qq-back/internal/platform/token"
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type fakeMailer struct {
@@ -71,6 +81,8 @@ type fakeTokenService struct {
 	generateResult        token.GenerateTokenResult
 	generateErr           error
 	generateCalls         []token.GenerateTokenParams
+	purposeTokens         map[string]token.PurposeTokenParams
+	lastPurpose           string
 }
 
 func (f *fakeTokenService) GenerateTokens(
@@ -83,7 +95,21 @@ func (f *fakeTokenService) GenerateTokens(
 		return token.GenerateTokenResult{}, f.generateErr
 	}
 	f.generateCalls = append(f.generateCalls, params)
-	return f.generateResult, nil
+
+	result := f.generateResult
+	if result.RefreshTokenID == "" {
+		result.RefreshTokenID = uuid.NewString()
+	}
+	if result.FamilyID == "" {
+		result.FamilyID = params.FamilyID
+	}
+	if result.FamilyID == "" {
+		result.FamilyID = uuid.NewString()
+	}
+	if result.RefreshTokenExpiresAt.IsZero() {
+		result.RefreshTokenExpiresAt = time.Now().Add(time.Hour)
+	}
+	return result, nil
 }
 
 func (f *fakeTokenService) ValidateToken(
@@ -101,6 +127,65 @@ func (f *fakeTokenService) ValidateToken(
 	return f.validateResult, nil
 }
 
+func (f *fakeTokenService) ValidateAccessToken(
+	ctx context.Context,
+	params token.ValidateTokenParams,
+) (token.ValidateTokenResult, error) {
+	return f.ValidateToken(ctx, params)
+}
+
+func (f *fakeTokenService) ValidateRefreshToken(
+	ctx context.Context,
+	params token.ValidateTokenParams,
+) (token.ValidateTokenResult, error) {
+	return f.ValidateToken(ctx, params)
+}
+
+// GeneratePurposeToken hands out opaque tokens that ValidatePurposeToken maps
+// back to the params they were issued with.
+func (f *fakeTokenService) GeneratePurposeToken(
+	ctx context.Context,
+	params token.PurposeTokenParams,
+) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.purposeTokens == nil {
+		f.purposeTokens = make(map[string]token.PurposeTokenParams)
+	}
+	issued := fmt.Sprintf("%s-token-%d", params.Use, len(f.purposeTokens)+1)
+	f.purposeTokens[issued] = params
+	f.lastPurpose = issued
+	return issued, nil
+}
+
+func (f *fakeTokenService) ValidatePurposeToken(
+	ctx context.Context,
+	params token.ValidateTokenParams,
+	use token.TokenUse,
+) (token.ValidateTokenResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	issued, ok := f.purposeTokens[params.Token]
+	if !ok {
+		return token.ValidateTokenResult{}, token.ErrInvalidToken
+	}
+	if issued.Use != use {
+		return token.ValidateTokenResult{}, token.ErrWrongTokenUse
+	}
+	return token.ValidateTokenResult{Claims: &token.Claims{
+		UserID:           issued.UserID,
+		TokenUse:         issued.Use,
+		RegisteredClaims: jwt.RegisteredClaims{ID: issued.ID},
+	}}, nil
+}
+
+// lastPurposeToken returns the most recently issued purpose token.
+func (f *fakeTokenService) lastPurposeToken() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastPurpose
+}
+
 func (f *fakeTokenService) setGenerateResult(result token.GenerateTokenResult) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -141,3 +226,91 @@ func (f *fakeTokenService) generateCallCount() int {
 	defer f.mu.Unlock()
 	return len(f.generateCalls)
 }
+
+type fakeOAuthVerifier struct {
+	mu        sync.Mutex
+	identity  *oauth.Identity
+	verifyErr error
+	lastToken string
+}
+
+func (f *fakeOAuthVerifier) VerifyIDToken(ctx context.Context, idToken string) (*oauth.Identity, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastToken = idToken
+	if f.verifyErr != nil {
+		return nil, f.verifyErr
+	}
+	if f.identity == nil {
+		return nil, oauth.ErrInvalidIDToken
+	}
+	identity := *f.identity
+	return &identity, nil
+}
+
+func (f *fakeOAuthVerifier) setIdentity(identity oauth.Identity) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.identity = &identity
+}
+
+func (f *fakeOAuthVerifier) setVerifyErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.verifyErr = err
+}
+
+// fakePasskeyService stands in for the WebAuthn ceremonies, which are covered by
+// the webauthn packages; here only the login that follows them matters.
+type fakePasskeyService struct {
+	mu            sync.Mutex
+	credential    *db.WebauthnCredential
+	finishErr     error
+	lastAssertion webauthn.Assertion
+}
+
+func (f *fakePasskeyService) WithTx(tx pgx.Tx) webauthn.Service {
+	return f
+}
+
+func (f *fakePasskeyService) BeginRegistration(
+	ctx context.Context, authID pgtype.UUID, userName string, displayName string,
+) (*webauthn.CreationOptions, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakePasskeyService) FinishRegistration(
+	ctx context.Context, authID pgtype.UUID, response webauthnport.RegistrationResponse, label string,
+) (*db.WebauthnCredential, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakePasskeyService) BeginLogin(ctx context.Context) (*webauthn.RequestOptions, error) {
+	return &webauthn.RequestOptions{Challenge: "challenge"}, nil
+}
+
+func (f *fakePasskeyService) FinishLogin(
+	ctx context.Context, assertion webauthn.Assertion,
+) (*db.WebauthnCredential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastAssertion = assertion
+	if f.finishErr != nil {
+		return nil, f.finishErr
+	}
+	if f.credential == nil {
+		return nil, webauthn.ErrUnknownCredential
+	}
+	credential := *f.credential
+	return &credential, nil
+}
+
+func (f *fakePasskeyService) ListCredentials(
+	ctx context.Context, authID pgtype.UUID,
+) ([]db.WebauthnCredential, error) {
+	return nil, nil
+}
+
+func (f *fakePasskeyService) DeleteCredential(ctx context.Context, authID pgtype.UUID, id pgtype.UUID) error {
+	return nil
+}
@@ -3,43 +3,96 @@ package registration_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/abdurrahimagca/qq-back/internal/auth"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/oauth"
+	"github.com/abdurrahimagca/qq-back/internal/platform/ratelimit"
 	"github.com/abdurrahimagca/qq-back/internal/platform/token"
 	"github.com/abdurrahimagca/qq-back/internal/registration"
 	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/humatest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 type fakeRegistrationUsecase struct {
-	registerResult    *bool
-	registerErr       error
-	verifyResult      token.GenerateTokenResult
-	verifyErr         error
-	refreshResult     token.GenerateTokenResult
-	refreshErr        error
-	lastRegisterEmail string
-	lastVerifyEmail   string
-	lastVerifyOTP     string
-	lastRefreshToken  string
+	registerResult        registration.SendResult
+	registerErr           error
+	verifyResult          registration.LoginResult
+	verifyErr             error
+	refreshResult         token.GenerateTokenResult
+	refreshErr            error
+	googleResult          registration.LoginResult
+	googleErr             error
+	magicLinkResult       registration.SendResult
+	magicLinkErr          error
+	secondFactorResult    token.GenerateTokenResult
+	secondFactorErr       error
+	passkeyResult         token.GenerateTokenResult
+	passkeyErr            error
+	lastAssertion         webauthn.Assertion
+	lastRegisterEmail     string
+	lastMagicEmail        string
+	lastMagicToken        string
+	lastVerifyEmail       string
+	lastVerifyOTP         string
+	lastRefreshToken      string
+	lastGoogleToken       string
+	lastSecondFactorToken string
+	lastSecondFactorCode  string
+	lastClient            registration.ClientInfo
 }
 
 func (f *fakeRegistrationUsecase) RegisterOrLoginOTP(
 	ctx context.Context, email string,
-) (*bool, error) {
+) (registration.SendResult, error) {
 	f.lastRegisterEmail = email
 	return f.registerResult, f.registerErr
 }
 
+func (f *fakeRegistrationUsecase) RegisterOrLoginMagicLink(
+	ctx context.Context, email string,
+) (registration.SendResult, error) {
+	f.lastMagicEmail = email
+	return f.magicLinkResult, f.magicLinkErr
+}
+
+func (f *fakeRegistrationUsecase) VerifyMagicLink(
+	ctx context.Context, linkToken string, client registration.ClientInfo,
+) (registration.LoginResult, error) {
+	f.lastMagicToken = linkToken
+	f.lastClient = client
+	return f.verifyResult, f.verifyErr
+}
+
 func (f *fakeRegistrationUsecase) VerifyOTPAndLogin(
-	ctx context.Context, email string, otp string,
-) (token.GenerateTokenResult, error) {
+	ctx context.Context, email string, otp string, client registration.ClientInfo,
+) (registration.LoginResult, error) {
 	f.lastVerifyEmail = email
 	f.lastVerifyOTP = otp
+	f.lastClient = client
 	return f.verifyResult, f.verifyErr
 }
 
+func (f *fakeRegistrationUsecase) VerifySecondFactor(
+	ctx context.Context, secondFactorToken string, code string, client registration.ClientInfo,
+) (token.GenerateTokenResult, error) {
+	f.lastSecondFactorToken = secondFactorToken
+	f.lastSecondFactorCode = code
+	f.lastClient = client
+	return f.secondFactorResult, f.secondFactorErr
+}
+
 func (f *fakeRegistrationUsecase) RefreshTokens(
 	ctx context.Context, refreshToken string,
 ) (token.GenerateTokenResult, error) {
@@ -47,10 +100,60 @@ func (f *fakeRegistrationUsecase) RefreshTokens(
 	return f.refreshResult, f.refreshErr
 }
 
+func (f *fakeRegistrationUsecase) LoginWithGoogle(
+	ctx context.Context, idToken string, client registration.ClientInfo,
+) (registration.LoginResult, error) {
+	f.lastGoogleToken = idToken
+	f.lastClient = client
+	return f.googleResult, f.googleErr
+}
+
+func (f *fakeRegistrationUsecase) BeginPasskeyLogin(ctx context.Context) (*webauthn.RequestOptions, error) {
+	return &webauthn.RequestOptions{Challenge: "challenge", RPID: "qq.example"}, nil
+}
+
+func (f *fakeRegistrationUsecase) VerifyPasskeyLogin(
+	ctx context.Context, assertion webauthn.Assertion, client registration.ClientInfo,
+) (token.GenerateTokenResult, error) {
+	f.lastAssertion = assertion
+	f.lastClient = client
+	return f.passkeyResult, f.passkeyErr
+}
+
+// testProxies are the trusted proxies of every test server.
+var testProxies = []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+
+func newTestServer(uc registration.Usecase) registration.Server {
+	generous := environment.RateLimit{Burst: 1000, Period: time.Hour}
+	return registration.NewServer(uc, ratelimit.NewMemoryLimiter(nil), environment.RateLimitEnvironment{
+		SendOTPPerEmail: generous,
+		SendOTPPerIP:    generous,
+		SendOTPGlobal:   generous,
+		LoginPerIP:      generous,
+	}, testProxies)
+}
+
+// resolveClient fills params as huma would for a request from remoteAddr
+// carrying forwardedFor.
+func resolveClient(t *testing.T, params *registration.ClientParams, remoteAddr string, forwardedFor string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = remoteAddr
+	params.ForwardedFor = forwardedFor
+	require.Empty(t, params.Resolve(humatest.NewContext(nil, req, httptest.NewRecorder())))
+}
+
+func loginResult(accessToken string, refreshToken string) registration.LoginResult {
+	return registration.LoginResult{
+		Tokens: token.GenerateTokenResult{AccessToken: accessToken, RefreshToken: refreshToken},
+	}
+}
+
 func TestServer_SendOtpHandler_Success(t *testing.T) {
-	isNew := true
-	uc := &fakeRegistrationUsecase{registerResult: &isNew}
-	server := registration.NewServer(uc)
+	uc := &fakeRegistrationUsecase{
+		registerResult: registration.SendResult{IsNewUser: true, ResendAfter: 29500 * time.Millisecond},
+	}
+	server := newTestServer(uc)
 
 	input := &registration.SendOtpInput{}
 	input.Body.Email = "user@example.com"
@@ -58,13 +161,15 @@ func TestServer_SendOtpHandler_Success(t *testing.T) {
 	resp, err := server.SendOtpHandler(context.Background(), input)
 	require.NoError(t, err)
 	require.NotNil(t, resp)
-	assert.True(t, resp.Body.Data.IsNewUser)
+	require.NotNil(t, resp.Body.Data.IsNewUser)
+	assert.True(t, *resp.Body.Data.IsNewUser)
+	assert.Equal(t, 30, resp.Body.Data.ResendAfter, "Seconds are rounded up")
 	assert.Equal(t, "user@example.com", uc.lastRegisterEmail)
 }
 
 func TestServer_SendOtpHandler_Error(t *testing.T) {
 	uc := &fakeRegistrationUsecase{registerErr: qqerrors.ErrValidationError}
-	server := registration.NewServer(uc)
+	server := newTestServer(uc)
 
 	input := &registration.SendOtpInput{}
 	input.Body.Email = "invalid"
@@ -74,9 +179,269 @@ func TestServer_SendOtpHandler_Error(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestServer_SendOtpHandler_ResendCooldown(t *testing.T) {
+	uc := &fakeRegistrationUsecase{
+		registerErr: &qqerrors.RetryAfterError{Err: auth.ErrOtpResendTooSoon, RetryAfter: 12 * time.Second},
+	}
+	server := newTestServer(uc)
+
+	resp, err := server.SendOtpHandler(context.Background(), sendOtpInput(t, "user@example.com", "203.0.113.1"))
+	require.Nil(t, resp)
+	requireRetryAfter(t, err)
+	var headersErr huma.HeadersError
+	require.ErrorAs(t, err, &headersErr)
+	assert.Equal(t, "12", headersErr.GetHeaders().Get("Retry-After"))
+}
+
+func TestServer_SendOtpHandler_MagicLinkMode(t *testing.T) {
+	uc := &fakeRegistrationUsecase{}
+	server := newTestServer(uc)
+
+	input := &registration.SendOtpInput{}
+	input.Body.Email = "user@example.com"
+	input.Body.Mode = registration.LoginModeMagicLink
+
+	resp, err := server.SendOtpHandler(context.Background(), input)
+	require.NoError(t, err)
+	require.NotNil(t, resp.Body.Data.IsNewUser)
+	assert.False(t, *resp.Body.Data.IsNewUser)
+	assert.Equal(t, "user@example.com", uc.lastMagicEmail)
+	assert.Empty(t, uc.lastRegisterEmail, "code mode should not be used")
+}
+
+func TestServer_SendOtpHandler_AccountHidden(t *testing.T) {
+	uc := &fakeRegistrationUsecase{
+		registerResult: registration.SendResult{ResendAfter: 30 * time.Second, AccountHidden: true},
+	}
+	server := newTestServer(uc)
+
+	resp, err := server.SendOtpHandler(context.Background(), sendOtpInput(t, "user@example.com", "203.0.113.1"))
+	require.NoError(t, err)
+	assert.Nil(t, resp.Body.Data.IsNewUser, "isNewUser should be left out")
+	assert.Equal(t, 30, resp.Body.Data.ResendAfter)
+}
+
+// sendOtpInput is a send from ip connecting directly.
+func sendOtpInput(t *testing.T, email string, ip string) *registration.SendOtpInput {
+	input := &registration.SendOtpInput{}
+	input.Body.Email = email
+	resolveClient(t, &input.ClientParams, net.JoinHostPort(ip, "4711"), "")
+	return input
+}
+
+func requireRetryAfter(t *testing.T, err error) {
+	t.Helper()
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusTooManyRequests, statusErr.GetStatus())
+	var headersErr huma.HeadersError
+	require.ErrorAs(t, err, &headersErr)
+	assert.NotEmpty(t, headersErr.GetHeaders().Get("Retry-After"))
+}
+
+func TestServer_SendOtpHandler_RateLimits(t *testing.T) {
+	ctx := context.Background()
+	generous := environment.RateLimit{Burst: 100, Period: time.Hour}
+
+	t.Run("Per email", func(t *testing.T) {
+		uc := &fakeRegistrationUsecase{}
+		server := registration.NewServer(uc, ratelimit.NewMemoryLimiter(nil), environment.RateLimitEnvironment{
+			SendOTPPerEmail: environment.RateLimit{Burst: 2, Period: time.Hour},
+			SendOTPPerIP:    generous,
+			SendOTPGlobal:   generous,
+		}, testProxies)
+
+		_, err := server.SendOtpHandler(ctx, sendOtpInput(t, "victim@example.com", "203.0.113.1"))
+		require.NoError(t, err)
+		magicLink := sendOtpInput(t, "Victim@Example.com ", "203.0.113.2")
+		magicLink.Body.Mode = registration.LoginModeMagicLink
+		_, err = server.SendOtpHandler(ctx, magicLink)
+		require.NoError(t, err)
+
+		uc.lastRegisterEmail = ""
+		resp, err := server.SendOtpHandler(ctx, sendOtpInput(t, "victim+again@example.com", "203.0.113.3"))
+		require.Nil(t, resp)
+		requireRetryAfter(t, err)
+		assert.Empty(t, uc.lastRegisterEmail, "No email is sent over the limit")
+
+		_, err = server.SendOtpHandler(ctx, sendOtpInput(t, "other@example.com", "203.0.113.3"))
+		require.NoError(t, err, "Other addresses keep their own budget")
+	})
+
+	t.Run("Per IP", func(t *testing.T) {
+		uc := &fakeRegistrationUsecase{}
+		server := registration.NewServer(uc, ratelimit.NewMemoryLimiter(nil), environment.RateLimitEnvironment{
+			SendOTPPerEmail: generous,
+			SendOTPPerIP:    environment.RateLimit{Burst: 1, Period: time.Hour},
+			SendOTPGlobal:   generous,
+		}, testProxies)
+
+		_, err := server.SendOtpHandler(ctx, sendOtpInput(t, "a@example.com", "203.0.113.7"))
+		require.NoError(t, err)
+		_, err = server.SendOtpHandler(ctx, sendOtpInput(t, "b@example.com", "203.0.113.7"))
+		requireRetryAfter(t, err)
+		_, err = server.SendOtpHandler(ctx, sendOtpInput(t, "b@example.com", "198.51.100.4"))
+		require.NoError(t, err)
+
+		// Behind a trusted proxy the forwarded address is the subject.
+		proxied := sendOtpInput(t, "c@example.com", "10.0.0.1")
+		resolveClient(t, &proxied.ClientParams, "10.0.0.1:4711", "192.0.2.9, 10.0.0.2")
+		_, err = server.SendOtpHandler(ctx, proxied)
+		require.NoError(t, err)
+		proxied = sendOtpInput(t, "d@example.com", "10.0.0.1")
+		resolveClient(t, &proxied.ClientParams, "10.0.0.3:4711", "192.0.2.9")
+		_, err = server.SendOtpHandler(ctx, proxied)
+		requireRetryAfter(t, err)
+	})
+
+	t.Run("Forged X-Forwarded-For", func(t *testing.T) {
+		uc := &fakeRegistrationUsecase{}
+		server := registration.NewServer(uc, ratelimit.NewMemoryLimiter(nil), environment.RateLimitEnvironment{
+			SendOTPPerEmail: generous,
+			SendOTPPerIP:    environment.RateLimit{Burst: 1, Period: time.Hour},
+			SendOTPGlobal:   generous,
+		}, testProxies)
+
+		// A direct client cannot pick its own subject.
+		input := sendOtpInput(t, "a@example.com", "203.0.113.7")
+		resolveClient(t, &input.ClientParams, "203.0.113.7:4711", "198.51.100.1")
+		_, err := server.SendOtpHandler(ctx, input)
+		require.NoError(t, err)
+		input = sendOtpInput(t, "b@example.com", "203.0.113.7")
+		resolveClient(t, &input.ClientParams, "203.0.113.7:4711", "198.51.100.2")
+		_, err = server.SendOtpHandler(ctx, input)
+		requireRetryAfter(t, err)
+
+		// A hop that is not an address, however long, ends the walk at the proxy.
+		input = sendOtpInput(t, "c@example.com", "10.0.0.1")
+		resolveClient(t, &input.ClientParams, "10.0.0.1:4711", "198.51.100.3, "+strings.Repeat("x", 1000))
+		_, err = server.SendOtpHandler(ctx, input)
+		require.NoError(t, err)
+		_, err = server.SendOtpHandler(ctx, sendOtpInput(t, "d@example.com", "10.0.0.1"))
+		requireRetryAfter(t, err)
+	})
+
+	t.Run("Global", func(t *testing.T) {
+		uc := &fakeRegistrationUsecase{}
+		server := registration.NewServer(uc, ratelimit.NewMemoryLimiter(nil), environment.RateLimitEnvironment{
+			SendOTPPerEmail: environment.RateLimit{Burst: 1, Period: time.Hour},
+			SendOTPPerIP:    generous,
+			SendOTPGlobal:   environment.RateLimit{Burst: 2, Period: time.Hour},
+		}, testProxies)
+
+		_, err := server.SendOtpHandler(ctx, sendOtpInput(t, "a@example.com", "203.0.113.1"))
+		require.NoError(t, err)
+		// Refused by the per-email budget, which must not use up the global one.
+		_, err = server.SendOtpHandler(ctx, sendOtpInput(t, "a@example.com", "203.0.113.1"))
+		requireRetryAfter(t, err)
+		_, err = server.SendOtpHandler(ctx, sendOtpInput(t, "b@example.com", "203.0.113.2"))
+		require.NoError(t, err)
+		_, err = server.SendOtpHandler(ctx, sendOtpInput(t, "c@example.com", "203.0.113.3"))
+		requireRetryAfter(t, err)
+	})
+}
+
+func TestServer_LoginHandlers_RateLimitedPerIP(t *testing.T) {
+	ctx := context.Background()
+	uc := &fakeRegistrationUsecase{
+		verifyErr:       auth.ErrInvalidMagicLink,
+		secondFactorErr: qqerrors.ErrUnauthorized,
+		googleErr:       qqerrors.ErrUnauthorized,
+		passkeyErr:      webauthn.ErrUnknownCredential,
+	}
+	server := registration.NewServer(uc, ratelimit.NewMemoryLimiter(nil), environment.RateLimitEnvironment{
+		LoginPerIP: environment.RateLimit{Burst: 3, Period: time.Hour},
+	}, testProxies)
+
+	attempts := map[string]func(ip string) error{
+		"Verify OTP": func(ip string) error {
+			input := &registration.VerifyOtpInput{}
+			resolveClient(t, &input.ClientParams, net.JoinHostPort(ip, "4711"), "")
+			_, err := server.VerifyOtpHandler(ctx, input)
+			return err
+		},
+		"Verify magic link": func(ip string) error {
+			input := &registration.VerifyMagicLinkInput{}
+			resolveClient(t, &input.ClientParams, net.JoinHostPort(ip, "4711"), "")
+			_, err := server.VerifyMagicLinkHandler(ctx, input)
+			return err
+		},
+		"Verify second factor": func(ip string) error {
+			input := &registration.VerifySecondFactorInput{}
+			resolveClient(t, &input.ClientParams, net.JoinHostPort(ip, "4711"), "")
+			_, err := server.VerifySecondFactorHandler(ctx, input)
+			return err
+		},
+		"Google login": func(ip string) error {
+			input := &registration.GoogleLoginInput{}
+			resolveClient(t, &input.ClientParams, net.JoinHostPort(ip, "4711"), "")
+			_, err := server.GoogleLoginHandler(ctx, input)
+			return err
+		},
+		"Verify passkey login": func(ip string) error {
+			input := newPasskeyLoginInput()
+			resolveClient(t, &input.ClientParams, net.JoinHostPort(ip, "4711"), "")
+			_, err := server.VerifyPasskeyLoginHandler(ctx, input)
+			return err
+		},
+	}
+	requireNotLimited := func(name string, err error) {
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr, name)
+		assert.Equal(t, http.StatusUnauthorized, statusErr.GetStatus(), name)
+	}
+	ipSuffix := 0
+	for name, attempt := range attempts {
+		ipSuffix++
+		ip := fmt.Sprintf("203.0.113.%d", ipSuffix)
+		for range 3 {
+			requireNotLimited(name, attempt(ip))
+		}
+		requireRetryAfter(t, attempt(ip))
+
+		// Every operation draws from the same budget of an address.
+		for other, otherAttempt := range attempts {
+			if other != name {
+				requireRetryAfter(t, otherAttempt(ip))
+			}
+		}
+		requireNotLimited(name, attempt(fmt.Sprintf("198.51.100.%d", ipSuffix)))
+	}
+}
+
+func TestServer_VerifyMagicLinkHandler_Success(t *testing.T) {
+	uc := &fakeRegistrationUsecase{verifyResult: loginResult("acc", "ref")}
+	server := newTestServer(uc)
+
+	input := &registration.VerifyMagicLinkInput{}
+	input.Body.Token = "link-token"
+	input.Body.DeviceLabel = "iPhone"
+
+	resp, err := server.VerifyMagicLinkHandler(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, "acc", resp.Body.Data.AccessToken)
+	assert.Equal(t, "ref", resp.Body.Data.RefreshToken)
+	assert.Equal(t, "link-token", uc.lastMagicToken)
+	assert.Equal(t, "iPhone", uc.lastClient.DeviceLabel)
+}
+
+func TestServer_VerifyMagicLinkHandler_Invalid(t *testing.T) {
+	uc := &fakeRegistrationUsecase{verifyErr: auth.ErrInvalidMagicLink}
+	server := newTestServer(uc)
+
+	input := &registration.VerifyMagicLinkInput{}
+	input.Body.Token = "used"
+
+	resp, err := server.VerifyMagicLinkHandler(context.Background(), input)
+	require.Nil(t, resp)
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusUnauthorized, statusErr.GetStatus())
+}
+
 func TestServer_VerifyOtpHandler_Success(t *testing.T) {
-	uc := &fakeRegistrationUsecase{verifyResult: token.GenerateTokenResult{AccessToken: "acc", RefreshToken: "ref"}}
-	server := registration.NewServer(uc)
+	uc := &fakeRegistrationUsecase{verifyResult: loginResult("acc", "ref")}
+	server := newTestServer(uc)
 
 	input := &registration.VerifyOtpInput{}
 	input.Body.Email = "user@example.com"
@@ -87,13 +452,180 @@ func TestServer_VerifyOtpHandler_Success(t *testing.T) {
 	require.NotNil(t, resp)
 	assert.Equal(t, "acc", resp.Body.Data.AccessToken)
 	assert.Equal(t, "ref", resp.Body.Data.RefreshToken)
+	assert.False(t, resp.Body.Data.SecondFactorRequired)
+	assert.Empty(t, resp.Body.Data.SecondFactorToken)
 	assert.Equal(t, "user@example.com", uc.lastVerifyEmail)
 	assert.Equal(t, "123456", uc.lastVerifyOTP)
+	assert.False(t, resp.Body.Data.IsNewUser)
+}
+
+func TestServer_VerifyOtpHandler_NewUser(t *testing.T) {
+	result := loginResult("acc", "ref")
+	result.IsNewUser = true
+	server := newTestServer(&fakeRegistrationUsecase{verifyResult: result})
+
+	input := &registration.VerifyOtpInput{}
+	input.Body.Email = "user@example.com"
+	input.Body.OtpCode = "123456"
+
+	resp, err := server.VerifyOtpHandler(context.Background(), input)
+	require.NoError(t, err)
+	assert.True(t, resp.Body.Data.IsNewUser)
+}
+
+func TestServer_VerifyOtpHandler_SecondFactorRequired(t *testing.T) {
+	uc := &fakeRegistrationUsecase{verifyResult: registration.LoginResult{SecondFactorToken: "second-factor-token"}}
+	server := newTestServer(uc)
+
+	input := &registration.VerifyOtpInput{}
+	input.Body.Email = "user@example.com"
+	input.Body.OtpCode = "123456"
+
+	resp, err := server.VerifyOtpHandler(context.Background(), input)
+	require.NoError(t, err)
+	assert.True(t, resp.Body.Data.SecondFactorRequired)
+	assert.Equal(t, "second-factor-token", resp.Body.Data.SecondFactorToken)
+	assert.Empty(t, resp.Body.Data.AccessToken)
+	assert.Empty(t, resp.Body.Data.RefreshToken)
+}
+
+func TestServer_VerifySecondFactorHandler_Success(t *testing.T) {
+	uc := &fakeRegistrationUsecase{
+		secondFactorResult: token.GenerateTokenResult{AccessToken: "acc", RefreshToken: "ref"}}
+	server := newTestServer(uc)
+
+	input := &registration.VerifySecondFactorInput{}
+	input.Body.SecondFactorToken = "second-factor-token"
+	input.Body.Code = "123456"
+	input.Body.DeviceLabel = "Laptop"
+
+	resp, err := server.VerifySecondFactorHandler(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, "acc", resp.Body.Data.AccessToken)
+	assert.Equal(t, "ref", resp.Body.Data.RefreshToken)
+	assert.Equal(t, "second-factor-token", uc.lastSecondFactorToken)
+	assert.Equal(t, "123456", uc.lastSecondFactorCode)
+	assert.Equal(t, "Laptop", uc.lastClient.DeviceLabel)
+}
+
+func TestServer_VerifySecondFactorHandler_Errors(t *testing.T) {
+	cases := map[error]int{
+		auth.ErrInvalidTOTPCode:      http.StatusUnauthorized,
+		auth.ErrTOTPAttemptsExceeded: http.StatusTooManyRequests,
+		token.ErrInvalidToken:        http.StatusUnauthorized,
+		auth.ErrAccountSuspended:     http.StatusForbidden,
+	}
+	for usecaseErr, status := range cases {
+		uc := &fakeRegistrationUsecase{secondFactorErr: usecaseErr}
+		server := newTestServer(uc)
+
+		input := &registration.VerifySecondFactorInput{}
+		input.Body.SecondFactorToken = "second-factor-token"
+		input.Body.Code = "000000"
+
+		resp, err := server.VerifySecondFactorHandler(context.Background(), input)
+		require.Nil(t, resp)
+
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, status, statusErr.GetStatus(), usecaseErr.Error())
+	}
+}
+
+func TestServer_PasskeyLoginOptionsHandler(t *testing.T) {
+	server := newTestServer(&fakeRegistrationUsecase{})
+
+	resp, err := server.PasskeyLoginOptionsHandler(context.Background(), &registration.PasskeyLoginOptionsInput{})
+	require.NoError(t, err)
+	assert.Equal(t, "challenge", resp.Body.Data.Challenge)
+	assert.Equal(t, "qq.example", resp.Body.Data.RPID)
+}
+
+func newPasskeyLoginInput() *registration.VerifyPasskeyLoginInput {
+	input := &registration.VerifyPasskeyLoginInput{}
+	input.Body.Credential.ID = "Y3JlZA"
+	input.Body.Credential.Type = "public-key"
+	input.Body.Credential.Response.ClientDataJSON = "e30"
+	input.Body.Credential.Response.AuthenticatorData = "YXV0aA"
+	input.Body.Credential.Response.Signature = "c2ln"
+	input.Body.Credential.Response.UserHandle = "dXNlcg"
+	return input
+}
+
+func TestServer_VerifyPasskeyLoginHandler_Success(t *testing.T) {
+	uc := &fakeRegistrationUsecase{passkeyResult: token.GenerateTokenResult{AccessToken: "acc", RefreshToken: "ref"}}
+	server := newTestServer(uc)
+
+	input := newPasskeyLoginInput()
+	input.Body.DeviceLabel = "Laptop"
+
+	resp, err := server.VerifyPasskeyLoginHandler(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, "acc", resp.Body.Data.AccessToken)
+	assert.Equal(t, "ref", resp.Body.Data.RefreshToken)
+	assert.Equal(t, []byte("cred"), uc.lastAssertion.CredentialID)
+	assert.Equal(t, []byte("user"), uc.lastAssertion.UserHandle)
+	assert.Equal(t, []byte("{}"), uc.lastAssertion.Response.ClientDataJSON)
+	assert.Equal(t, []byte("auth"), uc.lastAssertion.Response.AuthenticatorData)
+	assert.Equal(t, []byte("sig"), uc.lastAssertion.Response.Signature)
+	assert.Equal(t, "Laptop", uc.lastClient.DeviceLabel)
+}
+
+func TestServer_VerifyPasskeyLoginHandler_Errors(t *testing.T) {
+	t.Run("Malformed base64url", func(t *testing.T) {
+		uc := &fakeRegistrationUsecase{}
+		server := newTestServer(uc)
+
+		input := newPasskeyLoginInput()
+		input.Body.Credential.Response.Signature = "not base64!"
+
+		resp, err := server.VerifyPasskeyLoginHandler(context.Background(), input)
+		require.Nil(t, resp)
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusUnprocessableEntity, statusErr.GetStatus())
+		assert.Nil(t, uc.lastAssertion.CredentialID, "Usecase should not be called")
+	})
+
+	cases := map[error]int{
+		webauthn.ErrUnknownCredential: http.StatusUnauthorized,
+		webauthn.ErrInvalidChallenge:  http.StatusUnauthorized,
+	}
+	for usecaseErr, status := range cases {
+		server := newTestServer(&fakeRegistrationUsecase{passkeyErr: usecaseErr})
+
+		resp, err := server.VerifyPasskeyLoginHandler(context.Background(), newPasskeyLoginInput())
+		require.Nil(t, resp)
+
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, status, statusErr.GetStatus(), usecaseErr.Error())
+	}
+}
+
+func TestServer_VerifyOtpHandler_PassesClientInfo(t *testing.T) {
+	uc := &fakeRegistrationUsecase{}
+	server := newTestServer(uc)
+
+	input := &registration.VerifyOtpInput{}
+	input.Body.Email = "user@example.com"
+	input.Body.OtpCode = "123456"
+	input.Body.DeviceLabel = "Pixel 8"
+	input.UserAgent = "qq-android/1.0"
+	resolveClient(t, &input.ClientParams, "10.0.0.1:4711", "203.0.113.7, 10.0.0.2")
+
+	_, err := server.VerifyOtpHandler(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, registration.ClientInfo{
+		DeviceLabel: "Pixel 8",
+		IPAddress:   "203.0.113.7",
+		UserAgent:   "qq-android/1.0",
+	}, uc.lastClient)
 }
 
 func TestServer_VerifyOtpHandler_Error(t *testing.T) {
 	uc := &fakeRegistrationUsecase{verifyErr: errors.New("invalid otp")}
-	server := registration.NewServer(uc)
+	server := newTestServer(uc)
 
 	input := &registration.VerifyOtpInput{}
 	input.Body.Email = "user@example.com"
@@ -104,10 +636,31 @@ func TestServer_VerifyOtpHandler_Error(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestServer_VerifyOtpHandler_ErrorStatus(t *testing.T) {
+	cases := map[error]int{
+		auth.ErrInvalidOtpCode:      http.StatusUnauthorized,
+		auth.ErrOtpAttemptsExceeded: http.StatusTooManyRequests,
+	}
+	for usecaseErr, status := range cases {
+		server := newTestServer(&fakeRegistrationUsecase{verifyErr: usecaseErr})
+
+		input := &registration.VerifyOtpInput{}
+		input.Body.Email = "user@example.com"
+		input.Body.OtpCode = "000000"
+
+		resp, err := server.VerifyOtpHandler(context.Background(), input)
+		require.Nil(t, resp)
+
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, status, statusErr.GetStatus(), usecaseErr.Error())
+	}
+}
+
 func TestServer_RefreshTokensHandler_Success(t *testing.T) {
 	uc := &fakeRegistrationUsecase{
 		refreshResult: token.GenerateTokenResult{AccessToken: "new-acc", RefreshToken: "new-ref"}}
-	server := registration.NewServer(uc)
+	server := newTestServer(uc)
 
 	input := &registration.RefreshTokensInput{}
 	input.Body.RefreshToken = "token"
@@ -122,7 +675,7 @@ func TestServer_RefreshTokensHandler_Success(t *testing.T) {
 
 func TestServer_RefreshTokensHandler_Error(t *testing.T) {
 	uc := &fakeRegistrationUsecase{refreshErr: qqerrors.ErrUnauthorized}
-	server := registration.NewServer(uc)
+	server := newTestServer(uc)
 
 	input := &registration.RefreshTokensInput{}
 	input.Body.RefreshToken = "bad"
@@ -131,3 +684,58 @@ func TestServer_RefreshTokensHandler_Error(t *testing.T) {
 	require.Nil(t, resp)
 	require.Error(t, err)
 }
+
+func TestServer_SuspendedAccountForbidden(t *testing.T) {
+	suspended := fmt.Errorf("%w until 2099-01-01T00:00:00Z", auth.ErrAccountSuspended)
+	uc := &fakeRegistrationUsecase{registerErr: suspended, verifyErr: suspended, refreshErr: suspended}
+	server := newTestServer(uc)
+
+	_, sendErr := server.SendOtpHandler(context.Background(), sendOtpInput(t, "user@example.com", "203.0.113.1"))
+
+	verifyInput := &registration.VerifyOtpInput{}
+	verifyInput.Body.Email = "user@example.com"
+	verifyInput.Body.OtpCode = "000000"
+	_, verifyErr := server.VerifyOtpHandler(context.Background(), verifyInput)
+
+	refreshInput := &registration.RefreshTokensInput{}
+	refreshInput.Body.RefreshToken = "token"
+	_, refreshErr := server.RefreshTokensHandler(context.Background(), refreshInput)
+
+	for _, err := range []error{sendErr, verifyErr, refreshErr} {
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusForbidden, statusErr.GetStatus())
+	}
+}
+
+func TestServer_GoogleLoginHandler_Success(t *testing.T) {
+	uc := &fakeRegistrationUsecase{
+		googleResult: loginResult("g-acc", "g-ref")}
+	server := newTestServer(uc)
+
+	input := &registration.GoogleLoginInput{}
+	input.Body.IDToken = "id-token"
+
+	resp, err := server.GoogleLoginHandler(context.Background(), input)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "g-acc", resp.Body.Data.AccessToken)
+	assert.Equal(t, "g-ref", resp.Body.Data.RefreshToken)
+	assert.Equal(t, "id-token", uc.lastGoogleToken)
+}
+
+func TestServer_GoogleLoginHandler_InvalidToken(t *testing.T) {
+	uc := &fakeRegistrationUsecase{googleErr: oauth.ErrInvalidIDToken}
+	server := newTestServer(uc)
+
+	input := &registration.GoogleLoginInput{}
+	input.Body.IDToken = "bad"
+
+	resp, err := server.GoogleLoginHandler(context.Background(), input)
+	require.Nil(t, resp)
+	require.Error(t, err)
+
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusUnauthorized, statusErr.GetStatus())
+}
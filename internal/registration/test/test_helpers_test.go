@@ -17,7 +17,11 @@ import (
 
 	"github.com/abdurrahimagca/qq-back/internal/auth"
 	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/token"
 	"github.com/abdurrahimagca/qq-back/internal/user"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/require"
@@ -187,7 +191,7 @@ func hashOTP(code string) string {
 func createAuthAndUser(t *testing.T, h *registrationTestHarness, email, username string) (pgtype.UUID, db.User) {
 	t.Helper()
 
-	authIDPtr, err := h.authRepo.CreateAuthForOTPLogin(h.ctx, email)
+	authIDPtr, err := h.authRepo.CreateAuthForOTPLogin(h.ctx, email, email)
 	require.NoError(t, err)
 	authID := *authIDPtr
 
@@ -228,3 +232,53 @@ func verifyOTPCount(t *testing.T, h *registrationTestHarness, authID pgtype.UUID
 	require.NoError(t, err)
 	require.Equal(t, expectedCount, count)
 }
+
+func seedRefreshToken(t *testing.T, h *registrationTestHarness, userID pgtype.UUID) db.InsertRefreshTokenParams {
+	t.Helper()
+
+	params := db.InsertRefreshTokenParams{
+		UserID:    userID,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().UTC().Add(time.Hour), Valid: true},
+	}
+	require.NoError(t, params.ID.Scan(uuid.NewString()))
+	require.NoError(t, params.FamilyID.Scan(uuid.NewString()))
+	require.NoError(t, h.authRepo.CreateRefreshToken(h.ctx, params))
+	return params
+}
+
+func seedSessionRefreshToken(
+	t *testing.T, h *registrationTestHarness, userID pgtype.UUID, sessionID pgtype.UUID,
+) db.InsertRefreshTokenParams {
+	t.Helper()
+
+	params := db.InsertRefreshTokenParams{
+		UserID:    userID,
+		SessionID: sessionID,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().UTC().Add(time.Hour), Valid: true},
+	}
+	require.NoError(t, params.ID.Scan(uuid.NewString()))
+	require.NoError(t, params.FamilyID.Scan(uuid.NewString()))
+	require.NoError(t, h.authRepo.CreateRefreshToken(h.ctx, params))
+	return params
+}
+
+func refreshClaims(userID pgtype.UUID, seed db.InsertRefreshTokenParams) token.ValidateTokenResult {
+	return token.ValidateTokenResult{Claims: &token.Claims{
+		UserID:           userID.String(),
+		TokenUse:         token.TokenUseRefresh,
+		FamilyID:         seed.FamilyID.String(),
+		RegisteredClaims: jwt.RegisteredClaims{ID: seed.ID.String()},
+	}}
+}
+
+func suspendAccount(t *testing.T, h *registrationTestHarness, authID pgtype.UUID, until time.Time) {
+	t.Helper()
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+	err := authService.SuspendAccount(h.ctx, auth.SuspendParams{
+		AuthID: authID,
+		Reason: "terms of service violation",
+		Until:  until,
+		Actor:  "moderator@example.com",
+	})
+	require.NoError(t, err)
+}
@@ -8,10 +8,18 @@ import (
 	"time"
 
 	"github.com/abdurrahimagca/qq-back/internal/auth"
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/emailpolicy"
+	"github.com/abdurrahimagca/qq-back/internal/platform/oauth"
 	"github.com/abdurrahimagca/qq-back/internal/platform/token"
+	"github.com/abdurrahimagca/qq-back/internal/platform/totp"
 	"github.com/abdurrahimagca/qq-back/internal/registration"
 	"github.com/abdurrahimagca/qq-back/internal/user"
 	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -22,9 +30,82 @@ func newRegistrationUsecaseForTest(
 	mailSvc *fakeMailer,
 	tokenSvc *fakeTokenService,
 ) registration.Usecase {
-	authService := auth.NewService(h.authRepo)
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
 	userService := user.NewService(h.userRepo)
-	return registration.NewUsecase(mailSvc, authService, userService, h.pool, tokenSvc)
+	return registration.NewUsecase(
+		mailSvc, authService, userService, h.pool, tokenSvc, &fakeOAuthVerifier{}, &fakePasskeyService{},
+		emailpolicy.NewPolicy(nil, nil), testOTPEnvironment())
+}
+
+func newGoogleUsecaseForTest(
+	h *registrationTestHarness,
+	tokenSvc *fakeTokenService,
+	verifier *fakeOAuthVerifier,
+) registration.Usecase {
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+	userService := user.NewService(h.userRepo)
+	return registration.NewUsecase(
+		&fakeMailer{}, authService, userService, h.pool, tokenSvc, verifier, &fakePasskeyService{},
+		emailpolicy.NewPolicy(nil, nil), testOTPEnvironment())
+}
+
+func newPasskeyUsecaseForTest(
+	h *registrationTestHarness,
+	tokenSvc *fakeTokenService,
+	passkeys *fakePasskeyService,
+) registration.Usecase {
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+	userService := user.NewService(h.userRepo)
+	return registration.NewUsecase(
+		&fakeMailer{}, authService, userService, h.pool, tokenSvc, &fakeOAuthVerifier{}, passkeys,
+		emailpolicy.NewPolicy(nil, nil), testOTPEnvironment())
+}
+
+func newCooldownUsecaseForTest(
+	h *registrationTestHarness,
+	mailSvc *fakeMailer,
+	cooldown time.Duration,
+) registration.Usecase {
+	conf := testOTPEnvironment()
+	conf.ResendCooldown = cooldown
+	authService := auth.NewService(h.authRepo, conf)
+	userService := user.NewService(h.userRepo)
+	return registration.NewUsecase(
+		mailSvc, authService, userService, h.pool, &fakeTokenService{}, &fakeOAuthVerifier{}, &fakePasskeyService{},
+		emailpolicy.NewPolicy(nil, nil), conf)
+}
+
+func newPolicyUsecaseForTest(
+	h *registrationTestHarness,
+	mailSvc *fakeMailer,
+	policy emailpolicy.Policy,
+) registration.Usecase {
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+	userService := user.NewService(h.userRepo)
+	return registration.NewUsecase(
+		mailSvc, authService, userService, h.pool, &fakeTokenService{}, &fakeOAuthVerifier{}, &fakePasskeyService{},
+		policy, testOTPEnvironment())
+}
+
+func newHiddenUsecaseForTest(
+	h *registrationTestHarness,
+	mailSvc *fakeMailer,
+	minDuration time.Duration,
+	policy emailpolicy.Policy,
+) registration.Usecase {
+	conf := testOTPEnvironment()
+	conf.ResendCooldown = time.Minute
+	conf.HideAccountExistence = true
+	conf.SendMinDuration = minDuration
+	authService := auth.NewService(h.authRepo, conf)
+	userService := user.NewService(h.userRepo)
+	return registration.NewUsecase(
+		mailSvc, authService, userService, h.pool, &fakeTokenService{}, &fakeOAuthVerifier{}, &fakePasskeyService{},
+		policy, conf)
+}
+
+func testOTPEnvironment() environment.OTPEnvironment {
+	return environment.OTPEnvironment{MagicLinkURL: "https://qq.example/auth/magic?source=email"}
 }
 
 func TestRegisterOrLoginOTP_ExistingUser(t *testing.T) {
@@ -35,8 +116,8 @@ func TestRegisterOrLoginOTP_ExistingUser(t *testing.T) {
 	username := fmt.Sprintf("user_%d", time.Now().UnixNano())
 	authID, userRecord := createAuthAndUser(t, h, email, username)
 
-	// Seed stale OTP to ensure cleanup occurs.
-	err := h.authRepo.CreateOTP(ctx, authID, hashOTP("OLDOTP"))
+	// Seed a pending OTP; it is the code sent before and stays valid.
+	err := h.authRepo.CreateOTP(ctx, authID, hashOTP("OLDOTP"), auth.DefaultOTPLifetime)
 	require.NoError(t, err)
 
 	mailerFake := &fakeMailer{}
@@ -47,10 +128,9 @@ func TestRegisterOrLoginOTP_ExistingUser(t *testing.T) {
 
 	useDeterministicRand(t, []byte{0x0a, 0x0b, 0x0c})
 
-	isNewUserPtr, err := usecase.RegisterOrLoginOTP(ctx, email)
+	result, err := usecase.RegisterOrLoginOTP(ctx, email)
 	require.NoError(t, err)
-	require.NotNil(t, isNewUserPtr)
-	assert.False(t, *isNewUserPtr)
+	assert.False(t, result.IsNewUser)
 
 	require.Equal(t, 1, mailerFake.emailCount())
 	emailParams, err := mailerFake.lastEmail()
@@ -61,9 +141,10 @@ func TestRegisterOrLoginOTP_ExistingUser(t *testing.T) {
 	otpCode := "0A0B0C"
 	assert.Contains(t, emailParams.Body, otpCode)
 
-	// Only one OTP should exist for the auth id and it should be the new hash.
-	verifyOTPCount(t, h, authID, 1)
+	// The new code is stored next to the one sent before.
+	verifyOTPCount(t, h, authID, 2)
 	verifyOTPExists(t, h, authID, hashOTP(otpCode))
+	verifyOTPExists(t, h, authID, hashOTP("OLDOTP"))
 
 	retrieved := fetchUserByEmail(t, user.NewService(h.userRepo), ctx, email)
 	assert.Equal(t, userRecord.ID, retrieved.ID)
@@ -83,10 +164,9 @@ func TestRegisterOrLoginOTP_NewUser(t *testing.T) {
 
 	useDeterministicRand(t, []byte{0x1a, 0x2b, 0x3c})
 
-	isNewUserPtr, err := usecase.RegisterOrLoginOTP(ctx, email)
+	result, err := usecase.RegisterOrLoginOTP(ctx, email)
 	require.NoError(t, err)
-	require.NotNil(t, isNewUserPtr)
-	assert.True(t, *isNewUserPtr)
+	assert.True(t, result.IsNewUser)
 
 	emailParams, err := mailerFake.lastEmail()
 	require.NoError(t, err)
@@ -147,15 +227,73 @@ func TestVerifyOTPAndLogin_Success(t *testing.T) {
 	otpCode := strings.TrimPrefix(emailParams.Body, "OTP ")
 	otpCode = strings.TrimSpace(otpCode)
 
-	result, err := usecase.VerifyOTPAndLogin(ctx, email, otpCode)
+	result, err := usecase.VerifyOTPAndLogin(ctx, email, otpCode, registration.ClientInfo{})
 	require.NoError(t, err)
-	assert.Equal(t, "access", result.AccessToken)
-	assert.Equal(t, "refresh", result.RefreshToken)
+	assert.Equal(t, "access", result.Tokens.AccessToken)
+	assert.Equal(t, "refresh", result.Tokens.RefreshToken)
+	assert.True(t, result.IsNewUser, "The first login of an account should be reported")
 
 	require.Equal(t, 1, tokenFake.generateCallCount())
 	call, err := tokenFake.lastGenerateCall()
 	require.NoError(t, err)
 	assert.NotEmpty(t, call.UserID)
+
+	useDeterministicRand(t, []byte{0xab, 0xbc, 0xcd})
+	_, err = usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+	emailParams, err = mailerFake.lastEmail()
+	require.NoError(t, err)
+	otpCode = strings.TrimSpace(strings.TrimPrefix(emailParams.Body, "OTP "))
+	result, err = usecase.VerifyOTPAndLogin(ctx, email, otpCode, registration.ClientInfo{})
+	require.NoError(t, err)
+	assert.False(t, result.IsNewUser)
+
+	// The first login is recorded on the account, not derived from its sessions.
+	_, err = h.pool.Exec(ctx,
+		"DELETE FROM sessions WHERE user_id = (SELECT u.id FROM users u JOIN auth a ON a.id = u.auth_id "+
+			"WHERE a.email = $1)", email)
+	require.NoError(t, err)
+	useDeterministicRand(t, []byte{0xac, 0xbd, 0xce})
+	_, err = usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+	emailParams, err = mailerFake.lastEmail()
+	require.NoError(t, err)
+	otpCode = strings.TrimSpace(strings.TrimPrefix(emailParams.Body, "OTP "))
+	result, err = usecase.VerifyOTPAndLogin(ctx, email, otpCode, registration.ClientInfo{})
+	require.NoError(t, err)
+	assert.False(t, result.IsNewUser, "An account without sessions left is not new again")
+}
+
+func TestVerifyOTPAndLogin_IdenticalCodesForTwoAccounts(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("OTP {{.OTP}}")
+	tokenFake := &fakeTokenService{}
+	tokenFake.setGenerateResult(token.GenerateTokenResult{AccessToken: "access", RefreshToken: "refresh"})
+
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, tokenFake)
+
+	emails := []string{
+		fmt.Sprintf("first-%d@example.com", time.Now().UnixNano()),
+		fmt.Sprintf("second-%d@example.com", time.Now().UnixNano()),
+	}
+	for _, email := range emails {
+		useDeterministicRand(t, []byte{0xde, 0xad, 0xbe})
+		_, err := usecase.RegisterOrLoginOTP(ctx, email)
+		require.NoError(t, err)
+	}
+
+	for i := len(emails) - 1; i >= 0; i-- {
+		_, err := usecase.VerifyOTPAndLogin(ctx, emails[i], "DEADBE", registration.ClientInfo{})
+		require.NoError(t, err)
+
+		call, err := tokenFake.lastGenerateCall()
+		require.NoError(t, err)
+		expected := fetchUserByEmail(t, user.NewService(h.userRepo), ctx, emails[i])
+		assert.Equal(t, expected.ID.String(), call.UserID)
+	}
 }
 
 func TestVerifyOTPAndLogin_InvalidOTP(t *testing.T) {
@@ -174,7 +312,7 @@ func TestVerifyOTPAndLogin_InvalidOTP(t *testing.T) {
 	_, err := usecase.RegisterOrLoginOTP(ctx, email)
 	require.NoError(t, err)
 
-	_, err = usecase.VerifyOTPAndLogin(ctx, email, "WRONGOTP")
+	_, err = usecase.VerifyOTPAndLogin(ctx, email, "WRONGOTP", registration.ClientInfo{})
 	require.Error(t, err)
 }
 
@@ -188,9 +326,11 @@ func TestRefreshTokens_Success(t *testing.T) {
 	// Ensure user service can fetch by ID
 	require.NotEqual(t, pgtype.UUID{}, userRecord.ID)
 
+	seed := seedRefreshToken(t, h, userRecord.ID)
+
 	mailerFake := &fakeMailer{}
 	tokenFake := &fakeTokenService{}
-	tokenFake.setValidateResult(token.ValidateTokenResult{Claims: &token.Claims{UserID: userRecord.ID.String()}})
+	tokenFake.setValidateResult(refreshClaims(userRecord.ID, seed))
 	tokenFake.setExpectedValidateToken("valid-refresh")
 	tokenFake.setGenerateResult(token.GenerateTokenResult{AccessToken: "new-access", RefreshToken: "new-refresh"})
 
@@ -205,11 +345,362 @@ func TestRefreshTokens_Success(t *testing.T) {
 	call, err := tokenFake.lastGenerateCall()
 	require.NoError(t, err)
 	assert.Equal(t, userRecord.ID.String(), call.UserID)
+	assert.Equal(t, seed.FamilyID.String(), call.FamilyID)
+
+	// The presented token is consumed and its successor is chained to it.
+	consumed, err := h.authRepo.GetRefreshToken(ctx, seed.ID)
+	require.NoError(t, err)
+	assert.True(t, consumed.UsedAt.Valid)
+
+	var successor pgtype.UUID
+	require.NoError(t, successor.Scan(result.RefreshTokenID))
+	child, err := h.authRepo.GetRefreshToken(ctx, successor)
+	require.NoError(t, err)
+	assert.Equal(t, seed.ID, child.ParentID)
+	assert.Equal(t, seed.FamilyID, child.FamilyID)
+	assert.False(t, child.UsedAt.Valid)
 
 	// Ensure stored auth remains linked
 	verifyOTPCount(t, h, authID, 0)
 }
 
+func TestRefreshTokens_ReuseRevokesFamily(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("reuse-%d@example.com", time.Now().UnixNano())
+	_, userRecord := createAuthAndUser(t, h, email, fmt.Sprintf("reuse_user_%d", time.Now().UnixNano()))
+	seed := seedRefreshToken(t, h, userRecord.ID)
+
+	tokenFake := &fakeTokenService{}
+	tokenFake.setValidateResult(refreshClaims(userRecord.ID, seed))
+	usecase := newRegistrationUsecaseForTest(h, &fakeMailer{}, tokenFake)
+
+	first, err := usecase.RefreshTokens(ctx, "stolen")
+	require.NoError(t, err)
+
+	// Replaying the consumed token is detected and kills the family.
+	_, err = usecase.RefreshTokens(ctx, "stolen")
+	require.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+	assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+
+	var successorID pgtype.UUID
+	require.NoError(t, successorID.Scan(first.RefreshTokenID))
+	successor, err := h.authRepo.GetRefreshToken(ctx, successorID)
+	require.NoError(t, err)
+	assert.True(t, successor.RevokedAt.Valid)
+
+	// The legitimate holder of the successor is logged out as well.
+	tokenFake.setValidateResult(token.ValidateTokenResult{Claims: &token.Claims{
+		UserID:           userRecord.ID.String(),
+		FamilyID:         first.FamilyID,
+		RegisteredClaims: jwt.RegisteredClaims{ID: first.RefreshTokenID},
+	}})
+	_, err = usecase.RefreshTokens(ctx, "successor")
+	require.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+	assert.Equal(t, 1, tokenFake.generateCallCount())
+}
+
+func TestRefreshTokens_UnknownTokenID(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+
+	email := fmt.Sprintf("unknown-%d@example.com", time.Now().UnixNano())
+	_, userRecord := createAuthAndUser(t, h, email, fmt.Sprintf("unknown_user_%d", time.Now().UnixNano()))
+
+	tokenFake := &fakeTokenService{}
+	tokenFake.setValidateResult(token.ValidateTokenResult{Claims: &token.Claims{
+		UserID:           userRecord.ID.String(),
+		FamilyID:         uuid.NewString(),
+		RegisteredClaims: jwt.RegisteredClaims{ID: uuid.NewString()},
+	}})
+	usecase := newRegistrationUsecaseForTest(h, &fakeMailer{}, tokenFake)
+
+	_, err := usecase.RefreshTokens(context.Background(), "forged")
+	require.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+}
+
+func TestVerifyOTPAndLogin_RecordsRefreshToken(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("record-%d@example.com", time.Now().UnixNano())
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("OTP {{.OTP}}")
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, &fakeTokenService{})
+
+	useDeterministicRand(t, []byte{0x0d, 0x0e, 0x0f})
+	_, err := usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+
+	result, err := usecase.VerifyOTPAndLogin(ctx, email, "0D0E0F", registration.ClientInfo{})
+	require.NoError(t, err)
+
+	var tokenID pgtype.UUID
+	require.NoError(t, tokenID.Scan(result.Tokens.RefreshTokenID))
+	stored, err := h.authRepo.GetRefreshToken(ctx, tokenID)
+	require.NoError(t, err)
+	assert.Equal(t, result.Tokens.FamilyID, stored.FamilyID.String())
+	assert.False(t, stored.ParentID.Valid)
+}
+
+func TestVerifyOTPAndLogin_CreatesSession(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("session-%d@example.com", time.Now().UnixNano())
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("OTP {{.OTP}}")
+	tokenFake := &fakeTokenService{}
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, tokenFake)
+
+	useDeterministicRand(t, []byte{0x01, 0x02, 0x03})
+	_, err := usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+
+	client := registration.ClientInfo{DeviceLabel: "Pixel 8", IPAddress: "203.0.113.7", UserAgent: "qq-android/1.0"}
+	result, err := usecase.VerifyOTPAndLogin(ctx, email, "010203", client)
+	require.NoError(t, err)
+
+	call, err := tokenFake.lastGenerateCall()
+	require.NoError(t, err)
+	require.NotEmpty(t, call.SessionID)
+
+	var sessionID pgtype.UUID
+	require.NoError(t, sessionID.Scan(call.SessionID))
+	session, err := h.authRepo.GetSession(ctx, sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "Pixel 8", session.DeviceLabel.String)
+	assert.Equal(t, "203.0.113.7", session.IpAddress.String)
+	assert.Equal(t, "qq-android/1.0", session.UserAgent.String)
+	assert.False(t, session.RevokedAt.Valid)
+
+	var tokenID pgtype.UUID
+	require.NoError(t, tokenID.Scan(result.Tokens.RefreshTokenID))
+	stored, err := h.authRepo.GetRefreshToken(ctx, tokenID)
+	require.NoError(t, err)
+	assert.Equal(t, sessionID, stored.SessionID)
+}
+
+func TestRefreshTokens_KeepsSession(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("keep-session-%d@example.com", time.Now().UnixNano())
+	_, userRecord := createAuthAndUser(t, h, email, fmt.Sprintf("keep_user_%d", time.Now().UnixNano()))
+	session, err := h.authRepo.CreateSession(ctx, db.InsertSessionParams{UserID: userRecord.ID})
+	require.NoError(t, err)
+	seed := seedSessionRefreshToken(t, h, userRecord.ID, session.ID)
+
+	tokenFake := &fakeTokenService{}
+	tokenFake.setValidateResult(refreshClaims(userRecord.ID, seed))
+	usecase := newRegistrationUsecaseForTest(h, &fakeMailer{}, tokenFake)
+
+	_, err = usecase.RefreshTokens(ctx, "valid-refresh")
+	require.NoError(t, err)
+
+	call, err := tokenFake.lastGenerateCall()
+	require.NoError(t, err)
+	assert.Equal(t, session.ID.String(), call.SessionID)
+
+	touched, err := h.authRepo.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.False(t, touched.LastSeenAt.Time.Before(session.LastSeenAt.Time))
+}
+
+func TestRefreshTokens_RevokedSession(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("revoked-session-%d@example.com", time.Now().UnixNano())
+	_, userRecord := createAuthAndUser(t, h, email, fmt.Sprintf("revoked_user_%d", time.Now().UnixNano()))
+	session, err := h.authRepo.CreateSession(ctx, db.InsertSessionParams{UserID: userRecord.ID})
+	require.NoError(t, err)
+	seed := seedSessionRefreshToken(t, h, userRecord.ID, session.ID)
+	require.NoError(t, h.authRepo.RevokeSession(ctx, userRecord.ID, session.ID))
+
+	tokenFake := &fakeTokenService{}
+	tokenFake.setValidateResult(refreshClaims(userRecord.ID, seed))
+	usecase := newRegistrationUsecaseForTest(h, &fakeMailer{}, tokenFake)
+
+	_, err = usecase.RefreshTokens(ctx, "valid-refresh")
+	require.ErrorIs(t, err, auth.ErrSessionRevoked)
+	assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+}
+
+func TestRegisterOrLoginMagicLink_SendsSingleUseLink(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("magic-%d@example.com", time.Now().UnixNano())
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate(`<a href="{{.Link}}">Sign in</a>`)
+	tokenFake := &fakeTokenService{}
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, tokenFake)
+
+	sent, err := usecase.RegisterOrLoginMagicLink(ctx, email)
+	require.NoError(t, err)
+	assert.True(t, sent.IsNewUser)
+
+	linkToken := tokenFake.lastPurposeToken()
+	require.NotEmpty(t, linkToken)
+	emailParams, err := mailerFake.lastEmail()
+	require.NoError(t, err)
+	assert.Equal(t, email, emailParams.To)
+	assert.Contains(t, emailParams.Body, "https://qq.example/auth/magic?source=email&amp;token="+linkToken)
+
+	result, err := usecase.VerifyMagicLink(ctx, linkToken, registration.ClientInfo{DeviceLabel: "Phone"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.Tokens.RefreshTokenID)
+	assert.Equal(t, 1, tokenFake.generateCallCount())
+
+	_, err = usecase.VerifyMagicLink(ctx, linkToken, registration.ClientInfo{})
+	require.ErrorIs(t, err, auth.ErrInvalidMagicLink)
+	assert.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+	assert.Equal(t, 1, tokenFake.generateCallCount())
+}
+
+func TestRegisterOrLoginMagicLink_PendingCodeStaysValid(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("magic-replace-%d@example.com", time.Now().UnixNano())
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("{{.OTP}}{{.Link}}")
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, &fakeTokenService{})
+
+	useDeterministicRand(t, []byte{0x04, 0x05, 0x06})
+	_, err := usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+
+	_, err = usecase.RegisterOrLoginMagicLink(ctx, email)
+	require.NoError(t, err)
+
+	// Both modes share one cleanup, which keeps the newest pending entry.
+	_, err = usecase.VerifyOTPAndLogin(ctx, email, "040506", registration.ClientInfo{})
+	require.NoError(t, err)
+}
+
+func TestRegisterOrLoginOTP_ResendKeepsOnlyThePreviousCode(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("resend-%d@example.com", time.Now().UnixNano())
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("OTP {{.OTP}}")
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, &fakeTokenService{})
+
+	for _, code := range [][]byte{{0x11, 0x11, 0x11}, {0x22, 0x22, 0x22}, {0x33, 0x33, 0x33}} {
+		useDeterministicRand(t, code)
+		_, err := usecase.RegisterOrLoginOTP(ctx, email)
+		require.NoError(t, err)
+	}
+
+	_, err := usecase.VerifyOTPAndLogin(ctx, email, "111111", registration.ClientInfo{})
+	require.ErrorIs(t, err, auth.ErrInvalidOtpCode)
+
+	_, err = usecase.VerifyOTPAndLogin(ctx, email, "222222", registration.ClientInfo{})
+	require.NoError(t, err)
+}
+
+func TestRegisterOrLoginOTP_ResendCooldown(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("cooldown-%d@example.com", time.Now().UnixNano())
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("OTP {{.OTP}}")
+	usecase := newCooldownUsecaseForTest(h, mailerFake, time.Minute)
+
+	sent, err := usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, sent.ResendAfter)
+
+	_, err = usecase.RegisterOrLoginMagicLink(ctx, email)
+	require.ErrorIs(t, err, auth.ErrOtpResendTooSoon)
+	assert.ErrorIs(t, err, qqerrors.ErrTooManyRequests)
+	var retryErr *qqerrors.RetryAfterError
+	require.ErrorAs(t, err, &retryErr)
+	assert.Positive(t, retryErr.RetryAfter)
+	assert.LessOrEqual(t, retryErr.RetryAfter, time.Minute)
+	assert.Equal(t, 1, mailerFake.emailCount())
+
+	_, err = h.pool.Exec(ctx,
+		"UPDATE auth_otp_codes SET created_at = created_at - INTERVAL '1 minute' "+
+			"WHERE auth_id = (SELECT id FROM auth WHERE email = $1)", email)
+	require.NoError(t, err)
+
+	_, err = usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, 2, mailerFake.emailCount())
+}
+
+func TestVerifyMagicLink_UnknownToken(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	tokenFake := &fakeTokenService{}
+	usecase := newRegistrationUsecaseForTest(h, &fakeMailer{}, tokenFake)
+
+	_, err := usecase.VerifyMagicLink(context.Background(), "forged", registration.ClientInfo{})
+	require.ErrorIs(t, err, auth.ErrInvalidMagicLink)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+}
+
+func TestVerifyOTPAndLogin_TOTPEnabledRequiresSecondFactor(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("totp-%d@example.com", time.Now().UnixNano())
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("OTP {{.OTP}}")
+	tokenFake := &fakeTokenService{}
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, tokenFake)
+
+	useDeterministicRand(t, []byte{0x07, 0x08, 0x09})
+	_, err := usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+
+	created := fetchUserByEmail(t, user.NewService(h.userRepo), ctx, email)
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+	enrollment, err := authService.StartTOTPEnrollment(ctx, created.AuthID)
+	require.NoError(t, err)
+	code, err := totp.CodeAt(enrollment.Secret, totp.StepAt(time.Now()))
+	require.NoError(t, err)
+	_, err = authService.ConfirmTOTPEnrollment(ctx, created.AuthID, code)
+	require.NoError(t, err)
+
+	result, err := usecase.VerifyOTPAndLogin(ctx, email, "070809", registration.ClientInfo{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Tokens.AccessToken)
+	require.NotEmpty(t, result.SecondFactorToken)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+
+	_, err = usecase.VerifySecondFactor(ctx, result.SecondFactorToken, "000000", registration.ClientInfo{})
+	require.ErrorIs(t, err, auth.ErrInvalidTOTPCode)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+
+	totpRow, err := h.authRepo.GetTOTP(ctx, created.AuthID)
+	require.NoError(t, err)
+	// The confirmation used the current step; the next one is still inside the skew window.
+	code, err = totp.CodeAt(enrollment.Secret, totpRow.LastUsedStep+1)
+	require.NoError(t, err)
+
+	tokens, err := usecase.VerifySecondFactor(ctx, result.SecondFactorToken, code, registration.ClientInfo{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.RefreshTokenID)
+	assert.Equal(t, 1, tokenFake.generateCallCount())
+}
+
+func TestVerifySecondFactor_InvalidToken(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	tokenFake := &fakeTokenService{}
+	usecase := newRegistrationUsecaseForTest(h, &fakeMailer{}, tokenFake)
+
+	_, err := usecase.VerifySecondFactor(context.Background(), "forged", "123456", registration.ClientInfo{})
+	require.ErrorIs(t, err, token.ErrInvalidToken)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+}
+
 func TestRefreshTokens_EmptyUserIDClaims(t *testing.T) {
 	h := newRegistrationTestHarness(t)
 
@@ -228,7 +719,11 @@ func TestRefreshTokens_InvalidUUID(t *testing.T) {
 
 	mailerFake := &fakeMailer{}
 	tokenFake := &fakeTokenService{}
-	tokenFake.setValidateResult(token.ValidateTokenResult{Claims: &token.Claims{UserID: "not-a-uuid"}})
+	tokenFake.setValidateResult(token.ValidateTokenResult{Claims: &token.Claims{
+		UserID:           "not-a-uuid",
+		FamilyID:         uuid.NewString(),
+		RegisteredClaims: jwt.RegisteredClaims{ID: uuid.NewString()},
+	}})
 
 	usecase := newRegistrationUsecaseForTest(h, mailerFake, tokenFake)
 
@@ -249,3 +744,396 @@ func TestRefreshTokens_ValidateError(t *testing.T) {
 	require.Error(t, err)
 	assert.EqualError(t, err, "invalid token")
 }
+
+func TestLoginWithGoogle_NewUser(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("google-%d@example.com", time.Now().UnixNano())
+	subject := fmt.Sprintf("sub-%d", time.Now().UnixNano())
+
+	verifier := &fakeOAuthVerifier{}
+	verifier.setIdentity(oauth.Identity{Subject: subject, Email: email, EmailVerified: true})
+	tokenFake := &fakeTokenService{}
+	tokenFake.setGenerateResult(token.GenerateTokenResult{AccessToken: "acc", RefreshToken: "ref"})
+
+	usecase := newGoogleUsecaseForTest(h, tokenFake, verifier)
+
+	result, err := usecase.LoginWithGoogle(ctx, "id-token", registration.ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "acc", result.Tokens.AccessToken)
+	assert.True(t, result.IsNewUser)
+
+	authRow, err := h.authRepo.GetAuthByProvider(ctx, db.AuthProviderGoogleOauth, subject)
+	require.NoError(t, err)
+	assert.Equal(t, email, authRow.Email)
+
+	created := fetchUserByEmail(t, user.NewService(h.userRepo), ctx, email)
+	call, err := tokenFake.lastGenerateCall()
+	require.NoError(t, err)
+	assert.Equal(t, created.ID.String(), call.UserID)
+}
+
+func TestLoginWithGoogle_ExistingSubjectReusesUser(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("google-existing-%d@example.com", time.Now().UnixNano())
+	subject := fmt.Sprintf("sub-%d", time.Now().UnixNano())
+
+	verifier := &fakeOAuthVerifier{}
+	verifier.setIdentity(oauth.Identity{Subject: subject, Email: email, EmailVerified: true})
+	tokenFake := &fakeTokenService{}
+
+	usecase := newGoogleUsecaseForTest(h, tokenFake, verifier)
+
+	_, err := usecase.LoginWithGoogle(ctx, "first", registration.ClientInfo{})
+	require.NoError(t, err)
+	first, err := tokenFake.lastGenerateCall()
+	require.NoError(t, err)
+
+	_, err = usecase.LoginWithGoogle(ctx, "second", registration.ClientInfo{})
+	require.NoError(t, err)
+	second, err := tokenFake.lastGenerateCall()
+	require.NoError(t, err)
+
+	assert.Equal(t, first.UserID, second.UserID)
+	assert.Equal(t, 2, tokenFake.generateCallCount())
+}
+
+func TestLoginWithGoogle_InvalidToken(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+
+	verifier := &fakeOAuthVerifier{}
+	verifier.setVerifyErr(oauth.ErrInvalidIDToken)
+	tokenFake := &fakeTokenService{}
+
+	usecase := newGoogleUsecaseForTest(h, tokenFake, verifier)
+
+	_, err := usecase.LoginWithGoogle(context.Background(), "bad", registration.ClientInfo{})
+	require.ErrorIs(t, err, qqerrors.ErrUnauthorized)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+}
+
+func TestLoginWithGoogle_EmailOwnedByOTPAccount(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("taken-%d@example.com", time.Now().UnixNano())
+	createAuthAndUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+
+	verifier := &fakeOAuthVerifier{}
+	verifier.setIdentity(oauth.Identity{Subject: "new-subject", Email: email, EmailVerified: true})
+	tokenFake := &fakeTokenService{}
+
+	usecase := newGoogleUsecaseForTest(h, tokenFake, verifier)
+
+	_, err := usecase.LoginWithGoogle(ctx, "id-token", registration.ClientInfo{})
+	require.ErrorIs(t, err, auth.ErrAccountExists)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+}
+
+func TestRegisterOrLoginOTP_GoogleOnlyAccountRefused(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("google-only-%d@example.com", time.Now().UnixNano())
+	verifier := &fakeOAuthVerifier{}
+	verifier.setIdentity(oauth.Identity{Subject: "google-only", Email: email, EmailVerified: true})
+	googleUsecase := newGoogleUsecaseForTest(h, &fakeTokenService{}, verifier)
+	_, err := googleUsecase.LoginWithGoogle(ctx, "id-token", registration.ClientInfo{})
+	require.NoError(t, err)
+
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("Code: {{.OTP}}")
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, &fakeTokenService{})
+
+	_, err = usecase.RegisterOrLoginOTP(ctx, email)
+	require.ErrorIs(t, err, auth.ErrIdentityNotLinked)
+	assert.Equal(t, 0, mailerFake.emailCount())
+}
+
+func TestVerifyPasskeyLogin_Success(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("passkey-%d@example.com", time.Now().UnixNano())
+	authID, userRecord := createAuthAndUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+
+	// TOTP is enabled to show passkeys are not asked for a second factor.
+	authService := auth.NewService(h.authRepo, environment.OTPEnvironment{})
+	enrollment, err := authService.StartTOTPEnrollment(ctx, authID)
+	require.NoError(t, err)
+	code, err := totp.CodeAt(enrollment.Secret, totp.StepAt(time.Now()))
+	require.NoError(t, err)
+	_, err = authService.ConfirmTOTPEnrollment(ctx, authID, code)
+	require.NoError(t, err)
+
+	tokenFake := &fakeTokenService{}
+	tokenFake.setGenerateResult(token.GenerateTokenResult{AccessToken: "access", RefreshToken: "refresh"})
+	passkeys := &fakePasskeyService{credential: &db.WebauthnCredential{AuthID: authID}}
+	usecase := newPasskeyUsecaseForTest(h, tokenFake, passkeys)
+
+	assertion := webauthn.Assertion{CredentialID: []byte("credential-id")}
+	result, err := usecase.VerifyPasskeyLogin(ctx, assertion, registration.ClientInfo{DeviceLabel: "Laptop"})
+	require.NoError(t, err)
+	assert.Equal(t, "access", result.AccessToken)
+	assert.Equal(t, "refresh", result.RefreshToken)
+	assert.Equal(t, assertion, passkeys.lastAssertion)
+
+	call, err := tokenFake.lastGenerateCall()
+	require.NoError(t, err)
+	assert.Equal(t, userRecord.ID.String(), call.UserID)
+}
+
+func TestVerifyPasskeyLogin_RejectedAssertion(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	tokenFake := &fakeTokenService{}
+	passkeys := &fakePasskeyService{finishErr: webauthn.ErrInvalidChallenge}
+	usecase := newPasskeyUsecaseForTest(h, tokenFake, passkeys)
+
+	_, err := usecase.VerifyPasskeyLogin(context.Background(), webauthn.Assertion{}, registration.ClientInfo{})
+	require.ErrorIs(t, err, webauthn.ErrInvalidChallenge)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+}
+
+func TestRegisterOrLoginOTP_SuspendedAccountRefused(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("suspended-%d@example.com", time.Now().UnixNano())
+	authID, _ := createAuthAndUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+	suspendAccount(t, h, authID, time.Time{})
+
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("Code: {{.OTP}}")
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, &fakeTokenService{})
+
+	_, err := usecase.RegisterOrLoginOTP(ctx, email)
+	require.ErrorIs(t, err, auth.ErrAccountSuspended)
+	assert.ErrorIs(t, err, qqerrors.ErrAccountSuspended)
+	assert.Equal(t, 0, mailerFake.emailCount())
+	verifyOTPCount(t, h, authID, 0)
+
+	_, err = usecase.RegisterOrLoginMagicLink(ctx, email)
+	require.ErrorIs(t, err, auth.ErrAccountSuspended)
+	assert.Equal(t, 0, mailerFake.emailCount())
+}
+
+func TestRegisterOrLoginOTP_ExpiredSuspensionAllowsLogin(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("unsuspended-%d@example.com", time.Now().UnixNano())
+	authID, _ := createAuthAndUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+	err := h.authRepo.SuspendAuth(ctx, db.SuspendAuthParams{
+		ID:             authID,
+		Reason:         pgtype.Text{String: "cool-off", Valid: true},
+		SuspendedUntil: pgtype.Timestamp{Time: time.Now().Add(-time.Minute).UTC(), Valid: true},
+	})
+	require.NoError(t, err)
+
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("Code: {{.OTP}}")
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, &fakeTokenService{})
+
+	_, err = usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, 1, mailerFake.emailCount())
+}
+
+func TestVerifyOTPAndLogin_SuspendedAfterCodeSent(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("suspended-verify-%d@example.com", time.Now().UnixNano())
+	authID, _ := createAuthAndUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("OTP {{.OTP}}")
+	tokenFake := &fakeTokenService{}
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, tokenFake)
+
+	useDeterministicRand(t, []byte{0x0d, 0x0e, 0x0f})
+	_, err := usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+
+	suspendAccount(t, h, authID, time.Now().Add(time.Hour))
+
+	_, err = usecase.VerifyOTPAndLogin(ctx, email, "0D0E0F", registration.ClientInfo{})
+	require.ErrorIs(t, err, auth.ErrAccountSuspended)
+	assert.Contains(t, err.Error(), "until")
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+}
+
+func TestRefreshTokens_SuspendedAccount(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("suspended-refresh-%d@example.com", time.Now().UnixNano())
+	authID, userRecord := createAuthAndUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+	seed := seedRefreshToken(t, h, userRecord.ID)
+	suspendAccount(t, h, authID, time.Time{})
+
+	tokenFake := &fakeTokenService{}
+	tokenFake.setValidateResult(refreshClaims(userRecord.ID, seed))
+	usecase := newRegistrationUsecaseForTest(h, &fakeMailer{}, tokenFake)
+
+	_, err := usecase.RefreshTokens(ctx, "valid-refresh")
+	require.ErrorIs(t, err, auth.ErrAccountSuspended)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+
+	stored, err := h.authRepo.GetRefreshToken(ctx, seed.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.UsedAt.Valid, "The refresh token should not be consumed")
+}
+
+func TestVerifyPasskeyLogin_SuspendedAccount(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("suspended-passkey-%d@example.com", time.Now().UnixNano())
+	authID, _ := createAuthAndUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+	suspendAccount(t, h, authID, time.Time{})
+
+	tokenFake := &fakeTokenService{}
+	passkeys := &fakePasskeyService{credential: &db.WebauthnCredential{AuthID: authID}}
+	usecase := newPasskeyUsecaseForTest(h, tokenFake, passkeys)
+
+	_, err := usecase.VerifyPasskeyLogin(ctx, webauthn.Assertion{}, registration.ClientInfo{})
+	require.ErrorIs(t, err, auth.ErrAccountSuspended)
+	assert.Equal(t, 0, tokenFake.generateCallCount())
+}
+
+func TestRegisterOrLoginOTP_NormalizesEmail(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	local := fmt.Sprintf("mixed-%d", time.Now().UnixNano())
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("Code: {{.OTP}}")
+	usecase := newRegistrationUsecaseForTest(h, mailerFake, &fakeTokenService{})
+
+	entered := strings.ToUpper(local) + "+promo@Example.COM"
+	result, err := usecase.RegisterOrLoginOTP(ctx, " "+entered+" ")
+	require.NoError(t, err)
+	assert.True(t, result.IsNewUser)
+
+	emailParams, err := mailerFake.lastEmail()
+	require.NoError(t, err)
+	assert.Equal(t, entered, emailParams.To, "mail goes to the address as entered")
+
+	result, err = usecase.RegisterOrLoginMagicLink(ctx, local+"+other@example.com")
+	require.NoError(t, err)
+	assert.False(t, result.IsNewUser, "a plus tag must not open a second account")
+
+	emailParams, err = mailerFake.lastEmail()
+	require.NoError(t, err)
+	assert.Equal(t, entered, emailParams.To, "mail goes to the stored address, not the spelling used to sign in")
+
+	_, err = usecase.RegisterOrLoginOTP(ctx, "not-an-email")
+	require.ErrorIs(t, err, emailpolicy.ErrInvalidEmail)
+}
+
+func TestRegisterOrLoginOTP_DomainPolicy(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	rules := emailpolicy.StaticRules{}
+	rules.Add(emailpolicy.ActionDeny, "blocked.example")
+	policy := emailpolicy.NewPolicy([]emailpolicy.RuleSource{rules, emailpolicy.DisposableRules()}, nil)
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("Code: {{.OTP}}")
+	usecase := newPolicyUsecaseForTest(h, mailerFake, policy)
+
+	suffix := time.Now().UnixNano()
+	for _, email := range []string{
+		fmt.Sprintf("new-%d@blocked.example", suffix),
+		fmt.Sprintf("new-%d@mx.blocked.example", suffix),
+		fmt.Sprintf("new-%d@mailinator.com", suffix),
+	} {
+		_, err := usecase.RegisterOrLoginOTP(ctx, email)
+		require.ErrorIs(t, err, emailpolicy.ErrDomainNotAllowed, email)
+		_, err = h.userRepo.GetUserByEmail(ctx, email)
+		require.ErrorIs(t, err, qqerrors.ErrNotFound, "no account is created for %s", email)
+	}
+	assert.Equal(t, 0, mailerFake.emailCount())
+
+	// The policy guards sign-up only; accounts that already exist keep signing in.
+	existing := fmt.Sprintf("existing-%d@blocked.example", suffix)
+	createAuthAndUser(t, h, existing, fmt.Sprintf("user_%d", suffix))
+	result, err := usecase.RegisterOrLoginOTP(ctx, existing)
+	require.NoError(t, err)
+	assert.False(t, result.IsNewUser)
+}
+
+func TestRegisterOrLoginOTP_HiddenAccountExistence_DomainPolicy(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	rules := emailpolicy.StaticRules{}
+	rules.Add(emailpolicy.ActionDeny, "blocked.example")
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("Code: {{.OTP}}")
+	usecase := newHiddenUsecaseForTest(h, mailerFake, 0, emailpolicy.NewPolicy([]emailpolicy.RuleSource{rules}, nil))
+
+	// A refused domain is answered alike whether or not the account exists.
+	suffix := time.Now().UnixNano()
+	existing := fmt.Sprintf("existing-%d@blocked.example", suffix)
+	createAuthAndUser(t, h, existing, fmt.Sprintf("user_%d", suffix))
+	for _, email := range []string{existing, fmt.Sprintf("new-%d@blocked.example", suffix)} {
+		_, err := usecase.RegisterOrLoginOTP(ctx, email)
+		require.ErrorIs(t, err, emailpolicy.ErrDomainNotAllowed, email)
+	}
+	assert.Equal(t, 0, mailerFake.emailCount())
+}
+
+func TestRegisterOrLoginOTP_HiddenAccountExistence(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	mailerFake := &fakeMailer{}
+	mailerFake.setTemplate("Code: {{.OTP}}")
+	usecase := newHiddenUsecaseForTest(h, mailerFake, 0, emailpolicy.NewPolicy(nil, nil))
+	hidden := registration.SendResult{ResendAfter: time.Minute, AccountHidden: true}
+
+	newEmail := fmt.Sprintf("hidden-new-%d@example.com", time.Now().UnixNano())
+	result, err := usecase.RegisterOrLoginOTP(ctx, newEmail)
+	require.NoError(t, err)
+	assert.Equal(t, hidden, result, "A new account should not be reported")
+	assert.Equal(t, 1, mailerFake.emailCount())
+
+	result, err = usecase.RegisterOrLoginMagicLink(ctx, newEmail)
+	require.NoError(t, err, "The resend cooldown should not be reported")
+	assert.Equal(t, hidden, result)
+	assert.Equal(t, 1, mailerFake.emailCount())
+
+	suspended := fmt.Sprintf("hidden-suspended-%d@example.com", time.Now().UnixNano())
+	authID, _ := createAuthAndUser(t, h, suspended, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+	suspendAccount(t, h, authID, time.Time{})
+	result, err = usecase.RegisterOrLoginOTP(ctx, suspended)
+	require.NoError(t, err, "A suspension should not be reported")
+	assert.Equal(t, hidden, result)
+	assert.Equal(t, 1, mailerFake.emailCount())
+	verifyOTPCount(t, h, authID, 0)
+
+	_, err = usecase.RegisterOrLoginOTP(ctx, "not-an-email")
+	require.ErrorIs(t, err, emailpolicy.ErrInvalidEmail, "Invalid addresses are still refused")
+}
+
+func TestRegisterOrLoginOTP_HiddenSendMinDuration(t *testing.T) {
+	h := newRegistrationTestHarness(t)
+	ctx := context.Background()
+
+	email := fmt.Sprintf("hidden-timing-%d@example.com", time.Now().UnixNano())
+	authID, _ := createAuthAndUser(t, h, email, fmt.Sprintf("user_%d", time.Now().UnixNano()))
+	suspendAccount(t, h, authID, time.Time{})
+
+	minDuration := 50 * time.Millisecond
+	usecase := newHiddenUsecaseForTest(h, &fakeMailer{}, minDuration, emailpolicy.NewPolicy(nil, nil))
+
+	started := time.Now()
+	_, err := usecase.RegisterOrLoginOTP(ctx, email)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(started), minDuration, "A refused send should take as long as a real one")
+}
@@ -0,0 +1,192 @@
+package user_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	"github.com/abdurrahimagca/qq-back/internal/platform/file-upload/fileuploadtest"
+	"github.com/abdurrahimagca/qq-back/internal/user"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// fakeRepository keeps users in memory, keyed by ID.
+type fakeRepository struct {
+	mu      sync.Mutex
+	users   map[pgtype.UUID]db.User
+	updates []db.UpdateUserParams
+	// updateErr is returned by UpdateUser, as the database would for a
+	// username taken between the availability check and the update.
+	updateErr error
+}
+
+func newFakeRepository(users ...db.User) *fakeRepository {
+	repo := &fakeRepository{users: make(map[pgtype.UUID]db.User)}
+	for _, u := range users {
+		repo.users[u.ID] = u
+	}
+	return repo
+}
+
+func (f *fakeRepository) WithTx(tx pgx.Tx) user.Repository {
+	return f
+}
+
+func (f *fakeRepository) GetUserByID(ctx context.Context, userID pgtype.UUID) (*db.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[userID]
+	if !ok {
+		return nil, qqerrors.ErrNotFound
+	}
+	return &u, nil
+}
+
+func (f *fakeRepository) CreateUserWithAuthID(
+	ctx context.Context, authID pgtype.UUID, username string) (*db.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := db.User{ID: authID, AuthID: authID, Username: username, PrivacyLevel: db.PrivacyLevelPublic}
+	f.users[u.ID] = u
+	return &u, nil
+}
+
+func (f *fakeRepository) GetUserByEmail(ctx context.Context, email string) (*db.User, error) {
+	return nil, qqerrors.ErrNotFound
+}
+
+func (f *fakeRepository) GetUserByAuthID(ctx context.Context, authID pgtype.UUID) (*db.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.AuthID == authID {
+			return &u, nil
+		}
+	}
+	return nil, qqerrors.ErrNotFound
+}
+
+func (f *fakeRepository) UpdateUser(ctx context.Context, params db.UpdateUserParams) (*db.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates = append(f.updates, params)
+	if f.updateErr != nil {
+		return nil, f.updateErr
+	}
+	u, ok := f.users[params.ID]
+	if !ok {
+		return nil, qqerrors.ErrNotFound
+	}
+	if params.Username.Valid {
+		u.Username = params.Username.String
+	}
+	if params.DisplayName.Valid {
+		u.DisplayName = params.DisplayName
+	}
+	if params.AvatarKey.Valid {
+		u.AvatarKey = params.AvatarKey
+	}
+	if params.PrivacyLevel.Valid {
+		u.PrivacyLevel = params.PrivacyLevel.PrivacyLevel
+	}
+	f.users[u.ID] = u
+	return &u, nil
+}
+
+func (f *fakeRepository) ClearAvatarKey(ctx context.Context, userID pgtype.UUID) (*db.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[userID]
+	if !ok {
+		return nil, qqerrors.ErrNotFound
+	}
+	u.AvatarKey = pgtype.Text{}
+	f.users[u.ID] = u
+	return &u, nil
+}
+
+func (f *fakeRepository) UserNameExists(ctx context.Context, username string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Username == username {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pngProcessor stands in for the WebP processor, which needs cgo: it decodes
+// the input like the real one and stores it re-encoded as PNG, unresized.
+type pngProcessor struct{}
+
+func (p *pngProcessor) ImageProcessor(ctx context.Context, file io.Reader) (*imageprocess.ProcessedImage, error) {
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", imageprocess.ErrInvalidImage, err)
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &imageprocess.ProcessedImage{Data: buf.Bytes(), MimeType: "image/png"}, nil
+}
+
+func (p *pngProcessor) RenditionProcessor(
+	ctx context.Context, file io.Reader, renditions []imageprocess.Rendition,
+) (map[string]*imageprocess.ProcessedImage, error) {
+	processed, err := p.ImageProcessor(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string]*imageprocess.ProcessedImage, len(renditions))
+	for _, rendition := range renditions {
+		images[rendition.Name] = processed
+	}
+	return images, nil
+}
+
+const testBucket = "avatars"
+
+var testAvatarConf = environment.AvatarEnvironment{
+	UploadsEnabled: true,
+	MaxBytes:       64 << 10,
+	URLTTL:         time.Hour,
+}
+
+// newTestStorage returns an uploader backed by a local S3-compatible server,
+// which is closed when the test ends.
+func newTestStorage(t *testing.T) (*fileuploadtest.Server, fileupload.Uploader) {
+	t.Helper()
+	storage := fileuploadtest.NewServer()
+	t.Cleanup(storage.Close)
+	return storage, fileupload.NewR2Service(storage.Environment(testBucket), &pngProcessor{})
+}
+
+func newTestServer(t *testing.T, repo *fakeRepository) (*fileuploadtest.Server, user.Server) {
+	t.Helper()
+	storage, uploader := newTestStorage(t)
+	service := user.NewService(repo)
+	return storage, user.NewServer(service, user.NewAvatarService(service, uploader, testAvatarConf), testAvatarConf)
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encoding test image: %v", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,202 @@
+package user_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abdurrahimagca/qq-back/internal/middleware"
+	"github.com/abdurrahimagca/qq-back/internal/user"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/humatest"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requireStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, status, statusErr.GetStatus())
+}
+
+func stringPtr(value string) *string {
+	return &value
+}
+
+func TestServer_GetProfileHandler(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	alice.DisplayName = pgtype.Text{String: "Alice", Valid: true}
+	_, server := newTestServer(t, newFakeRepository(alice))
+
+	resp, err := server.GetProfileHandler(middleware.WithUser(context.Background(), &alice), &user.GetProfileInput{})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", resp.Body.Data.Username)
+	assert.Equal(t, "public", resp.Body.Data.PrivacyLevel)
+	require.NotNil(t, resp.Body.Data.DisplayName)
+	assert.Equal(t, "Alice", *resp.Body.Data.DisplayName)
+
+	_, err = server.GetProfileHandler(context.Background(), &user.GetProfileInput{})
+	requireStatus(t, err, http.StatusUnauthorized)
+}
+
+func TestServer_UpdateProfileHandler(t *testing.T) {
+	alice, bob := newTestUsers(t)
+	repo := newFakeRepository(alice, bob)
+	_, server := newTestServer(t, repo)
+	ctx := middleware.WithUser(context.Background(), &alice)
+
+	input := &user.UpdateProfileInput{}
+	input.Body.DisplayName = stringPtr("Alice A.")
+	input.Body.PrivacyLevel = stringPtr("full_private")
+	resp, err := server.UpdateProfileHandler(ctx, input)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", resp.Body.Data.Username, "Fields left out keep their value")
+	assert.Equal(t, "Alice A.", *resp.Body.Data.DisplayName)
+	assert.Equal(t, "full_private", resp.Body.Data.PrivacyLevel)
+
+	input = &user.UpdateProfileInput{}
+	input.Body.Username = stringPtr("alice")
+	_, err = server.UpdateProfileHandler(ctx, input)
+	require.NoError(t, err, "Sending the current username is not a change")
+	assert.False(t, repo.updates[len(repo.updates)-1].Username.Valid)
+
+	input.Body.Username = stringPtr("bob")
+	_, err = server.UpdateProfileHandler(ctx, input)
+	requireStatus(t, err, http.StatusUnprocessableEntity)
+
+	_, err = server.UpdateProfileHandler(context.Background(), input)
+	requireStatus(t, err, http.StatusUnauthorized)
+}
+
+func TestServer_UsernameAvailableHandler(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	_, server := newTestServer(t, newFakeRepository(alice))
+	ctx := context.Background()
+
+	input := &user.UsernameAvailableInput{}
+	input.Body.Username = "free_name"
+	resp, err := server.UsernameAvailableHandler(ctx, input)
+	require.NoError(t, err)
+	assert.True(t, resp.Body.Data.Available)
+
+	input.Body.Username = "alice"
+	_, err = server.UsernameAvailableHandler(ctx, input)
+	requireStatus(t, err, http.StatusUnprocessableEntity)
+}
+
+// TestServer_Validation goes through Huma so the documented username format
+// and privacy levels are checked before the handlers run.
+func TestServer_Validation(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	repo := newFakeRepository(alice)
+	_, api := humatest.New(t)
+	api.UseMiddleware(func(ctx huma.Context, next func(huma.Context)) {
+		next(huma.WithValue(ctx, middleware.UserContextKey, &alice))
+	})
+	_, uploader := newTestStorage(t)
+	user.NewModule(user.NewService(repo), uploader, testAvatarConf).RegisterEndpoints(api)
+
+	resp := api.Get("/me/profile")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"Data":{"displayName":null,"username":"alice","privacyLevel":"public"}}`, resp.Body.String())
+
+	for _, body := range []map[string]any{
+		{"username": "ab"},
+		{"username": "with space"},
+		{"username": strings.Repeat("a", 513)},
+		{"privacyLevel": "friends"},
+		{"displayName": strings.Repeat("a", 513)},
+	} {
+		resp = api.Post("/me/update-profile", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code, body)
+	}
+	assert.Empty(t, repo.updates, "Invalid updates should not reach the service")
+
+	resp = api.Post("/me/update-profile", map[string]any{"displayName": nil, "username": "alice_b"})
+	assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	resp = api.Post("/user/username-available", map[string]any{"username": "bad/name"})
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	resp = api.Post("/user/username-available", map[string]any{"username": "good-name"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = api.Post("/user/username-available", map[string]any{"username": "alice_b"})
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+}
+
+func TestServer_AvatarHandlers(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	repo := newFakeRepository(alice)
+	storage, server := newTestServer(t, repo)
+	ctx := middleware.WithUser(context.Background(), &alice)
+
+	_, err := server.GetAvatarHandler(ctx, &user.GetAvatarInput{})
+	requireStatus(t, err, http.StatusNotFound)
+	_, err = server.DeleteAvatarHandler(ctx, &user.DeleteAvatarInput{})
+	requireStatus(t, err, http.StatusUnprocessableEntity)
+	_, err = server.UploadAvatarHandler(ctx, &user.UploadAvatarInput{})
+	requireStatus(t, err, http.StatusUnprocessableEntity)
+	_, err = server.UploadAvatarHandler(ctx, &user.UploadAvatarInput{RawBody: []byte("not an image")})
+	requireStatus(t, err, http.StatusUnprocessableEntity)
+
+	uploaded, err := server.UploadAvatarHandler(ctx, &user.UploadAvatarInput{RawBody: testPNG(t)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3600), uploaded.Body.Data.ExpiresIn)
+	assert.NotEmpty(t, uploaded.Body.Data.SignedURL)
+
+	current, err := repo.GetUserByID(ctx, alice.ID)
+	require.NoError(t, err)
+	ctx = middleware.WithUser(context.Background(), current)
+	resp, err := server.GetAvatarHandler(ctx, &user.GetAvatarInput{})
+	require.NoError(t, err)
+	assert.Contains(t, resp.Body.Data.SignedURL, current.AvatarKey.String+"/large")
+	assert.Contains(t, resp.Body.Data.Renditions["small"], current.AvatarKey.String+"/small")
+
+	_, err = server.DeleteAvatarHandler(ctx, &user.DeleteAvatarInput{})
+	require.NoError(t, err)
+	assert.Empty(t, storage.Keys(testBucket))
+
+	_, err = server.GetAvatarHandler(context.Background(), &user.GetAvatarInput{})
+	requireStatus(t, err, http.StatusUnauthorized)
+	_, err = server.UploadAvatarHandler(context.Background(), &user.UploadAvatarInput{RawBody: testPNG(t)})
+	requireStatus(t, err, http.StatusUnauthorized)
+	_, err = server.DeleteAvatarHandler(context.Background(), &user.DeleteAvatarInput{})
+	requireStatus(t, err, http.StatusUnauthorized)
+}
+
+// TestServer_AvatarUpload goes through Huma, which reads the raw body and
+// enforces the size limit.
+func TestServer_AvatarUpload(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	repo := newFakeRepository(alice)
+	_, api := humatest.New(t)
+	api.UseMiddleware(func(ctx huma.Context, next func(huma.Context)) {
+		next(huma.WithValue(ctx, middleware.UserContextKey, &alice))
+	})
+	storage, uploader := newTestStorage(t)
+	user.NewModule(user.NewService(repo), uploader, testAvatarConf).RegisterEndpoints(api)
+
+	resp := api.Post("/me/avatar", "Content-Type: image/png", bytes.NewReader(testPNG(t)))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var body struct {
+		Data struct {
+			SignedURL  string            `json:"signedUrl"`
+			Renditions map[string]string `json:"renditions"`
+			ExpiresIn  int64             `json:"expiresIn"`
+		}
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	assert.Equal(t, int64(3600), body.Data.ExpiresIn)
+	assert.NotEmpty(t, body.Data.SignedURL)
+	assert.Len(t, body.Data.Renditions, 3)
+	assert.Len(t, storage.Keys(testBucket), 3)
+
+	tooLarge := bytes.Repeat([]byte{0}, int(testAvatarConf.MaxBytes)+1)
+	resp = api.Post("/me/avatar", "Content-Type: image/png", bytes.NewReader(tooLarge))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Len(t, storage.Keys(testBucket), 3)
+}
@@ -0,0 +1,249 @@
+package user_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/platform/file-upload/fileuploadtest"
+	"github.com/abdurrahimagca/qq-back/internal/user"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUUID(t *testing.T, value string) pgtype.UUID {
+	t.Helper()
+	var id pgtype.UUID
+	require.NoError(t, id.Scan(value))
+	return id
+}
+
+func newTestUsers(t *testing.T) (db.User, db.User) {
+	t.Helper()
+	alice := db.User{
+		ID:           newTestUUID(t, "11111111-1111-1111-1111-111111111111"),
+		Username:     "alice",
+		PrivacyLevel: db.PrivacyLevelPublic,
+	}
+	bob := db.User{
+		ID:           newTestUUID(t, "22222222-2222-2222-2222-222222222222"),
+		Username:     "bob",
+		PrivacyLevel: db.PrivacyLevelPublic,
+	}
+	return alice, bob
+}
+
+func TestService_UserNameAvailable(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	service := user.NewService(newFakeRepository(alice))
+	ctx := context.Background()
+
+	available, err := service.UserNameAvailable(ctx, "new_user-1")
+	require.NoError(t, err)
+	assert.True(t, available)
+
+	available, err = service.UserNameAvailable(ctx, "alice")
+	require.NoError(t, err)
+	assert.False(t, available)
+
+	for _, username := range []string{"", "ab", "has space", "dots.not.allowed", "ünicode", strings.Repeat("a", 513)} {
+		_, err = service.UserNameAvailable(ctx, username)
+		require.ErrorIs(t, err, user.ErrInvalidUsername, username)
+		assert.ErrorIs(t, err, qqerrors.ErrValidationError)
+	}
+}
+
+func TestService_UpdateUser(t *testing.T) {
+	alice, bob := newTestUsers(t)
+	repo := newFakeRepository(alice, bob)
+	service := user.NewService(repo)
+	ctx := context.Background()
+
+	updated, err := service.UpdateUser(ctx, db.UpdateUserParams{
+		ID:           alice.ID,
+		Username:     pgtype.Text{String: "alice_2", Valid: true},
+		DisplayName:  pgtype.Text{String: "Alice", Valid: true},
+		PrivacyLevel: db.NullPrivacyLevel{PrivacyLevel: db.PrivacyLevelPrivate, Valid: true},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "alice_2", updated.Username)
+	assert.Equal(t, "Alice", updated.DisplayName.String)
+	assert.Equal(t, db.PrivacyLevelPrivate, updated.PrivacyLevel)
+
+	_, err = service.UpdateUser(ctx, db.UpdateUserParams{ID: alice.ID, Username: pgtype.Text{String: "bob", Valid: true}})
+	require.ErrorIs(t, err, user.ErrUsernameTaken)
+
+	_, err = service.UpdateUser(ctx, db.UpdateUserParams{ID: alice.ID, Username: pgtype.Text{String: "b!", Valid: true}})
+	require.ErrorIs(t, err, user.ErrInvalidUsername)
+	assert.Len(t, repo.updates, 1, "Refused updates should not reach the repository")
+}
+
+func TestService_UpdateUser_UsernameTakenConcurrently(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	repo := newFakeRepository(alice)
+	repo.updateErr = qqerrors.GetDBErrAsQQError(&pgconn.PgError{Code: qqerrors.SQLUniqueViolation})
+	service := user.NewService(repo)
+
+	_, err := service.UpdateUser(context.Background(), db.UpdateUserParams{
+		ID:       alice.ID,
+		Username: pgtype.Text{String: "racer", Valid: true},
+	})
+	require.ErrorIs(t, err, user.ErrUsernameTaken)
+}
+
+func newTestAvatarService(
+	t *testing.T, repo *fakeRepository, conf environment.AvatarEnvironment,
+) (*fileuploadtest.Server, user.AvatarService) {
+	t.Helper()
+	storage, uploader := newTestStorage(t)
+	return storage, user.NewAvatarService(user.NewService(repo), uploader, conf)
+}
+
+// avatarKeys lists the objects of an avatar stored under prefix, sorted like
+// fileuploadtest.Server.Keys.
+func avatarKeys(prefix string) []string {
+	return []string{prefix + "/large", prefix + "/medium", prefix + "/small"}
+}
+
+func TestAvatarService_UploadAvatar(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	repo := newFakeRepository(alice)
+	storage, avatars := newTestAvatarService(t, repo, testAvatarConf)
+	ctx := context.Background()
+
+	avatar, err := avatars.UploadAvatar(ctx, &alice, bytes.NewReader(testPNG(t)))
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, avatar.ExpiresIn)
+	require.Len(t, avatar.Renditions, 3)
+	assert.Equal(t, avatar.Renditions["large"], avatar.SignedURL, "The plain URL is the largest rendition")
+
+	current, err := repo.GetUserByID(ctx, alice.ID)
+	require.NoError(t, err)
+	require.True(t, current.AvatarKey.Valid)
+	firstKey := current.AvatarKey.String
+	assert.Equal(t, avatarKeys(firstKey), storage.Keys(testBucket), "Every rendition shares the key prefix")
+	object, _ := storage.Object(testBucket, firstKey+"/small")
+	assert.Equal(t, "image/png", object.ContentType, "The processed image is stored, not the upload")
+
+	assert.Contains(t, avatar.Renditions["small"], firstKey+"/small")
+	resp, err := http.Get(avatar.Renditions["small"])
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, err)
+	assert.Equal(t, object.Data, body, "The signed URL serves the avatar")
+
+	_, err = avatars.UploadAvatar(ctx, current, bytes.NewReader(testPNG(t)))
+	require.NoError(t, err)
+	current, err = repo.GetUserByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, firstKey, current.AvatarKey.String)
+	assert.Equal(t, avatarKeys(current.AvatarKey.String), storage.Keys(testBucket), "The previous avatar is deleted")
+}
+
+func TestAvatarService_UploadAvatar_Errors(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	ctx := context.Background()
+
+	t.Run("invalid image", func(t *testing.T) {
+		repo := newFakeRepository(alice)
+		storage, avatars := newTestAvatarService(t, repo, testAvatarConf)
+		_, err := avatars.UploadAvatar(ctx, &alice, strings.NewReader("not an image"))
+		require.ErrorIs(t, err, user.ErrInvalidAvatar)
+		assert.ErrorIs(t, err, qqerrors.ErrValidationError)
+		assert.Empty(t, storage.Keys(testBucket))
+		assert.Empty(t, repo.updates)
+	})
+
+	t.Run("uploads disabled", func(t *testing.T) {
+		conf := testAvatarConf
+		conf.UploadsEnabled = false
+		storage, avatars := newTestAvatarService(t, newFakeRepository(alice), conf)
+		_, err := avatars.UploadAvatar(ctx, &alice, bytes.NewReader(testPNG(t)))
+		require.ErrorIs(t, err, user.ErrAvatarUploadsDisabled)
+		assert.ErrorIs(t, err, qqerrors.ErrForbidden)
+		assert.Empty(t, storage.Keys(testBucket))
+	})
+
+	t.Run("user update fails", func(t *testing.T) {
+		repo := newFakeRepository(alice)
+		repo.updateErr = qqerrors.ErrInternalServer
+		storage, avatars := newTestAvatarService(t, repo, testAvatarConf)
+		_, err := avatars.UploadAvatar(ctx, &alice, bytes.NewReader(testPNG(t)))
+		require.ErrorIs(t, err, qqerrors.ErrInternalServer)
+		assert.Empty(t, storage.Keys(testBucket), "The unused upload is deleted")
+	})
+}
+
+func TestAvatarService_GetAndDeleteAvatar(t *testing.T) {
+	alice, _ := newTestUsers(t)
+	repo := newFakeRepository(alice)
+	storage, avatars := newTestAvatarService(t, repo, testAvatarConf)
+	ctx := context.Background()
+
+	_, err := avatars.GetAvatarURL(ctx, &alice)
+	require.ErrorIs(t, err, user.ErrAvatarNotFound)
+	assert.ErrorIs(t, err, qqerrors.ErrNotFound)
+	err = avatars.DeleteAvatar(ctx, &alice)
+	require.ErrorIs(t, err, user.ErrNoAvatarToDelete)
+	assert.ErrorIs(t, err, qqerrors.ErrValidationError)
+
+	_, err = avatars.UploadAvatar(ctx, &alice, bytes.NewReader(testPNG(t)))
+	require.NoError(t, err)
+	current, err := repo.GetUserByID(ctx, alice.ID)
+	require.NoError(t, err)
+
+	avatar, err := avatars.GetAvatarURL(ctx, current)
+	require.NoError(t, err)
+	for _, name := range []string{"small", "medium", "large"} {
+		assert.Contains(t, avatar.Renditions[name], current.AvatarKey.String+"/"+name)
+		assert.Contains(t, avatar.Renditions[name], "X-Amz-Expires=3600")
+	}
+	assert.Equal(t, time.Hour, avatar.ExpiresIn)
+
+	require.NoError(t, avatars.DeleteAvatar(ctx, current))
+	current, err = repo.GetUserByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.False(t, current.AvatarKey.Valid)
+	assert.Empty(t, storage.Keys(testBucket))
+}
+
+func TestAvatarService_SingleObjectAvatar(t *testing.T) {
+	// Avatars uploaded before renditions are one image stored at the key.
+	alice, _ := newTestUsers(t)
+	storage, uploader := newTestStorage(t)
+	ctx := context.Background()
+	key, err := uploader.UploadFile(ctx, bytes.NewReader(testPNG(t)))
+	require.NoError(t, err)
+	alice.AvatarKey = pgtype.Text{String: *key, Valid: true}
+	repo := newFakeRepository(alice)
+	avatars := user.NewAvatarService(user.NewService(repo), uploader, testAvatarConf)
+
+	avatar, err := avatars.GetAvatarURL(ctx, &alice)
+	require.NoError(t, err)
+	require.Len(t, avatar.Renditions, 3)
+	object, _ := storage.Object(testBucket, *key)
+	for name, url := range avatar.Renditions {
+		assert.NotContains(t, url, *key+"/", "%s links to the single image", name)
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, resp.Body.Close())
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, object.Data, body, "%s serves the image", name)
+	}
+	assert.Equal(t, avatar.Renditions["large"], avatar.SignedURL)
+
+	require.NoError(t, avatars.DeleteAvatar(ctx, &alice))
+	assert.Empty(t, storage.Keys(testBucket), "The single image is deleted")
+}
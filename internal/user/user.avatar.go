@@ -0,0 +1,138 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	imageprocess "github.com/abdurrahimagca/qq-back/internal/image-process"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// AvatarURL holds signed links to an avatar and how long they stay valid.
+// SignedURL is the largest rendition; Renditions has every rendition by name.
+type AvatarURL struct {
+	SignedURL  string
+	Renditions map[string]string
+	ExpiresIn  time.Duration
+}
+
+type AvatarService interface {
+	UploadAvatar(ctx context.Context, user *db.User, image io.Reader) (*AvatarURL, error)
+	GetAvatarURL(ctx context.Context, user *db.User) (*AvatarURL, error)
+	DeleteAvatar(ctx context.Context, user *db.User) error
+}
+
+type avatarService struct {
+	users    Service
+	uploader fileupload.Uploader
+	conf     environment.AvatarEnvironment
+}
+
+func NewAvatarService(users Service, uploader fileupload.Uploader, conf environment.AvatarEnvironment) AvatarService {
+	return &avatarService{
+		users:    users,
+		uploader: uploader,
+		conf:     conf,
+	}
+}
+
+// UploadAvatar stores image in every avatar rendition and makes the shared key
+// prefix the user's avatar key, then removes the previous renditions. The new
+// ones are removed again when the user cannot be updated; previous ones that
+// cannot be removed are only logged, since the user already points at the new
+// ones.
+func (s *avatarService) UploadAvatar(ctx context.Context, user *db.User, image io.Reader) (*AvatarURL, error) {
+	if !s.conf.UploadsEnabled {
+		return nil, ErrAvatarUploadsDisabled
+	}
+
+	key, err := s.uploader.UploadRenditions(ctx, image, imageprocess.AvatarRenditions)
+	if errors.Is(err, imageprocess.ErrInvalidImage) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAvatar, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.users.UpdateUser(ctx, db.UpdateUserParams{
+		ID:        user.ID,
+		AvatarKey: pgtype.Text{String: *key, Valid: true},
+	})
+	if err != nil {
+		if deleteErr := s.uploader.DeleteRenditions(ctx, *key, imageprocess.AvatarRenditions); deleteErr != nil {
+			slog.Default().Error("Error deleting unused avatar", "key", *key, "error", deleteErr)
+		}
+		return nil, err
+	}
+
+	if user.AvatarKey.Valid && user.AvatarKey.String != "" && user.AvatarKey.String != *key {
+		err = s.uploader.DeleteRenditions(ctx, user.AvatarKey.String, imageprocess.AvatarRenditions)
+		if err != nil {
+			slog.Default().Error("Error deleting previous avatar", "key", user.AvatarKey.String, "error", err)
+		}
+	}
+
+	return s.signedURL(ctx, *key, true)
+}
+
+// GetAvatarURL signs a link to each rendition of the user's avatar. A user
+// without one is ErrAvatarNotFound. Avatars uploaded before renditions are a
+// single image at the key, so every rendition links to that image.
+func (s *avatarService) GetAvatarURL(ctx context.Context, user *db.User) (*AvatarURL, error) {
+	if !user.AvatarKey.Valid || user.AvatarKey.String == "" {
+		return nil, ErrAvatarNotFound
+	}
+	largest := imageprocess.AvatarRenditions[len(imageprocess.AvatarRenditions)-1]
+	hasRenditions, err := s.uploader.FileExists(ctx, fileupload.RenditionKey(user.AvatarKey.String, largest.Name))
+	if err != nil {
+		return nil, err
+	}
+	return s.signedURL(ctx, user.AvatarKey.String, hasRenditions)
+}
+
+// DeleteAvatar unsets the user's avatar and removes its renditions, or the
+// single image of an avatar uploaded before renditions. A user without one is
+// ErrNoAvatarToDelete. The key is cleared first so a failed removal leaves
+// unused objects rather than a user pointing at nothing.
+func (s *avatarService) DeleteAvatar(ctx context.Context, user *db.User) error {
+	if !user.AvatarKey.Valid || user.AvatarKey.String == "" {
+		return ErrNoAvatarToDelete
+	}
+	if _, err := s.users.ClearAvatarKey(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.uploader.DeleteRenditions(ctx, user.AvatarKey.String, imageprocess.AvatarRenditions); err != nil {
+		slog.Default().Error("Error deleting avatar", "key", user.AvatarKey.String, "error", err)
+	}
+	return nil
+}
+
+// signedURL signs the renditions stored under key, or the single image at key
+// when hasRenditions is false.
+func (s *avatarService) signedURL(ctx context.Context, key string, hasRenditions bool) (*AvatarURL, error) {
+	avatar := &AvatarURL{
+		Renditions: make(map[string]string, len(imageprocess.AvatarRenditions)),
+		ExpiresIn:  s.conf.URLTTL,
+	}
+	for _, rendition := range imageprocess.AvatarRenditions {
+		objectKey := key
+		if hasRenditions {
+			objectKey = fileupload.RenditionKey(key, rendition.Name)
+		}
+		url, err := s.uploader.GetSignedURL(ctx, objectKey, s.conf.URLTTL)
+		if err != nil {
+			return nil, err
+		}
+		avatar.Renditions[rendition.Name] = *url
+		// Renditions are listed smallest first.
+		avatar.SignedURL = *url
+	}
+	return avatar, nil
+}
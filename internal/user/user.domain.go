@@ -1 +1,173 @@
 package user
+
+import (
+	"fmt"
+	"regexp"
+
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+var moduleErrors = []int{400, 401, 404, 422, 500}
+var avatarUploadErrors = []int{401, 403, 413, 422, 500}
+var moduleTags = []string{"User"}
+var moduleSecurity = []map[string][]string{{"bearer": {}}}
+
+const (
+	GetProfile        = "getMeProfile"
+	UpdateProfile     = "updateMeProfile"
+	UsernameAvailable = "checkUsernameAvailable"
+	GetAvatar         = "getMeAvatar"
+	UploadAvatar      = "uploadNewAvatar"
+	DeleteAvatar      = "deleteAvatar"
+)
+
+// usernamePattern is the documented username format. The same rule is repeated
+// in the struct tags below so Huma rejects bad usernames before a handler runs.
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{3,512}$`)
+
+var (
+	ErrInvalidUsername = fmt.Errorf(
+		"username must be 3 to 512 letters, digits, underscores or hyphens: %w", qqerrors.ErrValidationError)
+	ErrUsernameTaken = fmt.Errorf("username is already taken: %w", qqerrors.ErrValidationError)
+
+	ErrAvatarNotFound        = fmt.Errorf("user has no avatar: %w", qqerrors.ErrNotFound)
+	ErrNoAvatarToDelete      = fmt.Errorf("user has no avatar: %w", qqerrors.ErrValidationError)
+	ErrInvalidAvatar         = fmt.Errorf("avatar is not a valid image: %w", qqerrors.ErrValidationError)
+	ErrAvatarUploadsDisabled = fmt.Errorf("avatar uploads are disabled: %w", qqerrors.ErrForbidden)
+)
+
+var operations = map[string]huma.Operation{
+	GetProfile: {
+		Method:      "GET",
+		Path:        "/me/profile",
+		Summary:     "Get user information",
+		Description: "Get the profile of the current user",
+		OperationID: GetProfile,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	UpdateProfile: {
+		Method:      "POST",
+		Path:        "/me/update-profile",
+		Summary:     "Update user profile",
+		Description: "Update the given fields of the current user's profile; fields left out keep their value",
+		OperationID: UpdateProfile,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	UsernameAvailable: {
+		Method:      "POST",
+		Path:        "/user/username-available",
+		Summary:     "Check if username is available",
+		Description: "Succeeds when the username is free; a username used by another user is a 422",
+		OperationID: UsernameAvailable,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	GetAvatar: {
+		Method:      "GET",
+		Path:        "/me/avatar",
+		Summary:     "Get signed URL for avatar",
+		Description: "Get a time-limited URL to the current user's avatar",
+		OperationID: GetAvatar,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	UploadAvatar: {
+		Method:      "POST",
+		Path:        "/me/avatar",
+		Summary:     "Upload new avatar",
+		Description: "Replace the current user's avatar with the image in the request body",
+		OperationID: UploadAvatar,
+		Errors:      avatarUploadErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+	DeleteAvatar: {
+		Method:      "DELETE",
+		Path:        "/me/avatar",
+		Summary:     "Delete avatar",
+		Description: "Remove the current user's avatar; a user without one is a 422",
+		OperationID: DeleteAvatar,
+		Errors:      moduleErrors,
+		Tags:        moduleTags,
+		Security:    moduleSecurity,
+	},
+}
+
+type UserData struct {
+	DisplayName  *string `json:"displayName" doc:"Display name of the user" example:"John Doe"`
+	Username     string  `json:"username" doc:"Username of the user" example:"john_doe"`
+	PrivacyLevel string  `json:"privacyLevel" doc:"Privacy level of the user" enum:"public,private,full_private"`
+}
+
+type GetProfileInput struct{}
+
+type GetProfileOutput struct {
+	Body struct {
+		Data UserData
+	}
+}
+
+type UpdateProfileInput struct {
+	Body struct {
+		DisplayName  *string `json:"displayName,omitempty" nullable:"true" doc:"Display name of the user" maxLength:"512"`
+		Username     *string `json:"username,omitempty" nullable:"true" doc:"Username of the user" pattern:"^[a-zA-Z0-9_-]+$" minLength:"3" maxLength:"512"`
+		PrivacyLevel *string `json:"privacyLevel,omitempty" nullable:"true" doc:"Privacy level of the user" enum:"public,private,full_private"`
+	}
+}
+
+type UpdateProfileOutput struct {
+	Body struct {
+		Data UserData
+	}
+}
+
+type UsernameAvailableInput struct {
+	Body struct {
+		Username string `json:"username" doc:"Username to check" example:"john_doe" required:"true" pattern:"^[a-zA-Z0-9_-]+$" minLength:"3" maxLength:"512"`
+	}
+}
+
+type UsernameAvailableData struct {
+	Available bool `json:"available"`
+}
+
+type UsernameAvailableOutput struct {
+	Body struct {
+		Data UsernameAvailableData
+	}
+}
+
+type AvatarData struct {
+	SignedURL  string            `json:"signedUrl" doc:"Signed URL for the largest avatar rendition"`
+	Renditions map[string]string `json:"renditions" doc:"Signed URL per rendition: small (64px), medium (256px) and large (1024px) squares"`
+	ExpiresIn  int64             `json:"expiresIn" doc:"Seconds until the signed URLs expire" example:"3600"`
+}
+
+type GetAvatarInput struct{}
+
+type GetAvatarOutput struct {
+	Body struct {
+		Data AvatarData
+	}
+}
+
+type UploadAvatarInput struct {
+	RawBody []byte
+}
+
+type UploadAvatarOutput struct {
+	Body struct {
+		Data AvatarData
+	}
+}
+
+type DeleteAvatarInput struct{}
+
+type DeleteAvatarOutput struct{}
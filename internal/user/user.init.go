@@ -0,0 +1,21 @@
+package user
+
+import (
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	fileupload "github.com/abdurrahimagca/qq-back/internal/platform/file-upload"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+type Module struct {
+	server Server
+}
+
+func NewModule(service Service, uploader fileupload.Uploader, avatarConf environment.AvatarEnvironment) *Module {
+	return &Module{
+		server: NewServer(service, NewAvatarService(service, uploader, avatarConf), avatarConf),
+	}
+}
+
+func (um *Module) RegisterEndpoints(api huma.API) {
+	um.server.RegisterUserEndpoints(api)
+}
@@ -16,8 +16,10 @@ type Repository interface {
 	WithTx(tx pgx.Tx) Repository
 	GetUserByID(ctx context.Context, userID pgtype.UUID) (*db.User, error)
 	CreateUserWithAuthID(ctx context.Context, authID pgtype.UUID, username string) (*db.User, error)
-	GetUserByEmail(ctx context.Context, email string) (*db.User, error)
+	GetUserByEmail(ctx context.Context, normalizedEmail string) (*db.User, error)
+	GetUserByAuthID(ctx context.Context, authID pgtype.UUID) (*db.User, error)
 	UpdateUser(ctx context.Context, user db.UpdateUserParams) (*db.User, error)
+	ClearAvatarKey(ctx context.Context, userID pgtype.UUID) (*db.User, error)
 	UserNameExists(ctx context.Context, username string) (bool, error)
 }
 
@@ -56,8 +58,15 @@ func (r *pgxRepository) CreateUserWithAuthID(
 	}
 	return &dbUser, nil
 }
-func (r *pgxRepository) GetUserByEmail(ctx context.Context, email string) (*db.User, error) {
-	dbUser, err := r.q.GetUserByEmail(ctx, email)
+func (r *pgxRepository) GetUserByEmail(ctx context.Context, normalizedEmail string) (*db.User, error) {
+	dbUser, err := r.q.GetUserByEmail(ctx, normalizedEmail)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &dbUser, nil
+}
+func (r *pgxRepository) GetUserByAuthID(ctx context.Context, authID pgtype.UUID) (*db.User, error) {
+	dbUser, err := r.q.GetUserByAuthID(ctx, authID)
 	if err != nil {
 		return nil, qqerrors.GetDBErrAsQQError(err)
 	}
@@ -71,6 +80,14 @@ func (r *pgxRepository) UpdateUser(ctx context.Context, user db.UpdateUserParams
 	return &dbUser, nil
 }
 
+func (r *pgxRepository) ClearAvatarKey(ctx context.Context, userID pgtype.UUID) (*db.User, error) {
+	dbUser, err := r.q.ClearUserAvatarKey(ctx, userID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &dbUser, nil
+}
+
 func (r *pgxRepository) UserNameExists(ctx context.Context, username string) (bool, error) {
 	exists, err := r.q.UserNameExists(ctx, username)
 	if err != nil {
@@ -0,0 +1,192 @@
+package user
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	"github.com/abdurrahimagca/qq-back/internal/environment"
+	"github.com/abdurrahimagca/qq-back/internal/middleware"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type userServer struct {
+	service    Service
+	avatars    AvatarService
+	avatarConf environment.AvatarEnvironment
+}
+type Server interface {
+	GetProfileHandler(ctx context.Context, input *GetProfileInput) (*GetProfileOutput, error)
+	UpdateProfileHandler(ctx context.Context, input *UpdateProfileInput) (*UpdateProfileOutput, error)
+	UsernameAvailableHandler(ctx context.Context, input *UsernameAvailableInput) (*UsernameAvailableOutput, error)
+	GetAvatarHandler(ctx context.Context, input *GetAvatarInput) (*GetAvatarOutput, error)
+	UploadAvatarHandler(ctx context.Context, input *UploadAvatarInput) (*UploadAvatarOutput, error)
+	DeleteAvatarHandler(ctx context.Context, input *DeleteAvatarInput) (*DeleteAvatarOutput, error)
+	RegisterUserEndpoints(api huma.API)
+}
+
+func NewServer(service Service, avatars AvatarService, avatarConf environment.AvatarEnvironment) Server {
+	return &userServer{
+		service:    service,
+		avatars:    avatars,
+		avatarConf: avatarConf,
+	}
+}
+
+func (s *userServer) GetProfileHandler(ctx context.Context, _ *GetProfileInput) (*GetProfileOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	return &GetProfileOutput{
+		Body: struct {
+			Data UserData
+		}{
+			Data: toUserData(user),
+		},
+	}, nil
+}
+
+func (s *userServer) UpdateProfileHandler(
+	ctx context.Context, input *UpdateProfileInput) (*UpdateProfileOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	params := db.UpdateUserParams{ID: user.ID}
+	// Sending the current username again is not a change, so it must not be
+	// refused as taken.
+	if input.Body.Username != nil && *input.Body.Username != user.Username {
+		params.Username = pgtype.Text{String: *input.Body.Username, Valid: true}
+	}
+	if input.Body.DisplayName != nil {
+		params.DisplayName = pgtype.Text{String: *input.Body.DisplayName, Valid: true}
+	}
+	if input.Body.PrivacyLevel != nil {
+		params.PrivacyLevel = db.NullPrivacyLevel{PrivacyLevel: db.PrivacyLevel(*input.Body.PrivacyLevel), Valid: true}
+	}
+
+	updated, err := s.service.UpdateUser(ctx, params)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &UpdateProfileOutput{
+		Body: struct {
+			Data UserData
+		}{
+			Data: toUserData(updated),
+		},
+	}, nil
+}
+
+func (s *userServer) UsernameAvailableHandler(
+	ctx context.Context, input *UsernameAvailableInput) (*UsernameAvailableOutput, error) {
+	available, err := s.service.UserNameAvailable(ctx, input.Body.Username)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	if !available {
+		return nil, qqerrors.GetHumaErrorFromError(ErrUsernameTaken)
+	}
+
+	return &UsernameAvailableOutput{
+		Body: struct {
+			Data UsernameAvailableData
+		}{
+			Data: UsernameAvailableData{Available: true},
+		},
+	}, nil
+}
+
+func (s *userServer) GetAvatarHandler(ctx context.Context, _ *GetAvatarInput) (*GetAvatarOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	avatar, err := s.avatars.GetAvatarURL(ctx, user)
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &GetAvatarOutput{
+		Body: struct {
+			Data AvatarData
+		}{
+			Data: toAvatarData(avatar),
+		},
+	}, nil
+}
+
+func (s *userServer) UploadAvatarHandler(ctx context.Context, input *UploadAvatarInput) (*UploadAvatarOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+	if len(input.RawBody) == 0 {
+		return nil, qqerrors.GetHumaErrorFromError(ErrInvalidAvatar)
+	}
+
+	avatar, err := s.avatars.UploadAvatar(ctx, user, bytes.NewReader(input.RawBody))
+	if err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+
+	return &UploadAvatarOutput{
+		Body: struct {
+			Data AvatarData
+		}{
+			Data: toAvatarData(avatar),
+		},
+	}, nil
+}
+
+func (s *userServer) DeleteAvatarHandler(ctx context.Context, _ *DeleteAvatarInput) (*DeleteAvatarOutput, error) {
+	user, ok := middleware.GetUserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Unauthorized")
+	}
+
+	if err := s.avatars.DeleteAvatar(ctx, user); err != nil {
+		return nil, qqerrors.GetHumaErrorFromError(err)
+	}
+	return &DeleteAvatarOutput{}, nil
+}
+
+func (s *userServer) RegisterUserEndpoints(api huma.API) {
+	huma.Register(api, operations[GetProfile], s.GetProfileHandler)
+	huma.Register(api, operations[UpdateProfile], s.UpdateProfileHandler)
+	huma.Register(api, operations[UsernameAvailable], s.UsernameAvailableHandler)
+	huma.Register(api, operations[GetAvatar], s.GetAvatarHandler)
+
+	// Huma reads the whole body before the handler runs, so the size limit is
+	// enforced there. It refuses a body of exactly the limit, hence the +1.
+	uploadAvatar := operations[UploadAvatar]
+	uploadAvatar.MaxBodyBytes = s.avatarConf.MaxBytes + 1
+	huma.Register(api, uploadAvatar, s.UploadAvatarHandler)
+	huma.Register(api, operations[DeleteAvatar], s.DeleteAvatarHandler)
+}
+
+func toUserData(user *db.User) UserData {
+	data := UserData{
+		Username:     user.Username,
+		PrivacyLevel: string(user.PrivacyLevel),
+	}
+	if user.DisplayName.Valid {
+		data.DisplayName = &user.DisplayName.String
+	}
+	return data
+}
+
+func toAvatarData(avatar *AvatarURL) AvatarData {
+	return AvatarData{
+		SignedURL:  avatar.SignedURL,
+		Renditions: avatar.Renditions,
+		ExpiresIn:  int64(avatar.ExpiresIn.Seconds()),
+	}
+}
@@ -3,6 +3,7 @@ package user
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 
 	"github.com/abdurrahimagca/qq-back/internal/db"
 	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
@@ -13,8 +14,10 @@ import (
 type Service interface {
 	CreateDefaultUserWithAuthID(ctx context.Context, authID pgtype.UUID) (*db.User, error)
 	GetUserByID(ctx context.Context, userID pgtype.UUID) (*db.User, error)
-	GetUserByEmail(ctx context.Context, email string) (*db.User, error)
+	GetUserByEmail(ctx context.Context, normalizedEmail string) (*db.User, error)
+	GetUserByAuthID(ctx context.Context, authID pgtype.UUID) (*db.User, error)
 	UpdateUser(ctx context.Context, user db.UpdateUserParams) (*db.User, error)
+	ClearAvatarKey(ctx context.Context, userID pgtype.UUID) (*db.User, error)
 	UserNameAvailable(ctx context.Context, username string) (bool, error)
 	WithTx(tx pgx.Tx) Service
 }
@@ -43,13 +46,22 @@ func (s *service) GetUserByID(ctx context.Context, userID pgtype.UUID) (*db.User
 	return s.repo.GetUserByID(ctx, userID)
 }
 
-func (s *service) GetUserByEmail(ctx context.Context, email string) (*db.User, error) {
-	user, err := s.repo.GetUserByEmail(ctx, email)
+// GetUserByEmail finds the user whose account has the normalized email; see
+// emailpolicy.Normalize.
+func (s *service) GetUserByEmail(ctx context.Context, normalizedEmail string) (*db.User, error) {
+	user, err := s.repo.GetUserByEmail(ctx, normalizedEmail)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
+func (s *service) GetUserByAuthID(ctx context.Context, authID pgtype.UUID) (*db.User, error) {
+	return s.repo.GetUserByAuthID(ctx, authID)
+}
+
+// UpdateUser changes the fields set in user. A new username has to match the
+// documented format and be free; one taken by a concurrent update is reported
+// the same way.
 func (s *service) UpdateUser(ctx context.Context, user db.UpdateUserParams) (*db.User, error) {
 	if user.Username.Valid {
 		available, err := s.UserNameAvailable(ctx, user.Username.String)
@@ -57,14 +69,29 @@ func (s *service) UpdateUser(ctx context.Context, user db.UpdateUserParams) (*db
 			return nil, err
 		}
 		if !available {
-			return nil, qqerrors.ErrUniqueViolation
+			return nil, ErrUsernameTaken
 		}
 	}
 
-	return s.repo.UpdateUser(ctx, user)
+	updated, err := s.repo.UpdateUser(ctx, user)
+	if user.Username.Valid && errors.Is(err, qqerrors.ErrUniqueViolation) {
+		return nil, ErrUsernameTaken
+	}
+	return updated, err
+}
+
+// ClearAvatarKey unsets the user's avatar key; UpdateUser cannot, as it keeps
+// the current value of every field left empty.
+func (s *service) ClearAvatarKey(ctx context.Context, userID pgtype.UUID) (*db.User, error) {
+	return s.repo.ClearAvatarKey(ctx, userID)
 }
 
+// UserNameAvailable reports whether no user has the username. Usernames that
+// do not match the documented format are ErrInvalidUsername.
 func (s *service) UserNameAvailable(ctx context.Context, username string) (bool, error) {
+	if !usernamePattern.MatchString(username) {
+		return false, ErrInvalidUsername
+	}
 	exists, err := s.repo.UserNameExists(ctx, username)
 	if err != nil {
 		return false, err
@@ -3,7 +3,10 @@ package qqerrors
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/jackc/pgx/v5"
@@ -85,7 +88,47 @@ func GetDBErrAsQQError(err error) *QQError {
 	}
 }
 
+// RetryAfterError is returned when a caller has used up a budget. It maps to a
+// 429 that tells the client when to try again.
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// statusErrorWithHeaders lets a mapped error carry response headers; huma
+// picks them up through huma.HeadersError.
+type statusErrorWithHeaders struct {
+	huma.StatusError
+	headers http.Header
+}
+
+func (e *statusErrorWithHeaders) GetHeaders() http.Header {
+	return e.headers
+}
+
+func (e *statusErrorWithHeaders) Unwrap() error {
+	return e.StatusError
+}
+
 func GetHumaErrorFromError(err error) huma.StatusError {
+	var retryErr *RetryAfterError
+	if errors.As(err, &retryErr) {
+		// Retry-After takes whole seconds; round up so clients never retry early.
+		seconds := max(int64(math.Ceil(retryErr.RetryAfter.Seconds())), 1)
+		return &statusErrorWithHeaders{
+			StatusError: huma.Error429TooManyRequests("Too many requests", err),
+			headers:     http.Header{"Retry-After": []string{strconv.FormatInt(seconds, 10)}},
+		}
+	}
+
 	// Check if error is already a properly typed SError, and if so, use its status code directly
 	var qqErr *QQError
 	if errors.As(err, &qqErr) {
@@ -98,6 +141,12 @@ func GetHumaErrorFromError(err error) huma.StatusError {
 			return huma.Error422UnprocessableEntity("Validation error", err)
 		case http.StatusBadRequest:
 			return huma.Error400BadRequest("Constraint violation", err)
+		case http.StatusUnauthorized:
+			return huma.Error401Unauthorized("Unauthorized", err)
+		case http.StatusForbidden:
+			return huma.Error403Forbidden("Forbidden", err)
+		case http.StatusTooManyRequests:
+			return huma.Error429TooManyRequests("Too many requests", err)
 		default:
 			return huma.Error500InternalServerError("Internal server error", err)
 		}
@@ -117,6 +166,16 @@ func GetHumaErrorFromError(err error) huma.StatusError {
 		return huma.Error400BadRequest("Constraint violation", err)
 	case errors.Is(err, ErrDuplicateRow):
 		return huma.Error409Conflict("Duplicate row", err)
+	case errors.Is(err, ErrUnauthorized):
+		return huma.Error401Unauthorized("Unauthorized", err)
+	case errors.Is(err, ErrForbidden):
+		return huma.Error403Forbidden("Forbidden", err)
+	case errors.Is(err, ErrAccountSuspended):
+		return huma.Error403Forbidden("Account suspended", err)
+	case errors.Is(err, ErrPendingDeletion):
+		return huma.Error403Forbidden("Account scheduled for deletion", err)
+	case errors.Is(err, ErrTooManyRequests):
+		return huma.Error429TooManyRequests("Too many requests", err)
 	default:
 		return huma.Error500InternalServerError("Internal server error", err)
 	}
@@ -10,5 +10,8 @@ var (
 	ErrDuplicateRow        = errors.New("duplicate row")
 	ErrUnauthorized        = errors.New("unauthorized")
 	ErrForbidden           = errors.New("forbidden")
+	ErrAccountSuspended    = errors.New("account suspended")
+	ErrPendingDeletion     = errors.New("account pending deletion")
+	ErrTooManyRequests     = errors.New("too many requests")
 	ErrInternalServer      = errors.New("internal server error")
 )
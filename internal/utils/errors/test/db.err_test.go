@@ -2,11 +2,14 @@ package qqerrors_test
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
 
+	"github.com/danielgtaylor/huma/v2"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 )
@@ -284,6 +287,89 @@ func TestGetHumaErrorFromError_ErrDuplicateRow(t *testing.T) {
 	}
 }
 
+func TestGetHumaErrorFromError_ErrUnauthorized(t *testing.T) {
+	result := qqerrors.GetHumaErrorFromError(qqerrors.ErrUnauthorized)
+
+	if result == nil {
+		t.Fatal("Expected huma.StatusError, got nil")
+	}
+
+	if result.GetStatus() != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, result.GetStatus())
+	}
+
+	if result.Error() != "Unauthorized" {
+		t.Errorf("Expected message 'Unauthorized', got '%s'", result.Error())
+	}
+}
+
+func TestGetHumaErrorFromError_ErrForbidden(t *testing.T) {
+	result := qqerrors.GetHumaErrorFromError(qqerrors.ErrForbidden)
+
+	if result == nil {
+		t.Fatal("Expected huma.StatusError, got nil")
+	}
+
+	if result.GetStatus() != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, result.GetStatus())
+	}
+
+	if result.Error() != "Forbidden" {
+		t.Errorf("Expected message 'Forbidden', got '%s'", result.Error())
+	}
+}
+
+func TestGetHumaErrorFromError_ErrAccountSuspended(t *testing.T) {
+	wrapped := fmt.Errorf("account is suspended: %w", qqerrors.ErrAccountSuspended)
+	result := qqerrors.GetHumaErrorFromError(wrapped)
+
+	if result == nil {
+		t.Fatal("Expected huma.StatusError, got nil")
+	}
+
+	if result.GetStatus() != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, result.GetStatus())
+	}
+
+	if result.Error() != "Account suspended" {
+		t.Errorf("Expected message 'Account suspended', got '%s'", result.Error())
+	}
+}
+
+func TestGetHumaErrorFromError_ErrPendingDeletion(t *testing.T) {
+	wrapped := fmt.Errorf("account is scheduled for deletion: %w", qqerrors.ErrPendingDeletion)
+	result := qqerrors.GetHumaErrorFromError(wrapped)
+
+	if result == nil {
+		t.Fatal("Expected huma.StatusError, got nil")
+	}
+
+	if result.GetStatus() != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, result.GetStatus())
+	}
+
+	if result.Error() != "Account scheduled for deletion" {
+		t.Errorf("Expected message 'Account scheduled for deletion', got '%s'", result.Error())
+	}
+}
+
+func TestGetHumaErrorFromError_ErrTooManyRequests(t *testing.T) {
+	wrapped := fmt.Errorf("otp attempts exceeded: %w", qqerrors.ErrTooManyRequests)
+	result := qqerrors.GetHumaErrorFromError(wrapped)
+
+	if result == nil {
+		t.Fatal("Expected huma.StatusError, got nil")
+	}
+
+	if result.GetStatus() != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, result.GetStatus())
+	}
+
+	if result.Error() != "Too many requests" {
+		t.Errorf("Expected message 'Too many requests', got '%s'", result.Error())
+	}
+}
+
 func TestGetHumaErrorFromError_UnknownError(t *testing.T) {
 	unknownErr := errors.New("unknown error")
 	result := qqerrors.GetHumaErrorFromError(unknownErr)
@@ -316,3 +402,48 @@ func TestGetHumaErrorFromError_NilError(t *testing.T) {
 		t.Errorf("Expected message 'Internal server error', got '%s'", result.Error())
 	}
 }
+
+func TestGetHumaErrorFromError_RetryAfter(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		expected   string
+	}{
+		{retryAfter: 90 * time.Second, expected: "90"},
+		{retryAfter: 1500 * time.Millisecond, expected: "2"},
+		{retryAfter: 0, expected: "1"},
+	}
+
+	for _, tt := range tests {
+		err := fmt.Errorf("send otp: %w", &qqerrors.RetryAfterError{
+			Err:        fmt.Errorf("limited: %w", qqerrors.ErrTooManyRequests),
+			RetryAfter: tt.retryAfter,
+		})
+
+		result := qqerrors.GetHumaErrorFromError(err)
+
+		if result.GetStatus() != http.StatusTooManyRequests {
+			t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, result.GetStatus())
+		}
+
+		var headersErr huma.HeadersError
+		if !errors.As(result, &headersErr) {
+			t.Fatal("Expected the error to carry headers")
+		}
+		if got := headersErr.GetHeaders().Get("Retry-After"); got != tt.expected {
+			t.Errorf("Expected Retry-After %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+func TestGetHumaErrorFromError_TooManyRequestsWithoutRetryAfter(t *testing.T) {
+	result := qqerrors.GetHumaErrorFromError(qqerrors.ErrTooManyRequests)
+
+	if result.GetStatus() != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, result.GetStatus())
+	}
+
+	var headersErr huma.HeadersError
+	if errors.As(result, &headersErr) {
+		t.Error("Expected no headers without a retry time")
+	}
+}
@@ -0,0 +1,200 @@
+package webauthn_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// fakeRepository keeps challenges and credentials in memory and mirrors the
+// conditional sign count update of the SQL query.
+type fakeRepository struct {
+	mu          sync.Mutex
+	challenges  []db.WebauthnChallenge
+	credentials []db.WebauthnCredential
+	sweeps      int
+}
+
+func (f *fakeRepository) WithTx(tx pgx.Tx) webauthn.Repository {
+	return f
+}
+
+func (f *fakeRepository) CreateChallenge(ctx context.Context, params db.InsertWebauthnChallengeParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.challenges = append(f.challenges, db.WebauthnChallenge{
+		ID:            newUUID(),
+		AuthID:        params.AuthID,
+		Ceremony:      params.Ceremony,
+		ChallengeHash: params.ChallengeHash,
+	})
+	return nil
+}
+
+func (f *fakeRepository) ConsumeChallenge(
+	ctx context.Context, ceremony db.WebauthnCeremony, challengeHash string,
+) (*db.WebauthnChallenge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, challenge := range f.challenges {
+		if challenge.Ceremony == ceremony && challenge.ChallengeHash == challengeHash {
+			f.challenges = append(f.challenges[:i], f.challenges[i+1:]...)
+			return &challenge, nil
+		}
+	}
+	return nil, webauthn.ErrNotFound
+}
+
+func (f *fakeRepository) DeleteExpiredChallenges(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sweeps++
+	return nil
+}
+
+func (f *fakeRepository) CreateCredential(
+	ctx context.Context, params db.InsertWebauthnCredentialParams,
+) (*db.WebauthnCredential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, credential := range f.credentials {
+		if bytes.Equal(credential.CredentialID, params.CredentialID) {
+			return nil, qqerrors.ErrUniqueViolation
+		}
+	}
+	credential := db.WebauthnCredential{
+		ID:             newUUID(),
+		AuthID:         params.AuthID,
+		CredentialID:   params.CredentialID,
+		PublicKey:      params.PublicKey,
+		Algorithm:      params.Algorithm,
+		SignCount:      params.SignCount,
+		Aaguid:         params.Aaguid,
+		BackupEligible: params.BackupEligible,
+		BackedUp:       params.BackedUp,
+		Label:          params.Label,
+	}
+	f.credentials = append(f.credentials, credential)
+	return &credential, nil
+}
+
+func (f *fakeRepository) GetCredential(ctx context.Context, credentialID []byte) (*db.WebauthnCredential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, credential := range f.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			return &credential, nil
+		}
+	}
+	return nil, webauthn.ErrNotFound
+}
+
+func (f *fakeRepository) ListCredentials(ctx context.Context, authID pgtype.UUID) ([]db.WebauthnCredential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var credentials []db.WebauthnCredential
+	for _, credential := range f.credentials {
+		if credential.AuthID == authID {
+			credentials = append(credentials, credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (f *fakeRepository) UpdateSignCount(ctx context.Context, params db.UpdateWebauthnCredentialSignCountParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, credential := range f.credentials {
+		if credential.ID == params.ID && credential.SignCount == params.PreviousSignCount {
+			f.credentials[i].SignCount = params.SignCount
+			f.credentials[i].BackedUp = params.BackedUp
+			return nil
+		}
+	}
+	return webauthn.ErrNotFound
+}
+
+func (f *fakeRepository) DeleteCredential(ctx context.Context, authID pgtype.UUID, id pgtype.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, credential := range f.credentials {
+		if credential.ID == id && credential.AuthID == authID {
+			f.credentials = append(f.credentials[:i], f.credentials[i+1:]...)
+			return nil
+		}
+	}
+	return webauthn.ErrNotFound
+}
+
+func (f *fakeRepository) challengeCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.challenges)
+}
+
+func (f *fakeRepository) credential(credentialID []byte) db.WebauthnCredential {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, credential := range f.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			return credential
+		}
+	}
+	return db.WebauthnCredential{}
+}
+
+// fakeRelyingParty skips the signature checks, which the platform package
+// covers, and records the challenge it was asked to verify against.
+type fakeRelyingParty struct {
+	mu            sync.Mutex
+	credential    webauthnport.Credential
+	assertion     webauthnport.Assertion
+	verifyErr     error
+	lastChallenge []byte
+}
+
+func (f *fakeRelyingParty) ID() string {
+	return "qq.example"
+}
+
+func (f *fakeRelyingParty) Name() string {
+	return "QQ"
+}
+
+func (f *fakeRelyingParty) VerifyRegistration(
+	challenge []byte, response webauthnport.RegistrationResponse,
+) (*webauthnport.Credential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastChallenge = challenge
+	if f.verifyErr != nil {
+		return nil, f.verifyErr
+	}
+	credential := f.credential
+	return &credential, nil
+}
+
+func (f *fakeRelyingParty) VerifyAssertion(
+	challenge []byte, credentialPublicKey []byte, response webauthnport.AssertionResponse,
+) (*webauthnport.Assertion, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastChallenge = challenge
+	if f.verifyErr != nil {
+		return nil, f.verifyErr
+	}
+	assertion := f.assertion
+	return &assertion, nil
+}
+
+func newUUID() pgtype.UUID {
+	return pgtype.UUID{Bytes: uuid.New(), Valid: true}
+}
@@ -0,0 +1,312 @@
+package webauthn_test
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	"github.com/abdurrahimagca/qq-back/internal/webauthn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientDataFor builds the client data a browser would return for a challenge
+// handed out as base64url by the service.
+func clientDataFor(challenge string) []byte {
+	return []byte(`{"type":"webauthn.get","challenge":"` + challenge + `"}`)
+}
+
+func registerCredential(
+	t *testing.T, service webauthn.Service, rp *fakeRelyingParty, authID pgtype.UUID, credentialID string,
+) *db.WebauthnCredential {
+	t.Helper()
+	ctx := context.Background()
+	options, err := service.BeginRegistration(ctx, authID, "user@example.com", "User")
+	require.NoError(t, err)
+
+	rp.credential = webauthnport.Credential{ID: []byte(credentialID), PublicKey: []byte("cose"), Algorithm: -7}
+	credential, err := service.FinishRegistration(ctx, authID, webauthnport.RegistrationResponse{
+		ClientDataJSON: clientDataFor(options.Challenge),
+	}, "")
+	require.NoError(t, err)
+	return credential
+}
+
+func TestService_BeginRegistration(t *testing.T) {
+	repo := &fakeRepository{}
+	rp := &fakeRelyingParty{}
+	service := webauthn.NewService(repo, rp)
+	authID := newUUID()
+	registerCredential(t, service, rp, authID, "existing")
+
+	options, err := service.BeginRegistration(context.Background(), authID, "user@example.com", "User")
+	require.NoError(t, err)
+
+	assert.Equal(t, webauthn.RelyingPartyEntity{ID: "qq.example", Name: "QQ"}, options.RP)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(authID.Bytes[:]), options.User.ID)
+	assert.Equal(t, "user@example.com", options.User.Name)
+	assert.Equal(t, "User", options.User.DisplayName)
+	assert.Len(t, options.PubKeyCredParams, len(webauthnport.SupportedAlgorithms))
+	assert.Equal(t, "required", options.AuthenticatorSelection.UserVerification)
+	require.Len(t, options.ExcludeCredentials, 1)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString([]byte("existing")), options.ExcludeCredentials[0].ID)
+
+	challenge, err := base64.RawURLEncoding.DecodeString(options.Challenge)
+	require.NoError(t, err)
+	assert.Len(t, challenge, 32)
+	assert.Equal(t, 1, repo.challengeCount(), "Only the hash of the open challenge is stored")
+	assert.Equal(t, 2, repo.sweeps, "Expired challenges are swept on every new ceremony")
+}
+
+func TestService_FinishRegistration(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Stores credential", func(t *testing.T) {
+		repo := &fakeRepository{}
+		rp := &fakeRelyingParty{credential: webauthnport.Credential{
+			ID: []byte("cred"), PublicKey: []byte("cose"), Algorithm: -8, SignCount: 3, BackupEligible: true,
+		}}
+		service := webauthn.NewService(repo, rp)
+		authID := newUUID()
+		options, err := service.BeginRegistration(ctx, authID, "user@example.com", "User")
+		require.NoError(t, err)
+
+		response := webauthnport.RegistrationResponse{ClientDataJSON: clientDataFor(options.Challenge)}
+		credential, err := service.FinishRegistration(ctx, authID, response, "Laptop")
+		require.NoError(t, err)
+
+		assert.Equal(t, authID, credential.AuthID)
+		assert.Equal(t, []byte("cred"), credential.CredentialID)
+		assert.Equal(t, int64(-8), credential.Algorithm)
+		assert.Equal(t, int64(3), credential.SignCount)
+		assert.True(t, credential.BackupEligible)
+		assert.Equal(t, pgtype.Text{String: "Laptop", Valid: true}, credential.Label)
+		assert.Equal(t, options.Challenge, base64.RawURLEncoding.EncodeToString(rp.lastChallenge))
+		assert.Equal(t, 0, repo.challengeCount())
+	})
+
+	t.Run("Challenge issued to another account", func(t *testing.T) {
+		repo := &fakeRepository{}
+		service := webauthn.NewService(repo, &fakeRelyingParty{})
+		options, err := service.BeginRegistration(ctx, newUUID(), "user@example.com", "User")
+		require.NoError(t, err)
+
+		response := webauthnport.RegistrationResponse{ClientDataJSON: clientDataFor(options.Challenge)}
+		_, err = service.FinishRegistration(ctx, newUUID(), response, "")
+		require.ErrorIs(t, err, webauthn.ErrInvalidChallenge)
+		assert.Equal(t, 0, repo.challengeCount(), "The challenge is spent even though it was rejected")
+	})
+
+	t.Run("Challenge is single use", func(t *testing.T) {
+		rp := &fakeRelyingParty{verifyErr: webauthnport.ErrVerificationFailed}
+		service := webauthn.NewService(&fakeRepository{}, rp)
+		authID := newUUID()
+		options, err := service.BeginRegistration(ctx, authID, "user@example.com", "User")
+		require.NoError(t, err)
+
+		response := webauthnport.RegistrationResponse{ClientDataJSON: clientDataFor(options.Challenge)}
+		_, err = service.FinishRegistration(ctx, authID, response, "")
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+
+		rp.verifyErr = nil
+		_, err = service.FinishRegistration(ctx, authID, response, "")
+		require.ErrorIs(t, err, webauthn.ErrInvalidChallenge)
+	})
+
+	t.Run("Login challenge", func(t *testing.T) {
+		service := webauthn.NewService(&fakeRepository{}, &fakeRelyingParty{})
+		options, err := service.BeginLogin(ctx)
+		require.NoError(t, err)
+
+		response := webauthnport.RegistrationResponse{ClientDataJSON: clientDataFor(options.Challenge)}
+		_, err = service.FinishRegistration(ctx, newUUID(), response, "")
+		require.ErrorIs(t, err, webauthn.ErrInvalidChallenge)
+	})
+
+	t.Run("Credential already registered", func(t *testing.T) {
+		rp := &fakeRelyingParty{}
+		service := webauthn.NewService(&fakeRepository{}, rp)
+		registerCredential(t, service, rp, newUUID(), "cred")
+
+		authID := newUUID()
+		options, err := service.BeginRegistration(ctx, authID, "other@example.com", "Other")
+		require.NoError(t, err)
+		response := webauthnport.RegistrationResponse{ClientDataJSON: clientDataFor(options.Challenge)}
+		_, err = service.FinishRegistration(ctx, authID, response, "")
+		require.ErrorIs(t, err, webauthn.ErrCredentialExists)
+	})
+
+	t.Run("Malformed client data", func(t *testing.T) {
+		service := webauthn.NewService(&fakeRepository{}, &fakeRelyingParty{})
+		response := webauthnport.RegistrationResponse{ClientDataJSON: []byte("not json")}
+		_, err := service.FinishRegistration(ctx, newUUID(), response, "")
+		require.ErrorIs(t, err, webauthnport.ErrMalformedResponse)
+	})
+}
+
+func TestService_BeginLogin(t *testing.T) {
+	repo := &fakeRepository{}
+	service := webauthn.NewService(repo, &fakeRelyingParty{})
+
+	options, err := service.BeginLogin(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, "qq.example", options.RPID)
+	assert.Empty(t, options.AllowCredentials, "Discoverable passkeys are offered by the authenticator")
+	assert.Equal(t, "required", options.UserVerification)
+	assert.NotEmpty(t, options.Challenge)
+	assert.Equal(t, 1, repo.challengeCount())
+}
+
+func TestService_FinishLogin(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (webauthn.Service, *fakeRepository, *fakeRelyingParty, *db.WebauthnCredential) {
+		t.Helper()
+		repo := &fakeRepository{}
+		rp := &fakeRelyingParty{}
+		service := webauthn.NewService(repo, rp)
+		credential := registerCredential(t, service, rp, newUUID(), "cred")
+		return service, repo, rp, credential
+	}
+	begin := func(t *testing.T, service webauthn.Service) []byte {
+		t.Helper()
+		options, err := service.BeginLogin(ctx)
+		require.NoError(t, err)
+		return clientDataFor(options.Challenge)
+	}
+
+	t.Run("Success advances sign count", func(t *testing.T) {
+		service, repo, rp, registered := setup(t)
+		rp.assertion = webauthnport.Assertion{SignCount: 5, BackedUp: true}
+
+		credential, err := service.FinishLogin(ctx, webauthn.Assertion{
+			CredentialID: []byte("cred"),
+			UserHandle:   registered.AuthID.Bytes[:],
+			Response:     webauthnport.AssertionResponse{ClientDataJSON: begin(t, service)},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, registered.AuthID, credential.AuthID)
+		assert.Equal(t, int64(5), credential.SignCount)
+		assert.True(t, credential.BackedUp)
+		assert.Equal(t, int64(5), repo.credential([]byte("cred")).SignCount)
+	})
+
+	t.Run("Authenticator without counter", func(t *testing.T) {
+		service, _, _, _ := setup(t)
+
+		for range 2 {
+			_, err := service.FinishLogin(ctx, webauthn.Assertion{
+				CredentialID: []byte("cred"),
+				Response:     webauthnport.AssertionResponse{ClientDataJSON: begin(t, service)},
+			})
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("Sign count regression", func(t *testing.T) {
+		service, repo, rp, _ := setup(t)
+		rp.assertion = webauthnport.Assertion{SignCount: 5}
+		_, err := service.FinishLogin(ctx, webauthn.Assertion{
+			CredentialID: []byte("cred"),
+			Response:     webauthnport.AssertionResponse{ClientDataJSON: begin(t, service)},
+		})
+		require.NoError(t, err)
+
+		rp.assertion = webauthnport.Assertion{SignCount: 4}
+		_, err = service.FinishLogin(ctx, webauthn.Assertion{
+			CredentialID: []byte("cred"),
+			Response:     webauthnport.AssertionResponse{ClientDataJSON: begin(t, service)},
+		})
+		require.ErrorIs(t, err, webauthnport.ErrSignCountRegression)
+		assert.Equal(t, int64(5), repo.credential([]byte("cred")).SignCount)
+	})
+
+	t.Run("Unknown credential", func(t *testing.T) {
+		service, _, _, _ := setup(t)
+		_, err := service.FinishLogin(ctx, webauthn.Assertion{
+			CredentialID: []byte("other"),
+			Response:     webauthnport.AssertionResponse{ClientDataJSON: begin(t, service)},
+		})
+		require.ErrorIs(t, err, webauthn.ErrUnknownCredential)
+	})
+
+	t.Run("User handle of another account", func(t *testing.T) {
+		service, _, _, _ := setup(t)
+		other := newUUID()
+		_, err := service.FinishLogin(ctx, webauthn.Assertion{
+			CredentialID: []byte("cred"),
+			UserHandle:   other.Bytes[:],
+			Response:     webauthnport.AssertionResponse{ClientDataJSON: begin(t, service)},
+		})
+		require.ErrorIs(t, err, webauthn.ErrUnknownCredential)
+	})
+
+	t.Run("Replayed response", func(t *testing.T) {
+		service, _, _, _ := setup(t)
+		assertion := webauthn.Assertion{
+			CredentialID: []byte("cred"),
+			Response:     webauthnport.AssertionResponse{ClientDataJSON: begin(t, service)},
+		}
+		_, err := service.FinishLogin(ctx, assertion)
+		require.NoError(t, err)
+
+		_, err = service.FinishLogin(ctx, assertion)
+		require.ErrorIs(t, err, webauthn.ErrInvalidChallenge)
+	})
+
+	t.Run("Signature rejected", func(t *testing.T) {
+		service, _, rp, _ := setup(t)
+		rp.verifyErr = webauthnport.ErrVerificationFailed
+		_, err := service.FinishLogin(ctx, webauthn.Assertion{
+			CredentialID: []byte("cred"),
+			Response:     webauthnport.AssertionResponse{ClientDataJSON: begin(t, service)},
+		})
+		require.ErrorIs(t, err, webauthnport.ErrVerificationFailed)
+	})
+}
+
+func TestService_DeleteCredential(t *testing.T) {
+	ctx := context.Background()
+	rp := &fakeRelyingParty{}
+	service := webauthn.NewService(&fakeRepository{}, rp)
+	credential := registerCredential(t, service, rp, newUUID(), "cred")
+
+	err := service.DeleteCredential(ctx, newUUID(), credential.ID)
+	require.ErrorIs(t, err, webauthn.ErrNotFound, "Passkeys of other accounts cannot be removed")
+
+	require.NoError(t, service.DeleteCredential(ctx, credential.AuthID, credential.ID))
+	credentials, err := service.ListCredentials(ctx, credential.AuthID)
+	require.NoError(t, err)
+	assert.Empty(t, credentials)
+}
+
+func TestCredentialJSON_Decode(t *testing.T) {
+	registration := webauthn.RegistrationCredentialJSON{ID: "Y3JlZA", Type: "public-key"}
+	registration.Response.ClientDataJSON = "e30="
+	registration.Response.AttestationObject = "b2Jq"
+
+	response, err := registration.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("{}"), response.ClientDataJSON, "Padded base64url is accepted")
+	assert.Equal(t, []byte("obj"), response.AttestationObject)
+
+	registration.Response.AttestationObject = "b2Jq+"
+	_, err = registration.Decode()
+	require.ErrorIs(t, err, webauthnport.ErrMalformedResponse)
+
+	assertion := webauthn.AssertionCredentialJSON{ID: "Y3JlZA", Type: "public-key"}
+	assertion.Response.ClientDataJSON = "e30"
+	assertion.Response.AuthenticatorData = "YXV0aA"
+	assertion.Response.Signature = "c2ln"
+
+	decoded, err := assertion.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("cred"), decoded.CredentialID)
+	assert.Nil(t, decoded.UserHandle, "Missing user handle stays nil")
+	assert.Equal(t, []byte("sig"), decoded.Response.Signature)
+}
@@ -0,0 +1,159 @@
+package webauthn
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+)
+
+var (
+	ErrNotFound          = fmt.Errorf("passkey %w", qqerrors.ErrNotFound)
+	ErrCredentialExists  = fmt.Errorf("passkey is already registered: %w", qqerrors.ErrUniqueViolation)
+	ErrInvalidChallenge  = fmt.Errorf("passkey challenge is invalid or expired: %w", qqerrors.ErrUnauthorized)
+	ErrUnknownCredential = fmt.Errorf("passkey is not registered: %w", qqerrors.ErrUnauthorized)
+)
+
+const (
+	publicKeyCredentialType = "public-key"
+	userVerificationPolicy  = "required"
+)
+
+// The option and credential types below follow the JSON forms of the WebAuthn
+// Level 3 spec, so browsers can pass them to PublicKeyCredential.parse*OptionsFromJSON
+// and post credential.toJSON() back unchanged. Binary values are base64url.
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id" doc:"Base64url user handle"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id" doc:"Base64url credential ID"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge" doc:"Base64url challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout" doc:"Milliseconds the challenge stays valid"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge" doc:"Base64url challenge"`
+	Timeout          int64                  `json:"timeout" doc:"Milliseconds the challenge stays valid"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type RegistrationCredentialJSON struct {
+	_        struct{} `additionalProperties:"true"`
+	ID       string   `json:"id" doc:"Base64url credential ID" required:"true" minLength:"1"`
+	Type     string   `json:"type" enum:"public-key" required:"true"`
+	Response struct {
+		_                 struct{} `additionalProperties:"true"`
+		ClientDataJSON    string   `json:"clientDataJSON" required:"true" minLength:"1"`
+		AttestationObject string   `json:"attestationObject" required:"true" minLength:"1"`
+	} `json:"response" required:"true"`
+}
+
+// Decode returns the binary response fields.
+func (c RegistrationCredentialJSON) Decode() (webauthnport.RegistrationResponse, error) {
+	clientDataJSON, err := decodeBase64URL(c.Response.ClientDataJSON)
+	if err != nil {
+		return webauthnport.RegistrationResponse{}, err
+	}
+	attestationObject, err := decodeBase64URL(c.Response.AttestationObject)
+	if err != nil {
+		return webauthnport.RegistrationResponse{}, err
+	}
+	return webauthnport.RegistrationResponse{
+		ClientDataJSON:    clientDataJSON,
+		AttestationObject: attestationObject,
+	}, nil
+}
+
+type AssertionCredentialJSON struct {
+	_        struct{} `additionalProperties:"true"`
+	ID       string   `json:"id" doc:"Base64url credential ID" required:"true" minLength:"1"`
+	Type     string   `json:"type" enum:"public-key" required:"true"`
+	Response struct {
+		_                 struct{} `additionalProperties:"true"`
+		ClientDataJSON    string   `json:"clientDataJSON" required:"true" minLength:"1"`
+		AuthenticatorData string   `json:"authenticatorData" required:"true" minLength:"1"`
+		Signature         string   `json:"signature" required:"true" minLength:"1"`
+		UserHandle        string   `json:"userHandle,omitempty"`
+	} `json:"response" required:"true"`
+}
+
+// Assertion is a decoded AssertionCredentialJSON.
+type Assertion struct {
+	CredentialID []byte
+	UserHandle   []byte
+	Response     webauthnport.AssertionResponse
+}
+
+// Decode returns the binary credential ID, user handle and response fields.
+func (c AssertionCredentialJSON) Decode() (Assertion, error) {
+	fields := []string{c.ID, c.Response.ClientDataJSON, c.Response.AuthenticatorData, c.Response.Signature}
+	decoded := make([][]byte, len(fields))
+	for i, field := range fields {
+		value, err := decodeBase64URL(field)
+		if err != nil {
+			return Assertion{}, err
+		}
+		decoded[i] = value
+	}
+
+	var userHandle []byte
+	if c.Response.UserHandle != "" {
+		var err error
+		if userHandle, err = decodeBase64URL(c.Response.UserHandle); err != nil {
+			return Assertion{}, err
+		}
+	}
+
+	return Assertion{
+		CredentialID: decoded[0],
+		UserHandle:   userHandle,
+		Response: webauthnport.AssertionResponse{
+			ClientDataJSON:    decoded[1],
+			AuthenticatorData: decoded[2],
+			Signature:         decoded[3],
+		},
+	}, nil
+}
+
+// decodeBase64URL accepts base64url with or without padding, as browsers differ.
+func decodeBase64URL(value string) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: field is not base64url", webauthnport.ErrMalformedResponse)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,129 @@
+package webauthn
+
+import (
+	"context"
+	"errors"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Repository interface {
+	WithTx(tx pgx.Tx) Repository
+	CreateChallenge(ctx context.Context, params db.InsertWebauthnChallengeParams) error
+	ConsumeChallenge(
+		ctx context.Context, ceremony db.WebauthnCeremony, challengeHash string) (*db.WebauthnChallenge, error)
+	DeleteExpiredChallenges(ctx context.Context) error
+	CreateCredential(ctx context.Context, params db.InsertWebauthnCredentialParams) (*db.WebauthnCredential, error)
+	GetCredential(ctx context.Context, credentialID []byte) (*db.WebauthnCredential, error)
+	ListCredentials(ctx context.Context, authID pgtype.UUID) ([]db.WebauthnCredential, error)
+	UpdateSignCount(ctx context.Context, params db.UpdateWebauthnCredentialSignCountParams) error
+	DeleteCredential(ctx context.Context, authID pgtype.UUID, id pgtype.UUID) error
+}
+
+type pgxRepository struct {
+	q *db.Queries
+}
+
+func NewPgxRepository(pool *pgxpool.Pool) Repository {
+	return &pgxRepository{
+		q: db.New(pool),
+	}
+}
+
+func (r *pgxRepository) WithTx(tx pgx.Tx) Repository {
+	return &pgxRepository{
+		q: r.q.WithTx(tx),
+	}
+}
+
+func (r *pgxRepository) CreateChallenge(ctx context.Context, params db.InsertWebauthnChallengeParams) error {
+	if err := r.q.InsertWebauthnChallenge(ctx, params); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+// ConsumeChallenge deletes the unexpired challenge of the ceremony and returns
+// it, so each challenge backs a single response. Unknown or expired challenges
+// return ErrNotFound.
+func (r *pgxRepository) ConsumeChallenge(
+	ctx context.Context, ceremony db.WebauthnCeremony, challengeHash string) (*db.WebauthnChallenge, error) {
+	challenge, err := r.q.ConsumeWebauthnChallenge(ctx, db.ConsumeWebauthnChallengeParams{
+		ChallengeHash: challengeHash,
+		Ceremony:      ceremony,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &challenge, nil
+}
+
+func (r *pgxRepository) DeleteExpiredChallenges(ctx context.Context) error {
+	if err := r.q.DeleteExpiredWebauthnChallenges(ctx); err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	return nil
+}
+
+func (r *pgxRepository) CreateCredential(
+	ctx context.Context, params db.InsertWebauthnCredentialParams) (*db.WebauthnCredential, error) {
+	credential, err := r.q.InsertWebauthnCredential(ctx, params)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &credential, nil
+}
+
+func (r *pgxRepository) GetCredential(ctx context.Context, credentialID []byte) (*db.WebauthnCredential, error) {
+	credential, err := r.q.GetWebauthnCredentialByCredentialID(ctx, credentialID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return &credential, nil
+}
+
+func (r *pgxRepository) ListCredentials(ctx context.Context, authID pgtype.UUID) ([]db.WebauthnCredential, error) {
+	credentials, err := r.q.ListWebauthnCredentialsByAuthID(ctx, authID)
+	if err != nil {
+		return nil, qqerrors.GetDBErrAsQQError(err)
+	}
+	return credentials, nil
+}
+
+// UpdateSignCount stores the counter of an accepted assertion. The update only
+// applies while the stored counter still equals PreviousSignCount, so of two
+// concurrent assertions with the same counter one returns ErrNotFound.
+func (r *pgxRepository) UpdateSignCount(ctx context.Context, params db.UpdateWebauthnCredentialSignCountParams) error {
+	rows, err := r.q.UpdateWebauthnCredentialSignCount(ctx, params)
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgxRepository) DeleteCredential(ctx context.Context, authID pgtype.UUID, id pgtype.UUID) error {
+	rows, err := r.q.DeleteWebauthnCredential(ctx, db.DeleteWebauthnCredentialParams{
+		ID:     id,
+		AuthID: authID,
+	})
+	if err != nil {
+		return qqerrors.GetDBErrAsQQError(err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/abdurrahimagca/qq-back/internal/db"
+	webauthnport "github.com/abdurrahimagca/qq-back/internal/platform/webauthn"
+	qqerrors "github.com/abdurrahimagca/qq-back/internal/utils/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type Service interface {
+	WithTx(tx pgx.Tx) Service
+	BeginRegistration(
+		ctx context.Context, authID pgtype.UUID, userName string, displayName string) (*CreationOptions, error)
+	FinishRegistration(
+		ctx context.Context, authID pgtype.UUID, response webauthnport.RegistrationResponse, label string,
+	) (*db.WebauthnCredential, error)
+	BeginLogin(ctx context.Context) (*RequestOptions, error)
+	FinishLogin(ctx context.Context, assertion Assertion) (*db.WebauthnCredential, error)
+	ListCredentials(ctx context.Context, authID pgtype.UUID) ([]db.WebauthnCredential, error)
+	DeleteCredential(ctx context.Context, authID pgtype.UUID, id pgtype.UUID) error
+}
+
+// ceremonyTimeout matches the expires_at default of webauthn_challenges.
+const ceremonyTimeout = 5 * time.Minute
+
+type service struct {
+	repo Repository
+	rp   webauthnport.RelyingParty
+}
+
+func NewService(repo Repository, rp webauthnport.RelyingParty) Service {
+	return &service{repo: repo, rp: rp}
+}
+
+func (s *service) WithTx(tx pgx.Tx) Service {
+	return &service{repo: s.repo.WithTx(tx), rp: s.rp}
+}
+
+// BeginRegistration issues the options for navigator.credentials.create. The
+// user handle is the auth ID, and credentials the account already holds are
+// excluded so an authenticator is not registered twice.
+func (s *service) BeginRegistration(
+	ctx context.Context, authID pgtype.UUID, userName string, displayName string,
+) (*CreationOptions, error) {
+	existing, err := s.repo.ListCredentials(ctx, authID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := s.newChallenge(ctx, authID, db.WebauthnCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make([]CredentialParameter, 0, len(webauthnport.SupportedAlgorithms))
+	for _, algorithm := range webauthnport.SupportedAlgorithms {
+		params = append(params, CredentialParameter{Type: publicKeyCredentialType, Alg: algorithm})
+	}
+
+	return &CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingPartyEntity{ID: s.rp.ID(), Name: s.rp.Name()},
+		User: UserEntity{
+			ID:          base64.RawURLEncoding.EncodeToString(authID.Bytes[:]),
+			Name:        userName,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            ceremonyTimeout.Milliseconds(),
+		ExcludeCredentials: toDescriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   userVerificationPolicy,
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishRegistration verifies the attestation against a registration challenge
+// issued to the same account and stores the new credential.
+func (s *service) FinishRegistration(
+	ctx context.Context, authID pgtype.UUID, response webauthnport.RegistrationResponse, label string,
+) (*db.WebauthnCredential, error) {
+	challenge, err := s.consumeChallenge(ctx, response.ClientDataJSON, db.WebauthnCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.stored.AuthID != authID {
+		return nil, ErrInvalidChallenge
+	}
+
+	credential, err := s.rp.VerifyRegistration(challenge.value, response)
+	if err != nil {
+		return nil, err
+	}
+
+	params := db.InsertWebauthnCredentialParams{
+		AuthID:         authID,
+		CredentialID:   credential.ID,
+		PublicKey:      credential.PublicKey,
+		Algorithm:      credential.Algorithm,
+		SignCount:      int64(credential.SignCount),
+		Aaguid:         credential.AAGUID,
+		BackupEligible: credential.BackupEligible,
+		BackedUp:       credential.BackedUp,
+	}
+	if label != "" {
+		params.Label = pgtype.Text{String: label, Valid: true}
+	}
+
+	stored, err := s.repo.CreateCredential(ctx, params)
+	if err != nil {
+		if errors.Is(err, qqerrors.ErrUniqueViolation) {
+			return nil, ErrCredentialExists
+		}
+		return nil, err
+	}
+	return stored, nil
+}
+
+// BeginLogin issues the options for navigator.credentials.get. No credentials
+// are listed: the authenticator offers its discoverable passkeys for the RP and
+// the response names the one that was used.
+func (s *service) BeginLogin(ctx context.Context) (*RequestOptions, error) {
+	challenge, err := s.newChallenge(ctx, pgtype.UUID{}, db.WebauthnCeremonyAssertion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          ceremonyTimeout.Milliseconds(),
+		RPID:             s.rp.ID(),
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: userVerificationPolicy,
+	}, nil
+}
+
+// FinishLogin verifies an assertion and returns the credential that signed it.
+// The sign counter has to move forward, otherwise the authenticator may have
+// been cloned and the login is refused.
+func (s *service) FinishLogin(ctx context.Context, assertion Assertion) (*db.WebauthnCredential, error) {
+	challenge, err := s.consumeChallenge(ctx, assertion.Response.ClientDataJSON, db.WebauthnCeremonyAssertion)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.repo.GetCredential(ctx, assertion.CredentialID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrUnknownCredential
+		}
+		return nil, err
+	}
+	if assertion.UserHandle != nil && !bytes.Equal(assertion.UserHandle, credential.AuthID.Bytes[:]) {
+		return nil, ErrUnknownCredential
+	}
+
+	result, err := s.rp.VerifyAssertion(challenge.value, credential.PublicKey, assertion.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	storedCount := uint32(credential.SignCount) //nolint:gosec // only ever written from a uint32 counter
+	if err = webauthnport.CheckSignCount(storedCount, result.SignCount); err != nil {
+		return nil, err
+	}
+	err = s.repo.UpdateSignCount(ctx, db.UpdateWebauthnCredentialSignCountParams{
+		SignCount:         int64(result.SignCount),
+		BackedUp:          result.BackedUp,
+		ID:                credential.ID,
+		PreviousSignCount: credential.SignCount,
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, webauthnport.ErrSignCountRegression
+		}
+		return nil, err
+	}
+
+	credential.SignCount = int64(result.SignCount)
+	credential.BackedUp = result.BackedUp
+	return credential, nil
+}
+
+func (s *service) ListCredentials(ctx context.Context, authID pgtype.UUID) ([]db.WebauthnCredential, error) {
+	return s.repo.ListCredentials(ctx, authID)
+}
+
+func (s *service) DeleteCredential(ctx context.Context, authID pgtype.UUID, id pgtype.UUID) error {
+	return s.repo.DeleteCredential(ctx, authID, id)
+}
+
+// newChallenge stores the hash of a fresh challenge and returns the challenge
+// base64url encoded. Expired challenges are swept at the same time.
+func (s *service) newChallenge(
+	ctx context.Context, authID pgtype.UUID, ceremony db.WebauthnCeremony) (string, error) {
+	if err := s.repo.DeleteExpiredChallenges(ctx); err != nil {
+		return "", err
+	}
+
+	challenge, err := webauthnport.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.repo.CreateChallenge(ctx, db.InsertWebauthnChallengeParams{
+		AuthID:        authID,
+		Ceremony:      ceremony,
+		ChallengeHash: hashChallenge(challenge),
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(challenge), nil
+}
+
+type consumedChallenge struct {
+	value  []byte
+	stored *db.WebauthnChallenge
+}
+
+// consumeChallenge finds the challenge the client signed and deletes it, so a
+// response can be tried once whatever the outcome.
+func (s *service) consumeChallenge(
+	ctx context.Context, clientDataJSON []byte, ceremony db.WebauthnCeremony) (*consumedChallenge, error) {
+	value, err := webauthnport.ChallengeFromClientData(clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.repo.ConsumeChallenge(ctx, ceremony, hashChallenge(value))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+	return &consumedChallenge{value: value, stored: stored}, nil
+}
+
+func hashChallenge(challenge []byte) string {
+	sum := sha256.Sum256(challenge)
+	return hex.EncodeToString(sum[:])
+}
+
+func toDescriptors(credentials []db.WebauthnCredential) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		descriptors = append(descriptors, CredentialDescriptor{
+			Type: publicKeyCredentialType,
+			ID:   base64.RawURLEncoding.EncodeToString(credential.CredentialID),
+		})
+	}
+	return descriptors
+}